@@ -1,36 +1,98 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strings"
 	"syscall"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-
+	"github.com/ZindGH/MQTT-Server/internal/admin"
+	"github.com/ZindGH/MQTT-Server/internal/bridge"
 	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/logging"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
 	"github.com/ZindGH/MQTT-Server/internal/server"
 	"github.com/ZindGH/MQTT-Server/internal/store"
+	"github.com/ZindGH/MQTT-Server/internal/timeseries"
+	"github.com/ZindGH/MQTT-Server/internal/version"
 )
 
 func main() {
 	// Parse command line flags
 	configPath := flag.String("config", "config/config.yaml", "Path to configuration file")
+	profile := flag.String("profile", os.Getenv("MQTT_PROFILE"), "Configuration profile merged over the base file (config.<profile>.yaml)")
+	validateOnly := flag.Bool("validate-config", false, "Check the configuration file and exit")
+	showVersion := flag.Bool("version", false, "Print the version and build information and exit")
 	flag.Parse()
 
-	log.Println("Starting MQTT Server...")
+	if *showVersion {
+		fmt.Println(version.Get())
+		return
+	}
+
+	if *validateOnly {
+		if _, err := config.LoadProfile(*configPath, *profile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: configuration is valid\n", *configPath)
+		return
+	}
+
+	run := func(ctx context.Context) error {
+		return runServer(ctx, *configPath, *profile)
+	}
+
+	// Under the Windows service manager the service control handler owns
+	// the lifecycle
+	if isService, err := runService(run); isService || err != nil {
+		if err != nil {
+			log.Fatalf("Service failed: %v", err)
+		}
+		return
+	}
+
+	// Stop on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runServer starts the broker and its extensions and blocks until ctx is
+// cancelled or the broker fails
+func runServer(ctx context.Context, configPath, profile string) error {
+	build := version.Get()
+	log.Printf("Starting MQTT Server %s...", build.Version)
+	metrics.BuildInfo.WithLabelValues(build.Version, build.Commit, build.BuildDate, build.GoVersion).Set(1)
 
 	// Load configuration
-	cfg, err := config.Load(*configPath)
+	cfg, err := config.LoadProfile(configPath, profile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// From here on all subsystems log to the configured output
+	logOutput, err := logging.Setup(cfg.Logging)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		return fmt.Errorf("failed to set up logging: %w", err)
 	}
+	defer logOutput.Close()
+	logEffectiveConfig(cfg)
 
-	log.Printf("Configuration loaded from %s", *configPath)
+	if profile != "" {
+		log.Printf("Configuration loaded from %s (profile %s)", configPath, profile)
+	} else {
+		log.Printf("Configuration loaded from %s", configPath)
+	}
 	log.Printf("Server will bind to %s:%d", cfg.Server.Host, cfg.Server.Port)
 	log.Printf("Storage backend: %s", cfg.Storage.Backend)
 	log.Printf("Max QoS level: %d", cfg.QoS.MaxQoS)
@@ -39,69 +101,218 @@ func main() {
 	var st store.Store
 	switch cfg.Storage.Backend {
 	case "bbolt":
-		// Ensure data directory exists
-		dir := filepath.Dir(cfg.Storage.Path)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			log.Fatalf("Failed to create data directory: %v", err)
+		opts := store.OpenOptions{
+			LockTimeout:  cfg.Storage.LockTimeout,
+			LockRetries:  cfg.Storage.LockRetries,
+			RetryBackoff: cfg.Storage.LockBackoff,
 		}
-
-		st, err = store.NewBboltStore(cfg.Storage.Path)
-		if err != nil {
-			log.Fatalf("Failed to initialize bbolt store: %v", err)
+		if cfg.Storage.Encryption.Enabled {
+			cipher, err := storeCipher(cfg.Storage.Encryption)
+			if err != nil {
+				return fmt.Errorf("failed to set up storage encryption: %w", err)
+			}
+			var reencrypted int
+			st, reencrypted, err = store.NewEncryptedBboltStore(cfg.Storage.Path, cipher, opts)
+			if err != nil {
+				return fmt.Errorf("failed to initialize bbolt store: %w", err)
+			}
+			log.Printf("Storage encryption enabled with key %s (%d stored values re-encrypted)",
+				cfg.Storage.Encryption.Keys[0].ID, reencrypted)
+		} else {
+			st, err = store.OpenBboltStore(cfg.Storage.Path, opts)
+			if err != nil {
+				return fmt.Errorf("failed to initialize bbolt store: %w", err)
+			}
 		}
 		log.Printf("Bbolt storage initialized at %s", cfg.Storage.Path)
 		defer st.Close()
 
 	case "memory":
 		log.Println("Using in-memory storage (data will not persist)")
-		// TODO: Implement memory store
-		log.Fatal("Memory storage not yet implemented")
+		st = store.NewMemoryStore()
+		defer st.Close()
 
 	default:
-		log.Fatalf("Unsupported storage backend: %s", cfg.Storage.Backend)
+		return fmt.Errorf("unsupported storage backend: %s", cfg.Storage.Backend)
 	}
 
 	// Create server with configuration and storage
 	srv, err := server.NewWithConfig(cfg, st)
 	if err != nil {
-		log.Fatalf("Failed to create server: %v", err)
+		return fmt.Errorf("failed to create server: %w", err)
+	}
+
+	// Start bridges to upstream brokers
+	bridges, err := bridge.NewManager(cfg.Bridges, srv, st)
+	if err != nil {
+		return fmt.Errorf("failed to create bridges: %w", err)
+	}
+	bridges.Start()
+
+	// Start time-series exporter if enabled
+	var exporter *timeseries.Exporter
+	if cfg.TimeSeries.Enabled {
+		exporter = timeseries.New(cfg.TimeSeries)
+		exporter.Start(srv)
 	}
 
 	// Start Prometheus metrics server if enabled
 	if cfg.Metrics.Enabled {
 		go func() {
-			metricsAddr := fmt.Sprintf(":%d", cfg.Metrics.Port)
-			http.Handle(cfg.Metrics.Path, promhttp.Handler())
-			log.Printf("Metrics server starting on %s%s", metricsAddr, cfg.Metrics.Path)
-			if err := http.ListenAndServe(metricsAddr, nil); err != nil {
+			log.Printf("Metrics server starting on %s:%d%s", cfg.Metrics.Host, cfg.Metrics.Port, cfg.Metrics.Path)
+			if err := metrics.ListenAndServe(cfg.Metrics); err != nil {
 				log.Printf("Metrics server error: %v", err)
 			}
 		}()
 	}
 
-	// Start MQTT server in a goroutine
+	// Start admin API server if enabled
+	if cfg.Admin.Enabled {
+		go func() {
+			adminAddr := fmt.Sprintf("%s:%d", cfg.Admin.Host, cfg.Admin.Port)
+			log.Printf("Admin API starting on %s", adminAddr)
+			if len(cfg.Admin.Tokens) == 0 {
+				log.Printf("Warning: admin API has no tokens configured, anyone who can reach %s has full access", adminAddr)
+			}
+			if err := http.ListenAndServe(adminAddr, admin.New(srv, cfg.Admin).Handler()); err != nil {
+				log.Printf("Admin API error: %v", err)
+			}
+		}()
+	}
+
+	// Start MQTT server in a goroutine and wait until it accepts connections
+	serverErr := make(chan error, 1)
 	go func() {
-		if err := srv.Start(); err != nil {
-			log.Printf("Server stopped: %v", err)
-		}
+		serverErr <- srv.StartContext(ctx)
 	}()
+	select {
+	case <-srv.Ready():
+	case err := <-serverErr:
+		bridges.Stop()
+		return fmt.Errorf("server failed to start: %w", err)
+	}
 
 	log.Println("✓ MQTT Server started successfully")
-	log.Printf("  → MQTT listening on %s:%d", cfg.Server.Host, cfg.Server.Port)
+	log.Printf("  → MQTT listening on %s", srv.Addr())
 	if cfg.Metrics.Enabled {
-		log.Printf("  → Metrics available at http://localhost:%d%s", cfg.Metrics.Port, cfg.Metrics.Path)
+		scheme, host := "http", cfg.Metrics.Host
+		if cfg.Metrics.CertFile != "" {
+			scheme = "https"
+		}
+		if host == "" {
+			host = "localhost"
+		}
+		log.Printf("  → Metrics available at %s://%s:%d%s", scheme, host, cfg.Metrics.Port, cfg.Metrics.Path)
+	}
+	if cfg.Admin.Enabled {
+		log.Printf("  → Admin API available at http://%s:%d/api/v1/", cfg.Admin.Host, cfg.Admin.Port)
 	}
 	log.Printf("  → Log level: %s", cfg.Logging.Level)
 	log.Println("Press Ctrl+C to stop")
 
+	// Apply configuration changes while running
+	if cfg.Reload.Interval > 0 {
+		current := cfg
+		go config.Watch(ctx, cfg, configPath, profile, cfg.Reload.Interval, func(next *config.Config) {
+			applyConfig(srv, current, next)
+			current = next
+		})
+	}
+
+	// Tell systemd we are up and keep its watchdog fed
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+	go sdWatchdog(ctx)
+
 	// Wait for interrupt signal to gracefully shutdown the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	select {
+	case <-ctx.Done():
+	case err := <-serverErr:
+		if err != nil {
+			log.Printf("Server stopped: %v", err)
+		}
+	}
 
+	sdNotify("STOPPING=1")
 	log.Println("\nShutting down server...")
+	bridges.Stop()
+	if exporter != nil {
+		exporter.Stop()
+	}
 	if err := srv.Stop(); err != nil {
 		log.Printf("Error during shutdown: %v", err)
 	}
 	fmt.Println("✓ Server stopped gracefully")
+	return nil
+}
+
+// applyConfig applies the hot-reloadable settings of a changed
+// configuration and logs the changes that need a restart
+func applyConfig(srv *server.Server, current, next *config.Config) {
+	var restart []string
+	for _, change := range config.Changes(current, next) {
+		var err error
+		switch change {
+		case "logging.level":
+			if err = logging.SetLevel(next.Logging.Level); err == nil {
+				log.Printf("Log level set to %s", next.Logging.Level)
+			}
+		case "logging.publish_sampling":
+			err = srv.SetPublishLogSampling(next.Logging.PublishSampling)
+		case "auth.username_password_file", "auth.acl_file", "auth.policy_file":
+			// Reloaded below
+		case "listeners":
+			err = srv.ApplyListeners(next.Listeners)
+		case "tls.session_tickets":
+			// Ticket keys are reloaded below; turning tickets on or off
+			// needs a new listener
+			if current.TLS.SessionTickets.Disabled != next.TLS.SessionTickets.Disabled {
+				restart = append(restart, change)
+			}
+		default:
+			restart = append(restart, change)
+		}
+		if err != nil {
+			log.Printf("Failed to apply %s: %v", change, err)
+		}
+	}
+	if err := srv.ReloadAuth(next.Auth); err != nil {
+		log.Printf("Failed to reload auth files: %v", err)
+	}
+	if err := srv.ReloadTicketKeys(next.TLS.SessionTickets); err != nil {
+		log.Printf("Failed to reload session ticket keys: %v", err)
+	}
+	if len(restart) > 0 {
+		log.Printf("Configuration changes to %s take effect after a restart", strings.Join(restart, ", "))
+	}
+}
+
+// storeCipher loads the configured storage encryption keys
+func storeCipher(cfg config.EncryptionConfig) (*store.Cipher, error) {
+	keys := make([]store.Key, 0, len(cfg.Keys))
+	for _, k := range cfg.Keys {
+		material, err := k.Material()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, store.Key{ID: k.ID, Key: material})
+	}
+	return store.NewCipher(keys)
+}
+
+// logEffectiveConfig logs the configuration after includes, profile and
+// defaults as a single JSON line, with secrets redacted
+func logEffectiveConfig(cfg *config.Config) {
+	doc, err := cfg.Redacted()
+	if err != nil {
+		log.Printf("Failed to redact configuration: %v", err)
+		return
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		log.Printf("Failed to encode configuration: %v", err)
+		return
+	}
+	log.Printf("Effective configuration: %s", data)
 }
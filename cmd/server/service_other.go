@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "context"
+
+// runService is only supported on Windows; elsewhere the broker always runs
+// in the foreground (under systemd, see sdNotify)
+func runService(run func(ctx context.Context) error) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,66 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// serviceName is the name the broker is registered under, e.g. with:
+// sc create mqtt-server binPath= "C:\mqtt\mqtt-server.exe -config C:\mqtt\config.yaml"
+const serviceName = "mqtt-server"
+
+// runService runs the broker under the Windows service control manager. It
+// reports false if the process was not started as a service.
+func runService(run func(ctx context.Context) error) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return false, fmt.Errorf("failed to detect service mode: %w", err)
+	}
+	if !isService {
+		return false, nil
+	}
+	return true, svc.Run(serviceName, &service{run: run})
+}
+
+// service adapts the broker to the service control manager
+type service struct {
+	run func(ctx context.Context) error
+}
+
+// Execute starts the broker and translates stop and shutdown requests into
+// a cancellation of its context
+func (s *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.run(ctx)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		case err := <-done:
+			if err != nil {
+				log.Printf("Service stopped: %v", err)
+				return false, 1
+			}
+			return false, 0
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Command storedump prints the content of the broker's bbolt database as
+// JSON for offline debugging and support bundles. The database is opened
+// read-only; stop the broker first, as it holds the file lock.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// sections lists what can be dumped, in output order
+var sections = []string{"sessions", "retained", "queued", "inflight", "presence"}
+
+// message is a stored message as printed
+type message struct {
+	ClientID string `json:"client_id,omitempty"`
+	PacketID uint16 `json:"packet_id,omitempty"`
+	Seq      uint64 `json:"seq,omitempty"`
+	Released bool   `json:"released,omitempty"` // QoS 2 inflight: awaiting PUBCOMP
+	Topic    string `json:"topic"`
+	QoS      byte   `json:"qos"`
+	Retain   bool   `json:"retain,omitempty"`
+	Size     int    `json:"size"`
+	Payload  string `json:"payload,omitempty"`
+	Encoding string `json:"encoding,omitempty"` // "base64" for binary payloads
+}
+
+// session is a stored persistent session as printed
+type session struct {
+	ClientID      string         `json:"client_id"`
+	CleanSession  bool           `json:"clean_session"`
+	Subscriptions []subscription `json:"subscriptions"`
+}
+
+type subscription struct {
+	Topic             string `json:"topic"`
+	QoS               byte   `json:"qos"`
+	RequestedQoS      byte   `json:"requested_qos"`
+	NoLocal           bool   `json:"no_local,omitempty"`
+	RetainAsPublished bool   `json:"retain_as_published,omitempty"`
+	RetainHandling    byte   `json:"retain_handling,omitempty"`
+}
+
+// presence is a stored presence record as printed
+type presence struct {
+	ClientID       string    `json:"client_id"`
+	Username       string    `json:"username,omitempty"`
+	RemoteAddr     string    `json:"remote_addr"`
+	Mountpoint     string    `json:"mountpoint,omitempty"`
+	Online         bool      `json:"online"` // as last recorded; stale if the broker stopped abruptly
+	ConnectedAt    time.Time `json:"connected_at"`
+	LastSeen       time.Time `json:"last_seen"`
+	Connections    uint64    `json:"connections"`
+	TotalConnected string    `json:"total_connected"`
+}
+
+func main() {
+	configPath := flag.String("config", "", "Configuration file providing storage.path and the encryption keys")
+	dbPath := flag.String("db", "", "Database file (default storage.path of -config)")
+	only := flag.String("only", strings.Join(sections, ","), "Comma-separated sections to dump")
+	client := flag.String("client", "", "Only records of ClientIDs matching this pattern (* and ? wildcards)")
+	topic := flag.String("topic", "", "Only messages on topics matching this MQTT topic filter")
+	payloads := flag.String("payload", "auto", "Payload output: auto (text, or base64 if binary), base64 or none")
+	timeout := flag.Duration("timeout", time.Second, "How long to wait for the database lock")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: storedump [-config file | -db file] [flags]\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(*configPath, *dbPath, *only, *client, *topic, *payloads, *timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "storedump: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath, dbPath, only, client, topic, payloads string, timeout time.Duration) error {
+	var cipher *store.Cipher
+	if configPath != "" {
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			return err
+		}
+		if dbPath == "" {
+			dbPath = cfg.Storage.Path
+		}
+		if cfg.Storage.Encryption.Enabled {
+			if cipher, err = storeCipher(cfg.Storage.Encryption); err != nil {
+				return fmt.Errorf("failed to set up storage encryption: %w", err)
+			}
+		}
+	}
+	if dbPath == "" {
+		return fmt.Errorf("no database: pass -db or -config")
+	}
+	if payloads != "auto" && payloads != "base64" && payloads != "none" {
+		return fmt.Errorf("invalid -payload %q (must be auto, base64 or none)", payloads)
+	}
+
+	filter, err := dumpFilter(only, client, topic)
+	if err != nil {
+		return err
+	}
+	st, err := store.OpenReadOnly(dbPath, cipher, timeout)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	dump, err := st.Dump(filter)
+	if errors.Is(err, store.ErrUnknownKey) {
+		return fmt.Errorf("%w (pass -config with the storage encryption keys)", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	out := make(map[string]interface{})
+	if filter.Sessions {
+		list := make([]session, 0, len(dump.Sessions))
+		for _, s := range dump.Sessions {
+			p := session{ClientID: s.ClientID, CleanSession: s.CleanSession, Subscriptions: []subscription{}}
+			for _, sub := range s.Subscriptions {
+				p.Subscriptions = append(p.Subscriptions, subscription(sub))
+			}
+			list = append(list, p)
+		}
+		out["sessions"] = list
+	}
+	if filter.Retained {
+		list := make([]message, 0, len(dump.Retained))
+		for _, r := range dump.Retained {
+			list = append(list, printable(r.Message, payloads))
+		}
+		out["retained"] = list
+	}
+	if filter.Queued {
+		list := make([]message, 0, len(dump.Queued))
+		for _, q := range dump.Queued {
+			m := printable(q.Message, payloads)
+			m.ClientID, m.Seq = q.ClientID, q.Seq
+			list = append(list, m)
+		}
+		out["queued"] = list
+	}
+	if filter.Inflight {
+		list := make([]message, 0, len(dump.Inflight))
+		for _, f := range dump.Inflight {
+			m := printable(f.Message, payloads)
+			m.ClientID, m.PacketID, m.Seq, m.Released = f.ClientID, f.PacketID, f.Seq, f.Released
+			list = append(list, m)
+		}
+		out["inflight"] = list
+	}
+	if filter.Presence {
+		list := make([]presence, 0, len(dump.Presence))
+		for _, p := range dump.Presence {
+			list = append(list, presence{
+				ClientID:       p.ClientID,
+				Username:       p.Username,
+				RemoteAddr:     p.RemoteAddr,
+				Mountpoint:     p.Mountpoint,
+				Online:         p.Online,
+				ConnectedAt:    p.ConnectedAt,
+				LastSeen:       p.LastSeen,
+				Connections:    p.Connections,
+				TotalConnected: p.TotalConnected.String(),
+			})
+		}
+		out["presence"] = list
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// dumpFilter builds the store filter from the command line
+func dumpFilter(only, client, topic string) (store.DumpFilter, error) {
+	var f store.DumpFilter
+	for _, name := range strings.Split(only, ",") {
+		switch strings.TrimSpace(name) {
+		case "sessions":
+			f.Sessions = true
+		case "retained":
+			f.Retained = true
+		case "queued":
+			f.Queued = true
+		case "inflight":
+			f.Inflight = true
+		case "presence":
+			f.Presence = true
+		default:
+			return f, fmt.Errorf("unknown section %q (must be one of %s)", name, strings.Join(sections, ", "))
+		}
+	}
+	if client != "" {
+		if _, err := path.Match(client, ""); err != nil {
+			return f, fmt.Errorf("invalid -client pattern %q: %w", client, err)
+		}
+		f.ClientID = func(id string) bool {
+			ok, _ := path.Match(client, id)
+			return ok
+		}
+	}
+	if topic != "" {
+		if !mqtt.ValidTopicFilter(topic) {
+			return f, fmt.Errorf("invalid -topic filter %q", topic)
+		}
+		f.Topic = func(t string) bool { return server.TopicMatch(topic, t) }
+	}
+	return f, nil
+}
+
+// printable converts a stored message for output
+func printable(msg *store.Message, payloads string) message {
+	m := message{Topic: msg.Topic, QoS: msg.QoS, Retain: msg.Retain, Size: len(msg.Payload)}
+	switch {
+	case payloads == "none":
+	case payloads == "auto" && utf8.Valid(msg.Payload):
+		m.Payload = string(msg.Payload)
+	default:
+		m.Payload, m.Encoding = base64.StdEncoding.EncodeToString(msg.Payload), "base64"
+	}
+	return m
+}
+
+// storeCipher builds the cipher for the configured encryption keys
+func storeCipher(cfg config.EncryptionConfig) (*store.Cipher, error) {
+	keys := make([]store.Key, 0, len(cfg.Keys))
+	for _, k := range cfg.Keys {
+		material, err := k.Material()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, store.Key{ID: k.ID, Key: material})
+	}
+	return store.NewCipher(keys)
+}
@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// API serves the broker's administrative HTTP endpoints
+type API struct {
+	broker *server.Server
+	mux    *http.ServeMux
+	tokens []*token // bearer tokens; none leaves the API open
+}
+
+// New creates the admin API for a broker, authenticating requests with
+// the configured tokens
+func New(broker *server.Server, cfg config.AdminConfig) *API {
+	a := &API{
+		broker: broker,
+		mux:    http.NewServeMux(),
+		tokens: newTokens(cfg.Tokens),
+	}
+	a.routes()
+	return a
+}
+
+// Handler returns the HTTP handler serving the admin API
+func (a *API) Handler() http.Handler {
+	return a.mux
+}
+
+func (a *API) routes() {
+	a.handle("POST /api/v1/clients/{id}/disconnect", RoleOperator, a.disconnectClient)
+	a.handle("GET /api/v1/sessions/{id}", RoleReadOnly, a.getSession)
+	a.handle("GET /api/v1/presence", RoleReadOnly, a.listPresence)
+	a.handle("GET /api/v1/presence/{id}", RoleReadOnly, a.getPresence)
+	a.handle("DELETE /api/v1/presence/{id}", RoleAdmin, a.forgetPresence)
+	a.handle("GET /api/v1/maintenance", RoleReadOnly, a.listMaintenance)
+	a.handle("POST /api/v1/maintenance", RoleOperator, a.addMaintenance)
+	a.handle("DELETE /api/v1/maintenance/{name}", RoleOperator, a.removeMaintenance)
+	a.handle("GET /api/v1/groups", RoleReadOnly, a.listGroups)
+	a.handle("GET /api/v1/groups/{name}", RoleReadOnly, a.getGroup)
+	a.handle("POST /api/v1/groups/{name}/disconnect", RoleOperator, a.disconnectGroup)
+	a.handle("PUT /api/v1/groups/{name}/ratelimit", RoleOperator, a.setGroupRateLimit)
+	a.handle("POST /api/v1/groups/{name}/publish", RoleAdmin, a.publishToGroup)
+	a.handle("POST /api/v1/broadcast", RoleAdmin, a.broadcast)
+	a.handle("GET /api/v1/usage", RoleReadOnly, a.listUsage)
+	a.handle("GET /api/v1/values", RoleReadOnly, a.listValues)
+	a.handle("GET /api/v1/files", RoleReadOnly, a.listFiles)
+	a.handle("GET /api/v1/files/{name}", RoleReadOnly, a.getFile)
+	a.handle("PUT /api/v1/files/{name}", RoleAdmin, a.putFile)
+	a.handle("DELETE /api/v1/files/{name}", RoleAdmin, a.removeFile)
+	a.handle("GET /api/v1/retained", RoleReadOnly, a.retainedTree)
+	a.handle("GET /api/v1/analytics/topics", RoleReadOnly, a.topicReport)
+	a.handle("GET /api/v1/content-types", RoleReadOnly, a.contentTypes)
+	a.handle("GET /api/v1/schemas", RoleReadOnly, a.listSchemas)
+	a.handle("GET /api/v1/slow-consumers", RoleReadOnly, a.listSlowConsumers)
+	a.handle("GET /api/v1/anomalies", RoleReadOnly, a.listAnomalies)
+	a.handle("GET /api/v1/traces", RoleReadOnly, a.listTraces)
+	a.handle("POST /api/v1/traces", RoleOperator, a.startTrace)
+	a.handle("PATCH /api/v1/traces/{id}", RoleOperator, a.extendTrace)
+	a.handle("DELETE /api/v1/traces/{id}", RoleOperator, a.stopTrace)
+	a.handle("GET /api/v1/logging/sampling", RoleReadOnly, a.getLogSampling)
+	a.handle("PUT /api/v1/logging/sampling", RoleOperator, a.setLogSampling)
+	a.handle("GET /api/v1/logging/level", RoleReadOnly, a.getLogLevel)
+	a.handle("PUT /api/v1/logging/level", RoleOperator, a.setLogLevel)
+	a.handle("POST /api/v1/stats/reset", RoleOperator, a.resetStats)
+	a.handle("GET /api/v1/stats/history", RoleReadOnly, a.statsHistory)
+	a.handle("GET /api/v1/config", RoleReadOnly, a.getConfig)
+	a.handle("GET /api/v1/version", RoleReadOnly, a.getVersion)
+	a.handle("GET /api/v1/store/health", RoleReadOnly, a.getStoreHealth)
+	a.handle("GET /api/v1/listeners", RoleReadOnly, a.listListeners)
+	a.handle("POST /api/v1/listeners", RoleAdmin, a.addListener)
+	a.handle("DELETE /api/v1/listeners/{name}", RoleAdmin, a.removeListener)
+	a.handle("GET /api/v1/drain", RoleReadOnly, a.getDrain)
+	a.handle("POST /api/v1/drain", RoleOperator, a.startDrain)
+	a.handle("DELETE /api/v1/drain", RoleOperator, a.stopDrain)
+	a.handle("POST /api/v1/migrate", RoleOperator, a.migrate)
+	a.handle("POST /api/v1/support-bundle", RoleAdmin, a.supportBundle)
+	a.handle("GET /api/v1/tap", RoleOperator, a.tap)
+}
+
+// writeJSON sends v as a JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Admin API: failed to encode response: %v", err)
+	}
+}
+
+// writeError sends an error as a JSON response body
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// statusFor maps broker errors to HTTP status codes
+func statusFor(err error) int {
+	if errors.Is(err, server.ErrGroupNotFound) || errors.Is(err, server.ErrTopicNotFound) ||
+		errors.Is(err, server.ErrTraceNotFound) || errors.Is(err, server.ErrClientNotFound) ||
+		errors.Is(err, server.ErrPresenceNotFound) || errors.Is(err, server.ErrWindowNotFound) ||
+		errors.Is(err, server.ErrSessionNotFound) || errors.Is(err, server.ErrFileNotFound) ||
+		errors.Is(err, server.ErrListenerNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func (a *API) topicReport(w http.ResponseWriter, r *http.Request) {
+	report := a.broker.TopicReport()
+	if report == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("topic analytics are disabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (a *API) contentTypes(w http.ResponseWriter, r *http.Request) {
+	report := a.broker.ContentTypes(r.URL.Query().Get("filter"))
+	if report == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("content type tracking is disabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (a *API) listSchemas(w http.ResponseWriter, r *http.Request) {
+	schemas := a.broker.SchemaCache()
+	if schemas == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("schema validation is disabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, schemas)
+}
+
+func (a *API) listSlowConsumers(w http.ResponseWriter, r *http.Request) {
+	slow := a.broker.SlowConsumers()
+	if slow == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("slow consumer detection is disabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, slow)
+}
+
+func (a *API) listAnomalies(w http.ResponseWriter, r *http.Request) {
+	anomalies := a.broker.TrafficAnomalies()
+	if anomalies == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("anomaly detection is disabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, anomalies)
+}
@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// broadcast sends a command to the online subscribers of a topic and
+// responds with who acknowledged it
+func (a *API) broadcast(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Topic   string `json:"topic"`
+		Payload string `json:"payload"`
+		Timeout string `json:"timeout"` // Go duration to wait for acknowledgements; default 10s
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	var timeout time.Duration
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout: %q", req.Timeout))
+			return
+		}
+		timeout = d
+	}
+
+	report, err := a.broker.Broadcast(r.Context(), req.Topic, []byte(req.Payload), timeout)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("Admin API: broadcast on %s by %s", req.Topic, r.RemoteAddr)
+	writeJSON(w, http.StatusOK, report)
+}
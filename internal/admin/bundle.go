@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/logging"
+	"github.com/ZindGH/MQTT-Server/internal/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// supportBundle returns a zip archive of the broker's state for attaching
+// to bug reports: version, redacted configuration, recent logs, metrics,
+// goroutine stacks and store statistics
+func (a *API) supportBundle(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	doc, err := a.broker.Config().Redacted()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	files := []struct {
+		name  string
+		write func(w io.Writer) error
+	}{
+		{"broker.json", writeIndented(map[string]interface{}{
+			"build":        version.Get(),
+			"cpus":         runtime.NumCPU(),
+			"goroutines":   runtime.NumGoroutine(),
+			"clients":      a.broker.ClientCount(),
+			"generated_at": now,
+		})},
+		{"config.json", writeIndented(doc)},
+		{"logs.txt", func(w io.Writer) error {
+			_, err := io.WriteString(w, strings.Join(logging.Recent(), "\n")+"\n")
+			return err
+		}},
+		{"metrics.txt", func(w io.Writer) error {
+			req, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+			promhttp.Handler().ServeHTTP(&bodyWriter{header: make(http.Header), body: w}, req)
+			return nil
+		}},
+		{"goroutines.txt", func(w io.Writer) error {
+			return pprof.Lookup("goroutine").WriteTo(w, 2)
+		}},
+		{"store.json", writeIndented(a.broker.StoreStats())},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			err = file.write(f)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to build support bundle: %s: %w", file.name, err))
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to build support bundle: %w", err))
+		return
+	}
+
+	log.Printf("Admin API: support bundle created (%d bytes)", buf.Len())
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="support-bundle-%s.zip"`, now.Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// writeIndented returns a function writing v as indented JSON
+func writeIndented(v interface{}) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+}
+
+// bodyWriter passes the body of an HTTP handler's response to a writer
+type bodyWriter struct {
+	header http.Header
+	body   io.Writer
+}
+
+func (b *bodyWriter) Header() http.Header         { return b.header }
+func (b *bodyWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bodyWriter) WriteHeader(int)             {}
@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+func (a *API) disconnectClient(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		ReasonCode byte   `json:"reason_code"`
+		Reason     string `json:"reason"`
+	}{ReasonCode: mqtt.ReasonAdministrativeAction}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.ReasonCode < 0x80 && req.ReasonCode != mqtt.ReasonSuccess {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid reason_code: %#x", req.ReasonCode))
+		return
+	}
+
+	if err := a.broker.DisconnectClient(r.PathValue("id"), req.ReasonCode, req.Reason); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) getSession(w http.ResponseWriter, r *http.Request) {
+	state, err := a.broker.SessionState(r.PathValue("id"))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (a *API) listPresence(w http.ResponseWriter, r *http.Request) {
+	var online *bool
+	if v := r.URL.Query().Get("online"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid online filter: %q", v))
+			return
+		}
+		online = &b
+	}
+
+	presence := a.broker.ClientPresences(online)
+	if presence == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("presence tracking is disabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, presence)
+}
+
+func (a *API) getPresence(w http.ResponseWriter, r *http.Request) {
+	presence, err := a.broker.ClientPresence(r.PathValue("id"))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, presence)
+}
+
+func (a *API) forgetPresence(w http.ResponseWriter, r *http.Request) {
+	if err := a.broker.ForgetClientPresence(r.PathValue("id")); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
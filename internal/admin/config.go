@@ -0,0 +1,22 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/ZindGH/MQTT-Server/internal/version"
+)
+
+// getConfig returns the effective configuration with secrets redacted
+func (a *API) getConfig(w http.ResponseWriter, r *http.Request) {
+	doc, err := a.broker.Config().Redacted()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// getVersion returns the broker's version and build information
+func (a *API) getVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, version.Get())
+}
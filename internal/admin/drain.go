@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+func (a *API) getDrain(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.broker.DrainState())
+}
+
+// startDrain refuses new connections and disconnects all clients, sending
+// MQTT 5 clients to the optional server reference
+func (a *API) startDrain(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ServerReference string `json:"server_reference"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	state := a.broker.Drain(req.ServerReference)
+	log.Printf("Admin API: drain started by %s", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (a *API) stopDrain(w http.ResponseWriter, r *http.Request) {
+	a.broker.Undrain()
+	log.Printf("Admin API: drain ended by %s", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// migrate sends MQTT 5 clients to another node to rebalance load
+func (a *API) migrate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Count           int    `json:"count"`
+		ServerReference string `json:"server_reference"`
+		Policy          string `json:"policy"`
+		Persistent      bool   `json:"persistent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	result, err := a.broker.Migrate(req.Count, req.ServerReference, req.Policy, req.Persistent)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("Admin API: %d clients migrated to %s by %s", len(result.Migrated), result.ServerReference, r.RemoteAddr)
+	writeJSON(w, http.StatusOK, result)
+}
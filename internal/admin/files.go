@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+func (a *API) listFiles(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.broker.Files())
+}
+
+func (a *API) getFile(w http.ResponseWriter, r *http.Request) {
+	file, err := a.broker.File(r.PathValue("name"))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, file)
+}
+
+// putFile distributes the request body as retained chunks, with the chunk
+// size from the optional chunk_size query parameter
+func (a *API) putFile(w http.ResponseWriter, r *http.Request) {
+	chunkSize := 0
+	if v := r.URL.Query().Get("chunk_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid chunk_size: %q", v))
+			return
+		}
+		chunkSize = n
+	}
+	body := r.Body
+	if maxSize := a.broker.Config().Files.MaxSize; maxSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("failed to read file: %w", err))
+		return
+	}
+
+	name := r.PathValue("name")
+	manifest, err := a.broker.DistributeFile(name, data, chunkSize)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	log.Printf("Admin API: file %s (%d bytes, %d chunks) distributed by %s", name, manifest.Size, manifest.Chunks, r.RemoteAddr)
+	writeJSON(w, http.StatusCreated, manifest)
+}
+
+func (a *API) removeFile(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.broker.RemoveFile(name); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	log.Printf("Admin API: file %s removed by %s", name, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
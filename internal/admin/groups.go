@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func (a *API) listGroups(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.broker.Groups())
+}
+
+func (a *API) getGroup(w http.ResponseWriter, r *http.Request) {
+	stats, err := a.broker.GroupStats(r.PathValue("name"))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (a *API) disconnectGroup(w http.ResponseWriter, r *http.Request) {
+	n, err := a.broker.DisconnectGroup(r.PathValue("name"))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"disconnected": n})
+}
+
+func (a *API) setGroupRateLimit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rate  float64 `json:"messages_per_second"`
+		Burst int     `json:"burst"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	if err := a.broker.SetGroupRateLimit(r.PathValue("name"), req.Rate, req.Burst); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) publishToGroup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Payload string `json:"payload"`
+		QoS     byte   `json:"qos"`
+		Retain  bool   `json:"retain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.QoS > 2 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid qos: %d", req.QoS))
+		return
+	}
+
+	n, err := a.broker.PublishToGroup(r.PathValue("name"), []byte(req.Payload), req.QoS, req.Retain)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"published": n})
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+func (a *API) listListeners(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.broker.Listeners())
+}
+
+func (a *API) addListener(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name    string `json:"name"`
+		Type    string `json:"type"` // tcp (default) or tls
+		Host    string `json:"host"` // default: server.host
+		Port    int    `json:"port"` // 0 = any free port
+		Persist bool   `json:"persist"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Type == "" {
+		req.Type = "tcp"
+	}
+
+	cfg := config.ListenerConfig{Name: req.Name, Type: req.Type, Host: req.Host, Port: req.Port}
+	info, err := a.broker.AddListener(cfg, req.Persist)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	log.Printf("Admin API: listener %s on %s added by %s (persist=%v)", info.Name, info.Address, r.RemoteAddr, req.Persist)
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (a *API) removeListener(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	persist := false
+	if v := r.URL.Query().Get("persist"); v != "" {
+		var err error
+		if persist, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid persist flag: %q", v))
+			return
+		}
+	}
+	if err := a.broker.RemoveListener(name, persist); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	log.Printf("Admin API: listener %s removed by %s (persist=%v)", name, r.RemoteAddr, persist)
+	w.WriteHeader(http.StatusNoContent)
+}
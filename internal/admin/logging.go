@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ZindGH/MQTT-Server/internal/logging"
+)
+
+// publishSampling is the body of the publish log sampling endpoints
+type publishSampling struct {
+	PublishSampling int `json:"publish_sampling"` // 1 in N publishes per topic are logged
+}
+
+func (a *API) getLogSampling(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, publishSampling{PublishSampling: a.broker.PublishLogSampling()})
+}
+
+func (a *API) setLogSampling(w http.ResponseWriter, r *http.Request) {
+	var req publishSampling
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	if err := a.broker.SetPublishLogSampling(req.PublishSampling); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logLevel is the body of the log level endpoints
+type logLevel struct {
+	Level string `json:"level"` // debug, info, warn or error
+}
+
+func (a *API) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevel{Level: logging.Level()})
+}
+
+func (a *API) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevel
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	if err := logging.SetLevel(req.Level); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("Log level set to %s", req.Level)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) resetStats(w http.ResponseWriter, r *http.Request) {
+	a.broker.ResetStats()
+	w.WriteHeader(http.StatusNoContent)
+}
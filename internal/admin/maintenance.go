@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+func (a *API) listMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.broker.MaintenanceWindows())
+}
+
+func (a *API) addMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string    `json:"name"`
+		Start    time.Time `json:"start"`    // RFC 3339; default now
+		End      time.Time `json:"end"`      // RFC 3339
+		Duration string    `json:"duration"` // Go duration from start, instead of end
+		Topics   []string  `json:"topics"`
+		Action   string    `json:"action"` // mute (default) or reject
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	if req.Start.IsZero() {
+		req.Start = time.Now()
+	}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %w", err))
+			return
+		}
+		req.End = req.Start.Add(d)
+	}
+
+	window := server.MaintenanceWindow{
+		Name:   req.Name,
+		Start:  req.Start,
+		End:    req.End,
+		Topics: req.Topics,
+		Action: req.Action,
+	}
+	if err := a.broker.AddMaintenanceWindow(window); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	log.Printf("Admin API: maintenance window %s added by %s", req.Name, r.RemoteAddr)
+	writeJSON(w, http.StatusCreated, window)
+}
+
+func (a *API) removeMaintenance(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.broker.RemoveMaintenanceWindow(name); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	log.Printf("Admin API: maintenance window %s removed by %s", name, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
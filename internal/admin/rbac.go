@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// Role is the access level of an admin API token. Each role includes the
+// permissions of the roles below it.
+type Role int
+
+const (
+	RoleReadOnly Role = iota + 1 // GET endpoints only
+	RoleOperator                 // also disconnects, rate limits, traces, maintenance and log settings
+	RoleAdmin                    // also deleting stored data and publishing to clients
+)
+
+// roles maps configured role names to roles
+var roles = map[string]Role{
+	"read-only": RoleReadOnly,
+	"operator":  RoleOperator,
+	"admin":     RoleAdmin,
+}
+
+// token is a configured bearer token
+type token struct {
+	name   string
+	secret []byte
+	role   Role
+}
+
+// handle registers a handler that requires at least the given role
+func (a *API) handle(pattern string, role Role, handler http.HandlerFunc) {
+	a.mux.Handle(pattern, a.require(role, handler))
+}
+
+// require wraps a handler with bearer token authentication. Without
+// configured tokens the API is open, relying on its bind address.
+func (a *API) require(role Role, handler http.HandlerFunc) http.Handler {
+	if len(a.tokens) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := a.authenticate(r)
+		if t == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		if t.role < role {
+			log.Printf("Admin API: %s %s denied for token %s", r.Method, r.URL.Path, t.name)
+			writeError(w, http.StatusForbidden, errors.New("token not permitted to perform this action"))
+			return
+		}
+		if role > RoleReadOnly {
+			log.Printf("Admin API: %s %s by token %s", r.Method, r.URL.Path, t.name)
+		}
+		handler(w, r)
+	})
+}
+
+// authenticate returns the token presented by a request, or nil. Every
+// token is compared so the time taken does not reveal which one matched.
+func (a *API) authenticate(r *http.Request) *token {
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+	var match *token
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(presented), t.secret) == 1 {
+			match = t
+		}
+	}
+	return match
+}
+
+// newTokens converts the configured tokens, which config validation has
+// checked to have known roles
+func newTokens(cfgs []config.AdminTokenConfig) []*token {
+	tokens := make([]*token, 0, len(cfgs))
+	for _, c := range cfgs {
+		tokens = append(tokens, &token{name: c.Name, secret: []byte(c.Token), role: roles[c.Role]})
+	}
+	return tokens
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/pkg/mqtttest"
+)
+
+// roleEndpoints are endpoints requiring each role
+var roleEndpoints = map[Role][2]string{
+	RoleReadOnly: {http.MethodGet, "/api/v1/version"},
+	RoleOperator: {http.MethodPost, "/api/v1/stats/reset"},
+	RoleAdmin:    {http.MethodDelete, "/api/v1/presence/nobody"},
+}
+
+// newTestAPI serves the admin API of a test broker with tokens
+func newTestAPI(t *testing.T, tokens ...config.AdminTokenConfig) *httptest.Server {
+	t.Helper()
+	broker := mqtttest.Start(t)
+	srv := httptest.NewServer(New(broker.Server, config.AdminConfig{Tokens: tokens}).Handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// request sends a request to an endpoint with a bearer token, if any, and
+// returns the response status
+func request(t *testing.T, srv *httptest.Server, endpoint [2]string, bearer string) int {
+	t.Helper()
+	req, err := http.NewRequest(endpoint[0], srv.URL+endpoint[1], nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", bearer)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", endpoint[0], endpoint[1], err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestRBACRoles tests that each token reaches the endpoints of its role and
+// the roles below it, and that requests without a valid token are refused
+func TestRBACRoles(t *testing.T) {
+	srv := newTestAPI(t,
+		config.AdminTokenConfig{Name: "viewer", Token: "view-secret", Role: "read-only"},
+		config.AdminTokenConfig{Name: "ops", Token: "ops-secret", Role: "operator"},
+		config.AdminTokenConfig{Name: "root", Token: "admin-secret", Role: "admin"},
+	)
+	tokens := map[Role]string{
+		RoleReadOnly: "Bearer view-secret",
+		RoleOperator: "Bearer ops-secret",
+		RoleAdmin:    "Bearer admin-secret",
+	}
+
+	for required, endpoint := range roleEndpoints {
+		for role, bearer := range tokens {
+			status := request(t, srv, endpoint, bearer)
+			switch {
+			case role < required && status != http.StatusForbidden:
+				t.Errorf("%s %s with role %d: expected 403, got %d", endpoint[0], endpoint[1], role, status)
+			case role >= required && (status == http.StatusUnauthorized || status == http.StatusForbidden):
+				t.Errorf("%s %s with role %d: expected access, got %d", endpoint[0], endpoint[1], role, status)
+			}
+		}
+		for _, bearer := range []string{"", "Bearer wrong", "Basic YWRtaW46YWRtaW4=", "admin-secret", "Bearer admin-secret2"} {
+			if status := request(t, srv, endpoint, bearer); status != http.StatusUnauthorized {
+				t.Errorf("%s %s with %q: expected 401, got %d", endpoint[0], endpoint[1], bearer, status)
+			}
+		}
+	}
+}
+
+// TestRBACOpenWithoutTokens tests that the API is open when no tokens are
+// configured
+func TestRBACOpenWithoutTokens(t *testing.T) {
+	srv := newTestAPI(t)
+	for _, endpoint := range roleEndpoints {
+		for _, bearer := range []string{"", "Bearer anything"} {
+			if status := request(t, srv, endpoint, bearer); status == http.StatusUnauthorized || status == http.StatusForbidden {
+				t.Errorf("%s %s with %q: expected access, got %d", endpoint[0], endpoint[1], bearer, status)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// retainedTree returns one level of the retained topic hierarchy.
+// Query parameters: topic (default: root), offset, limit.
+func (a *API) retainedTree(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %q", query.Get("offset")))
+		return
+	}
+	limit, err := queryInt(query.Get("limit"), defaultPageSize)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %q (must be 1-%d)", query.Get("limit"), maxPageSize))
+		return
+	}
+
+	tree, err := a.broker.RetainedTree(query.Get("topic"), offset, limit)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tree)
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
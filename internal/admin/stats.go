@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// defaultHistoryRange is the statistics history returned when no start is
+// given
+const defaultHistoryRange = 24 * time.Hour
+
+func (a *API) statsHistory(w http.ResponseWriter, r *http.Request) {
+	to := time.Now()
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid to: %w", err))
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultHistoryRange)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid from: %w", err))
+			return
+		}
+		from = t
+	}
+
+	samples, err := a.broker.StatsHistory(from, to)
+	if errors.Is(err, server.ErrStatsHistoryDisabled) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, samples)
+}
@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+)
+
+// getStoreHealth returns the last store health check, with status 503 while
+// the store is down
+func (a *API) getStoreHealth(w http.ResponseWriter, r *http.Request) {
+	h := a.broker.StoreHealth()
+	if h == nil {
+		writeError(w, http.StatusNotFound, errors.New("broker has no store"))
+		return
+	}
+	status := http.StatusOK
+	if !h.Up {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, h)
+}
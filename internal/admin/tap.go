@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// tapKeepAlive is how often an idle tap stream is sent a comment so
+// proxies keep it open
+const tapKeepAlive = 15 * time.Second
+
+// tap streams routed messages as server-sent events until the client goes
+// away. Query parameters: topic (filter), client (publisher ClientID), rate
+// (messages per second) and max_payload (bytes per message). "message"
+// events carry the messages, "dropped" events the running count of
+// matching messages skipped by the rate limit.
+func (a *API) tap(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := server.TapFilter{
+		Topic:    query.Get("topic"),
+		ClientID: query.Get("client"),
+	}
+	if v := query.Get("rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid rate: %q", v))
+			return
+		}
+		filter.Rate = rate
+	}
+	if v := query.Get("max_payload"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_payload: %q", v))
+			return
+		}
+		filter.MaxPayload = n
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+	t, err := a.broker.OpenTap(filter)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	defer t.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(tapKeepAlive)
+	defer keepAlive.Stop()
+	stats := time.NewTicker(time.Second)
+	defer stats.Stop()
+	var reported uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-t.C():
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(msg)
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		case <-stats.C:
+			dropped := t.Dropped()
+			if dropped == reported {
+				continue
+			}
+			reported = dropped
+			fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
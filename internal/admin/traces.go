@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultTraceTTL applies when a trace request does not set a TTL
+const defaultTraceTTL = 10 * time.Minute
+
+func (a *API) listTraces(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.broker.Traces())
+}
+
+func (a *API) startTrace(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID string `json:"client_id"`
+		Topic    string `json:"topic"`
+		TTL      string `json:"ttl"` // Go duration, e.g. "5m"
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	ttl := defaultTraceTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl: %w", err))
+			return
+		}
+		ttl = d
+	}
+
+	rule, err := a.broker.StartTrace(req.ClientID, req.Topic, ttl)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func (a *API) extendTrace(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TTL string `json:"ttl"` // Go duration, counted from now
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl: %w", err))
+		return
+	}
+
+	rule, err := a.broker.ExtendTrace(r.PathValue("id"), ttl)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (a *API) stopTrace(w http.ResponseWriter, r *http.Request) {
+	if err := a.broker.StopTrace(r.PathValue("id")); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
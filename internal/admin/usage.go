@@ -0,0 +1,15 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func (a *API) listUsage(w http.ResponseWriter, r *http.Request) {
+	usage := a.broker.Usage()
+	if usage == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("usage quotas are disabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
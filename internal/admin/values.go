@@ -0,0 +1,15 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func (a *API) listValues(w http.ResponseWriter, r *http.Request) {
+	values := a.broker.LastValues(r.URL.Query().Get("prefix"))
+	if values == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("last-value cache is disabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, values)
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Access is the kind of access an ACL rule grants
+type Access int
+
+const (
+	Read      Access = 1 << iota // Receive messages, including retained ones
+	Write                        // Publish messages
+	ReadWrite = Read | Write
+)
+
+// deny is the access of rules that deny a topic
+const deny Access = 0
+
+// accessNames maps the access keywords of an ACL file to their access
+var accessNames = map[string]Access{
+	"read":      Read,
+	"write":     Write,
+	"readwrite": ReadWrite,
+	"deny":      deny,
+}
+
+// aclRule grants or denies access to a topic filter
+type aclRule struct {
+	access Access
+	topic  string
+}
+
+// ACL holds topic access rules in the mosquitto acl_file format:
+//
+//	topic [read|write|readwrite|deny] <filter>    rule for the current user
+//	user <username>                               following topic rules apply to this user
+//	pattern [read|write|readwrite|deny] <filter>  rule for every client
+//
+// Topic rules before the first user line apply to anonymous clients. The
+// access defaults to readwrite. A topic is accessible if a matching rule
+// grants the access and no matching rule denies it. Blank lines and lines
+// starting with '#' are ignored.
+//
+// Filters of both rule kinds may bind topics to the client's identity
+// with %c (ClientID) and %u (username), as in "pattern write devices/%c/#".
+// Unlike mosquitto, which expands them in pattern rules only, topic rules
+// accept them too, so a user's devices can share one set of templates. A
+// rule cannot be bound to a client whose value is empty or contains '/',
+// '+' or '#': a granting rule then does not apply, and a deny rule denies
+// every topic.
+type ACL struct {
+	anonymous []aclRule
+	users     map[string][]aclRule
+	patterns  []aclRule
+}
+
+// LoadACLFile reads an ACL file
+func LoadACLFile(path string) (*ACL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ACL file: %w", err)
+	}
+	defer f.Close()
+
+	acl := &ACL{users: make(map[string][]aclRule)}
+	user, anonymous := "", true
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		keyword, rest, _ := strings.Cut(text, " ")
+		rest = strings.TrimSpace(rest)
+		switch keyword {
+		case "user":
+			if rest == "" {
+				return nil, fmt.Errorf("%s:%d: expected user <username>", path, line)
+			}
+			user, anonymous = rest, false
+		case "topic", "pattern":
+			rule, err := parseACLRule(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			switch {
+			case keyword == "pattern":
+				acl.patterns = append(acl.patterns, rule)
+			case anonymous:
+				acl.anonymous = append(acl.anonymous, rule)
+			default:
+				acl.users[user] = append(acl.users[user], rule)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown keyword %q (expected topic, user or pattern)", path, line, keyword)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ACL file: %w", err)
+	}
+	return acl, nil
+}
+
+// parseACLRule parses "[access] <filter>"
+func parseACLRule(text string) (aclRule, error) {
+	rule := aclRule{access: ReadWrite, topic: text}
+	if word, topic, ok := strings.Cut(text, " "); ok {
+		if access, known := accessNames[word]; known {
+			rule.access, rule.topic = access, strings.TrimSpace(topic)
+		}
+	}
+	if rule.topic == "" {
+		return aclRule{}, fmt.Errorf("expected a topic filter")
+	}
+	return rule, nil
+}
+
+// Len returns the number of rules
+func (a *ACL) Len() int {
+	n := len(a.anonymous) + len(a.patterns)
+	for _, rules := range a.users {
+		n += len(rules)
+	}
+	return n
+}
+
+// Allowed reports whether a client may access a topic. Clients without a
+// username are subject to the anonymous rules.
+func (a *ACL) Allowed(clientID, username, topic string, access Access) bool {
+	granted, denied := false, false
+	a.forEachRule(clientID, username, func(rule Access, filter string) bool {
+		if matchTopic(filter, topic) {
+			denied = rule == deny
+			granted = granted || rule&access == access
+		}
+		return !denied
+	})
+	return granted && !denied
+}
+
+// CanSubscribe reports whether a client may subscribe to a topic filter:
+// a rule must grant read access to some topic the filter matches, and no
+// rule may deny all of them. Messages within an accepted subscription are
+// still checked with Allowed as they are delivered, so wildcard
+// subscriptions only receive the topics the client may read.
+func (a *ACL) CanSubscribe(clientID, username, filter string) bool {
+	granted, denied := false, false
+	a.forEachRule(clientID, username, func(rule Access, ruleFilter string) bool {
+		if rule == deny {
+			denied = filterCovers(ruleFilter, filter)
+		} else if rule&Read != 0 && filtersOverlap(ruleFilter, filter) {
+			granted = true
+		}
+		return !denied
+	})
+	return granted && !denied
+}
+
+// forEachRule calls fn with the access and expanded filter of every rule
+// applying to a client, until fn returns false. Clients without a username
+// are subject to the anonymous rules.
+func (a *ACL) forEachRule(clientID, username string, fn func(access Access, filter string) bool) {
+	rules := a.anonymous
+	if username != "" {
+		rules = a.users[username]
+	}
+	for _, set := range [][]aclRule{rules, a.patterns} {
+		for _, rule := range set {
+			filter, ok := expandPattern(rule.topic, clientID, username)
+			if !ok {
+				if rule.access != deny {
+					continue
+				}
+				filter = "#"
+			}
+			if !fn(rule.access, filter) {
+				return
+			}
+		}
+	}
+}
+
+// expandPattern substitutes %c and %u in a rule's filter. It reports false
+// when a substituted value is empty or would change the filter's levels or
+// wildcards.
+func expandPattern(pattern, clientID, username string) (string, bool) {
+	for placeholder, value := range map[string]string{"%c": clientID, "%u": username} {
+		if !strings.Contains(pattern, placeholder) {
+			continue
+		}
+		if value == "" || strings.ContainsAny(value, "/+#") {
+			return "", false
+		}
+		pattern = strings.ReplaceAll(pattern, placeholder, value)
+	}
+	return pattern, true
+}
+
+// matchTopic reports whether a topic filter with + and # wildcards matches
+// a topic name
+func matchTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// filterCovers reports whether every topic matched by filter is also
+// matched by rule
+func filterCovers(rule, filter string) bool {
+	ruleLevels := strings.Split(rule, "/")
+	filterLevels := strings.Split(filter, "/")
+	for i, level := range ruleLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(filterLevels) || filterLevels[i] == "#" {
+			return false
+		}
+		if level != "+" && level != filterLevels[i] {
+			return false
+		}
+	}
+	return len(ruleLevels) == len(filterLevels)
+}
+
+// filtersOverlap reports whether some topic is matched by both filters
+func filtersOverlap(a, b string) bool {
+	aLevels := strings.Split(a, "/")
+	bLevels := strings.Split(b, "/")
+	n := min(len(aLevels), len(bLevels))
+	for i := range n {
+		if aLevels[i] == "#" || bLevels[i] == "#" {
+			return true
+		}
+		if aLevels[i] != "+" && bLevels[i] != "+" && aLevels[i] != bLevels[i] {
+			return false
+		}
+	}
+	// "a/#" also matches "a"
+	longer := aLevels
+	if len(bLevels) > len(aLevels) {
+		longer = bLevels
+	}
+	return len(longer) == n || (len(longer) == n+1 && longer[n] == "#")
+}
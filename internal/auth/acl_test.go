@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestACL writes an ACL file and loads it
+func loadTestACL(t *testing.T, lines ...string) *ACL {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write ACL file: %v", err)
+	}
+	acl, err := LoadACLFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load ACL file: %v", err)
+	}
+	return acl
+}
+
+// accessCase is an access a client asks for and whether it is allowed
+type accessCase struct {
+	clientID, username, topic string
+	access                    Access
+	want                      bool
+}
+
+func checkAccess(t *testing.T, acl *ACL, cases []accessCase) {
+	t.Helper()
+	for _, tc := range cases {
+		if got := acl.Allowed(tc.clientID, tc.username, tc.topic, tc.access); got != tc.want {
+			t.Errorf("Allowed(%q, %q, %q, %d) = %v, want %v", tc.clientID, tc.username, tc.topic, tc.access, got, tc.want)
+		}
+	}
+}
+
+// TestLoadACLFile tests parsing rules, their default access, comments and
+// invalid lines
+func TestLoadACLFile(t *testing.T) {
+	acl := loadTestACL(t,
+		"# anonymous clients",
+		"topic read public/#",
+		"",
+		"user alice",
+		"topic alice/#",
+		"topic deny alice/secret",
+		"user bob",
+		"topic write bob/in",
+		"pattern read devices/%c/config",
+	)
+	if acl.Len() != 5 {
+		t.Errorf("Expected 5 rules, got %d", acl.Len())
+	}
+	if len(acl.anonymous) != 1 || len(acl.users["alice"]) != 2 || len(acl.users["bob"]) != 1 || len(acl.patterns) != 1 {
+		t.Errorf("Rules assigned to the wrong clients: %+v", acl)
+	}
+	if rule := acl.users["alice"][0]; rule.access != ReadWrite || rule.topic != "alice/#" {
+		t.Errorf("Expected readwrite access by default, got %+v", rule)
+	}
+	if rule := acl.users["alice"][1]; rule.access != deny || rule.topic != "alice/secret" {
+		t.Errorf("Expected a deny rule, got %+v", rule)
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"unknown keyword": "group admins\n",
+		"missing user":    "user\n",
+		"missing topic":   "topic\n",
+		"missing pattern": "pattern\n",
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-"))
+		os.WriteFile(path, []byte(content), 0600)
+		if _, err := LoadACLFile(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := LoadACLFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+}
+
+// TestACLAllowed tests which rules apply to anonymous clients, users and
+// everyone, and that deny rules win over grants
+func TestACLAllowed(t *testing.T) {
+	acl := loadTestACL(t,
+		"topic read public/#",
+		"user alice",
+		"topic alice/#",
+		"topic deny alice/secret",
+		"topic read public/#",
+		"user bob",
+		"topic write bob/+/in",
+		"pattern read status/#",
+		"pattern deny status/internal",
+	)
+	checkAccess(t, acl, []accessCase{
+		{"c1", "", "public/news", Read, true},
+		{"c1", "", "public/news", Write, false},
+		{"c1", "", "alice/data", Read, false},
+		{"c1", "", "status/up", Read, true},
+
+		{"c2", "alice", "alice/data", ReadWrite, true},
+		{"c2", "alice", "alice", Read, true},
+		{"c2", "alice", "alice/secret", Read, false},
+		{"c2", "alice", "alice/secret", Write, false},
+		{"c2", "alice", "public/news", Read, true},
+		{"c2", "alice", "bob/x/in", Write, false},
+
+		{"c3", "bob", "bob/x/in", Write, true},
+		{"c3", "bob", "bob/x/in", Read, false},
+		{"c3", "bob", "bob/x/y/in", Write, false},
+		{"c3", "bob", "public/news", Read, false},
+		{"c3", "bob", "status/up", Read, true},
+		{"c3", "bob", "status/internal", Read, false},
+
+		{"c4", "carol", "status/up", Read, true},
+		{"c4", "carol", "public/news", Read, false},
+	})
+}
+
+// TestACLCanSubscribe tests that a filter needs read access to some of its
+// topics and is refused when a deny rule covers all of them
+func TestACLCanSubscribe(t *testing.T) {
+	acl := loadTestACL(t,
+		"user alice",
+		"topic read alice/#",
+		"topic write commands/#",
+		"topic deny alice/secret/#",
+	)
+	for filter, want := range map[string]bool{
+		"alice/#":          true,
+		"alice/+/temp":     true,
+		"#":                true,
+		"+/secret/x":       true,
+		"alice/secret/#":   false,
+		"alice/secret/a/b": false,
+		"commands/#":       false,
+		"other/#":          false,
+	} {
+		if got := acl.CanSubscribe("c1", "alice", filter); got != want {
+			t.Errorf("CanSubscribe(%q) = %v, want %v", filter, got, want)
+		}
+	}
+}
+
+// TestACLPatterns tests binding pattern rules to the ClientID and
+// username, and that a deny pattern that cannot be bound to a client
+// denies it every topic instead of not applying
+func TestACLPatterns(t *testing.T) {
+	acl := loadTestACL(t,
+		"pattern readwrite devices/%c/#",
+		"pattern read users/%u/#",
+		"pattern deny devices/%c/secret",
+	)
+	checkAccess(t, acl, []accessCase{
+		{"sensor", "", "devices/sensor/temp", Write, true},
+		{"sensor", "", "devices/other/temp", Write, false},
+		{"sensor", "", "devices/sensor/secret", Read, false},
+		{"sensor", "alice", "users/alice/inbox", Read, true},
+		{"sensor", "alice", "users/bob/inbox", Read, false},
+		{"sensor", "", "users//inbox", Read, false},
+
+		// A ClientID with levels or wildcards would escape the deny rule
+		{"a/b", "", "devices/a/b/secret", Read, false},
+		{"a/b", "", "devices/a/b/temp", Read, false},
+		{"a/b", "alice", "users/alice/inbox", Read, false},
+		{"+", "", "devices/x/secret", Read, false},
+		{"#", "alice", "users/alice/inbox", Read, false},
+	})
+	if acl.CanSubscribe("a/b", "alice", "users/alice/#") {
+		t.Error("Expected a client the deny rule cannot be bound to not to subscribe")
+	}
+	if !acl.CanSubscribe("sensor", "alice", "users/alice/#") {
+		t.Error("Expected a bound client to subscribe to its own topics")
+	}
+}
+
+// TestACLTopicTemplates tests %c and %u in the topic rules of users and
+// anonymous clients, deny templates and IDs that would escape them
+func TestACLTopicTemplates(t *testing.T) {
+	acl := loadTestACL(t,
+		"topic read lobby/%c",
+		"user fleet",
+		"topic readwrite fleet/%c/#",
+		"topic deny fleet/%c/firmware",
+		"topic write audit/%u/%c",
+	)
+	checkAccess(t, acl, []accessCase{
+		{"truck1", "fleet", "fleet/truck1/gps", ReadWrite, true},
+		{"truck1", "fleet", "fleet/truck2/gps", Read, false},
+		{"truck1", "fleet", "fleet/truck1/firmware", Write, false},
+		{"truck1", "fleet", "audit/fleet/truck1", Write, true},
+		{"truck1", "fleet", "audit/fleet/truck2", Write, false},
+		{"truck1", "fleet", "lobby/truck1", Read, false},
+
+		{"guest", "", "lobby/guest", Read, true},
+		{"guest", "", "lobby/other", Read, false},
+		{"guest", "", "fleet/guest/gps", Read, false},
+
+		// Hostile ClientIDs match neither another client's topics nor
+		// their own, and lose the user's grants to the deny template
+		{"truck2/gps", "fleet", "fleet/truck2/gps", Read, false},
+		{"+", "fleet", "fleet/truck2/gps", Read, false},
+		{"#", "fleet", "fleet/truck2/firmware", Write, false},
+		{"#", "fleet", "audit/fleet/#", Write, false},
+		{"a/b", "", "lobby/a/b", Read, false},
+		{"+", "", "lobby/x", Read, false},
+	})
+	if acl.CanSubscribe("+", "fleet", "fleet/#") {
+		t.Error("Expected a wildcard ClientID not to subscribe to the fleet")
+	}
+	if !acl.CanSubscribe("truck1", "fleet", "fleet/truck1/#") || acl.CanSubscribe("truck1", "fleet", "fleet/truck1/firmware") {
+		t.Error("Expected truck1 to subscribe to its topics except the firmware")
+	}
+}
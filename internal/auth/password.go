@@ -0,0 +1,159 @@
+// Package auth verifies client credentials and topic access.
+package auth
+
+import (
+	"bufio"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// pbkdf2Iterations and pbkdf2SaltLen match mosquitto_passwd defaults
+	pbkdf2Iterations = 101
+	pbkdf2SaltLen    = 12
+	pbkdf2KeyLen     = sha512.Size
+)
+
+// PasswordFile holds hashed passwords in the mosquitto_passwd format, one
+// "username:hash" entry per line. Two hash formats are understood:
+//
+//	$7$<iterations>$<salt>$<hash>  PBKDF2-SHA512 (mosquitto 2.x)
+//	$6$<salt>$<hash>               salted SHA512 (mosquitto 1.x)
+//
+// Salts and hashes are standard base64. Blank lines and lines starting with
+// '#' are ignored.
+type PasswordFile struct {
+	users map[string]passwordHash
+}
+
+// passwordHash is a parsed hash entry
+type passwordHash struct {
+	iterations int // 0 for salted SHA512
+	salt       []byte
+	hash       []byte
+}
+
+// dummyHash is checked for unknown users so they take as long to reject as
+// known users with a wrong password
+var dummyHash = passwordHash{
+	iterations: pbkdf2Iterations,
+	salt:       make([]byte, pbkdf2SaltLen),
+	hash:       make([]byte, pbkdf2KeyLen),
+}
+
+// LoadPasswordFile reads a password file
+func LoadPasswordFile(path string) (*PasswordFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open password file: %w", err)
+	}
+	defer f.Close()
+
+	pf := &PasswordFile{users: make(map[string]passwordHash)}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, encoded, ok := strings.Cut(text, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("%s:%d: expected username:hash", path, line)
+		}
+		h, err := parseHash(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: user %s: %w", path, line, username, err)
+		}
+		pf.users[username] = h
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read password file: %w", err)
+	}
+	return pf, nil
+}
+
+// Len returns the number of users in the file
+func (pf *PasswordFile) Len() int {
+	return len(pf.users)
+}
+
+// Check reports whether password is correct for username
+func (pf *PasswordFile) Check(username string, password []byte) bool {
+	h, ok := pf.users[username]
+	if !ok {
+		dummyHash.matches(password)
+		return false
+	}
+	return h.matches(password)
+}
+
+// HashPassword returns a PBKDF2-SHA512 password file entry hash for password
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, pbkdf2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha512.New, password, salt, pbkdf2Iterations, pbkdf2KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$7$%d$%s$%s", pbkdf2Iterations,
+		base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(key)), nil
+}
+
+// parseHash decodes a "$7$..." or "$6$..." hash
+func parseHash(encoded string) (passwordHash, error) {
+	parts := strings.Split(encoded, "$")
+	var h passwordHash
+	var salt, hash string
+	switch {
+	case len(parts) == 5 && parts[0] == "" && parts[1] == "7":
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n < 1 {
+			return h, fmt.Errorf("invalid iteration count %q", parts[2])
+		}
+		h.iterations = n
+		salt, hash = parts[3], parts[4]
+	case len(parts) == 4 && parts[0] == "" && parts[1] == "6":
+		salt, hash = parts[2], parts[3]
+	default:
+		return h, fmt.Errorf("unsupported hash format (expected $7$ or $6$)")
+	}
+
+	var err error
+	if h.salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+		return h, fmt.Errorf("invalid salt: %w", err)
+	}
+	if h.hash, err = base64.StdEncoding.DecodeString(hash); err != nil {
+		return h, fmt.Errorf("invalid hash: %w", err)
+	}
+	return h, nil
+}
+
+// matches hashes password the same way as h and compares the results in
+// constant time
+func (h passwordHash) matches(password []byte) bool {
+	var sum []byte
+	if h.iterations > 0 {
+		key, err := pbkdf2.Key(sha512.New, string(password), h.salt, h.iterations, len(h.hash))
+		if err != nil {
+			return false
+		}
+		sum = key
+	} else {
+		digest := sha512.New()
+		digest.Write(password)
+		digest.Write(h.salt)
+		sum = digest.Sum(nil)
+	}
+	return subtle.ConstantTimeCompare(sum, h.hash) == 1
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sha512Hash returns a mosquitto 1.x "$6$" hash of password
+func sha512Hash(password string, salt []byte) string {
+	digest := sha512.Sum512(append([]byte(password), salt...))
+	return "$6$" + base64.StdEncoding.EncodeToString(salt) + "$" + base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// pbkdf2Hash returns a mosquitto 2.x "$7$" hash of password
+func pbkdf2Hash(t *testing.T, password string, salt []byte, iterations int) string {
+	key, err := pbkdf2.Key(sha512.New, password, salt, iterations, pbkdf2KeyLen)
+	if err != nil {
+		t.Fatalf("Failed to derive key: %v", err)
+	}
+	return fmt.Sprintf("$7$%d$%s$%s", iterations, base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(key))
+}
+
+// writePasswordFile writes the lines of a password file and returns its path
+func writePasswordFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write password file: %v", err)
+	}
+	return path
+}
+
+// TestPasswordFileCheck tests both hash formats, wrong passwords and
+// unknown users
+func TestPasswordFileCheck(t *testing.T) {
+	generated, err := HashPassword("gen-secret")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	pf, err := LoadPasswordFile(writePasswordFile(t,
+		"# users",
+		"",
+		"legacy:"+sha512Hash("old-secret", []byte("saltsalt")),
+		"modern:"+pbkdf2Hash(t, "new-secret", []byte("twelve-bytes"), 1000),
+		"generated:"+generated,
+		"colon:"+pbkdf2Hash(t, "a:b", []byte("salt"), 1),
+	))
+	if err != nil {
+		t.Fatalf("Failed to load password file: %v", err)
+	}
+	if pf.Len() != 4 {
+		t.Errorf("Expected 4 users, got %d", pf.Len())
+	}
+
+	tests := []struct {
+		username, password string
+		want               bool
+	}{
+		{"legacy", "old-secret", true},
+		{"legacy", "old-secreT", false},
+		{"legacy", "", false},
+		{"modern", "new-secret", true},
+		{"modern", "old-secret", false},
+		{"generated", "gen-secret", true},
+		{"generated", "gen-secret ", false},
+		{"colon", "a:b", true},
+		{"unknown", "new-secret", false},
+		{"", "", false},
+		{"Modern", "new-secret", false},
+	}
+	for _, tc := range tests {
+		if got := pf.Check(tc.username, []byte(tc.password)); got != tc.want {
+			t.Errorf("Check(%q, %q) = %v, want %v", tc.username, tc.password, got, tc.want)
+		}
+	}
+}
+
+// TestLoadPasswordFileInvalid tests that malformed entries and hashes are
+// refused with their line
+func TestLoadPasswordFileInvalid(t *testing.T) {
+	salt := base64.StdEncoding.EncodeToString([]byte("salt"))
+	tests := map[string]string{
+		"no separator":         "alice",
+		"empty username":       ":" + sha512Hash("x", []byte("salt")),
+		"plain text password":  "alice:secret",
+		"unknown scheme":       "alice:$5$" + salt + "$" + salt,
+		"missing hash":         "alice:$6$" + salt,
+		"extra field":          "alice:$6$" + salt + "$" + salt + "$" + salt,
+		"bad salt":             "alice:$6$not*base64$" + salt,
+		"bad hash":             "alice:$7$101$" + salt + "$not*base64",
+		"no iterations":        "alice:$7$$" + salt + "$" + salt,
+		"zero iterations":      "alice:$7$0$" + salt + "$" + salt,
+		"negative iterations":  "alice:$7$-5$" + salt + "$" + salt,
+		"text iterations":      "alice:$7$many$" + salt + "$" + salt,
+		"missing leading $":    "alice:7$101$" + salt + "$" + salt,
+		"pbkdf2 missing field": "alice:$7$101$" + salt,
+	}
+	for name, entry := range tests {
+		_, err := LoadPasswordFile(writePasswordFile(t, "# header", entry))
+		if err == nil {
+			t.Errorf("%s: expected %q to be refused", name, entry)
+		} else if !strings.Contains(err.Error(), ":2:") {
+			t.Errorf("%s: expected the error to name line 2, got %v", name, err)
+		}
+	}
+	if _, err := LoadPasswordFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Policy is the service level granted to the connections of a user
+type Policy struct {
+	MaxQoS    int      // highest QoS granted to subscriptions (-1 = broker default)
+	KeepAlive int      // keepalive in seconds enforced by the broker (0 = the client's own); only lengthens that of MQTT 3.1/3.1.1 clients
+	RateLimit float64  // publishes per second (0 = unlimited)
+	RateBurst int      // publishes allowed in a burst above the rate limit
+	MaxQueued int      // messages queued while the session is offline (0 = broker default)
+	Topics    []string // filters the client may publish, subscribe and receive on (empty = any)
+}
+
+// Policies holds per-user policies in a file in the style of the ACL file:
+//
+//	user <username>     following settings apply to this user
+//	max_qos <0-2>
+//	keepalive <seconds>
+//	rate_limit <messages per second> [burst]
+//	max_queued <messages>
+//	topic <filter>      may be repeated; filters accept %c and %u
+//
+// Settings before the first user line apply to anonymous clients. Users
+// without a policy get the broker defaults. Blank lines and lines starting
+// with '#' are ignored.
+type Policies struct {
+	anonymous *Policy
+	users     map[string]*Policy
+}
+
+// newPolicy returns a policy with the broker defaults
+func newPolicy() *Policy {
+	return &Policy{MaxQoS: -1}
+}
+
+// LoadPolicyFile reads a policy file
+func LoadPolicyFile(path string) (*Policies, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open policy file: %w", err)
+	}
+	defer f.Close()
+
+	policies := &Policies{users: make(map[string]*Policy)}
+	var current *Policy
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		keyword, rest, _ := strings.Cut(text, " ")
+		rest = strings.TrimSpace(rest)
+		if keyword == "user" {
+			if rest == "" {
+				return nil, fmt.Errorf("%s:%d: expected user <username>", path, line)
+			}
+			if policies.users[rest] == nil {
+				policies.users[rest] = newPolicy()
+			}
+			current = policies.users[rest]
+			continue
+		}
+		if current == nil {
+			if policies.anonymous == nil {
+				policies.anonymous = newPolicy()
+			}
+			current = policies.anonymous
+		}
+		if err := current.set(keyword, rest); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	return policies, nil
+}
+
+// set parses a policy setting
+func (p *Policy) set(keyword, value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return fmt.Errorf("expected a value for %s", keyword)
+	}
+	var err error
+	switch keyword {
+	case "max_qos":
+		p.MaxQoS, err = strconv.Atoi(fields[0])
+		if err == nil && (p.MaxQoS < 0 || p.MaxQoS > 2) {
+			err = fmt.Errorf("must be 0, 1 or 2")
+		}
+	case "keepalive":
+		p.KeepAlive, err = strconv.Atoi(fields[0])
+		if err == nil && (p.KeepAlive < 0 || p.KeepAlive > 65535) {
+			err = fmt.Errorf("must be between 0 and 65535 seconds")
+		}
+	case "rate_limit":
+		p.RateLimit, err = strconv.ParseFloat(fields[0], 64)
+		if err == nil && p.RateLimit < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		if err == nil && len(fields) > 1 {
+			p.RateBurst, err = strconv.Atoi(fields[1])
+		}
+	case "max_queued":
+		p.MaxQueued, err = strconv.Atoi(fields[0])
+	case "topic":
+		p.Topics = append(p.Topics, value)
+	default:
+		return fmt.Errorf("unknown keyword %q (expected user, max_qos, keepalive, rate_limit, max_queued or topic)", keyword)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", keyword, value, err)
+	}
+	return nil
+}
+
+// Len returns the number of policies
+func (p *Policies) Len() int {
+	if p.anonymous != nil {
+		return len(p.users) + 1
+	}
+	return len(p.users)
+}
+
+// For returns the policy of a user, the anonymous policy for clients
+// without a username, or nil if none is defined
+func (p *Policies) For(username string) *Policy {
+	if username == "" {
+		return p.anonymous
+	}
+	return p.users[username]
+}
+
+// Allowed reports whether a policy lets a client publish or receive on a
+// topic
+func (p *Policy) Allowed(clientID, username, topic string) bool {
+	return p.anyTopic(clientID, username, func(filter string) bool {
+		return matchTopic(filter, topic)
+	})
+}
+
+// CanSubscribe reports whether a policy lets a client subscribe to a topic
+// filter: some of the topics it matches must be allowed. Deliveries are
+// checked with Allowed.
+func (p *Policy) CanSubscribe(clientID, username, filter string) bool {
+	return p.anyTopic(clientID, username, func(allowed string) bool {
+		return filtersOverlap(allowed, filter)
+	})
+}
+
+// anyTopic reports whether match accepts one of the policy's expanded
+// topic filters, or the policy does not restrict topics
+func (p *Policy) anyTopic(clientID, username string, match func(filter string) bool) bool {
+	if p == nil || len(p.Topics) == 0 {
+		return true
+	}
+	for _, topic := range p.Topics {
+		if filter, ok := expandPattern(topic, clientID, username); ok && match(filter) {
+			return true
+		}
+	}
+	return false
+}
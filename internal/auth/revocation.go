@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// RevocationList holds revoked client identities, one per line:
+//
+//	user <username>     every connection of the user
+//	client <ClientID>   connections with the ClientID
+//
+// Blank lines and lines starting with '#' are ignored.
+type RevocationList struct {
+	users   map[string]bool
+	clients map[string]bool
+}
+
+// ParseRevocationList reads a revocation list; name identifies it in errors
+func ParseRevocationList(r io.Reader, name string) (*RevocationList, error) {
+	list := &RevocationList{users: make(map[string]bool), clients: make(map[string]bool)}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		keyword, identity, _ := strings.Cut(text, " ")
+		identity = strings.TrimSpace(identity)
+		if identity == "" {
+			return nil, fmt.Errorf("%s:%d: expected %s <identity>", name, line, keyword)
+		}
+		switch keyword {
+		case "user":
+			list.users[identity] = true
+		case "client":
+			list.clients[identity] = true
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown keyword %q (expected user or client)", name, line, keyword)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read revocation list: %w", err)
+	}
+	return list, nil
+}
+
+// LoadRevocationFile reads a revocation list from a file
+func LoadRevocationFile(path string) (*RevocationList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open revocation list: %w", err)
+	}
+	defer f.Close()
+	return ParseRevocationList(f, path)
+}
+
+// FetchRevocationList downloads a revocation list from an HTTP endpoint
+func FetchRevocationList(ctx context.Context, url string) (*RevocationList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid revocation list URL: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch revocation list: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch revocation list: %s", resp.Status)
+	}
+	return ParseRevocationList(resp.Body, url)
+}
+
+// Len returns the number of revoked identities
+func (l *RevocationList) Len() int {
+	return len(l.users) + len(l.clients)
+}
+
+// Revoked reports whether a client's ClientID or username is revoked
+func (l *RevocationList) Revoked(clientID, username string) bool {
+	return l.clients[clientID] || (username != "" && l.users[username])
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Defaults for SQLOptions
+const (
+	defaultSQLCacheTTL     = time.Minute
+	defaultSQLQueryTimeout = 5 * time.Second
+)
+
+// SQLOptions configures SQLCredentials. Queries take the username as their
+// only argument, with the driver's placeholder ($1 for PostgreSQL, ? for
+// MySQL); password hashes use the password file formats ($7$ or $6$) and
+// access is one of read, write, readwrite or deny.
+type SQLOptions struct {
+	PasswordQuery string        // one row: the password hash of a user
+	ACLQuery      string        // rows of (access, topic filter) of a user; empty = no ACL
+	UsersQuery    string        // bulk refresh: rows of (username, password hash) of all users
+	RulesQuery    string        // bulk refresh: rows of (username, access, topic filter) of all users; needs ACLQuery
+	CacheTTL      time.Duration // how long looked up users are reused (default 1m)
+	QueryTimeout  time.Duration // per query (default 5s)
+}
+
+// SQLCredentials checks passwords and topic access against users kept in
+// an SQL database, such as an existing device provisioning database. Users
+// are looked up on first use and cached for CacheTTL, unknown users
+// included; Refresh reloads all users at once. While the database is
+// unreachable cached users keep their last known credentials.
+type SQLCredentials struct {
+	db   *sql.DB
+	opts SQLOptions
+
+	mu    sync.Mutex
+	users map[string]*sqlUser
+}
+
+// sqlUser is a cached user
+type sqlUser struct {
+	hash    *passwordHash // nil if the user does not exist
+	acl     *ACL
+	expires time.Time
+}
+
+// NewSQLCredentials returns credentials backed by db
+func NewSQLCredentials(db *sql.DB, opts SQLOptions) (*SQLCredentials, error) {
+	if opts.PasswordQuery == "" {
+		return nil, fmt.Errorf("SQL auth needs a password query")
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = defaultSQLCacheTTL
+	}
+	if opts.QueryTimeout <= 0 {
+		opts.QueryTimeout = defaultSQLQueryTimeout
+	}
+	return &SQLCredentials{db: db, opts: opts, users: make(map[string]*sqlUser)}, nil
+}
+
+// HasACL reports whether topic access is read from the database
+func (c *SQLCredentials) HasACL() bool {
+	return c.opts.ACLQuery != ""
+}
+
+// Len returns the number of cached users
+func (c *SQLCredentials) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.users)
+}
+
+// Check reports whether password is correct for username
+func (c *SQLCredentials) Check(username string, password []byte) bool {
+	u := c.user(username)
+	if u == nil || u.hash == nil {
+		dummyHash.matches(password)
+		return false
+	}
+	return u.hash.matches(password)
+}
+
+// Allowed reports whether a user's rules grant access to a topic
+func (c *SQLCredentials) Allowed(clientID, username, topic string, access Access) bool {
+	u := c.user(username)
+	return u != nil && u.acl.Allowed(clientID, username, topic, access)
+}
+
+// CanSubscribe reports whether a user's rules allow subscribing to a topic
+// filter, as ACL.CanSubscribe
+func (c *SQLCredentials) CanSubscribe(clientID, username, filter string) bool {
+	u := c.user(username)
+	return u != nil && u.acl.CanSubscribe(clientID, username, filter)
+}
+
+// user returns a cached user, looking it up if it is missing or expired.
+// It returns nil for clients without a username and when the lookup of an
+// uncached user fails.
+func (c *SQLCredentials) user(username string) *sqlUser {
+	if username == "" {
+		return nil
+	}
+	c.mu.Lock()
+	cached := c.users[username]
+	c.mu.Unlock()
+	if cached != nil && time.Now().Before(cached.expires) {
+		return cached
+	}
+
+	u, err := c.lookup(username)
+	if err != nil {
+		log.Printf("SQL auth lookup of user %q failed: %v", username, err)
+		return cached
+	}
+	c.mu.Lock()
+	c.users[username] = u
+	c.mu.Unlock()
+	return u
+}
+
+// lookup reads a user's password hash and rules
+func (c *SQLCredentials) lookup(username string) (*sqlUser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.QueryTimeout)
+	defer cancel()
+
+	u := &sqlUser{acl: newUserACL(), expires: time.Now().Add(c.opts.CacheTTL)}
+	var encoded string
+	err := c.db.QueryRowContext(ctx, c.opts.PasswordQuery, username).Scan(&encoded)
+	switch {
+	case err == sql.ErrNoRows:
+		return u, nil
+	case err != nil:
+		return nil, fmt.Errorf("password query: %w", err)
+	}
+	h, err := parseHash(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %w", username, err)
+	}
+	u.hash = &h
+
+	if c.opts.ACLQuery == "" {
+		return u, nil
+	}
+	rows, err := c.db.QueryContext(ctx, c.opts.ACLQuery, username)
+	if err != nil {
+		return nil, fmt.Errorf("ACL query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var access, topic string
+		if err := rows.Scan(&access, &topic); err != nil {
+			return nil, fmt.Errorf("ACL query: %w", err)
+		}
+		if err := u.acl.add(username, access, topic); err != nil {
+			return nil, fmt.Errorf("user %s: %w", username, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ACL query: %w", err)
+	}
+	return u, nil
+}
+
+// Refresh replaces the cache with all users and rules read by the bulk
+// queries. Users missing from the result are looked up again on next use.
+func (c *SQLCredentials) Refresh(ctx context.Context) error {
+	if c.opts.UsersQuery == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.QueryTimeout)
+	defer cancel()
+
+	expires := time.Now().Add(c.opts.CacheTTL)
+	users := make(map[string]*sqlUser)
+	rows, err := c.db.QueryContext(ctx, c.opts.UsersQuery)
+	if err != nil {
+		return fmt.Errorf("users query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username, encoded string
+		if err := rows.Scan(&username, &encoded); err != nil {
+			return fmt.Errorf("users query: %w", err)
+		}
+		h, err := parseHash(encoded)
+		if err != nil {
+			return fmt.Errorf("user %s: %w", username, err)
+		}
+		users[username] = &sqlUser{hash: &h, acl: newUserACL(), expires: expires}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("users query: %w", err)
+	}
+
+	if c.opts.RulesQuery != "" {
+		rules, err := c.db.QueryContext(ctx, c.opts.RulesQuery)
+		if err != nil {
+			return fmt.Errorf("rules query: %w", err)
+		}
+		defer rules.Close()
+		for rules.Next() {
+			var username, access, topic string
+			if err := rules.Scan(&username, &access, &topic); err != nil {
+				return fmt.Errorf("rules query: %w", err)
+			}
+			if u := users[username]; u != nil {
+				if err := u.acl.add(username, access, topic); err != nil {
+					return fmt.Errorf("user %s: %w", username, err)
+				}
+			}
+		}
+		if err := rules.Err(); err != nil {
+			return fmt.Errorf("rules query: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	c.users = users
+	c.mu.Unlock()
+	return nil
+}
+
+// Close closes the database
+func (c *SQLCredentials) Close() error {
+	return c.db.Close()
+}
+
+// newUserACL returns an empty ACL for a single user's rules
+func newUserACL() *ACL {
+	return &ACL{users: make(map[string][]aclRule)}
+}
+
+// add appends a rule read from the database
+func (a *ACL) add(username, access, topic string) error {
+	if _, known := accessNames[access]; !known {
+		return fmt.Errorf("unknown access %q (expected read, write, readwrite or deny)", access)
+	}
+	rule, err := parseACLRule(access + " " + topic)
+	if err != nil {
+		return err
+	}
+	a.users[username] = append(a.users[username], rule)
+	return nil
+}
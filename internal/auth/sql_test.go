@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubDB is a database answering the queries of SQLOptions, named by the
+// constants below, from maps
+type stubDB struct {
+	mu      sync.Mutex
+	down    bool
+	queries int
+	hashes  map[string]string   // username -> password hash
+	rules   map[string][]string // username -> "access topic" rules
+}
+
+// Queries understood by stubDB
+const (
+	stubPasswordQuery = "password"
+	stubACLQuery      = "acl"
+	stubUsersQuery    = "users"
+	stubRulesQuery    = "rules"
+)
+
+var (
+	stubsMu sync.Mutex
+	stubs   = make(map[string]*stubDB) // DSN -> database
+)
+
+func init() {
+	sql.Register("authstub", stubDriver{})
+}
+
+// newStubCredentials returns credentials backed by a new stub database
+func newStubCredentials(t *testing.T, opts SQLOptions) (*SQLCredentials, *stubDB) {
+	t.Helper()
+	stub := &stubDB{hashes: make(map[string]string), rules: make(map[string][]string)}
+	stubsMu.Lock()
+	stubs[t.Name()] = stub
+	stubsMu.Unlock()
+
+	db, err := sql.Open("authstub", t.Name())
+	if err != nil {
+		t.Fatalf("Failed to open stub database: %v", err)
+	}
+	creds, err := NewSQLCredentials(db, opts)
+	if err != nil {
+		t.Fatalf("Failed to create SQL credentials: %v", err)
+	}
+	t.Cleanup(func() { creds.Close() })
+	return creds, stub
+}
+
+// addUser stores a user with its password and rules
+func (db *stubDB) addUser(t *testing.T, username, password string, rules ...string) {
+	t.Helper()
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.hashes[username] = hash
+	db.rules[username] = rules
+}
+
+// setDown makes every query fail, as if the database were unreachable
+func (db *stubDB) setDown(down bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.down = down
+}
+
+// queryCount returns the number of queries answered so far
+func (db *stubDB) queryCount() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.queries
+}
+
+func (db *stubDB) query(query string, args []driver.Value) (driver.Rows, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.down {
+		return nil, errors.New("connection refused")
+	}
+	db.queries++
+	rows := &stubRows{}
+	switch query {
+	case stubPasswordQuery:
+		rows.columns = []string{"password_hash"}
+		if hash, ok := db.hashes[args[0].(string)]; ok {
+			rows.values = append(rows.values, []driver.Value{hash})
+		}
+	case stubACLQuery:
+		rows.columns = []string{"access", "topic"}
+		for _, rule := range db.rules[args[0].(string)] {
+			access, topic, _ := strings.Cut(rule, " ")
+			rows.values = append(rows.values, []driver.Value{access, topic})
+		}
+	case stubUsersQuery:
+		rows.columns = []string{"username", "password_hash"}
+		for username, hash := range db.hashes {
+			rows.values = append(rows.values, []driver.Value{username, hash})
+		}
+	case stubRulesQuery:
+		rows.columns = []string{"username", "access", "topic"}
+		for username, rules := range db.rules {
+			for _, rule := range rules {
+				access, topic, _ := strings.Cut(rule, " ")
+				rows.values = append(rows.values, []driver.Value{username, access, topic})
+			}
+		}
+	default:
+		return nil, errors.New("unknown query " + query)
+	}
+	return rows, nil
+}
+
+type stubDriver struct{}
+
+func (stubDriver) Open(name string) (driver.Conn, error) {
+	stubsMu.Lock()
+	defer stubsMu.Unlock()
+	db, ok := stubs[name]
+	if !ok {
+		return nil, errors.New("unknown stub database " + name)
+	}
+	return &stubConn{db: db}, nil
+}
+
+type stubConn struct{ db *stubDB }
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return &stubStmt{db: c.db, query: query}, nil
+}
+
+func (c *stubConn) Close() error { return nil }
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type stubStmt struct {
+	db    *stubDB
+	query string
+}
+
+func (s *stubStmt) Close() error  { return nil }
+func (s *stubStmt) NumInput() int { return -1 }
+
+func (s *stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("statements are not supported")
+}
+
+func (s *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.db.query(s.query, args)
+}
+
+type stubRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// TestSQLCredentialsLookup tests passwords and rules looked up per user
+func TestSQLCredentialsLookup(t *testing.T) {
+	creds, db := newStubCredentials(t, SQLOptions{PasswordQuery: stubPasswordQuery, ACLQuery: stubACLQuery})
+	db.addUser(t, "sensor", "s3cret", "write devices/%c/#", "read config/#")
+
+	if !creds.Check("sensor", []byte("s3cret")) {
+		t.Error("Expected the password to be accepted")
+	}
+	if creds.Check("sensor", []byte("wrong")) {
+		t.Error("Expected a wrong password to be refused")
+	}
+	if creds.Check("", []byte("s3cret")) {
+		t.Error("Expected a client without username to be refused")
+	}
+	if !creds.Allowed("dev1", "sensor", "devices/dev1/temp", Write) {
+		t.Error("Expected write access to the client's own topics")
+	}
+	if creds.Allowed("dev1", "sensor", "devices/dev2/temp", Write) {
+		t.Error("Expected no write access to another client's topics")
+	}
+	if creds.Allowed("dev1", "sensor", "config/dev1", Write) || !creds.Allowed("dev1", "sensor", "config/dev1", Read) {
+		t.Error("Expected read-only access to config topics")
+	}
+	if !creds.CanSubscribe("dev1", "sensor", "config/#") || creds.CanSubscribe("dev1", "sensor", "status/#") {
+		t.Error("Expected subscriptions limited to the readable filters")
+	}
+}
+
+// TestSQLCredentialsCache tests that looked up users, unknown ones
+// included, are reused until the cache TTL passes
+func TestSQLCredentialsCache(t *testing.T) {
+	creds, db := newStubCredentials(t, SQLOptions{PasswordQuery: stubPasswordQuery, CacheTTL: 100 * time.Millisecond})
+	db.addUser(t, "sensor", "s3cret")
+
+	creds.Check("sensor", []byte("s3cret"))
+	creds.Check("sensor", []byte("s3cret"))
+	creds.Check("ghost", []byte("anything"))
+	creds.Check("ghost", []byte("anything"))
+	if n := db.queryCount(); n != 2 {
+		t.Fatalf("Expected one query per user, got %d", n)
+	}
+
+	// An unknown user is cached as unknown until the TTL passes
+	db.addUser(t, "ghost", "boo")
+	if creds.Check("ghost", []byte("boo")) {
+		t.Fatal("Expected the cached unknown user to be refused")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if !creds.Check("ghost", []byte("boo")) {
+		t.Fatal("Expected the user to be looked up again after the TTL")
+	}
+	if n := db.queryCount(); n != 3 {
+		t.Fatalf("Expected a second query after the TTL, got %d queries", n)
+	}
+}
+
+// TestSQLCredentialsDatabaseDown tests that cached users keep their last
+// known credentials while the database is unreachable, and uncached users
+// are refused
+func TestSQLCredentialsDatabaseDown(t *testing.T) {
+	creds, db := newStubCredentials(t, SQLOptions{PasswordQuery: stubPasswordQuery, CacheTTL: 50 * time.Millisecond})
+	db.addUser(t, "sensor", "s3cret")
+	db.addUser(t, "other", "pass")
+	if !creds.Check("sensor", []byte("s3cret")) {
+		t.Fatal("Expected the password to be accepted")
+	}
+
+	db.setDown(true)
+	time.Sleep(100 * time.Millisecond)
+	if !creds.Check("sensor", []byte("s3cret")) {
+		t.Error("Expected the expired cached user to keep working while the database is down")
+	}
+	if creds.Check("other", []byte("pass")) {
+		t.Error("Expected an uncached user to be refused while the database is down")
+	}
+}
+
+// TestSQLCredentialsRefresh tests that a bulk refresh replaces the cache
+// with all users and rules, and a failed one keeps it
+func TestSQLCredentialsRefresh(t *testing.T) {
+	creds, db := newStubCredentials(t, SQLOptions{
+		PasswordQuery: stubPasswordQuery,
+		ACLQuery:      stubACLQuery,
+		UsersQuery:    stubUsersQuery,
+		RulesQuery:    stubRulesQuery,
+	})
+	db.addUser(t, "sensor", "s3cret", "write devices/#")
+	db.addUser(t, "display", "pass", "read devices/#")
+
+	if err := creds.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if n := creds.Len(); n != 2 {
+		t.Fatalf("Expected 2 cached users, got %d", n)
+	}
+	queries := db.queryCount()
+	if !creds.Check("display", []byte("pass")) || !creds.Allowed("c", "display", "devices/x", Read) || creds.Allowed("c", "display", "devices/x", Write) {
+		t.Error("Expected the refreshed user and rules")
+	}
+	if n := db.queryCount(); n != queries {
+		t.Errorf("Expected refreshed users to be served from the cache, got %d more queries", n-queries)
+	}
+
+	db.setDown(true)
+	if err := creds.Refresh(context.Background()); err == nil {
+		t.Fatal("Expected the refresh to fail while the database is down")
+	}
+	if n := creds.Len(); n != 2 || !creds.Check("sensor", []byte("s3cret")) {
+		t.Error("Expected a failed refresh to keep the cached users")
+	}
+}
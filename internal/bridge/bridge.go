@@ -0,0 +1,271 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// Bridge connects the local broker to an upstream broker and forwards
+// messages matching its topic mappings in either direction
+type Bridge struct {
+	cfg    config.BridgeConfig
+	broker *server.Server
+	client upstream
+	origin string     // Origin tag of messages injected by this bridge
+	loops  *loopGuard // shared with the other bridges of the broker
+	store  store.Store
+
+	flushMu sync.Mutex // serializes flushes of the buffer
+
+	mu            sync.Mutex // guards the fields below and orders upstream publishes
+	online        bool       // upstream connected and buffer flushed
+	buffered      int        // messages buffered in the store
+	bufferedBytes int64
+	bufferFull    bool // buffer limit warning already logged
+}
+
+// newBridge creates a bridge for the given configuration. The upstream
+// connection is not opened until Start is called.
+func newBridge(cfg config.BridgeConfig, broker *server.Server, st store.Store, loops *loopGuard) (*Bridge, error) {
+	b := &Bridge{
+		cfg:    cfg,
+		broker: broker,
+		origin: "bridge:" + cfg.Name,
+		loops:  loops,
+		store:  st,
+	}
+
+	var tlsConfig *tls.Config
+	if strings.HasPrefix(cfg.Address, "ssl://") {
+		var err error
+		if tlsConfig, err = newTLSConfig(cfg.TLS); err != nil {
+			return nil, fmt.Errorf("bridge %s: %w", cfg.Name, err)
+		}
+	}
+
+	b.client = newUpstream(cfg, tlsConfig, b.onConnect, func(err error) {
+		b.mu.Lock()
+		b.online = false
+		b.mu.Unlock()
+		log.Printf("Bridge %s: connection to %s lost: %v", cfg.Name, cfg.Address, err)
+	})
+	return b, nil
+}
+
+// newTLSConfig builds the client-side TLS configuration for an upstream
+// connection, including the client certificate if one is configured
+func newTLSConfig(cfg config.BridgeTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.SessionCacheSize > 0 {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(cfg.SessionCacheSize)
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Beyond a valid chain, the upstream must be one of the allowed peers
+	if len(cfg.AllowedPeers) > 0 {
+		allowed := cfg.AllowedPeers
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("upstream presented no certificate")
+			}
+			if !peerAllowed(cs.PeerCertificates[0], allowed) {
+				return fmt.Errorf("upstream certificate %q is not an allowed peer", cs.PeerCertificates[0].Subject.CommonName)
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// peerAllowed reports whether a certificate's common name or one of its DNS
+// names is in the allowed list
+func peerAllowed(cert *x509.Certificate, allowed []string) bool {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, name := range names {
+		for _, peer := range allowed {
+			if name != "" && strings.EqualFold(name, peer) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Start connects to the upstream broker and begins forwarding. Connection
+// failures are retried in the background.
+func (b *Bridge) Start() {
+	b.loadBuffer()
+	b.broker.AddPublishHook(b.forwardOut)
+	b.client.Connect()
+	log.Printf("Bridge %s: connecting to %s as %s", b.cfg.Name, b.cfg.Address, b.cfg.ClientID)
+}
+
+// Stop disconnects from the upstream broker
+func (b *Bridge) Stop() {
+	b.mu.Lock()
+	b.online = false
+	b.mu.Unlock()
+	b.client.Disconnect()
+}
+
+// onConnect flushes messages buffered during the outage and (re)establishes
+// the upstream subscriptions of inbound topics
+func (b *Bridge) onConnect() {
+	log.Printf("Bridge %s: connected to %s", b.cfg.Name, b.cfg.Address)
+
+	// New messages are buffered behind the flushed ones so upstream order
+	// is preserved
+	b.flush()
+
+	for _, t := range b.cfg.Topics {
+		if t.Direction == "out" {
+			continue
+		}
+		mapping := t
+		filter := mapping.RemotePrefix + mapping.Filter
+		token := b.client.Subscribe(filter, mapping.QoS, func(msg inbound) {
+			b.forwardIn(mapping, msg)
+		})
+		go func() {
+			if token.Wait() && token.Error() != nil {
+				log.Printf("Bridge %s: failed to subscribe to %s: %v", b.cfg.Name, filter, token.Error())
+			}
+		}()
+	}
+}
+
+// forwardOut publishes matching local messages to the upstream broker
+func (b *Bridge) forwardOut(msg *server.Message) {
+	if msg.Origin == b.origin {
+		return // Don't echo messages this bridge brought in
+	}
+	if b.localOnly(msg.Topic) {
+		return
+	}
+
+	for _, t := range b.cfg.Topics {
+		if t.Direction == "in" || !server.TopicMatch(t.LocalPrefix+t.Filter, msg.Topic) {
+			continue
+		}
+		if !b.allowed(msg.Topic) {
+			metrics.BridgeMessagesDenied.WithLabelValues(b.cfg.Name, "out").Inc()
+			return
+		}
+		hops, ok := b.hops(msg)
+		if !ok {
+			metrics.BridgeLoopsDetected.WithLabelValues(b.cfg.Name).Inc()
+			log.Printf("Bridge %s: dropping looped message on %s (%d bridge hops)", b.cfg.Name, msg.Topic, hops)
+			return
+		}
+
+		topic := t.RemotePrefix + strings.TrimPrefix(msg.Topic, t.LocalPrefix)
+		b.mu.Lock()
+		if b.online {
+			b.client.Publish(topic, t.QoS, msg.Retain, msg.Payload, hops)
+		} else {
+			b.buffer(&store.Message{Topic: topic, Payload: msg.Payload, QoS: t.QoS, Retain: msg.Retain, Hops: hops})
+		}
+		b.mu.Unlock()
+		return
+	}
+}
+
+// hops returns how many bridge hops a message will have made once forwarded
+// upstream, and whether that is within max_hops. A message carries its hop
+// count when it came in over an MQTT 5 link; otherwise, or when the
+// upstream cannot carry the count on, forwards of an identical message
+// within loop_window count as hops.
+func (b *Bridge) hops(msg *server.Message) (int, bool) {
+	hops, marked := hopCount(msg.Properties)
+	hops++
+	if !marked || b.cfg.ProtocolVersion != mqtt.ProtocolV5 {
+		hops = max(hops, b.loops.forward(msg.Topic, msg.Payload, b.cfg.LoopWindow))
+	}
+	return hops, hops <= b.cfg.MaxHops
+}
+
+// forwardIn injects an upstream message into the local broker. Over an MQTT
+// 5 link it carries the hop count, including the hop from the upstream.
+func (b *Bridge) forwardIn(t config.BridgeTopicConfig, msg inbound) {
+	topic := t.LocalPrefix + strings.TrimPrefix(msg.topic, t.RemotePrefix)
+	if b.localOnly(topic) {
+		log.Printf("Bridge %s: ignoring upstream message on local-only topic %s", b.cfg.Name, topic)
+		return
+	}
+	if !b.allowed(topic) {
+		metrics.BridgeMessagesDenied.WithLabelValues(b.cfg.Name, "in").Inc()
+		log.Printf("Bridge %s: ignoring upstream message on %s, not in allowed_topics", b.cfg.Name, topic)
+		return
+	}
+	var props mqtt.Properties
+	if b.cfg.ProtocolVersion == mqtt.ProtocolV5 {
+		props = mqtt.Properties{hopsProperty(msg.hops + 1)}
+	}
+	b.broker.Publish(&server.Message{
+		Topic:      topic,
+		Payload:    msg.payload,
+		QoS:        t.QoS,
+		Retain:     msg.retain,
+		Origin:     b.origin,
+		Properties: props,
+	})
+}
+
+// allowed reports whether a local topic is in the topic spaces this link
+// may replicate
+func (b *Bridge) allowed(topic string) bool {
+	if len(b.cfg.AllowedTopics) == 0 {
+		return true
+	}
+	for _, filter := range b.cfg.AllowedTopics {
+		if server.TopicMatch(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// localOnly reports whether a local topic must never cross the bridge
+func (b *Bridge) localOnly(topic string) bool {
+	for _, filter := range b.cfg.LocalOnly {
+		if server.TopicMatch(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package bridge
+
+import (
+	"log"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// flushBatchSize is how many buffered messages are read from the store per
+// transaction when flushing
+const flushBatchSize = 100
+
+// flushTimeout bounds how long a flush waits for the upstream to accept a
+// buffered message
+const flushTimeout = 10 * time.Second
+
+// queueID is the store queue holding a bridge's buffered messages
+func (b *Bridge) queueID() string {
+	return "$bridge/" + b.cfg.Name
+}
+
+// buffer persists an outbound message while the upstream broker is
+// unreachable. Must be called with b.mu held.
+func (b *Bridge) buffer(msg *store.Message) {
+	if b.store == nil || !b.cfg.Buffer.Enabled {
+		metrics.BridgeMessagesDropped.WithLabelValues(b.cfg.Name).Inc()
+		return
+	}
+
+	size := bufferedSize(msg)
+	if (b.cfg.Buffer.MaxMessages > 0 && b.buffered >= b.cfg.Buffer.MaxMessages) ||
+		(b.cfg.Buffer.MaxBytes > 0 && b.bufferedBytes+size > b.cfg.Buffer.MaxBytes) {
+		metrics.BridgeMessagesDropped.WithLabelValues(b.cfg.Name).Inc()
+		if !b.bufferFull {
+			log.Printf("Bridge %s: buffer full (%d messages, %d bytes), dropping new messages until upstream returns",
+				b.cfg.Name, b.buffered, b.bufferedBytes)
+			b.bufferFull = true
+		}
+		return
+	}
+
+	ctx, cancel := store.OpContext(b.broker.Config().Storage.OpTimeout)
+	defer cancel()
+	if err := b.store.EnqueueMessage(ctx, b.queueID(), msg); err != nil {
+		metrics.BridgeMessagesDropped.WithLabelValues(b.cfg.Name).Inc()
+		log.Printf("Bridge %s: failed to buffer message on %s: %v", b.cfg.Name, msg.Topic, err)
+		return
+	}
+	b.buffered++
+	b.bufferedBytes += size
+	metrics.BridgeMessagesBuffered.WithLabelValues(b.cfg.Name).Set(float64(b.buffered))
+}
+
+// flush publishes all buffered messages upstream in the order they were
+// buffered, then takes the bridge online. A batch is only removed from the
+// store once the upstream has accepted it, and b.mu is only held for the
+// final check that nothing was buffered behind the flush, so forwardOut
+// keeps buffering meanwhile. If the upstream fails to accept a message the
+// bridge stays offline and the rest is flushed on the next connection.
+func (b *Bridge) flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	if b.store == nil || !b.cfg.Buffer.Enabled {
+		b.mu.Lock()
+		b.online = b.client.IsConnectionOpen()
+		b.mu.Unlock()
+		return
+	}
+
+	flushed := 0
+	defer func() {
+		if flushed > 0 {
+			log.Printf("Bridge %s: flushed %d buffered messages upstream", b.cfg.Name, flushed)
+		}
+	}()
+
+	for {
+		ctx, cancel := store.OpContext(b.broker.Config().Storage.OpTimeout)
+		messages, err := b.store.PeekQueue(ctx, b.queueID(), 0, flushBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("Bridge %s: failed to read buffered messages: %v", b.cfg.Name, err)
+			break
+		}
+		if len(messages) == 0 {
+			if b.goOnline() {
+				return
+			}
+			continue
+		}
+
+		tokens := make([]paho.Token, len(messages))
+		for i, msg := range messages {
+			tokens[i] = b.client.Publish(msg.Topic, msg.QoS, msg.Retain, msg.Payload, max(msg.Hops, 1))
+		}
+		accepted := 0
+		for _, token := range tokens {
+			if !token.WaitTimeout(flushTimeout) || token.Error() != nil {
+				break
+			}
+			accepted++
+		}
+		if accepted > 0 && !b.unbuffer(accepted) {
+			break
+		}
+		flushed += accepted
+		if accepted < len(messages) {
+			log.Printf("Bridge %s: upstream did not accept a buffered message, flushing the rest on the next connection",
+				b.cfg.Name)
+			return
+		}
+	}
+
+	// The store failed: forward live rather than buffer behind messages
+	// that cannot be flushed
+	b.mu.Lock()
+	b.online = b.client.IsConnectionOpen()
+	b.mu.Unlock()
+}
+
+// goOnline takes the bridge online unless messages were buffered since the
+// queue was last read, and reports whether it did
+func (b *Bridge) goOnline() bool {
+	ctx, cancel := store.OpContext(b.broker.Config().Storage.OpTimeout)
+	defer cancel()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n, err := b.store.QueueLen(ctx, b.queueID()); err == nil && n > 0 {
+		return false
+	}
+	b.online = b.client.IsConnectionOpen()
+	b.buffered = 0
+	b.bufferedBytes = 0
+	b.bufferFull = false
+	metrics.BridgeMessagesBuffered.WithLabelValues(b.cfg.Name).Set(0)
+	return true
+}
+
+// unbuffer removes the n oldest buffered messages once they were accepted
+// upstream, and reports whether it succeeded
+func (b *Bridge) unbuffer(n int) bool {
+	ctx, cancel := store.OpContext(b.broker.Config().Storage.OpTimeout)
+	removed, err := b.store.DequeueBatch(ctx, b.queueID(), n)
+	cancel()
+	if err != nil {
+		log.Printf("Bridge %s: failed to remove flushed messages, they will be sent again: %v", b.cfg.Name, err)
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, msg := range removed {
+		b.buffered--
+		b.bufferedBytes -= bufferedSize(msg)
+	}
+	metrics.BridgeMessagesBuffered.WithLabelValues(b.cfg.Name).Set(float64(b.buffered))
+	return true
+}
+
+// loadBuffer counts the messages a previous run left buffered, so the
+// buffer limits hold across restarts
+func (b *Bridge) loadBuffer() {
+	if b.store == nil || !b.cfg.Buffer.Enabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for offset := 0; ; offset += flushBatchSize {
+		ctx, cancel := store.OpContext(b.broker.Config().Storage.OpTimeout)
+		messages, err := b.store.PeekQueue(ctx, b.queueID(), offset, flushBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("Bridge %s: failed to read buffered messages: %v", b.cfg.Name, err)
+			return
+		}
+		for _, msg := range messages {
+			b.buffered++
+			b.bufferedBytes += bufferedSize(msg)
+		}
+		if len(messages) < flushBatchSize {
+			break
+		}
+	}
+	if b.buffered > 0 {
+		log.Printf("Bridge %s: %d messages buffered by a previous run", b.cfg.Name, b.buffered)
+	}
+	metrics.BridgeMessagesBuffered.WithLabelValues(b.cfg.Name).Set(float64(b.buffered))
+}
+
+// bufferedSize is the size of a buffered message counted against max_bytes
+func bufferedSize(msg *store.Message) int64 {
+	return int64(len(msg.Topic) + len(msg.Payload))
+}
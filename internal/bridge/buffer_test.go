@@ -0,0 +1,154 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+	"github.com/ZindGH/MQTT-Server/pkg/mqtttest"
+)
+
+// testBridgeConfig returns a bridge forwarding out/# upstream to address
+// with a buffer of up to three messages
+func testBridgeConfig(address string) config.BridgeConfig {
+	return config.BridgeConfig{
+		Name:            "test",
+		Address:         address,
+		ClientID:        "bridge-test",
+		ProtocolVersion: 4,
+		KeepAlive:       60 * time.Second,
+		ConnectTimeout:  time.Second,
+		ReconnectDelay:  time.Second,
+		LoopWindow:      time.Second,
+		MaxHops:         3,
+		Topics:          []config.BridgeTopicConfig{{Filter: "out/#", Direction: "out", QoS: 1}},
+		Buffer:          config.BridgeBufferConfig{Enabled: true, MaxMessages: 3},
+	}
+}
+
+// newTestBridge creates a bridge of the local test broker
+func newTestBridge(t *testing.T, local *mqtttest.Broker, address string) *Bridge {
+	t.Helper()
+	b, err := newBridge(testBridgeConfig(address), local.Server, local.Store, newLoopGuard())
+	if err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	return b
+}
+
+// queueLen returns how many messages the bridge has buffered in the store
+func queueLen(t *testing.T, b *Bridge) int {
+	t.Helper()
+	n, err := b.store.QueueLen(context.Background(), b.queueID())
+	if err != nil {
+		t.Fatalf("Failed to read the buffer: %v", err)
+	}
+	return n
+}
+
+// waitFor polls cond until it holds, failing the test if it does not
+// within 5s
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestBufferRestart tests that a bridge counts the messages a previous run
+// buffered against its limits
+func TestBufferRestart(t *testing.T) {
+	local := mqtttest.Start(t)
+	first := newTestBridge(t, local, "tcp://127.0.0.1:1")
+	for i := range 3 {
+		first.forwardOut(&server.Message{Topic: fmt.Sprintf("out/%d", i), Payload: []byte("data")})
+	}
+
+	second := newTestBridge(t, local, "tcp://127.0.0.1:1")
+	second.loadBuffer()
+	if second.buffered != 3 || second.bufferedBytes != 3*int64(len("out/0data")) {
+		t.Fatalf("Expected 3 messages of 27 bytes buffered, got %d of %d bytes", second.buffered, second.bufferedBytes)
+	}
+	second.forwardOut(&server.Message{Topic: "out/3", Payload: []byte("data")})
+	if n := queueLen(t, second); n != 3 {
+		t.Errorf("Expected the full buffer to drop new messages, got %d buffered", n)
+	}
+}
+
+// TestFlushUnaccepted tests that messages the upstream did not accept stay
+// buffered and the bridge stays offline
+func TestFlushUnaccepted(t *testing.T) {
+	local := mqtttest.Start(t)
+	b := newTestBridge(t, local, "tcp://127.0.0.1:1")
+	b.forwardOut(&server.Message{Topic: "out/a", Payload: []byte("data")})
+	b.forwardOut(&server.Message{Topic: "out/b", Payload: []byte("data")})
+
+	b.flush()
+	if n := queueLen(t, b); n != 2 || b.buffered != 2 {
+		t.Errorf("Expected both messages to stay buffered, got %d in the store and %d counted", n, b.buffered)
+	}
+	if b.online {
+		t.Error("Expected the bridge to stay offline")
+	}
+}
+
+// TestFlushOrder tests that buffered messages reach the upstream in order
+// before the messages forwarded once the bridge is online, and are then
+// removed from the store
+func TestFlushOrder(t *testing.T) {
+	upstream := mqtttest.Start(t)
+	received := make(chan string, 10)
+	opts := paho.NewClientOptions()
+	opts.AddBroker(upstream.URL())
+	opts.SetClientID("upstream-watcher")
+	watcher := paho.NewClient(opts)
+	if token := watcher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to connect: %v", token.Error())
+	}
+	defer watcher.Disconnect(250)
+	token := watcher.Subscribe("out/#", 1, func(_ paho.Client, msg paho.Message) {
+		received <- msg.Topic()
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to subscribe: %v", token.Error())
+	}
+
+	local := mqtttest.Start(t)
+	offline := newTestBridge(t, local, "tcp://127.0.0.1:1")
+	for _, topic := range []string{"out/1", "out/2", "out/3"} {
+		offline.forwardOut(&server.Message{Topic: topic, Payload: []byte("data")})
+	}
+
+	b := newTestBridge(t, local, upstream.URL())
+	b.Start()
+	defer b.Stop()
+	waitFor(t, "the bridge to go online", func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.online
+	})
+	local.Server.Publish(&server.Message{Topic: "out/4", Payload: []byte("data")})
+
+	for _, want := range []string{"out/1", "out/2", "out/3", "out/4"} {
+		select {
+		case topic := <-received:
+			if topic != want {
+				t.Fatalf("Expected %s upstream, got %s", want, topic)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for %s upstream", want)
+		}
+	}
+	if n := queueLen(t, b); n != 0 || b.buffered != 0 {
+		t.Errorf("Expected the flushed messages to be removed, got %d in the store and %d counted", n, b.buffered)
+	}
+}
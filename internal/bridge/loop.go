@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// hopsKey is the MQTT 5 user property counting the bridge hops a message
+// has made. Bridges with MQTT 5 upstreams send it upstream and keep it on
+// the messages they bring in, so local MQTT 5 clients see it too.
+const hopsKey = "bridge-hops"
+
+// hopsProperty is the hop count user property of a message
+func hopsProperty(hops int) mqtt.Property {
+	return mqtt.UserProperty(hopsKey, strconv.Itoa(hops))
+}
+
+// hopCount returns the hop count a message carries, if any
+func hopCount(props mqtt.Properties) (int, bool) {
+	for _, prop := range props {
+		if key, value, ok := prop.UserProperty(); ok && key == hopsKey {
+			hops, err := strconv.Atoi(value)
+			if err != nil || hops < 0 {
+				return 0, false
+			}
+			return hops, true
+		}
+	}
+	return 0, false
+}
+
+// loopGuard counts how often the same message is forwarded upstream by any
+// bridge of the broker. It is the fallback for messages without a hop count,
+// which came over MQTT 3.1.1 links or were published by clients: a message
+// is identified by its local topic and payload, and one that keeps coming
+// back and being forwarded again within a short window is circulating
+// between misconfigured bridges.
+type loopGuard struct {
+	mu        sync.Mutex
+	seen      map[uint64]*forwardCount // fingerprint -> forwards in window
+	lastSweep time.Time
+}
+
+type forwardCount struct {
+	hops   int
+	expiry time.Time
+}
+
+func newLoopGuard() *loopGuard {
+	return &loopGuard{
+		seen:      make(map[uint64]*forwardCount),
+		lastSweep: time.Now(),
+	}
+}
+
+// fingerprint identifies a message by topic and payload
+func fingerprint(topic string, payload []byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(topic))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return h.Sum64()
+}
+
+// forward records that a message is about to be forwarded upstream and
+// returns how many times it has been forwarded within the window, including
+// this time
+func (g *loopGuard) forward(topic string, payload []byte, window time.Duration) int {
+	now := time.Now()
+	fp := fingerprint(topic, payload)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Drop expired fingerprints once per window
+	if now.Sub(g.lastSweep) > window {
+		for k, fc := range g.seen {
+			if now.After(fc.expiry) {
+				delete(g.seen, k)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	fc, ok := g.seen[fp]
+	if !ok || now.After(fc.expiry) {
+		fc = &forwardCount{expiry: now.Add(window)}
+		g.seen[fp] = fc
+	}
+	fc.hops++
+	return fc.hops
+}
@@ -0,0 +1,126 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+	"github.com/ZindGH/MQTT-Server/pkg/mqtttest"
+)
+
+// hopMessage publishes a local message carrying a hop count
+func hopMessage(topic string, hops int) *server.Message {
+	return &server.Message{Topic: topic, Payload: []byte("data"), Properties: mqtt.Properties{hopsProperty(hops)}}
+}
+
+// TestHopCount tests that an MQTT 5 bridge carries the hop count in both
+// directions and drops messages past max_hops, while messages without a
+// count fall back to the fingerprint of repeats
+func TestHopCount(t *testing.T) {
+	upstream := mqtttest.Start(t)
+	local := mqtttest.Start(t)
+
+	received := make(chan inbound, 20)
+	connected := make(chan struct{}, 1)
+	watcher := newV5Client(config.BridgeConfig{
+		Name:           "watcher",
+		Address:        upstream.URL(),
+		ClientID:       "hop-watcher",
+		KeepAlive:      60 * time.Second,
+		ConnectTimeout: time.Second,
+		ReconnectDelay: time.Second,
+		CleanSession:   true,
+	}, nil, func() { connected <- struct{}{} }, func(error) {})
+	watcher.Connect()
+	defer watcher.Disconnect()
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the watcher to connect")
+	}
+	if token := watcher.Subscribe("out/#", 1, func(msg inbound) { received <- msg }); token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to subscribe: %v", token.Error())
+	}
+
+	injected := make(chan *server.Message, 10)
+	local.Server.AddPublishHook(func(msg *server.Message) {
+		if strings.HasPrefix(msg.Topic, "in/") {
+			injected <- msg
+		}
+	})
+
+	cfg := testBridgeConfig(upstream.URL())
+	cfg.ProtocolVersion = mqtt.ProtocolV5
+	cfg.Topics = []config.BridgeTopicConfig{
+		{Filter: "out/#", Direction: "out", QoS: 2},
+		{Filter: "in/#", Direction: "in", QoS: 2},
+	}
+	b, err := newBridge(cfg, local.Server, local.Store, newLoopGuard())
+	if err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	b.Start()
+	defer b.Stop()
+	waitFor(t, "the bridge to subscribe upstream", func() bool {
+		state, err := upstream.Server.SessionState(cfg.ClientID)
+		return err == nil && state.Subscriptions["in/#"] == 2
+	})
+
+	expect := func(topic string, hops int) {
+		t.Helper()
+		select {
+		case msg := <-received:
+			if msg.topic != topic || msg.hops != hops {
+				t.Fatalf("Expected %s with %d hops upstream, got %s with %d", topic, hops, msg.topic, msg.hops)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for %s upstream", topic)
+		}
+	}
+
+	local.Server.Publish(&server.Message{Topic: "out/plain", Payload: []byte("data")})
+	expect("out/plain", 1)
+	local.Server.Publish(hopMessage("out/bridged", 2))
+	expect("out/bridged", 3)
+	local.Server.Publish(hopMessage("out/looped", 3))
+	local.Server.Publish(hopMessage("out/after", 0))
+	expect("out/after", 1)
+	t.Log("✓ Hop count carried upstream and messages past max_hops dropped")
+
+	// Repeats are only counted for messages without a hop count
+	for range 5 {
+		local.Server.Publish(hopMessage("out/repeat", 1))
+	}
+	for range 5 {
+		expect("out/repeat", 2)
+	}
+	for range 4 {
+		local.Server.Publish(&server.Message{Topic: "out/unmarked", Payload: []byte("data")})
+	}
+	local.Server.Publish(hopMessage("out/sentinel", 0))
+	for hops := 1; hops <= 3; hops++ {
+		expect("out/unmarked", hops)
+	}
+	expect("out/sentinel", 1)
+	t.Log("✓ Repeats counted as hops only without a hop count")
+
+	upstream.Server.Publish(hopMessage("in/bridged", 2))
+	upstream.Server.Publish(&server.Message{Topic: "in/plain", Payload: []byte("data")})
+	for _, want := range []struct {
+		topic string
+		hops  int
+	}{{"in/bridged", 3}, {"in/plain", 1}} {
+		select {
+		case msg := <-injected:
+			if hops, ok := hopCount(msg.Properties); msg.Topic != want.topic || !ok || hops != want.hops {
+				t.Fatalf("Expected %s with %d hops injected, got %s with %d", want.topic, want.hops, msg.Topic, hops)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for %s to be injected", want.topic)
+		}
+	}
+	t.Log("✓ Hop count of inbound messages includes the hop from the upstream")
+}
@@ -0,0 +1,41 @@
+package bridge
+
+import (
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// Manager owns all bridges of a broker and the state they share
+type Manager struct {
+	bridges []*Bridge
+	loops   *loopGuard
+}
+
+// NewManager creates bridges for all configured upstream brokers. Messages
+// for an unreachable upstream are buffered in st if the bridge enables it.
+func NewManager(cfgs []config.BridgeConfig, broker *server.Server, st store.Store) (*Manager, error) {
+	m := &Manager{loops: newLoopGuard()}
+	for _, cfg := range cfgs {
+		b, err := newBridge(cfg, broker, st, m.loops)
+		if err != nil {
+			return nil, err
+		}
+		m.bridges = append(m.bridges, b)
+	}
+	return m, nil
+}
+
+// Start connects all bridges
+func (m *Manager) Start() {
+	for _, b := range m.bridges {
+		b.Start()
+	}
+}
+
+// Stop disconnects all bridges
+func (m *Manager) Stop() {
+	for _, b := range m.bridges {
+		b.Stop()
+	}
+}
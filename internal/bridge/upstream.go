@@ -0,0 +1,92 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"errors"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// errNotConnected fails publishes and subscriptions made while the upstream
+// is disconnected
+var errNotConnected = errors.New("not connected to upstream")
+
+// upstream is a bridge's client connection to its upstream broker. Tokens
+// complete when the upstream has acknowledged the packet.
+type upstream interface {
+	Connect()
+	Disconnect()
+	IsConnectionOpen() bool
+	// Publish sends a message that has made hops bridge hops once it
+	// arrives upstream. Upstreams before MQTT 5 cannot carry the count.
+	Publish(topic string, qos byte, retain bool, payload []byte, hops int) paho.Token
+	Subscribe(filter string, qos byte, handler func(inbound)) paho.Token
+}
+
+// inbound is a message received from the upstream broker
+type inbound struct {
+	topic   string
+	payload []byte
+	retain  bool
+	hops    int // bridge hops made before reaching the upstream, 0 if unknown
+}
+
+// newUpstream creates the client for the configured protocol version.
+// onConnect runs on its own goroutine after every (re)connect, onLost when
+// an established connection drops.
+func newUpstream(cfg config.BridgeConfig, tlsConfig *tls.Config, onConnect func(), onLost func(error)) upstream {
+	if cfg.ProtocolVersion == 5 {
+		return newV5Client(cfg, tlsConfig, onConnect, onLost)
+	}
+
+	opts := paho.NewClientOptions()
+	opts.AddBroker(cfg.Address)
+	opts.SetClientID(cfg.ClientID)
+	opts.SetProtocolVersion(uint(cfg.ProtocolVersion))
+	opts.SetCleanSession(cfg.CleanSession)
+	opts.SetKeepAlive(cfg.KeepAlive)
+	opts.SetConnectTimeout(cfg.ConnectTimeout)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(cfg.ReconnectDelay)
+	opts.SetMaxReconnectInterval(cfg.ReconnectDelay)
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetOnConnectHandler(func(paho.Client) { onConnect() })
+	opts.SetConnectionLostHandler(func(_ paho.Client, err error) { onLost(err) })
+	return &pahoClient{client: paho.NewClient(opts)}
+}
+
+// pahoClient is the upstream connection of MQTT 3.1 and 3.1.1 bridges
+type pahoClient struct {
+	client paho.Client
+}
+
+func (c *pahoClient) Connect() {
+	c.client.Connect()
+}
+
+func (c *pahoClient) Disconnect() {
+	c.client.Disconnect(250)
+}
+
+func (c *pahoClient) IsConnectionOpen() bool {
+	return c.client.IsConnectionOpen()
+}
+
+func (c *pahoClient) Publish(topic string, qos byte, retain bool, payload []byte, _ int) paho.Token {
+	return c.client.Publish(topic, qos, retain, payload)
+}
+
+func (c *pahoClient) Subscribe(filter string, qos byte, handler func(inbound)) paho.Token {
+	return c.client.Subscribe(filter, qos, func(_ paho.Client, msg paho.Message) {
+		handler(inbound{topic: msg.Topic(), payload: msg.Payload(), retain: msg.Retained()})
+	})
+}
@@ -0,0 +1,543 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// errConnectionLost fails the publishes and subscriptions still awaiting an
+// acknowledgement when the connection drops
+var errConnectionLost = errors.New("connection to upstream lost")
+
+// v5Client is the upstream connection of MQTT 5 bridges. It implements the
+// part of the protocol a bridge needs, so the hop count of bridged messages
+// can travel as a user property. It reconnects after reconnect_delay until
+// Disconnect is called. Unlike paho, it does not resend publishes that were
+// in flight when the connection dropped: their tokens fail instead.
+type v5Client struct {
+	cfg       config.BridgeConfig
+	tlsConfig *tls.Config // nil for tcp:// addresses
+	onConnect func()
+	onLost    func(error)
+	stop      chan struct{} // closed by Disconnect
+	stopOnce  sync.Once
+
+	mu         sync.Mutex // guards the fields below and serializes writes
+	room       *sync.Cond // signalled when an acknowledgement frees a packet ID
+	conn       net.Conn   // nil while disconnected
+	receiveMax int        // publishes the upstream accepts unacknowledged
+	maxQoS     byte
+	nextID     uint16
+	pending    map[uint16]*v5Token      // packets awaiting their acknowledgement
+	handlers   map[string]func(inbound) // by subscribed topic filter
+}
+
+func newV5Client(cfg config.BridgeConfig, tlsConfig *tls.Config, onConnect func(), onLost func(error)) *v5Client {
+	c := &v5Client{
+		cfg:       cfg,
+		tlsConfig: tlsConfig,
+		onConnect: onConnect,
+		onLost:    onLost,
+		stop:      make(chan struct{}),
+		pending:   make(map[uint16]*v5Token),
+		handlers:  make(map[string]func(inbound)),
+	}
+	c.room = sync.NewCond(&c.mu)
+	return c
+}
+
+// v5Token completes when the upstream acknowledges a packet
+type v5Token struct {
+	done chan struct{}
+	err  error
+}
+
+func newV5Token() *v5Token {
+	return &v5Token{done: make(chan struct{})}
+}
+
+// complete ends the token's flow with err, nil on success
+func (t *v5Token) complete(err error) *v5Token {
+	t.err = err
+	close(t.done)
+	return t
+}
+
+func (t *v5Token) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *v5Token) WaitTimeout(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (t *v5Token) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *v5Token) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// Connect starts connecting in the background
+func (c *v5Client) Connect() {
+	go c.run()
+}
+
+// Disconnect ends the connection and stops reconnecting
+func (c *v5Client) Disconnect() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.writeLocked(encodePacket(byte(mqtt.DISCONNECT)<<4, []byte{mqtt.ReasonSuccess}))
+		c.conn.Close()
+	}
+}
+
+func (c *v5Client) IsConnectionOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Publish sends a message carrying its hop count. Publishes beyond the
+// upstream's receive maximum wait for an earlier one to be acknowledged.
+func (c *v5Client) Publish(topic string, qos byte, retain bool, payload []byte, hops int) paho.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	qos = min(qos, c.maxQoS)
+	for qos > 0 && c.conn != nil && len(c.pending) >= c.receiveMax {
+		c.room.Wait()
+	}
+	if c.conn == nil {
+		return newV5Token().complete(errNotConnected)
+	}
+
+	first := byte(mqtt.PUBLISH)<<4 | qos<<1
+	if retain {
+		first |= 0x01
+	}
+	body := mqtt.WriteString(topic)
+	token := newV5Token()
+	var packetID uint16
+	if qos > 0 {
+		packetID = c.packetID()
+		c.pending[packetID] = token
+		body = binary.BigEndian.AppendUint16(body, packetID)
+	}
+	body = append(body, mqtt.Properties{hopsProperty(hops)}.Encode()...)
+	body = append(body, payload...)
+	if err := c.writeLocked(encodePacket(first, body)); err != nil {
+		delete(c.pending, packetID)
+		return token.complete(err)
+	}
+	if qos == 0 {
+		token.complete(nil)
+	}
+	return token
+}
+
+// Subscribe subscribes to a topic filter, handing matching messages to
+// handler. The upstream does not send back the bridge's own publishes.
+func (c *v5Client) Subscribe(filter string, qos byte, handler func(inbound)) paho.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[filter] = handler
+	if c.conn == nil {
+		return newV5Token().complete(errNotConnected)
+	}
+
+	token := newV5Token()
+	packetID := c.packetID()
+	c.pending[packetID] = token
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	body = append(body, 0) // no properties
+	body = append(body, mqtt.WriteString(filter)...)
+	body = append(body, min(qos, c.maxQoS)|0x04|0x08) // No Local, Retain As Published
+	if err := c.writeLocked(encodePacket(byte(mqtt.SUBSCRIBE)<<4|0x02, body)); err != nil {
+		delete(c.pending, packetID)
+		return token.complete(err)
+	}
+	return token
+}
+
+// run connects and serves connections until Disconnect is called
+func (c *v5Client) run() {
+	for {
+		conn, r, err := c.dial()
+		if err != nil {
+			log.Printf("Bridge %s: failed to connect to %s: %v", c.cfg.Name, c.cfg.Address, err)
+		} else {
+			err = c.serve(conn, r)
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
+			c.onLost(err)
+		}
+
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(c.cfg.ReconnectDelay):
+		}
+	}
+}
+
+// dial opens a connection and completes the CONNECT handshake
+func (c *v5Client) dial() (net.Conn, *bufio.Reader, error) {
+	dialer := &net.Dialer{Timeout: c.cfg.ConnectTimeout}
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", strings.TrimPrefix(c.cfg.Address, "ssl://"), c.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", strings.TrimPrefix(c.cfg.Address, "tcp://"))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(c.cfg.ConnectTimeout))
+	r := bufio.NewReader(conn)
+	props, err := c.handshake(conn, r)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	c.receiveMax = math.MaxUint16
+	if v, ok := props.Get(mqtt.PropReceiveMaximum); ok && len(v) == 2 {
+		c.receiveMax = int(binary.BigEndian.Uint16(v))
+	}
+	c.maxQoS = 2
+	if v, ok := props.Get(mqtt.PropMaximumQoS); ok && len(v) == 1 {
+		c.maxQoS = v[0]
+	}
+	c.mu.Unlock()
+	return conn, r, nil
+}
+
+// handshake sends CONNECT and returns the properties of the upstream's
+// CONNACK if it accepted the connection
+func (c *v5Client) handshake(conn net.Conn, r *bufio.Reader) (mqtt.Properties, error) {
+	var flags byte
+	var props mqtt.Properties
+	if c.cfg.CleanSession {
+		flags |= 0x02
+	} else {
+		// Keep the upstream session across reconnects, as clean_session
+		// false does before MQTT 5
+		props = append(props, mqtt.Uint32Property(mqtt.PropSessionExpiryInterval, math.MaxUint32))
+	}
+	payload := mqtt.WriteString(c.cfg.ClientID)
+	if c.cfg.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqtt.WriteString(c.cfg.Username)...)
+		if c.cfg.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqtt.WriteString(c.cfg.Password)...)
+		}
+	}
+	body := append(mqtt.WriteString(mqtt.ProtocolNameV311), mqtt.ProtocolV5, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(c.cfg.KeepAlive/time.Second))
+	body = append(body, props.Encode()...)
+	body = append(body, payload...)
+	if _, err := conn.Write(encodePacket(byte(mqtt.CONNECT)<<4, body)); err != nil {
+		return nil, err
+	}
+
+	header, err := mqtt.ReadFixedHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if header.PacketType != mqtt.CONNACK || header.RemainingLen < 2 {
+		return nil, fmt.Errorf("expected CONNACK, got %s", header.PacketType)
+	}
+	connack := make([]byte, header.RemainingLen)
+	if _, err := io.ReadFull(r, connack); err != nil {
+		return nil, err
+	}
+	if connack[1] != mqtt.ReasonSuccess {
+		return nil, fmt.Errorf("upstream refused the connection with reason %#x", connack[1])
+	}
+	if len(connack) == 2 {
+		return nil, nil
+	}
+	props, _, err = mqtt.ReadProperties(bytes.NewReader(connack[2:]))
+	return props, err
+}
+
+// serve reads packets from an established connection until it ends, then
+// fails everything awaiting an acknowledgement
+func (c *v5Client) serve(conn net.Conn, r *bufio.Reader) error {
+	c.mu.Lock()
+	select {
+	case <-c.stop:
+		c.mu.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	c.conn = conn
+	c.mu.Unlock()
+	go c.onConnect()
+
+	done := make(chan struct{})
+	go c.keepAlive(done)
+	err := c.readLoop(conn, r)
+	close(done)
+
+	c.mu.Lock()
+	c.conn = nil
+	pending := c.pending
+	c.pending = make(map[uint16]*v5Token)
+	c.room.Broadcast()
+	c.mu.Unlock()
+	conn.Close()
+	for _, token := range pending {
+		token.complete(errConnectionLost)
+	}
+	return err
+}
+
+// keepAlive pings the upstream once per keep-alive interval until done is
+// closed
+func (c *v5Client) keepAlive(done <-chan struct{}) {
+	if c.cfg.KeepAlive <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.cfg.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			c.writeLocked([]byte{byte(mqtt.PINGREQ) << 4, 0})
+			c.mu.Unlock()
+		}
+	}
+}
+
+// readLoop handles the packets the upstream sends. A connection silent for
+// one and a half keep-alive intervals, which a PINGRESP would have broken,
+// is dead.
+func (c *v5Client) readLoop(conn net.Conn, r *bufio.Reader) error {
+	for {
+		if c.cfg.KeepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(c.cfg.KeepAlive * 3 / 2))
+		}
+		header, err := mqtt.ReadFixedHeader(r)
+		if err != nil {
+			return err
+		}
+
+		switch header.PacketType {
+		case mqtt.PUBLISH:
+			pub, err := mqtt.DecodePublishPacket(r, header, mqtt.ProtocolV5)
+			if err != nil {
+				return err
+			}
+			c.receive(pub)
+		case mqtt.PUBACK:
+			ack, err := mqtt.DecodePubackPacket(r, header.RemainingLen)
+			if err != nil {
+				return err
+			}
+			c.acknowledge(ack.PacketID, ack.ReasonCode)
+		case mqtt.PUBREC:
+			rec, err := mqtt.DecodePubrecPacket(r, header.RemainingLen)
+			if err != nil {
+				return err
+			}
+			c.released(rec.PacketID, rec.ReasonCode)
+		case mqtt.PUBCOMP:
+			comp, err := mqtt.DecodePubcompPacket(r, header.RemainingLen)
+			if err != nil {
+				return err
+			}
+			c.acknowledge(comp.PacketID, comp.ReasonCode)
+		case mqtt.PUBREL:
+			rel, err := mqtt.DecodePubrelPacket(r, header)
+			if err != nil {
+				return err
+			}
+			pkt, _ := (&mqtt.PubcompPacket{PacketID: rel.PacketID}).Encode()
+			c.write(pkt)
+		case mqtt.SUBACK:
+			packetID, reason, err := decodeSuback(r, header.RemainingLen)
+			if err != nil {
+				return err
+			}
+			c.acknowledge(packetID, reason)
+		case mqtt.DISCONNECT:
+			pkt, err := mqtt.DecodeDisconnectPacket(r, header.RemainingLen, mqtt.ProtocolV5)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("upstream disconnected with reason %#x", pkt.ReasonCode)
+		default:
+			if _, err := r.Discard(header.RemainingLen); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// receive hands a message to the handlers of the filters it matches, then
+// acknowledges it
+func (c *v5Client) receive(pub *mqtt.PublishPacket) {
+	msg := inbound{topic: pub.Topic, payload: pub.Payload, retain: pub.Retain}
+	msg.hops, _ = hopCount(pub.Properties)
+
+	c.mu.Lock()
+	var handlers []func(inbound)
+	for filter, handler := range c.handlers {
+		if server.TopicMatch(filter, pub.Topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.mu.Unlock()
+	for _, handler := range handlers {
+		handler(msg)
+	}
+
+	var ack []byte
+	switch pub.QoS {
+	case 1:
+		ack, _ = (&mqtt.PubackPacket{PacketID: pub.PacketID}).Encode()
+	case 2:
+		ack, _ = (&mqtt.PubrecPacket{PacketID: pub.PacketID}).Encode()
+	default:
+		return
+	}
+	c.write(ack)
+}
+
+// released answers the PUBREC of a QoS 2 publish with PUBREL, or fails the
+// publish if the upstream refused it
+func (c *v5Client) released(packetID uint16, reason byte) {
+	if reason >= mqtt.ReasonUnspecifiedError {
+		c.acknowledge(packetID, reason)
+		return
+	}
+	pkt, _ := (&mqtt.PubrelPacket{PacketID: packetID}).Encode()
+	c.write(pkt)
+}
+
+// acknowledge completes the token of a packet ID, failing it if the reason
+// code is an error
+func (c *v5Client) acknowledge(packetID uint16, reason byte) {
+	c.mu.Lock()
+	token, ok := c.pending[packetID]
+	delete(c.pending, packetID)
+	c.room.Signal()
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	if reason >= mqtt.ReasonUnspecifiedError {
+		token.complete(fmt.Errorf("upstream refused with reason %#x", reason))
+		return
+	}
+	token.complete(nil)
+}
+
+// packetID returns a packet ID not awaiting an acknowledgement. Must be
+// called with c.mu held.
+func (c *v5Client) packetID() uint16 {
+	for {
+		c.nextID++
+		if _, used := c.pending[c.nextID]; c.nextID != 0 && !used {
+			return c.nextID
+		}
+	}
+}
+
+// write sends an encoded packet
+func (c *v5Client) write(pkt []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeLocked(pkt)
+}
+
+// writeLocked sends an encoded packet, closing the connection if the write
+// fails so the read loop ends it. Must be called with c.mu held.
+func (c *v5Client) writeLocked(pkt []byte) error {
+	if c.conn == nil {
+		return errNotConnected
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.cfg.ConnectTimeout))
+	if _, err := c.conn.Write(pkt); err != nil {
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// decodeSuback reads a SUBACK and returns its packet ID and the first
+// failing reason code, or success
+func decodeSuback(r io.Reader, remainingLen int) (uint16, byte, error) {
+	if remainingLen < 2 {
+		return 0, 0, fmt.Errorf("malformed SUBACK: %w", io.ErrUnexpectedEOF)
+	}
+	body := make([]byte, remainingLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, err
+	}
+	rest := bytes.NewReader(body[2:])
+	if _, _, err := mqtt.ReadProperties(rest); err != nil {
+		return 0, 0, fmt.Errorf("malformed SUBACK properties: %w", err)
+	}
+	for {
+		reason, err := rest.ReadByte()
+		if err != nil {
+			return binary.BigEndian.Uint16(body), mqtt.ReasonSuccess, nil
+		}
+		if reason >= mqtt.ReasonUnspecifiedError {
+			return binary.BigEndian.Uint16(body), reason, nil
+		}
+	}
+}
+
+// encodePacket prefixes a packet body with its fixed header
+func encodePacket(first byte, body []byte) []byte {
+	pkt := append([]byte{first}, mqtt.EncodeVarInt(len(body))...)
+	return append(pkt, body...)
+}
@@ -0,0 +1,140 @@
+// Package clock abstracts the passing of time for the broker's timers, so
+// tests can replace the wall clock with a virtual one and fast-forward
+// keepalives, delays and expiries deterministically instead of sleeping.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs functions after a duration
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d, like time.AfterFunc.
+	// Virtual clocks call it from Advance instead.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending AfterFunc call. Stop and Reset behave like those of
+// time.Timer.
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// Virtual is a clock that only moves when told to. Timers fire during
+// Advance, on the calling goroutine, in the order they are due.
+type Virtual struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    uint64 // orders timers due at the same time by when they were set
+	timers map[*virtualTimer]struct{}
+}
+
+// virtualTimer is a pending call on a virtual clock
+type virtualTimer struct {
+	clock *Virtual
+	when  time.Time
+	seq   uint64
+	f     func()
+}
+
+// NewVirtual creates a virtual clock set to start
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start, timers: make(map[*virtualTimer]struct{})}
+}
+
+// Now returns the virtual time
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// AfterFunc calls f once Advance has moved the clock by d
+func (v *Virtual) AfterFunc(d time.Duration, f func()) Timer {
+	t := &virtualTimer{clock: v, f: f}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.schedule(t, d)
+	return t
+}
+
+// schedule (re)arms a timer. Must be called with mu held.
+func (v *Virtual) schedule(t *virtualTimer, d time.Duration) {
+	v.seq++
+	t.when, t.seq = v.now.Add(d), v.seq
+	v.timers[t] = struct{}{}
+}
+
+// Advance moves the clock forward by d, firing the timers that become due.
+// Timers set by the fired functions fire too if they are due before the
+// new time.
+func (v *Virtual) Advance(d time.Duration) {
+	v.mu.Lock()
+	end := v.now.Add(d)
+	for {
+		t := v.next(end)
+		if t == nil {
+			break
+		}
+		delete(v.timers, t)
+		if t.when.After(v.now) {
+			v.now = t.when
+		}
+		v.mu.Unlock()
+		t.f()
+		v.mu.Lock()
+	}
+	v.now = end
+	v.mu.Unlock()
+}
+
+// next returns the earliest timer due at or before end, or nil. Must be
+// called with mu held.
+func (v *Virtual) next(end time.Time) *virtualTimer {
+	var first *virtualTimer
+	for t := range v.timers {
+		if t.when.After(end) {
+			continue
+		}
+		if first == nil || t.when.Before(first.when) || t.when.Equal(first.when) && t.seq < first.seq {
+			first = t
+		}
+	}
+	return first
+}
+
+// Pending returns the number of timers waiting to fire. Tests use it to
+// wait until the broker has armed a timer before advancing the clock.
+func (v *Virtual) Pending() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.timers)
+}
+
+func (t *virtualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	_, pending := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return pending
+}
+
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	_, pending := t.clock.timers[t]
+	t.clock.schedule(t, d)
+	return pending
+}
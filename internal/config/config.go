@@ -2,54 +2,162 @@ package config
 
 import (
 	"fmt"
-	"os"
+	"path"
+	"slices"
+	"strings"
 	"time"
-
-	"gopkg.in/yaml.v3"
 )
 
 // Config represents the complete server configuration
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	TLS     TLSConfig     `yaml:"tls"`
-	Auth    AuthConfig    `yaml:"auth"`
-	Storage StorageConfig `yaml:"storage"`
-	Limits  LimitsConfig  `yaml:"limits"`
-	QoS     QoSConfig     `yaml:"qos"`
-	Logging LoggingConfig `yaml:"logging"`
-	Metrics MetricsConfig `yaml:"metrics"`
+	Include        []string                     `yaml:"include"`
+	Reload         ReloadConfig                 `yaml:"reload"`
+	Server         ServerConfig                 `yaml:"server"`
+	TLS            TLSConfig                    `yaml:"tls"`
+	Listeners      []ListenerConfig             `yaml:"listeners"`      // Additional listeners, also managed at runtime via the admin API
+	ListenersFile  string                       `yaml:"listeners_file"` // Where listeners changed at runtime with persist are saved
+	Auth           AuthConfig                   `yaml:"auth"`
+	Storage        StorageConfig                `yaml:"storage"`
+	Limits         LimitsConfig                 `yaml:"limits"`
+	QoS            QoSConfig                    `yaml:"qos"`
+	Logging        LoggingConfig                `yaml:"logging"`
+	Metrics        MetricsConfig                `yaml:"metrics"`
+	Admin          AdminConfig                  `yaml:"admin"`
+	Groups         []GroupConfig                `yaml:"groups"`
+	RetainHandling []RetainHandlingConfig       `yaml:"retain_handling"`
+	ReservedTopics []ReservedTopicConfig        `yaml:"reserved_topics"`
+	Mirrors        []MirrorConfig               `yaml:"mirrors"`
+	Transcoding    []TranscodingRuleConfig      `yaml:"transcoding"` // JSON <-> CBOR/MessagePack for constrained devices
+	LastValue      LastValueConfig              `yaml:"last_value"`
+	Files          FilesConfig                  `yaml:"files"`
+	UsageQuotas    UsageQuotaConfig             `yaml:"usage_quotas"`
+	UsageExport    UsageExportConfig            `yaml:"usage_export"`
+	Bridges        []BridgeConfig               `yaml:"bridges"`
+	TimeSeries     TimeSeriesConfig             `yaml:"timeseries"`
+	Analytics      AnalyticsConfig              `yaml:"analytics"`
+	StatsHistory   StatsHistoryConfig           `yaml:"stats_history"`
+	ContentTypes   ContentTypeConfig            `yaml:"content_types"`
+	SchemaRegistry SchemaRegistryConfig         `yaml:"schema_registry"`
+	SlowConsumer   SlowConsumerConfig           `yaml:"slow_consumer"`
+	Anomaly        AnomalyConfig                `yaml:"anomaly"`
+	Presence       PresenceConfig               `yaml:"presence"`
+	Maintenance    []MaintenanceWindowConfig    `yaml:"maintenance"`
+	Annotation     AnnotationConfig             `yaml:"annotation"`
+	TopicMetrics   TopicMetricsConfig           `yaml:"topic_metrics"`
+	Shaping        map[string]ShapingConfig     `yaml:"shaping"`       // Traffic shaping by listener name (tcp, tls)
+	RefusalHints   map[string]RefusalHintConfig `yaml:"refusal_hints"` // Backoff hints for refused MQTT 5 clients by refusal reason
+	Migration      MigrationConfig              `yaml:"migration"`
+}
+
+// ReloadConfig contains settings for applying configuration changes
+// without a restart
+type ReloadConfig struct {
+	Interval time.Duration `yaml:"interval"` // How often the configuration files are checked for changes (0 = disabled)
 }
 
 // ServerConfig contains server binding and network settings
 type ServerConfig struct {
 	Host                string        `yaml:"host"`                  // Network interface to bind to
-	Port                int           `yaml:"port"`                  // MQTT port (1883 standard)
-	KeepAlive           time.Duration `yaml:"keep_alive"`            // Client keep-alive timeout
+	Port                int           `yaml:"port"`                  // MQTT port (1883 standard, 0 = any free port)
+	KeepAlive           time.Duration `yaml:"keep_alive"`            // Longest keep-alive granted to MQTT 5 clients (negative = no limit)
 	WriteTimeout        time.Duration `yaml:"write_timeout"`         // Write operation timeout
 	ReadTimeout         time.Duration `yaml:"read_timeout"`          // Read operation timeout
 	CleanSessionDefault bool          `yaml:"clean_session_default"` // Default clean session behavior
+	WriteBufferSize     int           `yaml:"write_buffer_size"`     // Per-connection outgoing buffer size in bytes
+	TCP                 TCPConfig     `yaml:"tcp"`                   // Socket options applied to accepted connections
+	AcceptBackoff       time.Duration `yaml:"accept_backoff"`        // Maximum delay between retries after accept errors
+	TakeoverWill        string        `yaml:"takeover_will"`         // "publish" or "suppress" the will of a connection replaced by the same ClientID
+	RetainAsPublished   bool          `yaml:"retain_as_published"`   // Keep the publisher's RETAIN flag on deliveries to existing subscriptions
+}
+
+// TCPConfig contains socket tuning options for a listener
+type TCPConfig struct {
+	NoDelay         *bool         `yaml:"no_delay"`          // TCP_NODELAY (default true: favour latency over batching)
+	KeepAlivePeriod time.Duration `yaml:"keepalive_period"`  // SO_KEEPALIVE probe period (0 = OS default, negative = disabled)
+	ReadBufferSize  int           `yaml:"read_buffer_size"`  // SO_RCVBUF in bytes (0 = OS default)
+	WriteBufferSize int           `yaml:"write_buffer_size"` // SO_SNDBUF in bytes (0 = OS default)
 }
 
 // TLSConfig contains TLS/SSL settings
 type TLSConfig struct {
-	Enabled  bool   `yaml:"enabled"`   // Enable TLS
-	CertFile string `yaml:"cert_file"` // Server certificate path
-	KeyFile  string `yaml:"key_file"`  // Server private key path
-	CAFile   string `yaml:"ca_file"`   // CA certificate for client verification
+	Enabled      bool                `yaml:"enabled"`       // Enable TLS
+	Port         int                 `yaml:"port"`          // TLS listener port (8883 standard, 0 = any free port)
+	CertFile     string              `yaml:"cert_file"`     // Server certificate path
+	KeyFile      string              `yaml:"key_file"`      // Server private key path
+	CAFile       string              `yaml:"ca_file"`       // CA certificate for client verification
+	VirtualHosts []VirtualHostConfig `yaml:"virtual_hosts"` // Tenants selected by TLS SNI server name
+
+	SessionTickets TLSSessionConfig `yaml:"session_tickets"` // Session resumption for reconnecting clients
+}
+
+// TLSSessionConfig controls TLS session resumption, which lets reconnecting
+// clients skip the certificate exchange and key agreement of a full
+// handshake
+type TLSSessionConfig struct {
+	Disabled bool   `yaml:"disabled"` // Always do full handshakes
+	KeyFile  string `yaml:"key_file"` // Ticket keys shared by instances behind a load balancer (32 bytes in hex per line, newest first)
+}
+
+// VirtualHostConfig defines a tenant served on the TLS listener, selected by
+// the SNI server name the client connects with
+type VirtualHostConfig struct {
+	ServerName  string `yaml:"server_name"`  // SNI name, e.g. "tenant-a.mqtt.example.com"
+	CertFile    string `yaml:"cert_file"`    // Certificate for this name (default certificate if empty)
+	KeyFile     string `yaml:"key_file"`     // Private key for this name
+	Mountpoint  string `yaml:"mountpoint"`   // Topic prefix isolating the tenant, e.g. "tenant-a/"
+	MaxClients  int    `yaml:"max_clients"`  // Concurrent client limit for the tenant (0 = unlimited)
+	MaxRetained int    `yaml:"max_retained"` // Retained topics under the mountpoint (0 = unlimited)
 }
 
 // AuthConfig contains authentication settings
 type AuthConfig struct {
-	Enabled              bool   `yaml:"enabled"`                // Enable authentication
-	AllowAnonymous       bool   `yaml:"allow_anonymous"`        // Allow connections without auth
-	RequireClientCerts   bool   `yaml:"require_client_certs"`   // Require client certificates (mTLS)
-	UsernamePasswordFile string `yaml:"username_password_file"` // Path to username/password file
+	Enabled              bool             `yaml:"enabled"`                // Enable authentication
+	AllowAnonymous       bool             `yaml:"allow_anonymous"`        // Allow connections without auth
+	RequireClientCerts   bool             `yaml:"require_client_certs"`   // Require client certificates (mTLS)
+	UsernamePasswordFile string           `yaml:"username_password_file"` // Path to username/password file
+	ACLFile              string           `yaml:"acl_file"`               // Path to a mosquitto-format topic ACL file (empty = all topics allowed)
+	PolicyFile           string           `yaml:"policy_file"`            // Path to a file of per-user QoS, keepalive, rate, queue and topic policies
+	SQL                  SQLAuthConfig    `yaml:"sql"`                    // Users and ACLs read from an SQL database
+	Revocation           RevocationConfig `yaml:"revocation"`             // Revoked usernames and ClientIDs
+}
+
+// RevocationConfig points to a list of revoked identities, reloaded
+// periodically; listed clients are disconnected and refused
+type RevocationConfig struct {
+	File     string        `yaml:"file"`     // Path to the list
+	URL      string        `yaml:"url"`      // HTTP endpoint serving the list, instead of a file
+	Interval time.Duration `yaml:"interval"` // How often the list is reloaded
+}
+
+// SQLAuthConfig reads credentials and ACLs from an SQL database. Queries
+// take the username as their only argument ($1 or ?, depending on the
+// driver); the driver must be linked into the broker binary.
+type SQLAuthConfig struct {
+	Driver          string        `yaml:"driver"`           // database/sql driver name, e.g. "pgx" or "mysql" (empty = disabled)
+	DSN             string        `yaml:"dsn"`              // Data source name
+	PasswordQuery   string        `yaml:"password_query"`   // Returns the password hash ($7$ or $6$) of a user
+	ACLQuery        string        `yaml:"acl_query"`        // Returns (access, topic filter) rows of a user (empty = no ACL)
+	UsersQuery      string        `yaml:"users_query"`      // Bulk refresh: (username, password hash) rows of all users
+	RulesQuery      string        `yaml:"rules_query"`      // Bulk refresh: (username, access, topic filter) rows of all users
+	CacheTTL        time.Duration `yaml:"cache_ttl"`        // How long looked up users are reused
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // How often all users are reloaded with users_query (0 = disabled)
+	QueryTimeout    time.Duration `yaml:"query_timeout"`    // Timeout of each query
 }
 
 // StorageConfig contains persistence settings
 type StorageConfig struct {
-	Backend string `yaml:"backend"` // Storage backend: "memory", "bbolt", "redis"
-	Path    string `yaml:"path"`    // File path for file-based backends
+	Backend    string           `yaml:"backend"`    // Storage backend: "memory", "bbolt", "redis"
+	Path       string           `yaml:"path"`       // File path for file-based backends
+	Encryption EncryptionConfig `yaml:"encryption"` // Encryption at rest of sessions and stored messages
+
+	// A database file locked by another process is retried, then refused
+	LockTimeout time.Duration `yaml:"lock_timeout"` // Wait for the file lock per attempt
+	LockRetries int           `yaml:"lock_retries"` // Attempts after the first
+	LockBackoff time.Duration `yaml:"lock_backoff"` // Delay before the first retry, doubling after each
+
+	OpTimeout time.Duration `yaml:"op_timeout"` // Longest wait for each store operation
+
+	Health StoreHealthConfig `yaml:"health"` // Periodic health checks of the backend
 
 	// Redis-specific settings (for future use)
 	RedisAddr     string `yaml:"redis_addr,omitempty"`
@@ -57,12 +165,51 @@ type StorageConfig struct {
 	RedisDB       int    `yaml:"redis_db,omitempty"`
 }
 
+// StoreHealthConfig contains settings for store health checks and the
+// broker's behaviour while the store is down
+type StoreHealthConfig struct {
+	Interval time.Duration `yaml:"interval"` // Time between health checks (0 = disabled)
+	Degraded string        `yaml:"degraded"` // While down: "cache" keeps serving from memory, "reject" also refuses persistent sessions
+}
+
+// EncryptionConfig contains settings for AES-GCM encryption of stored
+// sessions and queued, retained and in-flight messages
+type EncryptionConfig struct {
+	Enabled bool                  `yaml:"enabled"` // Encrypt values in the store
+	Keys    []EncryptionKeyConfig `yaml:"keys"`    // The first key encrypts; the others decrypt data written before a rotation
+}
+
+// EncryptionKeyConfig is a base64-encoded 256-bit key, given inline, in an
+// environment variable (e.g. injected by a KMS agent) or in a file
+type EncryptionKeyConfig struct {
+	ID      string `yaml:"id"`       // Stored with each value to select the key for decryption
+	Key     string `yaml:"key"`      // Inline key (prefer key_env or key_file)
+	KeyEnv  string `yaml:"key_env"`  // Environment variable holding the key
+	KeyFile string `yaml:"key_file"` // File holding the key
+}
+
 // LimitsConfig contains connection and message limits
 type LimitsConfig struct {
 	MaxClients          int   `yaml:"max_clients"`           // Maximum concurrent connections
 	MaxMessageSize      int64 `yaml:"max_message_size"`      // Maximum message payload size in bytes
 	MaxInflightMessages int   `yaml:"max_inflight_messages"` // Maximum QoS 1/2 messages in flight per client
 	RetainedMessages    bool  `yaml:"retained_messages"`     // Enable retained message support
+	MaxMemory           int64 `yaml:"max_memory"`            // Message memory limit in bytes before overload mode (0 = unlimited)
+	MaxPreAuthBytes     int   `yaml:"max_preauth_bytes"`     // Bytes a connection may send before CONNECT is accepted
+	MaxQueuedMessages   int   `yaml:"max_queued_messages"`   // Messages queued per offline persistent session (-1 = unlimited)
+
+	MaxConnectionsPerUsername int `yaml:"max_connections_per_username"` // Concurrent connections per username (0 = unlimited)
+	MaxSubscriptionsPerClient int `yaml:"max_subscriptions_per_client"` // Topic filters a client may subscribe to (0 = unlimited)
+
+	MaxRetainedPerClient int    `yaml:"max_retained_per_client"` // Retained topics a client may own (0 = unlimited)
+	RetainedQuotaPolicy  string `yaml:"retained_quota_policy"`   // At the quota: "reject" the publish or "overwrite_oldest" retained topic
+
+	// Parser budgets close connections sending more than these rates,
+	// independently of publish rate limits (0 = unlimited)
+	MaxPreAuthPacketRate float64 `yaml:"max_preauth_packet_rate"` // Packets per second before CONNECT is accepted
+	MaxPreAuthByteRate   int64   `yaml:"max_preauth_byte_rate"`   // Bytes per second before CONNECT is accepted; a larger CONNECT is refused
+	MaxPacketRate        float64 `yaml:"max_packet_rate"`         // Packets per second once connected
+	MaxByteRate          int64   `yaml:"max_byte_rate"`           // Bytes per second once connected
 }
 
 // QoSConfig contains Quality of Service settings
@@ -76,26 +223,444 @@ type QoSConfig struct {
 type LoggingConfig struct {
 	Level  string `yaml:"level"`  // Log level: debug, info, warn, error
 	Format string `yaml:"format"` // Log format: text, json
-	Output string `yaml:"output"` // Output: stdout, stderr, or file path
+	Output string `yaml:"output"` // Output: stdout, stderr, syslog, journald, or file path
+
+	PublishSampling int               `yaml:"publish_sampling"` // Log 1 in N publishes per topic (1 = every publish)
+	Rotation        LogRotationConfig `yaml:"rotation"`         // Rotation of the log file when output is a file path
+	Syslog          SyslogConfig      `yaml:"syslog"`           // Syslog server when output is syslog
+	Journald        JournaldConfig    `yaml:"journald"`         // Journal socket when output is journald
+	Identifier      string            `yaml:"identifier"`       // Application name reported to syslog and journald
+	Fields          map[string]string `yaml:"fields"`           // Static structured fields added to syslog and journald entries
+}
+
+// SyslogConfig contains settings for logging to syslog (RFC 5424)
+type SyslogConfig struct {
+	Network  string `yaml:"network"`  // udp, tcp or unix
+	Address  string `yaml:"address"`  // host:port, or the socket path for unix
+	Facility string `yaml:"facility"` // Syslog facility, e.g. daemon or local0-local7
+}
+
+// JournaldConfig contains settings for logging to the systemd journal
+type JournaldConfig struct {
+	Socket string `yaml:"socket"` // Journal native protocol socket
+}
+
+// LogRotationConfig contains settings for rotating the log file
+type LogRotationConfig struct {
+	MaxSizeMB  int           `yaml:"max_size_mb"` // Rotate when the file would exceed this size (0 = no size limit)
+	Interval   time.Duration `yaml:"interval"`    // Rotate when the file has been written to this long (0 = never)
+	Compress   bool          `yaml:"compress"`    // Gzip rotated files
+	MaxBackups int           `yaml:"max_backups"` // Rotated files kept (0 = all)
+	MaxAge     time.Duration `yaml:"max_age"`     // Remove rotated files older than this (0 = keep)
 }
 
 // MetricsConfig contains Prometheus metrics settings
 type MetricsConfig struct {
-	Enabled bool   `yaml:"enabled"` // Enable metrics endpoint
-	Port    int    `yaml:"port"`    // Metrics HTTP server port
-	Path    string `yaml:"path"`    // Metrics endpoint path
+	Enabled     bool   `yaml:"enabled"`      // Enable metrics endpoint
+	Host        string `yaml:"host"`         // Interface the metrics endpoint binds to ("" = all)
+	Port        int    `yaml:"port"`         // Metrics HTTP server port
+	Path        string `yaml:"path"`         // Metrics endpoint path
+	CertFile    string `yaml:"cert_file"`    // Serve HTTPS with this certificate
+	KeyFile     string `yaml:"key_file"`     // Private key of the certificate
+	Username    string `yaml:"username"`     // Require HTTP basic auth with this username
+	Password    string `yaml:"password"`     // Password for basic auth
+	BearerToken string `yaml:"bearer_token"` // Accept "Authorization: Bearer <token>" instead of or in addition to basic auth
+}
+
+// AdminConfig contains admin HTTP API settings
+type AdminConfig struct {
+	Enabled bool               `yaml:"enabled"` // Enable admin API
+	Host    string             `yaml:"host"`    // Interface the admin API binds to
+	Port    int                `yaml:"port"`    // Admin HTTP server port
+	Tokens  []AdminTokenConfig `yaml:"tokens"`  // Bearer tokens with their roles (none = no authentication)
+}
+
+// AdminTokenConfig defines a bearer token for the admin API
+type AdminTokenConfig struct {
+	Name  string `yaml:"name"`  // Name logged with the actions performed using the token
+	Token string `yaml:"token"` // Secret presented as "Authorization: Bearer <token>"
+	Role  string `yaml:"role"`  // read-only, operator (disconnects, limits, traces, maintenance) or admin
+}
+
+// GroupConfig defines a named group of clients for bulk operations.
+// Patterns use shell glob syntax (e.g. "sensor-*").
+type GroupConfig struct {
+	Name         string   `yaml:"name"`          // Group name used by the admin API
+	ClientIDs    []string `yaml:"client_ids"`    // ClientID patterns selecting members
+	Usernames    []string `yaml:"usernames"`     // Username patterns selecting members
+	CommandTopic string   `yaml:"command_topic"` // Per-member command topic template (%c = ClientID, %u = username)
+	RateLimit    float64  `yaml:"rate_limit"`    // Per-member publish limit in messages/second (0 = unlimited)
+	RateBurst    int      `yaml:"rate_burst"`    // Messages allowed in a burst above the rate limit
+}
+
+// ReservedTopicConfig reserves a topic space for publishing by its owners
+// only, independently of the ACL. Patterns use shell glob syntax.
+type ReservedTopicConfig struct {
+	Filter    string   `yaml:"filter"`     // Reserved topic filter, e.g. "firmware/#"
+	ClientIDs []string `yaml:"client_ids"` // ClientID patterns of the owners
+	Usernames []string `yaml:"usernames"`  // Username patterns of the owners
+	Groups    []string `yaml:"groups"`     // Client groups whose members own the topic space
+}
+
+// MirrorConfig duplicates the messages matching a filter into a shadow
+// namespace, so new consumers can be tested against live traffic
+type MirrorConfig struct {
+	Filter string `yaml:"filter"` // Topic filter of the mirrored messages, e.g. "sensors/#"
+	Prefix string `yaml:"prefix"` // Prefix of the copies, e.g. "shadow/" mirrors sensors/1 to shadow/sensors/1
+}
+
+// TranscodingRuleConfig declares the binary format spoken by some clients
+// on a topic filter. JSON messages delivered to them are encoded in the
+// format, and their own messages are decoded to JSON before routing, so
+// other consumers keep receiving JSON.
+type TranscodingRuleConfig struct {
+	Filter    string   `yaml:"filter"`     // Topic filter, e.g. "devices/+/telemetry"
+	Format    string   `yaml:"format"`     // "cbor" or "msgpack"
+	ClientIDs []string `yaml:"client_ids"` // ClientID patterns of the clients speaking the format
+}
+
+// RetainHandlingConfig applies an MQTT 5 Retain Handling option to the
+// subscriptions of MQTT 3.1/3.1.1 clients, which cannot request one.
+// Client patterns use shell glob syntax; filters cover subscriptions with
+// the usual wildcards.
+type RetainHandlingConfig struct {
+	ClientIDs []string `yaml:"client_ids"` // ClientID patterns (empty = all clients)
+	Filters   []string `yaml:"filters"`    // Topic filters covering the subscriptions (empty = all)
+	Mode      int      `yaml:"mode"`       // 1 = send retained messages only for new subscriptions, 2 = never
+}
+
+// LastValueConfig contains settings for the per-topic last-value cache
+type LastValueConfig struct {
+	Enabled   bool `yaml:"enabled"`    // Cache the latest payload of every topic for the admin API
+	MaxTopics int  `yaml:"max_topics"` // Maximum number of cached topics (0 = unlimited)
+}
+
+// FilesConfig contains settings for distributing files, such as firmware,
+// as retained chunks
+type FilesConfig struct {
+	Enabled     bool   `yaml:"enabled"`      // Accept files through the admin API
+	TopicPrefix string `yaml:"topic_prefix"` // Files are published under <prefix><name>/
+	ChunkSize   int    `yaml:"chunk_size"`   // Default chunk size in bytes
+	MaxSize     int64  `yaml:"max_size"`     // Largest accepted file in bytes (0 = unlimited)
+}
+
+// UsageQuotaConfig limits how many messages and payload bytes each tenant
+// or user may publish per day (UTC)
+type UsageQuotaConfig struct {
+	Enabled      bool                 `yaml:"enabled"`       // Count daily usage and enforce the quotas
+	Per          string               `yaml:"per"`           // "username", or "tenant": the virtual host, by username on the default host
+	Messages     int64                `yaml:"messages"`      // Daily published messages (0 = unlimited)
+	Bytes        int64                `yaml:"bytes"`         // Daily published payload bytes (0 = unlimited)
+	Policy       string               `yaml:"policy"`        // Over quota: "reject" publishes or "throttle" them to throttle_rate
+	ThrottleRate float64              `yaml:"throttle_rate"` // Messages per second allowed over quota with the throttle policy
+	EventTopic   string               `yaml:"event_topic"`   // Topic template of quota events (%k = tenant or username, "" = none)
+	Overrides    []UsageQuotaOverride `yaml:"overrides"`     // Quotas of individual tenants or users
 }
 
-// Load reads and parses the configuration file
+// UsageQuotaOverride replaces the default quotas of a tenant or user
+type UsageQuotaOverride struct {
+	Name     string `yaml:"name"`     // Virtual host server name or username
+	Messages int64  `yaml:"messages"` // Daily published messages (0 = unlimited)
+	Bytes    int64  `yaml:"bytes"`    // Daily published payload bytes (0 = unlimited)
+}
+
+// UsageExportConfig contains settings for exporting per-tenant usage
+// summaries for billing. A summary is written at the end of every period.
+type UsageExportConfig struct {
+	Enabled   bool          `yaml:"enabled"`   // Export usage summaries
+	Interval  time.Duration `yaml:"interval"`  // Length of a summary period
+	Format    string        `yaml:"format"`    // "json" or "csv"
+	Directory string        `yaml:"directory"` // Write summaries to files in this directory
+	URL       string        `yaml:"url"`       // Upload summaries with HTTP PUT to <url>/<file name>, e.g. an object storage bucket
+	Token     string        `yaml:"token"`     // Bearer token for uploads
+	Timeout   time.Duration `yaml:"timeout"`   // Upload timeout
+}
+
+// AnalyticsConfig contains settings for topic usage analytics
+type AnalyticsConfig struct {
+	Enabled      bool          `yaml:"enabled"`        // Track topic cardinality and publisher activity
+	Interval     time.Duration `yaml:"interval"`       // How often a report is computed
+	Window       time.Duration `yaml:"window"`         // Topics not published for this long are no longer counted
+	TopN         int           `yaml:"top_n"`          // Entries in the top prefix and publisher lists
+	MaxTopics    int           `yaml:"max_topics"`     // Warn above this many distinct topics (0 = never)
+	MaxNewTopics int           `yaml:"max_new_topics"` // Warn above this many new topics per interval (0 = never)
+	MaxTracked   int           `yaml:"max_tracked"`    // Hard cap on tracked topics to bound memory
+}
+
+// StatsHistoryConfig contains settings for the broker statistics samples
+// kept in the store
+type StatsHistoryConfig struct {
+	Enabled   bool          `yaml:"enabled"`   // Sample statistics into the store (GET /api/v1/stats/history)
+	Interval  time.Duration `yaml:"interval"`  // How often a sample is taken
+	Retention time.Duration `yaml:"retention"` // Samples older than this are deleted
+}
+
+// ContentTypeConfig contains settings for tracking and enforcing the MQTT 5
+// Content Type of published payloads
+type ContentTypeConfig struct {
+	Enabled    bool                    `yaml:"enabled"`     // Count content types per topic (GET /api/v1/content-types)
+	MaxTracked int                     `yaml:"max_tracked"` // Hard cap on tracked topics to bound memory
+	Rules      []ContentTypeRuleConfig `yaml:"rules"`       // Expected content types by topic; the first matching rule applies
+}
+
+// ContentTypeRuleConfig declares the content types expected on a topic
+// filter. Content types are compared without parameters such as charset
+// and case-insensitively; patterns use shell glob syntax ("text/*").
+type ContentTypeRuleConfig struct {
+	Filter       string   `yaml:"filter"`        // Topic filter, e.g. "sensors/#"
+	ContentTypes []string `yaml:"content_types"` // Accepted content types
+	Required     bool     `yaml:"required"`      // MQTT 5 messages without a Content Type are mismatches too
+	Action       string   `yaml:"action"`        // "reject" mismatches or only "log" them
+	FillMissing  bool     `yaml:"fill_missing"`  // Give messages without a Content Type the first accepted one
+}
+
+// SchemaRegistryConfig contains settings for validating payloads against
+// schemas fetched from a schema registry
+type SchemaRegistryConfig struct {
+	Enabled     bool               `yaml:"enabled"`     // Validate messages on the rules' topics
+	Kind        string             `yaml:"kind"`        // "confluent" (Confluent Schema Registry API) or "http" (GET url with %s = subject, returning a JSON Schema)
+	URL         string             `yaml:"url"`         // Registry base URL, or URL template for "http"
+	Username    string             `yaml:"username"`    // Basic auth username
+	Password    string             `yaml:"password"`    // Basic auth password
+	Token       string             `yaml:"token"`       // Bearer token, instead of basic auth
+	Timeout     time.Duration      `yaml:"timeout"`     // Registry request timeout
+	CacheTTL    time.Duration      `yaml:"cache_ttl"`   // How long a fetched schema is used before it is fetched again
+	CacheDir    string             `yaml:"cache_dir"`   // Keep fetched schemas in this directory for registry outages across restarts ("" = memory only)
+	Unavailable string             `yaml:"unavailable"` // Messages whose schema cannot be fetched: "accept" or "reject"
+	Rules       []SchemaRuleConfig `yaml:"rules"`       // Schemas by topic; the first matching rule applies
+}
+
+// SchemaRuleConfig maps a topic filter to a registry subject
+type SchemaRuleConfig struct {
+	Filter   string `yaml:"filter"`   // Topic filter, e.g. "sensors/#"
+	Subject  string `yaml:"subject"`  // Registry subject; %t = the topic with "/" replaced by "."
+	Action   string `yaml:"action"`   // "reject" invalid messages or only "log" them
+	Annotate bool   `yaml:"annotate"` // Add schema_subject, schema_version, schema_id and schema_valid user properties
+}
+
+// SchemaRegistryKinds are the supported schema registry APIs
+var SchemaRegistryKinds = []string{"confluent", "http"}
+
+// SlowConsumerConfig contains settings for slow consumer detection
+type SlowConsumerConfig struct {
+	Enabled        bool          `yaml:"enabled"`         // Detect clients that cannot keep up with their messages
+	QueueThreshold int           `yaml:"queue_threshold"` // Outbound queue depth considered backed up
+	Duration       time.Duration `yaml:"duration"`        // How long the queue must stay above the threshold
+	CheckInterval  time.Duration `yaml:"check_interval"`  // How often client queues are checked
+}
+
+// AnomalyConfig contains settings for detecting publish rates that
+// deviate from their baseline, per client and per topic prefix
+type AnomalyConfig struct {
+	Enabled        bool          `yaml:"enabled"`         // Compare publish rates with their baselines
+	Interval       time.Duration `yaml:"interval"`        // How often rates are measured
+	Baseline       time.Duration `yaml:"baseline"`        // Time constant of the moving average rates are compared with
+	Warmup         int           `yaml:"warmup"`          // Intervals measured before a key can be flagged
+	Factor         float64       `yaml:"factor"`          // A rate this many times its baseline is anomalous
+	MinRate        float64       `yaml:"min_rate"`        // Messages per second below which a rate is never anomalous
+	TopicLevels    int           `yaml:"topic_levels"`    // Topic levels rates are tracked by (0 = clients only)
+	MaxTracked     int           `yaml:"max_tracked"`     // Hard cap on tracked clients and topic prefixes
+	Action         string        `yaml:"action"`          // On a client anomaly: "log" or "throttle" it to throttle_rate
+	ThrottleRate   float64       `yaml:"throttle_rate"`   // Messages per second allowed to a throttled client
+	WebhookURL     string        `yaml:"webhook_url"`     // POST anomaly events as JSON to this URL ("" = none)
+	WebhookTimeout time.Duration `yaml:"webhook_timeout"` // Webhook request timeout
+}
+
+// AnomalyActions are the actions taken on client anomalies
+var AnomalyActions = []string{"log", "throttle"}
+
+// PresenceConfig contains settings for client connect/disconnect
+// notifications
+type PresenceConfig struct {
+	Enabled           bool   `yaml:"enabled"`            // Publish a JSON event whenever a client connects or disconnects
+	ConnectedTopic    string `yaml:"connected_topic"`    // Topic template (%c = ClientID, %u = username)
+	DisconnectedTopic string `yaml:"disconnected_topic"` // Topic template (%c = ClientID, %u = username)
+	QoS               byte   `yaml:"qos"`                // QoS of the notifications
+	Retain            bool   `yaml:"retain"`             // Retain the latest notification per topic
+	Tracking          bool   `yaml:"tracking"`           // Keep online status and last-seen time of every client in the store
+	StatusTopic       string `yaml:"status_topic"`       // Retained per-client status topic template (requires tracking, "" = none)
+}
+
+// AnnotationConfig contains settings for broker-side message annotation
+type AnnotationConfig struct {
+	Enabled  bool     `yaml:"enabled"`   // Add receive time, publisher ClientID and listener to client messages
+	Topics   []string `yaml:"topics"`    // Topic filters of annotated messages (default: all)
+	WrapJSON bool     `yaml:"wrap_json"` // Deliver annotated messages to MQTT 3.1/3.1.1 subscribers in a JSON envelope
+}
+
+// TopicMetricsConfig contains settings for per-prefix payload size and
+// message rate histograms
+type TopicMetricsConfig struct {
+	Prefixes     []string      `yaml:"prefixes"`      // Topic prefixes with their own histograms; the longest match wins
+	RateInterval time.Duration `yaml:"rate_interval"` // Period over which each message rate sample is taken
+}
+
+// ShapingConfig degrades the traffic of a listener's connections to imitate
+// field network conditions. Meant for staging and test environments.
+type ShapingConfig struct {
+	Latency        time.Duration `yaml:"latency"`         // Delay added to data in each direction
+	Jitter         time.Duration `yaml:"jitter"`          // Random extra delay, up to this value
+	ReadBandwidth  int64         `yaml:"read_bandwidth"`  // Bytes per second received per connection (0 = unlimited)
+	WriteBandwidth int64         `yaml:"write_bandwidth"` // Bytes per second sent per connection (0 = unlimited)
+}
+
+// RefusalHintConfig tells MQTT 5 clients that were refused a connection
+// when and where to retry, through CONNACK properties
+type RefusalHintConfig struct {
+	RetryAfter      time.Duration `yaml:"retry_after"`      // First retry delay, sent as the "retry-after" user property in seconds
+	MaxRetryAfter   time.Duration `yaml:"max_retry_after"`  // Cap of the exponential backoff, sent as "retry-after-max" in seconds
+	ServerReference string        `yaml:"server_reference"` // Server to use instead, sent as Server Reference with reason Use another server
+}
+
+// MigrationConfig contains settings for moving MQTT 5 clients to other
+// nodes with a DISCONNECT carrying a Server Reference, so that clients
+// redistribute the load themselves
+type MigrationConfig struct {
+	Enabled         bool          `yaml:"enabled"`          // Migrate clients while the node is over its share
+	ServerReference string        `yaml:"server_reference"` // Where clients are sent (host[:port], space separated list); also the default for drains
+	MaxClients      int           `yaml:"max_clients"`      // Migrate clients while more than this many are connected (0 = no client threshold)
+	TargetClients   int           `yaml:"target_clients"`   // Client count migrations bring the node down to (default: 90% of max_clients)
+	OnOverload      bool          `yaml:"on_overload"`      // Also migrate clients while the broker is in overload mode
+	Policy          string        `yaml:"policy"`           // Clients migrated first: "newest", "oldest" or "random"
+	Persistent      bool          `yaml:"persistent"`       // Also migrate clients with a persistent session, which stays on this node
+	BatchSize       int           `yaml:"batch_size"`       // Most clients migrated per check
+	CheckInterval   time.Duration `yaml:"check_interval"`   // How often the load is checked
+}
+
+// MigrationPolicies are the client selection policies of migrations
+var MigrationPolicies = []string{"newest", "oldest", "random"}
+
+// RefusalReasons are the connection refusals refusal_hints apply to
+var RefusalReasons = []string{"overload", "shutting_down", "draining", "client_limit", "vhost_limit", "store_down"}
+
+// MaintenanceWindowConfig defines a planned period during which publishes
+// on some topics are muted or rejected
+type MaintenanceWindowConfig struct {
+	Name   string    `yaml:"name"`   // Window name used in logs, metrics and the admin API
+	Start  time.Time `yaml:"start"`  // RFC 3339 start time
+	End    time.Time `yaml:"end"`    // RFC 3339 end time
+	Topics []string  `yaml:"topics"` // Topic filters affected by the window
+	Action string    `yaml:"action"` // "mute" (accept, do not route) or "reject"
+}
+
+// BridgeConfig defines a connection to an upstream broker and the topics
+// forwarded over it
+type BridgeConfig struct {
+	Name            string              `yaml:"name"`             // Bridge name used in logs
+	Address         string              `yaml:"address"`          // Upstream broker URL (tcp://host:1883 or ssl://host:8883)
+	ClientID        string              `yaml:"client_id"`        // ClientID used upstream (default "bridge-<name>")
+	Username        string              `yaml:"username"`         // Upstream username
+	Password        string              `yaml:"password"`         // Upstream password
+	ProtocolVersion byte                `yaml:"protocol_version"` // 3 (MQTT 3.1), 4 (MQTT 3.1.1) or 5 (MQTT 5)
+	KeepAlive       time.Duration       `yaml:"keep_alive"`       // Upstream keep-alive interval
+	CleanSession    bool                `yaml:"clean_session"`    // Start a clean upstream session on every connect
+	ConnectTimeout  time.Duration       `yaml:"connect_timeout"`  // Upstream connect timeout
+	ReconnectDelay  time.Duration       `yaml:"reconnect_delay"`  // Maximum delay between reconnect attempts
+	LoopWindow      time.Duration       `yaml:"loop_window"`      // Window in which repeated forwards of one message count as a loop
+	MaxHops         int                 `yaml:"max_hops"`         // Bridge hops a message may make, or forwards of an identical message per loop_window without a hop count
+	TLS             BridgeTLSConfig     `yaml:"tls"`              // Upstream TLS settings for ssl:// addresses
+	Topics          []BridgeTopicConfig `yaml:"topics"`           // Topics forwarded over the bridge
+	LocalOnly       []string            `yaml:"local_only"`       // Topic filters never forwarded in either direction
+	AllowedTopics   []string            `yaml:"allowed_topics"`   // Local topic filters this link may replicate in either direction (empty = all mapped topics)
+	Buffer          BridgeBufferConfig  `yaml:"buffer"`           // Store-and-forward while the upstream is down
+}
+
+// BridgeBufferConfig contains store-and-forward settings for a bridge
+type BridgeBufferConfig struct {
+	Enabled     bool  `yaml:"enabled"`      // Buffer outbound messages in the store while disconnected
+	MaxMessages int   `yaml:"max_messages"` // Maximum buffered messages (0 = unlimited)
+	MaxBytes    int64 `yaml:"max_bytes"`    // Maximum buffered topic + payload bytes (0 = unlimited)
+}
+
+// BridgeTLSConfig contains TLS client settings for a bridge connection
+type BridgeTLSConfig struct {
+	CAFile             string   `yaml:"ca_file"`              // CA used to verify the upstream broker
+	CertFile           string   `yaml:"cert_file"`            // Client certificate presented upstream
+	KeyFile            string   `yaml:"key_file"`             // Client certificate private key
+	ServerName         string   `yaml:"server_name"`          // SNI / verification name (default: address host)
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify"` // Skip upstream certificate verification (testing only)
+	AllowedPeers       []string `yaml:"allowed_peers"`        // Names (CN or DNS SAN) the upstream certificate must carry one of (empty = any valid certificate)
+	SessionCacheSize   int      `yaml:"session_cache_size"`   // TLS sessions kept to resume upstream connections (0 = full handshakes)
+}
+
+// BridgeTopicConfig maps a topic filter across a bridge
+type BridgeTopicConfig struct {
+	Filter       string `yaml:"filter"`        // Topic filter, relative to the prefixes below
+	Direction    string `yaml:"direction"`     // "out" (local -> upstream), "in" (upstream -> local) or "both"
+	QoS          byte   `yaml:"qos"`           // QoS used on the bridge
+	LocalPrefix  string `yaml:"local_prefix"`  // Prefix added to the filter on the local broker
+	RemotePrefix string `yaml:"remote_prefix"` // Prefix added to the filter on the upstream broker
+}
+
+// TimeSeriesConfig contains settings for exporting numeric payloads to a
+// time-series database over the InfluxDB line protocol
+type TimeSeriesConfig struct {
+	Enabled       bool                   `yaml:"enabled"`        // Enable the exporter
+	URL           string                 `yaml:"url"`            // Line-protocol write endpoint
+	Token         string                 `yaml:"token"`          // API token (InfluxDB 2.x)
+	Username      string                 `yaml:"username"`       // Basic auth username (InfluxDB 1.x)
+	Password      string                 `yaml:"password"`       // Basic auth password
+	BatchSize     int                    `yaml:"batch_size"`     // Points per write request
+	FlushInterval time.Duration          `yaml:"flush_interval"` // Maximum time a point waits for its batch
+	Timeout       time.Duration          `yaml:"timeout"`        // Write request timeout
+	QueueSize     int                    `yaml:"queue_size"`     // Points buffered before new ones are dropped
+	Rules         []TimeSeriesRuleConfig `yaml:"rules"`          // Topic to measurement mappings (first match wins)
+}
+
+// TimeSeriesRuleConfig maps messages on a topic filter to a measurement.
+// Templates may reference topic levels as "{n}" (1-based).
+type TimeSeriesRuleConfig struct {
+	Filter      string            `yaml:"filter"`      // Topic filter selecting messages
+	Measurement string            `yaml:"measurement"` // Measurement name template
+	Tags        map[string]string `yaml:"tags"`        // Tag name -> value template
+}
+
+// Load reads and parses the configuration file. Unknown keys are rejected
+// so that typos do not silently fall back to defaults.
 func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+	return LoadProfile(path, "")
+}
+
+// LoadProfile reads the configuration file, merges in the files matched by
+// its include patterns and, when profile is set, the environment-specific
+// file config.<profile>.yaml next to it
+func LoadProfile(path, profile string) (*Config, error) {
+	root, err := readDocument(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
 	}
 
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	if root != nil {
+		if err := root.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	files, err := configFiles(path, cfg.Include, profile)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files[1:] {
+		doc, err := readDocument(file)
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			continue
+		}
+		if root == nil {
+			root = doc
+			continue
+		}
+		mergeNodes(root, doc)
+	}
+
+	if len(files) > 1 && root != nil {
+		cfg = Config{}
+		if err := root.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to merge config files: %w", err)
+		}
 	}
 
 	// Set defaults for any missing values
@@ -127,6 +692,24 @@ func (c *Config) setDefaults() {
 	if c.Server.ReadTimeout == 0 {
 		c.Server.ReadTimeout = 30 * time.Second
 	}
+	if c.Server.WriteBufferSize == 0 {
+		c.Server.WriteBufferSize = 4096
+	}
+	if c.Server.AcceptBackoff == 0 {
+		c.Server.AcceptBackoff = time.Second
+	}
+	if c.Server.TakeoverWill == "" {
+		c.Server.TakeoverWill = "publish"
+	}
+	if c.Server.TCP.NoDelay == nil {
+		noDelay := true
+		c.Server.TCP.NoDelay = &noDelay
+	}
+
+	// TLS defaults
+	if c.TLS.Port == 0 {
+		c.TLS.Port = 8883
+	}
 
 	// Storage defaults
 	if c.Storage.Backend == "" {
@@ -135,6 +718,42 @@ func (c *Config) setDefaults() {
 	if c.Storage.Path == "" {
 		c.Storage.Path = "./data/mqtt.db"
 	}
+	if c.Storage.LockTimeout == 0 {
+		c.Storage.LockTimeout = 5 * time.Second
+	}
+	if c.Storage.LockBackoff == 0 {
+		c.Storage.LockBackoff = time.Second
+	}
+	if c.Storage.OpTimeout == 0 {
+		c.Storage.OpTimeout = 5 * time.Second
+	}
+	if c.Storage.Health.Degraded == "" {
+		c.Storage.Health.Degraded = "cache"
+	}
+	if c.Files.TopicPrefix == "" {
+		c.Files.TopicPrefix = "files/"
+	}
+	if c.Files.ChunkSize == 0 {
+		c.Files.ChunkSize = 64 * 1024
+	}
+	if c.Auth.Revocation.Interval == 0 {
+		c.Auth.Revocation.Interval = 30 * time.Second
+	}
+	if c.UsageQuotas.Per == "" {
+		c.UsageQuotas.Per = "username"
+	}
+	if c.UsageQuotas.Policy == "" {
+		c.UsageQuotas.Policy = "reject"
+	}
+	if c.UsageQuotas.ThrottleRate == 0 {
+		c.UsageQuotas.ThrottleRate = 1
+	}
+	if c.Auth.SQL.CacheTTL == 0 {
+		c.Auth.SQL.CacheTTL = time.Minute
+	}
+	if c.Auth.SQL.QueryTimeout == 0 {
+		c.Auth.SQL.QueryTimeout = 5 * time.Second
+	}
 
 	// Limits defaults
 	if c.Limits.MaxClients == 0 {
@@ -146,6 +765,15 @@ func (c *Config) setDefaults() {
 	if c.Limits.MaxInflightMessages == 0 {
 		c.Limits.MaxInflightMessages = 100
 	}
+	if c.Limits.MaxPreAuthBytes == 0 {
+		c.Limits.MaxPreAuthBytes = 64 * 1024
+	}
+	if c.Limits.MaxQueuedMessages == 0 {
+		c.Limits.MaxQueuedMessages = 1000
+	}
+	if c.Limits.RetainedQuotaPolicy == "" {
+		c.Limits.RetainedQuotaPolicy = "reject"
+	}
 
 	// QoS defaults
 	if c.QoS.MaxQoS == 0 {
@@ -168,6 +796,28 @@ func (c *Config) setDefaults() {
 	if c.Logging.Output == "" {
 		c.Logging.Output = "stdout"
 	}
+	if c.Logging.PublishSampling == 0 {
+		c.Logging.PublishSampling = 100
+	}
+	if c.Logging.Identifier == "" {
+		c.Logging.Identifier = "mqtt-server"
+	}
+	if c.Logging.Syslog.Network == "" {
+		c.Logging.Syslog.Network = "udp"
+	}
+	if c.Logging.Syslog.Address == "" {
+		if c.Logging.Syslog.Network == "unix" {
+			c.Logging.Syslog.Address = "/dev/log"
+		} else {
+			c.Logging.Syslog.Address = "localhost:514"
+		}
+	}
+	if c.Logging.Syslog.Facility == "" {
+		c.Logging.Syslog.Facility = "daemon"
+	}
+	if c.Logging.Journald.Socket == "" {
+		c.Logging.Journald.Socket = "/run/systemd/journal/socket"
+	}
 
 	// Metrics defaults
 	if c.Metrics.Port == 0 {
@@ -176,20 +826,319 @@ func (c *Config) setDefaults() {
 	if c.Metrics.Path == "" {
 		c.Metrics.Path = "/metrics"
 	}
+
+	// Bridge defaults
+	for i := range c.Bridges {
+		b := &c.Bridges[i]
+		if b.ClientID == "" {
+			b.ClientID = "bridge-" + b.Name
+		}
+		if b.ProtocolVersion == 0 {
+			b.ProtocolVersion = 4
+		}
+		if b.KeepAlive == 0 {
+			b.KeepAlive = 60 * time.Second
+		}
+		if b.ConnectTimeout == 0 {
+			b.ConnectTimeout = 10 * time.Second
+		}
+		if b.ReconnectDelay == 0 {
+			b.ReconnectDelay = 30 * time.Second
+		}
+		if b.LoopWindow == 0 {
+			b.LoopWindow = time.Second
+		}
+		if b.MaxHops == 0 {
+			b.MaxHops = 3
+		}
+		if b.LocalOnly == nil {
+			b.LocalOnly = []string{"$SYS/#"}
+		}
+		for j := range b.Topics {
+			if b.Topics[j].Direction == "" {
+				b.Topics[j].Direction = "out"
+			}
+		}
+	}
+
+	// Time-series exporter defaults
+	if c.TimeSeries.BatchSize == 0 {
+		c.TimeSeries.BatchSize = 500
+	}
+	if c.TimeSeries.FlushInterval == 0 {
+		c.TimeSeries.FlushInterval = time.Second
+	}
+	if c.TimeSeries.Timeout == 0 {
+		c.TimeSeries.Timeout = 10 * time.Second
+	}
+	if c.TimeSeries.QueueSize == 0 {
+		c.TimeSeries.QueueSize = 10000
+	}
+
+	// Usage export defaults
+	if c.UsageExport.Interval == 0 {
+		c.UsageExport.Interval = time.Hour
+	}
+	if c.UsageExport.Format == "" {
+		c.UsageExport.Format = "json"
+	}
+	if c.UsageExport.Timeout == 0 {
+		c.UsageExport.Timeout = 30 * time.Second
+	}
+
+	// Topic metrics defaults
+	if c.TopicMetrics.RateInterval == 0 {
+		c.TopicMetrics.RateInterval = 10 * time.Second
+	}
+
+	// Analytics defaults
+	if c.Analytics.Interval == 0 {
+		c.Analytics.Interval = time.Minute
+	}
+	if c.Analytics.Window == 0 {
+		c.Analytics.Window = time.Hour
+	}
+	if c.Analytics.TopN == 0 {
+		c.Analytics.TopN = 10
+	}
+	if c.Analytics.MaxTracked == 0 {
+		c.Analytics.MaxTracked = 1000000
+	}
+
+	// Statistics history defaults
+	if c.StatsHistory.Interval == 0 {
+		c.StatsHistory.Interval = time.Minute
+	}
+	if c.StatsHistory.Retention == 0 {
+		c.StatsHistory.Retention = 7 * 24 * time.Hour
+	}
+
+	// Content type defaults
+	if c.ContentTypes.MaxTracked == 0 {
+		c.ContentTypes.MaxTracked = 10000
+	}
+	for i := range c.ContentTypes.Rules {
+		if c.ContentTypes.Rules[i].Action == "" {
+			c.ContentTypes.Rules[i].Action = "reject"
+		}
+	}
+
+	// Schema registry defaults
+	if c.SchemaRegistry.Kind == "" {
+		c.SchemaRegistry.Kind = "confluent"
+	}
+	if c.SchemaRegistry.Timeout == 0 {
+		c.SchemaRegistry.Timeout = 5 * time.Second
+	}
+	if c.SchemaRegistry.CacheTTL == 0 {
+		c.SchemaRegistry.CacheTTL = 5 * time.Minute
+	}
+	if c.SchemaRegistry.Unavailable == "" {
+		c.SchemaRegistry.Unavailable = "accept"
+	}
+	for i := range c.SchemaRegistry.Rules {
+		if c.SchemaRegistry.Rules[i].Action == "" {
+			c.SchemaRegistry.Rules[i].Action = "reject"
+		}
+	}
+
+	// Slow consumer defaults
+	if c.SlowConsumer.QueueThreshold == 0 {
+		c.SlowConsumer.QueueThreshold = 1000
+	}
+	if c.SlowConsumer.Duration == 0 {
+		c.SlowConsumer.Duration = 10 * time.Second
+	}
+	if c.SlowConsumer.CheckInterval == 0 {
+		c.SlowConsumer.CheckInterval = time.Second
+	}
+
+	// Anomaly detection defaults
+	if c.Anomaly.Interval == 0 {
+		c.Anomaly.Interval = 10 * time.Second
+	}
+	if c.Anomaly.Baseline == 0 {
+		c.Anomaly.Baseline = time.Hour
+	}
+	if c.Anomaly.Warmup == 0 {
+		c.Anomaly.Warmup = 6
+	}
+	if c.Anomaly.Factor == 0 {
+		c.Anomaly.Factor = 10
+	}
+	if c.Anomaly.MinRate == 0 {
+		c.Anomaly.MinRate = 1
+	}
+	if c.Anomaly.MaxTracked == 0 {
+		c.Anomaly.MaxTracked = 100000
+	}
+	if c.Anomaly.Action == "" {
+		c.Anomaly.Action = "log"
+	}
+	if c.Anomaly.ThrottleRate == 0 {
+		c.Anomaly.ThrottleRate = 1
+	}
+	if c.Anomaly.WebhookTimeout == 0 {
+		c.Anomaly.WebhookTimeout = 5 * time.Second
+	}
+
+	// Migration defaults
+	if c.Migration.Policy == "" {
+		c.Migration.Policy = "newest"
+	}
+	if c.Migration.TargetClients == 0 {
+		c.Migration.TargetClients = c.Migration.MaxClients * 9 / 10
+	}
+	if c.Migration.BatchSize == 0 {
+		c.Migration.BatchSize = 100
+	}
+	if c.Migration.CheckInterval == 0 {
+		c.Migration.CheckInterval = 10 * time.Second
+	}
+
+	// Presence defaults
+	if c.Presence.ConnectedTopic == "" {
+		c.Presence.ConnectedTopic = "$SYS/clients/%c/connected"
+	}
+	if c.Presence.DisconnectedTopic == "" {
+		c.Presence.DisconnectedTopic = "$SYS/clients/%c/disconnected"
+	}
+
+	// Annotation defaults
+	if len(c.Annotation.Topics) == 0 {
+		c.Annotation.Topics = []string{"#"}
+	}
+
+	// Maintenance window defaults
+	for i := range c.Maintenance {
+		if c.Maintenance[i].Action == "" {
+			c.Maintenance[i].Action = "mute"
+		}
+	}
+
+	// Admin defaults
+	if c.Admin.Host == "" {
+		c.Admin.Host = "127.0.0.1"
+	}
+	if c.Admin.Port == 0 {
+		c.Admin.Port = 8080
+	}
 }
 
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	// Validate server settings
-	if c.Server.Port < 1 || c.Server.Port > 65535 {
-		return fmt.Errorf("invalid port: %d (must be 1-65535)", c.Server.Port)
+	// Port 0 lets the system pick a free port, see Server.Addr. Loading a
+	// file turns an unset port into 1883, so only configurations built in
+	// code can ask for it.
+	if c.Server.Port < 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("invalid port: %d (must be 0-65535)", c.Server.Port)
+	}
+	if c.Server.KeepAlive > 65535*time.Second {
+		return fmt.Errorf("invalid keep_alive: %s (must be at most 65535s)", c.Server.KeepAlive)
+	}
+	if c.Server.WriteBufferSize < 0 {
+		return fmt.Errorf("invalid write_buffer_size: %d (must not be negative)", c.Server.WriteBufferSize)
+	}
+	if c.Server.TCP.ReadBufferSize < 0 || c.Server.TCP.WriteBufferSize < 0 {
+		return fmt.Errorf("invalid tcp buffer sizes: read=%d write=%d (must not be negative)",
+			c.Server.TCP.ReadBufferSize, c.Server.TCP.WriteBufferSize)
+	}
+
+	if c.Limits.MaxMemory < 0 {
+		return fmt.Errorf("invalid max_memory: %d (must not be negative)", c.Limits.MaxMemory)
+	}
+	if c.Server.TakeoverWill != "publish" && c.Server.TakeoverWill != "suppress" {
+		return fmt.Errorf("invalid takeover_will: %q (must be publish or suppress)", c.Server.TakeoverWill)
+	}
+	if c.Limits.MaxQueuedMessages < -1 {
+		return fmt.Errorf("invalid max_queued_messages: %d (must be -1 or more)", c.Limits.MaxQueuedMessages)
+	}
+	if c.Limits.MaxRetainedPerClient < 0 {
+		return fmt.Errorf("invalid max_retained_per_client: %d (must not be negative)", c.Limits.MaxRetainedPerClient)
+	}
+	if c.Limits.RetainedQuotaPolicy != "reject" && c.Limits.RetainedQuotaPolicy != "overwrite_oldest" {
+		return fmt.Errorf("invalid retained_quota_policy: %s (must be reject or overwrite_oldest)", c.Limits.RetainedQuotaPolicy)
+	}
+	if c.Limits.MaxSubscriptionsPerClient < 0 {
+		return fmt.Errorf("invalid max_subscriptions_per_client: %d (must not be negative)", c.Limits.MaxSubscriptionsPerClient)
+	}
+	if c.Limits.MaxConnectionsPerUsername < 0 {
+		return fmt.Errorf("invalid max_connections_per_username: %d (must not be negative)", c.Limits.MaxConnectionsPerUsername)
+	}
+	if c.Limits.MaxPreAuthBytes < 0 {
+		return fmt.Errorf("invalid max_preauth_bytes: %d (must not be negative)", c.Limits.MaxPreAuthBytes)
+	}
+	if c.Limits.MaxPreAuthPacketRate < 0 || c.Limits.MaxPacketRate < 0 {
+		return fmt.Errorf("invalid packet rates: max_preauth_packet_rate=%v max_packet_rate=%v (must not be negative)",
+			c.Limits.MaxPreAuthPacketRate, c.Limits.MaxPacketRate)
+	}
+	if c.Limits.MaxPreAuthByteRate < 0 || c.Limits.MaxByteRate < 0 {
+		return fmt.Errorf("invalid byte rates: max_preauth_byte_rate=%d max_byte_rate=%d (must not be negative)",
+			c.Limits.MaxPreAuthByteRate, c.Limits.MaxByteRate)
+	}
+
+	// Validate authentication settings
+	if c.Auth.Enabled && c.Auth.UsernamePasswordFile == "" && c.Auth.SQL.Driver == "" && !c.Auth.AllowAnonymous && !c.Auth.RequireClientCerts {
+		return fmt.Errorf("auth enabled but no username_password_file, sql, allow_anonymous or require_client_certs: no client could connect")
+	}
+	if sqlAuth := c.Auth.SQL; sqlAuth.Driver != "" {
+		if sqlAuth.PasswordQuery == "" {
+			return fmt.Errorf("auth.sql needs a password_query")
+		}
+		if sqlAuth.RefreshInterval > 0 && sqlAuth.UsersQuery == "" {
+			return fmt.Errorf("auth.sql.refresh_interval needs a users_query")
+		}
+		if sqlAuth.RulesQuery != "" && sqlAuth.ACLQuery == "" {
+			return fmt.Errorf("auth.sql.rules_query needs an acl_query")
+		}
+		if sqlAuth.CacheTTL < 0 || sqlAuth.RefreshInterval < 0 || sqlAuth.QueryTimeout < 0 {
+			return fmt.Errorf("auth.sql durations must not be negative")
+		}
+	}
+	if r := c.Auth.Revocation; r.File != "" && r.URL != "" {
+		return fmt.Errorf("auth.revocation: set either file or url, not both")
+	} else if r.Interval < 0 {
+		return fmt.Errorf("invalid auth.revocation.interval: %s (must not be negative)", r.Interval)
 	}
 
 	// Validate TLS settings
+	if c.Auth.Enabled && c.Auth.RequireClientCerts && c.TLS.CAFile == "" {
+		return fmt.Errorf("auth require_client_certs needs tls.ca_file to verify client certificates")
+	}
 	if c.TLS.Enabled {
 		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
 			return fmt.Errorf("TLS enabled but cert_file or key_file not specified")
 		}
+		if c.TLS.Port < 0 || c.TLS.Port > 65535 {
+			return fmt.Errorf("invalid TLS port: %d (must be 0-65535)", c.TLS.Port)
+		}
+		if c.TLS.Port != 0 && c.TLS.Port == c.Server.Port {
+			return fmt.Errorf("TLS port cannot be the same as server port")
+		}
+		serverNames := make(map[string]bool)
+		for _, vh := range c.TLS.VirtualHosts {
+			name := strings.ToLower(vh.ServerName)
+			if name == "" {
+				return fmt.Errorf("virtual host without a server_name")
+			}
+			if serverNames[name] {
+				return fmt.Errorf("duplicate virtual host: %s", vh.ServerName)
+			}
+			serverNames[name] = true
+			if (vh.CertFile == "") != (vh.KeyFile == "") {
+				return fmt.Errorf("virtual host %s must set both cert_file and key_file", vh.ServerName)
+			}
+			if strings.ContainsAny(vh.Mountpoint, "+#") {
+				return fmt.Errorf("invalid mountpoint for virtual host %s: %q (must not contain wildcards)", vh.ServerName, vh.Mountpoint)
+			}
+			if vh.MaxRetained < 0 {
+				return fmt.Errorf("invalid max_retained for virtual host %s: %d", vh.ServerName, vh.MaxRetained)
+			}
+			if vh.MaxClients < 0 {
+				return fmt.Errorf("invalid max_clients for virtual host %s: %d", vh.ServerName, vh.MaxClients)
+			}
+		}
 	}
 
 	// Validate storage backend
@@ -197,6 +1146,43 @@ func (c *Config) Validate() error {
 	if !validBackends[c.Storage.Backend] {
 		return fmt.Errorf("invalid storage backend: %s (must be memory, bbolt, or redis)", c.Storage.Backend)
 	}
+	if c.Storage.LockTimeout < 0 || c.Storage.LockRetries < 0 || c.Storage.LockBackoff < 0 {
+		return fmt.Errorf("invalid storage lock settings: lock_timeout=%s lock_retries=%d lock_backoff=%s (must not be negative)",
+			c.Storage.LockTimeout, c.Storage.LockRetries, c.Storage.LockBackoff)
+	}
+	if c.Storage.OpTimeout < 0 {
+		return fmt.Errorf("invalid storage op_timeout: %s (must not be negative)", c.Storage.OpTimeout)
+	}
+	if c.Reload.Interval < 0 {
+		return fmt.Errorf("invalid reload interval: %s (must not be negative)", c.Reload.Interval)
+	}
+	if c.Storage.Health.Interval < 0 {
+		return fmt.Errorf("invalid storage health interval: %s (must not be negative)", c.Storage.Health.Interval)
+	}
+	if c.Storage.Health.Degraded != "cache" && c.Storage.Health.Degraded != "reject" {
+		return fmt.Errorf("invalid storage health degraded policy: %q (must be cache or reject)", c.Storage.Health.Degraded)
+	}
+	if c.Storage.Encryption.Enabled {
+		if len(c.Storage.Encryption.Keys) == 0 {
+			return fmt.Errorf("storage encryption requires at least one key")
+		}
+		ids := make(map[string]bool)
+		for _, k := range c.Storage.Encryption.Keys {
+			if k.ID == "" || ids[k.ID] {
+				return fmt.Errorf("storage encryption key IDs must be unique and non-empty: %q", k.ID)
+			}
+			ids[k.ID] = true
+			sources := 0
+			for _, source := range []string{k.Key, k.KeyEnv, k.KeyFile} {
+				if source != "" {
+					sources++
+				}
+			}
+			if sources != 1 {
+				return fmt.Errorf("storage encryption key %s needs exactly one of key, key_env, or key_file", k.ID)
+			}
+		}
+	}
 
 	// Validate QoS level
 	if c.QoS.MaxQoS > 2 {
@@ -217,7 +1203,497 @@ func (c *Config) Validate() error {
 		if c.Metrics.Port == c.Server.Port {
 			return fmt.Errorf("metrics port cannot be the same as server port")
 		}
+		if (c.Metrics.CertFile == "") != (c.Metrics.KeyFile == "") {
+			return fmt.Errorf("metrics cert_file and key_file must be set together")
+		}
+		if (c.Metrics.Username == "") != (c.Metrics.Password == "") {
+			return fmt.Errorf("metrics username and password must be set together")
+		}
+	}
+
+	// Validate admin API
+	if c.Admin.Enabled {
+		if c.Admin.Port < 1 || c.Admin.Port > 65535 {
+			return fmt.Errorf("invalid admin port: %d (must be 1-65535)", c.Admin.Port)
+		}
+		if c.Admin.Port == c.Server.Port || (c.Metrics.Enabled && c.Admin.Port == c.Metrics.Port) {
+			return fmt.Errorf("admin port cannot be the same as server or metrics port")
+		}
+	}
+	validRoles := map[string]bool{"read-only": true, "operator": true, "admin": true}
+	tokenNames := make(map[string]bool)
+	tokenSecrets := make(map[string]bool)
+	for _, t := range c.Admin.Tokens {
+		if t.Name == "" || tokenNames[t.Name] {
+			return fmt.Errorf("admin token name must be unique and non-empty: %q", t.Name)
+		}
+		tokenNames[t.Name] = true
+		if t.Token == "" || tokenSecrets[t.Token] {
+			return fmt.Errorf("admin token %s: token must be unique and non-empty", t.Name)
+		}
+		tokenSecrets[t.Token] = true
+		if !validRoles[t.Role] {
+			return fmt.Errorf("admin token %s: invalid role %q (must be read-only, operator, or admin)", t.Name, t.Role)
+		}
+	}
+
+	if c.LastValue.MaxTopics < 0 {
+		return fmt.Errorf("invalid last_value max_topics: %d (must not be negative)", c.LastValue.MaxTopics)
+	}
+	if c.Files.ChunkSize < 0 || c.Files.MaxSize < 0 {
+		return fmt.Errorf("invalid files limits: chunk_size=%d max_size=%d (must not be negative)", c.Files.ChunkSize, c.Files.MaxSize)
+	}
+	if strings.ContainsAny(c.Files.TopicPrefix, "+#") {
+		return fmt.Errorf("invalid files topic_prefix %q: wildcards are not allowed", c.Files.TopicPrefix)
+	}
+	if err := c.UsageQuotas.validate(); err != nil {
+		return err
+	}
+
+	// Validate bridges
+	bridgeNames := make(map[string]bool)
+	for _, b := range c.Bridges {
+		if err := b.validate(); err != nil {
+			return err
+		}
+		if bridgeNames[b.Name] {
+			return fmt.Errorf("duplicate bridge: %s", b.Name)
+		}
+		bridgeNames[b.Name] = true
+	}
+
+	// Validate analytics
+	if c.Analytics.Enabled {
+		if c.Analytics.Interval <= 0 || c.Analytics.Window <= 0 {
+			return fmt.Errorf("invalid analytics interval or window (must be positive)")
+		}
+		if c.Analytics.TopN < 1 || c.Analytics.MaxTracked < 1 {
+			return fmt.Errorf("invalid analytics top_n or max_tracked (must be positive)")
+		}
+		if c.Analytics.MaxTopics < 0 || c.Analytics.MaxNewTopics < 0 {
+			return fmt.Errorf("invalid analytics thresholds (must not be negative)")
+		}
+	}
+
+	if c.StatsHistory.Enabled {
+		if c.StatsHistory.Interval < time.Second {
+			return fmt.Errorf("invalid stats_history interval: %s (must be at least 1s)", c.StatsHistory.Interval)
+		}
+		if c.StatsHistory.Retention < c.StatsHistory.Interval {
+			return fmt.Errorf("invalid stats_history retention: %s (must be at least the interval)", c.StatsHistory.Retention)
+		}
+	}
+
+	// Validate content type rules
+	if c.ContentTypes.Enabled && c.ContentTypes.MaxTracked < 1 {
+		return fmt.Errorf("invalid content_types max_tracked: %d (must be positive)", c.ContentTypes.MaxTracked)
+	}
+	if len(c.ContentTypes.Rules) > 0 && !c.ContentTypes.Enabled {
+		return fmt.Errorf("content_types rules require content_types.enabled")
+	}
+	for _, r := range c.ContentTypes.Rules {
+		if r.Filter == "" {
+			return fmt.Errorf("content type rule without a filter")
+		}
+		if len(r.ContentTypes) == 0 {
+			return fmt.Errorf("content type rule %s has no content types", r.Filter)
+		}
+		for _, pattern := range r.ContentTypes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("content type rule %s: invalid pattern %q", r.Filter, pattern)
+			}
+		}
+		if r.Action != "reject" && r.Action != "log" {
+			return fmt.Errorf("content type rule %s: invalid action %q (must be reject or log)", r.Filter, r.Action)
+		}
+		if r.FillMissing && strings.ContainsAny(r.ContentTypes[0], "*?[") {
+			return fmt.Errorf("content type rule %s: fill_missing needs a first content type without wildcards", r.Filter)
+		}
+	}
+
+	// Validate the schema registry
+	if c.SchemaRegistry.Enabled {
+		if !slices.Contains(SchemaRegistryKinds, c.SchemaRegistry.Kind) {
+			return fmt.Errorf("invalid schema_registry kind: %s (must be one of %s)", c.SchemaRegistry.Kind, strings.Join(SchemaRegistryKinds, ", "))
+		}
+		if c.SchemaRegistry.URL == "" {
+			return fmt.Errorf("schema_registry url is required")
+		}
+		if c.SchemaRegistry.Kind == "http" && !strings.Contains(c.SchemaRegistry.URL, "%s") {
+			return fmt.Errorf("schema_registry url must contain %%s for the subject with kind http")
+		}
+		if c.SchemaRegistry.Timeout <= 0 || c.SchemaRegistry.CacheTTL <= 0 {
+			return fmt.Errorf("invalid schema_registry timeout or cache_ttl (must be positive)")
+		}
+		if c.SchemaRegistry.Unavailable != "accept" && c.SchemaRegistry.Unavailable != "reject" {
+			return fmt.Errorf("invalid schema_registry unavailable: %q (must be accept or reject)", c.SchemaRegistry.Unavailable)
+		}
+	}
+	for _, r := range c.SchemaRegistry.Rules {
+		if r.Filter == "" || r.Subject == "" {
+			return fmt.Errorf("schema rule without a filter or subject")
+		}
+		if r.Action != "reject" && r.Action != "log" {
+			return fmt.Errorf("schema rule %s: invalid action %q (must be reject or log)", r.Filter, r.Action)
+		}
+	}
+
+	// Validate topic metrics
+	prefixes := make(map[string]bool)
+	for _, prefix := range c.TopicMetrics.Prefixes {
+		if prefix == "" || prefixes[prefix] {
+			return fmt.Errorf("topic_metrics prefixes must be unique and non-empty: %q", prefix)
+		}
+		if strings.ContainsAny(prefix, "+#") {
+			return fmt.Errorf("invalid topic_metrics prefix: %q (must not contain wildcards)", prefix)
+		}
+		prefixes[prefix] = true
+	}
+	if len(c.TopicMetrics.Prefixes) > 0 && c.TopicMetrics.RateInterval <= 0 {
+		return fmt.Errorf("invalid topic_metrics rate_interval (must be positive)")
+	}
+
+	// Validate traffic shaping
+	for name, shaping := range c.Shaping {
+		if name != "tcp" && name != "tls" {
+			return fmt.Errorf("invalid shaping listener: %s (must be tcp or tls)", name)
+		}
+		if shaping.Latency < 0 || shaping.Jitter < 0 || shaping.ReadBandwidth < 0 || shaping.WriteBandwidth < 0 {
+			return fmt.Errorf("invalid shaping settings for listener %s (must not be negative)", name)
+		}
+	}
+
+	// Validate refusal hints
+	for reason, hint := range c.RefusalHints {
+		if !slices.Contains(RefusalReasons, reason) {
+			return fmt.Errorf("invalid refusal_hints reason: %s (must be one of %s)", reason, strings.Join(RefusalReasons, ", "))
+		}
+		if hint.RetryAfter < 0 || hint.MaxRetryAfter < 0 {
+			return fmt.Errorf("invalid refusal_hints for %s: retry delays must not be negative", reason)
+		}
+		if hint.MaxRetryAfter > 0 && hint.MaxRetryAfter < hint.RetryAfter {
+			return fmt.Errorf("invalid refusal_hints for %s: max_retry_after is below retry_after", reason)
+		}
+	}
+
+	// Validate logging
+	if c.Logging.PublishSampling < 1 {
+		return fmt.Errorf("invalid logging publish_sampling: %d (must be positive)", c.Logging.PublishSampling)
+	}
+	if r := c.Logging.Rotation; r.MaxSizeMB < 0 || r.Interval < 0 || r.MaxBackups < 0 || r.MaxAge < 0 {
+		return fmt.Errorf("invalid logging rotation settings (must not be negative)")
+	}
+	if c.Logging.Output == "syslog" {
+		switch c.Logging.Syslog.Network {
+		case "udp", "tcp", "unix":
+		default:
+			return fmt.Errorf("invalid syslog network: %s (must be udp, tcp, or unix)", c.Logging.Syslog.Network)
+		}
+		validFacilities := map[string]bool{
+			"kern": true, "user": true, "mail": true, "daemon": true, "auth": true, "syslog": true,
+			"lpr": true, "news": true, "uucp": true, "cron": true, "authpriv": true, "ftp": true,
+			"local0": true, "local1": true, "local2": true, "local3": true,
+			"local4": true, "local5": true, "local6": true, "local7": true,
+		}
+		if !validFacilities[c.Logging.Syslog.Facility] {
+			return fmt.Errorf("invalid syslog facility: %s", c.Logging.Syslog.Facility)
+		}
+	}
+	if strings.ContainsAny(c.Logging.Identifier, " \t") {
+		return fmt.Errorf("invalid logging identifier: %q (must not contain spaces)", c.Logging.Identifier)
+	}
+
+	// Validate slow consumer detection
+	if c.SlowConsumer.Enabled {
+		if c.SlowConsumer.QueueThreshold < 1 {
+			return fmt.Errorf("invalid slow_consumer queue_threshold: %d (must be positive)", c.SlowConsumer.QueueThreshold)
+		}
+		if c.SlowConsumer.Duration < 0 || c.SlowConsumer.CheckInterval <= 0 {
+			return fmt.Errorf("invalid slow_consumer duration or check_interval")
+		}
+	}
+
+	// Validate anomaly detection
+	if c.Anomaly.Enabled {
+		if c.Anomaly.Interval <= 0 || c.Anomaly.Baseline < c.Anomaly.Interval {
+			return fmt.Errorf("invalid anomaly interval or baseline (baseline must be at least the interval)")
+		}
+		if c.Anomaly.Factor <= 1 {
+			return fmt.Errorf("invalid anomaly factor: %g (must be greater than 1)", c.Anomaly.Factor)
+		}
+		if c.Anomaly.Warmup < 1 || c.Anomaly.MinRate < 0 || c.Anomaly.TopicLevels < 0 || c.Anomaly.MaxTracked < 1 {
+			return fmt.Errorf("invalid anomaly warmup, min_rate, topic_levels or max_tracked")
+		}
+		if !slices.Contains(AnomalyActions, c.Anomaly.Action) {
+			return fmt.Errorf("invalid anomaly action: %s (must be one of %s)", c.Anomaly.Action, strings.Join(AnomalyActions, ", "))
+		}
+		if c.Anomaly.ThrottleRate <= 0 {
+			return fmt.Errorf("invalid anomaly throttle_rate: %g (must be positive)", c.Anomaly.ThrottleRate)
+		}
 	}
 
+	// Validate client migration
+	if !slices.Contains(MigrationPolicies, c.Migration.Policy) {
+		return fmt.Errorf("invalid migration policy: %s (must be one of %s)", c.Migration.Policy, strings.Join(MigrationPolicies, ", "))
+	}
+	if c.Migration.Enabled {
+		if c.Migration.ServerReference == "" {
+			return fmt.Errorf("migration requires a server_reference")
+		}
+		if c.Migration.MaxClients == 0 && !c.Migration.OnOverload {
+			return fmt.Errorf("migration needs max_clients or on_overload")
+		}
+		if c.Migration.MaxClients < 0 || c.Migration.TargetClients < 0 || c.Migration.TargetClients > c.Migration.MaxClients {
+			return fmt.Errorf("invalid migration max_clients %d / target_clients %d (target must be between 0 and max)",
+				c.Migration.MaxClients, c.Migration.TargetClients)
+		}
+		if c.Migration.BatchSize < 1 || c.Migration.CheckInterval <= 0 {
+			return fmt.Errorf("invalid migration batch_size or check_interval")
+		}
+	}
+
+	// Validate presence notifications
+	if c.Presence.Enabled {
+		if c.Presence.QoS > 2 {
+			return fmt.Errorf("invalid presence qos: %d (must be 0-2)", c.Presence.QoS)
+		}
+	}
+	for _, topic := range []string{c.Presence.ConnectedTopic, c.Presence.DisconnectedTopic, c.Presence.StatusTopic} {
+		if strings.ContainsAny(topic, "+#") {
+			return fmt.Errorf("invalid presence topic: %q (must not contain wildcards)", topic)
+		}
+	}
+	if c.Presence.StatusTopic != "" && !c.Presence.Tracking {
+		return fmt.Errorf("presence status_topic requires tracking")
+	}
+
+	// Validate maintenance windows
+	windowNames := make(map[string]bool)
+	for _, w := range c.Maintenance {
+		if w.Name == "" || windowNames[w.Name] {
+			return fmt.Errorf("maintenance window name must be unique and non-empty: %q", w.Name)
+		}
+		windowNames[w.Name] = true
+		if !w.End.After(w.Start) {
+			return fmt.Errorf("maintenance window %s: end must be after start", w.Name)
+		}
+		if len(w.Topics) == 0 {
+			return fmt.Errorf("maintenance window %s: no topics", w.Name)
+		}
+		if w.Action != "mute" && w.Action != "reject" {
+			return fmt.Errorf("maintenance window %s: invalid action %q (must be mute or reject)", w.Name, w.Action)
+		}
+	}
+
+	// Validate usage export
+	if c.UsageExport.Enabled {
+		if c.UsageExport.Directory == "" && c.UsageExport.URL == "" {
+			return fmt.Errorf("usage_export requires a directory or url")
+		}
+		if c.UsageExport.Interval < time.Minute {
+			return fmt.Errorf("invalid usage_export interval: %s (must be at least 1m)", c.UsageExport.Interval)
+		}
+	}
+	if c.UsageExport.Format != "json" && c.UsageExport.Format != "csv" {
+		return fmt.Errorf("invalid usage_export format: %s (must be json or csv)", c.UsageExport.Format)
+	}
+
+	// Validate time-series exporter
+	if c.TimeSeries.Enabled {
+		if !strings.HasPrefix(c.TimeSeries.URL, "http://") && !strings.HasPrefix(c.TimeSeries.URL, "https://") {
+			return fmt.Errorf("invalid timeseries url: %q (must start with http:// or https://)", c.TimeSeries.URL)
+		}
+		if c.TimeSeries.BatchSize < 1 || c.TimeSeries.QueueSize < 1 {
+			return fmt.Errorf("invalid timeseries batch_size or queue_size (must be positive)")
+		}
+		for _, r := range c.TimeSeries.Rules {
+			if r.Filter == "" || r.Measurement == "" {
+				return fmt.Errorf("timeseries rule must set filter and measurement")
+			}
+		}
+	}
+
+	// Validate client groups
+	groupNames := make(map[string]bool)
+	for _, g := range c.Groups {
+		if g.Name == "" {
+			return fmt.Errorf("client group without a name")
+		}
+		if groupNames[g.Name] {
+			return fmt.Errorf("duplicate client group: %s", g.Name)
+		}
+		groupNames[g.Name] = true
+		if g.RateLimit < 0 {
+			return fmt.Errorf("invalid rate_limit for group %s: %v (must not be negative)", g.Name, g.RateLimit)
+		}
+		for _, pattern := range append(append([]string{}, g.ClientIDs...), g.Usernames...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q in group %s: %w", pattern, g.Name, err)
+			}
+		}
+	}
+
+	// Validate topic reservations
+	for _, r := range c.ReservedTopics {
+		if r.Filter == "" {
+			return fmt.Errorf("reserved topic without a filter")
+		}
+		if len(r.ClientIDs)+len(r.Usernames)+len(r.Groups) == 0 {
+			return fmt.Errorf("reserved topic %s has no owners", r.Filter)
+		}
+		for _, pattern := range append(append([]string{}, r.ClientIDs...), r.Usernames...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q for reserved topic %s: %w", pattern, r.Filter, err)
+			}
+		}
+		for _, name := range r.Groups {
+			if !groupNames[name] {
+				return fmt.Errorf("reserved topic %s refers to unknown group %s", r.Filter, name)
+			}
+		}
+	}
+
+	// Validate mirrors
+	if err := c.validateListeners(); err != nil {
+		return err
+	}
+
+	for _, m := range c.Mirrors {
+		if m.Filter == "" {
+			return fmt.Errorf("mirror without a filter")
+		}
+		if m.Prefix == "" || strings.ContainsAny(m.Prefix, "+#") || strings.HasPrefix(m.Prefix, "$") {
+			return fmt.Errorf("invalid prefix for mirror %s: %q (must be non-empty, without wildcards and not start with $)", m.Filter, m.Prefix)
+		}
+	}
+
+	// Validate transcoding rules
+	for i, rule := range c.Transcoding {
+		if rule.Filter == "" {
+			return fmt.Errorf("transcoding rule %d without a filter", i+1)
+		}
+		if rule.Format != "cbor" && rule.Format != "msgpack" {
+			return fmt.Errorf("invalid format for transcoding rule %d: %q (must be cbor or msgpack)", i+1, rule.Format)
+		}
+		if len(rule.ClientIDs) == 0 {
+			return fmt.Errorf("transcoding rule %d without client_ids", i+1)
+		}
+		for _, pattern := range rule.ClientIDs {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q in transcoding rule %d: %w", pattern, i+1, err)
+			}
+		}
+	}
+
+	// Validate retain handling rules
+	for i, rule := range c.RetainHandling {
+		if rule.Mode != 1 && rule.Mode != 2 {
+			return fmt.Errorf("invalid mode for retain_handling rule %d: %d (must be 1 or 2)", i+1, rule.Mode)
+		}
+		for _, pattern := range rule.ClientIDs {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q in retain_handling rule %d: %w", pattern, i+1, err)
+			}
+		}
+		for _, filter := range rule.Filters {
+			if filter == "" {
+				return fmt.Errorf("empty filter in retain_handling rule %d", i+1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validate checks a single bridge definition
+func (b *BridgeConfig) validate() error {
+	if b.Name == "" {
+		return fmt.Errorf("bridge without a name")
+	}
+	if !strings.HasPrefix(b.Address, "tcp://") && !strings.HasPrefix(b.Address, "ssl://") {
+		return fmt.Errorf("invalid address for bridge %s: %q (must start with tcp:// or ssl://)", b.Name, b.Address)
+	}
+	if b.ProtocolVersion < 3 || b.ProtocolVersion > 5 {
+		return fmt.Errorf("invalid protocol_version for bridge %s: %d (must be 3, 4 or 5)", b.Name, b.ProtocolVersion)
+	}
+	if (b.TLS.CertFile == "") != (b.TLS.KeyFile == "") {
+		return fmt.Errorf("bridge %s must set both tls.cert_file and tls.key_file", b.Name)
+	}
+	if b.MaxHops < 0 {
+		return fmt.Errorf("invalid max_hops for bridge %s: %d (must not be negative)", b.Name, b.MaxHops)
+	}
+	if b.Buffer.MaxMessages < 0 || b.Buffer.MaxBytes < 0 {
+		return fmt.Errorf("invalid buffer limits for bridge %s (must not be negative)", b.Name)
+	}
+	if len(b.Topics) == 0 {
+		return fmt.Errorf("bridge %s has no topics", b.Name)
+	}
+	for _, filter := range b.LocalOnly {
+		if filter == "" {
+			return fmt.Errorf("bridge %s has an empty local_only filter", b.Name)
+		}
+	}
+	for _, filter := range b.AllowedTopics {
+		if filter == "" {
+			return fmt.Errorf("bridge %s has an empty allowed_topics filter", b.Name)
+		}
+	}
+	if len(b.TLS.AllowedPeers) > 0 {
+		if !strings.HasPrefix(b.Address, "ssl://") {
+			return fmt.Errorf("bridge %s sets tls.allowed_peers but its address is not ssl://", b.Name)
+		}
+		if b.TLS.InsecureSkipVerify {
+			return fmt.Errorf("bridge %s cannot combine tls.allowed_peers with insecure_skip_verify", b.Name)
+		}
+		for _, peer := range b.TLS.AllowedPeers {
+			if peer == "" {
+				return fmt.Errorf("bridge %s has an empty tls.allowed_peers entry", b.Name)
+			}
+		}
+	}
+	for _, t := range b.Topics {
+		if t.Filter == "" {
+			return fmt.Errorf("bridge %s has a topic without a filter", b.Name)
+		}
+		if t.Direction != "in" && t.Direction != "out" && t.Direction != "both" {
+			return fmt.Errorf("invalid direction for bridge %s topic %s: %q (must be in, out or both)", b.Name, t.Filter, t.Direction)
+		}
+		if t.QoS > 2 {
+			return fmt.Errorf("invalid qos for bridge %s topic %s: %d", b.Name, t.Filter, t.QoS)
+		}
+	}
+	return nil
+}
+
+// validate checks the usage quota settings
+func (q *UsageQuotaConfig) validate() error {
+	if q.Per != "username" && q.Per != "tenant" {
+		return fmt.Errorf("invalid usage_quotas per: %s (must be username or tenant)", q.Per)
+	}
+	if q.Policy != "reject" && q.Policy != "throttle" {
+		return fmt.Errorf("invalid usage_quotas policy: %s (must be reject or throttle)", q.Policy)
+	}
+	if q.ThrottleRate < 0 {
+		return fmt.Errorf("invalid usage_quotas throttle_rate: %g (must not be negative)", q.ThrottleRate)
+	}
+	if strings.ContainsAny(q.EventTopic, "+#") {
+		return fmt.Errorf("invalid usage_quotas event_topic %q: wildcards are not allowed", q.EventTopic)
+	}
+	if q.Messages < 0 || q.Bytes < 0 {
+		return fmt.Errorf("invalid usage_quotas: messages=%d bytes=%d (must not be negative)", q.Messages, q.Bytes)
+	}
+	names := make(map[string]bool)
+	for _, o := range q.Overrides {
+		if o.Name == "" {
+			return fmt.Errorf("usage_quotas override without a name")
+		}
+		if names[o.Name] {
+			return fmt.Errorf("duplicate usage_quotas override: %s", o.Name)
+		}
+		names[o.Name] = true
+		if o.Messages < 0 || o.Bytes < 0 {
+			return fmt.Errorf("invalid usage_quotas override %s: messages=%d bytes=%d (must not be negative)", o.Name, o.Messages, o.Bytes)
+		}
+	}
 	return nil
 }
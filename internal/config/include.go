@@ -0,0 +1,112 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFiles returns the files making up a configuration in merge order:
+// the main file, its includes (each pattern expanded in lexical order) and
+// the profile file config.<profile>.yaml next to the main file
+func configFiles(path string, includes []string, profile string) ([]string, error) {
+	files := []string{path}
+	dir := filepath.Dir(path)
+
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	if profile != "" {
+		ext := filepath.Ext(path)
+		profilePath := strings.TrimSuffix(path, ext) + "." + profile + ext
+		if _, err := os.Stat(profilePath); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile, err)
+		}
+		files = append(files, profilePath)
+	}
+	return files, nil
+}
+
+// readDocument parses and strictly checks a single configuration file. An
+// empty file yields a nil node.
+func readDocument(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Decode on its own first so key and type errors carry this file's name
+	// and line numbers
+	var check Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&check); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, describeYAMLError(err))
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return doc.Content[0], nil
+}
+
+// replaceTag marks a list or section in a later file that replaces the one
+// of earlier files instead of being merged into it, as in
+// "listeners: !replace [...]"
+const replaceTag = "!replace"
+
+// mergeNodes merges src into dst: mappings are merged key by key, sequences
+// are concatenated (so bridges or groups can be split across files) and any
+// other value in src replaces the one in dst, as do mappings and sequences
+// tagged !replace
+func mergeNodes(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		existing := mappingValue(dst, key.Value)
+		switch {
+		case existing == nil:
+			dst.Content = append(dst.Content, key, value)
+		case value.Tag == replaceTag:
+			*existing = *value
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNodes(existing, value)
+		case existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			existing.Content = append(existing.Content, value.Content...)
+		default:
+			*existing = *value
+		}
+	}
+}
+
+// mappingValue returns the value stored under key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfigFiles writes files by name into a directory and returns the
+// path of config.yaml in it
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return filepath.Join(dir, "config.yaml")
+}
+
+// groupNames returns the names of the configured groups
+func groupNames(cfg *Config) []string {
+	var names []string
+	for _, g := range cfg.Groups {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+// TestLoadProfileEmptyFiles tests that a configuration made only of empty
+// files loads with the defaults
+func TestLoadProfileEmptyFiles(t *testing.T) {
+	path := writeConfigFiles(t, map[string]string{
+		"config.yaml":      "",
+		"config.test.yaml": "# nothing to override\n",
+	})
+	cfg, err := LoadProfile(path, "test")
+	if err != nil {
+		t.Fatalf("LoadProfile failed: %v", err)
+	}
+	if cfg.Server.Port == 0 {
+		t.Error("Expected the defaults to be set")
+	}
+}
+
+// TestLoadProfileMerge tests that includes and the profile are merged in
+// order, appending lists unless they are tagged !replace
+func TestLoadProfileMerge(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `include: ["config.d/*.yaml"]
+server:
+  port: 1884
+  keep_alive: 30s
+groups:
+  - name: main
+    client_ids: ["a*"]
+`,
+		"config.d/10-groups.yaml": `groups:
+  - name: included
+    client_ids: ["b*"]
+`,
+		"config.d/20-server.yaml": `server:
+  port: 1885
+`,
+		"config.append.yaml": `groups:
+  - name: profile
+    client_ids: ["c*"]
+`,
+		"config.replace.yaml": `groups: !replace
+  - name: profile
+    client_ids: ["c*"]
+`,
+	}
+	path := writeConfigFiles(t, files)
+
+	cfg, err := LoadProfile(path, "append")
+	if err != nil {
+		t.Fatalf("LoadProfile failed: %v", err)
+	}
+	if cfg.Server.Port != 1885 || cfg.Server.KeepAlive.Seconds() != 30 {
+		t.Errorf("Expected the included port and the main keepalive, got %d and %s", cfg.Server.Port, cfg.Server.KeepAlive)
+	}
+	if names := groupNames(cfg); len(names) != 3 || names[0] != "main" || names[1] != "included" || names[2] != "profile" {
+		t.Errorf("Expected the groups of every file in merge order, got %v", names)
+	}
+
+	cfg, err = LoadProfile(path, "replace")
+	if err != nil {
+		t.Fatalf("LoadProfile failed: %v", err)
+	}
+	if names := groupNames(cfg); len(names) != 1 || names[0] != "profile" {
+		t.Errorf("Expected the profile to replace the groups, got %v", names)
+	}
+
+	if _, err := LoadProfile(path, "missing"); err == nil {
+		t.Error("Expected a missing profile to fail")
+	}
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Material returns the decoded key from its configured source
+func (k EncryptionKeyConfig) Material() ([]byte, error) {
+	encoded := k.Key
+	switch {
+	case k.KeyEnv != "":
+		encoded = os.Getenv(k.KeyEnv)
+		if encoded == "" {
+			return nil, fmt.Errorf("encryption key %s: environment variable %s is not set", k.ID, k.KeyEnv)
+		}
+	case k.KeyFile != "":
+		data, err := os.ReadFile(k.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", k.ID, err)
+		}
+		encoded = string(data)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key %s: invalid base64: %w", k.ID, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ListenerConfig is an additional MQTT listener, opened next to the main
+// TCP and TLS listeners
+type ListenerConfig struct {
+	Name string `yaml:"name"` // Unique name, used in logs and metrics
+	Type string `yaml:"type"` // "tcp" or "tls" (with the tls section's certificates)
+	Host string `yaml:"host"` // Interface to bind to (default: server.host)
+	Port int    `yaml:"port"` // Port to bind to (0 = any free port)
+}
+
+// Validate checks a listener definition
+func (l *ListenerConfig) Validate() error {
+	if l.Name == "" {
+		return fmt.Errorf("listener without a name")
+	}
+	if l.Name == "tcp" || l.Name == "tls" {
+		return fmt.Errorf("invalid listener name %q (reserved for the main listeners)", l.Name)
+	}
+	if l.Type != "tcp" && l.Type != "tls" {
+		return fmt.Errorf("invalid type for listener %s: %q (must be tcp or tls)", l.Name, l.Type)
+	}
+	if l.Port < 0 || l.Port > 65535 {
+		return fmt.Errorf("invalid port for listener %s: %d", l.Name, l.Port)
+	}
+	return nil
+}
+
+// validateListeners checks the additional listeners and that their names
+// are unique
+func (c *Config) validateListeners() error {
+	names := make(map[string]bool)
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if err := l.Validate(); err != nil {
+			return err
+		}
+		if names[l.Name] {
+			return fmt.Errorf("duplicate listener name: %s", l.Name)
+		}
+		names[l.Name] = true
+		if l.Type == "tls" && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+			return fmt.Errorf("listener %s: tls listeners need tls.cert_file and tls.key_file", l.Name)
+		}
+	}
+	return nil
+}
+
+// listenersHeader starts a listeners file written by the broker
+const listenersHeader = "# Listeners saved by the broker (POST/DELETE /api/v1/listeners with persist).\n" +
+	"# Include this file in the configuration; it is rewritten on every change.\n"
+
+// UpdateListenersFile rewrites the listeners of a configuration file
+// holding only a listeners section, as saved by the broker. update gets the
+// listeners in the file (none if it does not exist yet) and returns the
+// ones to save.
+func UpdateListenersFile(path string, update func([]ListenerConfig) ([]ListenerConfig, error)) error {
+	var doc struct {
+		Listeners []ListenerConfig `yaml:"listeners"`
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	doc.Listeners, err = update(slices.Clone(doc.Listeners))
+	if err != nil {
+		return err
+	}
+	if doc.Listeners == nil {
+		doc.Listeners = []ListenerConfig{}
+	}
+	buf := bytes.NewBufferString(listenersHeader)
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	data = buf.Bytes()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".listeners-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secrets in the redacted configuration
+const redactedValue = "[REDACTED]"
+
+// Redacted returns the configuration as a generic document keyed by the
+// YAML option names, with passwords, tokens and credentials embedded in
+// URLs replaced, so the effective configuration can be logged and served
+func (c *Config) Redacted() (map[string]any, error) {
+	var node yaml.Node
+	if err := node.Encode(c); err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	redactNode(&node)
+
+	doc := make(map[string]any)
+	if err := node.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+	return doc, nil
+}
+
+// redactNode replaces secret values below a node
+func redactNode(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.ScalarNode && value.Value != "" && secretKey(key.Value) {
+				value.Value, value.Tag, value.Style = redactedValue, "!!str", 0
+				continue
+			}
+			redactNode(value)
+		}
+	case yaml.SequenceNode, yaml.DocumentNode:
+		for _, child := range node.Content {
+			redactNode(child)
+		}
+	case yaml.ScalarNode:
+		node.Value = redactURL(node.Value)
+	}
+}
+
+// secretKey reports whether an option holds a secret. Options naming files
+// that contain secrets are paths and are kept. Database DSNs are secrets
+// whatever their form, since drivers embed passwords in their own syntax,
+// such as user:pass@tcp(host)/db or password=... key/value pairs.
+func secretKey(key string) bool {
+	if strings.HasSuffix(key, "_file") {
+		return false
+	}
+	return key == "password" || strings.HasSuffix(key, "_password") || key == "key" ||
+		key == "token" || strings.HasSuffix(key, "_token") || strings.Contains(key, "secret") ||
+		key == "dsn" || strings.HasSuffix(key, "_dsn")
+}
+
+// redactURL hides the password of a URL with credentials
+func redactURL(value string) string {
+	if !strings.Contains(value, "://") || !strings.Contains(value, "@") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); !ok {
+		return value
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// unknownFieldRe matches yaml.v3's error for keys without a struct field
+var unknownFieldRe = regexp.MustCompile(`^(line \d+): field (\S+) not found in type (\S+)$`)
+
+// describeYAMLError rewrites decoding errors in terms of the configuration
+// file: unknown keys are reported with their section and a suggestion when
+// the key looks like a typo of a known one
+func describeYAMLError(err error) error {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+
+	sections := schemaSections()
+	problems := make([]string, 0, len(typeErr.Errors))
+	for _, msg := range typeErr.Errors {
+		m := unknownFieldRe.FindStringSubmatch(msg)
+		if m == nil {
+			problems = append(problems, msg)
+			continue
+		}
+		line, key, typeName := m[1], m[2], m[3]
+		section, ok := sections[typeName]
+		if !ok {
+			problems = append(problems, msg)
+			continue
+		}
+
+		where := "at top level"
+		if section.path != "" {
+			where = "in " + section.path
+		}
+		problem := fmt.Sprintf("%s: unknown key %q %s", line, key, where)
+		if suggestion := closestKey(key, section.keys); suggestion != "" {
+			problem += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+	return &SchemaError{Problems: problems}
+}
+
+// SchemaError lists every key or type problem found in a configuration file
+type SchemaError struct {
+	Problems []string
+}
+
+func (e *SchemaError) Error() string {
+	return "\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// schemaSection describes where a config struct appears in the YAML file
+type schemaSection struct {
+	path string   // e.g. "server.tcp" or "bridges[].topics[]"
+	keys []string // valid keys of the section
+}
+
+// schemaSections maps the Go type names used in yaml.v3 errors (e.g.
+// "config.ServerConfig") to their location in the file
+func schemaSections() map[string]schemaSection {
+	sections := make(map[string]schemaSection)
+	var walk func(t reflect.Type, path string)
+	walk = func(t reflect.Type, path string) {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+			if t.Kind() == reflect.Slice {
+				path += "[]"
+			}
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		name := t.String()
+		if _, seen := sections[name]; seen {
+			return
+		}
+
+		section := schemaSection{path: path}
+		sections[name] = section
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if key == "" || key == "-" {
+				continue
+			}
+			section.keys = append(section.keys, key)
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			walk(f.Type, child)
+		}
+		sections[name] = section
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	return sections
+}
+
+// closestKey returns the known key within two edits of key, if any
+func closestKey(key string, keys []string) string {
+	best, bestDist := "", 3
+	for _, k := range keys {
+		if d := editDistance(key, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
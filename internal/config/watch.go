@@ -0,0 +1,110 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Watch polls the files making up a configuration, including the auth
+// and TLS ticket key files it refers to, and calls reload with the new configuration
+// whenever their contents change. Polling, unlike file system events, also
+// sees Kubernetes ConfigMap updates, which swap a symlinked directory.
+// Changes that fail to load are logged and ignored. Watch returns when ctx
+// is cancelled.
+func Watch(ctx context.Context, cfg *Config, path, profile string, interval time.Duration, reload func(*Config)) {
+	files := watchedFiles(cfg, path, profile)
+	last := fingerprint(files)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		sum := fingerprint(files)
+		if sum == last {
+			continue
+		}
+		last = sum
+
+		next, err := LoadProfile(path, profile)
+		if err != nil {
+			log.Printf("Configuration change ignored: %v", err)
+			continue
+		}
+		log.Printf("Configuration change detected in %s", path)
+		files = watchedFiles(next, path, profile)
+		last = fingerprint(files)
+		reload(next)
+	}
+}
+
+// watchedFiles returns the configuration files and the auth and ticket key
+// files they refer to
+func watchedFiles(cfg *Config, path, profile string) []string {
+	files, err := configFiles(path, cfg.Include, profile)
+	if err != nil {
+		files = []string{path}
+	}
+	for _, file := range []string{cfg.Auth.UsernamePasswordFile, cfg.Auth.ACLFile, cfg.Auth.PolicyFile, cfg.TLS.SessionTickets.KeyFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// fingerprint hashes the names and contents of files; unreadable files
+// count as empty
+func fingerprint(files []string) [sha256.Size]byte {
+	h := sha256.New()
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		h.Write([]byte(file))
+		h.Write([]byte{0})
+		h.Write(data)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// Changes lists the settings that differ between two configurations by
+// their YAML path, e.g. "logging.level", descending one level into
+// sections
+func Changes(old, next *Config) []string {
+	var changes []string
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		section := yamlKey(t.Field(i))
+		of, nf := ov.Field(i), nv.Field(i)
+		if reflect.DeepEqual(of.Interface(), nf.Interface()) {
+			continue
+		}
+		if of.Kind() != reflect.Struct {
+			changes = append(changes, section)
+			continue
+		}
+		for j := 0; j < of.NumField(); j++ {
+			if !reflect.DeepEqual(of.Field(j).Interface(), nf.Field(j).Interface()) {
+				changes = append(changes, section+"."+yamlKey(of.Type().Field(j)))
+			}
+		}
+	}
+	return changes
+}
+
+// yamlKey returns the YAML key of a struct field
+func yamlKey(f reflect.StructField) string {
+	key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	return key
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JournalWriter sends each log line to the systemd journal using its
+// native protocol, with the priority, identifier and configured fields as
+// journal fields
+type JournalWriter struct {
+	conn   net.Conn
+	fields []byte // encoded fields added to every entry
+}
+
+// DialJournal connects to the journal socket
+func DialJournal(socket, identifier string, fields map[string]string) (*JournalWriter, error) {
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald at %s: %w", socket, err)
+	}
+
+	var static bytes.Buffer
+	appendJournalField(&static, "SYSLOG_IDENTIFIER", identifier)
+	appendJournalField(&static, "SYSLOG_PID", strconv.Itoa(os.Getpid()))
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if field := journalFieldName(name); field != "" {
+			appendJournalField(&static, field, fields[name])
+		}
+	}
+	return &JournalWriter{conn: conn, fields: static.Bytes()}, nil
+}
+
+// Write sends one log line as a journal entry
+func (w *JournalWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	var entry bytes.Buffer
+	appendJournalField(&entry, "MESSAGE", msg)
+	appendJournalField(&entry, "PRIORITY", strconv.Itoa(severity(msg)))
+	entry.Write(w.fields)
+	if _, err := w.conn.Write(entry.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the journal socket
+func (w *JournalWriter) Close() error {
+	return w.conn.Close()
+}
+
+// appendJournalField encodes a field, using the length-prefixed form for
+// values spanning several lines
+func appendJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteString("=" + value + "\n")
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalFieldName converts a configured field name to a valid journal
+// field name: upper case letters, digits and underscores, not starting
+// with an underscore (those are reserved for journald)
+func journalFieldName(name string) string {
+	mapped := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	return strings.TrimLeft(mapped, "_0123456789")
+}
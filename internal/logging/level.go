@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Syslog severity of packet-level lines, shown at the debug level only
+const severityDebug = 7
+
+// levels maps log level names to the highest severity logged
+var levels = map[string]int{
+	"debug": severityDebug,
+	"info":  severityInfo,
+	"warn":  severityWarning,
+	"error": severityError,
+}
+
+// threshold is the highest severity currently logged
+var threshold atomic.Int32
+
+func init() {
+	threshold.Store(severityDebug)
+}
+
+// Level returns the name of the current log level
+func Level() string {
+	current := int(threshold.Load())
+	for name, severity := range levels {
+		if severity == current {
+			return name
+		}
+	}
+	return "debug"
+}
+
+// SetLevel changes the log level at runtime: lines less severe than the
+// level are discarded. Trace lines are always logged.
+func SetLevel(name string) error {
+	severity, ok := levels[name]
+	if !ok {
+		return fmt.Errorf("invalid log level: %s (must be debug, info, warn, or error)", name)
+	}
+	threshold.Store(int32(severity))
+	return nil
+}
+
+// levelWriter discards log lines below the current level before they reach
+// the destination
+type levelWriter struct {
+	out io.Writer
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	msg := message(string(p))
+	if severity(msg) > int(threshold.Load()) && !strings.HasPrefix(msg, "TRACE[") {
+		return len(p), nil
+	}
+	remember(p)
+	return w.out.Write(p)
+}
+
+// message strips the date and time the standard logger puts before a line
+func message(line string) string {
+	if log.Flags()&(log.Ldate|log.Ltime) == 0 {
+		return line
+	}
+	for range 2 {
+		field, rest, found := strings.Cut(line, " ")
+		if !found || strings.Trim(field, "0123456789/:.") != "" {
+			break
+		}
+		line = rest
+	}
+	return line
+}
@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"strings"
+	"sync"
+)
+
+// recentLines is how many logged lines are kept for support bundles
+const recentLines = 1000
+
+// recent holds the last lines written to the log, after level filtering
+var recent = struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+}{lines: make([]string, 0, recentLines)}
+
+// remember keeps a logged line, replacing the oldest once full
+func remember(line []byte) {
+	text := strings.TrimRight(string(line), "\n")
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+	if len(recent.lines) < recentLines {
+		recent.lines = append(recent.lines, text)
+		return
+	}
+	recent.lines[recent.next] = text
+	recent.next = (recent.next + 1) % recentLines
+}
+
+// Recent returns the most recently logged lines, oldest first
+func Recent() []string {
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+	lines := make([]string, 0, len(recent.lines))
+	lines = append(lines, recent.lines[recent.next:]...)
+	return append(lines, recent.lines[:recent.next]...)
+}
@@ -0,0 +1,255 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// backupTimeFormat timestamps rotated files so they sort chronologically
+const backupTimeFormat = "20060102-150405.000"
+
+// RotatingFile is a log file that is rotated by size and age. Rotated files
+// are renamed to <name>.<timestamp>, optionally gzip-compressed, and removed
+// once they exceed the retention limits.
+type RotatingFile struct {
+	path string
+	cfg  config.LogRotationConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	cleanup chan struct{} // signals the background compress/prune worker
+	done    chan struct{} // closed when the worker has finished
+}
+
+// OpenFile opens (appending to) the log file at path, creating its
+// directory if needed
+func OpenFile(path string, cfg config.LogRotationConfig) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f := &RotatingFile{
+		path:    path,
+		cfg:     cfg,
+		cleanup: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.worker()
+	f.signalCleanup() // apply retention to files left by earlier runs
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+// Write appends p to the log file, rotating it first if p would exceed
+// the size limit or the rotation interval has passed
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.due(len(p)) {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing output
+			fmt.Fprintf(os.Stderr, "Log rotation failed: %v\n", err)
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// due reports whether the file must be rotated before writing n bytes
+func (f *RotatingFile) due(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if limit := int64(f.cfg.MaxSizeMB) << 20; limit > 0 && f.size+int64(n) > limit {
+		return true
+	}
+	return f.cfg.Interval > 0 && time.Since(f.opened) >= f.cfg.Interval
+}
+
+// Rotate closes the current file, renames it to a timestamped backup and
+// starts a new file
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	backup := f.path + "." + time.Now().Format(backupTimeFormat)
+	for i := 1; fileExists(backup) || fileExists(backup+".gz"); i++ {
+		backup = fmt.Sprintf("%s.%s-%d", f.path, time.Now().Format(backupTimeFormat), i)
+	}
+	renameErr := os.Rename(f.path, backup)
+	if err := f.open(); err != nil {
+		f.file = nil
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to rename log file: %w", renameErr)
+	}
+	f.signalCleanup()
+	return nil
+}
+
+// Close closes the log file after pending compression and pruning finish
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	if f.file == nil {
+		f.mu.Unlock()
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	close(f.cleanup)
+	f.mu.Unlock()
+
+	<-f.done
+	return err
+}
+
+func (f *RotatingFile) signalCleanup() {
+	select {
+	case f.cleanup <- struct{}{}:
+	default:
+	}
+}
+
+// worker compresses and prunes rotated files in the background so writes
+// never wait for it
+func (f *RotatingFile) worker() {
+	defer close(f.done)
+	for range f.cleanup {
+		backups, err := f.backups()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list rotated log files: %v\n", err)
+			continue
+		}
+		if f.cfg.Compress {
+			for i, name := range backups {
+				if strings.HasSuffix(name, ".gz") {
+					continue
+				}
+				if err := compressFile(name); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to compress %s: %v\n", name, err)
+					continue
+				}
+				backups[i] = name + ".gz"
+			}
+		}
+		f.prune(backups)
+	}
+}
+
+// backups returns the rotated files of the log, oldest first
+func (f *RotatingFile) backups() ([]string, error) {
+	dir, base := filepath.Split(f.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), base+".") {
+			names = append(names, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// prune removes rotated files beyond the backup count or older than the
+// maximum age
+func (f *RotatingFile) prune(backups []string) {
+	for i, name := range backups {
+		remove := f.cfg.MaxBackups > 0 && i < len(backups)-f.cfg.MaxBackups
+		if !remove && f.cfg.MaxAge > 0 {
+			if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > f.cfg.MaxAge {
+				remove = true
+			}
+		}
+		if remove {
+			if err := os.Remove(name); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to remove rotated log file %s: %v\n", name, err)
+			}
+		}
+	}
+}
+
+// compressFile gzips name to name.gz and removes the original
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(name)
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
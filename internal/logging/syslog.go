@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// Syslog facility codes by name
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// sdID identifies the structured data element carrying the configured
+// fields (32473 is the enterprise number reserved for documentation)
+const sdID = "mqtt@32473"
+
+// SyslogWriter sends each log line as an RFC 5424 message to a syslog
+// server over UDP, TCP (octet-counting framing) or a unix socket. The
+// connection is re-established when a write fails.
+type SyslogWriter struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+	appName  string
+	sd       string // structured data, "-" if there are no fields
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// DialSyslog connects to the configured syslog server
+func DialSyslog(cfg config.LoggingConfig) (*SyslogWriter, error) {
+	facility, ok := facilities[cfg.Syslog.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility: %s", cfg.Syslog.Facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	w := &SyslogWriter{
+		network:  cfg.Syslog.Network,
+		address:  cfg.Syslog.Address,
+		facility: facility,
+		hostname: hostname,
+		appName:  cfg.Identifier,
+		sd:       structuredData(cfg.Fields),
+	}
+	if err := w.dial(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *SyslogWriter) dial() error {
+	var conn net.Conn
+	var err error
+	if w.network == "unix" {
+		// The local syslog socket is usually a datagram socket
+		conn, err = net.Dial("unixgram", w.address)
+		if err != nil {
+			conn, err = net.Dial("unix", w.address)
+		}
+	} else {
+		conn, err = net.DialTimeout(w.network, w.address, 5*time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog at %s: %w", w.address, err)
+	}
+	w.conn = conn
+	return nil
+}
+
+// Write sends one log line, retrying once on a new connection
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - %s %s",
+		w.facility*8+severity(msg), time.Now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.appName, os.Getpid(), w.sd, msg)
+	if w.network == "tcp" {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn != nil {
+		if _, err := w.conn.Write([]byte(line)); err == nil {
+			return len(p), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	if err := w.dial(); err != nil {
+		return 0, err
+	}
+	if _, err := w.conn.Write([]byte(line)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection to the syslog server
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// structuredData formats fields as an RFC 5424 SD-ELEMENT
+func structuredData(fields map[string]string) string {
+	if len(fields) == 0 {
+		return "-"
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	var b strings.Builder
+	b.WriteString("[" + sdID)
+	for _, name := range names {
+		fmt.Fprintf(&b, ` %s="%s"`, name, escape.Replace(fields[name]))
+	}
+	b.WriteString("]")
+	return b.String()
+}
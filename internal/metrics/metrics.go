@@ -68,4 +68,366 @@ var (
 		},
 		[]string{"qos"},
 	)
+
+	// MemoryTrackedBytes tracks approximate message memory held by the broker
+	MemoryTrackedBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mqtt_memory_tracked_bytes",
+			Help: "Approximate bytes held by retained, queued and in-flight messages",
+		},
+		[]string{"kind"},
+	)
+
+	// OverloadActive is 1 while the broker is shedding load
+	OverloadActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mqtt_overload_active",
+		Help: "Whether the broker is in overload mode (1) or not (0)",
+	})
+
+	// OverloadShedMessages counts QoS 0 deliveries dropped in overload mode
+	OverloadShedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mqtt_overload_shed_messages_total",
+		Help: "Total QoS 0 deliveries dropped while in overload mode",
+	})
+
+	// OverloadRejectedConnections counts connections refused in overload mode
+	OverloadRejectedConnections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mqtt_overload_rejected_connections_total",
+		Help: "Total connections refused while in overload mode",
+	})
+
+	// BridgeLoopsDetected counts messages dropped because they looped back over a bridge
+	BridgeLoopsDetected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_bridge_loops_detected_total",
+			Help: "Total messages dropped because they returned over a bridge after being forwarded upstream",
+		},
+		[]string{"bridge"},
+	)
+
+	// BridgeMessagesBuffered tracks messages buffered for an unreachable upstream
+	BridgeMessagesBuffered = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mqtt_bridge_messages_buffered",
+			Help: "Number of messages buffered in the store while a bridge upstream is down",
+		},
+		[]string{"bridge"},
+	)
+
+	// BridgeMessagesDropped counts outbound bridge messages that could not be buffered
+	BridgeMessagesDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_bridge_messages_dropped_total",
+			Help: "Total outbound bridge messages dropped while the upstream was down",
+		},
+		[]string{"bridge"},
+	)
+
+	// TimeSeriesPoints counts points handled by the time-series exporter
+	TimeSeriesPoints = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_timeseries_points_total",
+			Help: "Time-series exporter points by result (written, failed, dropped, skipped)",
+		},
+		[]string{"result"},
+	)
+
+	// TopicCardinality tracks distinct topics published within the analytics window
+	TopicCardinality = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mqtt_topic_cardinality",
+			Help: "Distinct topics published within the analytics window",
+		},
+	)
+
+	// TopicGrowth tracks how fast new topics appear
+	TopicGrowth = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mqtt_topic_growth_per_hour",
+			Help: "New topics per hour over the last analytics interval",
+		},
+	)
+
+	// SlowConsumers tracks clients whose outbound queue is backed up
+	SlowConsumers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mqtt_slow_consumers",
+			Help: "Clients whose outbound queue has stayed above the slow consumer threshold",
+		},
+	)
+
+	// ClientsMigrated counts MQTT 5 clients sent to another node with a
+	// Server Reference, by what triggered the migration
+	ClientsMigrated = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_clients_migrated_total",
+			Help: "MQTT 5 clients disconnected with a Server Reference to rebalance load",
+		},
+		[]string{"trigger"},
+	)
+
+	// ContentTypeMismatches counts messages whose Content Type does not
+	// match the content type rule of their topic
+	ContentTypeMismatches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_content_type_mismatches_total",
+			Help: "Messages whose MQTT 5 Content Type does not match the rule of their topic",
+		},
+		[]string{"filter", "action"},
+	)
+
+	// SchemaValidations counts messages checked against registry schemas,
+	// by rule and result (valid, invalid or unavailable)
+	SchemaValidations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_schema_validations_total",
+			Help: "Messages validated against a schema registry schema, by rule filter and result",
+		},
+		[]string{"filter", "result"},
+	)
+
+	// SlowConsumerEvents counts clients flagged as slow consumers
+	SlowConsumerEvents = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mqtt_slow_consumer_events_total",
+			Help: "Total number of times a client was flagged as a slow consumer",
+		},
+	)
+
+	// TrafficAnomalies tracks clients and topic prefixes publishing at an
+	// anomalous rate
+	TrafficAnomalies = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mqtt_traffic_anomalies",
+			Help: "Clients and topic prefixes currently publishing at an anomalous rate",
+		},
+	)
+
+	// TrafficAnomalyEvents counts traffic anomalies detected, by kind
+	TrafficAnomalyEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_traffic_anomaly_events_total",
+			Help: "Total number of traffic anomalies detected, by kind (client or topic)",
+		},
+		[]string{"kind"},
+	)
+
+	// ClientLatency tracks client round-trip times sampled on PINGREQ
+	ClientLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mqtt_client_rtt_seconds",
+			Help:    "Client round-trip time (kernel TCP estimate) sampled on every PINGREQ",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		[]string{"listener"},
+	)
+
+	// AcceptErrors counts failed accepts per listener
+	AcceptErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_accept_errors_total",
+			Help: "Total errors accepting connections",
+		},
+		[]string{"listener"},
+	)
+
+	// RecoveredPanics counts panics recovered in connection handlers
+	RecoveredPanics = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mqtt_recovered_panics_total",
+			Help: "Total panics recovered in connection and delivery goroutines",
+		},
+	)
+
+	// KeepAliveTimeouts counts clients disconnected for missing their
+	// keepalive
+	KeepAliveTimeouts = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mqtt_keepalive_timeouts_total",
+			Help: "Total clients disconnected after sending nothing for one and a half keepalive periods",
+		},
+	)
+
+	// ConnectionsRefused counts CONNECT attempts refused or dropped before
+	// the client was accepted, by reason
+	ConnectionsRefused = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_connections_refused_total",
+			Help: "Total connection attempts refused before CONNECT completed",
+		},
+		[]string{"reason"},
+	)
+
+	// OfflineMessages counts messages queued for (or dropped instead of
+	// queued for) disconnected persistent sessions
+	OfflineMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_offline_messages_total",
+			Help: "Total messages for offline persistent sessions by result (queued, dropped)",
+		},
+		[]string{"result"},
+	)
+
+	// MaintenanceMessages counts publishes muted or rejected by maintenance
+	// windows
+	MaintenanceMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_maintenance_messages_total",
+			Help: "Total publishes muted or rejected by maintenance windows",
+		},
+		[]string{"window", "action"},
+	)
+
+	// MaintenanceWindowsActive tracks the maintenance windows in effect
+	MaintenanceWindowsActive = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mqtt_maintenance_windows_active",
+			Help: "Number of maintenance windows currently in effect",
+		},
+	)
+
+	// TopicPayloadSize tracks payload sizes of routed messages per
+	// configured topic prefix
+	TopicPayloadSize = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mqtt_topic_payload_bytes",
+			Help:    "Payload size of routed messages by configured topic prefix",
+			Buckets: prometheus.ExponentialBuckets(16, 4, 10), // 16 B to 4 MiB
+		},
+		[]string{"prefix"},
+	)
+
+	// TopicMessageRate tracks message rates per configured topic prefix,
+	// sampled every rate interval
+	TopicMessageRate = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mqtt_topic_message_rate",
+			Help:    "Messages per second routed by configured topic prefix, sampled every rate interval",
+			Buckets: []float64{0.1, 1, 5, 10, 50, 100, 500, 1000, 5000, 10000},
+		},
+		[]string{"prefix"},
+	)
+
+	// RetainedQuotaExceeded counts retained publishes beyond a client or
+	// tenant quota, by the action taken
+	RetainedQuotaExceeded = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_retained_quota_exceeded_total",
+			Help: "Retained publishes beyond a client or tenant quota, by action (rejected, evicted)",
+		},
+		[]string{"action"},
+	)
+
+	// ParserBudgetExceeded counts connections closed for sending packets
+	// or bytes faster than the parser budget, by phase and limit
+	ParserBudgetExceeded = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_parser_budget_exceeded_total",
+			Help: "Connections closed for exceeding the parser budget, by phase (preauth, connected) and limit (packets, bytes)",
+		},
+		[]string{"phase", "limit"},
+	)
+
+	// UsernameLimitRejections counts connections refused because their
+	// username already held limits.max_connections_per_username connections
+	UsernameLimitRejections = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mqtt_username_limit_rejections_total",
+			Help: "Total connections refused because their username was at its connection limit",
+		},
+	)
+
+	// SubscriptionsRejected counts topic filters rejected in a SUBACK, by
+	// reason
+	SubscriptionsRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_subscriptions_rejected_total",
+			Help: "Total subscriptions rejected, by reason (invalid_filter, not_authorized, quota_exceeded)",
+		},
+		[]string{"reason"},
+	)
+
+	// BridgeMessagesDenied counts messages dropped because their topic is
+	// outside the bridge's allowed_topics
+	BridgeMessagesDenied = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_bridge_messages_denied_total",
+			Help: "Total bridge messages dropped because their topic is not allowed on the link, by bridge and direction",
+		},
+		[]string{"bridge", "direction"},
+	)
+
+	// StoreUp is 1 while the last store health check succeeded
+	StoreUp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mqtt_store_up",
+			Help: "Whether the last store health check succeeded (1) or failed (0)",
+		},
+	)
+
+	// ReservedTopicDenied counts publishes refused because the topic is
+	// reserved for other clients, by reserved filter
+	ReservedTopicDenied = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_reserved_topic_denied_total",
+			Help: "Total publishes refused on reserved topic spaces",
+		},
+		[]string{"filter"},
+	)
+
+	// TLSHandshakes counts completed TLS handshakes by whether they resumed
+	// an earlier session
+	TLSHandshakes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_tls_handshakes_total",
+			Help: "Total completed TLS handshakes, by whether a session was resumed",
+		},
+		[]string{"resumed"},
+	)
+
+	// UsageQuotaRejected counts publishes dropped because their tenant or
+	// user is over its daily quota
+	UsageQuotaRejected = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mqtt_usage_quota_rejected_total",
+			Help: "Total publishes dropped over a daily message or byte quota",
+		},
+	)
+
+	// TranscodedMessages counts payloads converted between JSON and a
+	// binary format
+	TranscodedMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_transcoded_messages_total",
+			Help: "Payloads converted between JSON and a binary format, by format and direction (encode, decode)",
+		},
+		[]string{"format", "direction"},
+	)
+
+	// TranscodingFailures counts payloads that could not be converted
+	TranscodingFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_transcoding_failures_total",
+			Help: "Payloads that could not be converted between JSON and a binary format, by format and direction",
+		},
+		[]string{"format", "direction"},
+	)
+
+	// MirroredMessages counts messages copied into shadow namespaces
+	MirroredMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mqtt_mirrored_messages_total",
+			Help: "Total messages mirrored into a shadow namespace, by prefix",
+		},
+		[]string{"prefix"},
+	)
+
+	// BuildInfo is always 1, labelled with the broker's build information
+	BuildInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mqtt_build_info",
+			Help: "Broker build information: version, commit, build date and Go version (always 1)",
+		},
+		[]string{"version", "commit", "build_date", "go_version"},
+	)
 )
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// Handler serves the Prometheus metrics, requiring basic auth or a bearer
+// token if configured
+func Handler(cfg config.MetricsConfig) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, promhttp.Handler())
+	if cfg.Username == "" && cfg.BearerToken == "" {
+		return mux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(cfg, r) {
+			if cfg.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// authorized checks the request's credentials in constant time
+func authorized(cfg config.MetricsConfig, r *http.Request) bool {
+	if cfg.BearerToken != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.BearerToken)) == 1
+		}
+	}
+	if cfg.Username != "" {
+		if user, pass, ok := r.BasicAuth(); ok {
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(cfg.Username)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.Password)) == 1
+			return userOK && passOK
+		}
+	}
+	return false
+}
+
+// ListenAndServe serves the metrics endpoint, over HTTPS if a certificate
+// is configured, until the listener fails
+func ListenAndServe(cfg config.MetricsConfig) error {
+	srv := &http.Server{
+		Addr:    net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Handler: Handler(cfg),
+	}
+	if cfg.CertFile != "" {
+		if err := srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile); err != nil {
+			return fmt.Errorf("metrics server: %w", err)
+		}
+		return nil
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		return fmt.Errorf("metrics server: %w", err)
+	}
+	return nil
+}
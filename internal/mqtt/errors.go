@@ -0,0 +1,57 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrMalformedPacket matches every MalformedPacketError with errors.Is
+var ErrMalformedPacket = errors.New("malformed packet")
+
+// MalformedPacketError reports a packet that could not be decoded because it
+// is truncated or violates the protocol
+type MalformedPacketError struct {
+	Type  PacketType // Packet being decoded (0 while reading the fixed header)
+	Field string     // Field that could not be decoded
+	Err   error      // Underlying cause, e.g. io.ErrUnexpectedEOF; may be nil
+}
+
+func (e *MalformedPacketError) Error() string {
+	name := "fixed header"
+	if e.Type != 0 {
+		name = e.Type.String()
+	}
+	if e.Err == nil {
+		return fmt.Sprintf("malformed %s: invalid %s", name, e.Field)
+	}
+	return fmt.Sprintf("malformed %s: failed to read %s: %v", name, e.Field, e.Err)
+}
+
+func (e *MalformedPacketError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrMalformedPacket
+func (e *MalformedPacketError) Is(target error) bool { return target == ErrMalformedPacket }
+
+// ErrInvalidString is returned for strings that are not well-formed UTF-8
+// (including encoded UTF-16 surrogates) or that contain U+0000
+var ErrInvalidString = errors.New("invalid UTF-8 string")
+
+// ValidateString checks that s is a valid MQTT UTF-8 string: topic names,
+// topic filters, client IDs and other protocol strings must be well-formed
+// UTF-8 and must not contain the null character
+func ValidateString(s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("%w: not well-formed UTF-8", ErrInvalidString)
+	}
+	if strings.IndexByte(s, 0) >= 0 {
+		return fmt.Errorf("%w: contains U+0000", ErrInvalidString)
+	}
+	return nil
+}
+
+// malformed returns a MalformedPacketError for a field of a packet
+func malformed(t PacketType, field string, err error) error {
+	return &MalformedPacketError{Type: t, Field: field, Err: err}
+}
@@ -2,6 +2,7 @@ package mqtt
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 )
@@ -79,60 +80,112 @@ type ConnectPacket struct {
 	WillMessage     []byte
 	Username        string
 	Password        []byte
+	Properties      Properties // MQTT 5 only
+	WillProperties  Properties // MQTT 5 only
 }
 
 func (c *ConnectPacket) Type() PacketType { return CONNECT }
 
 // ConnackPacket represents a CONNACK packet
 type ConnackPacket struct {
-	SessionPresent bool
-	ReturnCode     byte
+	SessionPresent bool // Not defined in MQTT 3.1; must stay false for those clients
+	ReturnCode     byte // 3.1.1 return code, or MQTT 5 reason code when Version is 5
+	Version        byte
+	Properties     Properties // MQTT 5 only
 }
 
 func (c *ConnackPacket) Type() PacketType { return CONNACK }
 
 // Encode creates a CONNACK packet bytes
 func (c *ConnackPacket) Encode() ([]byte, error) {
-	buf := make([]byte, 4)
-	buf[0] = byte(CONNACK) << 4 // Fixed header
-	buf[1] = 2                  // Remaining length
+	var flags byte
 	if c.SessionPresent {
-		buf[2] = 1
+		flags = 1
 	}
-	buf[3] = c.ReturnCode
-	return buf, nil
+	body := []byte{flags, c.ReturnCode}
+	if c.Version == ProtocolV5 {
+		body = append(body, c.Properties.Encode()...)
+	}
+	return appendFixedHeader(byte(CONNACK)<<4, body), nil
 }
 
 // PublishPacket represents a PUBLISH packet
 type PublishPacket struct {
-	Dup      bool
-	QoS      byte
-	Retain   bool
-	Topic    string
-	PacketID uint16
-	Payload  []byte
+	Dup        bool
+	QoS        byte
+	Retain     bool
+	Topic      string
+	PacketID   uint16
+	Payload    []byte
+	Properties Properties // MQTT 5 only
 }
 
 func (p *PublishPacket) Type() PacketType { return PUBLISH }
 
 func (p *PublishPacket) Encode() ([]byte, error) {
 	// TODO: Implement full PUBLISH encoding
-	return nil, fmt.Errorf("PUBLISH encoding not yet implemented")
+	return nil, fmt.Errorf("PUBLISH encoding: %w", errors.ErrUnsupported)
 }
 
 // PubackPacket represents a PUBACK packet
 type PubackPacket struct {
-	PacketID uint16
+	PacketID   uint16
+	ReasonCode byte // MQTT 5 only; omitted from the packet when zero
 }
 
 func (p *PubackPacket) Type() PacketType { return PUBACK }
 
 func (p *PubackPacket) Encode() ([]byte, error) {
-	buf := make([]byte, 4)
-	buf[0] = byte(PUBACK) << 4
-	buf[1] = 2
-	binary.BigEndian.PutUint16(buf[2:], p.PacketID)
-	return buf, nil
+	return encodeAck(byte(PUBACK)<<4, p.PacketID, p.ReasonCode), nil
+}
+
+// PubrecPacket represents a PUBREC packet, the receiver's reply to a QoS 2
+// PUBLISH
+type PubrecPacket struct {
+	PacketID   uint16
+	ReasonCode byte // MQTT 5 only; 0x80 and above end the QoS 2 flow
+}
+
+func (p *PubrecPacket) Type() PacketType { return PUBREC }
+
+func (p *PubrecPacket) Encode() ([]byte, error) {
+	return encodeAck(byte(PUBREC)<<4, p.PacketID, p.ReasonCode), nil
+}
+
+// PubrelPacket represents a PUBREL packet, the sender's reply to a PUBREC
+type PubrelPacket struct {
+	PacketID   uint16
+	ReasonCode byte // MQTT 5 only
+}
+
+func (p *PubrelPacket) Type() PacketType { return PUBREL }
+
+func (p *PubrelPacket) Encode() ([]byte, error) {
+	return encodeAck(byte(PUBREL)<<4|0x02, p.PacketID, p.ReasonCode), nil
+}
+
+// PubcompPacket represents a PUBCOMP packet, which completes a QoS 2
+// delivery
+type PubcompPacket struct {
+	PacketID   uint16
+	ReasonCode byte // MQTT 5 only
+}
+
+func (p *PubcompPacket) Type() PacketType { return PUBCOMP }
+
+func (p *PubcompPacket) Encode() ([]byte, error) {
+	return encodeAck(byte(PUBCOMP)<<4, p.PacketID, p.ReasonCode), nil
+}
+
+// encodeAck encodes a PUBACK, PUBREC, PUBREL or PUBCOMP. The reason code is
+// omitted when it is success, which also keeps the packet valid for MQTT
+// 3.1.1.
+func encodeAck(first byte, packetID uint16, reason byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	if reason != ReasonSuccess {
+		body = append(body, reason) // no properties
+	}
+	return appendFixedHeader(first, body)
 }
 
 // SubscribePacket represents a SUBSCRIBE packet
@@ -142,8 +195,11 @@ type SubscribePacket struct {
 }
 
 type Subscription struct {
-	Topic string
-	QoS   byte
+	Topic             string
+	QoS               byte
+	NoLocal           bool // MQTT 5: do not deliver the client's own messages
+	RetainAsPublished bool // MQTT 5: keep the RETAIN flag on live deliveries
+	RetainHandling    byte // MQTT 5: 0 = send retained, 1 = only for new subscriptions, 2 = never
 }
 
 func (s *SubscribePacket) Type() PacketType { return SUBSCRIBE }
@@ -152,17 +208,18 @@ func (s *SubscribePacket) Type() PacketType { return SUBSCRIBE }
 type SubackPacket struct {
 	PacketID    uint16
 	ReturnCodes []byte
+	Version     byte
 }
 
 func (s *SubackPacket) Type() PacketType { return SUBACK }
 
 func (s *SubackPacket) Encode() ([]byte, error) {
-	buf := make([]byte, 2+2+len(s.ReturnCodes))
-	buf[0] = byte(SUBACK) << 4
-	buf[1] = byte(2 + len(s.ReturnCodes))
-	binary.BigEndian.PutUint16(buf[2:], s.PacketID)
-	copy(buf[4:], s.ReturnCodes)
-	return buf, nil
+	body := binary.BigEndian.AppendUint16(nil, s.PacketID)
+	if s.Version == ProtocolV5 {
+		body = append(body, 0) // no properties
+	}
+	body = append(body, s.ReturnCodes...)
+	return appendFixedHeader(byte(SUBACK)<<4, body), nil
 }
 
 // UnsubscribePacket represents an UNSUBSCRIBE packet
@@ -173,19 +230,73 @@ type UnsubscribePacket struct {
 
 func (u *UnsubscribePacket) Type() PacketType { return UNSUBSCRIBE }
 
+// DisconnectPacket represents a DISCONNECT packet. Reason codes and
+// properties only exist in MQTT 5; older clients never receive one from the
+// broker.
+type DisconnectPacket struct {
+	Version    byte
+	ReasonCode byte
+	Properties Properties // MQTT 5 only
+}
+
+func (d *DisconnectPacket) Type() PacketType { return DISCONNECT }
+
+func (d *DisconnectPacket) Encode() ([]byte, error) {
+	var body []byte
+	if d.Version == ProtocolV5 {
+		body = append([]byte{d.ReasonCode}, d.Properties.Encode()...)
+	}
+	return appendFixedHeader(byte(DISCONNECT)<<4, body), nil
+}
+
+// DecodeDisconnectPacket decodes a DISCONNECT packet. An MQTT 5 DISCONNECT
+// without a body has reason code 0x00 (normal disconnection); older
+// protocol versions have no body.
+func DecodeDisconnectPacket(r io.Reader, remainingLen int, version byte) (*DisconnectPacket, error) {
+	pkt := &DisconnectPacket{Version: version}
+	if version != ProtocolV5 || remainingLen == 0 {
+		return pkt, nil
+	}
+	var reason [1]byte
+	if _, err := io.ReadFull(r, reason[:]); err != nil {
+		return nil, malformed(DISCONNECT, "reason code", err)
+	}
+	pkt.ReasonCode = reason[0]
+	if remainingLen > 1 {
+		props, n, err := ReadProperties(r)
+		if err != nil {
+			return nil, malformed(DISCONNECT, "properties", err)
+		}
+		if 1+n != remainingLen {
+			return nil, malformed(DISCONNECT, "properties", io.ErrUnexpectedEOF)
+		}
+		pkt.Properties = props
+	}
+	return pkt, nil
+}
+
 // UnsubackPacket represents an UNSUBACK packet
 type UnsubackPacket struct {
-	PacketID uint16
+	PacketID    uint16
+	Version     byte
+	ReasonCodes []byte // MQTT 5 only, one per topic filter
 }
 
 func (u *UnsubackPacket) Type() PacketType { return UNSUBACK }
 
 func (u *UnsubackPacket) Encode() ([]byte, error) {
-	buf := make([]byte, 4)
-	buf[0] = byte(UNSUBACK) << 4
-	buf[1] = 2
-	binary.BigEndian.PutUint16(buf[2:], u.PacketID)
-	return buf, nil
+	body := binary.BigEndian.AppendUint16(nil, u.PacketID)
+	if u.Version == ProtocolV5 {
+		body = append(body, 0) // no properties
+		body = append(body, u.ReasonCodes...)
+	}
+	return appendFixedHeader(byte(UNSUBACK)<<4, body), nil
+}
+
+// appendFixedHeader prefixes a packet body with its fixed header
+func appendFixedHeader(first byte, body []byte) []byte {
+	buf := append([]byte{first}, EncodeVarInt(len(body))...)
+	return append(buf, body...)
 }
 
 // PingrespPacket represents a PINGRESP packet
@@ -222,7 +333,7 @@ func ReadFixedHeader(r io.Reader) (*FixedHeader, error) {
 		}
 		multiplier *= 128
 		if multiplier > 128*128*128 {
-			return nil, fmt.Errorf("malformed remaining length")
+			return nil, malformed(0, "remaining length", nil)
 		}
 	}
 	header.RemainingLen = value
@@ -230,7 +341,9 @@ func ReadFixedHeader(r io.Reader) (*FixedHeader, error) {
 	return header, nil
 }
 
-// ReadString reads a UTF-8 encoded string (length-prefixed)
+// ReadString reads a UTF-8 encoded string (length-prefixed). Strings that
+// are not well-formed UTF-8 or contain U+0000 are rejected with
+// ErrInvalidString.
 func ReadString(r io.Reader) (string, error) {
 	lenBuf := make([]byte, 2)
 	if _, err := io.ReadFull(r, lenBuf); err != nil {
@@ -246,7 +359,11 @@ func ReadString(r io.Reader) (string, error) {
 	if _, err := io.ReadFull(r, strBuf); err != nil {
 		return "", err
 	}
-	return string(strBuf), nil
+	s := string(strBuf)
+	if err := ValidateString(s); err != nil {
+		return "", err
+	}
+	return s, nil
 }
 
 // WriteString writes a UTF-8 encoded string (length-prefixed)
@@ -264,21 +381,21 @@ func DecodeConnectPacket(r io.Reader, remainingLen int) (*ConnectPacket, error)
 	// Read protocol name
 	protocolName, err := ReadString(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read protocol name: %w", err)
+		return nil, malformed(CONNECT, "protocol name", err)
 	}
 	pkt.ProtocolName = protocolName
 
 	// Read protocol version
 	versionBuf := make([]byte, 1)
 	if _, err := io.ReadFull(r, versionBuf); err != nil {
-		return nil, err
+		return nil, malformed(CONNECT, "protocol version", err)
 	}
 	pkt.ProtocolVersion = versionBuf[0]
 
 	// Read connect flags
 	flagsBuf := make([]byte, 1)
 	if _, err := io.ReadFull(r, flagsBuf); err != nil {
-		return nil, err
+		return nil, malformed(CONNECT, "connect flags", err)
 	}
 	flags := flagsBuf[0]
 	pkt.UsernameFlag = (flags & 0x80) > 0
@@ -291,31 +408,42 @@ func DecodeConnectPacket(r io.Reader, remainingLen int) (*ConnectPacket, error)
 	// Read keep alive
 	keepAliveBuf := make([]byte, 2)
 	if _, err := io.ReadFull(r, keepAliveBuf); err != nil {
-		return nil, err
+		return nil, malformed(CONNECT, "keep alive", err)
 	}
 	pkt.KeepAlive = binary.BigEndian.Uint16(keepAliveBuf)
 
+	if pkt.ProtocolVersion == ProtocolV5 {
+		if pkt.Properties, _, err = ReadProperties(r); err != nil {
+			return nil, malformed(CONNECT, "properties", err)
+		}
+	}
+
 	// Read client ID
 	clientID, err := ReadString(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read client ID: %w", err)
+		return nil, malformed(CONNECT, "client ID", err)
 	}
 	pkt.ClientID = clientID
 
 	// Read will topic and message if present
 	if pkt.WillFlag {
+		if pkt.ProtocolVersion == ProtocolV5 {
+			if pkt.WillProperties, _, err = ReadProperties(r); err != nil {
+				return nil, malformed(CONNECT, "will properties", err)
+			}
+		}
 		pkt.WillTopic, err = ReadString(r)
 		if err != nil {
-			return nil, err
+			return nil, malformed(CONNECT, "will topic", err)
 		}
 		willMsgLen := make([]byte, 2)
 		if _, err := io.ReadFull(r, willMsgLen); err != nil {
-			return nil, err
+			return nil, malformed(CONNECT, "will message length", err)
 		}
 		msgLen := binary.BigEndian.Uint16(willMsgLen)
 		pkt.WillMessage = make([]byte, msgLen)
 		if _, err := io.ReadFull(r, pkt.WillMessage); err != nil {
-			return nil, err
+			return nil, malformed(CONNECT, "will message", err)
 		}
 	}
 
@@ -323,7 +451,7 @@ func DecodeConnectPacket(r io.Reader, remainingLen int) (*ConnectPacket, error)
 	if pkt.UsernameFlag {
 		pkt.Username, err = ReadString(r)
 		if err != nil {
-			return nil, err
+			return nil, malformed(CONNECT, "username", err)
 		}
 	}
 
@@ -331,20 +459,21 @@ func DecodeConnectPacket(r io.Reader, remainingLen int) (*ConnectPacket, error)
 	if pkt.PasswordFlag {
 		pwdLen := make([]byte, 2)
 		if _, err := io.ReadFull(r, pwdLen); err != nil {
-			return nil, err
+			return nil, malformed(CONNECT, "password length", err)
 		}
 		passLen := binary.BigEndian.Uint16(pwdLen)
 		pkt.Password = make([]byte, passLen)
 		if _, err := io.ReadFull(r, pkt.Password); err != nil {
-			return nil, err
+			return nil, malformed(CONNECT, "password", err)
 		}
 	}
 
 	return pkt, nil
 }
 
-// DecodePublishPacket decodes a PUBLISH packet
-func DecodePublishPacket(r io.Reader, header *FixedHeader) (*PublishPacket, error) {
+// DecodePublishPacket decodes a PUBLISH packet sent by a client using the
+// given protocol version
+func DecodePublishPacket(r io.Reader, header *FixedHeader, version byte) (*PublishPacket, error) {
 	pkt := &PublishPacket{
 		Dup:    (header.Flags & 0x08) > 0,
 		QoS:    (header.Flags >> 1) & 0x03,
@@ -354,7 +483,7 @@ func DecodePublishPacket(r io.Reader, header *FixedHeader) (*PublishPacket, erro
 	// Read topic name
 	topic, err := ReadString(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read topic: %w", err)
+		return nil, malformed(PUBLISH, "topic", err)
 	}
 	pkt.Topic = topic
 
@@ -363,18 +492,30 @@ func DecodePublishPacket(r io.Reader, header *FixedHeader) (*PublishPacket, erro
 	if pkt.QoS > 0 {
 		packetIDBuf := make([]byte, 2)
 		if _, err := io.ReadFull(r, packetIDBuf); err != nil {
-			return nil, err
+			return nil, malformed(PUBLISH, "packet ID", err)
 		}
 		pkt.PacketID = binary.BigEndian.Uint16(packetIDBuf)
 		bytesRead += 2
 	}
 
+	if version == ProtocolV5 {
+		props, n, err := ReadProperties(r)
+		if err != nil {
+			return nil, malformed(PUBLISH, "properties", err)
+		}
+		pkt.Properties = props
+		bytesRead += n
+	}
+
 	// Read payload (remaining bytes)
 	payloadLen := header.RemainingLen - bytesRead
+	if payloadLen < 0 {
+		return nil, malformed(PUBLISH, "remaining length", nil)
+	}
 	if payloadLen > 0 {
 		pkt.Payload = make([]byte, payloadLen)
 		if _, err := io.ReadFull(r, pkt.Payload); err != nil {
-			return nil, err
+			return nil, malformed(PUBLISH, "payload", err)
 		}
 	}
 
@@ -382,36 +523,54 @@ func DecodePublishPacket(r io.Reader, header *FixedHeader) (*PublishPacket, erro
 }
 
 // DecodeSubscribePacket decodes a SUBSCRIBE packet
-func DecodeSubscribePacket(r io.Reader, remainingLen int) (*SubscribePacket, error) {
+func DecodeSubscribePacket(r io.Reader, remainingLen int, version byte) (*SubscribePacket, error) {
 	pkt := &SubscribePacket{}
 
 	// Read packet ID
 	packetIDBuf := make([]byte, 2)
 	if _, err := io.ReadFull(r, packetIDBuf); err != nil {
-		return nil, err
+		return nil, malformed(SUBSCRIBE, "packet ID", err)
 	}
 	pkt.PacketID = binary.BigEndian.Uint16(packetIDBuf)
 
 	bytesRead := 2
+	if version == ProtocolV5 {
+		_, n, err := ReadProperties(r)
+		if err != nil {
+			return nil, malformed(SUBSCRIBE, "properties", err)
+		}
+		bytesRead += n
+	}
 
 	// Read topic filters
 	for bytesRead < remainingLen {
 		topic, err := ReadString(r)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read topic filter: %w", err)
+			return nil, malformed(SUBSCRIBE, "topic filter", err)
 		}
 		bytesRead += 2 + len(topic)
 
-		// Read QoS
+		// Read QoS (subscription options in MQTT 5)
 		qosBuf := make([]byte, 1)
 		if _, err := io.ReadFull(r, qosBuf); err != nil {
-			return nil, err
+			return nil, malformed(SUBSCRIBE, "requested QoS", err)
 		}
 		bytesRead++
+		options := qosBuf[0]
+		reserved := byte(0xFC)
+		if version == ProtocolV5 {
+			reserved = 0xC0
+		}
+		if options&reserved != 0 || options&0x03 > 2 || (options>>4)&0x03 > 2 {
+			return nil, malformed(SUBSCRIBE, "subscription options", nil)
+		}
 
 		pkt.Topics = append(pkt.Topics, Subscription{
-			Topic: topic,
-			QoS:   qosBuf[0],
+			Topic:             topic,
+			QoS:               options & 0x03,
+			NoLocal:           options&0x04 != 0,
+			RetainAsPublished: options&0x08 != 0,
+			RetainHandling:    (options >> 4) & 0x03,
 		})
 	}
 
@@ -419,23 +578,30 @@ func DecodeSubscribePacket(r io.Reader, remainingLen int) (*SubscribePacket, err
 }
 
 // DecodeUnsubscribePacket decodes an UNSUBSCRIBE packet
-func DecodeUnsubscribePacket(r io.Reader, remainingLen int) (*UnsubscribePacket, error) {
+func DecodeUnsubscribePacket(r io.Reader, remainingLen int, version byte) (*UnsubscribePacket, error) {
 	pkt := &UnsubscribePacket{}
 
 	// Read packet ID
 	packetIDBuf := make([]byte, 2)
 	if _, err := io.ReadFull(r, packetIDBuf); err != nil {
-		return nil, err
+		return nil, malformed(UNSUBSCRIBE, "packet ID", err)
 	}
 	pkt.PacketID = binary.BigEndian.Uint16(packetIDBuf)
 
 	bytesRead := 2
+	if version == ProtocolV5 {
+		_, n, err := ReadProperties(r)
+		if err != nil {
+			return nil, malformed(UNSUBSCRIBE, "properties", err)
+		}
+		bytesRead += n
+	}
 
 	// Read topic filters
 	for bytesRead < remainingLen {
 		topic, err := ReadString(r)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read topic filter: %w", err)
+			return nil, malformed(UNSUBSCRIBE, "topic filter", err)
 		}
 		bytesRead += 2 + len(topic)
 
@@ -444,3 +610,61 @@ func DecodeUnsubscribePacket(r io.Reader, remainingLen int) (*UnsubscribePacket,
 
 	return pkt, nil
 }
+
+// DecodePubackPacket decodes a PUBACK packet. The MQTT 5 reason code is kept;
+// its properties are ignored.
+func DecodePubackPacket(r io.Reader, remainingLen int) (*PubackPacket, error) {
+	packetID, reason, err := decodeAck(PUBACK, r, remainingLen)
+	if err != nil {
+		return nil, err
+	}
+	return &PubackPacket{PacketID: packetID, ReasonCode: reason}, nil
+}
+
+// DecodePubrecPacket decodes a PUBREC packet like DecodePubackPacket
+func DecodePubrecPacket(r io.Reader, remainingLen int) (*PubrecPacket, error) {
+	packetID, reason, err := decodeAck(PUBREC, r, remainingLen)
+	if err != nil {
+		return nil, err
+	}
+	return &PubrecPacket{PacketID: packetID, ReasonCode: reason}, nil
+}
+
+// DecodePubrelPacket decodes a PUBREL packet like DecodePubackPacket. Its
+// fixed header flags must be 0010.
+func DecodePubrelPacket(r io.Reader, header *FixedHeader) (*PubrelPacket, error) {
+	if header.Flags != 0x02 {
+		return nil, malformed(PUBREL, "flags", nil)
+	}
+	packetID, reason, err := decodeAck(PUBREL, r, header.RemainingLen)
+	if err != nil {
+		return nil, err
+	}
+	return &PubrelPacket{PacketID: packetID, ReasonCode: reason}, nil
+}
+
+// DecodePubcompPacket decodes a PUBCOMP packet like DecodePubackPacket
+func DecodePubcompPacket(r io.Reader, remainingLen int) (*PubcompPacket, error) {
+	packetID, reason, err := decodeAck(PUBCOMP, r, remainingLen)
+	if err != nil {
+		return nil, err
+	}
+	return &PubcompPacket{PacketID: packetID, ReasonCode: reason}, nil
+}
+
+// decodeAck reads the packet ID and MQTT 5 reason code of a PUBACK, PUBREC,
+// PUBREL or PUBCOMP
+func decodeAck(kind PacketType, r io.Reader, remainingLen int) (uint16, byte, error) {
+	if remainingLen < 2 {
+		return 0, 0, malformed(kind, "packet ID", io.ErrUnexpectedEOF)
+	}
+	buf := make([]byte, remainingLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, 0, malformed(kind, "packet ID", err)
+	}
+	var reason byte
+	if remainingLen > 2 {
+		reason = buf[2]
+	}
+	return binary.BigEndian.Uint16(buf), reason, nil
+}
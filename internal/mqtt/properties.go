@@ -0,0 +1,306 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MQTT 5 property identifiers used by the broker
+const (
+	PropPayloadFormat          byte = 0x01
+	PropMessageExpiry          byte = 0x02
+	PropContentType            byte = 0x03
+	PropResponseTopic          byte = 0x08
+	PropCorrelationData        byte = 0x09
+	PropSubscriptionIdentifier byte = 0x0B
+	PropSessionExpiryInterval  byte = 0x11
+	PropAssignedClientID       byte = 0x12
+	PropServerKeepAlive        byte = 0x13
+	PropWillDelayInterval      byte = 0x18
+	PropServerReference        byte = 0x1C
+	PropReasonString           byte = 0x1F
+	PropReceiveMaximum         byte = 0x21
+	PropTopicAlias             byte = 0x23
+	PropMaximumQoS             byte = 0x24
+	PropUserProperty           byte = 0x26
+	PropSharedSubAvailable     byte = 0x2A
+)
+
+// MQTT 5 reason codes used by the broker
+const (
+	ReasonSuccess                   byte = 0x00
+	ReasonDisconnectWithWill        byte = 0x04
+	ReasonNoSubscriptionExisted     byte = 0x11
+	ReasonUnspecifiedError          byte = 0x80
+	ReasonMalformedPacket           byte = 0x81
+	ReasonProtocolError             byte = 0x82
+	ReasonImplementationSpecificErr byte = 0x83
+	ReasonUnsupportedProtocol       byte = 0x84
+	ReasonClientIdentifierNotValid  byte = 0x85
+	ReasonBadUserNameOrPassword     byte = 0x86
+	ReasonNotAuthorized             byte = 0x87
+	ReasonServerUnavailable         byte = 0x88
+	ReasonServerShuttingDown        byte = 0x8B
+	ReasonKeepAliveTimeout          byte = 0x8D
+	ReasonSessionTakenOver          byte = 0x8E
+	ReasonTopicFilterInvalid        byte = 0x8F
+	ReasonTopicNameInvalid          byte = 0x90
+	ReasonPacketIDNotFound          byte = 0x92
+	ReasonQuotaExceeded             byte = 0x97
+	ReasonAdministrativeAction      byte = 0x98
+	ReasonPayloadFormatInvalid      byte = 0x99
+	ReasonQoSNotSupported           byte = 0x9B
+	ReasonUseAnotherServer          byte = 0x9C
+)
+
+// propertyKind is the wire encoding of a property value
+type propertyKind byte
+
+const (
+	propByte propertyKind = iota
+	propUint16
+	propUint32
+	propVarInt
+	propString
+	propBinary
+	propStringPair
+)
+
+// propertyKinds lists every property defined by MQTT 5
+var propertyKinds = map[byte]propertyKind{
+	0x01: propByte, 0x02: propUint32, 0x03: propString, 0x08: propString,
+	0x09: propBinary, 0x0B: propVarInt, 0x11: propUint32, 0x12: propString,
+	0x13: propUint16, 0x15: propString, 0x16: propBinary, 0x17: propByte,
+	0x18: propUint32, 0x19: propByte, 0x1A: propString, 0x1C: propString,
+	0x1F: propString, 0x21: propUint16, 0x22: propUint16, 0x23: propUint16,
+	0x24: propByte, 0x25: propByte, 0x26: propStringPair, 0x27: propUint32,
+	0x28: propByte, 0x29: propByte, 0x2A: propByte,
+}
+
+// errUnknownProperty is returned for property identifiers MQTT 5 does not define
+var errUnknownProperty = errors.New("unknown property")
+
+// Property is a single MQTT 5 property with its value in wire encoding
+type Property struct {
+	ID    byte
+	Value []byte
+}
+
+// Properties is the property list of an MQTT 5 packet, in wire order
+type Properties []Property
+
+// Get returns the value of the first property with the given identifier
+func (p Properties) Get(id byte) ([]byte, bool) {
+	for _, prop := range p {
+		if prop.ID == id {
+			return prop.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Uint32 returns the value of a four byte integer property
+func (p Properties) Uint32(id byte) (uint32, bool) {
+	v, ok := p.Get(id)
+	if !ok || len(v) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(v), true
+}
+
+// StringValue returns the value of a UTF-8 string property
+func (p Properties) StringValue(id byte) (string, bool) {
+	v, ok := p.Get(id)
+	if !ok || len(v) < 2 || int(binary.BigEndian.Uint16(v)) != len(v)-2 {
+		return "", false
+	}
+	return string(v[2:]), true
+}
+
+// Without returns the properties except those with the given identifiers
+func (p Properties) Without(ids ...byte) Properties {
+	var out Properties
+	for _, prop := range p {
+		if !bytes.Contains(ids, []byte{prop.ID}) {
+			out = append(out, prop)
+		}
+	}
+	return out
+}
+
+// Encode returns the property list prefixed with its variable byte length
+func (p Properties) Encode() []byte {
+	var body []byte
+	for _, prop := range p {
+		body = append(body, prop.ID)
+		body = append(body, prop.Value...)
+	}
+	return append(EncodeVarInt(len(body)), body...)
+}
+
+// ByteProperty returns a single byte property
+func ByteProperty(id, v byte) Property {
+	return Property{ID: id, Value: []byte{v}}
+}
+
+// Uint16Property returns a two byte integer property
+func Uint16Property(id byte, v uint16) Property {
+	return Property{ID: id, Value: binary.BigEndian.AppendUint16(nil, v)}
+}
+
+// Uint32Property returns a four byte integer property
+func Uint32Property(id byte, v uint32) Property {
+	return Property{ID: id, Value: binary.BigEndian.AppendUint32(nil, v)}
+}
+
+// StringProperty returns a UTF-8 string property
+func StringProperty(id byte, s string) Property {
+	return Property{ID: id, Value: WriteString(s)}
+}
+
+// UserProperty returns a user property (a UTF-8 string pair)
+func UserProperty(key, value string) Property {
+	return Property{ID: PropUserProperty, Value: append(WriteString(key), WriteString(value)...)}
+}
+
+// UserProperty returns the key and value of a user property
+func (p Property) UserProperty() (key, value string, ok bool) {
+	if p.ID != PropUserProperty {
+		return "", "", false
+	}
+	r := bytes.NewReader(p.Value)
+	key, err := ReadString(r)
+	if err != nil {
+		return "", "", false
+	}
+	value, err = ReadString(r)
+	if err != nil {
+		return "", "", false
+	}
+	return key, value, true
+}
+
+// ReadProperties reads a property list and returns it with the number of
+// bytes consumed, including the length prefix
+func ReadProperties(r io.Reader) (Properties, int, error) {
+	length, n, err := ReadVarInt(r)
+	if err != nil {
+		return nil, n, err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, n, err
+	}
+
+	var props Properties
+	for len(data) > 0 {
+		id := data[0]
+		kind, ok := propertyKinds[id]
+		if !ok {
+			return nil, n + length, fmt.Errorf("%w 0x%02x", errUnknownProperty, id)
+		}
+		size, err := propertySize(kind, data[1:])
+		if err != nil {
+			return nil, n + length, fmt.Errorf("property 0x%02x: %w", id, err)
+		}
+		props = append(props, Property{ID: id, Value: data[1 : 1+size]})
+		data = data[1+size:]
+	}
+	return props, n + length, nil
+}
+
+// propertySize returns the encoded size of a value of the given kind at the
+// start of data
+func propertySize(kind propertyKind, data []byte) (int, error) {
+	var size int
+	switch kind {
+	case propByte:
+		size = 1
+	case propUint16:
+		size = 2
+	case propUint32:
+		size = 4
+	case propVarInt:
+		_, n, err := ReadVarInt(bytes.NewReader(data))
+		if err != nil {
+			return 0, err
+		}
+		size = n
+	case propString, propBinary:
+		if len(data) < 2 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		size = 2 + int(binary.BigEndian.Uint16(data))
+	case propStringPair:
+		if len(data) < 2 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		key := 2 + int(binary.BigEndian.Uint16(data))
+		if len(data) < key+2 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		size = key + 2 + int(binary.BigEndian.Uint16(data[key:]))
+	}
+	if size > len(data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return size, nil
+}
+
+// ReadVarInt reads a variable byte integer and returns it with the number of
+// bytes consumed
+func ReadVarInt(r io.Reader) (int, int, error) {
+	buf := make([]byte, 1)
+	value, multiplier := 0, 1
+	for n := 1; ; n++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, n, err
+		}
+		value += int(buf[0]&0x7F) * multiplier
+		if buf[0]&0x80 == 0 {
+			return value, n, nil
+		}
+		if n == 4 {
+			return 0, n, errors.New("variable byte integer exceeds 4 bytes")
+		}
+		multiplier *= 128
+	}
+}
+
+// EncodeVarInt encodes a variable byte integer
+func EncodeVarInt(v int) []byte {
+	var out []byte
+	for {
+		digit := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			digit |= 0x80
+		}
+		out = append(out, digit)
+		if v == 0 {
+			return out
+		}
+	}
+}
+
+// V5ConnectReason maps a 3.1.1 CONNACK return code to its MQTT 5 reason code
+func V5ConnectReason(code byte) byte {
+	switch code {
+	case ConnectAccepted:
+		return ReasonSuccess
+	case ConnectRefusedProtocolVersion:
+		return ReasonUnsupportedProtocol
+	case ConnectRefusedIdentifierRejected:
+		return ReasonClientIdentifierNotValid
+	case ConnectRefusedServerUnavailable:
+		return ReasonServerUnavailable
+	case ConnectRefusedBadCredentials:
+		return ReasonBadUserNameOrPassword
+	case ConnectRefusedNotAuthorized:
+		return ReasonNotAuthorized
+	}
+	return ReasonImplementationSpecificErr
+}
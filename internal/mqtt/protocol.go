@@ -0,0 +1,83 @@
+package mqtt
+
+import "strings"
+
+// Protocol names and levels accepted in CONNECT
+const (
+	ProtocolNameV31  = "MQIsdp" // MQTT 3.1
+	ProtocolNameV311 = "MQTT"   // MQTT 3.1.1
+
+	ProtocolV31  byte = 3
+	ProtocolV311 byte = 4
+	ProtocolV5   byte = 5 // MQTT 5.0, also named "MQTT"
+)
+
+// MaxClientIDLenV31 is the longest client identifier MQTT 3.1 allows
+const MaxClientIDLenV31 = 23
+
+// CONNACK return codes
+const (
+	ConnectAccepted                  byte = 0
+	ConnectRefusedProtocolVersion    byte = 1
+	ConnectRefusedIdentifierRejected byte = 2
+	ConnectRefusedServerUnavailable  byte = 3
+	ConnectRefusedBadCredentials     byte = 4
+	ConnectRefusedNotAuthorized      byte = 5
+)
+
+// SubackFailure is the SUBACK return code of a rejected subscription before
+// MQTT 5, which has no reason codes
+const SubackFailure byte = 0x80
+
+// KnownProtocol reports whether the protocol name is one of MQTT 3.1 or
+// 3.1.1. Connections using any other name are closed without a CONNACK.
+func (c *ConnectPacket) KnownProtocol() bool {
+	return c.ProtocolName == ProtocolNameV31 || c.ProtocolName == ProtocolNameV311
+}
+
+// CheckProtocol returns the CONNACK return code for the protocol name, level
+// and client identifier, following the rules of the requested version:
+//   - 3.1 (MQIsdp, level 3) requires a client ID of 1-23 characters
+//   - 3.1.1 (MQTT, level 4) allows an empty client ID only with a clean
+//     session, in which case the server assigns one
+//   - 5.0 (MQTT, level 5) always allows an empty client ID
+func (c *ConnectPacket) CheckProtocol() byte {
+	switch {
+	case c.ProtocolName == ProtocolNameV31 && c.ProtocolVersion == ProtocolV31:
+		if c.ClientID == "" || len(c.ClientID) > MaxClientIDLenV31 {
+			return ConnectRefusedIdentifierRejected
+		}
+	case c.ProtocolName == ProtocolNameV311 && c.ProtocolVersion == ProtocolV311:
+		if c.ClientID == "" && !c.CleanSession {
+			return ConnectRefusedIdentifierRejected
+		}
+	case c.ProtocolName == ProtocolNameV311 && c.ProtocolVersion == ProtocolV5:
+	default:
+		return ConnectRefusedProtocolVersion
+	}
+	return ConnectAccepted
+}
+
+// ValidTopicName reports whether a PUBLISH topic is valid: topic names must
+// not be empty and must not contain wildcards
+func ValidTopicName(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#")
+}
+
+// ValidTopicFilter reports whether a subscription topic filter is valid:
+// wildcards must occupy a whole level and "#" must be the last level
+func ValidTopicFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) > 1 {
+			return false
+		}
+		if level == "#" && i != len(levels)-1 {
+			return false
+		}
+	}
+	return true
+}
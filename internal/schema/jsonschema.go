@@ -0,0 +1,501 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// jsonSchema is a compiled JSON Schema. The validation keywords of draft
+// 2020-12 for types, enums, numbers, strings, arrays, objects and the
+// allOf/anyOf/oneOf/not combinators are supported; formats and annotations
+// are ignored. Schemas using a validation keyword that is not supported,
+// such as $ref, are refused rather than validated more loosely than their
+// author intended.
+type jsonSchema struct {
+	always *bool // boolean schema
+
+	types      []string
+	enum       []any
+	constant   any
+	hasConst   bool
+	properties map[string]*jsonSchema
+	required   []string
+	additional *jsonSchema // nil = any additional property is allowed
+	items      *jsonSchema
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+	minLength, maxLength               *int
+	minItems, maxItems                 *int
+	minProperties, maxProperties       *int
+	uniqueItems                        bool
+	pattern                            *regexp.Regexp
+
+	allOf, anyOf, oneOf []*jsonSchema
+	not                 *jsonSchema
+}
+
+// decodeJSON decodes a single JSON document keeping numbers exact
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after JSON value")
+	}
+	return v, nil
+}
+
+// compileJSONSchema parses a JSON schema document
+func compileJSONSchema(data []byte) (*jsonSchema, error) {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return compileNode(doc, "#")
+}
+
+// unsupportedKeywords are the draft 2020-12 (and earlier) keywords that
+// constrain a value but are not implemented
+var unsupportedKeywords = []string{
+	"$ref", "$dynamicRef", "$recursiveRef",
+	"if", "then", "else",
+	"dependentRequired", "dependentSchemas", "dependencies",
+	"patternProperties", "propertyNames", "unevaluatedProperties",
+	"prefixItems", "additionalItems", "contains", "minContains", "maxContains", "unevaluatedItems",
+}
+
+// compileNode compiles a schema or subschema at a JSON pointer
+func compileNode(node any, at string) (*jsonSchema, error) {
+	switch n := node.(type) {
+	case bool:
+		return &jsonSchema{always: &n}, nil
+	case map[string]any:
+		return compileObject(n, at)
+	}
+	return nil, fmt.Errorf("%s: schema must be an object or a boolean", at)
+}
+
+func compileObject(n map[string]any, at string) (*jsonSchema, error) {
+	for _, keyword := range unsupportedKeywords {
+		if _, ok := n[keyword]; ok {
+			return nil, fmt.Errorf("%s/%s: keyword is not supported", at, keyword)
+		}
+	}
+
+	s := &jsonSchema{}
+	var err error
+
+	switch t := n["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: must be a string or an array of strings", at)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: must be a string or an array of strings", at)
+	}
+
+	if v, ok := n["enum"]; ok {
+		if s.enum, ok = v.([]any); !ok {
+			return nil, fmt.Errorf("%s/enum: must be an array", at)
+		}
+	}
+	if v, ok := n["const"]; ok {
+		s.constant, s.hasConst = v, true
+	}
+
+	if v, ok := n["properties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: must be an object", at)
+		}
+		s.properties = make(map[string]*jsonSchema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = compileNode(sub, at+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := n["required"]; ok {
+		list, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/required: must be an array of strings", at)
+		}
+		for _, name := range list {
+			str, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: must be an array of strings", at)
+			}
+			s.required = append(s.required, str)
+		}
+	}
+	if v, ok := n["additionalProperties"]; ok {
+		if s.additional, err = compileNode(v, at+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := n["items"]; ok {
+		if s.items, err = compileNode(v, at+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	numbers := map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf": &s.multipleOf,
+	}
+	for keyword, dst := range numbers {
+		if v, ok := n[keyword]; ok {
+			f, ok := number(v)
+			if !ok {
+				return nil, fmt.Errorf("%s/%s: must be a number", at, keyword)
+			}
+			*dst = &f
+		}
+	}
+	counts := map[string]**int{
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+		"minProperties": &s.minProperties, "maxProperties": &s.maxProperties,
+	}
+	for keyword, dst := range counts {
+		if v, ok := n[keyword]; ok {
+			f, ok := number(v)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return nil, fmt.Errorf("%s/%s: must be a non-negative integer", at, keyword)
+			}
+			i := int(f)
+			*dst = &i
+		}
+	}
+	if v, ok := n["uniqueItems"].(bool); ok {
+		s.uniqueItems = v
+	}
+	if v, ok := n["pattern"]; ok {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", at)
+		}
+		if s.pattern, err = regexp.Compile(str); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", at, err)
+		}
+	}
+
+	for keyword, dst := range map[string]*[]*jsonSchema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		v, ok := n[keyword]
+		if !ok {
+			continue
+		}
+		list, ok := v.([]any)
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s/%s: must be a non-empty array", at, keyword)
+		}
+		for i, sub := range list {
+			compiled, err := compileNode(sub, at+"/"+keyword+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+			*dst = append(*dst, compiled)
+		}
+	}
+	if v, ok := n["not"]; ok {
+		if s.not, err = compileNode(v, at+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// number returns the value of a JSON number
+func number(v any) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// validateDocument checks a JSON document against the schema
+func (s *jsonSchema) validateDocument(data []byte) error {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return fmt.Errorf("payload is not JSON: %w", err)
+	}
+	return s.validate(doc, "$")
+}
+
+// validate checks a value at a path, returning the first violation
+func (s *jsonSchema) validate(v any, at string) error {
+	if s.always != nil {
+		if !*s.always {
+			return fmt.Errorf("%s: no value is allowed", at)
+		}
+		return nil
+	}
+
+	if len(s.types) > 0 {
+		matched := false
+		for _, t := range s.types {
+			if hasType(v, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %v, got %s", at, s.types, typeName(v))
+		}
+	}
+	if s.enum != nil {
+		matched := false
+		for _, allowed := range s.enum {
+			if equal(v, allowed) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: value is not one of the enumerated values", at)
+		}
+	}
+	if s.hasConst && !equal(v, s.constant) {
+		return fmt.Errorf("%s: value does not equal the constant", at)
+	}
+
+	var err error
+	switch val := v.(type) {
+	case json.Number:
+		err = s.validateNumber(val, at)
+	case string:
+		err = s.validateString(val, at)
+	case []any:
+		err = s.validateArray(val, at)
+	case map[string]any:
+		err = s.validateObject(val, at)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, at); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.validate(v, at) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: value matches none of anyOf", at)
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matches := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, at) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: value matches %d of oneOf, expected exactly 1", at, matches)
+		}
+	}
+	if s.not != nil && s.not.validate(v, at) == nil {
+		return fmt.Errorf("%s: value matches a schema it must not match", at)
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateNumber(n json.Number, at string) error {
+	f, err := n.Float64()
+	if err != nil {
+		return fmt.Errorf("%s: invalid number %s", at, n)
+	}
+	switch {
+	case s.minimum != nil && f < *s.minimum:
+		return fmt.Errorf("%s: %s is less than the minimum %g", at, n, *s.minimum)
+	case s.maximum != nil && f > *s.maximum:
+		return fmt.Errorf("%s: %s is greater than the maximum %g", at, n, *s.maximum)
+	case s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum:
+		return fmt.Errorf("%s: %s is not greater than %g", at, n, *s.exclusiveMinimum)
+	case s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum:
+		return fmt.Errorf("%s: %s is not less than %g", at, n, *s.exclusiveMaximum)
+	case s.multipleOf != nil && *s.multipleOf > 0 && math.Abs(math.Remainder(f, *s.multipleOf)) > 1e-9:
+		return fmt.Errorf("%s: %s is not a multiple of %g", at, n, *s.multipleOf)
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateString(str string, at string) error {
+	length := utf8.RuneCountInString(str)
+	switch {
+	case s.minLength != nil && length < *s.minLength:
+		return fmt.Errorf("%s: string is shorter than %d characters", at, *s.minLength)
+	case s.maxLength != nil && length > *s.maxLength:
+		return fmt.Errorf("%s: string is longer than %d characters", at, *s.maxLength)
+	case s.pattern != nil && !s.pattern.MatchString(str):
+		return fmt.Errorf("%s: string does not match %s", at, s.pattern)
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateArray(list []any, at string) error {
+	switch {
+	case s.minItems != nil && len(list) < *s.minItems:
+		return fmt.Errorf("%s: array has fewer than %d items", at, *s.minItems)
+	case s.maxItems != nil && len(list) > *s.maxItems:
+		return fmt.Errorf("%s: array has more than %d items", at, *s.maxItems)
+	}
+	if s.uniqueItems {
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				if equal(list[i], list[j]) {
+					return fmt.Errorf("%s: items %d and %d are equal", at, i, j)
+				}
+			}
+		}
+	}
+	if s.items != nil {
+		for i, item := range list {
+			if err := s.items.validate(item, at+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateObject(obj map[string]any, at string) error {
+	switch {
+	case s.minProperties != nil && len(obj) < *s.minProperties:
+		return fmt.Errorf("%s: object has fewer than %d properties", at, *s.minProperties)
+	case s.maxProperties != nil && len(obj) > *s.maxProperties:
+		return fmt.Errorf("%s: object has more than %d properties", at, *s.maxProperties)
+	}
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", at, name)
+		}
+	}
+
+	// Check properties in name order so the reported violation is stable
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub, ok := s.properties[name]
+		if !ok {
+			sub = s.additional
+		}
+		if sub == nil {
+			continue
+		}
+		if err := sub.validate(obj[name], at+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasType reports whether a value is of a JSON Schema type
+func hasType(v any, t string) bool {
+	switch val := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		f, err := val.Float64()
+		return t == "integer" && err == nil && f == math.Trunc(f)
+	}
+	return false
+}
+
+// typeName returns the JSON Schema type of a value
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "number"
+}
+
+// equal compares JSON values, numbers by value
+func equal(a, b any) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errx := x.Float64()
+		fy, erry := y.Float64()
+		return errx == nil && erry == nil && fx == fy
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
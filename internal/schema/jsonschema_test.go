@@ -0,0 +1,93 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestJSONSchemaKeywords tests each supported validation keyword against
+// a value it accepts and one it refuses
+func TestJSONSchemaKeywords(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		valid  string
+		bad    string
+	}{
+		{"true", `true`, `{"a": 1}`, ``},
+		{"false", `false`, ``, `null`},
+		{"type", `{"type": "string"}`, `"x"`, `1`},
+		{"type list", `{"type": ["string", "null"]}`, `null`, `true`},
+		{"integer", `{"type": "integer"}`, `2.0`, `2.5`},
+		{"number", `{"type": "number"}`, `2.5`, `"2.5"`},
+		{"enum", `{"enum": ["on", 1, null]}`, `1.0`, `"off"`},
+		{"const", `{"const": {"a": [1, 2]}}`, `{"a": [1.0, 2]}`, `{"a": [2, 1]}`},
+		{"properties", `{"properties": {"a": {"type": "number"}}}`, `{"a": 1, "b": "x"}`, `{"a": "x"}`},
+		{"required", `{"required": ["a"]}`, `{"a": null}`, `{"b": 1}`},
+		{"additionalProperties", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1}`, `{"a": 1, "b": 2}`},
+		{"items", `{"items": {"type": "integer"}}`, `[1, 2]`, `[1, "2"]`},
+		{"minimum", `{"minimum": 5}`, `5`, `4.9`},
+		{"maximum", `{"maximum": 5}`, `5`, `5.1`},
+		{"exclusiveMinimum", `{"exclusiveMinimum": 5}`, `5.1`, `5`},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 5}`, `4.9`, `5`},
+		{"multipleOf", `{"multipleOf": 0.1}`, `0.3`, `0.35`},
+		{"minLength", `{"minLength": 2}`, `"żó"`, `"ż"`},
+		{"maxLength", `{"maxLength": 2}`, `"żó"`, `"abc"`},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc"`, `"ab1"`},
+		{"minItems", `{"minItems": 1}`, `[0]`, `[]`},
+		{"maxItems", `{"maxItems": 1}`, `[0]`, `[0, 1]`},
+		{"uniqueItems", `{"uniqueItems": true}`, `[1, "1"]`, `[1, 1.0]`},
+		{"minProperties", `{"minProperties": 1}`, `{"a": 1}`, `{}`},
+		{"maxProperties", `{"maxProperties": 1}`, `{"a": 1}`, `{"a": 1, "b": 2}`},
+		{"allOf", `{"allOf": [{"minimum": 1}, {"maximum": 2}]}`, `1.5`, `3`},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"minimum": 10}]}`, `10`, `9`},
+		{"oneOf", `{"oneOf": [{"minimum": 1}, {"maximum": 2}]}`, `3`, `1.5`},
+		{"not", `{"not": {"type": "null"}}`, `0`, `null`},
+		{"ignored annotations", `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "t", "format": "email"}`, `"x"`, ``},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := compileJSONSchema([]byte(tc.schema))
+			if err != nil {
+				t.Fatalf("Failed to compile %s: %v", tc.schema, err)
+			}
+			if tc.valid != "" {
+				if err := s.validateDocument([]byte(tc.valid)); err != nil {
+					t.Errorf("Expected %s to be valid: %v", tc.valid, err)
+				}
+			}
+			if tc.bad != "" {
+				if err := s.validateDocument([]byte(tc.bad)); err == nil {
+					t.Errorf("Expected %s to be invalid", tc.bad)
+				}
+			}
+		})
+	}
+}
+
+// TestJSONSchemaInvalid tests that malformed schemas and schemas using
+// unsupported keywords, also in subschemas, are refused
+func TestJSONSchemaInvalid(t *testing.T) {
+	tests := []struct {
+		schema string
+		err    string
+	}{
+		{`{"$ref": "#/$defs/a", "$defs": {"a": {}}}`, "#/$ref: keyword is not supported"},
+		{`{"properties": {"a": {"$ref": "other.json"}}}`, "#/properties/a/$ref"},
+		{`{"anyOf": [{"if": {}, "then": {}}]}`, "#/anyOf/0/if"},
+		{`{"patternProperties": {"^a": {}}}`, "patternProperties"},
+		{`{"prefixItems": [{}]}`, "prefixItems"},
+		{`{"type": 1}`, "#/type"},
+		{`{"minLength": -1}`, "#/minLength"},
+		{`{"pattern": "("}`, "#/pattern"},
+		{`{"allOf": []}`, "#/allOf"},
+		{`"string"`, "schema must be an object or a boolean"},
+		{`{} {}`, "trailing data"},
+	}
+	for _, tc := range tests {
+		_, err := compileJSONSchema([]byte(tc.schema))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Compiling %s: expected an error containing %q, got %v", tc.schema, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Package schema fetches schemas from a Confluent-compatible or plain HTTP
+// schema registry, caches them and validates message payloads against them.
+package schema
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// Schema types as named by the Confluent Schema Registry
+const (
+	TypeJSON     = "JSON"
+	TypeAvro     = "AVRO"
+	TypeProtobuf = "PROTOBUF"
+)
+
+// retryFailed is how long a failed fetch is remembered before the registry
+// is asked again, so an outage does not cost a request per message
+const retryFailed = 10 * time.Second
+
+// Schema is a schema fetched from the registry
+type Schema struct {
+	Subject string    `json:"subject"`
+	Version int       `json:"version,omitempty"`
+	ID      int       `json:"id,omitempty"`
+	Type    string    `json:"schema_type"`
+	Source  string    `json:"schema"`
+	Fetched time.Time `json:"fetched"`
+
+	compiled *jsonSchema // nil unless Type is JSON
+}
+
+// compile parses a JSON schema
+func (s *Schema) compile() error {
+	if s.Type != TypeJSON {
+		return nil
+	}
+	compiled, err := compileJSONSchema([]byte(s.Source))
+	if err != nil {
+		return fmt.Errorf("subject %s: invalid JSON schema: %w", s.Subject, err)
+	}
+	s.compiled = compiled
+	return nil
+}
+
+// Validate checks a payload against the schema. JSON payloads are
+// validated against JSON schemas, also when framed in the Confluent wire
+// format (magic byte 0 and the 4-byte schema ID). The broker cannot decode
+// Avro and Protobuf, so their payloads must carry the wire format with the
+// ID of the schema.
+func (s *Schema) Validate(payload []byte) error {
+	framed := len(payload) >= 5 && payload[0] == 0
+	if framed && s.ID != 0 {
+		if id := binary.BigEndian.Uint32(payload[1:5]); id != uint32(s.ID) {
+			return fmt.Errorf("payload carries schema ID %d, expected %d", id, s.ID)
+		}
+	}
+	if s.compiled == nil {
+		if !framed {
+			return fmt.Errorf("payload is not in the schema registry wire format")
+		}
+		return nil
+	}
+	if framed {
+		payload = payload[5:]
+	}
+	return s.compiled.validateDocument(payload)
+}
+
+// Status describes a cached subject
+type Status struct {
+	Subject string    `json:"subject"`
+	Version int       `json:"version,omitempty"`
+	ID      int       `json:"id,omitempty"`
+	Type    string    `json:"schema_type,omitempty"`
+	Fetched time.Time `json:"fetched"`
+	Error   string    `json:"error,omitempty"` // last fetch error
+}
+
+// Registry looks up schemas by subject, caching them for the configured
+// TTL. Stale schemas are refreshed in the background and kept in use while
+// the registry is unreachable.
+type Registry struct {
+	cfg    config.SchemaRegistryConfig
+	client *http.Client
+	spawn  Spawner
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// entry is the cache state of a subject
+type entry struct {
+	ready      chan struct{} // closed once the first fetch has finished
+	schema     *Schema       // nil until a fetch succeeded
+	err        error         // error of the last fetch
+	checked    time.Time     // time of the last fetch
+	refreshing bool
+}
+
+// Spawner runs a background task in a goroutine under a context that is
+// cancelled when its owner stops, and reports false if the owner is not
+// running and the task was dropped
+type Spawner func(task func(ctx context.Context)) bool
+
+// NewRegistry creates a registry client. Stale subjects are refreshed
+// through spawn, so the owner of the registry can wait for the refreshes
+// and cancel them; a nil spawn runs them in plain goroutines.
+func NewRegistry(cfg config.SchemaRegistryConfig, spawn Spawner) *Registry {
+	if spawn == nil {
+		spawn = func(task func(ctx context.Context)) bool {
+			go task(context.Background())
+			return true
+		}
+	}
+	return &Registry{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		spawn:   spawn,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Lookup returns the schema of a subject. Only the first lookup of a
+// subject waits for the registry.
+func (r *Registry) Lookup(ctx context.Context, subject string) (*Schema, error) {
+	r.mu.Lock()
+	e, ok := r.entries[subject]
+	if !ok {
+		e = &entry{ready: make(chan struct{})}
+		r.entries[subject] = e
+		r.mu.Unlock()
+
+		r.refresh(ctx, subject, e)
+		close(e.ready)
+	} else {
+		r.mu.Unlock()
+	}
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	r.mu.Lock()
+	age := time.Since(e.checked)
+	stale := !e.refreshing && (e.err == nil && age > r.cfg.CacheTTL || e.err != nil && age > retryFailed)
+	if stale {
+		e.refreshing = true
+	}
+	schema, err := e.schema, e.err
+	r.mu.Unlock()
+
+	if stale && !r.spawn(func(ctx context.Context) { r.refresh(ctx, subject, e) }) {
+		r.mu.Lock()
+		e.refreshing = false
+		r.mu.Unlock()
+	}
+	if schema == nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// refresh fetches a subject and updates its entry, falling back to the
+// cache directory when nothing was fetched yet
+func (r *Registry) refresh(ctx context.Context, subject string, e *entry) {
+	schema, err := r.fetch(ctx, subject)
+	if err == nil {
+		err = schema.compile()
+	}
+	if err == nil {
+		r.store(schema)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.checked, e.err, e.refreshing = time.Now(), err, false
+	if err == nil {
+		e.schema = schema
+		return
+	}
+	log.Printf("Failed to fetch schema of subject %s: %v", subject, err)
+	if e.schema == nil {
+		if cached := r.load(subject); cached != nil {
+			log.Printf("Using cached schema of subject %s fetched %s", subject, cached.Fetched.Format(time.RFC3339))
+			e.schema = cached
+		}
+	}
+}
+
+// confluentSchema is a Confluent Schema Registry subject version
+type confluentSchema struct {
+	Subject    string `json:"subject"`
+	Version    int    `json:"version"`
+	ID         int    `json:"id"`
+	SchemaType string `json:"schemaType"` // omitted for Avro
+	Schema     string `json:"schema"`
+}
+
+// fetch requests the latest schema of a subject from the registry
+func (r *Registry) fetch(ctx context.Context, subject string) (*Schema, error) {
+	var target string
+	if r.cfg.Kind == "http" {
+		target = strings.ReplaceAll(r.cfg.URL, "%s", url.PathEscape(subject))
+	} else {
+		target = strings.TrimSuffix(r.cfg.URL, "/") + "/subjects/" + url.PathEscape(subject) + "/versions/latest"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if r.cfg.Kind == "confluent" {
+		req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json, application/json")
+	}
+	if r.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	} else if r.cfg.Username != "" {
+		req.SetBasicAuth(r.cfg.Username, r.cfg.Password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("registry returned %s: %s", resp.Status, strings.TrimSpace(string(body[:min(len(body), 512)])))
+	}
+
+	schema := &Schema{Subject: subject, Type: TypeJSON, Source: string(body), Fetched: time.Now()}
+	if r.cfg.Kind == "confluent" {
+		var cs confluentSchema
+		if err := json.Unmarshal(body, &cs); err != nil {
+			return nil, fmt.Errorf("invalid registry response: %w", err)
+		}
+		schema.Version, schema.ID, schema.Source = cs.Version, cs.ID, cs.Schema
+		schema.Type = cs.SchemaType
+		if schema.Type == "" {
+			schema.Type = TypeAvro
+		}
+	}
+	return schema, nil
+}
+
+// cachePath returns the file a subject is cached in
+func (r *Registry) cachePath(subject string) string {
+	return filepath.Join(r.cfg.CacheDir, url.PathEscape(subject)+".json")
+}
+
+// store writes a fetched schema to the cache directory
+func (r *Registry) store(schema *Schema) {
+	if r.cfg.CacheDir == "" {
+		return
+	}
+	data, err := json.Marshal(schema)
+	if err == nil {
+		err = os.MkdirAll(r.cfg.CacheDir, 0o755)
+	}
+	if err == nil {
+		tmp := r.cachePath(schema.Subject) + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, r.cachePath(schema.Subject))
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to cache schema of subject %s: %v", schema.Subject, err)
+	}
+}
+
+// load reads a subject from the cache directory, or returns nil
+func (r *Registry) load(subject string) *Schema {
+	if r.cfg.CacheDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(r.cachePath(subject))
+	if err != nil {
+		return nil
+	}
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		log.Printf("Ignoring cached schema of subject %s: %v", subject, err)
+		return nil
+	}
+	if err := schema.compile(); err != nil {
+		log.Printf("Ignoring cached schema of subject %s: %v", subject, err)
+		return nil
+	}
+	return &schema
+}
+
+// Statuses returns the cached subjects, sorted by subject
+func (r *Registry) Statuses() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]Status, 0, len(r.entries))
+	for subject, e := range r.entries {
+		st := Status{Subject: subject}
+		if e.schema != nil {
+			st.Version, st.ID, st.Type, st.Fetched = e.schema.Version, e.schema.ID, e.schema.Type, e.schema.Fetched
+		}
+		if e.err != nil {
+			st.Error = e.err.Error()
+		}
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Subject < list[j].Subject })
+	return list
+}
@@ -0,0 +1,232 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// fakeRegistry is a Confluent Schema Registry serving one schema per
+// subject
+type fakeRegistry struct {
+	mu       sync.Mutex
+	schemas  map[string]confluentSchema
+	down     bool
+	block    chan struct{} // requests wait for it when not nil
+	requests atomic.Int32
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+	f.mu.Lock()
+	block, down := f.block, f.down
+	f.mu.Unlock()
+	if block != nil {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if user, pass, _ := r.BasicAuth(); user != "broker" || pass != "secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var subject string
+	if _, err := fmt.Sscanf(r.URL.Path, "/subjects/%s", &subject); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	subject = subject[:len(subject)-len("/versions/latest")]
+	f.mu.Lock()
+	cs, ok := f.schemas[subject]
+	f.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"error_code":40401}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(cs)
+}
+
+// set publishes a new version of a subject
+func (f *fakeRegistry) set(subject string, version int, schema string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.schemas[subject] = confluentSchema{Subject: subject, Version: version, ID: 100 + version, SchemaType: TypeJSON, Schema: schema}
+}
+
+func (f *fakeRegistry) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+// newTestRegistry starts a fake registry and a client of it whose
+// background refreshes are tracked by wg and cancelled with ctx
+func newTestRegistry(t *testing.T, ctx context.Context, wg *sync.WaitGroup) (*Registry, *fakeRegistry, config.SchemaRegistryConfig) {
+	t.Helper()
+	fake := &fakeRegistry{schemas: make(map[string]confluentSchema)}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg := config.SchemaRegistryConfig{
+		Kind:     "confluent",
+		URL:      srv.URL,
+		Username: "broker",
+		Password: "secret",
+		Timeout:  5 * time.Second,
+		CacheTTL: time.Hour,
+		CacheDir: t.TempDir(),
+	}
+	spawn := func(task func(ctx context.Context)) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task(ctx)
+		}()
+		return true
+	}
+	return NewRegistry(cfg, spawn), fake, cfg
+}
+
+// TestRegistryLookup tests fetching, caching, background refreshes and
+// outages of a registry subject
+func TestRegistryLookup(t *testing.T) {
+	var wg sync.WaitGroup
+	reg, fake, cfg := newTestRegistry(t, context.Background(), &wg)
+	fake.set("sensors", 1, `{"type": "object", "required": ["temp"]}`)
+	ctx := context.Background()
+
+	sch, err := reg.Lookup(ctx, "sensors")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if sch.Version != 1 || sch.ID != 101 || sch.Type != TypeJSON {
+		t.Fatalf("Unexpected schema %+v", sch)
+	}
+	if sch.Validate([]byte(`{"temp": 20}`)) != nil || sch.Validate([]byte(`{"hum": 40}`)) == nil {
+		t.Error("Expected payloads to be validated against the fetched schema")
+	}
+	if err := sch.Validate(append([]byte{0, 0, 0, 0, 101}, `{"temp": 20}`...)); err != nil {
+		t.Errorf("Expected a framed payload with the schema's ID to be valid: %v", err)
+	}
+	if err := sch.Validate(append([]byte{0, 0, 0, 0, 7}, `{"temp": 20}`...)); err == nil {
+		t.Error("Expected a framed payload with another schema ID to be invalid")
+	}
+
+	if _, err := reg.Lookup(ctx, "sensors"); err != nil || fake.requests.Load() != 1 {
+		t.Fatalf("Expected the cached schema without a request, got %d requests and %v", fake.requests.Load(), err)
+	}
+	if _, err := reg.Lookup(ctx, "missing"); err == nil {
+		t.Error("Expected an unknown subject to fail")
+	}
+
+	// A stale subject is served while it is refreshed in the background
+	reg.cfg.CacheTTL = 0
+	fake.set("sensors", 2, `{"type": "object", "required": ["hum"]}`)
+	if sch, err := reg.Lookup(ctx, "sensors"); err != nil || sch.Version != 1 {
+		t.Fatalf("Expected the stale version 1 during the refresh, got %+v, %v", sch, err)
+	}
+	wg.Wait()
+	if sch, err := reg.Lookup(ctx, "sensors"); err != nil || sch.Version != 2 {
+		t.Fatalf("Expected version 2 after the refresh, got %+v, %v", sch, err)
+	}
+	wg.Wait()
+
+	// A failed refresh keeps the last schema
+	fake.setDown(true)
+	reg.Lookup(ctx, "sensors")
+	wg.Wait()
+	if sch, err := reg.Lookup(ctx, "sensors"); err != nil || sch.Version != 2 {
+		t.Fatalf("Expected version 2 while the registry is down, got %+v, %v", sch, err)
+	}
+	statuses := reg.Statuses()
+	if len(statuses) != 2 || statuses[1].Subject != "sensors" || statuses[1].Error == "" {
+		t.Errorf("Expected the failed refresh in the statuses, got %+v", statuses)
+	}
+	wg.Wait()
+
+	// A new registry falls back to the cache directory
+	restarted := NewRegistry(cfg, nil)
+	if sch, err := restarted.Lookup(ctx, "sensors"); err != nil || sch.Version != 2 || sch.Validate([]byte(`{"hum": 1}`)) != nil {
+		t.Fatalf("Expected version 2 from the cache directory, got %+v, %v", sch, err)
+	}
+}
+
+// TestRegistryUnsupportedSchema tests that a schema using $ref is refused
+// instead of being validated without the referenced constraints
+func TestRegistryUnsupportedSchema(t *testing.T) {
+	var wg sync.WaitGroup
+	reg, fake, _ := newTestRegistry(t, context.Background(), &wg)
+	fake.set("refs", 1, `{"$ref": "#/$defs/reading", "$defs": {"reading": {"required": ["temp"]}}}`)
+
+	if _, err := reg.Lookup(context.Background(), "refs"); err == nil {
+		t.Fatal("Expected the schema using $ref to be refused")
+	}
+}
+
+// TestRegistryRefreshCancelled tests that background refreshes end when
+// the context of their owner is cancelled, and are not started afterwards
+func TestRegistryRefreshCancelled(t *testing.T) {
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	reg, fake, _ := newTestRegistry(t, ctx, &wg)
+	fake.set("sensors", 1, `{}`)
+	if _, err := reg.Lookup(context.Background(), "sensors"); err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+
+	// The refresh hangs in the registry until the owner stops
+	reg.cfg.CacheTTL = 0
+	fake.mu.Lock()
+	fake.block = make(chan struct{})
+	fake.mu.Unlock()
+	reg.Lookup(context.Background(), "sensors")
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the refresh to end when its context was cancelled")
+	}
+
+	// Make the failed refresh due for a retry
+	reg.mu.Lock()
+	reg.entries["sensors"].checked = time.Time{}
+	reg.mu.Unlock()
+	requests := fake.requests.Load()
+	if sch, err := reg.Lookup(context.Background(), "sensors"); err != nil || sch.Version != 1 {
+		t.Fatalf("Expected the cached schema after the cancelled refresh, got %+v, %v", sch, err)
+	}
+	wg.Wait()
+	if n := fake.requests.Load(); n != requests {
+		t.Errorf("Expected no refresh once the owner stopped, got %d more requests", n-requests)
+	}
+	reg.mu.Lock()
+	refreshing := reg.entries["sensors"].refreshing
+	reg.mu.Unlock()
+	if refreshing {
+		t.Error("Expected a dropped refresh not to leave the subject marked as refreshing")
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// TopicReport summarises topic usage over the analytics window
+type TopicReport struct {
+	Generated     time.Time    `json:"generated"`
+	Window        string       `json:"window"`          // Topics unused for longer are forgotten
+	Cardinality   int          `json:"cardinality"`     // Distinct topics published within the window
+	Truncated     bool         `json:"truncated"`       // Tracking limit reached; cardinality is a lower bound
+	NewTopics     int          `json:"new_topics"`      // Topics first seen during the last interval
+	GrowthPerHour float64      `json:"growth_per_hour"` // New topics per hour over the last interval
+	TopPrefixes   []UsageCount `json:"top_prefixes"`    // First topic levels with the most distinct topics
+	TopPublishers []UsageCount `json:"top_publishers"`  // Most active publishers during the last interval
+	Warnings      []string     `json:"warnings,omitempty"`
+}
+
+// UsageCount is a name with its count in a TopicReport
+type UsageCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// topicAnalytics tracks the topic space to spot unbounded growth, typically
+// devices putting IDs or timestamps into topic levels
+type topicAnalytics struct {
+	cfg config.AnalyticsConfig
+
+	mu         sync.Mutex
+	lastSeen   map[string]time.Time // topic -> last publish
+	newTopics  int                  // first seen during the current interval
+	publishers map[string]int64     // publisher -> messages during the current interval
+	truncated  bool
+	report     *TopicReport
+	warned     bool // threshold warning active, logged on transitions only
+}
+
+func newTopicAnalytics(cfg config.AnalyticsConfig) *topicAnalytics {
+	return &topicAnalytics{
+		cfg:        cfg,
+		lastSeen:   make(map[string]time.Time),
+		publishers: make(map[string]int64),
+		report:     &TopicReport{Window: cfg.Window.String()},
+	}
+}
+
+// record accounts a routed message
+func (a *topicAnalytics) record(topic, publisher string) {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.lastSeen[topic]; ok || len(a.lastSeen) < a.cfg.MaxTracked {
+		if !ok {
+			a.newTopics++
+		}
+		a.lastSeen[topic] = now
+	} else {
+		a.truncated = true
+	}
+	if publisher != "" {
+		a.publishers[publisher]++
+	}
+}
+
+// run produces a report every interval until ctx is cancelled
+func (a *topicAnalytics) run(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.analyze()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// analyze expires topics outside the window and builds a new report
+func (a *topicAnalytics) analyze() {
+	now := time.Now()
+	report := &TopicReport{Generated: now, Window: a.cfg.Window.String()}
+	prefixes := make(map[string]int64)
+
+	a.mu.Lock()
+	for topic, seen := range a.lastSeen {
+		if now.Sub(seen) > a.cfg.Window {
+			delete(a.lastSeen, topic)
+			continue
+		}
+		first, _, _ := strings.Cut(topic, "/")
+		prefixes[first]++
+	}
+	report.Cardinality = len(a.lastSeen)
+	report.Truncated = a.truncated
+	report.NewTopics = a.newTopics
+	report.TopPublishers = topCounts(a.publishers, a.cfg.TopN)
+	a.newTopics = 0
+	a.truncated = false
+	a.publishers = make(map[string]int64)
+	a.mu.Unlock()
+
+	report.GrowthPerHour = float64(report.NewTopics) / a.cfg.Interval.Hours()
+	report.TopPrefixes = topCounts(prefixes, a.cfg.TopN)
+
+	if a.cfg.MaxTopics > 0 && report.Cardinality > a.cfg.MaxTopics {
+		report.Warnings = append(report.Warnings, "topic cardinality exceeds the configured maximum")
+	}
+	if a.cfg.MaxNewTopics > 0 && report.NewTopics > a.cfg.MaxNewTopics {
+		report.Warnings = append(report.Warnings, "new topics per interval exceed the configured maximum")
+	}
+	if report.Truncated {
+		report.Warnings = append(report.Warnings, "topic tracking limit reached")
+	}
+
+	metrics.TopicCardinality.Set(float64(report.Cardinality))
+	metrics.TopicGrowth.Set(report.GrowthPerHour)
+
+	a.mu.Lock()
+	a.report = report
+	warned := len(report.Warnings) > 0
+	if warned && !a.warned {
+		log.Printf("Topic analytics: %d topics (%d new in %s): %s; top prefixes: %v",
+			report.Cardinality, report.NewTopics, a.cfg.Interval, strings.Join(report.Warnings, ", "), report.TopPrefixes)
+	} else if !warned && a.warned {
+		log.Printf("Topic analytics: topic space back within limits (%d topics)", report.Cardinality)
+	}
+	a.warned = warned
+	a.mu.Unlock()
+}
+
+// topCounts returns the n largest counts, ties broken by name
+func topCounts(counts map[string]int64, n int) []UsageCount {
+	top := make([]UsageCount, 0, len(counts))
+	for name, count := range counts {
+		top = append(top, UsageCount{Name: name, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// TopicReport returns the latest topic usage report, or nil if analytics are
+// disabled
+func (s *Server) TopicReport() *TopicReport {
+	if s.analytics == nil {
+		return nil
+	}
+	s.analytics.mu.Lock()
+	defer s.analytics.mu.Unlock()
+	return s.analytics.report
+}
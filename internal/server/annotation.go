@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// User property keys of broker annotations. Publishers cannot set them:
+// client-supplied properties with the prefix are removed.
+const (
+	annotationPrefix     = "broker_"
+	annotationReceivedAt = "broker_received_at" // RFC 3339 UTC time the broker received the message
+	annotationClientID   = "broker_client_id"   // ClientID of the publisher
+	annotationListener   = "broker_listener"    // listener the publisher connected to
+)
+
+// annotate adds the broker's receive metadata to a message published by a
+// client, if annotation is enabled for its topic
+func (s *Server) annotate(client *Client, pub *mqtt.PublishPacket) {
+	cfg := s.config.Annotation
+	if !cfg.Enabled {
+		return
+	}
+
+	// Drop look-alike properties first so consumers can trust the annotation
+	props := pub.Properties[:0:0]
+	for _, prop := range pub.Properties {
+		if key, _, ok := prop.UserProperty(); ok && strings.HasPrefix(key, annotationPrefix) {
+			continue
+		}
+		props = append(props, prop)
+	}
+	pub.Properties = props
+
+	matched := false
+	for _, filter := range cfg.Topics {
+		if topicMatch(filter, pub.Topic) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return
+	}
+	pub.Properties = append(pub.Properties,
+		mqtt.UserProperty(annotationReceivedAt, time.Now().UTC().Format(time.RFC3339Nano)),
+		mqtt.UserProperty(annotationClientID, client.ID),
+		mqtt.UserProperty(annotationListener, client.listener),
+	)
+}
+
+// annotationEnvelope is the JSON form of an annotated message for clients
+// without user properties
+type annotationEnvelope struct {
+	ReceivedAt      string          `json:"received_at"`
+	ClientID        string          `json:"client_id"`
+	Listener        string          `json:"listener"`
+	Payload         json.RawMessage `json:"payload"`
+	PayloadEncoding string          `json:"payload_encoding,omitempty"` // "string" or "base64" unless the payload is JSON
+}
+
+// wrapAnnotated returns the payload of an annotated message wrapped in a
+// JSON envelope, or the payload unchanged if the message is not annotated.
+// JSON payloads are embedded as is, other payloads as a string.
+func wrapAnnotated(pub *mqtt.PublishPacket) []byte {
+	env := annotationEnvelope{}
+	found := false
+	for _, prop := range pub.Properties {
+		key, value, ok := prop.UserProperty()
+		if !ok {
+			continue
+		}
+		switch key {
+		case annotationReceivedAt:
+			env.ReceivedAt, found = value, true
+		case annotationClientID:
+			env.ClientID = value
+		case annotationListener:
+			env.Listener = value
+		}
+	}
+	if !found {
+		return pub.Payload
+	}
+
+	var encoded []byte
+	switch {
+	case json.Valid(pub.Payload):
+		env.Payload = pub.Payload
+	case utf8.Valid(pub.Payload):
+		encoded, _ = json.Marshal(string(pub.Payload))
+		env.Payload, env.PayloadEncoding = encoded, "string"
+	default:
+		encoded, _ = json.Marshal(pub.Payload) // []byte marshals as base64
+		env.Payload, env.PayloadEncoding = encoded, "base64"
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return pub.Payload
+	}
+	return data
+}
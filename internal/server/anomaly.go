@@ -0,0 +1,337 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// Kinds of traffic anomaly keys
+const (
+	AnomalyClient = "client" // a publishing ClientID
+	AnomalyTopic  = "topic"  // the first anomaly.topic_levels levels of a topic
+)
+
+// AnomalyDetector decides whether a measured publish rate deviates from
+// what is normal for a key. Observe is called once per interval for every
+// tracked key, with 0 for keys that were silent; Forget is called when a
+// key is no longer tracked. Detectors are only called from one goroutine.
+type AnomalyDetector interface {
+	Observe(kind, key string, rate float64) (anomalous bool, baseline float64)
+	Forget(kind, key string)
+}
+
+// TrafficAnomaly describes a client or topic prefix publishing at an
+// anomalous rate
+type TrafficAnomaly struct {
+	Kind     string    `json:"kind"` // client or topic
+	Key      string    `json:"key"`  // ClientID or topic prefix
+	Rate     float64   `json:"rate"` // messages per second during the last interval
+	Baseline float64   `json:"baseline"`
+	Since    time.Time `json:"since"`
+	Action   string    `json:"action"` // log or throttle
+}
+
+// anomalyEvent is the JSON body of anomaly webhooks
+type anomalyEvent struct {
+	Event string `json:"event"`
+	*TrafficAnomaly
+	Time time.Time `json:"time"`
+}
+
+// anomalyKey identifies a tracked rate
+type anomalyKey struct {
+	kind, key string
+}
+
+// anomalyMonitor counts publishes per client and topic prefix and hands
+// the rates to a detector every interval
+type anomalyMonitor struct {
+	cfg    config.AnomalyConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	detector  AnomalyDetector
+	counts    map[anomalyKey]int64     // publishes during the current interval
+	lastSeen  map[anomalyKey]time.Time // last publish of tracked keys
+	active    map[anomalyKey]*TrafficAnomaly
+	throttles map[string]*rateLimiter // ClientID -> limit while its anomaly lasts
+}
+
+func newAnomalyMonitor(cfg config.AnomalyConfig) *anomalyMonitor {
+	return &anomalyMonitor{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.WebhookTimeout},
+		detector:  newBaselineDetector(cfg),
+		counts:    make(map[anomalyKey]int64),
+		lastSeen:  make(map[anomalyKey]time.Time),
+		active:    make(map[anomalyKey]*TrafficAnomaly),
+		throttles: make(map[string]*rateLimiter),
+	}
+}
+
+// record counts a publish by a client to a (mounted) topic
+func (m *anomalyMonitor) record(clientID, topic string) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count(anomalyKey{AnomalyClient, clientID}, now)
+	if m.cfg.TopicLevels > 0 {
+		m.count(anomalyKey{AnomalyTopic, topicPrefix(topic, m.cfg.TopicLevels)}, now)
+	}
+}
+
+// count adds a publish to a key, unless the tracking limit is reached
+func (m *anomalyMonitor) count(k anomalyKey, now time.Time) {
+	if _, ok := m.lastSeen[k]; !ok && len(m.lastSeen) >= m.cfg.MaxTracked {
+		return
+	}
+	m.lastSeen[k] = now
+	m.counts[k]++
+}
+
+// topicPrefix returns the first levels of a topic
+func topicPrefix(topic string, levels int) string {
+	parts := strings.SplitN(topic, "/", levels+1)
+	if len(parts) > levels {
+		parts = parts[:levels]
+	}
+	return strings.Join(parts, "/")
+}
+
+// admit reports whether a client may publish, applying the throttle of an
+// ongoing anomaly
+func (m *anomalyMonitor) admit(clientID string) bool {
+	m.mu.Lock()
+	limiter := m.throttles[clientID]
+	m.mu.Unlock()
+	return limiter == nil || limiter.Allow()
+}
+
+// run evaluates the rates every interval until ctx is cancelled
+func (m *anomalyMonitor) run(ctx context.Context, s *Server) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.evaluate(s)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// evaluate hands the rates of the last interval to the detector, starting
+// and ending anomalies, and forgets keys idle for longer than the baseline
+func (m *anomalyMonitor) evaluate(s *Server) {
+	now := time.Now()
+	var started, ended []*TrafficAnomaly
+
+	m.mu.Lock()
+	for k, seen := range m.lastSeen {
+		count := m.counts[k]
+		if count == 0 && now.Sub(seen) > m.cfg.Baseline {
+			delete(m.lastSeen, k)
+			m.detector.Forget(k.kind, k.key)
+			if a, ok := m.active[k]; ok {
+				ended = append(ended, m.end(k, a))
+			}
+			continue
+		}
+
+		rate := float64(count) / m.cfg.Interval.Seconds()
+		anomalous, baseline := m.detector.Observe(k.kind, k.key, rate)
+		a, ok := m.active[k]
+		switch {
+		case anomalous && !ok:
+			a = &TrafficAnomaly{Kind: k.kind, Key: k.key, Since: now, Action: "log"}
+			if k.kind == AnomalyClient && m.cfg.Action == "throttle" {
+				a.Action = "throttle"
+				m.throttles[k.key] = newRateLimiter(m.cfg.ThrottleRate, 1)
+			}
+			m.active[k] = a
+			started = append(started, a)
+		case !anomalous && ok:
+			ended = append(ended, m.end(k, a))
+		}
+		if a != nil {
+			a.Rate, a.Baseline = rate, baseline
+		}
+	}
+	clear(m.counts)
+	active := len(m.active)
+	m.mu.Unlock()
+
+	metrics.TrafficAnomalies.Set(float64(active))
+	for _, a := range started {
+		metrics.TrafficAnomalyEvents.WithLabelValues(a.Kind).Inc()
+		log.Printf("Traffic anomaly: %s %s publishing %.1f msg/s, baseline %.2f msg/s (%s)", a.Kind, a.Key, a.Rate, a.Baseline, a.Action)
+		s.emitEvent(m.event(EventTrafficAnomaly, a))
+		m.notify(EventTrafficAnomaly, a)
+	}
+	for _, a := range ended {
+		log.Printf("Traffic anomaly of %s %s ended after %s", a.Kind, a.Key, now.Sub(a.Since).Round(time.Second))
+		s.emitEvent(m.event(EventTrafficAnomalyEnded, a))
+		m.notify(EventTrafficAnomalyEnded, a)
+	}
+}
+
+// end forgets an anomaly and lifts its throttle. Must be called with mu held.
+func (m *anomalyMonitor) end(k anomalyKey, a *TrafficAnomaly) *TrafficAnomaly {
+	delete(m.active, k)
+	if k.kind == AnomalyClient {
+		delete(m.throttles, k.key)
+	}
+	return a
+}
+
+// event converts an anomaly to a broker event
+func (m *anomalyMonitor) event(typ string, a *TrafficAnomaly) *Event {
+	ev := &Event{
+		Type:   typ,
+		Reason: fmt.Sprintf("%s %s at %.1f msg/s, baseline %.2f msg/s", a.Kind, a.Key, a.Rate, a.Baseline),
+	}
+	if a.Kind == AnomalyClient {
+		ev.ClientID = a.Key
+	} else {
+		ev.Filter = a.Key
+	}
+	return ev
+}
+
+// notify posts an anomaly event to the webhook, if configured
+func (m *anomalyMonitor) notify(typ string, a *TrafficAnomaly) {
+	if m.cfg.WebhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(anomalyEvent{Event: typ, TrafficAnomaly: a, Time: time.Now()})
+	if err != nil {
+		log.Printf("Failed to encode anomaly event of %s %s: %v", a.Kind, a.Key, err)
+		return
+	}
+
+	resp, err := m.client.Post(m.cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to send anomaly webhook for %s %s: %v", a.Kind, a.Key, err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		log.Printf("Anomaly webhook for %s %s returned %s", a.Kind, a.Key, resp.Status)
+	}
+}
+
+// baselineDetector flags rates that exceed a multiple of their
+// exponentially weighted moving average. The average keeps adapting, so a
+// lasting change of rate becomes the new baseline over time.
+type baselineDetector struct {
+	alpha   float64 // weight of a new rate
+	factor  float64
+	minRate float64
+	warmup  int
+
+	baselines map[anomalyKey]*baseline
+}
+
+// baseline is the moving average rate of a key
+type baseline struct {
+	mean    float64
+	samples int
+}
+
+func newBaselineDetector(cfg config.AnomalyConfig) *baselineDetector {
+	return &baselineDetector{
+		alpha:     cfg.Interval.Seconds() / cfg.Baseline.Seconds(),
+		factor:    cfg.Factor,
+		minRate:   cfg.MinRate,
+		warmup:    cfg.Warmup,
+		baselines: make(map[anomalyKey]*baseline),
+	}
+}
+
+// Observe compares a rate with the key's baseline, then updates it
+func (d *baselineDetector) Observe(kind, key string, rate float64) (bool, float64) {
+	k := anomalyKey{kind, key}
+	b := d.baselines[k]
+	if b == nil {
+		b = &baseline{mean: rate}
+		d.baselines[k] = b
+	}
+
+	mean := b.mean
+	anomalous := b.samples >= d.warmup && rate >= d.minRate && rate > d.factor*mean
+	b.mean += d.alpha * (rate - b.mean)
+	b.samples++
+	return anomalous, mean
+}
+
+// Forget drops the baseline of a key
+func (d *baselineDetector) Forget(kind, key string) {
+	delete(d.baselines, anomalyKey{kind, key})
+}
+
+// SetAnomalyDetector replaces the moving average detector of traffic
+// anomalies. Baselines collected so far are discarded.
+func (s *Server) SetAnomalyDetector(detector AnomalyDetector) error {
+	if s.anomalies == nil {
+		return fmt.Errorf("anomaly detection is disabled")
+	}
+	s.anomalies.mu.Lock()
+	defer s.anomalies.mu.Unlock()
+	for k := range s.anomalies.lastSeen {
+		s.anomalies.detector.Forget(k.kind, k.key)
+	}
+	s.anomalies.detector = detector
+	return nil
+}
+
+// recordRate counts a publish received from a client for anomaly detection
+func (s *Server) recordRate(client *Client, topic string) {
+	if s.anomalies != nil {
+		s.anomalies.record(client.ID, topic)
+	}
+}
+
+// admitAnomalous applies the throttle of a client publishing at an
+// anomalous rate
+func (s *Server) admitAnomalous(client *Client) bool {
+	return s.anomalies == nil || s.anomalies.admit(client.ID)
+}
+
+// TrafficAnomalies returns the ongoing traffic anomalies, or nil if anomaly
+// detection is disabled
+func (s *Server) TrafficAnomalies() []TrafficAnomaly {
+	if s.anomalies == nil {
+		return nil
+	}
+	m := s.anomalies
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]TrafficAnomaly, 0, len(m.active))
+	for _, a := range m.active {
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind < list[j].Kind
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/x509"
+	"log"
+
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// defaultMaxPreAuthBytes is used when no pre-authentication limit is configured
+const defaultMaxPreAuthBytes = 64 * 1024
+
+// authenticate checks the credentials of a CONNECT packet and returns the
+// CONNACK return code. A client presenting a verified certificate is
+// authenticated as the certificate's common name: without a username it
+// gets the common name as username, and a matching username needs no
+// password.
+func (s *Server) authenticate(pkt *mqtt.ConnectPacket, cert *x509.Certificate) byte {
+	authCfg := s.config.Auth
+	if !authCfg.Enabled {
+		return mqtt.ConnectAccepted
+	}
+
+	if cert != nil && cert.Subject.CommonName != "" {
+		switch {
+		case !pkt.UsernameFlag && !pkt.PasswordFlag:
+			pkt.Username, pkt.UsernameFlag = cert.Subject.CommonName, true
+			return mqtt.ConnectAccepted
+		case pkt.UsernameFlag && pkt.Username == cert.Subject.CommonName:
+			return mqtt.ConnectAccepted
+		}
+	}
+
+	switch {
+	case pkt.PasswordFlag && !pkt.UsernameFlag:
+		return mqtt.ConnectRefusedBadCredentials // a password needs a username
+	case !pkt.UsernameFlag:
+		if authCfg.AllowAnonymous {
+			return mqtt.ConnectAccepted
+		}
+		return mqtt.ConnectRefusedNotAuthorized
+	case !s.checkPassword(pkt.Username, pkt.Password):
+		return mqtt.ConnectRefusedBadCredentials
+	}
+	return mqtt.ConnectAccepted
+}
+
+// checkPassword checks a password against the password file, then against
+// the auth database
+func (s *Server) checkPassword(username string, password []byte) bool {
+	if passwords := s.passwords.Load(); passwords != nil && passwords.Check(username, password) {
+		return true
+	}
+	return s.sqlAuth != nil && s.sqlAuth.Check(username, password)
+}
+
+// atClientLimit reports whether the broker has reached limits.max_clients.
+// A client taking over its own session does not count against the limit.
+func (s *Server) atClientLimit(key string) bool {
+	maxClients := s.config.Limits.MaxClients
+	if maxClients <= 0 {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, takeover := s.clients[key]
+	return !takeover && len(s.clients) >= maxClients
+}
+
+// atUserLimit reports whether a username already holds
+// limits.max_connections_per_username connections. A connection taking
+// over previous, the connection of its ClientID, replaces it if both belong
+// to the same user; anonymous clients are not limited. The caller holds
+// s.mu.
+func (s *Server) atUserLimit(username string, previous *Client) bool {
+	maxConns := s.config.Limits.MaxConnectionsPerUsername
+	if maxConns <= 0 || username == "" {
+		return false
+	}
+	conns := s.userConns[username]
+	if previous != nil && previous.Username == username {
+		conns--
+	}
+	return conns >= maxConns
+}
+
+// countUserConn adds delta to the connections of a client's username. The
+// caller holds s.mu.
+func (s *Server) countUserConn(client *Client, delta int) {
+	if client.Username == "" {
+		return
+	}
+	if s.userConns[client.Username] += delta; s.userConns[client.Username] <= 0 {
+		delete(s.userConns, client.Username)
+	}
+}
+
+// refuseConnect sends a CONNACK with a 3.1.1 refusal code, translated to the
+// matching reason code for MQTT 5 clients; the caller then closes the
+// connection
+func (s *Server) refuseConnect(writer *connWriter, pkt *mqtt.ConnectPacket, code byte, reason string) {
+	metrics.ConnectionsRefused.WithLabelValues(connackReason(code)).Inc()
+	log.Printf("Rejecting client %q: %s (return code %d)", pkt.ClientID, reason, code)
+	connack := &mqtt.ConnackPacket{ReturnCode: code}
+	if pkt.ProtocolVersion == mqtt.ProtocolV5 {
+		connack.Version = mqtt.ProtocolV5
+		connack.ReturnCode = mqtt.V5ConnectReason(code)
+	}
+	data, _ := connack.Encode()
+	writer.WritePacket(data)
+}
+
+// connackReason names a CONNACK refusal code for metrics
+func connackReason(code byte) string {
+	switch code {
+	case mqtt.ConnectRefusedProtocolVersion:
+		return "protocol_version"
+	case mqtt.ConnectRefusedIdentifierRejected:
+		return "identifier_rejected"
+	case mqtt.ConnectRefusedServerUnavailable:
+		return "server_unavailable"
+	case mqtt.ConnectRefusedBadCredentials:
+		return "bad_credentials"
+	case mqtt.ConnectRefusedNotAuthorized:
+		return "not_authorized"
+	}
+	return "unknown"
+}
+
+// maxPreAuthBytes returns how many bytes a connection may send before its
+// CONNECT has been accepted
+func (s *Server) maxPreAuthBytes() int {
+	if s.config.Limits.MaxPreAuthBytes > 0 {
+		return s.config.Limits.MaxPreAuthBytes
+	}
+	return defaultMaxPreAuthBytes
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// defaultBroadcastTimeout is how long Broadcast waits for acknowledgements
+// when no timeout is given
+const defaultBroadcastTimeout = 10 * time.Second
+
+// BroadcastReport is the outcome of a broadcast command
+type BroadcastReport struct {
+	Topic        string        `json:"topic"`
+	Recipients   int           `json:"recipients"`   // online clients with a matching subscription
+	Acknowledged []string      `json:"acknowledged"` // clients that sent PUBACK
+	Pending      []string      `json:"pending"`      // clients that did not acknowledge in time
+	Unconfirmed  []string      `json:"unconfirmed"`  // clients subscribed at QoS 0, which cannot acknowledge
+	Failed       []string      `json:"failed"`       // clients the command could not be written to
+	Elapsed      time.Duration `json:"elapsed"`
+}
+
+// broadcastKey identifies a tracked delivery by its recipient and packet ID
+type broadcastKey struct {
+	clientID string
+	packetID uint16
+}
+
+// broadcastTracker correlates PUBACKs with the deliveries of running
+// broadcasts
+type broadcastTracker struct {
+	mu      sync.Mutex
+	pending map[broadcastKey]*broadcast
+}
+
+// broadcast is a running broadcast awaiting acknowledgements
+type broadcast struct {
+	acked     map[string]bool
+	remaining int
+	done      chan struct{} // closed once every delivery is acknowledged
+}
+
+func newBroadcastTracker() *broadcastTracker {
+	return &broadcastTracker{pending: make(map[broadcastKey]*broadcast)}
+}
+
+// expect registers a delivery before it is written, so that an early
+// PUBACK is not missed
+func (t *broadcastTracker) expect(b *broadcast, clientID string, packetID uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[broadcastKey{clientID, packetID}] = b
+	b.remaining++
+}
+
+// ack records a PUBACK for a tracked delivery
+func (t *broadcastTracker) ack(clientID string, packetID uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := broadcastKey{clientID, packetID}
+	b := t.pending[key]
+	if b == nil {
+		return
+	}
+	delete(t.pending, key)
+	b.acked[clientID] = true
+	b.remaining--
+	if b.remaining == 0 {
+		close(b.done)
+	}
+}
+
+// finish stops tracking a broadcast's deliveries and returns the clients
+// that acknowledged
+func (t *broadcastTracker) finish(b *broadcast) map[string]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, tracked := range t.pending {
+		if tracked == b {
+			delete(t.pending, key)
+		}
+	}
+	return b.acked
+}
+
+// Broadcast sends a command to the online clients subscribed to topic and
+// waits up to timeout (10s if 0) for their PUBACKs. Deliveries are QoS 1,
+// or QoS 0 for clients subscribed at QoS 0; a delivery fails for a client
+// whose inflight window is full. The command is neither retained nor
+// queued for offline sessions.
+func (s *Server) Broadcast(ctx context.Context, topic string, payload []byte, timeout time.Duration) (BroadcastReport, error) {
+	if !mqtt.ValidTopicName(topic) {
+		return BroadcastReport{}, fmt.Errorf("invalid topic %q", topic)
+	}
+	if timeout <= 0 {
+		timeout = defaultBroadcastTimeout
+	}
+	start := time.Now()
+	report := BroadcastReport{
+		Topic:        topic,
+		Acknowledged: []string{},
+		Pending:      []string{},
+		Unconfirmed:  []string{},
+		Failed:       []string{},
+	}
+	b := &broadcast{acked: make(map[string]bool), done: make(chan struct{})}
+
+	var expected []string
+	for client, subQoS := range s.broadcastRecipients(topic) {
+		report.Recipients++
+		delivery := &mqtt.PublishPacket{QoS: deliveryQoS(1, subQoS), Topic: topic, Payload: payload}
+		var packetID uint16
+		if delivery.QoS > 0 {
+			var ok bool
+			if packetID, ok = client.inflight.tryAdd(delivery, s.clock.Now()); !ok {
+				report.Failed = append(report.Failed, client.ID)
+				continue
+			}
+			s.persistInflight(client, packetID, delivery, false)
+			s.broadcasts.expect(b, client.ID, packetID)
+		}
+		switch {
+		case !s.writePublish(client, delivery, packetID, false, false):
+			report.Failed = append(report.Failed, client.ID)
+		case delivery.QoS == 0:
+			report.Unconfirmed = append(report.Unconfirmed, client.ID)
+		default:
+			expected = append(expected, client.ID)
+		}
+	}
+
+	if len(expected) > 0 {
+		timer := time.NewTimer(timeout)
+		select {
+		case <-b.done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+	}
+	acked := s.broadcasts.finish(b)
+	for _, id := range expected {
+		if acked[id] {
+			report.Acknowledged = append(report.Acknowledged, id)
+		} else {
+			report.Pending = append(report.Pending, id)
+		}
+	}
+	for _, list := range [][]string{report.Acknowledged, report.Pending, report.Unconfirmed, report.Failed} {
+		sort.Strings(list)
+	}
+	report.Elapsed = time.Since(start)
+	log.Printf("Broadcast on %s: %d recipients, %d acknowledged, %d pending", topic, report.Recipients, len(report.Acknowledged), len(report.Pending))
+	return report, nil
+}
+
+// broadcastRecipients returns the online clients allowed to receive topic
+// with the QoS of their first matching subscription
+func (s *Server) broadcastRecipients(topic string) map[*Client]byte {
+	recipients := make(map[*Client]byte)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, client := range s.clients {
+		client.mu.RLock()
+		for filter, subQoS := range client.Subscriptions {
+			if topicMatch(filter, topic) {
+				if s.authorizeRead(client, topic) {
+					recipients[client] = subQoS
+				}
+				break
+			}
+		}
+		client.mu.RUnlock()
+	}
+	return recipients
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/clock"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// SetClock replaces the wall clock behind keepalives, delayed wills, trace
+// expiry and inflight ages, so end-to-end tests can fast-forward them with
+// a clock.Virtual. It must be called before Start.
+func (s *Server) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// armKeepAlive restarts the keepalive of a client: one that stays silent
+// for one and a half keepalive periods is disconnected. The read deadline
+// enforces it on the wall clock; a virtual clock cannot move a socket
+// deadline, so a timer disconnects the client instead.
+func (s *Server) armKeepAlive(conn net.Conn, client *Client) {
+	timeout := client.keepAlive * 3 / 2
+	if s.clock == clock.Real {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		return
+	}
+	if client.keepAliveTimer == nil {
+		client.keepAliveTimer = s.clock.AfterFunc(timeout, func() { s.expireKeepAlive(client) })
+		return
+	}
+	client.keepAliveTimer.Reset(timeout)
+}
+
+// expireKeepAlive disconnects a client that exceeded its keepalive
+func (s *Server) expireKeepAlive(client *Client) {
+	if client.keepAliveOver.Swap(true) {
+		return
+	}
+	idle := s.clock.Now().Sub(time.Unix(0, client.lastPacket.Load())).Round(time.Second)
+	metrics.KeepAliveTimeouts.Inc()
+	client.disconnect(mqtt.ReasonKeepAliveTimeout, fmt.Sprintf("no packet for %s (keepalive %s)", idle, client.keepAlive))
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultWriteBufferSize is used when no write buffer size is configured
+const defaultWriteBufferSize = 4096
+
+// connWriter buffers outgoing packets for a single connection.
+//
+// Packets produced while the read loop is working through a burst of inbound
+// data (PUBACK, SUBACK, PINGRESP, ...) are queued and written with a single
+// syscall once the burst has been processed. Writes are bounded by the
+// configured write timeout; a failed or short write leaves a partial packet on
+// the wire, so the writer closes the connection and reports the same error
+// for every later call.
+type connWriter struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	buf     *bufio.Writer
+	timeout time.Duration
+	err     error // sticky error after a failed write
+}
+
+// newConnWriter wraps conn with a write buffer of the given size
+func newConnWriter(conn net.Conn, size int, timeout time.Duration) *connWriter {
+	if size <= 0 {
+		size = defaultWriteBufferSize
+	}
+	cw := &connWriter{
+		conn:    conn,
+		timeout: timeout,
+	}
+	cw.buf = bufio.NewWriterSize(deadlineWriter{cw}, size)
+	return cw
+}
+
+// Write queues a complete packet without flushing it
+func (cw *connWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.write(p)
+}
+
+// WritePacket queues a complete packet and flushes it immediately
+func (cw *connWriter) WritePacket(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	n, err := cw.write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, cw.flush()
+}
+
+// Flush writes any queued packets to the connection
+func (cw *connWriter) Flush() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.flush()
+}
+
+func (cw *connWriter) write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.buf.Write(p)
+	if err != nil {
+		cw.fail(err)
+	}
+	return n, err
+}
+
+func (cw *connWriter) flush() error {
+	if cw.err != nil {
+		return cw.err
+	}
+	if cw.buf.Buffered() == 0 {
+		return nil
+	}
+	if err := cw.buf.Flush(); err != nil {
+		cw.fail(err)
+		return err
+	}
+	return nil
+}
+
+// fail records the first write error and closes the connection, since the
+// peer may have received a truncated packet
+func (cw *connWriter) fail(err error) {
+	if cw.err == nil {
+		cw.err = fmt.Errorf("connection write failed: %w", err)
+		cw.conn.Close()
+	}
+}
+
+// deadlineWriter applies the write timeout to every write reaching the socket
+// and turns short writes into errors
+type deadlineWriter struct {
+	cw *connWriter
+}
+
+func (d deadlineWriter) Write(p []byte) (int, error) {
+	if d.cw.timeout > 0 {
+		if err := d.cw.conn.SetWriteDeadline(time.Now().Add(d.cw.timeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	written := 0
+	for written < len(p) {
+		n, err := d.cw.conn.Write(p[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// ContentTypeReport lists the content types published per topic
+type ContentTypeReport struct {
+	Totals    map[string]int64   `json:"totals"`    // messages by content type; "" = none
+	Truncated bool               `json:"truncated"` // tracking limit reached; later topics are only in the totals
+	Topics    []ContentTypeStats `json:"topics"`
+}
+
+// ContentTypeStats counts the content types published on a topic
+type ContentTypeStats struct {
+	Topic        string           `json:"topic"`
+	ContentTypes map[string]int64 `json:"content_types"` // "" = none
+	Mismatches   int64            `json:"mismatches"`    // messages not matching the topic's rule
+	LastSeen     time.Time        `json:"last_seen"`
+}
+
+// contentTypeRegistry counts the MQTT 5 Content Type of client messages by
+// topic and checks them against the configured rules
+type contentTypeRegistry struct {
+	rules      []config.ContentTypeRuleConfig
+	maxTracked int
+
+	mu        sync.Mutex
+	topics    map[string]*ContentTypeStats
+	totals    map[string]int64
+	truncated bool
+}
+
+func newContentTypeRegistry(cfg config.ContentTypeConfig) *contentTypeRegistry {
+	return &contentTypeRegistry{
+		rules:      cfg.Rules,
+		maxTracked: cfg.MaxTracked,
+		topics:     make(map[string]*ContentTypeStats),
+		totals:     make(map[string]int64),
+	}
+}
+
+// normalizeContentType drops parameters and case from a content type
+func normalizeContentType(contentType string) string {
+	media, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(media))
+}
+
+// rule returns the first rule whose filter matches topic, or nil
+func (r *contentTypeRegistry) rule(topic string) *config.ContentTypeRuleConfig {
+	for i := range r.rules {
+		if topicMatch(r.rules[i].Filter, topic) {
+			return &r.rules[i]
+		}
+	}
+	return nil
+}
+
+// accepts reports whether a rule accepts a content type; "" is a message
+// without one
+func accepts(rule *config.ContentTypeRuleConfig, contentType string, v5 bool) bool {
+	if contentType == "" {
+		// MQTT 3.1/3.1.1 messages cannot carry a Content Type
+		return !rule.Required || !v5
+	}
+	for _, pattern := range rule.ContentTypes {
+		if ok, _ := path.Match(normalizeContentType(pattern), contentType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// record counts a message's content type on its topic
+func (r *contentTypeRegistry) record(topic, contentType string, mismatch bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.totals[contentType]++
+	stats := r.topics[topic]
+	if stats == nil {
+		if len(r.topics) >= r.maxTracked {
+			r.truncated = true
+			return
+		}
+		stats = &ContentTypeStats{Topic: topic, ContentTypes: make(map[string]int64)}
+		r.topics[topic] = stats
+	}
+	stats.ContentTypes[contentType]++
+	if mismatch {
+		stats.Mismatches++
+	}
+	stats.LastSeen = time.Now()
+}
+
+// checkContentType counts the content type of a client's message and
+// reports whether the message may be published. Mismatches under a "log"
+// rule are published; a rule with fill_missing gives messages without a
+// Content Type the rule's first one.
+func (s *Server) checkContentType(client *Client, pub *mqtt.PublishPacket) bool {
+	r := s.contentTypes
+	if r == nil {
+		return true
+	}
+	value, present := pub.Properties.StringValue(mqtt.PropContentType)
+	contentType := normalizeContentType(value)
+
+	rule := r.rule(pub.Topic)
+	mismatch := rule != nil && !accepts(rule, contentType, client.ProtocolVersion == mqtt.ProtocolV5)
+	r.record(pub.Topic, contentType, mismatch)
+	if mismatch {
+		metrics.ContentTypeMismatches.WithLabelValues(rule.Filter, rule.Action).Inc()
+		log.Printf("Message from %s on %s has content type %q, expected %s (%s)",
+			client.ID, pub.Topic, contentType, strings.Join(rule.ContentTypes, ", "), rule.Action)
+		if rule.Action == "reject" {
+			return false
+		}
+	}
+	if rule != nil && rule.FillMissing && !present {
+		pub.Properties = append(pub.Properties.Without(mqtt.PropContentType),
+			mqtt.StringProperty(mqtt.PropContentType, rule.ContentTypes[0]))
+	}
+	return true
+}
+
+// ContentTypes reports the content types published per topic, sorted by
+// topic, or nil if content type tracking is disabled. A non-empty filter
+// restricts the topics listed.
+func (s *Server) ContentTypes(filter string) *ContentTypeReport {
+	r := s.contentTypes
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &ContentTypeReport{
+		Totals:    make(map[string]int64, len(r.totals)),
+		Truncated: r.truncated,
+		Topics:    []ContentTypeStats{},
+	}
+	for contentType, n := range r.totals {
+		report.Totals[contentType] = n
+	}
+	for topic, stats := range r.topics {
+		if filter != "" && !topicMatch(filter, topic) {
+			continue
+		}
+		copied := *stats
+		copied.ContentTypes = make(map[string]int64, len(stats.ContentTypes))
+		for contentType, n := range stats.ContentTypes {
+			copied.ContentTypes[contentType] = n
+		}
+		report.Topics = append(report.Topics, copied)
+	}
+	sort.Slice(report.Topics, func(i, j int) bool { return report.Topics[i].Topic < report.Topics[j].Topic })
+	return report
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// ErrClientNotFound is returned when no client with the given ID is connected
+var ErrClientNotFound = fmt.Errorf("client not connected")
+
+// DisconnectClient closes the connection of a connected client. MQTT 5
+// clients are first sent a DISCONNECT with the reason code and, if not
+// empty, the reason string; MQTT 3.1/3.1.1 have no broker-sent DISCONNECT.
+// Clients of a virtual host are named by its server name, a NUL and their
+// ClientID.
+func (s *Server) DisconnectClient(clientID string, code byte, reason string) error {
+	s.mu.RLock()
+	client, ok := s.clients[clientID]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrClientNotFound, clientID)
+	}
+
+	client.disconnect(code, reason)
+	return nil
+}
+
+// handleDisconnect processes a DISCONNECT from a client and reports whether
+// it ends the connection gracefully. The will is discarded on a normal
+// disconnection; MQTT 5 clients keep it by sending any other reason code,
+// such as 0x04 (disconnect with will message) or an error. An MQTT 5 client
+// ends its session, inflight and queued messages included, by setting the
+// Session Expiry Interval to 0, or changes how long it is kept by setting
+// another one; raising it from 0 is a protocol error, and the connection is
+// then closed as if it had been lost.
+func (s *Server) handleDisconnect(client *Client, data []byte) bool {
+	pkt, err := mqtt.DecodeDisconnectPacket(bytes.NewReader(data), len(data), client.ProtocolVersion)
+	if err != nil {
+		log.Printf("Failed to decode DISCONNECT from %s: %v", client.ID, err)
+		client.disconnect(mqtt.ReasonMalformedPacket, "malformed DISCONNECT")
+		return false
+	}
+	if expiry, ok := pkt.Properties.Uint32(mqtt.PropSessionExpiryInterval); ok {
+		if expiry > 0 && client.sessionExpiry == 0 {
+			client.disconnect(mqtt.ReasonProtocolError, "session expiry interval was 0 on CONNECT")
+			return false
+		}
+		if expiry == 0 && !client.CleanSession {
+			log.Printf("Client %s ended its session on DISCONNECT", client.ID)
+			client.sessionEnded.Store(true)
+		} else if expiry != client.sessionExpiry {
+			log.Printf("Client %s changed its session expiry interval from %ds to %ds", client.ID, client.sessionExpiry, expiry)
+			client.sessionExpiry = expiry
+			s.persistSubscriptions(client)
+		}
+	}
+	if pkt.ReasonCode == mqtt.ReasonSuccess {
+		client.discardWill()
+	} else {
+		log.Printf("Client %s disconnected with reason 0x%02x, keeping its will", client.ID, pkt.ReasonCode)
+	}
+	return true
+}
+
+// disconnect tells an MQTT 5 client why it is being disconnected and closes
+// its connection
+func (c *Client) disconnect(code byte, reason string) {
+	var props mqtt.Properties
+	if reason != "" {
+		props = mqtt.Properties{mqtt.StringProperty(mqtt.PropReasonString, reason)}
+	}
+	c.disconnectWith(code, reason, props)
+}
+
+// disconnectWith is disconnect with the properties of the DISCONNECT given
+// by the caller
+func (c *Client) disconnectWith(code byte, reason string, props mqtt.Properties) {
+	log.Printf("Disconnecting client %s (reason 0x%02x) %s", c.ID, code, reason)
+	if c.ProtocolVersion == mqtt.ProtocolV5 {
+		pkt := &mqtt.DisconnectPacket{Version: mqtt.ProtocolV5, ReasonCode: code, Properties: props}
+		data, _ := pkt.Encode()
+		if _, err := c.writer.WritePacket(data); err != nil {
+			log.Printf("Failed to send DISCONNECT to %s: %v", c.ID, err)
+		}
+	}
+	c.Conn.Close()
+}
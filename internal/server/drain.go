@@ -0,0 +1,98 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// DrainState describes a node being drained ahead of maintenance or an
+// upgrade
+type DrainState struct {
+	Draining        bool      `json:"draining"`
+	ServerReference string    `json:"server_reference,omitempty"` // where MQTT 5 clients are sent
+	Since           time.Time `json:"since,omitempty"`
+	Disconnected    int       `json:"disconnected"` // clients disconnected when the drain started
+}
+
+// Drain stops the broker from accepting connections and disconnects all
+// connected clients. MQTT 5 clients are told to use serverReference
+// (host[:port], possibly a space separated list), or the migration
+// server_reference when it is empty; MQTT 3.1/3.1.1 clients are refused
+// with server unavailable and have to find another node themselves. Persistent sessions stay in this broker's
+// store.
+func (s *Server) Drain(serverReference string) DrainState {
+	if serverReference == "" {
+		serverReference = s.config.Migration.ServerReference
+	}
+	state := &DrainState{Draining: true, ServerReference: serverReference, Since: time.Now()}
+	s.drain.Store(state)
+
+	s.mu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.RUnlock()
+
+	code, props := s.drainDisconnect()
+	for _, client := range clients {
+		client.disconnectWith(code, "node is draining", props)
+	}
+	state.Disconnected = len(clients)
+	log.Printf("Draining: disconnected %d clients, server reference %q", len(clients), serverReference)
+	return *state
+}
+
+// Undrain accepts connections again after Drain
+func (s *Server) Undrain() {
+	if s.drain.Swap(nil) != nil {
+		log.Printf("Drain ended, accepting connections")
+	}
+}
+
+// DrainState reports whether the broker is draining
+func (s *Server) DrainState() DrainState {
+	if state := s.drain.Load(); state != nil {
+		return *state
+	}
+	return DrainState{}
+}
+
+// drainDisconnect returns the DISCONNECT reason code and properties sent to
+// MQTT 5 clients while draining
+func (s *Server) drainDisconnect() (byte, mqtt.Properties) {
+	props := mqtt.Properties{mqtt.StringProperty(mqtt.PropReasonString, "node is draining")}
+	state := s.drain.Load()
+	if state == nil || state.ServerReference == "" {
+		return mqtt.ReasonServerShuttingDown, props
+	}
+	return mqtt.ReasonUseAnotherServer, append(props, mqtt.StringProperty(mqtt.PropServerReference, state.ServerReference))
+}
+
+// refuseDraining refuses a CONNECT while draining, redirecting MQTT 5
+// clients to the server reference
+func (s *Server) refuseDraining(writer *connWriter, pkt *mqtt.ConnectPacket) bool {
+	state := s.drain.Load()
+	if state == nil {
+		return false
+	}
+	if pkt.ProtocolVersion != mqtt.ProtocolV5 || state.ServerReference == "" {
+		s.refuseBusy(writer, pkt, refusalDraining, "node is draining")
+		return true
+	}
+
+	metrics.ConnectionsRefused.WithLabelValues("use_another_server").Inc()
+	log.Printf("Redirecting client %q to %s: node is draining", pkt.ClientID, state.ServerReference)
+	connack := &mqtt.ConnackPacket{
+		Version:    mqtt.ProtocolV5,
+		ReturnCode: mqtt.ReasonUseAnotherServer,
+		Properties: append(retryProperties(s.config.RefusalHints[refusalDraining]),
+			mqtt.StringProperty(mqtt.PropServerReference, state.ServerReference)),
+	}
+	data, _ := connack.Encode()
+	writer.WritePacket(data)
+	return true
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// ErrFileNotFound is returned for files that are not being distributed
+var ErrFileNotFound = errors.New("file not found")
+
+// filesOrigin is the origin of chunk and manifest messages
+const filesOrigin = "files"
+
+// FileManifest describes a distributed file. It is retained on
+// <prefix><name>/manifest once all chunks have been retained on
+// <prefix><name>/chunk/<index>, counting from 0.
+type FileManifest struct {
+	Name       string    `json:"name"`
+	Size       int       `json:"size"`
+	SHA256     string    `json:"sha256"` // hex digest of the whole file
+	ChunkSize  int       `json:"chunk_size"`
+	Chunks     int       `json:"chunks"`
+	ChunkTopic string    `json:"chunk_topic"` // prefix of the chunk topics, followed by the index
+	AckTopic   string    `json:"ack_topic"`   // where clients report progress
+	Created    time.Time `json:"created"`
+}
+
+// FileDownload is a client's progress, as last reported on the ack topic
+type FileDownload struct {
+	ClientID string    `json:"client_id"`
+	Chunks   int       `json:"chunks"` // chunks received
+	Complete bool      `json:"complete"`
+	Updated  time.Time `json:"updated"`
+}
+
+// FileStatus is a distributed file with the progress of its downloads
+type FileStatus struct {
+	FileManifest
+	Completed int            `json:"completed"` // clients that received every chunk
+	Downloads []FileDownload `json:"downloads"`
+}
+
+// fileDistributor publishes files as retained chunks and tracks the
+// progress clients report
+type fileDistributor struct {
+	cfg config.FilesConfig
+
+	mu    sync.Mutex
+	files map[string]*distributedFile
+}
+
+type distributedFile struct {
+	manifest  FileManifest
+	downloads map[string]*FileDownload
+}
+
+func newFileDistributor(cfg config.FilesConfig) *fileDistributor {
+	return &fileDistributor{cfg: cfg, files: make(map[string]*distributedFile)}
+}
+
+// DistributeFile splits data into retained chunks of chunkSize bytes (the
+// configured size if 0) and retains its manifest after them. A file with
+// the same name is replaced and its download progress reset.
+func (s *Server) DistributeFile(name string, data []byte, chunkSize int) (FileManifest, error) {
+	d := s.files
+	if d == nil {
+		return FileManifest{}, fmt.Errorf("file distribution is disabled (files.enabled)")
+	}
+	if name == "" || strings.ContainsAny(name, "/+#") {
+		return FileManifest{}, fmt.Errorf("invalid file name %q (must be a single topic level)", name)
+	}
+	if chunkSize == 0 {
+		chunkSize = d.cfg.ChunkSize
+	}
+	if chunkSize < 1 {
+		return FileManifest{}, fmt.Errorf("invalid chunk size: %d", chunkSize)
+	}
+	if d.cfg.MaxSize > 0 && int64(len(data)) > d.cfg.MaxSize {
+		return FileManifest{}, fmt.Errorf("file of %d bytes exceeds files.max_size (%d)", len(data), d.cfg.MaxSize)
+	}
+
+	sum := sha256.Sum256(data)
+	base := d.cfg.TopicPrefix + name
+	manifest := FileManifest{
+		Name:       name,
+		Size:       len(data),
+		SHA256:     hex.EncodeToString(sum[:]),
+		ChunkSize:  chunkSize,
+		Chunks:     (len(data) + chunkSize - 1) / chunkSize,
+		ChunkTopic: base + "/chunk/",
+		AckTopic:   base + "/ack",
+		Created:    time.Now(),
+	}
+
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		return FileManifest{}, err
+	}
+	d.mu.Lock()
+	previous := 0
+	if old := d.files[name]; old != nil {
+		previous = old.manifest.Chunks
+	}
+	d.mu.Unlock()
+
+	// Publish hooks run synchronously, so nothing is published while the
+	// distributor is locked
+	for i := 0; i < manifest.Chunks; i++ {
+		chunk := data[i*chunkSize : min((i+1)*chunkSize, len(data))]
+		s.retainFileMessage(manifest.ChunkTopic+strconv.Itoa(i), chunk)
+	}
+	for i := manifest.Chunks; i < previous; i++ {
+		s.retainFileMessage(manifest.ChunkTopic+strconv.Itoa(i), nil)
+	}
+	s.retainFileMessage(base+"/manifest", payload)
+
+	d.mu.Lock()
+	d.files[name] = &distributedFile{manifest: manifest, downloads: make(map[string]*FileDownload)}
+	d.mu.Unlock()
+	return manifest, nil
+}
+
+// RemoveFile clears a file's retained chunks and manifest and forgets its
+// download progress
+func (s *Server) RemoveFile(name string) error {
+	d := s.files
+	if d == nil {
+		return ErrFileNotFound
+	}
+	d.mu.Lock()
+	f := d.files[name]
+	delete(d.files, name)
+	d.mu.Unlock()
+	if f == nil {
+		return ErrFileNotFound
+	}
+
+	s.retainFileMessage(d.cfg.TopicPrefix+name+"/manifest", nil)
+	for i := 0; i < f.manifest.Chunks; i++ {
+		s.retainFileMessage(f.manifest.ChunkTopic+strconv.Itoa(i), nil)
+	}
+	return nil
+}
+
+// Files returns the distributed files with their download progress
+func (s *Server) Files() []FileStatus {
+	files := []FileStatus{}
+	if s.files == nil {
+		return files
+	}
+	s.files.mu.Lock()
+	defer s.files.mu.Unlock()
+	for _, f := range s.files.files {
+		files = append(files, f.status())
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// File returns a distributed file with its download progress
+func (s *Server) File(name string) (FileStatus, error) {
+	if s.files == nil {
+		return FileStatus{}, ErrFileNotFound
+	}
+	s.files.mu.Lock()
+	defer s.files.mu.Unlock()
+	f := s.files.files[name]
+	if f == nil {
+		return FileStatus{}, ErrFileNotFound
+	}
+	return f.status(), nil
+}
+
+// retainFileMessage retains a chunk or manifest, or clears it if payload
+// is empty
+func (s *Server) retainFileMessage(topic string, payload []byte) {
+	s.publishMessage(&mqtt.PublishPacket{Topic: topic, Payload: payload, QoS: 1, Retain: true}, filesOrigin)
+}
+
+// recordAck is a publish hook recording the progress clients report by
+// publishing the number of chunks they have received to a file's ack topic
+func (d *fileDistributor) recordAck(msg *Message) {
+	rest, ok := strings.CutPrefix(msg.Topic, d.cfg.TopicPrefix)
+	if !ok {
+		return
+	}
+	name, ok := strings.CutSuffix(rest, "/ack")
+	if !ok {
+		return
+	}
+	chunks, err := strconv.Atoi(strings.TrimSpace(string(msg.Payload)))
+	if err != nil || chunks < 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.files[name]
+	if f == nil {
+		return
+	}
+	chunks = min(chunks, f.manifest.Chunks)
+	f.downloads[msg.Origin] = &FileDownload{
+		ClientID: msg.Origin,
+		Chunks:   chunks,
+		Complete: chunks == f.manifest.Chunks,
+		Updated:  time.Now(),
+	}
+}
+
+// status reports a file's progress. The caller holds the distributor's lock.
+func (f *distributedFile) status() FileStatus {
+	status := FileStatus{FileManifest: f.manifest, Downloads: []FileDownload{}}
+	for _, download := range f.downloads {
+		status.Downloads = append(status.Downloads, *download)
+		if download.Complete {
+			status.Completed++
+		}
+	}
+	sort.Slice(status.Downloads, func(i, j int) bool { return status.Downloads[i].ClientID < status.Downloads[j].ClientID })
+	return status
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// ErrGroupNotFound is returned by group operations on an unknown group
+var ErrGroupNotFound = fmt.Errorf("group not found")
+
+// clientGroup is a named set of clients selected by ClientID or username
+// patterns, used for bulk administrative operations
+type clientGroup struct {
+	config.GroupConfig
+	limiter *groupRateLimit // nil when no limit applies
+}
+
+// groupRateLimit is the per-member publish rate applied to a group
+type groupRateLimit struct {
+	Rate  float64 `json:"messages_per_second"`
+	Burst int     `json:"burst"`
+}
+
+// clientStats holds traffic counters for a connected client
+type clientStats struct {
+	messagesIn  atomic.Uint64
+	messagesOut atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+}
+
+// GroupStats summarizes a group and its currently connected members
+type GroupStats struct {
+	Name             string          `json:"name"`
+	CommandTopic     string          `json:"command_topic,omitempty"`
+	Members          []string        `json:"members"`
+	RateLimit        *groupRateLimit `json:"rate_limit,omitempty"`
+	MessagesReceived uint64          `json:"messages_received"`
+	MessagesSent     uint64          `json:"messages_sent"`
+	BytesReceived    uint64          `json:"bytes_received"`
+	BytesSent        uint64          `json:"bytes_sent"`
+}
+
+func newClientGroups(cfgs []config.GroupConfig) map[string]*clientGroup {
+	groups := make(map[string]*clientGroup, len(cfgs))
+	for _, gc := range cfgs {
+		g := &clientGroup{GroupConfig: gc}
+		if gc.RateLimit > 0 {
+			g.limiter = &groupRateLimit{Rate: gc.RateLimit, Burst: gc.RateBurst}
+		}
+		groups[gc.Name] = g
+	}
+	return groups
+}
+
+// matches reports whether a client belongs to the group
+func (g *clientGroup) matches(clientID, username string) bool {
+	for _, pattern := range g.ClientIDs {
+		if ok, _ := path.Match(pattern, clientID); ok {
+			return true
+		}
+	}
+	if username == "" {
+		return false
+	}
+	for _, pattern := range g.Usernames {
+		if ok, _ := path.Match(pattern, username); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// commandTopic expands the group's command topic template for a member
+func (g *clientGroup) commandTopic(client *Client) string {
+	return strings.NewReplacer("%c", client.ID, "%u", client.Username).Replace(g.CommandTopic)
+}
+
+// assignGroups tags a newly connected client with its groups and applies the
+// strictest group rate limit
+func (s *Server) assignGroups(client *Client) {
+	s.groupsMu.RLock()
+	defer s.groupsMu.RUnlock()
+
+	var limit *groupRateLimit
+	for _, g := range s.groups {
+		if !g.matches(client.ID, client.Username) {
+			continue
+		}
+		client.Groups = append(client.Groups, g.Name)
+		if g.limiter != nil && (limit == nil || g.limiter.Rate < limit.Rate) {
+			limit = g.limiter
+		}
+	}
+	sort.Strings(client.Groups)
+
+	if limit != nil {
+		client.limiter.Store(newRateLimiter(limit.Rate, limit.Burst))
+	}
+}
+
+// groupMembers returns the connected clients belonging to a group
+func (s *Server) groupMembers(name string) (*clientGroup, []*Client, error) {
+	s.groupsMu.RLock()
+	g, ok := s.groups[name]
+	s.groupsMu.RUnlock()
+	if !ok {
+		return nil, nil, ErrGroupNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var members []*Client
+	for _, client := range s.clients {
+		for _, groupName := range client.Groups {
+			if groupName == name {
+				members = append(members, client)
+				break
+			}
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return g, members, nil
+}
+
+// Groups returns aggregate statistics for every configured group
+func (s *Server) Groups() []GroupStats {
+	s.groupsMu.RLock()
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	s.groupsMu.RUnlock()
+	sort.Strings(names)
+
+	stats := make([]GroupStats, 0, len(names))
+	for _, name := range names {
+		if gs, err := s.GroupStats(name); err == nil {
+			stats = append(stats, gs)
+		}
+	}
+	return stats
+}
+
+// GroupStats returns aggregate statistics for a single group
+func (s *Server) GroupStats(name string) (GroupStats, error) {
+	g, members, err := s.groupMembers(name)
+	if err != nil {
+		return GroupStats{}, err
+	}
+
+	s.groupsMu.RLock()
+	gs := GroupStats{
+		Name:         g.Name,
+		CommandTopic: g.CommandTopic,
+		Members:      make([]string, 0, len(members)),
+		RateLimit:    g.limiter,
+	}
+	s.groupsMu.RUnlock()
+
+	for _, client := range members {
+		gs.Members = append(gs.Members, client.ID)
+		gs.MessagesReceived += client.stats.messagesIn.Load()
+		gs.MessagesSent += client.stats.messagesOut.Load()
+		gs.BytesReceived += client.stats.bytesIn.Load()
+		gs.BytesSent += client.stats.bytesOut.Load()
+	}
+	return gs, nil
+}
+
+// DisconnectGroup closes the connections of all connected group members and
+// returns how many were disconnected
+func (s *Server) DisconnectGroup(name string) (int, error) {
+	_, members, err := s.groupMembers(name)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, client := range members {
+		client.disconnect(mqtt.ReasonAdministrativeAction, "disconnected by administrator")
+	}
+	log.Printf("Disconnected %d members of group %s", len(members), name)
+	return len(members), nil
+}
+
+// SetGroupRateLimit applies a per-member publish rate limit to a group.
+// A rate of zero removes the limit.
+func (s *Server) SetGroupRateLimit(name string, rate float64, burst int) error {
+	if rate < 0 {
+		return fmt.Errorf("invalid rate limit: %v (must not be negative)", rate)
+	}
+
+	s.groupsMu.Lock()
+	g, ok := s.groups[name]
+	if !ok {
+		s.groupsMu.Unlock()
+		return ErrGroupNotFound
+	}
+	if rate == 0 {
+		g.limiter = nil
+	} else {
+		g.limiter = &groupRateLimit{Rate: rate, Burst: burst}
+	}
+	s.groupsMu.Unlock()
+
+	_, members, _ := s.groupMembers(name)
+	for _, client := range members {
+		if rate == 0 {
+			client.limiter.Store(nil)
+		} else {
+			client.limiter.Store(newRateLimiter(rate, burst))
+		}
+	}
+	log.Printf("Set rate limit for group %s to %.2f msg/s (burst %d, %d members)", name, rate, burst, len(members))
+	return nil
+}
+
+// PublishToGroup publishes a message to the command topic of every connected
+// group member and returns the number of members addressed
+func (s *Server) PublishToGroup(name string, payload []byte, qos byte, retain bool) (int, error) {
+	g, members, err := s.groupMembers(name)
+	if err != nil {
+		return 0, err
+	}
+	if g.CommandTopic == "" {
+		return 0, fmt.Errorf("group %s has no command_topic configured", name)
+	}
+
+	for _, client := range members {
+		s.publishMessage(&mqtt.PublishPacket{
+			Topic:   client.mount(g.commandTopic(client)),
+			QoS:     qos,
+			Retain:  retain,
+			Payload: payload,
+		}, "")
+	}
+	return len(members), nil
+}
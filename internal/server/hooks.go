@@ -0,0 +1,208 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// Message is a published message as seen by broker extensions such as bridges
+type Message struct {
+	Topic      string
+	Payload    []byte
+	QoS        byte
+	Retain     bool
+	Origin     string          // publishing ClientID, or the name of the extension that injected it
+	Properties mqtt.Properties // MQTT 5 properties, such as user properties
+}
+
+// PublishHook is called for every message accepted for routing. Hooks run on
+// the publisher's goroutine and must not block.
+type PublishHook func(msg *Message)
+
+// PublishAuthorizer decides whether a client may publish to a topic. Topics
+// are passed without the client's mountpoint. Authorizers must not block.
+type PublishAuthorizer func(clientID, username, topic string) bool
+
+// ReadAuthorizer decides whether a client may receive messages on a topic,
+// whether routed live, queued for its session or retained. Topics are
+// passed without the client's mountpoint. Authorizers must not block.
+type ReadAuthorizer func(clientID, username, topic string) bool
+
+// SubscribeAuthorizer decides whether a client may subscribe to a topic
+// filter. Filters are passed without the client's mountpoint. Authorizers
+// must not block.
+type SubscribeAuthorizer func(clientID, username, filter string) bool
+
+// Event types reported to event hooks
+const (
+	EventSlowConsumer          = "slow_consumer"
+	EventSlowConsumerRecovered = "slow_consumer_recovered"
+	EventMaintenanceStarted    = "maintenance_started"
+	EventMaintenanceEnded      = "maintenance_ended"
+	EventSubscriptionRejected  = "subscription_rejected"
+	EventStoreDown             = "store_down"
+	EventStoreUp               = "store_up"
+	EventClientRevoked         = "client_revoked"
+	EventQuotaExceeded         = "quota_exceeded"
+	EventTrafficAnomaly        = "traffic_anomaly"
+	EventTrafficAnomalyEnded   = "traffic_anomaly_ended"
+)
+
+// Event is a notable broker occurrence reported to event hooks
+type Event struct {
+	Type       string        `json:"type"`
+	ClientID   string        `json:"client_id,omitempty"`
+	Time       time.Time     `json:"time"`
+	QueueDepth int           `json:"queue_depth,omitempty"`
+	OldestAge  time.Duration `json:"oldest_age,omitempty"`
+	Window     string        `json:"window,omitempty"` // maintenance window name
+	Username   string        `json:"username,omitempty"`
+	Filter     string        `json:"filter,omitempty"` // rejected topic filter, or topic prefix of a traffic anomaly
+	Reason     string        `json:"reason,omitempty"` // why the subscription was rejected, or the store failed
+}
+
+// EventHook is called for every broker event. Hooks must not block.
+type EventHook func(ev *Event)
+
+// AddEventHook registers a hook called for every broker event
+func (s *Server) AddEventHook(hook EventHook) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.eventHooks = append(s.eventHooks, hook)
+}
+
+// emitEvent stamps an event and hands it to all registered hooks
+func (s *Server) emitEvent(ev *Event) {
+	s.hooksMu.RLock()
+	hooks := s.eventHooks
+	s.hooksMu.RUnlock()
+
+	ev.Time = time.Now()
+	for _, hook := range hooks {
+		hook(ev)
+	}
+}
+
+// AddPublishAuthorizer registers an authorizer consulted for every PUBLISH
+// received from a client; a message is rejected if any authorizer denies it
+func (s *Server) AddPublishAuthorizer(authorizer PublishAuthorizer) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.authorizers = append(s.authorizers, authorizer)
+}
+
+// authorizePublish reports whether a client may publish to a (mounted)
+// topic. Topics starting with "$" are reserved for the broker.
+func (s *Server) authorizePublish(client *Client, topic string) bool {
+	topic = strings.TrimPrefix(topic, client.mountpoint)
+	if strings.HasPrefix(topic, "$") {
+		return false
+	}
+
+	s.hooksMu.RLock()
+	authorizers := s.authorizers
+	s.hooksMu.RUnlock()
+
+	for _, authorize := range authorizers {
+		if !authorize(client.ID, client.Username, topic) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddReadAuthorizer registers an authorizer consulted before every delivery
+// to a client; a message is withheld if any authorizer denies it
+func (s *Server) AddReadAuthorizer(authorizer ReadAuthorizer) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.readers = append(s.readers, authorizer)
+}
+
+// authorizeRead reports whether a client may receive a message on a
+// (mounted) topic
+func (s *Server) authorizeRead(client *Client, topic string) bool {
+	s.hooksMu.RLock()
+	authorizers := s.readers
+	s.hooksMu.RUnlock()
+
+	topic = strings.TrimPrefix(topic, client.mountpoint)
+	for _, authorize := range authorizers {
+		if !authorize(client.ID, client.Username, topic) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddSubscribeAuthorizer registers an authorizer consulted for every topic
+// filter a client subscribes to; a subscription is rejected if any
+// authorizer denies it
+func (s *Server) AddSubscribeAuthorizer(authorizer SubscribeAuthorizer) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.subscribers = append(s.subscribers, authorizer)
+}
+
+// authorizeSubscribe reports whether a client may subscribe to an
+// (unmounted) topic filter
+func (s *Server) authorizeSubscribe(client *Client, filter string) bool {
+	s.hooksMu.RLock()
+	authorizers := s.subscribers
+	s.hooksMu.RUnlock()
+
+	for _, authorize := range authorizers {
+		if !authorize(client.ID, client.Username, filter) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddPublishHook registers a hook called for every routed message
+func (s *Server) AddPublishHook(hook PublishHook) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.publishHooks = append(s.publishHooks, hook)
+}
+
+// Publish injects a message into the broker as if it had been published by
+// a client, updating retained state and routing it to subscribers
+func (s *Server) Publish(msg *Message) {
+	s.publishMessage(&mqtt.PublishPacket{
+		Topic:      msg.Topic,
+		Payload:    msg.Payload,
+		QoS:        msg.QoS,
+		Retain:     msg.Retain,
+		Properties: msg.Properties,
+	}, msg.Origin)
+}
+
+// runPublishHooks hands a routed message to all registered hooks
+func (s *Server) runPublishHooks(pub *mqtt.PublishPacket, origin string) {
+	s.hooksMu.RLock()
+	hooks := s.publishHooks
+	s.hooksMu.RUnlock()
+
+	if len(hooks) == 0 {
+		return
+	}
+	msg := &Message{
+		Topic:      pub.Topic,
+		Payload:    pub.Payload,
+		QoS:        pub.QoS,
+		Retain:     pub.Retain,
+		Origin:     origin,
+		Properties: pub.Properties,
+	}
+	for _, hook := range hooks {
+		hook(msg)
+	}
+}
+
+// TopicMatch reports whether a topic filter matches a topic name
+func TopicMatch(filter, topic string) bool {
+	return topicMatch(filter, topic)
+}
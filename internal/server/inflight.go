@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/clock"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// inflightMessage is a QoS 1 delivery awaiting the subscriber's PUBACK, or
+// a QoS 2 delivery awaiting its PUBREC or PUBCOMP
+type inflightMessage struct {
+	packetID uint16
+	pub      *mqtt.PublishPacket // as delivered: mounted topic and delivery QoS
+	sentAt   time.Time           // first sent, or restored from the store
+	retries  int                 // times resent with the DUP flag
+	released bool                // QoS 2: PUBREC received and PUBREL sent
+}
+
+// inflightWindow assigns packet IDs to outgoing QoS 1/2 deliveries and keeps
+// them, in the order they were sent, until they are acknowledged. At most
+// max deliveries are in flight at once; further ones wait for room.
+type inflightWindow struct {
+	mu       sync.Mutex
+	lastID   uint16
+	max      int                         // limits.max_inflight_messages, 0 for as many as there are packet IDs
+	messages []*inflightMessage          // in the order they were sent, including acknowledged ones not compacted yet
+	index    map[uint16]*inflightMessage // packet ID -> unacknowledged delivery
+	freed    chan struct{}               // closed when a delivery leaves the window, nil if nobody waits
+	memory   *memoryGuard                // accounts the deliveries as memInflight, nil once the connection has ended
+	bytes    int64                       // accounted in memory
+}
+
+// add tracks a delivery under a free packet ID, sent at the time of clk
+// once it is in the window. While the window is full it waits for an
+// acknowledgement to make room, and fails if ctx ends first.
+func (w *inflightWindow) add(ctx context.Context, pub *mqtt.PublishPacket, clk clock.Clock) (uint16, bool) {
+	for {
+		packetID, freed := w.reserve(pub, clk.Now())
+		if freed == nil {
+			return packetID, true
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return 0, false
+		}
+	}
+}
+
+// tryAdd tracks a delivery sent at now under a free packet ID, failing
+// when the window is full
+func (w *inflightWindow) tryAdd(pub *mqtt.PublishPacket, now time.Time) (uint16, bool) {
+	packetID, freed := w.reserve(pub, now)
+	return packetID, freed == nil
+}
+
+// reserve tracks a delivery sent at now under a free packet ID if the
+// window has room, and otherwise returns a channel closed once a delivery
+// leaves it
+func (w *inflightWindow) reserve(pub *mqtt.PublishPacket, now time.Time) (uint16, <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if n := len(w.index); n >= 0xFFFF || (w.max > 0 && n >= w.max) {
+		if w.freed == nil {
+			w.freed = make(chan struct{})
+		}
+		return 0, w.freed
+	}
+	for {
+		w.lastID++
+		if _, used := w.index[w.lastID]; w.lastID != 0 && !used {
+			break
+		}
+	}
+	w.track(&inflightMessage{packetID: w.lastID, pub: pub, sentAt: now})
+	return w.lastID, nil
+}
+
+// restore tracks a delivery resumed from an earlier connection under its
+// original packet ID. Resumed deliveries may exceed the window.
+func (w *inflightWindow) restore(m inflightMessage) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.index[m.packetID]; !ok {
+		w.track(&m)
+	}
+}
+
+// track adds a delivery to the window. The caller holds w.mu.
+func (w *inflightWindow) track(m *inflightMessage) {
+	if w.index == nil {
+		w.index = make(map[uint16]*inflightMessage)
+	}
+	w.index[m.packetID] = m
+	w.messages = append(w.messages, m)
+	w.account(publishMemorySize(m.pub.Topic, m.pub.Payload))
+}
+
+// account adjusts the memory held by the deliveries in the window. The
+// caller holds w.mu.
+func (w *inflightWindow) account(delta int64) {
+	if w.memory == nil {
+		return
+	}
+	w.bytes += delta
+	w.memory.add(memInflight, delta)
+}
+
+// close stops accounting for the window once its connection has ended. The
+// deliveries left in it are kept by the store or the connection that took
+// the session over, which accounts for them in turn.
+func (w *inflightWindow) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.memory != nil {
+		w.memory.add(memInflight, -w.bytes)
+		w.memory, w.bytes = nil, 0
+	}
+}
+
+// resent counts a retransmission of a delivery
+func (w *inflightWindow) resent(packetID uint16) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if m := w.index[packetID]; m != nil {
+		m.retries++
+	}
+}
+
+// ack forgets a QoS 1 delivery acknowledged with PUBACK and reports
+// whether it was tracked. QoS 2 deliveries leave the window through
+// release and complete, or refuse.
+func (w *inflightWindow) ack(packetID uint16) bool {
+	return w.remove(packetID, func(m *inflightMessage) bool { return m.pub.QoS == 1 })
+}
+
+// release marks a QoS 2 delivery as received by the subscriber. Its payload
+// is no longer needed, since only PUBREL is resent from now on. It returns
+// the delivery as it is now tracked, or nil if it is unknown.
+func (w *inflightWindow) release(packetID uint16) *mqtt.PublishPacket {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := w.index[packetID]
+	if m == nil || m.pub.QoS != 2 {
+		return nil
+	}
+	if !m.released {
+		m.released = true
+		w.account(-int64(len(m.pub.Payload)))
+		m.pub = &mqtt.PublishPacket{Topic: m.pub.Topic, QoS: 2}
+	}
+	return m.pub
+}
+
+// complete forgets a released QoS 2 delivery on PUBCOMP and reports whether
+// it was tracked
+func (w *inflightWindow) complete(packetID uint16) bool {
+	return w.remove(packetID, func(m *inflightMessage) bool { return m.released })
+}
+
+// refuse forgets a QoS 2 delivery the subscriber refused with an error
+// PUBREC and reports whether it was tracked
+func (w *inflightWindow) refuse(packetID uint16) bool {
+	return w.remove(packetID, func(m *inflightMessage) bool { return m.pub.QoS == 2 && !m.released })
+}
+
+// remove forgets a delivery in the state an acknowledgement expects, making
+// room for a delivery waiting in add
+func (w *inflightWindow) remove(packetID uint16, expected func(m *inflightMessage) bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := w.index[packetID]
+	if m == nil || !expected(m) {
+		return false
+	}
+	delete(w.index, packetID)
+	w.account(-publishMemorySize(m.pub.Topic, m.pub.Payload))
+	if w.freed != nil {
+		close(w.freed)
+		w.freed = nil
+	}
+
+	// Acknowledged deliveries are dropped from the send order once they
+	// make up half of it
+	if len(w.messages) > 2*len(w.index) {
+		live := w.messages[:0]
+		for _, m := range w.messages {
+			if w.index[m.packetID] == m {
+				live = append(live, m)
+			}
+		}
+		clear(w.messages[len(live):])
+		w.messages = live
+	}
+	return true
+}
+
+// unacknowledged returns the deliveries still in the window in the order
+// they were sent. The caller holds w.mu.
+func (w *inflightWindow) unacknowledged() []*inflightMessage {
+	list := make([]*inflightMessage, 0, len(w.index))
+	for _, m := range w.messages {
+		if w.index[m.packetID] == m {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// snapshot returns the unacknowledged deliveries in the order they were sent
+func (w *inflightWindow) snapshot() []*inflightMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.unacknowledged()
+}
+
+// copies returns copies of the unacknowledged deliveries, safe to read
+// while the window changes
+func (w *inflightWindow) copies() []inflightMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	messages := w.unacknowledged()
+	list := make([]inflightMessage, len(messages))
+	for i, m := range messages {
+		list[i] = *m
+	}
+	return list
+}
+
+// handlePuback completes a QoS 1 delivery
+func (s *Server) handlePuback(client *Client, data []byte) {
+	puback, err := mqtt.DecodePubackPacket(bytes.NewReader(data), len(data))
+	if err != nil {
+		log.Printf("Failed to decode PUBACK from %s: %v", client.ID, err)
+		s.dropMalformed(client, err)
+		return
+	}
+	if !client.inflight.ack(puback.PacketID) {
+		log.Printf("PUBACK from %s for unknown packet %d", client.ID, puback.PacketID)
+		return
+	}
+	s.broadcasts.ack(client.ID, puback.PacketID)
+	s.clearInflight(client, puback.PacketID)
+}
+
+// persistInflight records a QoS 1/2 delivery of a persistent session so it
+// survives a reconnect or broker restart. released records that a QoS 2
+// delivery awaits PUBCOMP.
+func (s *Server) persistInflight(client *Client, packetID uint16, pub *mqtt.PublishPacket, released bool) {
+	if client.CleanSession || s.store == nil {
+		return
+	}
+	msg := &store.InflightMessage{
+		PacketID: packetID,
+		Message:  &store.Message{Topic: pub.Topic, Payload: pub.Payload, QoS: pub.QoS},
+		Released: released,
+	}
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	if err := s.store.PersistInflight(ctx, client.key, msg); err != nil {
+		log.Printf("Failed to persist inflight message %d of %s: %v", packetID, client.ID, err)
+	}
+}
+
+// clearInflight removes an acknowledged delivery of a persistent session
+// from the store
+func (s *Server) clearInflight(client *Client, packetID uint16) {
+	if client.CleanSession || s.store == nil {
+		return
+	}
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	if err := s.store.ClearInflight(ctx, client.key, packetID); err != nil {
+		log.Printf("Failed to clear inflight message %d of %s: %v", packetID, client.ID, err)
+	}
+}
+
+// restoreInflight gives a resumed persistent session the unacknowledged
+// deliveries of its previous connection or, failing that, the store
+func (s *Server) restoreInflight(client, previous *Client) {
+	if previous != nil {
+		for _, m := range previous.inflight.copies() {
+			client.inflight.restore(m)
+		}
+		return
+	}
+	if s.store == nil {
+		return
+	}
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	messages, err := s.store.LoadInflight(ctx, client.key)
+	if err != nil {
+		log.Printf("Failed to load inflight messages of %s: %v", client.ID, err)
+		return
+	}
+	now := s.clock.Now()
+	for _, m := range messages {
+		pub := &mqtt.PublishPacket{Topic: m.Message.Topic, Payload: m.Message.Payload, QoS: m.Message.QoS}
+		client.inflight.restore(inflightMessage{packetID: m.PacketID, pub: pub, sentAt: now, released: m.Released})
+	}
+}
+
+// resendInflight retransmits the unacknowledged deliveries of a resumed
+// session with the DUP flag set, in the order they were first sent.
+// Released QoS 2 deliveries get their PUBREL again instead.
+func (s *Server) resendInflight(client *Client) {
+	messages := client.inflight.snapshot()
+	if len(messages) == 0 {
+		return
+	}
+	log.Printf("Resending %d unacknowledged messages to %s", len(messages), client.ID)
+	for _, m := range messages {
+		client.inflight.resent(m.packetID)
+		if m.released {
+			s.sendAck(client, mqtt.PUBREL, m.packetID, mqtt.ReasonSuccess)
+			continue
+		}
+		s.writePublish(client, m.pub, m.packetID, false, true)
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// ErrPresenceNotFound is returned for clients without a presence record
+var ErrPresenceNotFound = fmt.Errorf("no presence record for client")
+
+// ClientPresence is the online status and connection history of a known
+// client
+type ClientPresence struct {
+	ClientID           string        `json:"client_id"`
+	Username           string        `json:"username,omitempty"`
+	RemoteAddr         string        `json:"remote_addr,omitempty"`
+	Online             bool          `json:"online"`
+	ConnectedAt        time.Time     `json:"connected_at"`        // Start of the current or last connection
+	LastSeen           time.Time     `json:"last_seen"`           // Now while online, else when the last connection ended
+	ConnectionDuration time.Duration `json:"connection_duration"` // Length of the current or last connection
+	Connections        uint64        `json:"connections"`         // Connections accepted since the client was first seen
+	TotalConnected     time.Duration `json:"total_connected"`     // Time spent connected, including the current connection
+}
+
+// presenceTracker keeps the last known connection state of every client
+// that has connected, persisted in the store so it survives restarts
+type presenceTracker struct {
+	mu      sync.Mutex
+	clients map[string]*store.Presence
+	store   store.Store   // nil keeps records in memory only
+	timeout time.Duration // of each store operation
+}
+
+// newPresenceTracker loads the known clients from the store. Clients still
+// marked online lost their connection when the broker last stopped.
+func newPresenceTracker(st store.Store, timeout time.Duration) *presenceTracker {
+	t := &presenceTracker{
+		clients: make(map[string]*store.Presence),
+		store:   st,
+		timeout: timeout,
+	}
+	if st == nil {
+		return t
+	}
+
+	ctx, cancel := store.OpContext(timeout)
+	defer cancel()
+	records, err := st.ListPresence(ctx)
+	if err != nil {
+		log.Printf("Failed to load client presence: %v", err)
+		return t
+	}
+	for _, p := range records {
+		p.Online = false
+		t.clients[p.ClientID] = p
+	}
+	return t
+}
+
+// connected records the start of a connection
+func (t *presenceTracker) connected(client *Client) *ClientPresence {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.clients[client.ID]
+	if !ok {
+		p = &store.Presence{ClientID: client.ID}
+		t.clients[client.ID] = p
+	}
+	if p.Online {
+		// Taken over by a new connection before the old one was removed
+		p.TotalConnected += now.Sub(p.ConnectedAt)
+	}
+	p.Username = client.Username
+	p.RemoteAddr = client.Conn.RemoteAddr().String()
+	p.Mountpoint = client.mountpoint
+	p.Online = true
+	p.ConnectedAt = now
+	p.LastSeen = now
+	p.Connections++
+	t.save(p)
+	return presenceView(p, now)
+}
+
+// disconnected records the end of a connection
+func (t *presenceTracker) disconnected(client *Client) *ClientPresence {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.clients[client.ID]
+	if !ok || !p.Online {
+		return nil
+	}
+	p.Online = false
+	p.LastSeen = now
+	p.TotalConnected += now.Sub(p.ConnectedAt)
+	t.save(p)
+	return presenceView(p, now)
+}
+
+// save persists a record; called with t.mu held
+func (t *presenceTracker) save(p *store.Presence) {
+	if t.store == nil {
+		return
+	}
+	ctx, cancel := store.OpContext(t.timeout)
+	defer cancel()
+	if err := t.store.SavePresence(ctx, p); err != nil {
+		log.Printf("Failed to save presence of %s: %v", p.ClientID, err)
+	}
+}
+
+// presenceView reports a record as seen at the given time
+func presenceView(p *store.Presence, now time.Time) *ClientPresence {
+	cp := &ClientPresence{
+		ClientID:       p.ClientID,
+		Username:       p.Username,
+		RemoteAddr:     p.RemoteAddr,
+		Online:         p.Online,
+		ConnectedAt:    p.ConnectedAt,
+		LastSeen:       p.LastSeen,
+		Connections:    p.Connections,
+		TotalConnected: p.TotalConnected,
+	}
+	if p.Online {
+		cp.LastSeen = now
+		cp.ConnectionDuration = now.Sub(p.ConnectedAt)
+		cp.TotalConnected += cp.ConnectionDuration
+	} else if !p.ConnectedAt.IsZero() && p.LastSeen.After(p.ConnectedAt) {
+		cp.ConnectionDuration = p.LastSeen.Sub(p.ConnectedAt)
+	}
+	return cp
+}
+
+// ClientPresences returns the presence of all known clients sorted by
+// ClientID, or nil if presence tracking is disabled. A non-nil online
+// selects only online or only offline clients.
+func (s *Server) ClientPresences(online *bool) []*ClientPresence {
+	if s.presence == nil {
+		return nil
+	}
+	now := time.Now()
+
+	s.presence.mu.Lock()
+	result := make([]*ClientPresence, 0, len(s.presence.clients))
+	for _, p := range s.presence.clients {
+		if online == nil || p.Online == *online {
+			result = append(result, presenceView(p, now))
+		}
+	}
+	s.presence.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].ClientID < result[j].ClientID })
+	return result
+}
+
+// ClientPresence returns the presence of a known client
+func (s *Server) ClientPresence(clientID string) (*ClientPresence, error) {
+	if s.presence == nil {
+		return nil, fmt.Errorf("%w: presence tracking is disabled", ErrPresenceNotFound)
+	}
+
+	s.presence.mu.Lock()
+	defer s.presence.mu.Unlock()
+	p, ok := s.presence.clients[clientID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPresenceNotFound, clientID)
+	}
+	return presenceView(p, time.Now()), nil
+}
+
+// ForgetClientPresence removes the presence record of an offline client,
+// e.g. a decommissioned device, and clears its retained status topic
+func (s *Server) ForgetClientPresence(clientID string) error {
+	if s.presence == nil {
+		return fmt.Errorf("%w: presence tracking is disabled", ErrPresenceNotFound)
+	}
+
+	s.presence.mu.Lock()
+	p, ok := s.presence.clients[clientID]
+	switch {
+	case !ok:
+		s.presence.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrPresenceNotFound, clientID)
+	case p.Online:
+		s.presence.mu.Unlock()
+		return fmt.Errorf("client %s is online", clientID)
+	}
+	delete(s.presence.clients, clientID)
+	if s.presence.store != nil {
+		ctx, cancel := store.OpContext(s.presence.timeout)
+		err := s.presence.store.DeletePresence(ctx, clientID)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to delete presence of %s: %v", clientID, err)
+		}
+	}
+	s.presence.mu.Unlock()
+
+	if topic := s.statusTopic(clientID, p.Username); topic != "" {
+		s.publishMessage(&mqtt.PublishPacket{Topic: p.Mountpoint + topic, Retain: true}, "")
+	}
+	return nil
+}
+
+// trackPresence updates a client's presence record and mirrors it to its
+// retained status topic, if one is configured
+func (s *Server) trackPresence(client *Client, event string) {
+	if s.presence == nil {
+		return
+	}
+	var cp *ClientPresence
+	if event == presenceConnected {
+		cp = s.presence.connected(client)
+	} else {
+		cp = s.presence.disconnected(client)
+	}
+	topic := s.statusTopic(client.ID, client.Username)
+	if cp == nil || topic == "" {
+		return
+	}
+
+	payload, err := json.Marshal(cp)
+	if err != nil {
+		log.Printf("Failed to encode presence of %s: %v", client.ID, err)
+		return
+	}
+	s.publishMessage(&mqtt.PublishPacket{
+		Topic:   client.mount(topic),
+		Payload: payload,
+		QoS:     s.config.Presence.QoS,
+		Retain:  true,
+	}, "")
+}
+
+// statusTopic expands the configured status topic template for a client,
+// returning "" if none is configured or the result is not a valid topic
+func (s *Server) statusTopic(clientID, username string) string {
+	template := s.config.Presence.StatusTopic
+	if template == "" {
+		return ""
+	}
+	topic := strings.NewReplacer("%c", clientID, "%u", username).Replace(template)
+	if !mqtt.ValidTopicName(topic) {
+		return ""
+	}
+	return topic
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/base64"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// LastValue is the most recent message seen on a topic
+type LastValue struct {
+	Topic     string    `json:"topic"`
+	Payload   string    `json:"payload"`
+	Encoding  string    `json:"encoding"` // "utf-8" or "base64"
+	QoS       byte      `json:"qos"`
+	Retained  bool      `json:"retained"`
+	Publisher string    `json:"publisher,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+type lastValueEntry struct {
+	payload   []byte
+	qos       byte
+	retain    bool
+	publisher string
+	updated   time.Time
+}
+
+// lastValueCache keeps the latest payload of every published topic,
+// independent of the retain flag, so current device state can be queried
+// without subscribing
+type lastValueCache struct {
+	mu        sync.RWMutex
+	entries   map[string]*lastValueEntry // topic -> last message
+	maxTopics int                        // 0 = unlimited
+	full      bool                       // capacity warning already logged
+}
+
+func newLastValueCache(maxTopics int) *lastValueCache {
+	return &lastValueCache{
+		entries:   make(map[string]*lastValueEntry),
+		maxTopics: maxTopics,
+	}
+}
+
+// update records a message; it returns the change in cached bytes
+func (c *lastValueCache) update(pub *mqtt.PublishPacket, publisher string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old, exists := c.entries[pub.Topic]
+	if !exists && c.maxTopics > 0 && len(c.entries) >= c.maxTopics {
+		if !c.full {
+			log.Printf("Last-value cache is full (%d topics), new topics are not cached", c.maxTopics)
+			c.full = true
+		}
+		return 0
+	}
+
+	var delta int64
+	if exists {
+		delta -= publishMemorySize(pub.Topic, old.payload)
+	}
+	c.entries[pub.Topic] = &lastValueEntry{
+		payload:   pub.Payload,
+		qos:       pub.QoS,
+		retain:    pub.Retain,
+		publisher: publisher,
+		updated:   time.Now(),
+	}
+	return delta + publishMemorySize(pub.Topic, pub.Payload)
+}
+
+// list returns the cached values whose topic starts with prefix, sorted by topic
+func (c *lastValueCache) list(prefix string) []LastValue {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	values := []LastValue{}
+	for topic, e := range c.entries {
+		if !strings.HasPrefix(topic, prefix) {
+			continue
+		}
+		v := LastValue{
+			Topic:     topic,
+			QoS:       e.qos,
+			Retained:  e.retain,
+			Publisher: e.publisher,
+			Updated:   e.updated,
+		}
+		if utf8.Valid(e.payload) {
+			v.Payload, v.Encoding = string(e.payload), "utf-8"
+		} else {
+			v.Payload, v.Encoding = base64.StdEncoding.EncodeToString(e.payload), "base64"
+		}
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Topic < values[j].Topic })
+	return values
+}
+
+// LastValues returns the latest message of every topic starting with prefix.
+// It returns nil when the last-value cache is disabled.
+func (s *Server) LastValues(prefix string) []LastValue {
+	if s.lastValues == nil {
+		return nil
+	}
+	return s.lastValues.list(prefix)
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"crypto/tls"
+
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// sampleLatency records the kernel's smoothed round-trip time estimate for a
+// client's connection. It is sampled on every PINGREQ, which clients send at
+// least once per keep-alive period even when otherwise idle.
+func (s *Server) sampleLatency(client *Client) {
+	rtt, ok := connRTT(client.Conn)
+	if !ok {
+		return
+	}
+
+	listener := "tcp"
+	if _, ok := client.Conn.(*tls.Conn); ok {
+		listener = "tls"
+	}
+	metrics.ClientLatency.WithLabelValues(listener).Observe(rtt.Seconds())
+	s.tracef(client.ID, "", "round-trip time %s", rtt)
+}
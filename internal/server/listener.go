@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// listener is a bound network endpoint accepting MQTT connections
+type listener struct {
+	name      string // "tcp" or "tls" for the main listeners, used in logs
+	ln        net.Listener
+	tlsConfig *tls.Config // nil for plain TCP
+
+	cfg     *config.ListenerConfig // nil for the main listeners
+	runtime bool                   // added via the admin API and not saved to the configuration
+	removed atomic.Bool            // closed by RemoveListener, not by Stop
+}
+
+// listen binds a listener on addr
+func listen(name, addr string, tlsConfig *tls.Config) (*listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s listener on %s: %w", name, addr, err)
+	}
+	return &listener{name: name, ln: ln, tlsConfig: tlsConfig}, nil
+}
+
+// minAcceptBackoff is the first delay after a failed Accept
+const minAcceptBackoff = 5 * time.Millisecond
+
+// serve accepts connections until the listener is closed. Connections run
+// under ctx, the context of the server run.
+func (s *Server) serve(ctx context.Context, l *listener) error {
+	log.Printf("MQTT broker listening on %s (%s)", l.ln.Addr(), l.name)
+	if shaping, ok := s.config.Shaping[l.name]; ok {
+		log.Printf("Traffic shaping on %s listener: latency=%s jitter=%s read=%d B/s write=%d B/s",
+			l.name, shaping.Latency, shaping.Jitter, shaping.ReadBandwidth, shaping.WriteBandwidth)
+	}
+
+	var backoff time.Duration
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if l.removed.Load() {
+				log.Printf("Listener %s on %s closed", l.name, l.ln.Addr())
+				return nil
+			}
+			if ctx.Err() != nil {
+				return nil // Server stopped
+			}
+
+			// Errors such as running out of file descriptors persist for
+			// a while; back off instead of spinning on them
+			backoff = nextAcceptBackoff(backoff, s.config.Server.AcceptBackoff)
+			metrics.AcceptErrors.WithLabelValues(l.name).Inc()
+			log.Printf("Error accepting %s connection: %v; retrying in %s", l.name, err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		// Socket options must be applied to the raw TCP connection,
+		// before it is wrapped for TLS
+		if err := applyTCPOptions(conn, s.config.Server.TCP); err != nil {
+			log.Printf("Failed to tune connection from %s: %v", conn.RemoteAddr(), err)
+		}
+		// Shaping sits below TLS so handshakes are shaped too
+		if shaping, ok := s.config.Shaping[l.name]; ok {
+			conn = shapeConn(conn, shaping)
+		}
+		if l.tlsConfig != nil {
+			conn = tls.Server(conn, l.tlsConfig)
+		}
+
+		// Handle each connection in a goroutine
+		s.wg.Add(1)
+		go s.handleConnection(ctx, conn, l.name)
+	}
+}
+
+// nextAcceptBackoff doubles the accept retry delay up to max
+func nextAcceptBackoff(backoff, max time.Duration) time.Duration {
+	if backoff == 0 {
+		backoff = minAcceptBackoff
+	} else {
+		backoff *= 2
+	}
+	if max > 0 && backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// recoverPanic stops a panic in a connection or delivery goroutine from
+// taking down the broker. Only the goroutine's own connection is affected;
+// it must be deferred directly.
+func (s *Server) recoverPanic(what string) {
+	if r := recover(); r != nil {
+		metrics.RecoveredPanics.Inc()
+		log.Printf("Recovered from panic in %s: %v\n%s", what, r, debug.Stack())
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// ErrListenerNotFound is returned for listeners that are not open
+var ErrListenerNotFound = errors.New("listener not found")
+
+// ListenerInfo describes an open listener
+type ListenerInfo struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`    // tcp or tls
+	Address string `json:"address"` // bound address
+	Main    bool   `json:"main"`    // a listener of the server and tls sections, which cannot be removed
+	Runtime bool   `json:"runtime"` // added via the admin API without persist; gone after a restart
+}
+
+func (l *listener) info() ListenerInfo {
+	info := ListenerInfo{
+		Name:    l.name,
+		Type:    "tcp",
+		Address: l.ln.Addr().String(),
+		Main:    l.cfg == nil,
+		Runtime: l.runtime,
+	}
+	if l.tlsConfig != nil {
+		info.Type = "tls"
+	}
+	return info
+}
+
+// Listeners returns the open listeners
+func (s *Server) Listeners() []ListenerInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	listeners := make([]ListenerInfo, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		listeners = append(listeners, l.info())
+	}
+	return listeners
+}
+
+// openListener binds an additional listener. TLS listeners share the
+// certificates of the tls section.
+func (s *Server) openListener(cfg config.ListenerConfig) (*listener, error) {
+	var tlsConfig *tls.Config
+	if cfg.Type == "tls" {
+		tlsConfig = s.tlsConfig.Load()
+		if tlsConfig == nil {
+			var err error
+			if tlsConfig, err = s.buildTLSConfig(); err != nil {
+				return nil, fmt.Errorf("listener %s: %w", cfg.Name, err)
+			}
+			s.tlsConfig.Store(tlsConfig)
+		}
+	}
+	host := cfg.Host
+	if host == "" {
+		host = s.config.Server.Host
+	}
+	l, err := listen(cfg.Name, net.JoinHostPort(host, strconv.Itoa(cfg.Port)), tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	l.cfg = &cfg
+	return l, nil
+}
+
+// listenerNamed returns the open listener with a name, or nil. The caller
+// holds s.mu.
+func (s *Server) listenerNamed(name string) *listener {
+	for _, l := range s.listeners {
+		if l.name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// AddListener opens an additional listener while the server runs. With
+// persist it is also saved to listeners_file, so it is opened again after
+// a restart.
+func (s *Server) AddListener(cfg config.ListenerConfig, persist bool) (ListenerInfo, error) {
+	if err := cfg.Validate(); err != nil {
+		return ListenerInfo{}, err
+	}
+	if persist && s.config.ListenersFile == "" {
+		return ListenerInfo{}, fmt.Errorf("cannot persist listener %s: listeners_file is not set", cfg.Name)
+	}
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	s.mu.RLock()
+	running, exists := s.running, s.listenerNamed(cfg.Name) != nil
+	s.mu.RUnlock()
+	if !running {
+		return ListenerInfo{}, fmt.Errorf("server is not running")
+	}
+	if exists {
+		return ListenerInfo{}, fmt.Errorf("listener %s already exists", cfg.Name)
+	}
+
+	l, err := s.openListener(cfg)
+	if err != nil {
+		return ListenerInfo{}, err
+	}
+	l.runtime = !persist
+	if persist {
+		err := config.UpdateListenersFile(s.config.ListenersFile, func(saved []config.ListenerConfig) ([]config.ListenerConfig, error) {
+			saved = slices.DeleteFunc(saved, func(c config.ListenerConfig) bool { return c.Name == cfg.Name })
+			return append(saved, cfg), nil
+		})
+		if err != nil {
+			l.ln.Close()
+			return ListenerInfo{}, fmt.Errorf("failed to save listener %s: %w", cfg.Name, err)
+		}
+	}
+	if !s.startListener(l) {
+		return ListenerInfo{}, fmt.Errorf("server is not running")
+	}
+	return l.info(), nil
+}
+
+// startListener registers a bound listener and serves it, unless the
+// server stopped meanwhile
+func (s *Server) startListener(l *listener) bool {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		l.ln.Close()
+		return false
+	}
+	s.listeners = append(s.listeners, l)
+	ctx := s.ctx
+	s.wg.Add(1)
+	s.mu.Unlock()
+	go func() {
+		defer s.wg.Done()
+		s.serve(ctx, l)
+	}()
+	return true
+}
+
+// RemoveListener closes an additional listener. Connections it accepted
+// stay connected. With persist it is also removed from listeners_file.
+func (s *Server) RemoveListener(name string, persist bool) error {
+	if persist && s.config.ListenersFile == "" {
+		return fmt.Errorf("cannot persist removal of listener %s: listeners_file is not set", name)
+	}
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	s.mu.RLock()
+	l := s.listenerNamed(name)
+	s.mu.RUnlock()
+	if l != nil && l.cfg == nil {
+		return fmt.Errorf("listener %s is a main listener and cannot be removed", name)
+	}
+	if l == nil && !persist {
+		return fmt.Errorf("%w: %s", ErrListenerNotFound, name)
+	}
+
+	if persist {
+		err := config.UpdateListenersFile(s.config.ListenersFile, func(saved []config.ListenerConfig) ([]config.ListenerConfig, error) {
+			n := len(saved)
+			saved = slices.DeleteFunc(saved, func(c config.ListenerConfig) bool { return c.Name == name })
+			if len(saved) == n && (l == nil || !l.runtime) {
+				// Configured elsewhere, so removing it would not last
+				return nil, fmt.Errorf("listener %s is not in %s; remove it where it is configured", name, s.config.ListenersFile)
+			}
+			return saved, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if l != nil {
+		s.closeListener(l)
+	}
+	return nil
+}
+
+// closeListener stops an additional listener
+func (s *Server) closeListener(l *listener) {
+	s.mu.Lock()
+	s.listeners = slices.DeleteFunc(s.listeners, func(open *listener) bool { return open == l })
+	s.mu.Unlock()
+	l.removed.Store(true)
+	l.ln.Close()
+}
+
+// ApplyListeners brings the additional listeners in line with a reloaded
+// configuration: new ones are opened, changed ones reopened and removed
+// ones closed. Listeners added at runtime without persist are kept unless
+// the configuration now defines one with the same name.
+func (s *Server) ApplyListeners(cfgs []config.ListenerConfig) error {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	s.mu.RLock()
+	open := slices.Clone(s.listeners)
+	s.mu.RUnlock()
+
+	configured := make(map[string]config.ListenerConfig, len(cfgs))
+	for _, cfg := range cfgs {
+		configured[cfg.Name] = cfg
+	}
+	for _, l := range open {
+		if l.cfg == nil {
+			continue
+		}
+		cfg, ok := configured[l.name]
+		switch {
+		case ok && cfg == *l.cfg:
+			s.mu.Lock()
+			l.runtime = false
+			s.mu.Unlock()
+			delete(configured, l.name)
+		case ok || !l.runtime:
+			log.Printf("Closing listener %s: removed or changed in the configuration", l.name)
+			s.closeListener(l)
+		}
+	}
+
+	var errs []error
+	for _, cfg := range cfgs {
+		if _, ok := configured[cfg.Name]; !ok {
+			continue
+		}
+		l, err := s.openListener(cfg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.startListener(l)
+	}
+	return errors.Join(errs...)
+}
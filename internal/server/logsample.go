@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// maxSampledTopics bounds the per-topic counters of the publish log
+// sampler; they start over when the limit is reached
+const maxSampledTopics = 100000
+
+// logSampler decides which publishes are logged: 1 in N per topic, always
+// including the first one seen, so quiet topics still show up in the log
+type logSampler struct {
+	rate   atomic.Int64 // N
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// newLogSampler creates a sampler logging 1 in rate publishes per topic,
+// or every publish if rate is not positive
+func newLogSampler(rate int) *logSampler {
+	l := &logSampler{counts: make(map[string]uint64)}
+	l.rate.Store(int64(max(rate, 1)))
+	return l
+}
+
+// sample counts a publish on topic and reports whether it should be logged
+func (l *logSampler) sample(topic string) bool {
+	rate := uint64(l.rate.Load())
+	if rate == 1 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n, ok := l.counts[topic]
+	if !ok && len(l.counts) >= maxSampledTopics {
+		clear(l.counts)
+	}
+	l.counts[topic] = n + 1
+	return n%rate == 0
+}
+
+// PublishLogSampling returns N where 1 in N publishes per topic is logged
+func (s *Server) PublishLogSampling() int {
+	return int(s.publishLog.rate.Load())
+}
+
+// SetPublishLogSampling changes at runtime how many publishes are logged:
+// 1 in n per topic, or every publish for n = 1. Packet-level detail for
+// specific clients or topics is available through traces.
+func (s *Server) SetPublishLogSampling(n int) error {
+	if n < 1 {
+		return fmt.Errorf("invalid publish log sampling: %d (must be positive)", n)
+	}
+	s.publishLog.mu.Lock()
+	clear(s.publishLog.counts)
+	s.publishLog.mu.Unlock()
+	s.publishLog.rate.Store(int64(n))
+	log.Printf("Publish log sampling set to 1 in %d per topic", n)
+	return nil
+}
+
+// publisherName names the publisher of a message in the log
+func publisherName(publisherID string) string {
+	if publisherID == "" {
+		return "broker"
+	}
+	return publisherID
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// Maintenance window actions
+const (
+	MaintenanceMute   = "mute"   // publishes are accepted but not routed
+	MaintenanceReject = "reject" // publishes are refused (MQTT 5 clients get a PUBACK reason code)
+)
+
+// ErrWindowNotFound is returned for unknown maintenance windows
+var ErrWindowNotFound = errors.New("maintenance window not found")
+
+// MaintenanceWindow mutes or rejects publishes on a set of topic filters
+// for a planned period, e.g. while a downstream consumer is down
+type MaintenanceWindow struct {
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Topics   []string  `json:"topics"`
+	Action   string    `json:"action"`
+	Active   bool      `json:"active"`   // Set in listings: the window is in effect now
+	Affected uint64    `json:"affected"` // Set in listings: publishes muted or rejected so far
+}
+
+// active reports whether the window is in effect at t
+func (w *MaintenanceWindow) active(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// validate checks a window definition
+func (w *MaintenanceWindow) validate() error {
+	if w.Name == "" {
+		return fmt.Errorf("maintenance window needs a name")
+	}
+	if !w.End.After(w.Start) {
+		return fmt.Errorf("maintenance window %s: end must be after start", w.Name)
+	}
+	if len(w.Topics) == 0 {
+		return fmt.Errorf("maintenance window %s: no topics", w.Name)
+	}
+	if w.Action != MaintenanceMute && w.Action != MaintenanceReject {
+		return fmt.Errorf("maintenance window %s: invalid action %q (must be mute or reject)", w.Name, w.Action)
+	}
+	return nil
+}
+
+// scheduledWindow is a window with its start/end timers
+type scheduledWindow struct {
+	MaintenanceWindow
+	affected atomic.Uint64
+	timers   []*time.Timer
+}
+
+// maintenanceSchedule holds the configured windows. Publishes only check
+// the windows while at least one exists.
+type maintenanceSchedule struct {
+	mu      sync.RWMutex
+	windows map[string]*scheduledWindow
+	count   atomic.Int32
+}
+
+func newMaintenanceSchedule() *maintenanceSchedule {
+	return &maintenanceSchedule{windows: make(map[string]*scheduledWindow)}
+}
+
+// match returns the first window in effect whose topics match the topic
+func (m *maintenanceSchedule) match(topic string) *scheduledWindow {
+	if m.count.Load() == 0 {
+		return nil
+	}
+	now := time.Now()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, w := range m.windows {
+		if !w.active(now) {
+			continue
+		}
+		for _, filter := range w.Topics {
+			if topicMatch(filter, topic) {
+				return w
+			}
+		}
+	}
+	return nil
+}
+
+// underMaintenance reports whether a window in effect applies the given
+// action to publishes on topic, counting the publish if so
+func (s *Server) underMaintenance(topic, action string) bool {
+	w := s.maintenance.match(topic)
+	if w == nil || w.Action != action {
+		return false
+	}
+	w.affected.Add(1)
+	metrics.MaintenanceMessages.WithLabelValues(w.Name, w.Action).Inc()
+	return true
+}
+
+// AddMaintenanceWindow schedules a maintenance window. The start and end
+// of the window are logged and reported to event hooks.
+func (s *Server) AddMaintenanceWindow(window MaintenanceWindow) error {
+	if window.Action == "" {
+		window.Action = MaintenanceMute
+	}
+	if err := window.validate(); err != nil {
+		return err
+	}
+	window.Active, window.Affected = false, 0
+	window.Topics = append([]string(nil), window.Topics...)
+
+	m := s.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.windows[window.Name]; exists {
+		return fmt.Errorf("maintenance window %s already exists", window.Name)
+	}
+
+	log.Printf("Maintenance window %s scheduled: %s %s from %s to %s", window.Name, window.Action,
+		strings.Join(window.Topics, ","), window.Start.Format(time.RFC3339), window.End.Format(time.RFC3339))
+
+	w := &scheduledWindow{MaintenanceWindow: window}
+	now := time.Now()
+	if window.End.After(now) {
+		if window.Start.After(now) {
+			w.timers = append(w.timers, time.AfterFunc(window.Start.Sub(now), func() { s.maintenanceTransition(w, true) }))
+		} else {
+			s.maintenanceTransition(w, true)
+		}
+		w.timers = append(w.timers, time.AfterFunc(window.End.Sub(now), func() { s.maintenanceTransition(w, false) }))
+	}
+	m.windows[window.Name] = w
+	m.count.Add(1)
+	return nil
+}
+
+// RemoveMaintenanceWindow cancels a maintenance window, ending it at once
+// if it is in effect
+func (s *Server) RemoveMaintenanceWindow(name string) error {
+	m := s.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.windows[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrWindowNotFound, name)
+	}
+	for _, t := range w.timers {
+		t.Stop()
+	}
+	delete(m.windows, name)
+	m.count.Add(-1)
+
+	if w.active(time.Now()) {
+		s.maintenanceTransition(w, false)
+	}
+	log.Printf("Maintenance window %s removed (%d publishes affected)", name, w.affected.Load())
+	return nil
+}
+
+// MaintenanceWindows returns all scheduled windows ordered by start time
+func (s *Server) MaintenanceWindows() []MaintenanceWindow {
+	m := s.maintenance
+	now := time.Now()
+
+	m.mu.RLock()
+	windows := make([]MaintenanceWindow, 0, len(m.windows))
+	for _, w := range m.windows {
+		window := w.MaintenanceWindow
+		window.Active = w.active(now)
+		window.Affected = w.affected.Load()
+		windows = append(windows, window)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(windows, func(i, j int) bool {
+		if !windows[i].Start.Equal(windows[j].Start) {
+			return windows[i].Start.Before(windows[j].Start)
+		}
+		return windows[i].Name < windows[j].Name
+	})
+	return windows
+}
+
+// maintenanceTransition logs the start or end of a window and reports it
+// to event hooks
+func (s *Server) maintenanceTransition(w *scheduledWindow, started bool) {
+	if started {
+		log.Printf("Maintenance window %s started: %s publishes on %s until %s", w.Name, w.Action,
+			strings.Join(w.Topics, ","), w.End.Format(time.RFC3339))
+		metrics.MaintenanceWindowsActive.Inc()
+		s.emitEvent(&Event{Type: EventMaintenanceStarted, Window: w.Name})
+		return
+	}
+	log.Printf("Maintenance window %s ended: %d publishes affected", w.Name, w.affected.Load())
+	metrics.MaintenanceWindowsActive.Dec()
+	s.emitEvent(&Event{Type: EventMaintenanceEnded, Window: w.Name})
+}
+
+// scheduleMaintenance adds the windows from the configuration
+func (s *Server) scheduleMaintenance(windows []config.MaintenanceWindowConfig) error {
+	for _, w := range windows {
+		err := s.AddMaintenanceWindow(MaintenanceWindow{
+			Name:   w.Name,
+			Start:  w.Start,
+			End:    w.End,
+			Topics: w.Topics,
+			Action: w.Action,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// Triggers of client migrations, as labelled in metrics
+const (
+	migrateClients  = "clients"
+	migrateOverload = "overload"
+	migrateAdmin    = "admin"
+)
+
+// MigrationResult lists the clients sent to another node
+type MigrationResult struct {
+	ServerReference string   `json:"server_reference"`
+	Migrated        []string `json:"migrated"` // ClientIDs
+}
+
+// migrator periodically moves clients off the node while it holds more
+// than its share
+type migrator struct {
+	cfg config.MigrationConfig
+}
+
+func newMigrator(cfg config.MigrationConfig) *migrator {
+	return &migrator{cfg: cfg}
+}
+
+// run checks the load every interval until ctx is cancelled
+func (m *migrator) run(ctx context.Context, s *Server) {
+	ticker := time.NewTicker(m.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.check(s)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check migrates up to a batch of clients when the node is above
+// max_clients, or overloaded with on_overload set. A draining node has
+// already let all its clients go.
+func (m *migrator) check(s *Server) {
+	if s.drain.Load() != nil {
+		return
+	}
+	s.mu.RLock()
+	connected := len(s.clients)
+	s.mu.RUnlock()
+
+	count, trigger := 0, ""
+	if m.cfg.MaxClients > 0 && connected > m.cfg.MaxClients {
+		count, trigger = connected-m.cfg.TargetClients, migrateClients
+	} else if m.cfg.OnOverload && s.memory.Overloaded() {
+		count, trigger = m.cfg.BatchSize, migrateOverload
+	}
+	if count == 0 {
+		return
+	}
+	migrated := s.migrate(min(count, m.cfg.BatchSize), m.cfg.ServerReference, m.cfg.Policy, m.cfg.Persistent, trigger)
+	if len(migrated) > 0 {
+		log.Printf("Migrated %d of %d clients to %s (%s)", len(migrated), connected, m.cfg.ServerReference, trigger)
+	}
+}
+
+// Migrate sends up to count MQTT 5 clients to serverReference (host[:port],
+// possibly a space separated list) with a DISCONNECT reason Use another
+// server. The clients are picked by policy ("newest", "oldest" or
+// "random"); clients with a persistent session are only picked if
+// persistent is set, as their session stays in this broker's store.
+// MQTT 3.1/3.1.1 clients cannot be redirected and are never picked. An
+// empty serverReference or policy defaults to the migration settings.
+func (s *Server) Migrate(count int, serverReference, policy string, persistent bool) (MigrationResult, error) {
+	if serverReference == "" {
+		serverReference = s.config.Migration.ServerReference
+	}
+	if policy == "" {
+		policy = s.config.Migration.Policy
+	}
+	if count < 1 {
+		return MigrationResult{}, fmt.Errorf("invalid count: %d (must be positive)", count)
+	}
+	if serverReference == "" {
+		return MigrationResult{}, fmt.Errorf("no server reference given or configured")
+	}
+	if !slices.Contains(config.MigrationPolicies, policy) {
+		return MigrationResult{}, fmt.Errorf("invalid policy: %s (must be newest, oldest or random)", policy)
+	}
+	migrated := s.migrate(count, serverReference, policy, persistent, migrateAdmin)
+	log.Printf("Migrated %d clients to %s on request", len(migrated), serverReference)
+	return MigrationResult{ServerReference: serverReference, Migrated: migrated}, nil
+}
+
+// migrate disconnects up to count clients picked by policy and returns
+// their ClientIDs
+func (s *Server) migrate(count int, serverReference, policy string, persistent bool, trigger string) []string {
+	s.mu.RLock()
+	candidates := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		if client.ProtocolVersion == mqtt.ProtocolV5 && (client.CleanSession || persistent) {
+			candidates = append(candidates, client)
+		}
+	}
+	s.mu.RUnlock()
+
+	switch policy {
+	case "newest":
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].connectedAt.After(candidates[j].connectedAt) })
+	case "oldest":
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].connectedAt.Before(candidates[j].connectedAt) })
+	default:
+		rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	}
+	candidates = candidates[:min(count, len(candidates))]
+
+	props := mqtt.Properties{
+		mqtt.StringProperty(mqtt.PropReasonString, "rebalancing load"),
+		mqtt.StringProperty(mqtt.PropServerReference, serverReference),
+	}
+	migrated := make([]string, 0, len(candidates))
+	for _, client := range candidates {
+		client.disconnectWith(mqtt.ReasonUseAnotherServer, "rebalancing load to "+serverReference, props)
+		migrated = append(migrated, client.ID)
+	}
+	metrics.ClientsMigrated.WithLabelValues(trigger).Add(float64(len(migrated)))
+	return migrated
+}
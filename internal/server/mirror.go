@@ -0,0 +1,33 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// mirrorOrigin is the origin of mirrored copies
+const mirrorOrigin = "mirror"
+
+// mirrorMessage publishes a copy of a message under the prefix of every
+// mirror whose filter matches it, once the original has been routed.
+// Copies keep QoS, RETAIN flag and properties, and are not mirrored again.
+func (s *Server) mirrorMessage(pub *mqtt.PublishPacket, publisherID string) {
+	if publisherID == mirrorOrigin {
+		return
+	}
+	for _, m := range s.config.Mirrors {
+		if strings.HasPrefix(pub.Topic, m.Prefix) || !topicMatch(m.Filter, pub.Topic) {
+			continue
+		}
+		metrics.MirroredMessages.WithLabelValues(m.Prefix).Inc()
+		s.publishMessage(&mqtt.PublishPacket{
+			Topic:      m.Prefix + pub.Topic,
+			Payload:    pub.Payload,
+			QoS:        pub.QoS,
+			Retain:     pub.Retain,
+			Properties: pub.Properties,
+		}, mirrorOrigin)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// outbox holds the messages routed to a client, in the order they were
+// routed, until its delivery goroutine writes them. A single goroutine per
+// client keeps live messages in order, and behind the backlog of a
+// resuming session.
+type outbox struct {
+	mu       sync.Mutex
+	messages []outgoing
+	draining bool // a delivery goroutine is running
+}
+
+// outgoing is a message waiting in an outbox
+type outgoing struct {
+	pub    *mqtt.PublishPacket
+	subQoS byte
+	retain bool
+	logged bool
+	size   int64  // accounted in the memory guard until written
+	seq    uint64 // sequence in the client's pending deliveries
+}
+
+// push appends a message and reports whether the caller has to start the
+// delivery goroutine
+func (o *outbox) push(m outgoing) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, m)
+	start := !o.draining
+	o.draining = true
+	return start
+}
+
+// pop removes the oldest message. Once the outbox is empty the delivery
+// goroutine has to end, and the next push starts another.
+func (o *outbox) pop() (outgoing, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.messages) == 0 {
+		o.messages = nil
+		o.draining = false
+		return outgoing{}, false
+	}
+	m := o.messages[0]
+	o.messages[0] = outgoing{}
+	o.messages = o.messages[1:]
+	return m, true
+}
+
+// drainOutbox writes a client's outbox in order once its session has
+// resumed. Messages left when the connection ends are handed off to the
+// session.
+func (s *Server) drainOutbox(client *Client) {
+	defer s.wg.Done()
+	select {
+	case <-client.resumed:
+	case <-client.ctx.Done():
+	}
+	for {
+		m, ok := client.outbox.pop()
+		if !ok {
+			return
+		}
+		s.deliverOutgoing(client, m)
+	}
+}
+
+// deliverOutgoing writes one message of an outbox
+func (s *Server) deliverOutgoing(client *Client, m outgoing) {
+	defer s.recoverPanic("delivery to " + client.ID)
+	defer s.memory.add(memQueued, -m.size)
+	defer client.pending.done(m.seq)
+	s.deliverMessage(client, m.pub, m.subQoS, m.retain, m.logged)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// memoryKind identifies a category of broker-held message memory
+type memoryKind int
+
+const (
+	memRetained  memoryKind = iota // retained messages kept for new subscribers
+	memQueued                      // messages routed but not yet written to a subscriber
+	memInflight                    // QoS 1/2 messages awaiting acknowledgment
+	memLastValue                   // last-value cache entries
+	numMemoryKinds
+)
+
+var memoryKindNames = [numMemoryKinds]string{"retained", "queued", "inflight", "last_value"}
+
+// overloadRecoveryRatio is the fraction of the limit tracked memory must fall
+// below before overload mode is left, so the broker doesn't flap around the
+// threshold
+const overloadRecoveryRatio = 0.9
+
+// memoryGuard tracks an approximation of the memory held by messages and
+// switches the broker into overload mode when the configured limit is hit.
+// While overloaded, new connections are refused and QoS 0 traffic is shed.
+type memoryGuard struct {
+	limit      int64 // 0 disables the guard
+	usage      [numMemoryKinds]atomic.Int64
+	overloaded atomic.Bool
+	mu         sync.Mutex // serializes mode transitions
+}
+
+func newMemoryGuard(limit int64) *memoryGuard {
+	return &memoryGuard{limit: limit}
+}
+
+// add adjusts the tracked usage for a category by delta bytes (which may be
+// negative) and re-evaluates the overload state
+func (g *memoryGuard) add(kind memoryKind, delta int64) {
+	if delta == 0 {
+		return
+	}
+	current := g.usage[kind].Add(delta)
+	metrics.MemoryTrackedBytes.WithLabelValues(memoryKindNames[kind]).Set(float64(current))
+
+	if g.limit > 0 {
+		g.evaluate()
+	}
+}
+
+// total returns the tracked usage across all categories
+func (g *memoryGuard) total() int64 {
+	var sum int64
+	for i := range g.usage {
+		sum += g.usage[i].Load()
+	}
+	return sum
+}
+
+// Overloaded reports whether the broker is currently shedding load
+func (g *memoryGuard) Overloaded() bool {
+	return g.overloaded.Load()
+}
+
+func (g *memoryGuard) evaluate() {
+	total := g.total()
+	overloaded := g.overloaded.Load()
+	recovery := int64(float64(g.limit) * overloadRecoveryRatio)
+	if (!overloaded && total < g.limit) || (overloaded && total >= recovery) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	total = g.total()
+	switch {
+	case !g.overloaded.Load() && total >= g.limit:
+		g.overloaded.Store(true)
+		metrics.OverloadActive.Set(1)
+		log.Printf("Entering overload mode: tracked message memory %d bytes exceeds limit %d (retained=%d queued=%d inflight=%d)",
+			total, g.limit, g.usage[memRetained].Load(), g.usage[memQueued].Load(), g.usage[memInflight].Load())
+	case g.overloaded.Load() && total < recovery:
+		g.overloaded.Store(false)
+		metrics.OverloadActive.Set(0)
+		log.Printf("Leaving overload mode: tracked message memory %d bytes below %d", total, recovery)
+	}
+}
+
+// publishMemorySize approximates the memory held by a PUBLISH
+func publishMemorySize(topic string, payload []byte) int64 {
+	return int64(len(topic) + len(payload))
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// parserBudget caps how many packets and bytes a connection may send per
+// second, whatever the packets are, so a flood of cheap packets cannot keep
+// the read loop busy. A nil budget allows everything.
+type parserBudget struct {
+	phase   string // "preauth" or "connected", for logs and metrics
+	packets *rateLimiter
+	bytes   *rateLimiter
+}
+
+// newParserBudget returns the budget for a phase of a connection, or nil if
+// neither rate is limited. A second's worth of traffic may arrive at once.
+func newParserBudget(phase string, packetRate float64, byteRate int64) *parserBudget {
+	if packetRate <= 0 && byteRate <= 0 {
+		return nil
+	}
+	b := &parserBudget{phase: phase}
+	if packetRate > 0 {
+		b.packets = newRateLimiter(packetRate, int(packetRate))
+	}
+	if byteRate > 0 {
+		b.bytes = newRateLimiter(float64(byteRate), int(byteRate))
+	}
+	return b
+}
+
+// preAuthBudget returns the budget of a connection before CONNECT is accepted
+func (s *Server) preAuthBudget() *parserBudget {
+	limits := s.config.Limits
+	return newParserBudget("preauth", limits.MaxPreAuthPacketRate, limits.MaxPreAuthByteRate)
+}
+
+// connectedBudget returns the budget of a connection once CONNECT is accepted
+func (s *Server) connectedBudget() *parserBudget {
+	limits := s.config.Limits
+	return newParserBudget("connected", limits.MaxPacketRate, limits.MaxByteRate)
+}
+
+// admit charges a packet against the budget and returns the exhausted limit
+// ("packets" or "bytes"), or "" if the packet is within it
+func (b *parserBudget) admit(header *mqtt.FixedHeader) string {
+	if b == nil {
+		return ""
+	}
+	if b.packets != nil && !b.packets.Allow() {
+		return "packets"
+	}
+	if b.bytes != nil {
+		// Before CONNECT the connection sends a single packet, so one
+		// larger than a second's worth of bytes is refused outright
+		// instead of passing on the full bucket
+		size := packetSize(header)
+		if b.phase == "preauth" && float64(size) > b.bytes.burst || !b.bytes.AllowN(size) {
+			return "bytes"
+		}
+	}
+	return ""
+}
+
+// packetSize returns the size of a packet on the wire
+func packetSize(header *mqtt.FixedHeader) int {
+	size := 2 + header.RemainingLen // type byte, first length byte
+	for n := header.RemainingLen; n >= 128; n /= 128 {
+		size++
+	}
+	return size
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/auth"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// policyFor returns the policy of a user from auth.policy_file, or nil if
+// the user gets the broker defaults
+func (s *Server) policyFor(username string) *auth.Policy {
+	policies := s.policies.Load()
+	if policies == nil {
+		return nil
+	}
+	return policies.For(username)
+}
+
+// clientMaxQoS returns the highest QoS granted to a client's subscriptions
+func (s *Server) clientMaxQoS(client *Client) byte {
+	if p := client.policy; p != nil && p.MaxQoS >= 0 {
+		return min(byte(p.MaxQoS), s.maxQoS())
+	}
+	return s.maxQoS()
+}
+
+// applyPolicy applies a newly connected client's policy: its publish rate
+// limit, if stricter than its groups', and the keepalive the broker
+// enforces. requested is the keepalive from CONNECT in seconds.
+func (s *Server) applyPolicy(client *Client, requested uint16) {
+	client.keepAlive = s.keepAlive(client, requested)
+	p := client.policy
+	if p == nil {
+		return
+	}
+	if p.RateLimit > 0 {
+		if current := client.limiter.Load(); current == nil || p.RateLimit < current.rate {
+			client.limiter.Store(newRateLimiter(p.RateLimit, p.RateBurst))
+		}
+	}
+}
+
+// keepAlive returns the keepalive the broker enforces for a client: the
+// one from its policy, else the one it requested. MQTT 5 clients requesting
+// none or more than server.keep_alive are given server.keep_alive, which the
+// CONNACK announces; MQTT 3.1/3.1.1 clients cannot be told, so their own
+// keepalive stands and 0 leaves them unchecked. For them the policy only
+// lengthens a keepalive: a shorter one would disconnect clients that ping
+// on their own schedule.
+func (s *Server) keepAlive(client *Client, requested uint16) time.Duration {
+	keepAlive := time.Duration(requested) * time.Second
+	if p := client.policy; p != nil && p.KeepAlive > 0 {
+		policy := time.Duration(p.KeepAlive) * time.Second
+		if client.ProtocolVersion == mqtt.ProtocolV5 || (keepAlive > 0 && policy >= keepAlive) {
+			return policy
+		}
+		log.Printf("Not enforcing the %s keepalive policy of client %s: before MQTT 5 it cannot be announced and would cut short the client's own keepalive (%s, 0 = none)", policy, client.ID, keepAlive)
+		return keepAlive
+	}
+	if limit := s.config.Server.KeepAlive.Truncate(time.Second); client.ProtocolVersion == mqtt.ProtocolV5 && limit > 0 && (keepAlive == 0 || keepAlive > limit) {
+		keepAlive = limit
+	}
+	return keepAlive
+}
+
+// maxQueued returns how many messages are queued for an offline session
+func (s *Server) maxQueued(session *offlineSession) int {
+	if session.maxQueued != 0 {
+		return session.maxQueued
+	}
+	if s.config.Limits.MaxQueuedMessages == 0 {
+		return defaultMaxQueuedMessages
+	}
+	return s.config.Limits.MaxQueuedMessages
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// Presence events
+const (
+	presenceConnected    = "connected"
+	presenceDisconnected = "disconnected"
+)
+
+// Reasons a connection ended, reported in disconnected events
+const (
+	disconnectGraceful       = "disconnect"        // the client sent DISCONNECT
+	disconnectConnectionLost = "connection_lost"   // the connection failed or was closed by the broker
+	disconnectKeepAlive      = "keepalive_timeout" // nothing received within one and a half keepalive periods
+)
+
+// presenceEvent is the JSON payload of a presence notification
+type presenceEvent struct {
+	Event           string    `json:"event"`
+	ClientID        string    `json:"client_id"`
+	Username        string    `json:"username,omitempty"`
+	RemoteAddr      string    `json:"remote_addr"`
+	ProtocolVersion byte      `json:"protocol_version"`
+	CleanSession    bool      `json:"clean_session"`
+	SessionPresent  bool      `json:"session_present,omitempty"` // connected events only
+	Reason          string    `json:"reason,omitempty"`          // disconnected events only
+	Time            time.Time `json:"time"`
+}
+
+// publishPresence publishes a connected or disconnected notification for a
+// client, if presence notifications are enabled. Notifications are published
+// within the client's virtual host.
+func (s *Server) publishPresence(client *Client, event string, sessionPresent bool, reason string) {
+	cfg := s.config.Presence
+	if !cfg.Enabled {
+		return
+	}
+
+	template := cfg.ConnectedTopic
+	if event == presenceDisconnected {
+		template = cfg.DisconnectedTopic
+	}
+	topic := strings.NewReplacer("%c", client.ID, "%u", client.Username).Replace(template)
+	if !mqtt.ValidTopicName(topic) {
+		log.Printf("Skipping %s notification of %s: invalid topic %q", event, client.ID, topic)
+		return
+	}
+
+	payload, err := json.Marshal(presenceEvent{
+		Event:           event,
+		ClientID:        client.ID,
+		Username:        client.Username,
+		RemoteAddr:      client.Conn.RemoteAddr().String(),
+		ProtocolVersion: client.ProtocolVersion,
+		CleanSession:    client.CleanSession,
+		SessionPresent:  sessionPresent,
+		Reason:          reason,
+		Time:            time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Failed to encode %s notification of %s: %v", event, client.ID, err)
+		return
+	}
+
+	s.publishMessage(&mqtt.PublishPacket{
+		Topic:   client.mount(topic),
+		Payload: payload,
+		QoS:     cfg.QoS,
+		Retain:  cfg.Retain,
+	}, "")
+}
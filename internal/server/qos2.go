@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// qos2Receipts holds the packet IDs of QoS 2 messages received from a
+// client and routed, until the client releases them with PUBREL. A PUBLISH
+// resent under one of these IDs is not routed again.
+type qos2Receipts struct {
+	mu  sync.Mutex
+	ids map[uint16]struct{}
+}
+
+// add records a received packet ID
+func (r *qos2Receipts) add(packetID uint16) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ids == nil {
+		r.ids = make(map[uint16]struct{})
+	}
+	r.ids[packetID] = struct{}{}
+}
+
+// has reports whether a packet ID awaits PUBREL
+func (r *qos2Receipts) has(packetID uint16) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.ids[packetID]
+	return ok
+}
+
+// release forgets a packet ID and reports whether it was recorded
+func (r *qos2Receipts) release(packetID uint16) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ids[packetID]; !ok {
+		return false
+	}
+	delete(r.ids, packetID)
+	return true
+}
+
+// list returns the recorded packet IDs
+func (r *qos2Receipts) list() []uint16 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]uint16, 0, len(r.ids))
+	for id := range r.ids {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// restore records the packet IDs of a resumed session
+func (r *qos2Receipts) restore(ids []uint16) {
+	for _, id := range ids {
+		r.add(id)
+	}
+}
+
+// handlePubrec answers a subscriber's PUBREC to a QoS 2 delivery with
+// PUBREL. An MQTT 5 error reason code ends the delivery instead.
+func (s *Server) handlePubrec(client *Client, data []byte) {
+	pubrec, err := mqtt.DecodePubrecPacket(bytes.NewReader(data), len(data))
+	if err != nil {
+		log.Printf("Failed to decode PUBREC from %s: %v", client.ID, err)
+		s.dropMalformed(client, err)
+		return
+	}
+	if pubrec.ReasonCode >= mqtt.ReasonUnspecifiedError {
+		if client.inflight.refuse(pubrec.PacketID) {
+			log.Printf("Client %s refused packet %d (reason 0x%02x)", client.ID, pubrec.PacketID, pubrec.ReasonCode)
+			s.clearInflight(client, pubrec.PacketID)
+		}
+		return
+	}
+
+	reason := mqtt.ReasonSuccess
+	if pub := client.inflight.release(pubrec.PacketID); pub != nil {
+		s.persistInflight(client, pubrec.PacketID, pub, true)
+	} else {
+		log.Printf("PUBREC from %s for unknown packet %d", client.ID, pubrec.PacketID)
+		reason = mqtt.ReasonPacketIDNotFound
+	}
+	s.sendAck(client, mqtt.PUBREL, pubrec.PacketID, reason)
+}
+
+// handlePubrel releases a QoS 2 message received from a client and
+// completes the flow with PUBCOMP
+func (s *Server) handlePubrel(client *Client, header *mqtt.FixedHeader, data []byte) {
+	pubrel, err := mqtt.DecodePubrelPacket(bytes.NewReader(data), header)
+	if err != nil {
+		log.Printf("Failed to decode PUBREL from %s: %v", client.ID, err)
+		s.dropMalformed(client, err)
+		return
+	}
+	reason := mqtt.ReasonSuccess
+	if !client.received.release(pubrel.PacketID) {
+		log.Printf("PUBREL from %s for unknown packet %d", client.ID, pubrel.PacketID)
+		reason = mqtt.ReasonPacketIDNotFound
+	}
+	s.sendAck(client, mqtt.PUBCOMP, pubrel.PacketID, reason)
+}
+
+// handlePubcomp completes a QoS 2 delivery
+func (s *Server) handlePubcomp(client *Client, data []byte) {
+	pubcomp, err := mqtt.DecodePubcompPacket(bytes.NewReader(data), len(data))
+	if err != nil {
+		log.Printf("Failed to decode PUBCOMP from %s: %v", client.ID, err)
+		s.dropMalformed(client, err)
+		return
+	}
+	if !client.inflight.complete(pubcomp.PacketID) {
+		log.Printf("PUBCOMP from %s for unknown packet %d", client.ID, pubcomp.PacketID)
+		return
+	}
+	s.clearInflight(client, pubcomp.PacketID)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// Reasons the broker cannot take a connection at the moment, as keyed in
+// refusal_hints
+const (
+	refusalOverload     = "overload"
+	refusalShuttingDown = "shutting_down"
+	refusalDraining     = "draining"
+	refusalClientLimit  = "client_limit"
+	refusalVHostLimit   = "vhost_limit"
+	refusalStoreDown    = "store_down"
+)
+
+// refuseBusy refuses a connection the broker cannot take at the moment.
+// MQTT 5 clients get the hints configured for the refusal: when to retry
+// as user properties and, with a server reference, where to go instead.
+func (s *Server) refuseBusy(writer *connWriter, pkt *mqtt.ConnectPacket, refusal, reason string) {
+	hint, ok := s.config.RefusalHints[refusal]
+	if !ok || pkt.ProtocolVersion != mqtt.ProtocolV5 {
+		s.refuseConnect(writer, pkt, mqtt.ConnectRefusedServerUnavailable, reason)
+		return
+	}
+
+	code, label := mqtt.ReasonServerUnavailable, connackReason(mqtt.ConnectRefusedServerUnavailable)
+	props := retryProperties(hint)
+	if hint.ServerReference != "" {
+		code, label = mqtt.ReasonUseAnotherServer, "use_another_server"
+		props = append(props, mqtt.StringProperty(mqtt.PropServerReference, hint.ServerReference))
+	}
+	metrics.ConnectionsRefused.WithLabelValues(label).Inc()
+	log.Printf("Rejecting client %q: %s (reason 0x%02x, retry after %s)", pkt.ClientID, reason, code, hint.RetryAfter)
+	connack := &mqtt.ConnackPacket{Version: mqtt.ProtocolV5, ReturnCode: code, Properties: props}
+	data, _ := connack.Encode()
+	writer.WritePacket(data)
+}
+
+// retryProperties returns the retry-after and retry-after-max user
+// properties of a hint, in whole seconds
+func retryProperties(hint config.RefusalHintConfig) mqtt.Properties {
+	var props mqtt.Properties
+	if hint.RetryAfter > 0 {
+		props = append(props, mqtt.UserProperty("retry-after", seconds(hint.RetryAfter)))
+	}
+	if hint.MaxRetryAfter > 0 {
+		props = append(props, mqtt.UserProperty("retry-after-max", seconds(hint.MaxRetryAfter)))
+	}
+	return props
+}
+
+// seconds formats a duration as whole seconds, rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ZindGH/MQTT-Server/internal/auth"
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// ReloadAuth reloads the password, ACL and policy files, for example after
+// they changed on disk. Reloaded policies apply to new connections. Only files the broker was started with are reloaded;
+// enabling or disabling them needs a restart. On error the previous
+// credentials and rules stay in effect.
+func (s *Server) ReloadAuth(cfg config.AuthConfig) error {
+	if s.passwords.Load() != nil {
+		if cfg.UsernamePasswordFile == "" {
+			return fmt.Errorf("removing auth.username_password_file requires a restart")
+		}
+		passwords, err := auth.LoadPasswordFile(cfg.UsernamePasswordFile)
+		if err != nil {
+			return err
+		}
+		s.passwords.Store(passwords)
+		log.Printf("Reloaded %d users from %s", passwords.Len(), cfg.UsernamePasswordFile)
+	}
+	if s.acl.Load() != nil {
+		if cfg.ACLFile == "" {
+			return fmt.Errorf("removing auth.acl_file requires a restart")
+		}
+		acl, err := auth.LoadACLFile(cfg.ACLFile)
+		if err != nil {
+			return err
+		}
+		s.acl.Store(acl)
+		log.Printf("Reloaded %d ACL rules from %s", acl.Len(), cfg.ACLFile)
+	}
+	if s.policies.Load() != nil {
+		if cfg.PolicyFile == "" {
+			return fmt.Errorf("removing auth.policy_file requires a restart")
+		}
+		policies, err := auth.LoadPolicyFile(cfg.PolicyFile)
+		if err != nil {
+			return err
+		}
+		s.policies.Store(policies)
+		log.Printf("Reloaded %d client policies from %s", policies.Len(), cfg.PolicyFile)
+	}
+	return nil
+}
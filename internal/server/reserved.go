@@ -0,0 +1,62 @@
+package server
+
+import (
+	"log"
+	"path"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// reservedTopic is a topic space only its owners may publish to, whatever
+// the ACL grants
+type reservedTopic struct {
+	config.ReservedTopicConfig
+}
+
+// reserveTopics registers a publish authorizer enforcing topic reservations
+func (s *Server) reserveTopics(cfgs []config.ReservedTopicConfig) {
+	if len(cfgs) == 0 {
+		return
+	}
+	reserved := make([]*reservedTopic, len(cfgs))
+	for i, rc := range cfgs {
+		reserved[i] = &reservedTopic{ReservedTopicConfig: rc}
+	}
+	s.AddPublishAuthorizer(func(clientID, username, topic string) bool {
+		for _, r := range reserved {
+			if topicMatch(r.Filter, topic) && !s.ownsReserved(r, clientID, username) {
+				metrics.ReservedTopicDenied.WithLabelValues(r.Filter).Inc()
+				log.Printf("Client %s may not publish to %s: topic space %s is reserved", clientID, topic, r.Filter)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// ownsReserved reports whether a client is one of a reserved topic space's
+// owners, by ClientID, username or client group
+func (s *Server) ownsReserved(r *reservedTopic, clientID, username string) bool {
+	for _, pattern := range r.ClientIDs {
+		if ok, _ := path.Match(pattern, clientID); ok {
+			return true
+		}
+	}
+	if username != "" {
+		for _, pattern := range r.Usernames {
+			if ok, _ := path.Match(pattern, username); ok {
+				return true
+			}
+		}
+	}
+
+	s.groupsMu.RLock()
+	defer s.groupsMu.RUnlock()
+	for _, name := range r.Groups {
+		if g := s.groups[name]; g != nil && g.matches(clientID, username) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrTopicNotFound is returned when no retained message exists at or below a
+// topic
+var ErrTopicNotFound = errors.New("no retained messages under topic")
+
+// RetainedNode summarises the retained messages at and below a topic level
+type RetainedNode struct {
+	Name     string    `json:"name"`             // Last topic level
+	Topic    string    `json:"topic"`            // Full topic of this level
+	Retained bool      `json:"retained"`         // A retained message is stored on exactly this topic
+	Size     int       `json:"size,omitempty"`   // Payload size of that message
+	Messages int       `json:"messages"`         // Retained messages at and below this level
+	Bytes    int64     `json:"bytes"`            // Payload bytes at and below this level
+	Children int       `json:"children"`         // Number of direct child levels
+	Updated  time.Time `json:"updated,omitzero"` // Most recent update at or below this level
+}
+
+// RetainedTree is one level of the retained topic hierarchy with a page of
+// its children, sorted by name
+type RetainedTree struct {
+	RetainedNode
+	Items  []RetainedNode `json:"items"`
+	Total  int            `json:"total"` // Number of children across all pages
+	Offset int            `json:"offset"`
+}
+
+// RetainedTree returns the retained messages under topic (the root if empty)
+// as a tree level with up to limit children starting at offset. A limit of 0
+// returns all children.
+func (s *Server) RetainedTree(topic string, offset, limit int) (*RetainedTree, error) {
+	prefix := ""
+	if topic != "" {
+		prefix = topic + "/"
+	}
+
+	tree := &RetainedTree{Offset: offset}
+	tree.Topic = topic
+	tree.Name = topic[strings.LastIndexByte(topic, '/')+1:]
+	children := make(map[string]*RetainedNode)
+	grandchildren := make(map[string]bool) // "child/grandchild" levels seen
+
+	s.retainedMsgsMu.RLock()
+	for t, msg := range s.retainedMsgs {
+		size := len(msg.Payload)
+		updated := s.retainedAt[t]
+
+		if topic != "" && t == topic {
+			tree.Retained = true
+			tree.Size = size
+			tree.add(size, updated)
+			continue
+		}
+		if !strings.HasPrefix(t, prefix) {
+			continue
+		}
+
+		name, rest, deeper := strings.Cut(t[len(prefix):], "/")
+		child := children[name]
+		if child == nil {
+			child = &RetainedNode{Name: name, Topic: prefix + name}
+			children[name] = child
+		}
+		if deeper {
+			next, _, _ := strings.Cut(rest, "/")
+			if key := name + "/" + next; !grandchildren[key] {
+				grandchildren[key] = true
+				child.Children++
+			}
+		} else {
+			child.Retained = true
+			child.Size = size
+		}
+		child.add(size, updated)
+		tree.add(size, updated)
+	}
+	s.retainedMsgsMu.RUnlock()
+
+	if tree.Messages == 0 && topic != "" {
+		return nil, fmt.Errorf("%w: %s", ErrTopicNotFound, topic)
+	}
+
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tree.Children = len(names)
+	tree.Total = len(names)
+	if offset > len(names) {
+		offset = len(names)
+	}
+	end := len(names)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	tree.Items = make([]RetainedNode, 0, end-offset)
+	for _, name := range names[offset:end] {
+		tree.Items = append(tree.Items, *children[name])
+	}
+	return tree, nil
+}
+
+// add accounts a retained message at or below the node
+func (n *RetainedNode) add(size int, updated time.Time) {
+	n.Messages++
+	n.Bytes += int64(size)
+	if updated.After(n.Updated) {
+		n.Updated = updated
+	}
+}
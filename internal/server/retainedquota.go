@@ -0,0 +1,135 @@
+package server
+
+import (
+	"log"
+	"strings"
+
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// retainedOwners records which client and tenant own each retained topic,
+// so retained quotas can be enforced. It is guarded by retainedMsgsMu.
+type retainedOwners struct {
+	owner    map[string]string                    // topic -> ClientID that set it
+	byClient map[string]map[string]struct{}       // ClientID -> owned topics
+	byTenant map[*virtualHost]map[string]struct{} // tenant -> retained topics under its mountpoint
+}
+
+func newRetainedOwners() *retainedOwners {
+	return &retainedOwners{
+		owner:    make(map[string]string),
+		byClient: make(map[string]map[string]struct{}),
+		byTenant: make(map[*virtualHost]map[string]struct{}),
+	}
+}
+
+// set records topic as retained by clientID ("" for the broker) in tenant
+func (o *retainedOwners) set(topic, clientID string, tenant *virtualHost) {
+	o.remove(topic, tenant)
+	if clientID != "" {
+		o.owner[topic] = clientID
+		addTopic(o.byClient, clientID, topic)
+	}
+	if tenant != nil {
+		addTopic(o.byTenant, tenant, topic)
+	}
+}
+
+// remove forgets the owner of a retained topic
+func (o *retainedOwners) remove(topic string, tenant *virtualHost) {
+	if clientID, ok := o.owner[topic]; ok {
+		delete(o.owner, topic)
+		removeTopic(o.byClient, clientID, topic)
+	}
+	if tenant != nil {
+		removeTopic(o.byTenant, tenant, topic)
+	}
+}
+
+func addTopic[K comparable](sets map[K]map[string]struct{}, key K, topic string) {
+	if sets[key] == nil {
+		sets[key] = make(map[string]struct{})
+	}
+	sets[key][topic] = struct{}{}
+}
+
+func removeTopic[K comparable](sets map[K]map[string]struct{}, key K, topic string) {
+	delete(sets[key], topic)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
+
+// retainedTenant returns the virtual host whose mountpoint holds topic and
+// that limits its retained topics, or nil
+func (s *Server) retainedTenant(topic string) *virtualHost {
+	var tenant *virtualHost
+	for _, vh := range s.vhosts {
+		if vh.MaxRetained > 0 && vh.Mountpoint != "" && strings.HasPrefix(topic, vh.Mountpoint) &&
+			(tenant == nil || len(vh.Mountpoint) > len(tenant.Mountpoint)) {
+			tenant = vh
+		}
+	}
+	return tenant
+}
+
+// admitRetained applies the retained quotas of a client and its tenant to
+// a retained publish on a (mounted) topic. At a quota the publish is
+// rejected, or with the overwrite_oldest policy the oldest retained topic
+// of the client or tenant is removed to make room.
+func (s *Server) admitRetained(client *Client, topic string) bool {
+	maxPerClient := s.config.Limits.MaxRetainedPerClient
+	tenant := s.retainedTenant(topic)
+	if maxPerClient <= 0 && tenant == nil {
+		return true
+	}
+	overwrite := s.config.Limits.RetainedQuotaPolicy == "overwrite_oldest"
+
+	s.retainedMsgsMu.Lock()
+	defer s.retainedMsgsMu.Unlock()
+
+	// Replacing a retained message does not add a topic to the tenant, nor
+	// to the client if it already owns it
+	_, exists := s.retainedMsgs[topic]
+	if maxPerClient > 0 && s.retainedOwners.owner[topic] != client.ID {
+		for len(s.retainedOwners.byClient[client.ID]) >= maxPerClient {
+			if !overwrite {
+				metrics.RetainedQuotaExceeded.WithLabelValues("rejected").Inc()
+				log.Printf("Client %s reached its quota of %d retained topics, rejecting retained message on %s", client.ID, maxPerClient, topic)
+				return false
+			}
+			s.evictOldestRetained(s.retainedOwners.byClient[client.ID])
+		}
+	}
+	if tenant != nil && !exists {
+		for len(s.retainedOwners.byTenant[tenant]) >= tenant.MaxRetained {
+			if !overwrite {
+				metrics.RetainedQuotaExceeded.WithLabelValues("rejected").Inc()
+				log.Printf("Virtual host %s reached its quota of %d retained topics, rejecting retained message on %s from %s",
+					tenant.ServerName, tenant.MaxRetained, topic, client.ID)
+				return false
+			}
+			s.evictOldestRetained(s.retainedOwners.byTenant[tenant])
+		}
+	}
+	return true
+}
+
+// evictOldestRetained removes the least recently set retained message
+// among topics. The caller holds retainedMsgsMu.
+func (s *Server) evictOldestRetained(topics map[string]struct{}) {
+	var oldest string
+	for topic := range topics {
+		if oldest == "" || s.retainedAt[topic].Before(s.retainedAt[oldest]) {
+			oldest = topic
+		}
+	}
+	if msg, ok := s.retainedMsgs[oldest]; ok {
+		s.memory.add(memRetained, -publishMemorySize(msg.Topic, msg.Payload))
+	}
+	delete(s.retainedMsgs, oldest)
+	delete(s.retainedAt, oldest)
+	s.retainedOwners.remove(oldest, s.retainedTenant(oldest))
+	metrics.RetainedQuotaExceeded.WithLabelValues("evicted").Inc()
+	log.Printf("Retained quota reached, removed oldest retained message on %s", oldest)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/auth"
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// revocationWatcher periodically reloads the revocation list and
+// disconnects clients whose identity has been revoked
+type revocationWatcher struct {
+	cfg config.RevocationConfig
+}
+
+// load reads the revocation list from its file or HTTP endpoint
+func (w *revocationWatcher) load(ctx context.Context) (*auth.RevocationList, error) {
+	if w.cfg.File != "" {
+		return auth.LoadRevocationFile(w.cfg.File)
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Interval)
+	defer cancel()
+	return auth.FetchRevocationList(ctx, w.cfg.URL)
+}
+
+// run reloads the list every interval until ctx is cancelled. A list that
+// fails to load leaves the previous one in effect.
+func (w *revocationWatcher) run(ctx context.Context, s *Server) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		list, err := w.load(ctx)
+		if err != nil {
+			log.Printf("Failed to reload revocation list, keeping the previous one: %v", err)
+			continue
+		}
+		if previous := s.revocations.Swap(list); previous == nil || previous.Len() != list.Len() {
+			log.Printf("Loaded %d revoked identities", list.Len())
+		}
+		s.disconnectRevoked(list)
+	}
+}
+
+// revoked reports whether a client's ClientID or username is revoked
+func (s *Server) revoked(clientID, username string) bool {
+	list := s.revocations.Load()
+	return list != nil && list.Revoked(clientID, username)
+}
+
+// disconnectRevoked disconnects the connected clients a revocation list
+// names. They are refused when they reconnect for as long as they stay on
+// the list.
+func (s *Server) disconnectRevoked(list *auth.RevocationList) {
+	s.mu.RLock()
+	var revoked []*Client
+	for _, client := range s.clients {
+		if list.Revoked(client.ID, client.Username) {
+			revoked = append(revoked, client)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, client := range revoked {
+		s.emitEvent(&Event{Type: EventClientRevoked, ClientID: client.ID, Username: client.Username})
+		client.disconnect(mqtt.ReasonNotAuthorized, "identity revoked")
+	}
+}
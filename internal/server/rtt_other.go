@@ -0,0 +1,13 @@
+//go:build !linux
+
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// connRTT is not available on this platform
+func connRTT(conn net.Conn) (time.Duration, bool) {
+	return 0, false
+}
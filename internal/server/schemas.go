@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/schema"
+)
+
+// User property keys of schema annotations. Publishers cannot set them:
+// client-supplied properties with the prefix are removed.
+const (
+	schemaPrefix  = "schema_"
+	schemaSubject = "schema_subject" // registry subject the message was validated against
+	schemaVersion = "schema_version" // version of the subject's schema
+	schemaID      = "schema_id"      // registry ID of the schema
+	schemaValid   = "schema_valid"   // "true", or "false" for mismatches under a "log" rule
+)
+
+// schemaRule returns the first schema rule whose filter matches topic, or
+// nil
+func (s *Server) schemaRule(topic string) *config.SchemaRuleConfig {
+	for i, rule := range s.config.SchemaRegistry.Rules {
+		if topicMatch(rule.Filter, topic) {
+			return &s.config.SchemaRegistry.Rules[i]
+		}
+	}
+	return nil
+}
+
+// checkSchema validates a client's message against the registry schema of
+// its topic and reports whether the message may be published. Messages are
+// accepted or rejected per schema_registry.unavailable when the schema
+// cannot be fetched; mismatches under a "log" rule are published.
+func (s *Server) checkSchema(client *Client, pub *mqtt.PublishPacket) bool {
+	if s.schemas == nil {
+		return true
+	}
+
+	// Drop look-alike properties first so consumers can trust the annotation
+	props := pub.Properties[:0:0]
+	for _, prop := range pub.Properties {
+		if key, _, ok := prop.UserProperty(); ok && strings.HasPrefix(key, schemaPrefix) {
+			continue
+		}
+		props = append(props, prop)
+	}
+	pub.Properties = props
+
+	rule := s.schemaRule(pub.Topic)
+	if rule == nil || len(pub.Payload) == 0 {
+		return true
+	}
+	subject := strings.ReplaceAll(rule.Subject, "%t", strings.ReplaceAll(pub.Topic, "/", "."))
+
+	ctx, cancel := context.WithTimeout(client.ctx, s.config.SchemaRegistry.Timeout)
+	defer cancel()
+	sch, err := s.schemas.Lookup(ctx, subject)
+	if err != nil {
+		metrics.SchemaValidations.WithLabelValues(rule.Filter, "unavailable").Inc()
+		log.Printf("No schema for message from %s on %s (subject %s, %s): %v",
+			client.ID, pub.Topic, subject, s.config.SchemaRegistry.Unavailable, err)
+		return s.config.SchemaRegistry.Unavailable != "reject"
+	}
+
+	valid := true
+	if err := sch.Validate(pub.Payload); err != nil {
+		valid = false
+		metrics.SchemaValidations.WithLabelValues(rule.Filter, "invalid").Inc()
+		log.Printf("Message from %s on %s does not match schema %s version %d (%s): %v",
+			client.ID, pub.Topic, subject, sch.Version, rule.Action, err)
+		if rule.Action == "reject" {
+			return false
+		}
+	} else {
+		metrics.SchemaValidations.WithLabelValues(rule.Filter, "valid").Inc()
+	}
+
+	if rule.Annotate {
+		pub.Properties = append(pub.Properties,
+			mqtt.UserProperty(schemaSubject, subject),
+			mqtt.UserProperty(schemaVersion, strconv.Itoa(sch.Version)),
+			mqtt.UserProperty(schemaID, strconv.Itoa(sch.ID)),
+			mqtt.UserProperty(schemaValid, strconv.FormatBool(valid)),
+		)
+	}
+	return true
+}
+
+// SchemaCache lists the subjects looked up in the schema registry, or
+// returns nil if schema validation is disabled
+func (s *Server) SchemaCache() []schema.Status {
+	if s.schemas == nil {
+		return nil
+	}
+	return s.schemas.Statuses()
+}
@@ -3,37 +3,131 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ZindGH/MQTT-Server/internal/auth"
+	"github.com/ZindGH/MQTT-Server/internal/clock"
 	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
 	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/schema"
 	"github.com/ZindGH/MQTT-Server/internal/store"
+	"github.com/ZindGH/MQTT-Server/internal/version"
 )
 
 // Server represents the MQTT broker server
 type Server struct {
 	config         *config.Config
-	listener       net.Listener
+	listeners      []*listener             // guarded by mu
+	listenersMu    sync.Mutex              // serializes adding and removing listeners
+	vhosts         map[string]*virtualHost // SNI server name -> virtual host
 	store          store.Store
 	mu             sync.RWMutex
 	running        bool
-	clients        map[string]*Client             // clientID -> Client
+	clients        map[string]*Client             // session key -> Client
+	subscriptions  *subscriptionTrie              // subscriptions of connected clients
+	userConns      map[string]int                 // username -> connected clients, guarded by mu
+	settling       map[string]chan struct{}       // session key -> closed once an ended connection has settled its session in the store, guarded by mu
 	retainedMsgs   map[string]*mqtt.PublishPacket // topic -> retained message
+	retainedAt     map[string]time.Time           // topic -> time the retained message was stored
+	retainedOwners *retainedOwners
 	retainedMsgsMu sync.RWMutex
-	wg             sync.WaitGroup
+	memory         *memoryGuard
+	groups         map[string]*clientGroup // name -> group
+	groupsMu       sync.RWMutex
+	lastValues     *lastValueCache  // nil when disabled
+	files          *fileDistributor // nil when disabled
+	broadcasts     *broadcastTracker
+	usage          *usageTracker        // nil when usage quotas are disabled
+	usageExport    *usageExporter       // nil when usage export is disabled
+	analytics      *topicAnalytics      // nil when disabled
+	statsHistory   *statsHistory        // nil when disabled or without a store
+	contentTypes   *contentTypeRegistry // nil when disabled
+	schemas        *schema.Registry     // nil when schema validation is disabled
+	topicMetrics   *topicMetrics        // nil when no prefixes are configured
+	presence       *presenceTracker     // nil when presence tracking is disabled
+	maintenance    *maintenanceSchedule
+	slowConsumers  *slowConsumerMonitor       // nil when disabled
+	anomalies      *anomalyMonitor            // nil when disabled
+	storeHealth    *storeHealthMonitor        // nil when disabled or without a store
+	drain          atomic.Pointer[DrainState] // nil unless draining
+	migrator       *migrator                  // nil when migration is disabled
+	wills          delayedWills
+	clock          clock.Clock                // time source of keepalives, delayed wills and expiries
+	sessions       map[string]*offlineSession // session key -> disconnected persistent session
+	sessionsMu     sync.Mutex
+	passwords      atomic.Pointer[auth.PasswordFile]   // nil when no password file is configured
+	acl            atomic.Pointer[auth.ACL]            // nil when no ACL file is configured
+	policies       atomic.Pointer[auth.Policies]       // nil when no policy file is configured
+	sqlAuth        *auth.SQLCredentials                // nil unless auth.sql is configured
+	revocations    atomic.Pointer[auth.RevocationList] // nil when no revocation list is configured
+	revocation     *revocationWatcher                  // nil when no revocation list is configured
+	tlsConfig      atomic.Pointer[tls.Config]          // TLS listener configuration, nil until it is opened
+	tracer         *tracer
+	publishLog     *logSampler
+	ready          chan struct{}   // closed once the listeners are bound
+	ctx            context.Context // cancelled when the server stops
+	cancel         context.CancelFunc
+	publishHooks   []PublishHook
+	eventHooks     []EventHook
+	authorizers    []PublishAuthorizer
+	readers        []ReadAuthorizer
+	subscribers    []SubscribeAuthorizer
+	taps           map[*Tap]struct{}
+	tapsMu         sync.Mutex
+	tapCount       atomic.Int32
+	hooksMu        sync.RWMutex
+	wg             sync.WaitGroup // connections, listeners and background tasks of the current run
+	runMu          sync.Mutex     // orders waiting for a run to end before starting the next
 }
 
 // Client represents a connected MQTT client
 type Client struct {
-	ID            string
-	Conn          net.Conn
-	CleanSession  bool
-	Subscriptions map[string]byte // topic -> QoS
-	mu            sync.RWMutex
+	ID              string
+	Username        string
+	ProtocolVersion byte // 3 = MQTT 3.1 (MQIsdp), 4 = MQTT 3.1.1, 5 = MQTT 5
+	Conn            net.Conn
+	CleanSession    bool            // the session ends with the connection
+	Subscriptions   map[string]byte // topic -> granted QoS
+	Groups          []string        // names of the groups this client belongs to
+	mu              sync.RWMutex
+	ctx             context.Context // cancelled when the connection ends
+	writer          *connWriter
+	vhost           *virtualHost // nil for the default host
+	key             string       // ClientID scoped by the virtual host, see sessionKey
+	mountpoint      string       // topic prefix isolating the client's virtual host
+	listener        string       // name of the listener the client connected to
+	stats           clientStats
+	pending         pendingDeliveries                  // messages queued but not yet written
+	limiter         atomic.Pointer[rateLimiter]        // publish rate limit, nil if unlimited
+	will            atomic.Pointer[mqtt.PublishPacket] // published if the connection ends without DISCONNECT
+	options         map[string]mqtt.Subscription       // topic -> requested QoS and MQTT 5 subscription options
+	inflight        inflightWindow                     // QoS 1 and 2 deliveries awaiting PUBACK, PUBREC or PUBCOMP
+	outbox          outbox                             // messages routed to the client but not yet written
+	resumed         chan struct{}                      // closed once the session's backlog has been sent
+	policy          *auth.Policy                       // service level from auth.policy_file, nil for the broker defaults
+	keepAlive       time.Duration                      // keepalive enforced by the broker, 0 if none
+	keepAliveTimer  clock.Timer                        // enforces keepAlive under a virtual clock, nil otherwise
+	keepAliveOver   atomic.Bool                        // the client was disconnected for exceeding its keepalive
+	lastPacket      atomic.Int64                       // when the last packet was received, in Unix nanoseconds
+	cleanStart      bool                               // CONNECT discarded any existing session
+	sessionExpiry   uint32                             // MQTT 5 Session Expiry Interval from CONNECT or DISCONNECT
+	sessionEnded    atomic.Bool                        // an MQTT 5 DISCONNECT set the Session Expiry Interval to 0
+	received        qos2Receipts                       // QoS 2 messages received but not yet released with PUBREL
+	willDelay       time.Duration                      // MQTT 5 Will Delay Interval
+	connectedAt     time.Time                          // when the CONNECT was accepted
 }
 
 // New creates a new MQTT server instance
@@ -46,61 +140,426 @@ func New() (*Server, error) {
 				Port: 1883,
 			},
 		},
-		clients: make(map[string]*Client),
+		clients:      make(map[string]*Client),
+		retainedMsgs: make(map[string]*mqtt.PublishPacket),
+		retainedAt:   make(map[string]time.Time),
+		memory:       newMemoryGuard(0),
+		groups:       make(map[string]*clientGroup),
+		sessions:     make(map[string]*offlineSession),
+		tracer:       newTracer(),
+		publishLog:   newLogSampler(1),
+		maintenance:  newMaintenanceSchedule(),
+		broadcasts:   newBroadcastTracker(),
+		ready:        make(chan struct{}),
+		clock:        clock.Real,
+
+		retainedOwners: newRetainedOwners(),
+		userConns:      make(map[string]int),
+		settling:       make(map[string]chan struct{}),
+		subscriptions:  newSubscriptionTrie(),
 	}, nil
 }
 
 // NewWithConfig creates a new MQTT server with configuration
 func NewWithConfig(cfg *config.Config, st store.Store) (*Server, error) {
-	return &Server{
+	s := &Server{
 		config:       cfg,
 		store:        st,
 		clients:      make(map[string]*Client),
 		retainedMsgs: make(map[string]*mqtt.PublishPacket),
-	}, nil
+		retainedAt:   make(map[string]time.Time),
+		memory:       newMemoryGuard(cfg.Limits.MaxMemory),
+		groups:       newClientGroups(cfg.Groups),
+		sessions:     make(map[string]*offlineSession),
+		tracer:       newTracer(),
+		publishLog:   newLogSampler(cfg.Logging.PublishSampling),
+		maintenance:  newMaintenanceSchedule(),
+		broadcasts:   newBroadcastTracker(),
+		ready:        make(chan struct{}),
+		clock:        clock.Real,
+
+		retainedOwners: newRetainedOwners(),
+		userConns:      make(map[string]int),
+		settling:       make(map[string]chan struct{}),
+		subscriptions:  newSubscriptionTrie(),
+	}
+	if cfg.LastValue.Enabled {
+		s.lastValues = newLastValueCache(cfg.LastValue.MaxTopics)
+	}
+	if cfg.Files.Enabled {
+		s.files = newFileDistributor(cfg.Files)
+		s.AddPublishHook(s.files.recordAck)
+	}
+	if cfg.UsageQuotas.Enabled {
+		s.usage = newUsageTracker(cfg.UsageQuotas)
+	}
+	if cfg.UsageExport.Enabled {
+		s.usageExport = newUsageExporter(cfg.UsageExport)
+	}
+	if cfg.Analytics.Enabled {
+		s.analytics = newTopicAnalytics(cfg.Analytics)
+	}
+	if st != nil && cfg.StatsHistory.Enabled {
+		s.statsHistory = newStatsHistory(cfg.StatsHistory)
+	}
+	if cfg.ContentTypes.Enabled {
+		s.contentTypes = newContentTypeRegistry(cfg.ContentTypes)
+	}
+	if cfg.SchemaRegistry.Enabled {
+		s.schemas = schema.NewRegistry(cfg.SchemaRegistry, s.runTask)
+	}
+	if len(cfg.TopicMetrics.Prefixes) > 0 {
+		s.topicMetrics = newTopicMetrics(cfg.TopicMetrics)
+	}
+	if cfg.SlowConsumer.Enabled {
+		s.slowConsumers = newSlowConsumerMonitor(cfg.SlowConsumer)
+	}
+	if cfg.Anomaly.Enabled {
+		s.anomalies = newAnomalyMonitor(cfg.Anomaly)
+	}
+	if cfg.Migration.Enabled {
+		s.migrator = newMigrator(cfg.Migration)
+	}
+	if st != nil && cfg.Storage.Health.Interval > 0 {
+		s.storeHealth = newStoreHealthMonitor(cfg.Storage.Health)
+	}
+	if cfg.TLS.Enabled {
+		vhosts, err := newVirtualHosts(cfg.TLS.VirtualHosts)
+		if err != nil {
+			return nil, err
+		}
+		s.vhosts = vhosts
+	}
+	if cfg.Auth.Enabled && cfg.Auth.UsernamePasswordFile != "" {
+		passwords, err := auth.LoadPasswordFile(cfg.Auth.UsernamePasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d users from %s", passwords.Len(), cfg.Auth.UsernamePasswordFile)
+		s.passwords.Store(passwords)
+	}
+	if cfg.Auth.Enabled && cfg.Auth.ACLFile != "" {
+		acl, err := auth.LoadACLFile(cfg.Auth.ACLFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d ACL rules from %s", acl.Len(), cfg.Auth.ACLFile)
+		s.acl.Store(acl)
+
+		// The rules are looked up on every check so that ReloadAuth takes
+		// effect immediately
+		s.AddPublishAuthorizer(func(clientID, username, topic string) bool {
+			return s.acl.Load().Allowed(clientID, username, topic, auth.Write)
+		})
+		s.AddReadAuthorizer(func(clientID, username, topic string) bool {
+			return s.acl.Load().Allowed(clientID, username, topic, auth.Read)
+		})
+		s.AddSubscribeAuthorizer(func(clientID, username, filter string) bool {
+			return s.acl.Load().CanSubscribe(clientID, username, filter)
+		})
+	}
+	if cfg.Auth.Enabled && cfg.Auth.SQL.Driver != "" {
+		creds, err := openSQLAuth(cfg.Auth.SQL)
+		if err != nil {
+			return nil, err
+		}
+		if err := creds.Refresh(context.Background()); err != nil {
+			log.Printf("Failed to load SQL auth users, looking them up on connect: %v", err)
+		}
+		log.Printf("Using %s auth database (%d users cached)", cfg.Auth.SQL.Driver, creds.Len())
+		s.sqlAuth = creds
+
+		if creds.HasACL() {
+			s.AddPublishAuthorizer(func(clientID, username, topic string) bool {
+				return creds.Allowed(clientID, username, topic, auth.Write)
+			})
+			s.AddReadAuthorizer(func(clientID, username, topic string) bool {
+				return creds.Allowed(clientID, username, topic, auth.Read)
+			})
+			s.AddSubscribeAuthorizer(func(clientID, username, filter string) bool {
+				return creds.CanSubscribe(clientID, username, filter)
+			})
+		}
+	}
+	if cfg.Auth.Enabled && (cfg.Auth.Revocation.File != "" || cfg.Auth.Revocation.URL != "") {
+		s.revocation = &revocationWatcher{cfg: cfg.Auth.Revocation}
+		list, err := s.revocation.load(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d revoked identities", list.Len())
+		s.revocations.Store(list)
+	}
+	if cfg.Auth.Enabled && cfg.Auth.PolicyFile != "" {
+		policies, err := auth.LoadPolicyFile(cfg.Auth.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d client policies from %s", policies.Len(), cfg.Auth.PolicyFile)
+		s.policies.Store(policies)
+
+		// Policies restrict topics on top of the ACL
+		s.AddPublishAuthorizer(func(clientID, username, topic string) bool {
+			return s.policyFor(username).Allowed(clientID, username, topic)
+		})
+		s.AddReadAuthorizer(func(clientID, username, topic string) bool {
+			return s.policyFor(username).Allowed(clientID, username, topic)
+		})
+		s.AddSubscribeAuthorizer(func(clientID, username, filter string) bool {
+			return s.policyFor(username).CanSubscribe(clientID, username, filter)
+		})
+	}
+	if cfg.Presence.Tracking {
+		s.presence = newPresenceTracker(st, cfg.Storage.OpTimeout)
+	}
+	s.reserveTopics(cfg.ReservedTopics)
+	if err := s.scheduleMaintenance(cfg.Maintenance); err != nil {
+		return nil, err
+	}
+	s.loadSessions()
+	return s, nil
 }
 
-// Start begins listening for MQTT connections
+// sysVersionTopic carries the broker version as a retained message
+const sysVersionTopic = "$SYS/broker/version"
+
+// Start begins listening for MQTT connections and blocks until the server
+// is stopped
 func (s *Server) Start() error {
+	return s.StartContext(context.Background())
+}
+
+// StartContext is like Start, but also stops the server when ctx is
+// cancelled. Connections and background tasks run under a context derived
+// from ctx, so they unwind as soon as the server stops. A stopped server
+// can be started again.
+func (s *Server) StartContext(ctx context.Context) error {
+	s.mu.RLock()
+	running := s.running
+	s.mu.RUnlock()
+	if running {
+		return fmt.Errorf("server is already running")
+	}
+
+	// A restart waits for the previous run to unwind
+	s.runMu.Lock()
+	s.wg.Wait()
+
 	s.mu.Lock()
 	if s.running {
 		s.mu.Unlock()
+		s.runMu.Unlock()
 		return fmt.Errorf("server is already running")
 	}
 	s.running = true
+
+	listeners, err := s.openListeners()
+	if err != nil {
+		s.running = false
+		s.mu.Unlock()
+		s.runMu.Unlock()
+		return err
+	}
+	s.listeners = listeners
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.ctx = ctx
+	s.drain.Store(nil)
+	s.wg.Add(len(listeners))
+	close(s.ready)
 	s.mu.Unlock()
+	s.runMu.Unlock()
+	s.armSessionExpiries()
+	s.resumeWills()
+
+	s.publishMessage(&mqtt.PublishPacket{
+		Topic:   sysVersionTopic,
+		Payload: []byte("mqtt-server " + version.Version),
+		Retain:  true,
+	}, "")
+
+	context.AfterFunc(ctx, func() {
+		if err := s.shutdown(); err != nil {
+			log.Printf("Error during shutdown: %v", err)
+		}
+	})
 
+	if s.analytics != nil {
+		s.background(func() { s.analytics.run(ctx) })
+	}
+	if s.topicMetrics != nil {
+		s.background(func() { s.topicMetrics.run(ctx) })
+	}
+	if s.statsHistory != nil {
+		s.background(func() { s.statsHistory.run(ctx, s) })
+	}
+	if s.slowConsumers != nil {
+		s.background(func() { s.slowConsumers.run(ctx, s) })
+	}
+	if s.anomalies != nil {
+		s.background(func() { s.anomalies.run(ctx, s) })
+	}
+	if s.storeHealth != nil {
+		s.background(func() { s.storeHealth.run(ctx, s) })
+	}
+	if s.sqlAuth != nil {
+		s.background(func() { s.refreshSQLAuth(ctx, s.config.Auth.SQL.RefreshInterval) })
+	}
+	if s.revocation != nil {
+		s.background(func() { s.revocation.run(ctx, s) })
+	}
+	if s.usageExport != nil {
+		s.background(func() { s.usageExport.run(ctx, s) })
+	}
+	if s.migrator != nil {
+		s.background(func() { s.migrator.run(ctx, s) })
+	}
+
+	// Additional listeners run in the background, the plain TCP listener
+	// blocks until the server is stopped
+	for _, l := range listeners[1:] {
+		go func() {
+			defer s.wg.Done()
+			s.serve(ctx, l)
+		}()
+	}
+	defer s.wg.Done()
+	return s.serve(ctx, listeners[0])
+}
+
+// background runs a task in a goroutine that Stop waits for
+func (s *Server) background(task func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		task()
+	}()
+}
+
+// runTask runs a task of the current run in a goroutine that Stop waits
+// for, under the context of the run. It reports false and drops the task
+// when the server is not running.
+func (s *Server) runTask(task func(ctx context.Context)) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.running {
+		return false
+	}
+	ctx := s.ctx
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		task(ctx)
+	}()
+	return true
+}
+
+// openListeners binds the plain TCP listener, the TLS listener when TLS is
+// enabled and the additional listeners
+func (s *Server) openListeners() ([]*listener, error) {
 	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.Port)
-	listener, err := net.Listen("tcp", addr)
+	plain, err := listen("tcp", addr, nil)
 	if err != nil {
-		return fmt.Errorf("failed to start listener: %w", err)
+		return nil, err
 	}
-	s.listener = listener
+	listeners := []*listener{plain}
 
-	log.Printf("MQTT broker listening on %s", addr)
+	if s.config.TLS.Enabled {
+		tlsConfig, err := s.buildTLSConfig()
+		if err != nil {
+			plain.ln.Close()
+			return nil, err
+		}
+		tlsAddr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.TLS.Port)
+		secure, err := listen("tls", tlsAddr, tlsConfig)
+		if err != nil {
+			plain.ln.Close()
+			return nil, err
+		}
+		s.tlsConfig.Store(tlsConfig)
+		listeners = append(listeners, secure)
+	}
 
-	// Accept connections
-	for {
-		conn, err := s.listener.Accept()
+	for _, cfg := range s.config.Listeners {
+		l, err := s.openListener(cfg)
 		if err != nil {
-			s.mu.Lock()
-			running := s.running
-			s.mu.Unlock()
-			if !running {
-				return nil // Server stopped
+			for _, open := range listeners {
+				open.ln.Close()
 			}
-			log.Printf("Error accepting connection: %v", err)
-			continue
+			return nil, err
 		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
 
-		// Handle each connection in a goroutine
-		s.wg.Add(1)
-		go s.handleConnection(conn)
+// Ready returns a channel that is closed once the server accepts
+// connections. After Stop it returns a new channel for the next start.
+func (s *Server) Ready() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ready
+}
+
+// Addr returns the address the plain TCP listener is bound to, which
+// tells the port chosen by the system for server.port 0. It returns nil
+// unless the server is running.
+func (s *Server) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.listeners) == 0 {
+		return nil
 	}
+	return s.listeners[0].ln.Addr()
 }
 
-// Stop gracefully shuts down the server
+// TLSAddr returns the address the TLS listener is bound to, or nil unless
+// the server is running with TLS enabled
+func (s *Server) TLSAddr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, l := range s.listeners {
+		if l.name == "tls" && l.cfg == nil {
+			return l.ln.Addr()
+		}
+	}
+	return nil
+}
+
+// Config returns the configuration the server runs with. It must not be
+// modified.
+func (s *Server) Config() *config.Config {
+	return s.config
+}
+
+// StoreStats returns the statistics of the store, or nil if it keeps none
+func (s *Server) StoreStats() map[string]interface{} {
+	if st, ok := s.store.(interface{ Stats() map[string]interface{} }); ok {
+		return st.Stats()
+	}
+	return nil
+}
+
+// ClientCount returns the number of connected clients
+func (s *Server) ClientCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients)
+}
+
+// Stop gracefully shuts down the server and waits until its connections
+// and background tasks have ended
 func (s *Server) Stop() error {
+	err := s.shutdown()
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// shutdown closes the listeners and connections and resets the state of a
+// run, without waiting for them to unwind. Delayed wills still pending are
+// suspended, see suspendWills.
+func (s *Server) shutdown() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -109,13 +568,22 @@ func (s *Server) Stop() error {
 	}
 
 	s.running = false
+	s.cancel()
+	s.ready = make(chan struct{})
+	if pending := s.wills.stopAll(); len(pending) > 0 {
+		// Authorizers and the store are not called with s.mu held; the
+		// next run waits for the wills to be saved
+		s.background(func() { s.suspendWills(pending) })
+	}
 
-	// Close listener
-	if s.listener != nil {
-		if err := s.listener.Close(); err != nil {
-			return fmt.Errorf("error closing listener: %w", err)
+	// Close listeners
+	var err error
+	for _, l := range s.listeners {
+		if cerr := l.ln.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("error closing %s listener: %w", l.name, cerr)
 		}
 	}
+	s.listeners = nil
 
 	// Close all client connections
 	for _, client := range s.clients {
@@ -124,24 +592,79 @@ func (s *Server) Stop() error {
 		}
 	}
 
-	return nil
+	return err
 }
 
-// handleConnection processes an individual client connection
-func (s *Server) handleConnection(conn net.Conn) {
+// handleConnection processes an individual client connection. The
+// connection is closed when ctx is cancelled.
+func (s *Server) handleConnection(ctx context.Context, conn net.Conn, listenerName string) {
 	defer s.wg.Done()
 	defer conn.Close()
+	defer s.recoverPanic("connection from " + conn.RemoteAddr().String())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 
 	log.Printf("New connection from %s", conn.RemoteAddr())
 
+	var vhost *virtualHost
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		vh, err := s.handshake(ctx, tlsConn)
+		if err != nil {
+			log.Printf("Connection from %s closed: %v", conn.RemoteAddr(), err)
+			return
+		}
+		vhost = vh
+	}
+
+	// Until CONNECT is accepted the connection gets a bounded amount of
+	// time and data
+	if timeout := s.config.Server.ReadTimeout; timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+
 	reader := bufio.NewReader(conn)
+	budget := s.preAuthBudget()
+	writer := newConnWriter(conn, s.config.Server.WriteBufferSize, s.config.Server.WriteTimeout)
 	var client *Client
+	disconnectReason := disconnectConnectionLost
+	defer func() {
+		if client != nil {
+			if client.keepAliveTimer != nil {
+				client.keepAliveTimer.Stop()
+			}
+			s.publishWill(client)
+			if s.removeClient(client) {
+				s.trackPresence(client, presenceDisconnected)
+				s.publishPresence(client, presenceDisconnected, false, disconnectReason)
+			}
+			client.inflight.close()
+			if client.vhost != nil {
+				client.vhost.release()
+			}
+		}
+	}()
 
 	for {
+		if client != nil && client.keepAlive > 0 {
+			s.armKeepAlive(conn, client)
+		}
+
 		// Read fixed header
 		header, err := mqtt.ReadFixedHeader(reader)
 		if err != nil {
-			if client != nil {
+			var netErr net.Error
+			timedOut := errors.As(err, &netErr) && netErr.Timeout()
+			if client != nil && client.keepAlive > 0 && (timedOut || client.keepAliveOver.Load()) {
+				if timedOut {
+					s.expireKeepAlive(client)
+				}
+				disconnectReason = disconnectKeepAlive
+			} else if errors.Is(err, mqtt.ErrMalformedPacket) {
+				log.Printf("Closing connection from %s: %v", conn.RemoteAddr(), err)
+			} else if client != nil {
 				log.Printf("Client %s disconnected: %v", client.ID, err)
 			} else {
 				log.Printf("Connection from %s closed: %v", conn.RemoteAddr(), err)
@@ -149,7 +672,39 @@ func (s *Server) handleConnection(conn net.Conn) {
 			return
 		}
 
-		log.Printf("Received %s packet (remaining length: %d)", header.PacketType, header.RemainingLen)
+		// The budget is charged before the packet body is read or parsed
+		if limit := budget.admit(header); limit != "" {
+			metrics.ParserBudgetExceeded.WithLabelValues(budget.phase, limit).Inc()
+			if client != nil {
+				log.Printf("Closing connection of %s: %s rate exceeds the parser budget", client.ID, limit)
+			} else {
+				metrics.ConnectionsRefused.WithLabelValues("parser_budget").Inc()
+				log.Printf("Closing connection from %s: %s rate exceeds the pre-authentication parser budget", conn.RemoteAddr(), limit)
+			}
+			return
+		}
+
+		// Messages and their acknowledgements are logged through the
+		// publish log sampler instead
+		switch header.PacketType {
+		case mqtt.PUBLISH, mqtt.PUBACK, mqtt.PUBREC, mqtt.PUBREL, mqtt.PUBCOMP:
+		default:
+			log.Printf("Received %s packet (remaining length: %d)", header.PacketType, header.RemainingLen)
+		}
+
+		if client == nil {
+			if header.PacketType != mqtt.CONNECT {
+				metrics.ConnectionsRefused.WithLabelValues("protocol_violation").Inc()
+				log.Printf("Closing connection from %s: %s received before CONNECT", conn.RemoteAddr(), header.PacketType)
+				return
+			}
+			if header.RemainingLen > s.maxPreAuthBytes() {
+				metrics.ConnectionsRefused.WithLabelValues("preauth_limit").Inc()
+				log.Printf("Closing connection from %s: CONNECT of %d bytes exceeds the pre-authentication limit of %d",
+					conn.RemoteAddr(), header.RemainingLen, s.maxPreAuthBytes())
+				return
+			}
+		}
 
 		// Read the remaining packet data
 		remainingData := make([]byte, header.RemainingLen)
@@ -160,50 +715,119 @@ func (s *Server) handleConnection(conn net.Conn) {
 				return
 			}
 		}
+		if client != nil {
+			client.lastPacket.Store(s.clock.Now().UnixNano())
+			s.tracef(client.ID, "", "received %s (%d bytes): %s", header.PacketType, header.RemainingLen, traceDump(remainingData))
+		}
 
 		// Handle different packet types
 		switch header.PacketType {
 		case mqtt.CONNECT:
-			client = s.handleConnect(conn, bytes.NewReader(remainingData), header.RemainingLen)
+			if client != nil {
+				log.Printf("Closing connection of %s: second CONNECT", client.ID)
+				return
+			}
+			client = s.handleConnect(ctx, conn, writer, vhost, bytes.NewReader(remainingData), header.RemainingLen)
 			if client == nil {
 				return // Connection rejected
 			}
+			conn.SetReadDeadline(time.Time{})
+			client.lastPacket.Store(s.clock.Now().UnixNano())
+			budget = s.connectedBudget()
+			client.listener = listenerName // only read by this goroutine
+			s.tracef(client.ID, "", "received CONNECT from %s (%d bytes): %s", conn.RemoteAddr(), header.RemainingLen, traceDump(remainingData))
 
 		case mqtt.PUBLISH:
-			if client == nil {
-				log.Printf("PUBLISH received before CONNECT")
-				return
-			}
 			s.handlePublish(client, header, remainingData)
 
 		case mqtt.SUBSCRIBE:
-			if client == nil {
-				log.Printf("SUBSCRIBE received before CONNECT")
-				return
-			}
 			s.handleSubscribe(client, conn, remainingData)
 
 		case mqtt.UNSUBSCRIBE:
-			if client == nil {
-				log.Printf("UNSUBSCRIBE received before CONNECT")
-				return
-			}
 			s.handleUnsubscribe(client, remainingData)
 
+		case mqtt.PUBACK:
+			s.handlePuback(client, remainingData)
+
+		case mqtt.PUBREC:
+			s.handlePubrec(client, remainingData)
+
+		case mqtt.PUBREL:
+			s.handlePubrel(client, header, remainingData)
+
+		case mqtt.PUBCOMP:
+			s.handlePubcomp(client, remainingData)
+
 		case mqtt.PINGREQ:
-			s.handlePingreq(conn)
+			s.handlePingreq(client, writer)
 
 		case mqtt.DISCONNECT:
+			if !s.handleDisconnect(client, remainingData) {
+				return
+			}
+			disconnectReason = disconnectGraceful
+			writer.Flush()
 			log.Printf("Client %s disconnected gracefully", client.ID)
 			return
 
 		default:
 			log.Printf("Unhandled packet type: %s", header.PacketType)
 		}
+
+		// Coalesce responses: only flush once no further inbound data is
+		// already buffered, so a pipelined burst is answered in one write
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				log.Printf("Failed to flush responses to %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+}
+
+// dropMalformed closes the connection of a client that sent a malformed
+// packet, as the protocol requires; the read loop then ends. Other decode
+// failures leave the connection open.
+func (s *Server) dropMalformed(client *Client, err error) {
+	if errors.Is(err, mqtt.ErrMalformedPacket) {
+		client.writer.Flush() // responses to earlier packets
+		client.Conn.Close()
 	}
 }
 
-func (s *Server) handleConnect(conn net.Conn, reader *bytes.Reader, remainingLen int) *Client {
+// removeClient forgets a client whose connection has ended, unless a newer
+// connection has already taken over its ClientID, and reports whether it
+// did. The store is only updated once s.mu is released; a connection
+// resuming the session meanwhile waits for it in handleConnect.
+func (s *Server) removeClient(client *Client) bool {
+	s.mu.Lock()
+	client.mu.RLock()
+	for filter := range client.Subscriptions {
+		s.subscriptions.remove(client, filter)
+	}
+	client.mu.RUnlock()
+	if s.clients[client.key] != client {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.clients, client.key)
+	s.countUserConn(client, -1)
+	offline := s.suspendSession(client)
+	settled := make(chan struct{})
+	s.settling[client.key] = settled
+	s.mu.Unlock()
+
+	s.settleSession(client, offline)
+	s.mu.Lock()
+	if s.settling[client.key] == settled {
+		delete(s.settling, client.key)
+	}
+	s.mu.Unlock()
+	close(settled)
+	return true
+}
+
+func (s *Server) handleConnect(ctx context.Context, conn net.Conn, writer *connWriter, vhost *virtualHost, reader *bytes.Reader, remainingLen int) *Client {
 	connectPkt, err := mqtt.DecodeConnectPacket(reader, remainingLen)
 	if err != nil {
 		log.Printf("Failed to decode CONNECT: %v", err)
@@ -214,28 +838,192 @@ func (s *Server) handleConnect(conn net.Conn, reader *bytes.Reader, remainingLen
 	log.Printf("CONNECT from client: %s (protocol: %s v%d, clean_session: %v)",
 		connectPkt.ClientID, connectPkt.ProtocolName, connectPkt.ProtocolVersion, connectPkt.CleanSession)
 
+	// Legacy 3.1 clients (MQIsdp) and 3.1.1 clients differ in which client
+	// identifiers they may use
+	if !connectPkt.KnownProtocol() {
+		metrics.ConnectionsRefused.WithLabelValues("protocol_name").Inc()
+		log.Printf("Closing connection from %s: unknown protocol name %q", conn.RemoteAddr(), connectPkt.ProtocolName)
+		return nil
+	}
+	if code := connectPkt.CheckProtocol(); code != mqtt.ConnectAccepted {
+		s.refuseConnect(writer, connectPkt, code,
+			fmt.Sprintf("invalid client ID or protocol level for %s v%d", connectPkt.ProtocolName, connectPkt.ProtocolVersion))
+		return nil
+	}
+	assigned := connectPkt.ClientID == ""
+	if assigned {
+		connectPkt.ClientID = assignClientID()
+		log.Printf("Assigned client ID %s to %s", connectPkt.ClientID, conn.RemoteAddr())
+	}
+
+	if code := s.authenticate(connectPkt, peerCertificate(conn)); code != mqtt.ConnectAccepted {
+		s.refuseConnect(writer, connectPkt, code, fmt.Sprintf("authentication failed for user %q", connectPkt.Username))
+		return nil
+	}
+	if s.revoked(connectPkt.ClientID, connectPkt.Username) {
+		s.refuseConnect(writer, connectPkt, mqtt.ConnectRefusedNotAuthorized, "identity revoked")
+		return nil
+	}
+
+	// Refuse new sessions while shedding load
+	if s.memory.Overloaded() {
+		metrics.OverloadRejectedConnections.Inc()
+		s.refuseBusy(writer, connectPkt, refusalOverload, "broker is in overload mode")
+		return nil
+	}
+	if ctx.Err() != nil {
+		s.refuseBusy(writer, connectPkt, refusalShuttingDown, "broker is shutting down")
+		return nil
+	}
+	if s.refuseDraining(writer, connectPkt) {
+		return nil
+	}
+	key := sessionKey(vhost, connectPkt.ClientID)
+	if s.atClientLimit(key) {
+		s.refuseBusy(writer, connectPkt, refusalClientLimit,
+			fmt.Sprintf("broker is at its client limit (%d)", s.config.Limits.MaxClients))
+		return nil
+	}
+
+	// Enforce the virtual host's connection limit
+	if vhost != nil && !vhost.admit() {
+		s.refuseBusy(writer, connectPkt, refusalVHostLimit,
+			fmt.Sprintf("virtual host %s is at its client limit (%d)", vhost.ServerName, vhost.MaxClients))
+		return nil
+	}
+
+	// MQTT 5 separates Clean Start, which discards an existing session,
+	// from the session expiry interval, which keeps the new one after the
+	// connection ends
+	cleanSession := connectPkt.CleanSession
+	var sessionExpiry uint32
+	if connectPkt.ProtocolVersion == mqtt.ProtocolV5 {
+		sessionExpiry, _ = connectPkt.Properties.Uint32(mqtt.PropSessionExpiryInterval)
+		cleanSession = sessionExpiry == 0
+	}
+	if !cleanSession && s.rejectPersistent() {
+		if vhost != nil {
+			vhost.release()
+		}
+		s.refuseBusy(writer, connectPkt, refusalStoreDown, "store is down, persistent sessions are refused")
+		return nil
+	}
+
 	// Create client
 	client := &Client{
-		ID:            connectPkt.ClientID,
-		Conn:          conn,
-		CleanSession:  connectPkt.CleanSession,
-		Subscriptions: make(map[string]byte),
+		ID:              connectPkt.ClientID,
+		Username:        connectPkt.Username,
+		ProtocolVersion: connectPkt.ProtocolVersion,
+		Conn:            conn,
+		CleanSession:    cleanSession,
+		cleanStart:      connectPkt.CleanSession,
+		sessionExpiry:   sessionExpiry,
+		Subscriptions:   make(map[string]byte),
+		options:         make(map[string]mqtt.Subscription),
+		inflight:        inflightWindow{max: s.config.Limits.MaxInflightMessages, memory: s.memory},
+		resumed:         make(chan struct{}),
+		ctx:             ctx,
+		writer:          writer,
+		vhost:           vhost,
+		key:             key,
+		policy:          s.policyFor(connectPkt.Username),
+		connectedAt:     s.clock.Now(),
+	}
+	if vhost != nil {
+		client.mountpoint = vhost.Mountpoint
 	}
+	will, ok := newWill(connectPkt, client)
+	if !ok {
+		log.Printf("Closing connection of %s: invalid will (topic %q, QoS %d)", client.ID, connectPkt.WillTopic, connectPkt.WillQoS)
+		if vhost != nil {
+			vhost.release()
+		}
+		return nil
+	}
+	client.will.Store(will)
+	if delay, ok := connectPkt.WillProperties.Uint32(mqtt.PropWillDelayInterval); ok && will != nil {
+		client.willDelay = time.Duration(delay) * time.Second
+	}
+	s.assignGroups(client)
+	s.applyPolicy(client, connectPkt.KeepAlive)
 
-	// Store client
+	// Store client, taking over any existing connection with the same ID.
+	// The username's connections are checked and counted under one lock so
+	// that concurrent CONNECTs cannot all pass the limit.
 	s.mu.Lock()
-	s.clients[client.ID] = client
+	previous := s.clients[client.key]
+	settling := s.settling[client.key]
+	if s.atUserLimit(client.Username, previous) {
+		s.mu.Unlock()
+		if vhost != nil {
+			vhost.release()
+		}
+		metrics.UsernameLimitRejections.Inc()
+		s.refuseConnect(writer, connectPkt, mqtt.ConnectRefusedNotAuthorized,
+			fmt.Sprintf("user %q is at its connection limit (%d)", client.Username, s.config.Limits.MaxConnectionsPerUsername))
+		return nil
+	}
+	s.clients[client.key] = client
+	if previous != nil {
+		s.countUserConn(previous, -1)
+	}
+	s.countUserConn(client, 1)
 	s.mu.Unlock()
+	if settling != nil {
+		<-settling // the previous connection is still saving the session
+	}
+	if s.wills.cancel(client.key) {
+		log.Printf("Client %s reconnected within its Will Delay Interval, discarding its will", client.ID)
+	}
+	sessionPresent := s.restoreSession(client, previous)
+	s.persistSubscriptions(client)
+	if previous != nil {
+		log.Printf("Client %s reconnected, closing its previous connection from %s", client.ID, previous.Conn.RemoteAddr())
+		if s.config.Server.TakeoverWill == TakeoverWillSuppress {
+			previous.discardWill()
+		}
+		previous.disconnect(mqtt.ReasonSessionTakenOver, "client ID connected again")
+	}
 
-	// Send CONNACK
+	// Send CONNACK. MQTT 3.1 has no session present flag.
 	connack := &mqtt.ConnackPacket{
-		SessionPresent: false,
-		ReturnCode:     0, // Connection accepted
+		SessionPresent: sessionPresent && client.ProtocolVersion != mqtt.ProtocolV31,
+		ReturnCode:     mqtt.ConnectAccepted,
+		Version:        client.ProtocolVersion,
+	}
+	if client.ProtocolVersion == mqtt.ProtocolV5 {
+		if assigned {
+			connack.Properties = append(connack.Properties, mqtt.StringProperty(mqtt.PropAssignedClientID, client.ID))
+		}
+		if maxQoS := s.clientMaxQoS(client); maxQoS < 2 {
+			connack.Properties = append(connack.Properties, mqtt.ByteProperty(mqtt.PropMaximumQoS, maxQoS))
+		}
+		if keepAlive := uint16(client.keepAlive / time.Second); client.keepAlive > 0 && keepAlive != connectPkt.KeepAlive {
+			connack.Properties = append(connack.Properties, mqtt.Uint16Property(mqtt.PropServerKeepAlive, keepAlive))
+		}
+		connack.Properties = append(connack.Properties, mqtt.ByteProperty(mqtt.PropSharedSubAvailable, 0))
 	}
 	data, _ := connack.Encode()
-	conn.Write(data)
+	if _, err := writer.Write(data); err != nil {
+		log.Printf("Failed to send CONNACK to %s: %v", client.ID, err)
+		close(client.resumed)
+		return client // the read loop ends on the closed connection
+	}
 
-	log.Printf("Client %s connected successfully", client.ID)
+	log.Printf("Client %s connected successfully (session present: %v)", client.ID, connack.SessionPresent)
+
+	// Resume the session before any live message: unacknowledged deliveries
+	// first, then messages queued while the client was offline. The queue
+	// is sent alongside the read loop, whose acknowledgements make room for
+	// it in the inflight window.
+	s.resendInflight(client)
+	s.background(func() {
+		s.deliverQueued(client)
+		close(client.resumed)
+	})
+	s.recordConnection(client)
+	s.trackPresence(client, presenceConnected)
+	s.publishPresence(client, presenceConnected, connack.SessionPresent, "")
 
 	// Update metrics if configured
 	if s.config != nil && s.config.Metrics.Enabled {
@@ -247,68 +1035,247 @@ func (s *Server) handleConnect(conn net.Conn, reader *bytes.Reader, remainingLen
 
 func (s *Server) handlePublish(client *Client, header *mqtt.FixedHeader, data []byte) {
 	// Decode PUBLISH packet
-	publishPkt, err := mqtt.DecodePublishPacket(bytes.NewReader(data), header)
+	publishPkt, err := mqtt.DecodePublishPacket(bytes.NewReader(data), header, client.ProtocolVersion)
 	if err != nil {
-		log.Printf("Failed to decode PUBLISH: %v", err)
+		log.Printf("Failed to decode PUBLISH from %s: %v", client.ID, err)
+		s.dropMalformed(client, err)
+		return
+	}
+
+	// MQTT 5 clients were told the highest QoS they may publish with in
+	// CONNACK
+	if client.ProtocolVersion == mqtt.ProtocolV5 && publishPkt.QoS > s.clientMaxQoS(client) {
+		client.writer.Flush()
+		client.disconnect(mqtt.ReasonQoSNotSupported, fmt.Sprintf("QoS %d is not supported", publishPkt.QoS))
+		return
+	}
+
+	// Publishing to an empty topic or one with wildcards is a protocol
+	// violation; MQTT 5 clients are told through the PUBACK or PUBREC
+	// instead
+	if !mqtt.ValidTopicName(publishPkt.Topic) {
+		if client.ProtocolVersion == mqtt.ProtocolV5 && publishPkt.QoS > 0 {
+			log.Printf("Rejecting PUBLISH from %s: invalid topic name %q", client.ID, publishPkt.Topic)
+			s.sendAck(client, publishAck(publishPkt.QoS), publishPkt.PacketID, mqtt.ReasonTopicNameInvalid)
+			return
+		}
+		log.Printf("Closing connection of %s: PUBLISH to invalid topic name %q", client.ID, publishPkt.Topic)
+		client.writer.Flush()
+		client.Conn.Close()
 		return
 	}
+	publishPkt.Topic = client.mount(publishPkt.Topic)
 
-	log.Printf("PUBLISH from %s: topic=%s, QoS=%d, retain=%t, payload=%d bytes",
-		client.ID, publishPkt.Topic, publishPkt.QoS, publishPkt.Retain, len(publishPkt.Payload))
+	// A QoS 2 message is routed once: until the client releases its packet
+	// ID with PUBREL, a resent PUBLISH is only acknowledged again
+	if publishPkt.QoS == 2 && client.received.has(publishPkt.PacketID) {
+		s.sendAck(client, mqtt.PUBREC, publishPkt.PacketID, mqtt.ReasonSuccess)
+		return
+	}
+
+	client.stats.messagesIn.Add(1)
+	client.stats.bytesIn.Add(uint64(len(data)))
+	s.recordRate(client, publishPkt.Topic)
+	s.tracef(client.ID, publishPkt.Topic, "PUBLISH in: topic=%s packet_id=%d qos=%d retain=%t dup=%t payload=%s",
+		publishPkt.Topic, publishPkt.PacketID, publishPkt.QoS, publishPkt.Retain, publishPkt.Dup, traceDump(publishPkt.Payload))
+
+	// Drop messages the client may not publish or that exceed its rate limit
+	reason := mqtt.ReasonSuccess
+	switch {
+	case !s.authorizePublish(client, publishPkt.Topic):
+		reason = mqtt.ReasonNotAuthorized
+		log.Printf("Client %s is not authorized to publish to %s, dropping message", client.ID, publishPkt.Topic)
+	case !client.limiter.Load().Allow():
+		reason = mqtt.ReasonQuotaExceeded
+		log.Printf("Rate limit exceeded for %s, dropping message on topic %s", client.ID, publishPkt.Topic)
+	case !s.admitAnomalous(client):
+		reason = mqtt.ReasonQuotaExceeded
+		log.Printf("Client %s is throttled for an anomalous publish rate, dropping message on topic %s", client.ID, publishPkt.Topic)
+	case s.underMaintenance(publishPkt.Topic, MaintenanceReject):
+		reason = mqtt.ReasonImplementationSpecificErr
+		log.Printf("Rejecting message from %s on topic %s: maintenance window in effect", client.ID, publishPkt.Topic)
+	case !s.decodeTranscoded(client, publishPkt):
+		reason = mqtt.ReasonPayloadFormatInvalid
+	case !s.checkContentType(client, publishPkt):
+		reason = mqtt.ReasonPayloadFormatInvalid
+	case !s.checkSchema(client, publishPkt):
+		reason = mqtt.ReasonPayloadFormatInvalid
+	case publishPkt.Retain && len(publishPkt.Payload) > 0 && !s.admitRetained(client, publishPkt.Topic):
+		reason = mqtt.ReasonQuotaExceeded
+	case !s.admitUsage(client, publishPkt):
+		reason = mqtt.ReasonQuotaExceeded
+	}
+
+	// Send PUBACK for QoS 1 and PUBREC for QoS 2. MQTT 5 clients get the
+	// reason a message was dropped, which also ends a QoS 2 flow; 3.1.1 has
+	// no way to signal it, so the message is acknowledged.
+	if publishPkt.QoS > 0 {
+		if !s.sendAck(client, publishAck(publishPkt.QoS), publishPkt.PacketID, reason) {
+			return
+		}
+		if publishPkt.QoS == 2 && (reason == mqtt.ReasonSuccess || client.ProtocolVersion != mqtt.ProtocolV5) {
+			client.received.add(publishPkt.PacketID)
+		}
+	}
+
+	if reason == mqtt.ReasonSuccess {
+		s.recordPublished(client, len(publishPkt.Payload))
+		s.annotate(client, publishPkt)
+		s.publishMessage(publishPkt, client.ID)
+	}
+}
+
+// sendAck queues a PUBACK, PUBREC, PUBREL or PUBCOMP with an MQTT 5 reason
+// code (always success for older clients) and reports whether it could be
+// written
+func (s *Server) sendAck(client *Client, kind mqtt.PacketType, packetID uint16, reason byte) bool {
+	if client.ProtocolVersion != mqtt.ProtocolV5 {
+		reason = mqtt.ReasonSuccess
+	}
+	var ack mqtt.Packet
+	switch kind {
+	case mqtt.PUBREC:
+		ack = &mqtt.PubrecPacket{PacketID: packetID, ReasonCode: reason}
+	case mqtt.PUBREL:
+		ack = &mqtt.PubrelPacket{PacketID: packetID, ReasonCode: reason}
+	case mqtt.PUBCOMP:
+		ack = &mqtt.PubcompPacket{PacketID: packetID, ReasonCode: reason}
+	default:
+		ack = &mqtt.PubackPacket{PacketID: packetID, ReasonCode: reason}
+	}
+	ackData, _ := ack.Encode()
+	if _, err := client.writer.Write(ackData); err != nil {
+		log.Printf("Failed to send %s to %s: %v", kind, client.ID, err)
+		return false
+	}
+	if reason != mqtt.ReasonSuccess {
+		log.Printf("Sent %s to %s for packet %d (reason 0x%02x)", kind, client.ID, packetID, reason)
+	}
+	return true
+}
+
+// publishAck is the packet acknowledging a PUBLISH of the given QoS
+func publishAck(qos byte) mqtt.PacketType {
+	if qos == 2 {
+		return mqtt.PUBREC
+	}
+	return mqtt.PUBACK
+}
+
+// publishMessage updates retained state and routes a message to subscribers.
+// publisherID is empty for messages originating from the broker itself and
+// names the extension for messages injected through Publish.
+func (s *Server) publishMessage(publishPkt *mqtt.PublishPacket, publisherID string) {
+	// Muted topics are accepted but go nowhere while a maintenance window
+	// is in effect
+	if s.underMaintenance(publishPkt.Topic, MaintenanceMute) {
+		return
+	}
+
+	if s.lastValues != nil {
+		s.memory.add(memLastValue, s.lastValues.update(publishPkt, publisherID))
+	}
+	if s.analytics != nil {
+		s.analytics.record(publishPkt.Topic, publisherID)
+	}
+	if s.topicMetrics != nil {
+		s.topicMetrics.record(publishPkt.Topic, len(publishPkt.Payload))
+	}
+
+	logged := s.publishLog.sample(publishPkt.Topic)
+	if logged {
+		log.Printf("PUBLISH from %s: topic=%s, QoS=%d, retain=%t, payload=%d bytes",
+			publisherName(publisherID), publishPkt.Topic, publishPkt.QoS, publishPkt.Retain, len(publishPkt.Payload))
+	}
 
 	// Handle retained messages
 	if publishPkt.Retain {
 		s.retainedMsgsMu.Lock()
+		if old, ok := s.retainedMsgs[publishPkt.Topic]; ok {
+			s.memory.add(memRetained, -publishMemorySize(old.Topic, old.Payload))
+		}
 		if len(publishPkt.Payload) == 0 {
 			// Empty payload removes retained message
 			delete(s.retainedMsgs, publishPkt.Topic)
-			log.Printf("Removed retained message for topic %s", publishPkt.Topic)
+			delete(s.retainedAt, publishPkt.Topic)
+			s.retainedOwners.remove(publishPkt.Topic, s.retainedTenant(publishPkt.Topic))
+			if logged {
+				log.Printf("Removed retained message for topic %s", publishPkt.Topic)
+			}
 		} else {
 			// Store retained message
 			s.retainedMsgs[publishPkt.Topic] = publishPkt
-			log.Printf("Stored retained message for topic %s", publishPkt.Topic)
+			s.retainedAt[publishPkt.Topic] = time.Now()
+			s.retainedOwners.set(publishPkt.Topic, publisherID, s.retainedTenant(publishPkt.Topic))
+			s.memory.add(memRetained, publishMemorySize(publishPkt.Topic, publishPkt.Payload))
+			if logged {
+				log.Printf("Stored retained message for topic %s", publishPkt.Topic)
+			}
 		}
 		s.retainedMsgsMu.Unlock()
 	}
 
-	// Send PUBACK for QoS 1
-	if publishPkt.QoS == 1 {
-		puback := &mqtt.PubackPacket{
-			PacketID: publishPkt.PacketID,
-		}
-		ackData, _ := puback.Encode()
-		client.Conn.Write(ackData)
-		log.Printf("Sent PUBACK to %s for packet %d", client.ID, publishPkt.PacketID)
-	}
-
-	// Route message to subscribers
-	s.routeMessage(publishPkt)
+	// Route message to subscribers, offline sessions and extensions
+	s.routeMessage(publishPkt, publisherID, logged)
+	s.queueOffline(publishPkt)
+	s.runPublishHooks(publishPkt, publisherID)
+	s.runTaps(publishPkt, publisherID)
+	s.mirrorMessage(publishPkt, publisherID)
 }
 
 func (s *Server) handleSubscribe(client *Client, conn net.Conn, data []byte) {
 	// Decode SUBSCRIBE packet
-	subscribePkt, err := mqtt.DecodeSubscribePacket(bytes.NewReader(data), len(data))
+	subscribePkt, err := mqtt.DecodeSubscribePacket(bytes.NewReader(data), len(data), client.ProtocolVersion)
 	if err != nil {
-		log.Printf("Failed to decode SUBSCRIBE: %v", err)
+		log.Printf("Failed to decode SUBSCRIBE from %s: %v", client.ID, err)
+		s.dropMalformed(client, err)
 		return
 	}
 
 	log.Printf("SUBSCRIBE from %s: %d topics", client.ID, len(subscribePkt.Topics))
 
+	// Check the filters before they are mounted
+	rejected := make([]string, len(subscribePkt.Topics))
+	for i := range subscribePkt.Topics {
+		rejected[i] = s.checkSubscription(client, subscribePkt.Topics[i].Topic)
+		subscribePkt.Topics[i].Topic = client.mount(subscribePkt.Topics[i].Topic)
+	}
+
 	// Store subscriptions
 	client.mu.Lock()
 	returnCodes := make([]byte, len(subscribePkt.Topics))
+	sendRetained := make([]bool, len(subscribePkt.Topics))
 	for i, sub := range subscribePkt.Topics {
-		client.Subscriptions[sub.Topic] = sub.QoS
-		returnCodes[i] = sub.QoS // Grant requested QoS
-		log.Printf("  - %s subscribed to %s (QoS %d)", client.ID, sub.Topic, sub.QoS)
+		_, existed := client.Subscriptions[sub.Topic]
+		if rejected[i] == "" && !existed && s.atSubscriptionLimit(client) {
+			rejected[i] = SubscribeQuotaExceeded
+		}
+		if rejected[i] != "" {
+			returnCodes[i] = subackFailure(client.ProtocolVersion, rejected[i])
+			continue
+		}
+		granted := s.grantQoS(client, sub.QoS)
+		client.Subscriptions[sub.Topic] = granted
+		client.options[sub.Topic] = sub
+		s.subscriptions.add(client, sub.Topic)
+		returnCodes[i] = granted
+		retainHandling := s.retainHandling(client, sub)
+		sendRetained[i] = retainHandling == 0 || (retainHandling == 1 && !existed)
+		log.Printf("  - %s subscribed to %s (requested QoS %d, granted %d)", client.ID, sub.Topic, sub.QoS, granted)
 	}
 	client.mu.Unlock()
+	s.persistSubscriptions(client)
+	for i, reason := range rejected {
+		if reason != "" {
+			s.rejectSubscription(client, client.unmount(subscribePkt.Topics[i].Topic), reason)
+		}
+	}
 
 	// Send SUBACK
 	suback := &mqtt.SubackPacket{
 		PacketID:    subscribePkt.PacketID,
 		ReturnCodes: returnCodes,
+		Version:     client.ProtocolVersion,
 	}
 	ackData, err := suback.Encode()
 	if err != nil {
@@ -316,20 +1283,32 @@ func (s *Server) handleSubscribe(client *Client, conn net.Conn, data []byte) {
 		return
 	}
 
-	n, err := client.Conn.Write(ackData)
+	n, err := client.writer.Write(ackData)
 	if err != nil {
 		log.Printf("Failed to send SUBACK to %s: %v", client.ID, err)
 		return
 	}
 	log.Printf("Sent SUBACK to %s for packet %d (%d bytes)", client.ID, subscribePkt.PacketID, n)
 
+	// Retained messages must follow the SUBACK on the wire
+	if err := client.writer.Flush(); err != nil {
+		log.Printf("Failed to flush SUBACK to %s: %v", client.ID, err)
+		return
+	}
+
 	// Deliver retained messages matching the subscriptions
 	s.retainedMsgsMu.RLock()
 	for topic, retainedMsg := range s.retainedMsgs {
-		for _, sub := range subscribePkt.Topics {
-			if topicMatch(sub.Topic, topic) {
-				// Send retained message to new subscriber
-				go s.deliverMessage(client, retainedMsg, sub.QoS)
+		for i, sub := range subscribePkt.Topics {
+			if sendRetained[i] && rejected[i] == "" && topicMatch(sub.Topic, topic) {
+				// A wildcard subscription must not reveal retained state
+				// the client may not receive
+				if !s.authorizeRead(client, topic) {
+					log.Printf("Client %s is not authorized to receive %s, withholding retained message", client.ID, topic)
+					break
+				}
+				// Send retained message to new subscriber, flagged as retained
+				s.queueDelivery(client, retainedMsg, returnCodes[i], true, false)
 				log.Printf("Delivered retained message on topic %s to %s", topic, client.ID)
 				break
 			}
@@ -340,9 +1319,10 @@ func (s *Server) handleSubscribe(client *Client, conn net.Conn, data []byte) {
 
 func (s *Server) handleUnsubscribe(client *Client, data []byte) {
 	// Decode UNSUBSCRIBE packet
-	unsubscribePkt, err := mqtt.DecodeUnsubscribePacket(bytes.NewReader(data), len(data))
+	unsubscribePkt, err := mqtt.DecodeUnsubscribePacket(bytes.NewReader(data), len(data), client.ProtocolVersion)
 	if err != nil {
-		log.Printf("Failed to decode UNSUBSCRIBE: %v", err)
+		log.Printf("Failed to decode UNSUBSCRIBE from %s: %v", client.ID, err)
+		s.dropMalformed(client, err)
 		return
 	}
 
@@ -350,15 +1330,26 @@ func (s *Server) handleUnsubscribe(client *Client, data []byte) {
 
 	// Remove subscriptions
 	client.mu.Lock()
-	for _, topic := range unsubscribePkt.Topics {
+	reasonCodes := make([]byte, len(unsubscribePkt.Topics))
+	for i, topic := range unsubscribePkt.Topics {
+		topic = client.mount(topic)
+		if _, ok := client.Subscriptions[topic]; !ok {
+			reasonCodes[i] = mqtt.ReasonNoSubscriptionExisted
+		} else {
+			s.subscriptions.remove(client, topic)
+		}
 		delete(client.Subscriptions, topic)
+		delete(client.options, topic)
 		log.Printf("  - %s unsubscribed from %s", client.ID, topic)
 	}
 	client.mu.Unlock()
+	s.persistSubscriptions(client)
 
 	// Send UNSUBACK
 	unsuback := &mqtt.UnsubackPacket{
-		PacketID: unsubscribePkt.PacketID,
+		PacketID:    unsubscribePkt.PacketID,
+		Version:     client.ProtocolVersion,
+		ReasonCodes: reasonCodes,
 	}
 	ackData, err := unsuback.Encode()
 	if err != nil {
@@ -366,7 +1357,7 @@ func (s *Server) handleUnsubscribe(client *Client, data []byte) {
 		return
 	}
 
-	n, err := client.Conn.Write(ackData)
+	n, err := client.writer.Write(ackData)
 	if err != nil {
 		log.Printf("Failed to send UNSUBACK to %s: %v", client.ID, err)
 		return
@@ -374,52 +1365,137 @@ func (s *Server) handleUnsubscribe(client *Client, data []byte) {
 	log.Printf("Sent UNSUBACK to %s for packet %d (%d bytes)", client.ID, unsubscribePkt.PacketID, n)
 }
 
-// routeMessage delivers a message to all matching subscribers
-func (s *Server) routeMessage(pub *mqtt.PublishPacket) {
+// routeMessage delivers a message to all matching subscribers, once per
+// client through its matching subscription with the highest QoS.
+// Subscribers with the MQTT 5 No Local option do not receive their own
+// messages. logged selects the message for the sampled publish log.
+func (s *Server) routeMessage(pub *mqtt.PublishPacket, publisherID string, logged bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	// Messages delivered to existing subscriptions are not flagged as
+	// retained, even if the publisher set the flag, unless retain as
+	// published is configured
+	retain := pub.Retain && s.config.Server.RetainAsPublished
+
 	delivered := 0
-	for _, client := range s.clients {
+	for client, filters := range s.subscriptions.match(pub.Topic) {
+		// A connection taken over keeps its subscriptions until it ends,
+		// but they belong to the new connection now
+		if s.clients[client.key] != client {
+			continue
+		}
 		client.mu.RLock()
-		for subTopic, subQoS := range client.Subscriptions {
-			if topicMatch(subTopic, pub.Topic) {
-				// Deliver message to subscriber
-				go s.deliverMessage(client, pub, subQoS)
+		var subTopic string
+		var subQoS byte
+		matched := false
+		for _, filter := range filters {
+			if qos, ok := client.Subscriptions[filter]; ok && (!matched || qos > subQoS) {
+				subTopic, subQoS, matched = filter, qos, true
+			}
+		}
+		opts := client.options[subTopic]
+		if matched && !(opts.NoLocal && client.ID == publisherID) && s.authorizeRead(client, pub.Topic) {
+			// Deliver message to subscriber
+			if s.queueDelivery(client, pub, subQoS, retain || (pub.Retain && opts.RetainAsPublished), logged) {
 				delivered++
-				break // Only deliver once per client
 			}
 		}
 		client.mu.RUnlock()
 	}
 
-	log.Printf("Routed message on topic %s to %d subscribers", pub.Topic, delivered)
+	if logged {
+		log.Printf("Routed message on topic %s to %d subscribers", pub.Topic, delivered)
+	}
 }
 
-// deliverMessage sends a PUBLISH packet to a subscriber
-func (s *Server) deliverMessage(client *Client, pub *mqtt.PublishPacket, subQoS byte) {
-	// Use the minimum of publisher and subscriber QoS
-	qos := pub.QoS
-	if subQoS < qos {
-		qos = subQoS
+// queueDelivery hands a message to a subscriber asynchronously through its
+// outbox, accounting for it in the memory guard until it has been written.
+// QoS 0 deliveries are dropped while the broker is overloaded; the return
+// value reports whether the message was queued. retain sets the RETAIN
+// flag of the delivery and logged selects it for the sampled publish log.
+func (s *Server) queueDelivery(client *Client, pub *mqtt.PublishPacket, subQoS byte, retain, logged bool) bool {
+	if (pub.QoS == 0 || subQoS == 0) && s.memory.Overloaded() {
+		metrics.OverloadShedMessages.Inc()
+		return false
+	}
+
+	size := publishMemorySize(pub.Topic, pub.Payload)
+	s.memory.add(memQueued, size)
+	m := outgoing{pub: pub, subQoS: subQoS, retain: retain, logged: logged, size: size, seq: client.pending.add()}
+	if client.outbox.push(m) {
+		s.wg.Add(1)
+		go s.drainOutbox(client)
+	}
+	return true
+}
+
+// deliverMessage sends a PUBLISH packet to a subscriber. QoS 1 and 2
+// deliveries get a packet ID of their own and are tracked until their
+// PUBACK or PUBCOMP.
+func (s *Server) deliverMessage(client *Client, pub *mqtt.PublishPacket, subQoS byte, retain, logged bool) {
+	// The subscriber may have gone away while the message was queued
+	if client.ctx.Err() != nil {
+		s.handOff(client, pub, subQoS, retain)
+		return
+	}
+
+	// Use the minimum of publisher and granted subscriber QoS
+	delivery := &mqtt.PublishPacket{
+		QoS:        deliveryQoS(pub.QoS, subQoS),
+		Topic:      pub.Topic,
+		Payload:    pub.Payload,
+		Properties: pub.Properties,
+	}
+	if client.ProtocolVersion != mqtt.ProtocolV5 && s.config.Annotation.WrapJSON {
+		delivery.Payload = wrapAnnotated(pub)
+	}
+	s.encodeTranscoded(client, delivery)
+
+	// QoS 1 and 2 deliveries wait for room in the inflight window, holding
+	// back the rest of the outbox; if the connection ends first, the
+	// message stays with the session
+	var packetID uint16
+	if delivery.QoS > 0 {
+		var ok bool
+		if packetID, ok = client.inflight.add(client.ctx, delivery, s.clock); !ok {
+			s.handOff(client, pub, subQoS, retain)
+			return
+		}
+		s.persistInflight(client, packetID, delivery, false)
+	}
+	if s.writePublish(client, delivery, packetID, retain, false) && logged {
+		log.Printf("Delivered message to %s on topic %s", client.ID, pub.Topic)
 	}
+}
+
+// writePublish encodes a PUBLISH packet for a subscriber and writes it,
+// reporting whether the write succeeded
+func (s *Server) writePublish(client *Client, pub *mqtt.PublishPacket, packetID uint16, retain, dup bool) bool {
+	qos := pub.QoS
 
 	// Build PUBLISH packet
 	buf := bytes.NewBuffer(nil)
 
 	// Fixed header
 	fixedHeader := byte(mqtt.PUBLISH) << 4
-	if pub.Dup {
+	if dup {
 		fixedHeader |= 0x08
 	}
 	fixedHeader |= (qos << 1)
-	if pub.Retain {
+	if retain {
 		fixedHeader |= 0x01
 	}
 	buf.WriteByte(fixedHeader)
 
 	// Calculate remaining length
-	remainingLen := 2 + len(pub.Topic) + len(pub.Payload)
+	topic := client.unmount(pub.Topic)
+	var props []byte
+	if client.ProtocolVersion == mqtt.ProtocolV5 {
+		// Aliases and subscription identifiers only apply to the inbound side
+		props = pub.Properties.Without(mqtt.PropTopicAlias, mqtt.PropSubscriptionIdentifier).Encode()
+	}
+	remainingLen := 2 + len(topic) + len(props) + len(pub.Payload)
 	if qos > 0 {
 		remainingLen += 2 // Packet ID
 	}
@@ -441,27 +1517,30 @@ func (s *Server) deliverMessage(client *Client, pub *mqtt.PublishPacket, subQoS
 	}
 
 	// Write topic
-	buf.Write(mqtt.WriteString(pub.Topic))
+	buf.Write(mqtt.WriteString(topic))
 
 	// Write packet ID if QoS > 0
 	if qos > 0 {
-		packetID := pub.PacketID
-		if packetID == 0 {
-			packetID = 1 // Generate packet ID if needed
-		}
 		buf.WriteByte(byte(packetID >> 8))
 		buf.WriteByte(byte(packetID & 0xFF))
 	}
+	buf.Write(props)
 
 	// Write payload
 	buf.Write(pub.Payload)
 
+	s.tracef(client.ID, pub.Topic, "PUBLISH out: topic=%s qos=%d: %s", pub.Topic, qos, traceDump(buf.Bytes()))
+
 	// Send to client
-	if _, err := client.Conn.Write(buf.Bytes()); err != nil {
+	n, err := client.writer.WritePacket(buf.Bytes())
+	if err != nil {
 		log.Printf("Failed to deliver message to %s: %v", client.ID, err)
-	} else {
-		log.Printf("Delivered message to %s on topic %s", client.ID, pub.Topic)
+		return false
 	}
+	client.stats.messagesOut.Add(1)
+	client.stats.bytesOut.Add(uint64(n))
+	s.recordDelivered(client, n)
+	return true
 }
 
 // topicMatch checks if a subscription topic matches a publish topic
@@ -472,6 +1551,11 @@ func topicMatch(subTopic, pubTopic string) bool {
 		return true
 	}
 
+	// Broker topics such as $SYS/... are not matched by a leading wildcard
+	if strings.HasPrefix(pubTopic, "$") && (strings.HasPrefix(subTopic, "#") || strings.HasPrefix(subTopic, "+")) {
+		return false
+	}
+
 	// Split topics into levels
 	subLevels := splitTopic(subTopic)
 	pubLevels := splitTopic(pubTopic)
@@ -509,30 +1593,30 @@ func topicMatch(subTopic, pubTopic string) bool {
 	return si == len(subLevels) && pi == len(pubLevels)
 }
 
-// splitTopic splits a topic into levels by '/'
+// splitTopic splits a topic into levels by '/'. Empty levels count, so a
+// leading or trailing '/' adds a level.
 func splitTopic(topic string) []string {
 	if topic == "" {
 		return []string{}
 	}
-
-	levels := []string{}
-	start := 0
-	for i := 0; i < len(topic); i++ {
-		if topic[i] == '/' {
-			levels = append(levels, topic[start:i])
-			start = i + 1
-		}
-	}
-	// Add last level
-	if start < len(topic) {
-		levels = append(levels, topic[start:])
-	}
-
-	return levels
+	return strings.Split(topic, "/")
 }
 
-func (s *Server) handlePingreq(conn net.Conn) {
+func (s *Server) handlePingreq(client *Client, writer *connWriter) {
 	pingresp := &mqtt.PingrespPacket{}
 	data, _ := pingresp.Encode()
-	conn.Write(data)
+	writer.Write(data)
+
+	if client != nil {
+		s.sampleLatency(client)
+	}
+}
+
+// assignClientID generates an identifier for a 3.1.1 client that connected
+// with an empty client ID. It stays within the 23 characters 3.1 allows so
+// it can be reused when bridging to legacy brokers.
+func assignClientID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return "auto-" + hex.EncodeToString(b[:])
 }
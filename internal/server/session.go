@@ -0,0 +1,417 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"math"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/clock"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// defaultMaxQueuedMessages is used when no offline queue limit is configured
+const defaultMaxQueuedMessages = 1000
+
+// sessionNeverExpires is the MQTT 5 Session Expiry Interval of a session
+// that is kept until the client ends it
+const sessionNeverExpires = math.MaxUint32
+
+// offlineSession is a persistent session (CleanSession=false) whose client
+// is not connected. Messages matching its subscriptions are queued in the
+// store until the client reconnects or the session expires.
+type offlineSession struct {
+	subscriptions map[string]byte              // topic filter -> granted QoS
+	options       map[string]mqtt.Subscription // topic filter -> requested options
+	queued        int                          // messages in its store queue, counted against the queue limit
+	maxQueued     int                          // queue limit from the client's policy (0 = limits.max_queued_messages)
+	received      []uint16                     // QoS 2 packet IDs the client has yet to release
+	expiry        uint32                       // MQTT 5 Session Expiry Interval in seconds, 0 if it never expires
+	expiryTimer   clock.Timer                  // ends the session when its expiry interval passes, nil until armed
+	will          *delayedWill                 // delayed will suspended while the broker is stopped, nil if none
+}
+
+// maxQoS returns the highest QoS the broker grants
+func (s *Server) maxQoS() byte {
+	if s.config.QoS.MaxQoS == 0 {
+		return 1 // unset: the configuration default
+	}
+	return s.config.QoS.MaxQoS
+}
+
+// grantQoS returns the QoS granted to a client for a requested
+// subscription QoS
+func (s *Server) grantQoS(client *Client, requested byte) byte {
+	return min(requested, s.clientMaxQoS(client))
+}
+
+// deliveryQoS is the QoS a message is delivered with: the lower of the
+// publish QoS and the QoS granted to the subscription
+func deliveryQoS(pubQoS, granted byte) byte {
+	return min(pubQoS, granted)
+}
+
+// persistSubscriptions saves the subscriptions of a persistent session
+func (s *Server) persistSubscriptions(client *Client) {
+	if client.CleanSession || s.store == nil {
+		return
+	}
+
+	client.mu.RLock()
+	session := &store.Session{ClientID: client.key, ExpiryInterval: client.sessionExpiry}
+	for filter, granted := range client.Subscriptions {
+		opts := client.options[filter]
+		session.Subscriptions = append(session.Subscriptions, store.Subscription{
+			Topic:             filter,
+			QoS:               granted,
+			RequestedQoS:      opts.QoS,
+			NoLocal:           opts.NoLocal,
+			RetainAsPublished: opts.RetainAsPublished,
+			RetainHandling:    opts.RetainHandling,
+		})
+	}
+	client.mu.RUnlock()
+
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	if err := s.store.SaveSession(ctx, client.key, session); err != nil {
+		log.Printf("Failed to persist session of %s: %v", client.ID, err)
+	}
+}
+
+// restoreSession gives a persistent client the subscriptions of its
+// previous connection, its offline session or, failing both, the store,
+// and reports whether a session was found. Clean sessions discard any
+// offline session.
+func (s *Server) restoreSession(client, previous *Client) bool {
+	s.sessionsMu.Lock()
+	offline := s.sessions[client.key]
+	delete(s.sessions, client.key)
+	if offline != nil && offline.expiryTimer != nil {
+		offline.expiryTimer.Stop()
+	}
+	s.sessionsMu.Unlock()
+
+	if client.cleanStart {
+		s.discardSession(client.key)
+		return false
+	}
+	if previous != nil && previous.CleanSession {
+		previous = nil // its session ended with the connection
+	}
+	s.restoreInflight(client, previous)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	switch {
+	case previous != nil:
+		previous.mu.RLock()
+		maps.Copy(client.Subscriptions, previous.Subscriptions)
+		maps.Copy(client.options, previous.options)
+		previous.mu.RUnlock()
+		client.received.restore(previous.received.list())
+	case offline != nil:
+		maps.Copy(client.Subscriptions, offline.subscriptions)
+		maps.Copy(client.options, offline.options)
+		client.received.restore(offline.received)
+	case s.store != nil:
+		ctx, cancel := s.storeContext()
+		session, err := s.store.LoadSession(ctx, client.key)
+		cancel()
+		if err != nil {
+			if !errors.Is(err, store.ErrSessionNotFound) {
+				log.Printf("Failed to load session of %s: %v", client.ID, err)
+			}
+			return false
+		}
+		for _, sub := range session.Subscriptions {
+			client.Subscriptions[sub.Topic] = sub.QoS
+			client.options[sub.Topic] = storedOptions(sub)
+		}
+	default:
+		return false
+	}
+	for filter := range client.Subscriptions {
+		s.subscriptions.add(client, filter)
+	}
+	if len(client.Subscriptions) > 0 {
+		log.Printf("Restored %d subscriptions for %s", len(client.Subscriptions), client.ID)
+	}
+	return true
+}
+
+// loadSessions turns the persistent sessions in the store into offline
+// sessions, so messages published after a restart are queued for clients
+// that have not reconnected yet
+func (s *Server) loadSessions() {
+	if s.store == nil {
+		return
+	}
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	sessions, err := s.store.ListSessions(ctx)
+	if err != nil {
+		log.Printf("Failed to load persistent sessions: %v", err)
+		return
+	}
+
+	offline := make(map[string]*offlineSession, len(sessions))
+	for _, session := range sessions {
+		o := &offlineSession{
+			subscriptions: make(map[string]byte),
+			options:       make(map[string]mqtt.Subscription),
+			queued:        s.storedQueueLen(session.ClientID),
+			expiry:        session.ExpiryInterval,
+			will:          storedWill(session),
+		}
+		for _, sub := range session.Subscriptions {
+			o.subscriptions[sub.Topic] = sub.QoS
+			o.options[sub.Topic] = storedOptions(sub)
+		}
+		offline[session.ClientID] = o // the session key
+	}
+
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	maps.Copy(s.sessions, offline)
+	if len(sessions) > 0 {
+		log.Printf("Loaded %d persistent sessions", len(sessions))
+	}
+}
+
+// storedQueueLen returns the number of messages in a client's store queue,
+// left over from earlier connections or before a restart, so they count
+// against the queue limit of its offline session
+func (s *Server) storedQueueLen(key string) int {
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	n, err := s.store.QueueLen(ctx, key)
+	if err != nil {
+		log.Printf("Failed to count queued messages of %s: %v", key, err)
+	}
+	return n
+}
+
+// storedOptions converts a stored subscription to its subscription options
+func storedOptions(sub store.Subscription) mqtt.Subscription {
+	return mqtt.Subscription{
+		Topic:             sub.Topic,
+		QoS:               sub.RequestedQoS,
+		NoLocal:           sub.NoLocal,
+		RetainAsPublished: sub.RetainAsPublished,
+		RetainHandling:    sub.RetainHandling,
+	}
+}
+
+// discardSession removes any stored state of a session: subscriptions,
+// queued messages and unacknowledged deliveries
+func (s *Server) discardSession(key string) {
+	if s.store == nil {
+		return
+	}
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	if err := s.store.DeleteSession(ctx, key); err != nil {
+		log.Printf("Failed to delete session of %s: %v", key, err)
+	}
+}
+
+// suspendSession keeps the subscriptions of a persistent client that
+// disconnected so messages can be queued for it, and returns its offline
+// session, or nil if it ended. Called with s.mu held; settleSession then
+// updates the store.
+func (s *Server) suspendSession(client *Client) *offlineSession {
+	if client.CleanSession || client.sessionEnded.Load() || s.store == nil {
+		return nil
+	}
+
+	client.mu.RLock()
+	offline := &offlineSession{
+		subscriptions: maps.Clone(client.Subscriptions),
+		options:       maps.Clone(client.options),
+		received:      client.received.list(),
+	}
+	if client.policy != nil {
+		offline.maxQueued = client.policy.MaxQueued
+	}
+	client.mu.RUnlock()
+	offline.expiry = client.sessionExpiry
+
+	s.sessionsMu.Lock()
+	s.sessions[client.key] = offline
+	s.armSessionExpiry(client.key, offline)
+	s.sessionsMu.Unlock()
+	return offline
+}
+
+// settleSession finishes suspending the session of a client that
+// disconnected, without holding s.mu: the messages left in its store queue
+// count against the queue limit of its offline session, and a session that
+// ended is wiped from the store
+func (s *Server) settleSession(client *Client, offline *offlineSession) {
+	if offline == nil {
+		s.discardSession(client.key)
+		return
+	}
+	queued := s.storedQueueLen(client.key)
+	s.sessionsMu.Lock()
+	// Messages queued since the session was suspended are in both counts
+	offline.queued = max(offline.queued, queued)
+	s.sessionsMu.Unlock()
+}
+
+// armSessionExpiry starts the countdown of an offline session's expiry
+// interval. Called with s.sessionsMu held.
+func (s *Server) armSessionExpiry(key string, session *offlineSession) {
+	if session.expiry == 0 || session.expiry == sessionNeverExpires || session.expiryTimer != nil {
+		return
+	}
+	session.expiryTimer = s.clock.AfterFunc(time.Duration(session.expiry)*time.Second, func() {
+		s.expireSession(key, session)
+	})
+}
+
+// armSessionExpiries starts the countdown of the sessions loaded from the
+// store. Their clients' disconnection times were not stored, so the
+// interval counts from the start of the broker: a session may outlive its
+// interval across a restart, but never ends early.
+func (s *Server) armSessionExpiries() {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	for clientID, session := range s.sessions {
+		s.armSessionExpiry(clientID, session)
+	}
+}
+
+// expireSession ends an offline session whose expiry interval has passed,
+// unless its client has reconnected in the meantime
+func (s *Server) expireSession(key string, session *offlineSession) {
+	s.sessionsMu.Lock()
+	if s.sessions[key] != session {
+		s.sessionsMu.Unlock()
+		return
+	}
+	delete(s.sessions, key)
+	s.sessionsMu.Unlock()
+
+	log.Printf("Session of %s expired after %ds offline", key, session.expiry)
+	s.discardSession(key)
+}
+
+// queueOffline stores a message for every offline session subscribed to
+// its topic. Messages that would be delivered with QoS 0 are not queued.
+func (s *Server) queueOffline(pub *mqtt.PublishPacket) {
+	if s.store == nil {
+		return
+	}
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	for clientID, session := range s.sessions {
+		var granted byte
+		matched := false
+		for filter, qos := range session.subscriptions {
+			if topicMatch(filter, pub.Topic) && (!matched || qos > granted) {
+				granted, matched = qos, true
+			}
+		}
+		qos := deliveryQoS(pub.QoS, granted)
+		if !matched || qos == 0 {
+			continue
+		}
+
+		if limit := s.maxQueued(session); limit > 0 && session.queued >= limit {
+			metrics.OfflineMessages.WithLabelValues("dropped").Inc()
+			continue
+		}
+		msg := &store.Message{Topic: pub.Topic, Payload: pub.Payload, QoS: qos}
+		ctx, cancel := s.storeContext()
+		err := s.store.EnqueueMessage(ctx, clientID, msg)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to queue message on %s for offline client %s: %v", pub.Topic, clientID, err)
+			metrics.OfflineMessages.WithLabelValues("dropped").Inc()
+			continue
+		}
+		session.queued++
+		metrics.OfflineMessages.WithLabelValues("queued").Inc()
+	}
+}
+
+// handOff passes a QoS 1 or 2 delivery that was still waiting when its
+// connection ended to the client's session: the connection that took the
+// session over or, while the client is offline, the store queue. It is not
+// lost, and resumes after the deliveries that were already in flight.
+func (s *Server) handOff(client *Client, pub *mqtt.PublishPacket, subQoS byte, retain bool) {
+	qos := deliveryQoS(pub.QoS, subQoS)
+	if client.CleanSession || client.sessionEnded.Load() || qos == 0 || s.store == nil {
+		return
+	}
+
+	s.mu.RLock()
+	successor := s.clients[client.key]
+	s.mu.RUnlock()
+	if successor != nil && successor != client {
+		if !successor.CleanSession {
+			s.queueDelivery(successor, pub, subQoS, retain, false)
+		}
+		return
+	}
+
+	msg := &store.Message{Topic: pub.Topic, Payload: pub.Payload, QoS: qos}
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	if err := s.store.EnqueueMessage(ctx, client.key, msg); err != nil {
+		log.Printf("Failed to queue undelivered message on %s for %s: %v", pub.Topic, client.ID, err)
+		metrics.OfflineMessages.WithLabelValues("dropped").Inc()
+		return
+	}
+	s.sessionsMu.Lock()
+	if session := s.sessions[client.key]; session != nil {
+		session.queued++
+	}
+	s.sessionsMu.Unlock()
+	metrics.OfflineMessages.WithLabelValues("queued").Inc()
+}
+
+// queueBatchSize is how many queued messages are read from the store per
+// transaction when delivering a backlog
+const queueBatchSize = 100
+
+// deliverQueued sends the messages queued while a persistent client was
+// offline, in the order they were published. The queue is streamed in
+// batches so a large backlog does not hold the store's write lock; if the
+// client goes away, the rest stays queued. A client resuming its session
+// gets the queue even if the session ends with this connection.
+func (s *Server) deliverQueued(client *Client) {
+	if client.cleanStart || s.store == nil {
+		return
+	}
+	delivered := 0
+	for client.ctx.Err() == nil {
+		ctx, cancel := s.storeContext()
+		messages, err := s.store.DequeueBatch(ctx, client.key, queueBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to load queued messages for %s: %v", client.ID, err)
+			break
+		}
+		for _, msg := range messages {
+			// Permissions may have changed while the client was away
+			if !s.authorizeRead(client, msg.Topic) {
+				continue
+			}
+			pub := &mqtt.PublishPacket{Topic: msg.Topic, Payload: msg.Payload, QoS: msg.QoS}
+			s.deliverMessage(client, pub, msg.QoS, false, false)
+		}
+		delivered += len(messages)
+		if len(messages) < queueBatchSize {
+			break
+		}
+	}
+	if delivered > 0 {
+		log.Printf("Delivered %d queued messages to %s", delivered, client.ID)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// ErrSessionNotFound is returned for clients that are neither connected nor
+// hold a persistent session
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionState describes the QoS state of a connected client or of an
+// offline persistent session
+type SessionState struct {
+	ClientID      string             `json:"client_id"`
+	Connected     bool               `json:"connected"`
+	CleanSession  bool               `json:"clean_session"`
+	Subscriptions map[string]byte    `json:"subscriptions"` // topic filter -> granted QoS
+	Inflight      []InflightDelivery `json:"inflight"`      // in the order they were sent
+	Outbound      *OutboundQueue     `json:"outbound,omitempty"`
+	Queued        *QueueSummary      `json:"queued,omitempty"`
+}
+
+// InflightDelivery is a QoS 1 delivery awaiting the client's PUBACK, or a
+// QoS 2 delivery awaiting its PUBREC or, once released, its PUBCOMP. Send
+// times are only known while the session is held in memory.
+type InflightDelivery struct {
+	PacketID     uint16     `json:"packet_id"`
+	Topic        string     `json:"topic"`
+	QoS          byte       `json:"qos"`
+	PayloadBytes int        `json:"payload_bytes"`
+	SentAt       *time.Time `json:"sent_at,omitempty"` // first sent, or restored from the store
+	Age          string     `json:"age,omitempty"`
+	Retries      int        `json:"retries"`            // times resent with the DUP flag
+	Released     bool       `json:"released,omitempty"` // QoS 2: PUBREC received, awaiting PUBCOMP
+}
+
+// OutboundQueue describes the messages a connected client has yet to be
+// written
+type OutboundQueue struct {
+	Depth     int    `json:"depth"`
+	OldestAge string `json:"oldest_age,omitempty"`
+}
+
+// QueueSummary describes the messages stored for an offline session. Bytes
+// and Topics cover the Sampled oldest messages, all of them unless the
+// queue is longer than maxQueueSample.
+type QueueSummary struct {
+	Messages int            `json:"messages"`
+	Sampled  int            `json:"sampled"`
+	Bytes    int            `json:"bytes"`
+	Topics   map[string]int `json:"topics,omitempty"` // topic -> queued messages
+}
+
+// maxQueueSample is how many queued messages SessionState reads at most to
+// summarise a queue
+const maxQueueSample = 10 * queueBatchSize
+
+// SessionState returns the subscriptions, inflight window and queues of a
+// client, to debug QoS flows that do not complete. Clients of a virtual host
+// are named by its server name, a NUL and their ClientID.
+func (s *Server) SessionState(clientID string) (*SessionState, error) {
+	s.mu.RLock()
+	client, connected := s.clients[clientID]
+	s.mu.RUnlock()
+	if connected {
+		return s.connectedState(client), nil
+	}
+
+	s.sessionsMu.Lock()
+	session, ok := s.sessions[clientID]
+	var subscriptions map[string]byte
+	if ok {
+		subscriptions = maps.Clone(session.subscriptions)
+	}
+	s.sessionsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, clientID)
+	}
+
+	state := &SessionState{ClientID: clientID, Subscriptions: subscriptions, Inflight: []InflightDelivery{}}
+	if s.store == nil {
+		return state, nil
+	}
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	inflight, err := s.store.LoadInflight(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range inflight {
+		state.Inflight = append(state.Inflight, InflightDelivery{
+			PacketID:     m.PacketID,
+			Topic:        m.Message.Topic,
+			QoS:          m.Message.QoS,
+			PayloadBytes: len(m.Message.Payload),
+			Released:     m.Released,
+		})
+	}
+	queued, err := s.store.QueueLen(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	state.Queued = &QueueSummary{Messages: queued}
+	if queued > 0 {
+		state.Queued.Topics = make(map[string]int)
+	}
+	for state.Queued.Sampled < min(queued, maxQueueSample) {
+		page, err := s.store.PeekQueue(ctx, clientID, state.Queued.Sampled, queueBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range page {
+			state.Queued.Bytes += len(msg.Payload)
+			state.Queued.Topics[msg.Topic]++
+		}
+		state.Queued.Sampled += len(page)
+		if len(page) < queueBatchSize {
+			break // drained since it was counted
+		}
+	}
+	return state, nil
+}
+
+// connectedState describes the session of a connected client
+func (s *Server) connectedState(client *Client) *SessionState {
+	client.mu.RLock()
+	subscriptions := maps.Clone(client.Subscriptions)
+	client.mu.RUnlock()
+
+	state := &SessionState{
+		ClientID:      client.ID,
+		Connected:     true,
+		CleanSession:  client.CleanSession,
+		Subscriptions: subscriptions,
+		Inflight:      []InflightDelivery{},
+	}
+	now := s.clock.Now()
+	for _, m := range client.inflight.copies() {
+		sentAt := m.sentAt
+		state.Inflight = append(state.Inflight, InflightDelivery{
+			PacketID:     m.packetID,
+			Topic:        m.pub.Topic,
+			QoS:          m.pub.QoS,
+			PayloadBytes: len(m.pub.Payload),
+			SentAt:       &sentAt,
+			Age:          now.Sub(sentAt).Round(time.Millisecond).String(),
+			Retries:      m.retries,
+			Released:     m.released,
+		})
+	}
+	depth, oldest := client.pending.snapshot()
+	state.Outbound = &OutboundQueue{Depth: depth}
+	if !oldest.IsZero() {
+		state.Outbound.OldestAge = time.Since(oldest).Round(time.Millisecond).String()
+	}
+	return state
+}
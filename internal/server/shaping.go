@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// Traffic shaping limits
+const (
+	shapedChunkSize  = 32 * 1024 // largest chunk read from or queued for the socket
+	shapedQueueDepth = 256       // chunks held in each direction before writers block
+	shapedFlushGrace = 5 * time.Second
+)
+
+// shapedChunk is data held back until its delivery time
+type shapedChunk struct {
+	data []byte
+	due  time.Time
+}
+
+// pacer spaces out transfers to a bandwidth cap
+type pacer struct {
+	rate float64 // bytes per second, 0 = unlimited
+	next time.Time
+}
+
+// wait blocks until n more bytes fit the bandwidth cap
+func (p *pacer) wait(n int) {
+	if p.rate <= 0 {
+		return
+	}
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(float64(n) / p.rate * float64(time.Second)))
+	time.Sleep(time.Until(p.next))
+}
+
+// shapedConn imitates a slow or distant network: data in both directions
+// is paced to the bandwidth caps and held back for the latency plus
+// jitter. Deadlines apply to the shaped data, not to the socket.
+type shapedConn struct {
+	net.Conn
+	cfg config.ShapingConfig
+
+	reads        chan shapedChunk
+	readErr      error // set before reads is closed
+	pending      *shapedChunk
+	readDeadline atomic.Int64 // unix nanoseconds, 0 = none
+
+	writes        chan shapedChunk
+	writeErr      atomic.Pointer[error]
+	writeDeadline atomic.Int64
+	flushed       chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// shapeConn wraps a connection accepted on a listener with shaping
+// configured; other connections are returned unchanged
+func shapeConn(conn net.Conn, cfg config.ShapingConfig) net.Conn {
+	if cfg == (config.ShapingConfig{}) {
+		return conn
+	}
+	c := &shapedConn{
+		Conn:    conn,
+		cfg:     cfg,
+		reads:   make(chan shapedChunk, shapedQueueDepth),
+		writes:  make(chan shapedChunk, shapedQueueDepth),
+		flushed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.writeLoop()
+	return c
+}
+
+// delay returns the latency plus jitter for the next chunk
+func (c *shapedConn) delay() time.Duration {
+	d := c.cfg.Latency
+	if c.cfg.Jitter > 0 {
+		d += rand.N(c.cfg.Jitter)
+	}
+	return d
+}
+
+// readLoop receives data from the socket and queues it for delivery after
+// the delay
+func (c *shapedConn) readLoop() {
+	defer close(c.reads)
+	in := pacer{rate: float64(c.cfg.ReadBandwidth)}
+	var lastDue time.Time
+	for {
+		buf := make([]byte, shapedReadSize(c.cfg.ReadBandwidth))
+		n, err := c.Conn.Read(buf)
+		if n > 0 {
+			in.wait(n)
+			// Jitter must not reorder a stream
+			due := time.Now().Add(c.delay())
+			if due.Before(lastDue) {
+				due = lastDue
+			}
+			lastDue = due
+			select {
+			case c.reads <- shapedChunk{data: buf[:n], due: due}:
+			case <-c.closed:
+				c.readErr = net.ErrClosed
+				return
+			}
+		}
+		if err != nil {
+			c.readErr = err
+			return
+		}
+	}
+}
+
+// Read returns received data once its delay has passed
+func (c *shapedConn) Read(p []byte) (int, error) {
+	expired, stop := deadlineTimer(c.readDeadline.Load())
+	defer stop()
+
+	if c.pending == nil {
+		select {
+		case chunk, ok := <-c.reads:
+			if !ok {
+				return 0, c.readErr
+			}
+			c.pending = &chunk
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-c.closed:
+			return 0, net.ErrClosed
+		}
+	}
+	if wait := time.Until(c.pending.due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-c.closed:
+			return 0, net.ErrClosed
+		}
+	}
+
+	n := copy(p, c.pending.data)
+	c.pending.data = c.pending.data[n:]
+	if len(c.pending.data) == 0 {
+		c.pending = nil
+	}
+	return n, nil
+}
+
+// Write queues data for sending after the delay. Errors of earlier
+// writes are reported by later ones.
+func (c *shapedConn) Write(p []byte) (int, error) {
+	if err := c.writeErr.Load(); err != nil {
+		return 0, *err
+	}
+	expired, stop := deadlineTimer(c.writeDeadline.Load())
+	defer stop()
+
+	written := 0
+	for written < len(p) {
+		n := min(len(p)-written, shapedChunkSize)
+		chunk := shapedChunk{data: bytes.Clone(p[written : written+n]), due: time.Now().Add(c.delay())}
+		select {
+		case c.writes <- chunk:
+		case <-expired:
+			return written, os.ErrDeadlineExceeded
+		case <-c.closed:
+			return written, net.ErrClosed
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// writeLoop sends queued data to the socket once its delay has passed,
+// flushing what is queued when the connection is closed
+func (c *shapedConn) writeLoop() {
+	defer close(c.flushed)
+	out := pacer{rate: float64(c.cfg.WriteBandwidth)}
+	send := func(chunk shapedChunk) {
+		if c.writeErr.Load() != nil {
+			return
+		}
+		time.Sleep(time.Until(chunk.due))
+		out.wait(len(chunk.data))
+		if _, err := c.Conn.Write(chunk.data); err != nil {
+			c.writeErr.Store(&err)
+		}
+	}
+	for {
+		select {
+		case chunk := <-c.writes:
+			send(chunk)
+		case <-c.closed:
+			for {
+				select {
+				case chunk := <-c.writes:
+					send(chunk)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close sends the data still queued, then closes the socket
+func (c *shapedConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		select {
+		case <-c.flushed:
+		case <-time.After(c.cfg.Latency + c.cfg.Jitter + shapedFlushGrace):
+		}
+	})
+	return c.Conn.Close()
+}
+
+func (c *shapedConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *shapedConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(deadlineNanos(t))
+	return nil
+}
+
+func (c *shapedConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Store(deadlineNanos(t))
+	return nil
+}
+
+// shapedReadSize limits reads so bandwidth caps are applied in small steps
+func shapedReadSize(bandwidth int64) int {
+	if bandwidth <= 0 {
+		return shapedChunkSize
+	}
+	return int(min(max(bandwidth/20, 512), shapedChunkSize))
+}
+
+func deadlineNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// deadlineTimer returns a channel receiving when the deadline passes, or
+// nil if there is no deadline
+func deadlineTimer(deadline int64) (<-chan time.Time, func()) {
+	if deadline == 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Until(time.Unix(0, deadline)))
+	return timer.C, func() { timer.Stop() }
+}
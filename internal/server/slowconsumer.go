@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// pendingDeliveries tracks messages queued for a client but not yet written
+type pendingDeliveries struct {
+	mu     sync.Mutex
+	next   uint64
+	queued map[uint64]time.Time // sequence -> enqueue time
+}
+
+// add records a queued message and returns its sequence number
+func (p *pendingDeliveries) add() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.queued == nil {
+		p.queued = make(map[uint64]time.Time)
+	}
+	p.next++
+	p.queued[p.next] = time.Now()
+	return p.next
+}
+
+// done forgets a message once it has been written or dropped
+func (p *pendingDeliveries) done(seq uint64) {
+	p.mu.Lock()
+	delete(p.queued, seq)
+	p.mu.Unlock()
+}
+
+// snapshot returns the queue depth and the enqueue time of the oldest message
+func (p *pendingDeliveries) snapshot() (int, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var oldest time.Time
+	for _, t := range p.queued {
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	return len(p.queued), oldest
+}
+
+// SlowConsumer describes a client whose outbound queue has stayed above the
+// configured threshold
+type SlowConsumer struct {
+	ClientID   string    `json:"client_id"`
+	QueueDepth int       `json:"queue_depth"`
+	OldestAge  string    `json:"oldest_age"` // Age of the oldest undelivered message
+	Since      time.Time `json:"since"`      // When the queue first exceeded the threshold
+}
+
+// slowConsumerMonitor periodically checks client queues
+type slowConsumerMonitor struct {
+	cfg config.SlowConsumerConfig
+
+	mu    sync.Mutex
+	above map[*Client]time.Time // clients above the threshold -> since
+	slow  map[*Client]*SlowConsumer
+}
+
+func newSlowConsumerMonitor(cfg config.SlowConsumerConfig) *slowConsumerMonitor {
+	return &slowConsumerMonitor{
+		cfg:   cfg,
+		above: make(map[*Client]time.Time),
+		slow:  make(map[*Client]*SlowConsumer),
+	}
+}
+
+// run checks all clients every interval until ctx is cancelled
+func (m *slowConsumerMonitor) run(ctx context.Context, s *Server) {
+	ticker := time.NewTicker(m.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.check(s)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check updates the slow state of every connected client and emits events
+// on transitions
+func (m *slowConsumerMonitor) check(s *Server) {
+	s.mu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.RUnlock()
+
+	now := time.Now()
+	connected := make(map[*Client]bool, len(clients))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, client := range clients {
+		connected[client] = true
+		depth, oldest := client.pending.snapshot()
+
+		if depth <= m.cfg.QueueThreshold {
+			delete(m.above, client)
+			if sc, ok := m.slow[client]; ok {
+				delete(m.slow, client)
+				log.Printf("Client %s is no longer a slow consumer (queue depth %d, slow for %s)",
+					client.ID, depth, now.Sub(sc.Since).Round(time.Second))
+				s.emitEvent(&Event{Type: EventSlowConsumerRecovered, ClientID: client.ID, QueueDepth: depth})
+			}
+			continue
+		}
+
+		since, ok := m.above[client]
+		if !ok {
+			m.above[client] = now
+			continue
+		}
+		if now.Sub(since) < m.cfg.Duration {
+			continue
+		}
+
+		age := now.Sub(oldest)
+		sc, alreadySlow := m.slow[client]
+		if !alreadySlow {
+			sc = &SlowConsumer{ClientID: client.ID, Since: since}
+			m.slow[client] = sc
+			metrics.SlowConsumerEvents.Inc()
+			log.Printf("Slow consumer %s: %d messages queued for over %s, oldest %s old",
+				client.ID, depth, m.cfg.Duration, age.Round(time.Millisecond))
+			s.emitEvent(&Event{Type: EventSlowConsumer, ClientID: client.ID, QueueDepth: depth, OldestAge: age})
+		}
+		sc.QueueDepth = depth
+		sc.OldestAge = age.Round(time.Millisecond).String()
+	}
+
+	// Forget disconnected clients
+	for client := range m.above {
+		if !connected[client] {
+			delete(m.above, client)
+		}
+	}
+	for client := range m.slow {
+		if !connected[client] {
+			delete(m.slow, client)
+		}
+	}
+	metrics.SlowConsumers.Set(float64(len(m.slow)))
+}
+
+// SlowConsumers returns the clients currently flagged as slow consumers, or
+// nil if detection is disabled
+func (s *Server) SlowConsumers() []SlowConsumer {
+	if s.slowConsumers == nil {
+		return nil
+	}
+	m := s.slowConsumers
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]SlowConsumer, 0, len(m.slow))
+	for _, sc := range m.slow {
+		list = append(list, *sc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+	return list
+}
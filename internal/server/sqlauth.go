@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/auth"
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// openSQLAuth connects to the database holding users and ACLs. The driver
+// must have been registered by importing it into the binary; the shipped
+// broker links none.
+func openSQLAuth(cfg config.SQLAuthConfig) (*auth.SQLCredentials, error) {
+	if !slices.Contains(sql.Drivers(), cfg.Driver) {
+		return nil, fmt.Errorf("auth.sql.driver %q is not linked into this binary: build the broker with a blank import of the driver package (for example _ \"github.com/jackc/pgx/v5/stdlib\" in cmd/server)", cfg.Driver)
+	}
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open auth database: %w", err)
+	}
+	creds, err := auth.NewSQLCredentials(db, auth.SQLOptions{
+		PasswordQuery: cfg.PasswordQuery,
+		ACLQuery:      cfg.ACLQuery,
+		UsersQuery:    cfg.UsersQuery,
+		RulesQuery:    cfg.RulesQuery,
+		CacheTTL:      cfg.CacheTTL,
+		QueryTimeout:  cfg.QueryTimeout,
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return creds, nil
+}
+
+// refreshSQLAuth reloads all users from the database every interval until
+// ctx is cancelled, then closes the database
+func (s *Server) refreshSQLAuth(ctx context.Context, interval time.Duration) {
+	defer s.sqlAuth.Close()
+	if interval <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.sqlAuth.Refresh(ctx); err != nil {
+				log.Printf("Failed to refresh SQL auth users, keeping cached users: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"log"
+
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// ResetStats starts the broker's topic and traffic statistics over: topic
+// analytics, per-prefix histograms, client and group traffic counters and
+// the publish log sampler. Prometheus counters are left alone, as scrapers
+// handle their resets on restart only.
+func (s *Server) ResetStats() {
+	if s.analytics != nil {
+		s.analytics.reset()
+	}
+	if s.topicMetrics != nil {
+		s.topicMetrics.reset()
+	}
+
+	s.mu.RLock()
+	for _, client := range s.clients {
+		client.stats.messagesIn.Store(0)
+		client.stats.messagesOut.Store(0)
+		client.stats.bytesIn.Store(0)
+		client.stats.bytesOut.Store(0)
+	}
+	s.mu.RUnlock()
+
+	s.publishLog.mu.Lock()
+	clear(s.publishLog.counts)
+	s.publishLog.mu.Unlock()
+
+	log.Printf("Statistics reset")
+}
+
+// reset forgets all tracked topics and publishers
+func (a *topicAnalytics) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clear(a.lastSeen)
+	clear(a.publishers)
+	a.newTopics = 0
+	a.truncated = false
+	a.warned = false
+	a.report = &TopicReport{Window: a.cfg.Window.String()}
+	metrics.TopicCardinality.Set(0)
+	metrics.TopicGrowth.Set(0)
+}
+
+// reset clears the pending rate counts and the recorded histograms
+func (m *topicMetrics) reset() {
+	for i := range m.counts {
+		m.counts[i].Store(0)
+	}
+	metrics.TopicPayloadSize.Reset()
+	metrics.TopicMessageRate.Reset()
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// ErrStatsHistoryDisabled is returned when statistics history is queried
+// while it is disabled or the broker has no store
+var ErrStatsHistoryDisabled = errors.New("statistics history is disabled")
+
+// StatsSample is a stored snapshot of broker statistics. Traffic counts
+// cover the interval ending at Time.
+type StatsSample struct {
+	Time          time.Time `json:"time"`
+	Clients       int       `json:"clients"`
+	Sessions      int       `json:"sessions"`      // persistent sessions of disconnected clients
+	Subscriptions int       `json:"subscriptions"` // subscriptions of connected clients
+	Retained      int       `json:"retained"`
+	MemoryBytes   int64     `json:"memory_bytes"` // held by queued, retained and inflight messages
+	Connections   uint64    `json:"connections"`  // connections accepted in the interval
+	MessagesIn    uint64    `json:"messages_in"`
+	MessagesOut   uint64    `json:"messages_out"`
+	BytesIn       uint64    `json:"bytes_in"`
+	BytesOut      uint64    `json:"bytes_out"`
+}
+
+// statsHistory counts broker-wide traffic and periodically stores a
+// snapshot of the statistics, so trends survive restarts without an
+// external monitoring system
+type statsHistory struct {
+	cfg config.StatsHistoryConfig
+
+	connections atomic.Uint64
+	messagesIn  atomic.Uint64
+	messagesOut atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+}
+
+func newStatsHistory(cfg config.StatsHistoryConfig) *statsHistory {
+	return &statsHistory{cfg: cfg}
+}
+
+// run stores a sample every interval and prunes samples older than the
+// retention until ctx is cancelled
+func (h *statsHistory) run(ctx context.Context, s *Server) {
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+
+	h.prune(s)
+	for {
+		select {
+		case <-ticker.C:
+			h.sample(s)
+			h.prune(s)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sample stores the current statistics and starts the traffic counts over
+func (h *statsHistory) sample(s *Server) {
+	sample := &store.StatsSample{
+		Time:        time.Now(),
+		MemoryBytes: s.memory.total(),
+		Connections: h.connections.Swap(0),
+		MessagesIn:  h.messagesIn.Swap(0),
+		MessagesOut: h.messagesOut.Swap(0),
+		BytesIn:     h.bytesIn.Swap(0),
+		BytesOut:    h.bytesOut.Swap(0),
+	}
+
+	s.mu.RLock()
+	sample.Clients = len(s.clients)
+	for _, client := range s.clients {
+		client.mu.RLock()
+		sample.Subscriptions += len(client.Subscriptions)
+		client.mu.RUnlock()
+	}
+	s.mu.RUnlock()
+
+	s.sessionsMu.Lock()
+	sample.Sessions = len(s.sessions)
+	s.sessionsMu.Unlock()
+
+	s.retainedMsgsMu.RLock()
+	sample.Retained = len(s.retainedMsgs)
+	s.retainedMsgsMu.RUnlock()
+
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	if err := s.store.SaveStats(ctx, sample); err != nil {
+		log.Printf("Failed to store statistics sample: %v", err)
+	}
+}
+
+// prune deletes samples older than the retention
+func (h *statsHistory) prune(s *Server) {
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	pruned, err := s.store.PruneStats(ctx, time.Now().Add(-h.cfg.Retention))
+	if err != nil {
+		log.Printf("Failed to prune statistics history: %v", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d statistics samples older than %s", pruned, h.cfg.Retention)
+	}
+}
+
+// StatsHistory returns the statistics samples taken in [from, to), oldest
+// first
+func (s *Server) StatsHistory(from, to time.Time) ([]*StatsSample, error) {
+	if s.statsHistory == nil {
+		return nil, ErrStatsHistoryDisabled
+	}
+
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	stored, err := s.store.LoadStats(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]*StatsSample, 0, len(stored))
+	for _, st := range stored {
+		samples = append(samples, &StatsSample{
+			Time:          st.Time,
+			Clients:       st.Clients,
+			Sessions:      st.Sessions,
+			Subscriptions: st.Subscriptions,
+			Retained:      st.Retained,
+			MemoryBytes:   st.MemoryBytes,
+			Connections:   st.Connections,
+			MessagesIn:    st.MessagesIn,
+			MessagesOut:   st.MessagesOut,
+			BytesIn:       st.BytesIn,
+			BytesOut:      st.BytesOut,
+		})
+	}
+	return samples, nil
+}
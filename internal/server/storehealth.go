@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// Degradation policies while the store is down
+const (
+	StoreDegradedCache  = "cache"  // keep serving retained messages and sessions from memory
+	StoreDegradedReject = "reject" // also refuse new persistent sessions
+)
+
+// storeHealthMonitor periodically checks the store and remembers the result
+type storeHealthMonitor struct {
+	cfg config.StoreHealthConfig
+
+	mu   sync.RWMutex
+	last *store.Health
+}
+
+func newStoreHealthMonitor(cfg config.StoreHealthConfig) *storeHealthMonitor {
+	return &storeHealthMonitor{cfg: cfg}
+}
+
+// run checks the store every interval until ctx is cancelled
+func (m *storeHealthMonitor) run(ctx context.Context, s *Server) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	m.check(s)
+	for {
+		select {
+		case <-ticker.C:
+			m.check(s)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check runs a health check, logging and emitting events when the store
+// goes down or comes back
+func (m *storeHealthMonitor) check(s *Server) {
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	h := s.store.HealthCheck(ctx)
+
+	m.mu.Lock()
+	wasUp := m.last == nil || m.last.Up
+	m.last = h
+	m.mu.Unlock()
+
+	if h.Up {
+		metrics.StoreUp.Set(1)
+	} else {
+		metrics.StoreUp.Set(0)
+	}
+	switch {
+	case wasUp && !h.Up:
+		log.Printf("Store health check failed (degraded mode %q): %s", m.cfg.Degraded, h.Error)
+		s.emitEvent(&Event{Type: EventStoreDown, Reason: h.Error})
+	case !wasUp && h.Up:
+		log.Printf("Store health check succeeded, store is back")
+		s.emitEvent(&Event{Type: EventStoreUp})
+	}
+}
+
+// down reports whether the last health check failed
+func (m *storeHealthMonitor) down() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.last != nil && !m.last.Up
+}
+
+// rejectPersistent reports whether new persistent sessions are refused
+// because the store is down
+func (s *Server) rejectPersistent() bool {
+	return s.storeHealth != nil && s.storeHealth.cfg.Degraded == StoreDegradedReject && s.storeHealth.down()
+}
+
+// StoreHealth returns the result of the last periodic store health check,
+// or checks the store now if periodic checks are disabled. It returns nil
+// if the broker has no store.
+func (s *Server) StoreHealth() *store.Health {
+	if s.store == nil {
+		return nil
+	}
+	if s.storeHealth != nil {
+		s.storeHealth.mu.RLock()
+		last := s.storeHealth.last
+		s.storeHealth.mu.RUnlock()
+		if last != nil {
+			return last
+		}
+	}
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	return s.store.HealthCheck(ctx)
+}
+
+// storeContext bounds a store operation by storage.op_timeout
+func (s *Server) storeContext() (context.Context, context.CancelFunc) {
+	return store.OpContext(s.config.Storage.OpTimeout)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"log"
+	"path"
+
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// Reasons a subscription is rejected, reported in logs, metrics and
+// subscription_rejected events
+const (
+	SubscribeInvalidFilter = "invalid_filter"
+	SubscribeNotAuthorized = "not_authorized"
+	SubscribeQuotaExceeded = "quota_exceeded"
+)
+
+// checkSubscription returns why a client may not subscribe to an
+// (unmounted) topic filter, or "" if it may
+func (s *Server) checkSubscription(client *Client, filter string) string {
+	if !mqtt.ValidTopicFilter(filter) {
+		return SubscribeInvalidFilter
+	}
+	if !s.authorizeSubscribe(client, filter) {
+		return SubscribeNotAuthorized
+	}
+	return ""
+}
+
+// atSubscriptionLimit reports whether a client holds
+// limits.max_subscriptions_per_client filters. The caller holds client.mu.
+func (s *Server) atSubscriptionLimit(client *Client) bool {
+	limit := s.config.Limits.MaxSubscriptionsPerClient
+	return limit > 0 && len(client.Subscriptions) >= limit
+}
+
+// retainHandling returns the Retain Handling option in effect for a
+// subscription. MQTT 5 clients choose it themselves; for MQTT 3.1/3.1.1
+// clients the first retain_handling rule matching the ClientID and the
+// (unmounted) filter applies, so that devices re-subscribing after every
+// reconnect are not flooded with retained messages they already have.
+func (s *Server) retainHandling(client *Client, sub mqtt.Subscription) byte {
+	if client.ProtocolVersion == mqtt.ProtocolV5 {
+		return sub.RetainHandling
+	}
+	filter := client.unmount(sub.Topic)
+	for _, rule := range s.config.RetainHandling {
+		if matchesAny(rule.ClientIDs, client.ID, func(pattern, id string) bool {
+			ok, _ := path.Match(pattern, id)
+			return ok
+		}) && matchesAny(rule.Filters, filter, func(ruleFilter, filter string) bool {
+			return ruleFilter == filter || topicMatch(ruleFilter, filter)
+		}) {
+			return byte(rule.Mode)
+		}
+	}
+	return sub.RetainHandling
+}
+
+// matchesAny reports whether value matches one of patterns, or patterns is
+// empty
+func matchesAny(patterns []string, value string, match func(pattern, value string) bool) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// subackFailure returns the SUBACK return code for a rejected subscription:
+// a reason code for MQTT 5 clients, the generic failure code otherwise
+func subackFailure(version byte, reason string) byte {
+	if version != mqtt.ProtocolV5 {
+		return mqtt.SubackFailure
+	}
+	switch reason {
+	case SubscribeInvalidFilter:
+		return mqtt.ReasonTopicFilterInvalid
+	case SubscribeNotAuthorized:
+		return mqtt.ReasonNotAuthorized
+	case SubscribeQuotaExceeded:
+		return mqtt.ReasonQuotaExceeded
+	}
+	return mqtt.ReasonUnspecifiedError
+}
+
+// rejectSubscription reports a rejected subscription, so clients whose
+// SUBACK failure codes go unnoticed can be diagnosed from the broker side
+func (s *Server) rejectSubscription(client *Client, filter, reason string) {
+	metrics.SubscriptionsRejected.WithLabelValues(reason).Inc()
+	log.Printf("Rejected subscription of %s (user %q) to %q: %s", client.ID, client.Username, filter, reason)
+	s.tracef(client.ID, "", "subscription to %q rejected: %s", filter, reason)
+	s.emitEvent(&Event{
+		Type:     EventSubscriptionRejected,
+		ClientID: client.ID,
+		Username: client.Username,
+		Filter:   filter,
+		Reason:   reason,
+	})
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// Tap limits
+const (
+	maxTaps           = 16   // concurrent taps per broker
+	tapBuffer         = 256  // messages buffered per tap before dropping
+	maxTapRate        = 1000 // messages per second per tap
+	defaultTapPayload = 1024 // payload bytes included unless requested otherwise
+)
+
+// TapFilter selects the messages observed by a tap. Filtering and rate
+// limiting happen in the broker so a tap on a busy broker stays cheap.
+type TapFilter struct {
+	Topic      string  // Topic filter (default "#")
+	ClientID   string  // Only messages published by this client
+	Rate       float64 // Maximum messages per second (default and maximum 1000)
+	MaxPayload int     // Payload bytes included per message (default 1024)
+}
+
+// TapMessage is a routed message as observed by a tap
+type TapMessage struct {
+	Time      time.Time `json:"time"`
+	Topic     string    `json:"topic"`
+	Origin    string    `json:"origin,omitempty"` // publishing ClientID or extension
+	QoS       byte      `json:"qos"`
+	Retain    bool      `json:"retain,omitempty"`
+	Size      int       `json:"size"`                // full payload size in bytes
+	Payload   string    `json:"payload"`             // possibly truncated
+	Encoding  string    `json:"encoding,omitempty"`  // "base64" for binary payloads
+	Truncated bool      `json:"truncated,omitempty"` // payload cut to the tap's MaxPayload
+}
+
+// Tap observes routed messages without subscribing over MQTT, so it leaves
+// no trace in subscriptions, sessions or delivery statistics
+type Tap struct {
+	filter  TapFilter
+	limiter *rateLimiter
+	ch      chan *TapMessage
+	dropped atomic.Uint64
+	server  *Server
+	once    sync.Once
+}
+
+// C returns the channel delivering tapped messages. It is closed when the
+// tap is closed.
+func (t *Tap) C() <-chan *TapMessage {
+	return t.ch
+}
+
+// Dropped returns the number of matching messages skipped because of the
+// rate limit or a slow reader
+func (t *Tap) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+// Close stops the tap
+func (t *Tap) Close() {
+	t.once.Do(func() {
+		t.server.tapsMu.Lock()
+		delete(t.server.taps, t)
+		t.server.tapCount.Add(-1)
+		close(t.ch)
+		t.server.tapsMu.Unlock()
+	})
+}
+
+// OpenTap starts observing routed messages matching the filter
+func (s *Server) OpenTap(filter TapFilter) (*Tap, error) {
+	if filter.Topic == "" {
+		filter.Topic = "#"
+	}
+	if !mqtt.ValidTopicFilter(filter.Topic) {
+		return nil, fmt.Errorf("invalid topic filter: %q", filter.Topic)
+	}
+	if filter.Rate <= 0 || filter.Rate > maxTapRate {
+		filter.Rate = maxTapRate
+	}
+	if filter.MaxPayload <= 0 {
+		filter.MaxPayload = defaultTapPayload
+	}
+
+	t := &Tap{
+		filter:  filter,
+		limiter: newRateLimiter(filter.Rate, int(filter.Rate)+1),
+		ch:      make(chan *TapMessage, tapBuffer),
+		server:  s,
+	}
+
+	s.tapsMu.Lock()
+	defer s.tapsMu.Unlock()
+	if len(s.taps) >= maxTaps {
+		return nil, fmt.Errorf("too many open taps (maximum %d)", maxTaps)
+	}
+	if s.taps == nil {
+		s.taps = make(map[*Tap]struct{})
+	}
+	s.taps[t] = struct{}{}
+	s.tapCount.Add(1)
+	return t, nil
+}
+
+// runTaps hands a routed message to the matching taps. It never blocks:
+// messages a tap cannot take are counted as dropped.
+func (s *Server) runTaps(pub *mqtt.PublishPacket, origin string) {
+	if s.tapCount.Load() == 0 {
+		return
+	}
+
+	s.tapsMu.Lock()
+	defer s.tapsMu.Unlock()
+	for t := range s.taps {
+		if t.filter.ClientID != "" && t.filter.ClientID != origin {
+			continue
+		}
+		if !topicMatch(t.filter.Topic, pub.Topic) {
+			continue
+		}
+		if !t.limiter.Allow() {
+			t.dropped.Add(1)
+			continue
+		}
+		select {
+		case t.ch <- newTapMessage(pub, origin, t.filter.MaxPayload):
+		default:
+			t.dropped.Add(1)
+		}
+	}
+}
+
+func newTapMessage(pub *mqtt.PublishPacket, origin string, maxPayload int) *TapMessage {
+	msg := &TapMessage{
+		Time:   time.Now(),
+		Topic:  pub.Topic,
+		Origin: origin,
+		QoS:    pub.QoS,
+		Retain: pub.Retain,
+		Size:   len(pub.Payload),
+	}
+	payload := pub.Payload
+	text := utf8.Valid(payload)
+	if len(payload) > maxPayload {
+		payload, msg.Truncated = payload[:maxPayload], true
+		for text && !utf8.Valid(payload) {
+			payload = payload[:len(payload)-1] // do not split the last character
+		}
+	}
+	if text {
+		msg.Payload = string(payload)
+	} else {
+		msg.Payload, msg.Encoding = base64.StdEncoding.EncodeToString(payload), "base64"
+	}
+	return msg
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// applyTCPOptions tunes an accepted connection according to the listener's
+// TCP settings. Connections that are not TCP (e.g. in-memory pipes) are left
+// untouched.
+func applyTCPOptions(conn net.Conn, opts config.TCPConfig) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if opts.NoDelay != nil {
+		if err := tcpConn.SetNoDelay(*opts.NoDelay); err != nil {
+			return fmt.Errorf("failed to set TCP_NODELAY: %w", err)
+		}
+	}
+
+	// Negative period disables keepalive probes, zero keeps the OS default
+	if opts.KeepAlivePeriod < 0 {
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			return fmt.Errorf("failed to disable SO_KEEPALIVE: %w", err)
+		}
+	} else if opts.KeepAlivePeriod > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			return fmt.Errorf("failed to enable SO_KEEPALIVE: %w", err)
+		}
+		if err := tcpConn.SetKeepAlivePeriod(opts.KeepAlivePeriod); err != nil {
+			return fmt.Errorf("failed to set keepalive period: %w", err)
+		}
+	}
+
+	if opts.ReadBufferSize > 0 {
+		if err := tcpConn.SetReadBuffer(opts.ReadBufferSize); err != nil {
+			return fmt.Errorf("failed to set SO_RCVBUF: %w", err)
+		}
+	}
+	if opts.WriteBufferSize > 0 {
+		if err := tcpConn.SetWriteBuffer(opts.WriteBufferSize); err != nil {
+			return fmt.Errorf("failed to set SO_SNDBUF: %w", err)
+		}
+	}
+
+	return nil
+}
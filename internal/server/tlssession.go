@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// configureSessionTickets sets up TLS session resumption on the listener's
+// configuration. Without a key file Go generates and rotates ticket keys
+// itself, which only lets clients resume on the instance that issued their
+// ticket.
+func configureSessionTickets(tlsConfig *tls.Config, cfg config.TLSSessionConfig) error {
+	if cfg.Disabled {
+		tlsConfig.SessionTicketsDisabled = true
+		return nil
+	}
+	if cfg.KeyFile == "" {
+		return nil
+	}
+	keys, err := loadTicketKeys(cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig.SetSessionTicketKeys(keys)
+	return nil
+}
+
+// loadTicketKeys reads session ticket keys, 32 hex encoded bytes per line.
+// The first key encrypts new tickets; all of them decrypt tickets, so a
+// key can be rotated in on every instance before it is used. Blank lines
+// and lines starting with '#' are ignored.
+func loadTicketKeys(path string) ([][32]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ticket key file: %w", err)
+	}
+	defer f.Close()
+
+	var keys [][32]byte
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		raw, err := hex.DecodeString(text)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("%s:%d: expected a 32 byte key in hex", path, line)
+		}
+		keys = append(keys, [32]byte(raw))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ticket key file: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in ticket key file %s", path)
+	}
+	return keys, nil
+}
+
+// ReloadTicketKeys reloads the shared session ticket keys of the TLS
+// listener, for example after a key rotation. Tickets encrypted with keys
+// no longer in the file stop resuming sessions.
+func (s *Server) ReloadTicketKeys(cfg config.TLSSessionConfig) error {
+	tlsConfig := s.tlsConfig.Load()
+	if tlsConfig == nil || cfg.Disabled || cfg.KeyFile == "" {
+		return nil
+	}
+	keys, err := loadTicketKeys(cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig.SetSessionTicketKeys(keys)
+	log.Printf("Reloaded %d session ticket keys from %s", len(keys), cfg.KeyFile)
+	return nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// topicMetrics records payload size and message rate histograms for the
+// configured topic prefixes, so per-subsystem bandwidth can be tracked in
+// Prometheus
+type topicMetrics struct {
+	prefixes []string        // longest first
+	counts   []atomic.Uint64 // messages per prefix since the last rate sample
+	interval time.Duration
+}
+
+func newTopicMetrics(cfg config.TopicMetricsConfig) *topicMetrics {
+	prefixes := append([]string(nil), cfg.Prefixes...)
+	sort.SliceStable(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	return &topicMetrics{
+		prefixes: prefixes,
+		counts:   make([]atomic.Uint64, len(prefixes)),
+		interval: cfg.RateInterval,
+	}
+}
+
+// record accounts a routed message under the longest matching prefix.
+// Messages matching no prefix are not recorded.
+func (m *topicMetrics) record(topic string, size int) {
+	for i, prefix := range m.prefixes {
+		if strings.HasPrefix(topic, prefix) {
+			m.counts[i].Add(1)
+			metrics.TopicPayloadSize.WithLabelValues(prefix).Observe(float64(size))
+			return
+		}
+	}
+}
+
+// run samples the message rate of every prefix each interval until ctx is
+// cancelled
+func (m *topicMetrics) run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for i, prefix := range m.prefixes {
+				rate := float64(m.counts[i].Swap(0)) / m.interval.Seconds()
+				metrics.TopicMessageRate.WithLabelValues(prefix).Observe(rate)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/clock"
+)
+
+// maxTraceDump limits the bytes of a packet included in a trace line
+const maxTraceDump = 256
+
+// maxTraceTTL bounds how long a trace may stay enabled
+const maxTraceTTL = 24 * time.Hour
+
+// ErrTraceNotFound is returned when a trace ID is unknown or has expired
+var ErrTraceNotFound = errors.New("trace not found")
+
+// TraceRule selects the traffic logged at packet level by a trace. A rule
+// with both fields set only matches that client's messages on the topics.
+type TraceRule struct {
+	ID       string    `json:"id"`
+	ClientID string    `json:"client_id,omitempty"` // Trace packets sent and received by this client
+	Topic    string    `json:"topic,omitempty"`     // Trace messages matching this topic filter
+	Expires  time.Time `json:"expires"`
+}
+
+// matches reports whether a packet of clientID on topic (empty for packets
+// without a topic) is selected by the rule
+func (r *TraceRule) matches(clientID, topic string) bool {
+	if r.ClientID != "" && r.ClientID != clientID {
+		return false
+	}
+	if r.Topic != "" && (topic == "" || !topicMatch(r.Topic, topic)) {
+		return false
+	}
+	return true
+}
+
+// tracer holds the active trace rules. Tracing is off in the common case, so
+// the hot path only checks an atomic counter.
+type tracer struct {
+	mu     sync.RWMutex
+	rules  map[string]*TraceRule
+	timers map[string]clock.Timer
+	active atomic.Int32
+	nextID atomic.Uint64
+}
+
+func newTracer() *tracer {
+	return &tracer{
+		rules:  make(map[string]*TraceRule),
+		timers: make(map[string]clock.Timer),
+	}
+}
+
+// match returns the ID of the first rule selecting the packet, or ""
+func (t *tracer) match(clientID, topic string) string {
+	if t.active.Load() == 0 {
+		return ""
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for id, r := range t.rules {
+		if r.matches(clientID, topic) {
+			return id
+		}
+	}
+	return ""
+}
+
+// tracef logs a trace line if a rule selects the packet
+func (s *Server) tracef(clientID, topic, format string, args ...interface{}) {
+	id := s.tracer.match(clientID, topic)
+	if id == "" {
+		return
+	}
+	log.Printf("TRACE[%s] %s: %s", id, clientID, fmt.Sprintf(format, args...))
+}
+
+// traceDump formats packet bytes for a trace line
+func traceDump(data []byte) string {
+	if len(data) > maxTraceDump {
+		return fmt.Sprintf("% x ... (%d bytes)", data[:maxTraceDump], len(data))
+	}
+	return fmt.Sprintf("% x", data)
+}
+
+// StartTrace enables packet-level logging for a client, a topic filter or
+// both until ttl elapses
+func (s *Server) StartTrace(clientID, topic string, ttl time.Duration) (*TraceRule, error) {
+	if clientID == "" && topic == "" {
+		return nil, fmt.Errorf("trace needs a client ID or a topic filter")
+	}
+	if ttl <= 0 || ttl > maxTraceTTL {
+		return nil, fmt.Errorf("invalid trace ttl: %s (must be positive and at most %s)", ttl, maxTraceTTL)
+	}
+
+	t := s.tracer
+	rule := &TraceRule{
+		ID:       strconv.FormatUint(t.nextID.Add(1), 10),
+		ClientID: clientID,
+		Topic:    topic,
+		Expires:  s.clock.Now().Add(ttl),
+	}
+
+	t.mu.Lock()
+	t.rules[rule.ID] = rule
+	t.timers[rule.ID] = s.clock.AfterFunc(ttl, func() {
+		if s.StopTrace(rule.ID) == nil {
+			log.Printf("Trace %s expired", rule.ID)
+		}
+	})
+	t.active.Add(1)
+	t.mu.Unlock()
+
+	log.Printf("Trace %s started (client=%q topic=%q) until %s", rule.ID, clientID, topic, rule.Expires.Format(time.RFC3339))
+	return rule, nil
+}
+
+// StopTrace disables a trace before its TTL elapses
+func (s *Server) StopTrace(id string) error {
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.rules[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrTraceNotFound, id)
+	}
+	t.timers[id].Stop()
+	delete(t.rules, id)
+	delete(t.timers, id)
+	t.active.Add(-1)
+	return nil
+}
+
+// ExtendTrace changes the TTL of an active trace, counted from now
+func (s *Server) ExtendTrace(id string, ttl time.Duration) (*TraceRule, error) {
+	if ttl <= 0 || ttl > maxTraceTTL {
+		return nil, fmt.Errorf("invalid trace ttl: %s (must be positive and at most %s)", ttl, maxTraceTTL)
+	}
+
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rule, ok := t.rules[id]
+	if !ok || !t.timers[id].Stop() {
+		// An expired trace is removed as soon as the lock is released
+		return nil, fmt.Errorf("%w: %s", ErrTraceNotFound, id)
+	}
+	t.timers[id].Reset(ttl)
+	rule.Expires = s.clock.Now().Add(ttl)
+	log.Printf("Trace %s extended until %s", id, rule.Expires.Format(time.RFC3339))
+	updated := *rule
+	return &updated, nil
+}
+
+// Traces returns the active traces ordered by ID
+func (s *Server) Traces() []TraceRule {
+	t := s.tracer
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	traces := make([]TraceRule, 0, len(t.rules))
+	for _, r := range t.rules {
+		traces = append(traces, *r)
+	}
+	sort.Slice(traces, func(i, j int) bool {
+		a, _ := strconv.ParseUint(traces[i].ID, 10, 64)
+		b, _ := strconv.ParseUint(traces[j].ID, 10, 64)
+		return a < b
+	})
+	return traces
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"log"
+	"path"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/transcode"
+)
+
+// transcodingRule returns the first transcoding rule declaring the format
+// a client speaks on a topic, or nil
+func (s *Server) transcodingRule(client *Client, topic string) *config.TranscodingRuleConfig {
+	for i, rule := range s.config.Transcoding {
+		if !topicMatch(rule.Filter, topic) {
+			continue
+		}
+		for _, pattern := range rule.ClientIDs {
+			if ok, _ := path.Match(pattern, client.ID); ok {
+				return &s.config.Transcoding[i]
+			}
+		}
+	}
+	return nil
+}
+
+// decodeTranscoded converts a message published in a binary format by a
+// client covered by a transcoding rule to JSON, and reports whether the
+// message may be routed. MQTT 5 messages with a JSON Content Type and
+// empty payloads are left alone.
+func (s *Server) decodeTranscoded(client *Client, pub *mqtt.PublishPacket) bool {
+	rule := s.transcodingRule(client, pub.Topic)
+	if rule == nil || len(pub.Payload) == 0 {
+		return true
+	}
+	if contentType, ok := pub.Properties.StringValue(mqtt.PropContentType); ok && normalizeContentType(contentType) == "application/json" {
+		return true
+	}
+	data, err := transcode.ToJSON(rule.Format, pub.Payload)
+	if err != nil {
+		metrics.TranscodingFailures.WithLabelValues(rule.Format, "decode").Inc()
+		log.Printf("Rejecting message from %s on %s: invalid %s payload: %v", client.ID, pub.Topic, rule.Format, err)
+		return false
+	}
+	metrics.TranscodedMessages.WithLabelValues(rule.Format, "decode").Inc()
+	pub.Payload = data
+	pub.Properties = append(pub.Properties.Without(mqtt.PropContentType),
+		mqtt.StringProperty(mqtt.PropContentType, "application/json"))
+	return true
+}
+
+// encodeTranscoded converts a JSON delivery to the binary format of a
+// subscriber covered by a transcoding rule. MQTT 5 subscribers get the
+// format's Content Type. Payloads that are not JSON are delivered as they
+// are.
+func (s *Server) encodeTranscoded(client *Client, delivery *mqtt.PublishPacket) {
+	rule := s.transcodingRule(client, delivery.Topic)
+	if rule == nil || len(delivery.Payload) == 0 {
+		return
+	}
+	data, err := transcode.FromJSON(rule.Format, delivery.Payload)
+	if err != nil {
+		metrics.TranscodingFailures.WithLabelValues(rule.Format, "encode").Inc()
+		return
+	}
+	metrics.TranscodedMessages.WithLabelValues(rule.Format, "encode").Inc()
+	delivery.Payload = data
+	if client.ProtocolVersion == mqtt.ProtocolV5 {
+		delivery.Properties = append(delivery.Properties.Without(mqtt.PropContentType),
+			mqtt.StringProperty(mqtt.PropContentType, transcode.ContentType(rule.Format)))
+	}
+}
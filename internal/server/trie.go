@@ -0,0 +1,110 @@
+package server
+
+import (
+	"strings"
+	"sync"
+)
+
+// subscriptionTrie indexes the subscriptions of connected clients by topic
+// level, so a publish is matched against the filters along its own levels
+// instead of against every subscription of every client
+type subscriptionTrie struct {
+	mu   sync.RWMutex
+	root *trieNode
+}
+
+// trieNode is one level of a topic filter. The + and # wildcards are
+// children like any other level.
+type trieNode struct {
+	filter   string               // the filter ending at this node
+	children map[string]*trieNode // level -> node
+	clients  map[*Client]struct{} // clients subscribed to filter
+}
+
+func newSubscriptionTrie() *subscriptionTrie {
+	return &subscriptionTrie{root: &trieNode{}}
+}
+
+// add subscribes client to filter
+func (t *subscriptionTrie) add(client *Client, filter string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node := t.root
+	for _, level := range splitTopic(filter) {
+		child := node.children[level]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode)
+			}
+			child = &trieNode{}
+			node.children[level] = child
+		}
+		node = child
+	}
+	if node.clients == nil {
+		node.filter = filter
+		node.clients = make(map[*Client]struct{})
+	}
+	node.clients[client] = struct{}{}
+}
+
+// remove unsubscribes client from filter, pruning the levels no
+// subscription needs any more
+func (t *subscriptionTrie) remove(client *Client, filter string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root.remove(client, filter, splitTopic(filter))
+}
+
+// remove reports whether the node is left empty
+func (n *trieNode) remove(client *Client, filter string, levels []string) bool {
+	if len(levels) == 0 {
+		if n.filter == filter {
+			delete(n.clients, client)
+			if len(n.clients) == 0 {
+				n.clients = nil
+			}
+		}
+	} else if child := n.children[levels[0]]; child != nil && child.remove(client, filter, levels[1:]) {
+		delete(n.children, levels[0])
+	}
+	return len(n.clients) == 0 && len(n.children) == 0
+}
+
+// match returns the clients with a subscription matching topic, with the
+// filters that match it
+func (t *subscriptionTrie) match(topic string) map[*Client][]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	matches := make(map[*Client][]string)
+	// Broker topics such as $SYS/... are not matched by a leading wildcard
+	t.root.match(splitTopic(topic), !strings.HasPrefix(topic, "$"), matches)
+	return matches
+}
+
+func (n *trieNode) match(levels []string, wildcards bool, matches map[*Client][]string) {
+	if wildcards {
+		// # also matches the parent level
+		if child := n.children["#"]; child != nil {
+			child.collect(matches)
+		}
+	}
+	if len(levels) == 0 {
+		n.collect(matches)
+		return
+	}
+	if child := n.children[levels[0]]; child != nil {
+		child.match(levels[1:], true, matches)
+	}
+	if wildcards && levels[0] != "+" {
+		if child := n.children["+"]; child != nil {
+			child.match(levels[1:], true, matches)
+		}
+	}
+}
+
+func (n *trieNode) collect(matches map[*Client][]string) {
+	for client := range n.clients {
+		matches[client] = append(matches[client], n.filter)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+)
+
+// UsageReport is a tenant's or user's usage of the current day (UTC)
+type UsageReport struct {
+	Key          string `json:"key"` // tenant server name or username; "" for anonymous clients
+	Day          string `json:"day"`
+	Messages     int64  `json:"messages"`
+	Bytes        int64  `json:"bytes"`
+	MessageQuota int64  `json:"message_quota,omitempty"`
+	ByteQuota    int64  `json:"byte_quota,omitempty"`
+	Exceeded     bool   `json:"exceeded"`
+}
+
+// quotaEvent is the JSON payload of a quota event
+type quotaEvent struct {
+	Event    string    `json:"event"`
+	Per      string    `json:"per"`   // username or tenant
+	Key      string    `json:"key"`   // the tenant or username
+	Quota    string    `json:"quota"` // messages or bytes
+	Limit    int64     `json:"limit"`
+	Messages int64     `json:"messages"`
+	Bytes    int64     `json:"bytes"`
+	Policy   string    `json:"policy"`
+	ClientID string    `json:"client_id"` // publisher of the message over quota
+	Time     time.Time `json:"time"`
+}
+
+// usageTracker counts the messages and bytes published per tenant or user
+// and day
+type usageTracker struct {
+	cfg       config.UsageQuotaConfig
+	overrides map[string]config.UsageQuotaOverride
+
+	mu    sync.Mutex
+	day   string
+	usage map[string]*usage
+}
+
+// usage is a tenant's or user's count for the day
+type usage struct {
+	messages int64
+	bytes    int64
+	exceeded bool
+	throttle *rateLimiter // over quota with the throttle policy
+}
+
+func newUsageTracker(cfg config.UsageQuotaConfig) *usageTracker {
+	t := &usageTracker{
+		cfg:       cfg,
+		overrides: make(map[string]config.UsageQuotaOverride),
+		usage:     make(map[string]*usage),
+	}
+	for _, o := range cfg.Overrides {
+		t.overrides[o.Name] = o
+	}
+	return t
+}
+
+// quotas returns the daily message and byte quotas of a key
+func (t *usageTracker) quotas(key string) (int64, int64) {
+	if o, ok := t.overrides[key]; ok {
+		return o.Messages, o.Bytes
+	}
+	return t.cfg.Messages, t.cfg.Bytes
+}
+
+// charge counts a publish of size payload bytes at now against a key's
+// quotas and reports whether it is admitted. Publishes over quota are
+// rejected, or admitted at the throttle rate. The first publish over quota
+// of the day returns the event to publish.
+func (t *usageTracker) charge(key string, size int, clientID string, now time.Time) (bool, *quotaEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now = now.UTC()
+	if day := now.Format(time.DateOnly); day != t.day {
+		t.day = day
+		clear(t.usage)
+	}
+	u := t.usage[key]
+	if u == nil {
+		u = &usage{}
+		t.usage[key] = u
+	}
+
+	var event *quotaEvent
+	if !u.exceeded {
+		messageQuota, byteQuota := t.quotas(key)
+		quota, limit := "", int64(0)
+		switch {
+		case messageQuota > 0 && u.messages+1 > messageQuota:
+			quota, limit = "messages", messageQuota
+		case byteQuota > 0 && u.bytes+int64(size) > byteQuota:
+			quota, limit = "bytes", byteQuota
+		}
+		if quota != "" {
+			u.exceeded = true
+			if t.cfg.Policy == "throttle" {
+				u.throttle = newRateLimiter(t.cfg.ThrottleRate, 1)
+			}
+			event = &quotaEvent{
+				Event:    "quota_exceeded",
+				Per:      t.cfg.Per,
+				Key:      key,
+				Quota:    quota,
+				Limit:    limit,
+				Messages: u.messages,
+				Bytes:    u.bytes,
+				Policy:   t.cfg.Policy,
+				ClientID: clientID,
+				Time:     now,
+			}
+		}
+	}
+	if u.exceeded && (u.throttle == nil || !u.throttle.Allow()) {
+		return false, event
+	}
+	u.messages++
+	u.bytes += int64(size)
+	return true, event
+}
+
+// reports returns the usage of the day of now
+func (t *usageTracker) reports(now time.Time) []UsageReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reports := []UsageReport{}
+	if t.day != now.UTC().Format(time.DateOnly) {
+		return reports
+	}
+	for key, u := range t.usage {
+		messageQuota, byteQuota := t.quotas(key)
+		reports = append(reports, UsageReport{
+			Key:          key,
+			Day:          t.day,
+			Messages:     u.messages,
+			Bytes:        u.bytes,
+			MessageQuota: messageQuota,
+			ByteQuota:    byteQuota,
+			Exceeded:     u.exceeded,
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Key < reports[j].Key })
+	return reports
+}
+
+// Usage returns the daily usage of every tenant or user that published
+// today, or nil if usage quotas are disabled
+func (s *Server) Usage() []UsageReport {
+	if s.usage == nil {
+		return nil
+	}
+	return s.usage.reports(s.clock.Now())
+}
+
+// usageKey returns what a client's usage is counted by
+func (s *Server) usageKey(client *Client) string {
+	if s.usage.cfg.Per == "tenant" && client.vhost != nil {
+		return client.vhost.ServerName
+	}
+	return client.Username
+}
+
+// admitUsage charges a client's publish against the daily quotas of its
+// tenant or user, publishing a quota event when a quota is first exceeded
+func (s *Server) admitUsage(client *Client, pub *mqtt.PublishPacket) bool {
+	if s.usage == nil {
+		return true
+	}
+	key := s.usageKey(client)
+	ok, event := s.usage.charge(key, len(pub.Payload), client.ID, s.clock.Now())
+	if event != nil {
+		log.Printf("Daily %s quota of %d exceeded by %s %q (%s)", event.Quota, event.Limit, event.Per, key, event.Policy)
+		s.emitEvent(&Event{
+			Type:     EventQuotaExceeded,
+			ClientID: client.ID,
+			Username: client.Username,
+			Reason:   fmt.Sprintf("daily %s quota of %d exceeded by %s %q", event.Quota, event.Limit, event.Per, key),
+		})
+		s.publishQuotaEvent(event)
+	}
+	if !ok {
+		metrics.UsageQuotaRejected.Inc()
+		log.Printf("Daily quota exceeded for %s, dropping message from %s on topic %s", key, client.ID, pub.Topic)
+	}
+	return ok
+}
+
+// publishQuotaEvent publishes a quota event on the configured topic
+func (s *Server) publishQuotaEvent(event *quotaEvent) {
+	template := s.usage.cfg.EventTopic
+	if template == "" {
+		return
+	}
+	topic := strings.ReplaceAll(template, "%k", event.Key)
+	if !mqtt.ValidTopicName(topic) {
+		log.Printf("Skipping quota event of %q: invalid topic %q", event.Key, topic)
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode quota event of %q: %v", event.Key, err)
+		return
+	}
+	s.publishMessage(&mqtt.PublishPacket{Topic: topic, Payload: payload, QoS: 1}, "")
+}
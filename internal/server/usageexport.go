@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// TenantUsage summarizes a tenant's usage over an export period. Tenants
+// are the virtual hosts; the default host is the tenant "".
+type TenantUsage struct {
+	Tenant           string    `json:"tenant"`
+	PeriodStart      time.Time `json:"period_start"`
+	PeriodEnd        time.Time `json:"period_end"`
+	Connections      int64     `json:"connections"`       // connections accepted in the period
+	ConnectedClients int       `json:"connected_clients"` // clients connected at the end of the period
+	MessagesIn       int64     `json:"messages_in"`       // messages published by the tenant's clients
+	BytesIn          int64     `json:"bytes_in"`          // payload bytes published
+	MessagesOut      int64     `json:"messages_out"`      // messages delivered to the tenant's clients
+	BytesOut         int64     `json:"bytes_out"`         // bytes delivered
+	RetainedTopics   int       `json:"retained_topics"`   // retained topics under the mountpoint at the end of the period
+	RetainedBytes    int64     `json:"retained_bytes"`    // payload bytes of the retained topics
+}
+
+// usageCSVHeader is the header row of CSV summaries
+var usageCSVHeader = []string{
+	"tenant", "period_start", "period_end", "connections", "connected_clients",
+	"messages_in", "bytes_in", "messages_out", "bytes_out", "retained_topics", "retained_bytes",
+}
+
+// usageExporter counts per-tenant traffic and periodically exports it
+type usageExporter struct {
+	cfg    config.UsageExportConfig
+	client *http.Client
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]*tenantCounts
+}
+
+// tenantCounts is a tenant's traffic in the current period
+type tenantCounts struct {
+	connections int64
+	messagesIn  int64
+	bytesIn     int64
+	messagesOut int64
+	bytesOut    int64
+}
+
+func newUsageExporter(cfg config.UsageExportConfig) *usageExporter {
+	return &usageExporter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		start:  time.Now().UTC(),
+		counts: make(map[string]*tenantCounts),
+	}
+}
+
+// record updates a tenant's counts
+func (e *usageExporter) record(tenant string, update func(c *tenantCounts)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := e.counts[tenant]
+	if c == nil {
+		c = &tenantCounts{}
+		e.counts[tenant] = c
+	}
+	update(c)
+}
+
+// tenantName returns the tenant a client's usage is billed to
+func tenantName(client *Client) string {
+	if client.vhost != nil {
+		return client.vhost.ServerName
+	}
+	return ""
+}
+
+// recordConnection counts a connection accepted from a client
+func (s *Server) recordConnection(client *Client) {
+	if s.statsHistory != nil {
+		s.statsHistory.connections.Add(1)
+	}
+	if s.usageExport != nil {
+		s.usageExport.record(tenantName(client), func(c *tenantCounts) { c.connections++ })
+	}
+}
+
+// recordPublished counts a message accepted from a client
+func (s *Server) recordPublished(client *Client, size int) {
+	if s.statsHistory != nil {
+		s.statsHistory.messagesIn.Add(1)
+		s.statsHistory.bytesIn.Add(uint64(size))
+	}
+	if s.usageExport != nil {
+		s.usageExport.record(tenantName(client), func(c *tenantCounts) {
+			c.messagesIn++
+			c.bytesIn += int64(size)
+		})
+	}
+}
+
+// recordDelivered counts a PUBLISH packet of n bytes written to a client
+func (s *Server) recordDelivered(client *Client, n int) {
+	if s.statsHistory != nil {
+		s.statsHistory.messagesOut.Add(1)
+		s.statsHistory.bytesOut.Add(uint64(n))
+	}
+	if s.usageExport != nil {
+		s.usageExport.record(tenantName(client), func(c *tenantCounts) {
+			c.messagesOut++
+			c.bytesOut += int64(n)
+		})
+	}
+}
+
+// run exports a summary at the end of every period until ctx is
+// cancelled, and a final one for the partial period at shutdown
+func (e *usageExporter) run(ctx context.Context, s *Server) {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.export(ctx, s.closeUsagePeriod())
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+			e.export(ctx, s.closeUsagePeriod())
+			cancel()
+			return
+		}
+	}
+}
+
+// closeUsagePeriod ends the current period and returns its summary, with a
+// row for every tenant
+func (s *Server) closeUsagePeriod() []TenantUsage {
+	e := s.usageExport
+	end := time.Now().UTC()
+	e.mu.Lock()
+	start, counts := e.start, e.counts
+	e.start, e.counts = end, make(map[string]*tenantCounts)
+	e.mu.Unlock()
+
+	tenants := map[string]*TenantUsage{"": {Tenant: ""}}
+	for _, vh := range s.vhosts {
+		tenants[vh.ServerName] = &TenantUsage{Tenant: vh.ServerName}
+	}
+	for name, c := range counts {
+		if tenants[name] == nil {
+			tenants[name] = &TenantUsage{Tenant: name}
+		}
+		t := tenants[name]
+		t.Connections = c.connections
+		t.MessagesIn, t.BytesIn = c.messagesIn, c.bytesIn
+		t.MessagesOut, t.BytesOut = c.messagesOut, c.bytesOut
+	}
+
+	s.mu.RLock()
+	for _, client := range s.clients {
+		if t := tenants[tenantName(client)]; t != nil {
+			t.ConnectedClients++
+		}
+	}
+	s.mu.RUnlock()
+
+	s.retainedMsgsMu.RLock()
+	for topic, msg := range s.retainedMsgs {
+		if strings.HasPrefix(topic, "$") {
+			continue // broker topics are not billed
+		}
+		name := ""
+		if vh := s.mountTenant(topic); vh != nil {
+			name = vh.ServerName
+		}
+		if t := tenants[name]; t != nil {
+			t.RetainedTopics++
+			t.RetainedBytes += int64(len(msg.Payload))
+		}
+	}
+	s.retainedMsgsMu.RUnlock()
+
+	summary := make([]TenantUsage, 0, len(tenants))
+	for _, t := range tenants {
+		t.PeriodStart, t.PeriodEnd = start, end
+		summary = append(summary, *t)
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Tenant < summary[j].Tenant })
+	return summary
+}
+
+// mountTenant returns the virtual host with the longest mountpoint holding
+// topic, or nil
+func (s *Server) mountTenant(topic string) *virtualHost {
+	var tenant *virtualHost
+	for _, vh := range s.vhosts {
+		if vh.Mountpoint != "" && strings.HasPrefix(topic, vh.Mountpoint) &&
+			(tenant == nil || len(vh.Mountpoint) > len(tenant.Mountpoint)) {
+			tenant = vh
+		}
+	}
+	return tenant
+}
+
+// export writes a summary to the configured directory and uploads it to
+// the configured URL. Failures are logged; the summary is not retried.
+func (e *usageExporter) export(ctx context.Context, summary []TenantUsage) {
+	data, err := e.encode(summary)
+	if err != nil {
+		log.Printf("Failed to encode usage summary: %v", err)
+		return
+	}
+	name := "usage-" + summary[0].PeriodStart.Format("20060102T150405Z") + "." + e.cfg.Format
+
+	if e.cfg.Directory != "" {
+		if err := os.MkdirAll(e.cfg.Directory, 0o755); err != nil {
+			log.Printf("Failed to create usage export directory: %v", err)
+		} else if err := writeFileAtomic(filepath.Join(e.cfg.Directory, name), data); err != nil {
+			log.Printf("Failed to write usage summary: %v", err)
+		} else {
+			log.Printf("Wrote usage summary %s", name)
+		}
+	}
+	if e.cfg.URL != "" {
+		if err := e.upload(ctx, name, data); err != nil {
+			log.Printf("Failed to upload usage summary %s: %v", name, err)
+		} else {
+			log.Printf("Uploaded usage summary %s", name)
+		}
+	}
+}
+
+// encode formats a summary as JSON or CSV
+func (e *usageExporter) encode(summary []TenantUsage) ([]byte, error) {
+	if e.cfg.Format == "json" {
+		return json.MarshalIndent(summary, "", "  ")
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(usageCSVHeader)
+	for _, t := range summary {
+		w.Write([]string{
+			t.Tenant,
+			t.PeriodStart.Format(time.RFC3339),
+			t.PeriodEnd.Format(time.RFC3339),
+			strconv.FormatInt(t.Connections, 10),
+			strconv.Itoa(t.ConnectedClients),
+			strconv.FormatInt(t.MessagesIn, 10),
+			strconv.FormatInt(t.BytesIn, 10),
+			strconv.FormatInt(t.MessagesOut, 10),
+			strconv.FormatInt(t.BytesOut, 10),
+			strconv.Itoa(t.RetainedTopics),
+			strconv.FormatInt(t.RetainedBytes, 10),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// upload stores a summary with HTTP PUT to <url>/<name>
+func (e *usageExporter) upload(ctx context.Context, name string, data []byte) error {
+	url := strings.TrimSuffix(e.cfg.URL, "/") + "/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if e.cfg.Format == "json" {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "text/csv")
+	}
+	if e.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.cfg.Token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory, so readers never see a partial summary
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".usage-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+)
+
+// virtualHost is a tenant selected by the TLS SNI server name. Each virtual
+// host has its own certificate, topic mountpoint and connection limit.
+type virtualHost struct {
+	config.VirtualHostConfig
+	cert    *tls.Certificate
+	clients atomic.Int64 // currently connected clients
+}
+
+// newVirtualHosts loads the certificates of all configured virtual hosts,
+// keyed by lower-cased server name
+func newVirtualHosts(cfgs []config.VirtualHostConfig) (map[string]*virtualHost, error) {
+	vhosts := make(map[string]*virtualHost, len(cfgs))
+	for _, vc := range cfgs {
+		vh := &virtualHost{VirtualHostConfig: vc}
+		if vc.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(vc.CertFile, vc.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load certificate for virtual host %s: %w", vc.ServerName, err)
+			}
+			vh.cert = &cert
+		}
+		vhosts[strings.ToLower(vc.ServerName)] = vh
+	}
+	return vhosts, nil
+}
+
+// buildTLSConfig creates the TLS configuration for the TLS listeners,
+// serving each virtual host's certificate based on SNI and the default
+// certificate otherwise. With a ca_file, client certificates are verified
+// against it, and required with auth.require_client_certs.
+func (s *Server) buildTLSConfig() (*tls.Config, error) {
+	defaultCert, err := tls.LoadX509KeyPair(s.config.TLS.CertFile, s.config.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if vh := s.vhosts[strings.ToLower(hello.ServerName)]; vh != nil && vh.cert != nil {
+				return vh.cert, nil
+			}
+			return &defaultCert, nil
+		},
+	}
+	if caFile := s.config.TLS.CAFile; caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if s.config.Auth.Enabled && s.config.Auth.RequireClientCerts {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	if err := configureSessionTickets(tlsConfig, s.config.TLS.SessionTickets); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+// peerCertificate returns the verified client certificate of a TLS
+// connection, or nil
+func peerCertificate(conn net.Conn) *x509.Certificate {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// handshake completes the TLS handshake of a connection and returns the
+// virtual host selected by its SNI name, or nil for the default host
+func (s *Server) handshake(ctx context.Context, conn *tls.Conn) (*virtualHost, error) {
+	if timeout := s.config.Server.ReadTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	state := conn.ConnectionState()
+	metrics.TLSHandshakes.WithLabelValues(strconv.FormatBool(state.DidResume)).Inc()
+	vh := s.vhosts[strings.ToLower(state.ServerName)]
+	if vh != nil {
+		log.Printf("Connection from %s routed to virtual host %s", conn.RemoteAddr(), vh.ServerName)
+	}
+	return vh, nil
+}
+
+// admit reserves a connection slot on the virtual host, reporting false if
+// its client limit has been reached
+func (vh *virtualHost) admit() bool {
+	if vh.clients.Add(1) > int64(vh.MaxClients) && vh.MaxClients > 0 {
+		vh.clients.Add(-1)
+		return false
+	}
+	return true
+}
+
+// release frees a slot reserved by admit
+func (vh *virtualHost) release() {
+	vh.clients.Add(-1)
+}
+
+// sessionKey returns the key of a client's session in the server and the
+// store. The ClientIDs of a virtual host are scoped by its server name, so
+// that tenants using the same ClientID do not share a session; a NUL, which
+// no ClientID contains, separates them. Clients of the default host are
+// keyed by their ClientID alone.
+func sessionKey(vhost *virtualHost, clientID string) string {
+	if vhost == nil {
+		return clientID
+	}
+	return strings.ToLower(vhost.ServerName) + "\x00" + clientID
+}
+
+// mount maps a client-visible topic into the server-wide topic space
+func (c *Client) mount(topic string) string {
+	return c.mountpoint + topic
+}
+
+// unmount maps a server-wide topic back into the client's view
+func (c *Client) unmount(topic string) string {
+	return strings.TrimPrefix(topic, c.mountpoint)
+}
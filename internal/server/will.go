@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/clock"
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// Takeover will policies (server.takeover_will)
+const (
+	TakeoverWillPublish  = "publish"  // the replaced connection's will is published (spec behaviour)
+	TakeoverWillSuppress = "suppress" // the will is discarded when the same client reconnects
+)
+
+// newWill builds the will message of a CONNECT packet, or nil if it has
+// none. MQTT 5 will properties are kept, except the Will Delay Interval,
+// which is the broker's.
+func newWill(pkt *mqtt.ConnectPacket, client *Client) (*mqtt.PublishPacket, bool) {
+	if !pkt.WillFlag {
+		return nil, true
+	}
+	if pkt.WillQoS > 2 || pkt.WillTopic == "" || strings.ContainsAny(pkt.WillTopic, "+#") {
+		return nil, false
+	}
+	return &mqtt.PublishPacket{
+		Topic:      client.mount(pkt.WillTopic),
+		QoS:        pkt.WillQoS,
+		Retain:     pkt.WillRetain,
+		Payload:    pkt.WillMessage,
+		Properties: pkt.WillProperties.Without(mqtt.PropWillDelayInterval),
+	}, true
+}
+
+// delayedWill is a will waiting out its Will Delay Interval
+type delayedWill struct {
+	client *Client // nil once suspended by a stop: authorized then
+	will   *mqtt.PublishPacket
+	due    time.Time
+	timer  clock.Timer
+}
+
+// delayedWills holds the wills waiting out their Will Delay Interval, by
+// ClientID
+type delayedWills struct {
+	mu    sync.Mutex
+	wills map[string]*delayedWill
+}
+
+// schedule calls publish once the will is due on clk unless it is cancelled
+// first
+func (d *delayedWills) schedule(clk clock.Clock, clientID string, w *delayedWill, publish func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.wills == nil {
+		d.wills = make(map[string]*delayedWill)
+	}
+	if previous := d.wills[clientID]; previous != nil {
+		previous.timer.Stop()
+	}
+	w.timer = clk.AfterFunc(w.due.Sub(clk.Now()), func() {
+		d.mu.Lock()
+		current := d.wills[clientID] == w
+		if current {
+			delete(d.wills, clientID)
+		}
+		d.mu.Unlock()
+		if current {
+			publish()
+		}
+	})
+	d.wills[clientID] = w
+}
+
+// cancel drops the delayed will of a client and reports whether there was
+// one
+func (d *delayedWills) cancel(clientID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w := d.wills[clientID]
+	if w == nil {
+		return false
+	}
+	w.timer.Stop()
+	delete(d.wills, clientID)
+	return true
+}
+
+// stopAll stops every delayed will and returns them by ClientID
+func (d *delayedWills) stopAll() map[string]*delayedWill {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stopped := d.wills
+	for _, w := range stopped {
+		w.timer.Stop()
+	}
+	d.wills = nil
+	return stopped
+}
+
+// discardWill drops the client's will so it is never published
+func (c *Client) discardWill() {
+	c.will.Store(nil)
+}
+
+// publishWill publishes the client's will, at most once, after its
+// connection ended without a DISCONNECT. An MQTT 5 Will Delay Interval
+// postpones it, for no longer than the session lasts; a reconnection in the
+// meantime discards it.
+func (s *Server) publishWill(client *Client) {
+	will := client.will.Swap(nil)
+	if will == nil {
+		return
+	}
+	delay := client.willDelay
+	if client.CleanSession || client.sessionEnded.Load() {
+		delay = 0
+	} else if expiry := time.Duration(client.sessionExpiry) * time.Second; client.sessionExpiry > 0 && expiry < delay {
+		delay = expiry
+	}
+	if delay > 0 {
+		log.Printf("Will of %s delayed by %s", client.ID, delay)
+		s.scheduleWill(client.key, &delayedWill{client: client, will: will, due: s.clock.Now().Add(delay)})
+		return
+	}
+	s.sendWill(client, will)
+}
+
+// scheduleWill publishes a delayed will once it is due, unless the client
+// reconnects first
+func (s *Server) scheduleWill(clientID string, w *delayedWill) {
+	s.wills.schedule(s.clock, clientID, w, func() {
+		// A takeover may have started a new connection before this one's
+		// will was scheduled
+		s.mu.RLock()
+		current, connected := s.clients[clientID]
+		s.mu.RUnlock()
+		if connected && current != w.client {
+			log.Printf("Discarding delayed will of %s: client reconnected", clientID)
+			return
+		}
+		if w.client != nil {
+			s.sendWill(w.client, w.will)
+		} else if !s.shuttingDown() {
+			s.releaseWill(clientID, w.will)
+		}
+	})
+}
+
+// sendWill publishes a will. Wills are not published while the broker shuts
+// down, and like any message need the client to be authorized to publish
+// to their topic.
+func (s *Server) sendWill(client *Client, will *mqtt.PublishPacket) {
+	if s.shuttingDown() {
+		log.Printf("Discarding will of %s: broker is shutting down", client.ID)
+		return
+	}
+	if !s.authorizePublish(client, will.Topic) {
+		log.Printf("Discarding will of %s: not authorized to publish to %s", client.ID, will.Topic)
+		return
+	}
+	s.releaseWill(client.ID, will)
+}
+
+// shuttingDown reports whether the current run of the broker is stopping
+func (s *Server) shuttingDown() bool {
+	s.mu.RLock()
+	ctx := s.ctx
+	s.mu.RUnlock()
+	return ctx != nil && ctx.Err() != nil
+}
+
+// releaseWill publishes an authorized will
+func (s *Server) releaseWill(clientID string, will *mqtt.PublishPacket) {
+	log.Printf("Publishing will of %s to %s", clientID, will.Topic)
+	s.tracef(clientID, will.Topic, "will published: topic=%s qos=%d retain=%t payload=%s",
+		will.Topic, will.QoS, will.Retain, traceDump(will.Payload))
+	s.publishMessage(will, clientID)
+}
+
+// suspendWills keeps the delayed wills that were pending when the broker
+// stopped, once their clients are checked to be still authorized to
+// publish them. With a store they are saved with their sessions and
+// resumed by the next run at their due time; without one the sessions end
+// with the broker, and so are their wills published now, as at the end of
+// any session.
+func (s *Server) suspendWills(pending map[string]*delayedWill) {
+	for clientID, w := range pending {
+		if w.client != nil && !s.authorizePublish(w.client, w.will.Topic) {
+			log.Printf("Discarding will of %s: not authorized to publish to %s", clientID, w.will.Topic)
+			continue
+		}
+		if s.store == nil {
+			s.releaseWill(clientID, w.will)
+			continue
+		}
+		w.client = nil
+		s.sessionsMu.Lock()
+		if session := s.sessions[clientID]; session != nil {
+			session.will = w
+		}
+		s.sessionsMu.Unlock()
+		s.saveWill(clientID, w)
+	}
+}
+
+// saveWill stores a suspended will with its client's session, or removes
+// the stored will if w is nil
+func (s *Server) saveWill(clientID string, w *delayedWill) {
+	ctx, cancel := s.storeContext()
+	defer cancel()
+	session, err := s.store.LoadSession(ctx, clientID)
+	if err == nil && (w != nil || session.Will != nil) {
+		session.Will = nil
+		if w != nil {
+			session.Will = &store.Will{
+				Message:    &store.Message{Topic: w.will.Topic, Payload: w.will.Payload, QoS: w.will.QoS, Retain: w.will.Retain},
+				Properties: w.will.Properties.Encode(),
+				Due:        w.due,
+			}
+		}
+		err = s.store.SaveSession(ctx, clientID, session)
+	}
+	if err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+		log.Printf("Failed to save the delayed will of %s: %v", clientID, err)
+	}
+}
+
+// storedWill returns the delayed will saved with a session, or nil
+func storedWill(session *store.Session) *delayedWill {
+	if session.Will == nil || session.Will.Message == nil {
+		return nil
+	}
+	props, _, err := mqtt.ReadProperties(bytes.NewReader(session.Will.Properties))
+	if err != nil {
+		log.Printf("Ignoring the properties of the stored will of %s: %v", session.ClientID, err)
+	}
+	msg := session.Will.Message
+	return &delayedWill{
+		will: &mqtt.PublishPacket{Topic: msg.Topic, Payload: msg.Payload, QoS: msg.QoS, Retain: msg.Retain, Properties: props},
+		due:  session.Will.Due,
+	}
+}
+
+// resumeWills schedules the delayed wills suspended when the broker last
+// stopped, at their original due time. They are removed from the store,
+// which only keeps them while the broker is stopped.
+func (s *Server) resumeWills() {
+	s.sessionsMu.Lock()
+	resumed := make(map[string]*delayedWill)
+	for clientID, session := range s.sessions {
+		if session.will != nil {
+			resumed[clientID] = session.will
+			session.will = nil
+		}
+	}
+	s.sessionsMu.Unlock()
+
+	for clientID, w := range resumed {
+		log.Printf("Resuming delayed will of %s, due in %s", clientID, max(w.due.Sub(s.clock.Now()), 0))
+		s.saveWill(clientID, nil)
+		s.scheduleWill(clientID, w)
+	}
+}
@@ -1,9 +1,15 @@
 package store
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
@@ -14,28 +20,38 @@ var (
 	messagesBucket = []byte("messages")
 	retainedBucket = []byte("retained")
 	inflightBucket = []byte("inflight")
+	presenceBucket = []byte("presence")
+	statsBucket    = []byte("stats")
 )
 
+// encryptedBuckets hold message payloads and subscriptions, which are
+// encrypted when the store has a cipher
+var encryptedBuckets = [][]byte{sessionsBucket, messagesBucket, retainedBucket, inflightBucket}
+
 // BboltStore implements Store interface using bbolt embedded database
 type BboltStore struct {
-	db *bbolt.DB
+	db     *bbolt.DB
+	cipher *Cipher       // nil when encryption at rest is disabled
+	writer chan struct{} // held by the read-write transaction running or about to run
 }
 
-// NewBboltStore creates a new bbolt-backed store
+// NewBboltStore creates a new bbolt-backed store with the default open
+// options
 func NewBboltStore(path string) (*BboltStore, error) {
-	// Note: bbolt will create the file if it doesn't exist
-	// The directory must exist beforehand
-	_ = filepath.Dir(path) // Directory validation could be added here
+	return OpenBboltStore(path, OpenOptions{})
+}
 
-	// Open database
-	db, err := bbolt.Open(path, 0600, nil)
+// OpenBboltStore creates a new bbolt-backed store. The file and its
+// directory are created if they do not exist.
+func OpenBboltStore(path string, opts OpenOptions) (*BboltStore, error) {
+	db, err := openDB(path, false, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
 	// Create buckets if they don't exist
 	err = db.Update(func(tx *bbolt.Tx) error {
-		buckets := [][]byte{sessionsBucket, messagesBucket, retainedBucket, inflightBucket}
+		buckets := [][]byte{sessionsBucket, messagesBucket, retainedBucket, inflightBucket, presenceBucket, statsBucket}
 		for _, bucket := range buckets {
 			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
 				return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
@@ -48,151 +64,655 @@ func NewBboltStore(path string) (*BboltStore, error) {
 		return nil, err
 	}
 
-	return &BboltStore{db: db}, nil
+	return &BboltStore{db: db, writer: make(chan struct{}, 1)}, nil
+}
+
+// NewEncryptedBboltStore creates a bbolt-backed store encrypting sessions,
+// queued, retained and in-flight messages. Values written without
+// encryption or with a rotated-out key are re-encrypted with the current
+// key, so older keys can be dropped from the configuration afterwards.
+func NewEncryptedBboltStore(path string, c *Cipher, opts OpenOptions) (*BboltStore, int, error) {
+	s, err := OpenBboltStore(path, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.cipher = c
+	n, err := s.reencrypt()
+	if err != nil {
+		s.Close()
+		return nil, 0, opError("re-encrypt", "", err)
+	}
+	return s, n, nil
+}
+
+// reencrypt rewrites the values not encrypted with the current key and
+// returns how many were rewritten
+func (s *BboltStore) reencrypt() (int, error) {
+	rewritten := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range encryptedBuckets {
+			bucket := tx.Bucket(name)
+			var keys, values [][]byte
+			err := bucket.ForEach(func(k, v []byte) error {
+				if s.cipher.current(v) {
+					return nil
+				}
+				plain, err := s.cipher.open(name, k, v)
+				if err != nil {
+					return fmt.Errorf("%s %s: %w", name, k, err)
+				}
+				sealed, err := s.cipher.seal(name, k, plain)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, sealed)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for i, k := range keys {
+				if err := bucket.Put(k, values[i]); err != nil {
+					return err
+				}
+			}
+			rewritten += len(keys)
+		}
+		return nil
+	})
+	return rewritten, err
+}
+
+// update runs a read-write transaction bounded by ctx. The caller stops
+// waiting for the write lock when ctx ends, and the transaction is rolled
+// back instead of committed if ctx ended while it ran. Transactions run on
+// the caller's goroutine, and one that gave up waiting never runs, so
+// operations from one goroutine keep their order.
+func (s *BboltStore) update(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case s.writer <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.writer }()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+}
+
+// view runs a read-only transaction unless ctx has already ended. Readers
+// do not wait for the write lock.
+func (s *BboltStore) view(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(fn)
+}
+
+// encode encrypts a value stored under key in one of the encrypted buckets
+func (s *BboltStore) encode(bucket, key, data []byte) ([]byte, error) {
+	if s.cipher == nil {
+		return data, nil
+	}
+	return s.cipher.seal(bucket, key, data)
+}
+
+// decode decrypts a value read from one of the encrypted buckets
+func (s *BboltStore) decode(bucket, key, data []byte) ([]byte, error) {
+	if s.cipher == nil {
+		if id, _, ok := encryptedKeyID(data); ok {
+			return nil, fmt.Errorf("%w: %s (encryption is disabled)", ErrUnknownKey, id)
+		}
+		return data, nil
+	}
+	return s.cipher.open(bucket, key, data)
 }
 
 // SaveSession stores a client session
-func (s *BboltStore) SaveSession(clientID string, session *Session) error {
+func (s *BboltStore) SaveSession(ctx context.Context, clientID string, session *Session) error {
 	data, err := json.Marshal(session)
 	if err != nil {
-		return fmt.Errorf("failed to marshal session: %w", err)
+		return opError("save session", clientID, fmt.Errorf("failed to marshal session: %w", err))
 	}
 
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	data, err = s.encode(sessionsBucket, []byte(clientID), data)
+	if err != nil {
+		return opError("save session", clientID, err)
+	}
+
+	err = s.update(ctx, func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(sessionsBucket)
 		return bucket.Put([]byte(clientID), data)
 	})
+	return opError("save session", clientID, err)
 }
 
 // LoadSession retrieves a client session
-func (s *BboltStore) LoadSession(clientID string) (*Session, error) {
+func (s *BboltStore) LoadSession(ctx context.Context, clientID string) (*Session, error) {
 	var session Session
 
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(sessionsBucket)
 		data := bucket.Get([]byte(clientID))
 		if data == nil {
-			return fmt.Errorf("session not found")
+			return ErrSessionNotFound
+		}
+		data, err := s.decode(sessionsBucket, []byte(clientID), data)
+		if err != nil {
+			return err
 		}
 		return json.Unmarshal(data, &session)
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, opError("load session", clientID, err)
 	}
 	return &session, nil
 }
 
-// DeleteSession removes a client session
-func (s *BboltStore) DeleteSession(clientID string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+// DeleteSession removes a client session together with its queued and
+// in-flight messages
+func (s *BboltStore) DeleteSession(ctx context.Context, clientID string) error {
+	err := s.update(ctx, func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(sessionsBucket).Delete([]byte(clientID)); err != nil {
+			return err
+		}
+		prefix := []byte(clientID + ":")
+		if err := deletePrefixed(tx.Bucket(messagesBucket), prefix, func(rest []byte) bool {
+			return len(rest) == queueKeyDigits
+		}); err != nil {
+			return err
+		}
+		return deletePrefixed(tx.Bucket(inflightBucket), prefix, func(rest []byte) bool {
+			_, err := strconv.ParseUint(string(rest), 10, 16)
+			return err == nil
+		})
+	})
+	return opError("delete session", clientID, err)
+}
+
+// deletePrefixed deletes the keys starting with prefix whose remainder is
+// accepted by owned, which tells a client's keys apart from those of a
+// client whose ID starts with "<clientID>:"
+func deletePrefixed(bucket *bbolt.Bucket, prefix []byte, owned func(rest []byte) bool) error {
+	var keys [][]byte
+	cursor := bucket.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		if owned(k[len(prefix):]) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSessions returns all stored sessions
+func (s *BboltStore) ListSessions(ctx context.Context) ([]*Session, error) {
+	var sessions []*Session
+
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(sessionsBucket)
-		return bucket.Delete([]byte(clientID))
+		return bucket.ForEach(func(k, v []byte) error {
+			var session Session
+			v, err := s.decode(sessionsBucket, k, v)
+			if err != nil {
+				return fmt.Errorf("session %s: %w", k, err)
+			}
+			if err := json.Unmarshal(v, &session); err != nil {
+				return fmt.Errorf("session %s: %w", k, err)
+			}
+			sessions = append(sessions, &session)
+			return nil
+		})
 	})
+
+	if err != nil {
+		return nil, opError("list sessions", "", err)
+	}
+	return sessions, nil
 }
 
-// EnqueueMessage adds a message to a client's queue
-func (s *BboltStore) EnqueueMessage(clientID string, msg *Message) error {
-	// Create a queue key: clientID + message timestamp/ID
-	queueKey := fmt.Sprintf("%s:%d", clientID, len(msg.Payload)) // Simplified for now
+// queueKeyDigits is the width of the zero-padded sequence number in queue
+// keys, so that keys of one client sort in enqueue order
+const queueKeyDigits = 20
 
+// EnqueueMessage adds a message to a client's queue
+func (s *BboltStore) EnqueueMessage(ctx context.Context, clientID string, msg *Message) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return opError("enqueue message", clientID, fmt.Errorf("failed to marshal message: %w", err))
 	}
 
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	err = s.update(ctx, func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(messagesBucket)
-		return bucket.Put([]byte(queueKey), data)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		// Create a queue key: clientID + sequence number
+		queueKey := []byte(fmt.Sprintf("%s:%0*d", clientID, queueKeyDigits, seq))
+		data, err := s.encode(messagesBucket, queueKey, data)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(queueKey, data)
 	})
+	return opError("enqueue message", clientID, err)
 }
 
 // DequeueMessages retrieves all queued messages for a client
-func (s *BboltStore) DequeueMessages(clientID string) ([]*Message, error) {
+func (s *BboltStore) DequeueMessages(ctx context.Context, clientID string) ([]*Message, error) {
 	var messages []*Message
 
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(messagesBucket)
-		cursor := bucket.Cursor()
+	err := s.update(ctx, func(tx *bbolt.Tx) error {
+		var keys [][]byte
+		var err error
+		messages, keys, err = s.queuedMessages(tx, clientID, 0, 0)
+		if err != nil {
+			return err
+		}
 
-		// Find all messages for this client
-		prefix := []byte(clientID + ":")
-		for k, v := cursor.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, v = cursor.Next() {
-			var msg Message
-			if err := json.Unmarshal(v, &msg); err != nil {
+		// Delete the messages after reading
+		bucket := tx.Bucket(messagesBucket)
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
 				return err
 			}
-			messages = append(messages, &msg)
+		}
+		return nil
+	})
 
-			// Delete the message after reading
-			if err := cursor.Delete(); err != nil {
+	if err != nil {
+		return nil, opError("dequeue messages", clientID, err)
+	}
+	return messages, nil
+}
+
+// PeekMessages retrieves all queued messages for a client without removing
+// them
+func (s *BboltStore) PeekMessages(ctx context.Context, clientID string) ([]*Message, error) {
+	var messages []*Message
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		messages, _, err = s.queuedMessages(tx, clientID, 0, 0)
+		return err
+	})
+	if err != nil {
+		return nil, opError("peek messages", clientID, err)
+	}
+	return messages, nil
+}
+
+// DequeueBatch removes and returns up to max of a client's oldest queued
+// messages. Each batch is its own transaction, so a large queue can be
+// drained without holding the write lock for the whole queue.
+func (s *BboltStore) DequeueBatch(ctx context.Context, clientID string, max int) ([]*Message, error) {
+	if max < 1 {
+		return nil, opError("dequeue batch", clientID, fmt.Errorf("invalid batch size: %d", max))
+	}
+	var messages []*Message
+	err := s.update(ctx, func(tx *bbolt.Tx) error {
+		var keys [][]byte
+		var err error
+		messages, keys, err = s.queuedMessages(tx, clientID, 0, max)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket(messagesBucket)
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
 				return err
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, opError("dequeue batch", clientID, err)
+	}
+	return messages, nil
+}
 
+// PeekQueue returns up to max of a client's queued messages, skipping the
+// offset oldest, without removing them
+func (s *BboltStore) PeekQueue(ctx context.Context, clientID string, offset, max int) ([]*Message, error) {
+	if offset < 0 || max < 1 {
+		return nil, opError("peek queue", clientID, fmt.Errorf("invalid page: offset %d, max %d", offset, max))
+	}
+	var messages []*Message
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		messages, _, err = s.queuedMessages(tx, clientID, offset, max)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, opError("peek queue", clientID, err)
 	}
 	return messages, nil
 }
 
+// QueueLen returns the number of a client's queued messages, counting their
+// keys without reading them
+func (s *BboltStore) QueueLen(ctx context.Context, clientID string) (int, error) {
+	var n int
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(messagesBucket).Cursor()
+		prefix := []byte(clientID + ":")
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			if len(k) == len(prefix)+queueKeyDigits {
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, opError("queue length", clientID, err)
+	}
+	return n, nil
+}
+
+// queuedMessages reads the queue of a client in enqueue order, skipping the
+// offset oldest messages and stopping after max (all if 0), returning the
+// messages and their keys
+func (s *BboltStore) queuedMessages(tx *bbolt.Tx, clientID string, offset, max int) ([]*Message, [][]byte, error) {
+	var messages []*Message
+	var keys [][]byte
+	cursor := tx.Bucket(messagesBucket).Cursor()
+	prefix := []byte(clientID + ":")
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		if len(k) != len(prefix)+queueKeyDigits {
+			continue // Queue of another client whose ID starts with "<clientID>:"
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if max > 0 && len(messages) == max {
+			break
+		}
+		var msg Message
+		v, err := s.decode(messagesBucket, k, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(v, &msg); err != nil {
+			return nil, nil, err
+		}
+		messages = append(messages, &msg)
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	return messages, keys, nil
+}
+
 // StoreRetained stores a retained message for a topic
-func (s *BboltStore) StoreRetained(topic string, msg *Message) error {
+func (s *BboltStore) StoreRetained(ctx context.Context, topic string, msg *Message) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal retained message: %w", err)
+		return opError("store retained", topic, fmt.Errorf("failed to marshal retained message: %w", err))
 	}
 
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	data, err = s.encode(retainedBucket, []byte(topic), data)
+	if err != nil {
+		return opError("store retained", topic, err)
+	}
+
+	err = s.update(ctx, func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(retainedBucket)
 		return bucket.Put([]byte(topic), data)
 	})
+	return opError("store retained", topic, err)
 }
 
 // GetRetained retrieves the retained message for a topic
-func (s *BboltStore) GetRetained(topic string) (*Message, error) {
+func (s *BboltStore) GetRetained(ctx context.Context, topic string) (*Message, error) {
 	var msg Message
 
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(retainedBucket)
 		data := bucket.Get([]byte(topic))
 		if data == nil {
-			return fmt.Errorf("no retained message for topic")
+			return ErrRetainedNotFound
+		}
+		data, err := s.decode(retainedBucket, []byte(topic), data)
+		if err != nil {
+			return err
 		}
 		return json.Unmarshal(data, &msg)
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, opError("get retained", topic, err)
 	}
 	return &msg, nil
 }
 
-// PersistInflight stores an in-flight QoS 1/2 message
-func (s *BboltStore) PersistInflight(clientID string, packetID uint16, msg *Message) error {
-	key := fmt.Sprintf("%s:%d", clientID, packetID)
+// inflightRecord is the stored form of an in-flight message. Seq records the
+// order messages were sent in, since packet IDs wrap around.
+type inflightRecord struct {
+	Seq      uint64
+	Released bool `json:",omitempty"`
+	*Message
+}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal inflight message: %w", err)
-	}
+// PersistInflight stores an in-flight QoS 1/2 message. A message already
+// stored under the packet ID is replaced but keeps its place in the send
+// order, so a QoS 2 delivery can be marked released.
+func (s *BboltStore) PersistInflight(ctx context.Context, clientID string, msg *InflightMessage) error {
+	key := fmt.Sprintf("%s:%d", clientID, msg.PacketID)
 
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	err := s.update(ctx, func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(inflightBucket)
+		seq, err := s.inflightSeq(bucket, []byte(key))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(inflightRecord{Seq: seq, Released: msg.Released, Message: msg.Message})
+		if err != nil {
+			return fmt.Errorf("failed to marshal inflight message: %w", err)
+		}
+		data, err = s.encode(inflightBucket, []byte(key), data)
+		if err != nil {
+			return err
+		}
 		return bucket.Put([]byte(key), data)
 	})
+	return opError("persist inflight", key, err)
+}
+
+// inflightSeq returns the send order of the in-flight message stored under
+// key, or the next one if there is none
+func (s *BboltStore) inflightSeq(bucket *bbolt.Bucket, key []byte) (uint64, error) {
+	if v := bucket.Get(key); v != nil {
+		v, err := s.decode(inflightBucket, key, v)
+		if err != nil {
+			return 0, err
+		}
+		var record inflightRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return 0, err
+		}
+		return record.Seq, nil
+	}
+	return bucket.NextSequence()
+}
+
+// LoadInflight returns the in-flight messages of a client in the order they
+// were sent
+func (s *BboltStore) LoadInflight(ctx context.Context, clientID string) ([]*InflightMessage, error) {
+	type sequenced struct {
+		seq uint64
+		msg *InflightMessage
+	}
+	var found []sequenced
+
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(inflightBucket).Cursor()
+		prefix := []byte(clientID + ":")
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			packetID, err := strconv.ParseUint(string(k[len(prefix):]), 10, 16)
+			if err != nil {
+				continue // Message of another client whose ID starts with "<clientID>:"
+			}
+			record := inflightRecord{Message: &Message{}}
+			v, err = s.decode(inflightBucket, k, v)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			found = append(found, sequenced{record.Seq, &InflightMessage{PacketID: uint16(packetID), Message: record.Message, Released: record.Released}})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, opError("load inflight", clientID, err)
+	}
+	slices.SortFunc(found, func(a, b sequenced) int { return cmp.Compare(a.seq, b.seq) })
+	messages := make([]*InflightMessage, len(found))
+	for i, f := range found {
+		messages[i] = f.msg
+	}
+	return messages, nil
 }
 
 // ClearInflight removes an in-flight message after acknowledgment
-func (s *BboltStore) ClearInflight(clientID string, packetID uint16) error {
+func (s *BboltStore) ClearInflight(ctx context.Context, clientID string, packetID uint16) error {
 	key := fmt.Sprintf("%s:%d", clientID, packetID)
 
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	err := s.update(ctx, func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(inflightBucket)
 		return bucket.Delete([]byte(key))
 	})
+	return opError("clear inflight", key, err)
+}
+
+// SavePresence stores the presence record of a client
+func (s *BboltStore) SavePresence(ctx context.Context, p *Presence) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return opError("save presence", p.ClientID, fmt.Errorf("failed to marshal presence: %w", err))
+	}
+
+	err = s.update(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(presenceBucket).Put([]byte(p.ClientID), data)
+	})
+	return opError("save presence", p.ClientID, err)
+}
+
+// ListPresence returns the presence records of all known clients
+func (s *BboltStore) ListPresence(ctx context.Context) ([]*Presence, error) {
+	var records []*Presence
+
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(presenceBucket).ForEach(func(k, v []byte) error {
+			var p Presence
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("presence %s: %w", k, err)
+			}
+			records = append(records, &p)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, opError("list presence", "", err)
+	}
+	return records, nil
+}
+
+// DeletePresence removes the presence record of a client
+func (s *BboltStore) DeletePresence(ctx context.Context, clientID string) error {
+	err := s.update(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(presenceBucket).Delete([]byte(clientID))
+	})
+	return opError("delete presence", clientID, err)
+}
+
+// statsKey orders statistics samples by time
+func statsKey(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+// SaveStats stores a statistics sample under its time
+func (s *BboltStore) SaveStats(ctx context.Context, sample *StatsSample) error {
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return opError("save stats", "", fmt.Errorf("failed to marshal stats: %w", err))
+	}
+
+	err = s.update(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(statsBucket).Put(statsKey(sample.Time), data)
+	})
+	return opError("save stats", "", err)
+}
+
+// LoadStats returns the statistics samples taken in [from, to)
+func (s *BboltStore) LoadStats(ctx context.Context, from, to time.Time) ([]*StatsSample, error) {
+	var samples []*StatsSample
+	end := statsKey(to)
+
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		c := tx.Bucket(statsBucket).Cursor()
+		for k, v := c.Seek(statsKey(from)); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			var sample StatsSample
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return fmt.Errorf("stats %x: %w", k, err)
+			}
+			samples = append(samples, &sample)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, opError("load stats", "", err)
+	}
+	return samples, nil
+}
+
+// PruneStats deletes the statistics samples taken before a time
+func (s *BboltStore) PruneStats(ctx context.Context, before time.Time) (int, error) {
+	pruned := 0
+	end := statsKey(before)
+
+	err := s.update(ctx, func(tx *bbolt.Tx) error {
+		c := tx.Bucket(statsBucket).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			pruned++
+		}
+		return nil
+	})
+	return pruned, opError("prune stats", "", err)
+}
+
+// Ping runs an empty read transaction, failing once the database is closed
+func (s *BboltStore) Ping(ctx context.Context) error {
+	return opError("ping", "", s.view(ctx, func(tx *bbolt.Tx) error { return nil }))
+}
+
+// HealthCheck pings the database
+func (s *BboltStore) HealthCheck(ctx context.Context) *Health {
+	start := time.Now()
+	err := s.Ping(ctx)
+	h := &Health{Up: err == nil, Latency: time.Since(start), CheckedAt: start}
+	if err != nil {
+		h.Error = err.Error()
+	}
+	return h
 }
 
 // Close closes the database
@@ -200,10 +720,23 @@ func (s *BboltStore) Close() error {
 	return s.db.Close()
 }
 
-// Stats returns database statistics
+// Stats returns database statistics: transaction counters, free pages,
+// file size and keys per bucket
 func (s *BboltStore) Stats() map[string]interface{} {
 	stats := s.db.Stats()
+	keys := make(map[string]int)
+	var size int64
+	s.db.View(func(tx *bbolt.Tx) error {
+		size = tx.Size()
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			keys[string(name)] = b.Stats().KeyN
+			return nil
+		})
+	})
 	return map[string]interface{}{
-		"tx_stats": stats.TxStats,
+		"tx_stats":   stats.TxStats,
+		"free_pages": stats.FreePageN,
+		"size":       size,
+		"keys":       keys,
 	}
 }
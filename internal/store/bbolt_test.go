@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func openTestStore(t *testing.T) *BboltStore {
+	t.Helper()
+	s, err := NewBboltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// TestUpdateBoundedByContext tests that an operation waiting behind a
+// blocked transaction gives up when its context ends, and is not run later
+func TestUpdateBoundedByContext(t *testing.T) {
+	s := openTestStore(t)
+
+	held := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- s.update(context.Background(), func(tx *bbolt.Tx) error {
+			close(held)
+			<-release
+			return nil
+		})
+	}()
+	<-held
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.SaveSession(ctx, "blocked", &Session{ClientID: "blocked"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the wait to end with the context, took %s", elapsed)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Blocking transaction failed: %v", err)
+	}
+	if _, err := s.LoadSession(context.Background(), "blocked"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected the timed out save not to run, got %v", err)
+	}
+	if err := s.SaveSession(context.Background(), "blocked", &Session{ClientID: "blocked"}); err != nil {
+		t.Fatalf("Save after the blocked transaction failed: %v", err)
+	}
+}
+
+// TestUpdateRolledBackAfterDeadline tests that a transaction whose context
+// ends while it runs is rolled back
+func TestUpdateRolledBackAfterDeadline(t *testing.T) {
+	s := openTestStore(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := s.update(ctx, func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(sessionsBucket).Put([]byte("slow"), []byte("{}")); err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := s.LoadSession(context.Background(), "slow"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected the transaction to be rolled back, got %v", err)
+	}
+}
+
+// TestQueueLen tests that queue lengths count one client's messages only,
+// as they are enqueued and dequeued, in both stores
+func TestQueueLen(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range map[string]Store{"bbolt": openTestStore(t), "memory": NewMemoryStore()} {
+		for i := range 5 {
+			if err := s.EnqueueMessage(ctx, "sensor", &Message{Topic: "t", Payload: []byte{byte(i)}}); err != nil {
+				t.Fatalf("%s: enqueue failed: %v", name, err)
+			}
+		}
+		// Another client whose ID starts with "sensor:"
+		if err := s.EnqueueMessage(ctx, "sensor:2", &Message{Topic: "t"}); err != nil {
+			t.Fatalf("%s: enqueue failed: %v", name, err)
+		}
+		if n, err := s.QueueLen(ctx, "sensor"); err != nil || n != 5 {
+			t.Errorf("%s: expected 5 queued messages, got %d, %v", name, n, err)
+		}
+		if _, err := s.DequeueBatch(ctx, "sensor", 2); err != nil {
+			t.Fatalf("%s: dequeue failed: %v", name, err)
+		}
+		if n, err := s.QueueLen(ctx, "sensor"); err != nil || n != 3 {
+			t.Errorf("%s: expected 3 queued messages after dequeuing 2, got %d, %v", name, n, err)
+		}
+		if n, err := s.QueueLen(ctx, "unknown"); err != nil || n != 0 {
+			t.Errorf("%s: expected no queued messages for an unknown client, got %d, %v", name, n, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// OpenReadOnly opens a bbolt store for inspection without modifying it.
+// The cipher decrypts encrypted values and may be nil. A running broker
+// holds the file lock, so opening fails after the timeout while it runs.
+func OpenReadOnly(path string, c *Cipher, timeout time.Duration) (*BboltStore, error) {
+	db, err := openDB(path, true, OpenOptions{LockTimeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	return &BboltStore{db: db, cipher: c, writer: make(chan struct{}, 1)}, nil
+}
+
+// Dump is the content of a store, for offline debugging
+type Dump struct {
+	Sessions []*Session
+	Retained []*RetainedRecord
+	Queued   []*QueuedRecord
+	Inflight []*InflightRecord
+	Presence []*Presence
+}
+
+// RetainedRecord is a stored retained message
+type RetainedRecord struct {
+	Topic   string
+	Message *Message
+}
+
+// QueuedRecord is a message queued for an offline session
+type QueuedRecord struct {
+	ClientID string
+	Seq      uint64 // enqueue order
+	Message  *Message
+}
+
+// InflightRecord is a delivery awaiting the client's acknowledgement
+type InflightRecord struct {
+	ClientID string
+	PacketID uint16
+	Seq      uint64 // send order
+	Message  *Message
+	Released bool // QoS 2: awaiting PUBCOMP
+}
+
+// DumpFilter selects what a dump contains. Nil functions select everything.
+type DumpFilter struct {
+	Sessions, Retained, Queued, Inflight, Presence bool
+
+	ClientID func(clientID string) bool // sessions, queues, inflight and presence
+	Topic    func(topic string) bool    // retained, queued and inflight messages
+}
+
+// Dump reads the records a filter selects, decrypting them
+func (s *BboltStore) Dump(f DumpFilter) (*Dump, error) {
+	client := func(id string) bool { return f.ClientID == nil || f.ClientID(id) }
+	topic := func(t string) bool { return f.Topic == nil || f.Topic(t) }
+	d := &Dump{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		if f.Sessions {
+			err := s.forEach(tx, sessionsBucket, func(k, v []byte) error {
+				if !client(string(k)) {
+					return nil
+				}
+				var session Session
+				if err := json.Unmarshal(v, &session); err != nil {
+					return err
+				}
+				d.Sessions = append(d.Sessions, &session)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if f.Retained {
+			err := s.forEach(tx, retainedBucket, func(k, v []byte) error {
+				if !topic(string(k)) {
+					return nil
+				}
+				var msg Message
+				if err := json.Unmarshal(v, &msg); err != nil {
+					return err
+				}
+				d.Retained = append(d.Retained, &RetainedRecord{Topic: string(k), Message: &msg})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if f.Queued {
+			err := s.forEach(tx, messagesBucket, func(k, v []byte) error {
+				id, seq, ok := splitRecordKey(k)
+				if !ok || !client(id) {
+					return nil
+				}
+				var msg Message
+				if err := json.Unmarshal(v, &msg); err != nil {
+					return err
+				}
+				if topic(msg.Topic) {
+					d.Queued = append(d.Queued, &QueuedRecord{ClientID: id, Seq: seq, Message: &msg})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if f.Inflight {
+			err := s.forEach(tx, inflightBucket, func(k, v []byte) error {
+				id, packetID, ok := splitRecordKey(k)
+				if !ok || packetID > 0xFFFF || !client(id) {
+					return nil
+				}
+				record := inflightRecord{Message: &Message{}}
+				if err := json.Unmarshal(v, &record); err != nil {
+					return err
+				}
+				if topic(record.Topic) {
+					d.Inflight = append(d.Inflight, &InflightRecord{ClientID: id, PacketID: uint16(packetID), Seq: record.Seq, Message: record.Message, Released: record.Released})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if f.Presence {
+			return s.forEach(tx, presenceBucket, func(k, v []byte) error {
+				if !client(string(k)) {
+					return nil
+				}
+				var p Presence
+				if err := json.Unmarshal(v, &p); err != nil {
+					return err
+				}
+				d.Presence = append(d.Presence, &p)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, opError("dump", "", err)
+	}
+	return d, nil
+}
+
+// forEach calls fn with every key and decrypted value of a bucket, in key
+// order. Buckets missing from older files are skipped.
+func (s *BboltStore) forEach(tx *bbolt.Tx, name []byte, fn func(k, v []byte) error) error {
+	bucket := tx.Bucket(name)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(func(k, v []byte) error {
+		if !bytes.Equal(name, presenceBucket) {
+			var err error
+			if v, err = s.decode(name, k, v); err != nil {
+				return fmt.Errorf("%s %s: %w", name, k, err)
+			}
+		}
+		if err := fn(k, v); err != nil {
+			return fmt.Errorf("%s %s: %w", name, k, err)
+		}
+		return nil
+	})
+}
+
+// splitRecordKey splits a "<clientID>:<number>" queue or inflight key
+func splitRecordKey(k []byte) (string, uint64, bool) {
+	i := strings.LastIndexByte(string(k), ':')
+	if i < 0 {
+		return "", 0, false
+	}
+	n, err := strconv.ParseUint(string(k[i+1:]), 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return string(k[:i]), n, true
+}
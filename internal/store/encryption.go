@@ -0,0 +1,127 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// encryptedMagic starts every encrypted value. Stored JSON starts with '{',
+// so values written before encryption was enabled are told apart.
+var encryptedMagic = []byte{0x00, 'E', '1'}
+
+// ErrUnknownKey is returned when a value was encrypted with a key that is
+// no longer configured
+var ErrUnknownKey = errors.New("value encrypted with unknown key")
+
+// Key is an AES-256 key identified by an ID stored with every value it
+// encrypts, so keys can be rotated without rewriting the store at once
+type Key struct {
+	ID  string
+	Key []byte // 32 bytes
+}
+
+// Cipher encrypts stored values with AES-GCM. The first key encrypts new
+// values; the others decrypt values written before a key rotation.
+type Cipher struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewCipher creates a cipher from one or more keys, the first being the
+// current one
+func NewCipher(keys []Key) (*Cipher, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no encryption keys")
+	}
+	c := &Cipher{primary: keys[0].ID, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for _, k := range keys {
+		if k.ID == "" || len(k.ID) > 255 {
+			return nil, fmt.Errorf("invalid encryption key ID: %q", k.ID)
+		}
+		if _, ok := c.aeads[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate encryption key ID: %s", k.ID)
+		}
+		if len(k.Key) != 32 {
+			return nil, fmt.Errorf("encryption key %s must be 32 bytes, got %d", k.ID, len(k.Key))
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", k.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", k.ID, err)
+		}
+		c.aeads[k.ID] = aead
+	}
+	return c, nil
+}
+
+// seal encrypts a value stored under key in bucket. The location is
+// authenticated, so values cannot be moved between keys or buckets.
+// Format: magic, key ID length, key ID, nonce, ciphertext.
+func (c *Cipher) seal(bucket, key, value []byte) ([]byte, error) {
+	aead := c.aeads[c.primary]
+	out := make([]byte, 0, len(encryptedMagic)+1+len(c.primary)+aead.NonceSize()+len(value)+aead.Overhead())
+	out = append(out, encryptedMagic...)
+	out = append(out, byte(len(c.primary)))
+	out = append(out, c.primary...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, value, additionalData(bucket, key)), nil
+}
+
+// open decrypts a stored value. Values that are not encrypted are returned
+// as they are.
+func (c *Cipher) open(bucket, key, value []byte) ([]byte, error) {
+	id, rest, ok := encryptedKeyID(value)
+	if !ok {
+		return value, nil
+	}
+	aead, found := c.aeads[id]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted value too short")
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, additionalData(bucket, key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plain, nil
+}
+
+// current reports whether a stored value is encrypted with the primary key
+func (c *Cipher) current(value []byte) bool {
+	id, _, ok := encryptedKeyID(value)
+	return ok && id == c.primary
+}
+
+// encryptedKeyID splits an encrypted value into its key ID and the rest
+func encryptedKeyID(value []byte) (string, []byte, bool) {
+	if !bytes.HasPrefix(value, encryptedMagic) || len(value) < len(encryptedMagic)+1 {
+		return "", nil, false
+	}
+	rest := value[len(encryptedMagic):]
+	n := int(rest[0])
+	if len(rest) < 1+n {
+		return "", nil, false
+	}
+	return string(rest[1 : 1+n]), rest[1+n:], true
+}
+
+func additionalData(bucket, key []byte) []byte {
+	ad := make([]byte, 0, len(bucket)+1+len(key))
+	ad = append(ad, bucket...)
+	ad = append(ad, 0)
+	return append(ad, key...)
+}
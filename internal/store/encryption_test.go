@@ -0,0 +1,230 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// testKey returns a 32 byte key filled with b
+func testKey(id string, b byte) Key {
+	return Key{ID: id, Key: bytes.Repeat([]byte{b}, 32)}
+}
+
+func newTestCipher(t *testing.T, keys ...Key) *Cipher {
+	t.Helper()
+	c, err := NewCipher(keys)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	return c
+}
+
+// TestCipherRoundTrip tests that sealed values open to the original, are
+// bound to their location and pass through unencrypted values
+func TestCipherRoundTrip(t *testing.T) {
+	c := newTestCipher(t, testKey("k1", 1))
+	bucket, key, value := []byte("sessions"), []byte("sensor"), []byte(`{"ClientID":"sensor"}`)
+
+	sealed, err := c.seal(bucket, key, value)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if !bytes.HasPrefix(sealed, encryptedMagic) || bytes.Contains(sealed, value) {
+		t.Fatalf("Expected an encrypted value, got %q", sealed)
+	}
+	if again, _ := c.seal(bucket, key, value); bytes.Equal(again, sealed) {
+		t.Error("Expected a fresh nonce for every seal")
+	}
+	if !c.current(sealed) {
+		t.Error("Expected the value to be encrypted with the current key")
+	}
+	plain, err := c.open(bucket, key, sealed)
+	if err != nil || !bytes.Equal(plain, value) {
+		t.Fatalf("Expected %s, got %s, %v", value, plain, err)
+	}
+
+	if _, err := c.open(bucket, []byte("other"), sealed); err == nil {
+		t.Error("Expected a value moved to another key to fail")
+	}
+	if _, err := c.open([]byte("retained"), key, sealed); err == nil {
+		t.Error("Expected a value moved to another bucket to fail")
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := c.open(bucket, key, tampered); err == nil {
+		t.Error("Expected a tampered value to fail")
+	}
+	if _, err := c.open(bucket, key, sealed[:len(encryptedMagic)+4]); err == nil {
+		t.Error("Expected a truncated value to fail")
+	}
+
+	if plain, err := c.open(bucket, key, value); err != nil || !bytes.Equal(plain, value) {
+		t.Errorf("Expected an unencrypted value as it is, got %s, %v", plain, err)
+	}
+	if c.current(value) {
+		t.Error("Expected an unencrypted value not to be current")
+	}
+}
+
+// TestCipherWrongKey tests that values do not open with another key, under
+// the same ID or another one
+func TestCipherWrongKey(t *testing.T) {
+	bucket, key := []byte("messages"), []byte("sensor:00000000000000000001")
+	sealed, err := newTestCipher(t, testKey("k1", 1)).seal(bucket, key, []byte("payload"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	if _, err := newTestCipher(t, testKey("k1", 2)).open(bucket, key, sealed); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected a decryption failure with other key material, got %v", err)
+	}
+	if _, err := newTestCipher(t, testKey("k2", 1)).open(bucket, key, sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey without the key, got %v", err)
+	}
+}
+
+// TestNewCipherInvalid tests that invalid key sets are refused
+func TestNewCipherInvalid(t *testing.T) {
+	tests := map[string][]Key{
+		"no keys":      nil,
+		"empty ID":     {testKey("", 1)},
+		"duplicate ID": {testKey("k1", 1), testKey("k1", 2)},
+		"short key":    {{ID: "k1", Key: make([]byte, 16)}},
+	}
+	for name, keys := range tests {
+		if _, err := NewCipher(keys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// writeTestRecords stores one record in each encrypted bucket
+func writeTestRecords(t *testing.T, s *BboltStore) {
+	t.Helper()
+	ctx := context.Background()
+	msg := &Message{Topic: "devices/sensor/temp", Payload: []byte("21.5"), QoS: 1}
+	if err := s.SaveSession(ctx, "sensor", &Session{ClientID: "sensor", Subscriptions: []Subscription{{Topic: "config/#", QoS: 1}}}); err != nil {
+		t.Fatalf("Save session failed: %v", err)
+	}
+	if err := s.EnqueueMessage(ctx, "sensor", msg); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if err := s.StoreRetained(ctx, msg.Topic, msg); err != nil {
+		t.Fatalf("Store retained failed: %v", err)
+	}
+	if err := s.PersistInflight(ctx, "sensor", &InflightMessage{PacketID: 7, Message: msg}); err != nil {
+		t.Fatalf("Persist inflight failed: %v", err)
+	}
+}
+
+// checkTestRecords reads back the records of writeTestRecords
+func checkTestRecords(t *testing.T, s *BboltStore) {
+	t.Helper()
+	ctx := context.Background()
+	session, err := s.LoadSession(ctx, "sensor")
+	if err != nil || len(session.Subscriptions) != 1 || session.Subscriptions[0].Topic != "config/#" {
+		t.Fatalf("Expected the stored session, got %+v, %v", session, err)
+	}
+	queued, err := s.PeekMessages(ctx, "sensor")
+	if err != nil || len(queued) != 1 || string(queued[0].Payload) != "21.5" {
+		t.Fatalf("Expected the queued message, got %v, %v", queued, err)
+	}
+	retained, err := s.GetRetained(ctx, "devices/sensor/temp")
+	if err != nil || retained == nil || string(retained.Payload) != "21.5" {
+		t.Fatalf("Expected the retained message, got %v, %v", retained, err)
+	}
+	inflight, err := s.LoadInflight(ctx, "sensor")
+	if err != nil || len(inflight) != 1 || inflight[0].PacketID != 7 {
+		t.Fatalf("Expected the inflight message, got %v, %v", inflight, err)
+	}
+}
+
+// rawValue reads a value as it is stored
+func rawValue(t *testing.T, s *BboltStore, bucket []byte, key string) []byte {
+	t.Helper()
+	var value []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		value = bytes.Clone(tx.Bucket(bucket).Get([]byte(key)))
+		return nil
+	})
+	if err != nil || value == nil {
+		t.Fatalf("Failed to read %s/%s: %v", bucket, key, err)
+	}
+	return value
+}
+
+// TestEncryptedStoreRotation tests that records written before encryption
+// was enabled and before a key rotation are read and re-encrypted with the
+// current key, after which the old key can be dropped
+func TestEncryptedStoreRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encrypted.db")
+	k1, k2 := testKey("k1", 1), testKey("k2", 2)
+	open := func(keys ...Key) (*BboltStore, int, error) {
+		return NewEncryptedBboltStore(path, newTestCipher(t, keys...), OpenOptions{})
+	}
+
+	// Records written without encryption are encrypted on open
+	plain, err := NewBboltStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	writeTestRecords(t, plain)
+	plain.Close()
+
+	s, n, err := open(k1)
+	if err != nil {
+		t.Fatalf("Failed to open encrypted store: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected the 4 plain records to be encrypted, got %d", n)
+	}
+	checkTestRecords(t, s)
+	if raw := rawValue(t, s, retainedBucket, "devices/sensor/temp"); !newTestCipher(t, k1).current(raw) || bytes.Contains(raw, []byte("21.5")) {
+		t.Errorf("Expected the retained message encrypted with k1, got %q", raw)
+	}
+	s.Close()
+
+	// A store without the cipher cannot read them
+	plain, err = NewBboltStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if _, err := plain.LoadSession(context.Background(), "sensor"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey without encryption, got %v", err)
+	}
+	plain.Close()
+
+	// Rotating to k2 keeps the records written with k1 readable
+	s, n, err = open(k2, k1)
+	if err != nil {
+		t.Fatalf("Failed to open store with rotated keys: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected the 4 records to be re-encrypted, got %d", n)
+	}
+	checkTestRecords(t, s)
+	if raw := rawValue(t, s, sessionsBucket, "sensor"); !newTestCipher(t, k2).current(raw) {
+		t.Error("Expected the session re-encrypted with k2")
+	}
+	s.Close()
+
+	// Once re-encrypted, k1 is no longer needed, and k1 alone cannot read
+	// the store
+	s, n, err = open(k2)
+	if err != nil {
+		t.Fatalf("Failed to open store without the old key: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Expected nothing to re-encrypt, got %d", n)
+	}
+	checkTestRecords(t, s)
+	s.Close()
+	if _, _, err := open(k1); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey with the rotated-out key only, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSessionNotFound is returned when no session is stored for a client
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrRetainedNotFound is returned when no retained message is stored for a topic
+	ErrRetainedNotFound = errors.New("retained message not found")
+
+	// ErrDatabaseLocked is returned when another process keeps the database
+	// file locked beyond the lock timeout and retries
+	ErrDatabaseLocked = errors.New("database locked by another process")
+
+	// ErrStoreUnavailable is returned while a network backend is down and
+	// its circuit breaker is open
+	ErrStoreUnavailable = errors.New("store unavailable")
+)
+
+// OpError records the store operation and key that failed. Err is either one
+// of the sentinel errors above or the underlying encoding or I/O failure.
+type OpError struct {
+	Op  string // Operation, e.g. "load session"
+	Key string // Client ID or topic the operation was applied to
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+// opError wraps err in an OpError, returning nil if err is nil
+func opError(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OpError{Op: op, Key: key, Err: err}
+}
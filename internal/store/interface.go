@@ -1,39 +1,139 @@
 package store
 
-// Store defines the interface for persistent storage
+import (
+	"context"
+	"time"
+)
+
+// Store defines the interface for persistent storage. Operations give up
+// when their context ends, returning its error wrapped in an OpError, so a
+// slow backend cannot stall the caller beyond its deadline.
 type Store interface {
 	// Session management
-	SaveSession(clientID string, session *Session) error
-	LoadSession(clientID string) (*Session, error)
-	DeleteSession(clientID string) error
+	SaveSession(ctx context.Context, clientID string, session *Session) error
+	LoadSession(ctx context.Context, clientID string) (*Session, error)
+	DeleteSession(ctx context.Context, clientID string) error // also drops queued and in-flight messages
+	ListSessions(ctx context.Context) ([]*Session, error)
 
 	// Message queue operations
-	EnqueueMessage(clientID string, msg *Message) error
-	DequeueMessages(clientID string) ([]*Message, error)
+	EnqueueMessage(ctx context.Context, clientID string, msg *Message) error
+	DequeueMessages(ctx context.Context, clientID string) ([]*Message, error)
+	PeekMessages(ctx context.Context, clientID string) ([]*Message, error)               // queued messages, left in the queue
+	DequeueBatch(ctx context.Context, clientID string, max int) ([]*Message, error)      // up to max of the oldest queued messages
+	PeekQueue(ctx context.Context, clientID string, offset, max int) ([]*Message, error) // one page of the queue, left in the queue
+	QueueLen(ctx context.Context, clientID string) (int, error)                          // number of queued messages, none of them read
 
 	// Retained messages
-	StoreRetained(topic string, msg *Message) error
-	GetRetained(topic string) (*Message, error)
+	StoreRetained(ctx context.Context, topic string, msg *Message) error
+	GetRetained(ctx context.Context, topic string) (*Message, error)
 
 	// QoS state tracking
-	PersistInflight(clientID string, packetID uint16, msg *Message) error
-	ClearInflight(clientID string, packetID uint16) error
+	PersistInflight(ctx context.Context, clientID string, msg *InflightMessage) error // adds or updates a delivery, keeping its place
+	ClearInflight(ctx context.Context, clientID string, packetID uint16) error
+	LoadInflight(ctx context.Context, clientID string) ([]*InflightMessage, error)
+
+	// Client presence
+	SavePresence(ctx context.Context, p *Presence) error
+	ListPresence(ctx context.Context) ([]*Presence, error)
+	DeletePresence(ctx context.Context, clientID string) error
+
+	// Statistics history
+	SaveStats(ctx context.Context, sample *StatsSample) error
+	LoadStats(ctx context.Context, from, to time.Time) ([]*StatsSample, error) // samples taken in [from, to), oldest first
+	PruneStats(ctx context.Context, before time.Time) (int, error)             // deletes samples taken before a time
+
+	// Health
+	Ping(ctx context.Context) error          // cheap round trip to the backend
+	HealthCheck(ctx context.Context) *Health // ping, timed and with the backend's connection state
 
 	// Close the store
 	Close() error
 }
 
+// OpContext returns a context bounding a store operation by timeout, or
+// one without deadline if timeout is not positive
+func OpContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Health is the outcome of a store health check
+type Health struct {
+	Up         bool          `json:"up"`
+	Latency    time.Duration `json:"latency"`
+	Error      string        `json:"error,omitempty"`
+	CheckedAt  time.Time     `json:"checked_at"`
+	Circuit    string        `json:"circuit,omitempty"`    // circuit breaker state of network backends
+	Failures   int           `json:"failures,omitempty"`   // consecutive failed checks
+	Reconnects int           `json:"reconnects,omitempty"` // successful reconnections so far
+}
+
 // Session represents a client session
 type Session struct {
-	ClientID      string
-	CleanSession  bool
-	Subscriptions []Subscription
+	ClientID       string
+	CleanSession   bool
+	Subscriptions  []Subscription
+	ExpiryInterval uint32 // MQTT 5 Session Expiry Interval in seconds, 0 for MQTT 3 sessions that never expire
+	Will           *Will  // delayed will that was pending when the broker stopped, nil if none
+}
+
+// Will is a will waiting out its MQTT 5 Will Delay Interval, saved with its
+// session while the broker is stopped
+type Will struct {
+	Message    *Message
+	Properties []byte    // MQTT 5 will properties, encoded with their length prefix
+	Due        time.Time // when it is published unless the client reconnects first
 }
 
 // Subscription represents a topic subscription
 type Subscription struct {
-	Topic string
-	QoS   byte
+	Topic        string
+	QoS          byte // QoS granted by the broker
+	RequestedQoS byte // QoS requested by the client
+
+	// MQTT 5 subscription options
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+}
+
+// Presence is the last known connection state of a client
+type Presence struct {
+	ClientID       string
+	Username       string
+	RemoteAddr     string
+	Mountpoint     string // topic prefix of the client's virtual host
+	Online         bool
+	ConnectedAt    time.Time     // start of the current or last connection
+	LastSeen       time.Time     // when the last connection ended, or was last recorded
+	Connections    uint64        // connections accepted so far
+	TotalConnected time.Duration // time spent connected, excluding the current connection
+}
+
+// StatsSample is a snapshot of broker statistics. Counters cover the time
+// since the previous sample.
+type StatsSample struct {
+	Time          time.Time
+	Clients       int   // connected clients
+	Sessions      int   // persistent sessions of disconnected clients
+	Subscriptions int   // subscriptions of connected clients
+	Retained      int   // retained messages
+	MemoryBytes   int64 // memory held by queued, retained and inflight messages
+	Connections   uint64
+	MessagesIn    uint64
+	MessagesOut   uint64
+	BytesIn       uint64
+	BytesOut      uint64
+}
+
+// InflightMessage is a QoS 1/2 message sent to a client but not yet
+// acknowledged
+type InflightMessage struct {
+	PacketID uint16
+	Message  *Message
+	Released bool // QoS 2: PUBREC received and PUBREL sent, awaiting PUBCOMP
 }
 
 // Message represents an MQTT message
@@ -42,4 +142,5 @@ type Message struct {
 	Payload []byte
 	QoS     byte
 	Retain  bool
+	Hops    int `json:",omitempty"` // bridge hops of a message buffered by a bridge, counting the hop upstream
 }
@@ -0,0 +1,423 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errMemoryClosed is returned by a MemoryStore after Close
+var errMemoryClosed = errors.New("memory store is closed")
+
+// MemoryStore keeps everything in memory, so its data is lost when the
+// process exits. It behaves like BboltStore and suits tests and brokers
+// that need no persistence. Values are copied in and out, so callers cannot
+// change stored data through the pointers they pass or get.
+type MemoryStore struct {
+	mu       sync.Mutex
+	closed   bool
+	sessions map[string]*Session
+	queues   map[string][]*Message
+	retained map[string]*Message
+	inflight map[string][]*InflightMessage // clientID -> deliveries in send order
+	presence map[string]*Presence
+	stats    []*StatsSample // ordered by time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]*Session),
+		queues:   make(map[string][]*Message),
+		retained: make(map[string]*Message),
+		inflight: make(map[string][]*InflightMessage),
+		presence: make(map[string]*Presence),
+	}
+}
+
+// lock takes the store lock unless ctx has ended or the store is closed.
+// The caller must unlock mu if it returns nil.
+func (s *MemoryStore) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errMemoryClosed
+	}
+	return nil
+}
+
+func copySession(session *Session) *Session {
+	c := *session
+	c.Subscriptions = slices.Clone(session.Subscriptions)
+	if session.Will != nil {
+		will := *session.Will
+		will.Message = copyMessage(will.Message)
+		will.Properties = bytes.Clone(will.Properties)
+		c.Will = &will
+	}
+	return &c
+}
+
+func copyMessage(msg *Message) *Message {
+	c := *msg
+	c.Payload = bytes.Clone(msg.Payload)
+	return &c
+}
+
+func copyMessages(messages []*Message) []*Message {
+	copies := make([]*Message, len(messages))
+	for i, msg := range messages {
+		copies[i] = copyMessage(msg)
+	}
+	return copies
+}
+
+// SaveSession stores a client session
+func (s *MemoryStore) SaveSession(ctx context.Context, clientID string, session *Session) error {
+	if err := s.lock(ctx); err != nil {
+		return opError("save session", clientID, err)
+	}
+	defer s.mu.Unlock()
+	s.sessions[clientID] = copySession(session)
+	return nil
+}
+
+// LoadSession retrieves a client session
+func (s *MemoryStore) LoadSession(ctx context.Context, clientID string) (*Session, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("load session", clientID, err)
+	}
+	defer s.mu.Unlock()
+	session, ok := s.sessions[clientID]
+	if !ok {
+		return nil, opError("load session", clientID, ErrSessionNotFound)
+	}
+	return copySession(session), nil
+}
+
+// DeleteSession removes a client session together with its queued and
+// in-flight messages
+func (s *MemoryStore) DeleteSession(ctx context.Context, clientID string) error {
+	if err := s.lock(ctx); err != nil {
+		return opError("delete session", clientID, err)
+	}
+	defer s.mu.Unlock()
+	delete(s.sessions, clientID)
+	delete(s.queues, clientID)
+	delete(s.inflight, clientID)
+	return nil
+}
+
+// ListSessions returns all stored sessions ordered by client ID
+func (s *MemoryStore) ListSessions(ctx context.Context) ([]*Session, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("list sessions", "", err)
+	}
+	defer s.mu.Unlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, copySession(session))
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ClientID < sessions[j].ClientID })
+	return sessions, nil
+}
+
+// EnqueueMessage adds a message to a client's queue
+func (s *MemoryStore) EnqueueMessage(ctx context.Context, clientID string, msg *Message) error {
+	if err := s.lock(ctx); err != nil {
+		return opError("enqueue message", clientID, err)
+	}
+	defer s.mu.Unlock()
+	s.queues[clientID] = append(s.queues[clientID], copyMessage(msg))
+	return nil
+}
+
+// DequeueMessages retrieves all queued messages for a client
+func (s *MemoryStore) DequeueMessages(ctx context.Context, clientID string) ([]*Message, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("dequeue messages", clientID, err)
+	}
+	defer s.mu.Unlock()
+	messages := s.queues[clientID]
+	delete(s.queues, clientID)
+	return messages, nil
+}
+
+// PeekMessages retrieves all queued messages for a client without removing
+// them
+func (s *MemoryStore) PeekMessages(ctx context.Context, clientID string) ([]*Message, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("peek messages", clientID, err)
+	}
+	defer s.mu.Unlock()
+	if len(s.queues[clientID]) == 0 {
+		return nil, nil
+	}
+	return copyMessages(s.queues[clientID]), nil
+}
+
+// DequeueBatch removes and returns up to max of a client's oldest queued
+// messages
+func (s *MemoryStore) DequeueBatch(ctx context.Context, clientID string, max int) ([]*Message, error) {
+	if max < 1 {
+		return nil, opError("dequeue batch", clientID, fmt.Errorf("invalid batch size: %d", max))
+	}
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("dequeue batch", clientID, err)
+	}
+	defer s.mu.Unlock()
+	queue := s.queues[clientID]
+	n := min(max, len(queue))
+	if n == 0 {
+		return nil, nil
+	}
+	batch := queue[:n:n]
+	if n == len(queue) {
+		delete(s.queues, clientID)
+	} else {
+		s.queues[clientID] = queue[n:]
+	}
+	return batch, nil
+}
+
+// PeekQueue returns up to max of a client's queued messages, skipping the
+// offset oldest, without removing them
+func (s *MemoryStore) PeekQueue(ctx context.Context, clientID string, offset, max int) ([]*Message, error) {
+	if offset < 0 || max < 1 {
+		return nil, opError("peek queue", clientID, fmt.Errorf("invalid page: offset %d, max %d", offset, max))
+	}
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("peek queue", clientID, err)
+	}
+	defer s.mu.Unlock()
+	queue := s.queues[clientID]
+	if offset >= len(queue) {
+		return nil, nil
+	}
+	return copyMessages(queue[offset:min(offset+max, len(queue))]), nil
+}
+
+// QueueLen returns the number of a client's queued messages
+func (s *MemoryStore) QueueLen(ctx context.Context, clientID string) (int, error) {
+	if err := s.lock(ctx); err != nil {
+		return 0, opError("queue length", clientID, err)
+	}
+	defer s.mu.Unlock()
+	return len(s.queues[clientID]), nil
+}
+
+// StoreRetained stores a retained message for a topic
+func (s *MemoryStore) StoreRetained(ctx context.Context, topic string, msg *Message) error {
+	if err := s.lock(ctx); err != nil {
+		return opError("store retained", topic, err)
+	}
+	defer s.mu.Unlock()
+	s.retained[topic] = copyMessage(msg)
+	return nil
+}
+
+// GetRetained retrieves the retained message for a topic
+func (s *MemoryStore) GetRetained(ctx context.Context, topic string) (*Message, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("get retained", topic, err)
+	}
+	defer s.mu.Unlock()
+	msg, ok := s.retained[topic]
+	if !ok {
+		return nil, opError("get retained", topic, ErrRetainedNotFound)
+	}
+	return copyMessage(msg), nil
+}
+
+// PersistInflight stores an in-flight QoS 1/2 message. A message already
+// stored under the packet ID is replaced but keeps its place in the send
+// order.
+func (s *MemoryStore) PersistInflight(ctx context.Context, clientID string, msg *InflightMessage) error {
+	key := fmt.Sprintf("%s:%d", clientID, msg.PacketID)
+	if err := s.lock(ctx); err != nil {
+		return opError("persist inflight", key, err)
+	}
+	defer s.mu.Unlock()
+	stored := &InflightMessage{PacketID: msg.PacketID, Message: copyMessage(msg.Message), Released: msg.Released}
+	messages := s.inflight[clientID]
+	if i := slices.IndexFunc(messages, func(m *InflightMessage) bool { return m.PacketID == msg.PacketID }); i >= 0 {
+		messages[i] = stored
+		return nil
+	}
+	s.inflight[clientID] = append(messages, stored)
+	return nil
+}
+
+// ClearInflight removes an in-flight message after acknowledgment
+func (s *MemoryStore) ClearInflight(ctx context.Context, clientID string, packetID uint16) error {
+	key := fmt.Sprintf("%s:%d", clientID, packetID)
+	if err := s.lock(ctx); err != nil {
+		return opError("clear inflight", key, err)
+	}
+	defer s.mu.Unlock()
+	messages := slices.DeleteFunc(s.inflight[clientID], func(m *InflightMessage) bool { return m.PacketID == packetID })
+	if len(messages) == 0 {
+		delete(s.inflight, clientID)
+	} else {
+		s.inflight[clientID] = messages
+	}
+	return nil
+}
+
+// LoadInflight returns the in-flight messages of a client in the order they
+// were sent
+func (s *MemoryStore) LoadInflight(ctx context.Context, clientID string) ([]*InflightMessage, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("load inflight", clientID, err)
+	}
+	defer s.mu.Unlock()
+	messages := make([]*InflightMessage, len(s.inflight[clientID]))
+	for i, m := range s.inflight[clientID] {
+		messages[i] = &InflightMessage{PacketID: m.PacketID, Message: copyMessage(m.Message), Released: m.Released}
+	}
+	return messages, nil
+}
+
+// SavePresence stores the presence record of a client
+func (s *MemoryStore) SavePresence(ctx context.Context, p *Presence) error {
+	if err := s.lock(ctx); err != nil {
+		return opError("save presence", p.ClientID, err)
+	}
+	defer s.mu.Unlock()
+	c := *p
+	s.presence[p.ClientID] = &c
+	return nil
+}
+
+// ListPresence returns the presence records of all known clients ordered
+// by client ID
+func (s *MemoryStore) ListPresence(ctx context.Context) ([]*Presence, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("list presence", "", err)
+	}
+	defer s.mu.Unlock()
+	records := make([]*Presence, 0, len(s.presence))
+	for _, p := range s.presence {
+		c := *p
+		records = append(records, &c)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ClientID < records[j].ClientID })
+	return records, nil
+}
+
+// DeletePresence removes the presence record of a client
+func (s *MemoryStore) DeletePresence(ctx context.Context, clientID string) error {
+	if err := s.lock(ctx); err != nil {
+		return opError("delete presence", clientID, err)
+	}
+	defer s.mu.Unlock()
+	delete(s.presence, clientID)
+	return nil
+}
+
+// SaveStats stores a statistics sample, replacing one taken at the same
+// time
+func (s *MemoryStore) SaveStats(ctx context.Context, sample *StatsSample) error {
+	if err := s.lock(ctx); err != nil {
+		return opError("save stats", "", err)
+	}
+	defer s.mu.Unlock()
+	c := *sample
+	i, found := slices.BinarySearchFunc(s.stats, sample.Time, func(stored *StatsSample, t time.Time) int {
+		return stored.Time.Compare(t)
+	})
+	if found {
+		s.stats[i] = &c
+	} else {
+		s.stats = slices.Insert(s.stats, i, &c)
+	}
+	return nil
+}
+
+// LoadStats returns the statistics samples taken in [from, to)
+func (s *MemoryStore) LoadStats(ctx context.Context, from, to time.Time) ([]*StatsSample, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, opError("load stats", "", err)
+	}
+	defer s.mu.Unlock()
+	var samples []*StatsSample
+	for _, sample := range s.stats {
+		if !sample.Time.Before(from) && sample.Time.Before(to) {
+			c := *sample
+			samples = append(samples, &c)
+		}
+	}
+	return samples, nil
+}
+
+// PruneStats deletes the statistics samples taken before a time
+func (s *MemoryStore) PruneStats(ctx context.Context, before time.Time) (int, error) {
+	if err := s.lock(ctx); err != nil {
+		return 0, opError("prune stats", "", err)
+	}
+	defer s.mu.Unlock()
+	n := len(s.stats)
+	s.stats = slices.DeleteFunc(s.stats, func(sample *StatsSample) bool { return sample.Time.Before(before) })
+	return n - len(s.stats), nil
+}
+
+// Ping fails once the store is closed
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	if err := s.lock(ctx); err != nil {
+		return opError("ping", "", err)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// HealthCheck pings the store
+func (s *MemoryStore) HealthCheck(ctx context.Context) *Health {
+	start := time.Now()
+	err := s.Ping(ctx)
+	h := &Health{Up: err == nil, Latency: time.Since(start), CheckedAt: start}
+	if err != nil {
+		h.Error = err.Error()
+	}
+	return h
+}
+
+// Close drops the stored data. Later operations fail.
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.sessions, s.queues, s.retained, s.inflight, s.presence, s.stats = nil, nil, nil, nil, nil, nil
+	return nil
+}
+
+// Stats returns the number of stored entries of each kind, keyed like the
+// buckets of BboltStore
+func (s *MemoryStore) Stats() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queued, inflight := 0, 0
+	for _, queue := range s.queues {
+		queued += len(queue)
+	}
+	for _, messages := range s.inflight {
+		inflight += len(messages)
+	}
+	return map[string]interface{}{
+		"keys": map[string]int{
+			string(sessionsBucket): len(s.sessions),
+			string(messagesBucket): queued,
+			string(retainedBucket): len(s.retained),
+			string(inflightBucket): inflight,
+			string(presenceBucket): len(s.presence),
+			string(statsBucket):    len(s.stats),
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Defaults for opening a database
+const (
+	defaultLockTimeout  = 5 * time.Second
+	defaultRetryBackoff = time.Second
+)
+
+// OpenOptions control how a bbolt database file is opened. Zero values
+// select the defaults.
+type OpenOptions struct {
+	LockTimeout  time.Duration // how long each attempt waits for the file lock (default 5s)
+	LockRetries  int           // attempts after the first while the file is locked
+	RetryBackoff time.Duration // delay before the first retry, doubling after each (default 1s)
+}
+
+// openDB opens a database file, creating its directory first unless it is
+// opened read-only. A file locked by another process is retried with
+// backoff and then reported as ErrDatabaseLocked, rather than waiting
+// forever.
+func openDB(path string, readOnly bool, opts OpenOptions) (*bbolt.DB, error) {
+	// Accept forward slashes on Windows, as in the default configuration
+	path = filepath.Clean(filepath.FromSlash(path))
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return nil, fmt.Errorf("database path %s is a directory", path)
+	}
+	if !readOnly {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	timeout := opts.LockTimeout
+	if timeout <= 0 {
+		timeout = defaultLockTimeout
+	}
+	backoff := opts.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	started := time.Now()
+	for attempt := 0; ; attempt++ {
+		db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: timeout, ReadOnly: readOnly})
+		if err == nil {
+			return db, nil
+		}
+		if !errors.Is(err, bbolt.ErrTimeout) {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		if attempt >= opts.LockRetries {
+			return nil, fmt.Errorf("%w: %s (waited %s; is another broker instance running?)",
+				ErrDatabaseLocked, path, time.Since(started).Round(time.Millisecond))
+		}
+		log.Printf("Database %s is locked by another process, retrying in %s", path, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,479 @@
+package store
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states of a ReconnectingStore
+const (
+	CircuitClosed   = "closed"    // backend up, operations pass through
+	CircuitOpen     = "open"      // backend down, operations fail fast
+	CircuitHalfOpen = "half_open" // reconnecting after the cooldown
+)
+
+// Defaults for ReconnectOptions
+const (
+	defaultFailureThreshold = 3
+	defaultCooldown         = 10 * time.Second
+)
+
+// Dialer connects to a network backend such as Redis or PostgreSQL, giving
+// up when ctx ends
+type Dialer func(ctx context.Context) (Store, error)
+
+// ReconnectOptions control when a ReconnectingStore gives up on its backend
+// and how it degrades meanwhile. Zero values select the defaults.
+type ReconnectOptions struct {
+	FailureThreshold int           // consecutive failed health checks before the circuit opens (default 3)
+	Cooldown         time.Duration // wait after the circuit opens before reconnecting (default 10s)
+	ServeFromCache   bool          // while open, serve sessions and retained messages last read or written
+}
+
+// ReconnectingStore wraps a network backend with a circuit breaker. Health
+// checks drive it: once FailureThreshold checks in a row fail the backend
+// is closed and operations fail fast with ErrStoreUnavailable; after the
+// cooldown the next check dials a new connection and closes the circuit if
+// it answers a ping.
+type ReconnectingStore struct {
+	dial Dialer
+	opts ReconnectOptions
+
+	checking sync.Mutex // serializes health checks
+
+	mu         sync.RWMutex
+	backend    Store // nil while the circuit is open
+	circuit    string
+	failures   int
+	openedAt   time.Time
+	reconnects int
+	lastErr    error
+
+	cacheMu  sync.Mutex
+	sessions map[string]*Session
+	retained map[string]*Message
+}
+
+// NewReconnectingStore dials the backend, failing if it cannot be reached
+// at startup
+func NewReconnectingStore(ctx context.Context, dial Dialer, opts ReconnectOptions) (*ReconnectingStore, error) {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultFailureThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = defaultCooldown
+	}
+	backend, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectingStore{
+		dial:     dial,
+		opts:     opts,
+		backend:  backend,
+		circuit:  CircuitClosed,
+		sessions: make(map[string]*Session),
+		retained: make(map[string]*Message),
+	}, nil
+}
+
+// current returns the backend while the circuit is closed
+func (s *ReconnectingStore) current() (Store, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.circuit != CircuitClosed || s.backend == nil {
+		return nil, ErrStoreUnavailable
+	}
+	return s.backend, nil
+}
+
+// HealthCheck pings the backend, or reconnects once the circuit has been
+// open for the cooldown, and moves the circuit breaker accordingly
+func (s *ReconnectingStore) HealthCheck(ctx context.Context) *Health {
+	s.checking.Lock()
+	defer s.checking.Unlock()
+
+	s.mu.Lock()
+	if s.circuit == CircuitOpen && time.Since(s.openedAt) >= s.opts.Cooldown {
+		s.circuit = CircuitHalfOpen
+	}
+	circuit, backend := s.circuit, s.backend
+	s.mu.Unlock()
+
+	start := time.Now()
+	var err error
+	switch circuit {
+	case CircuitOpen:
+		err = ErrStoreUnavailable
+	case CircuitHalfOpen:
+		backend, err = s.reconnect(ctx)
+	default:
+		err = backend.Ping(ctx)
+	}
+	h := &Health{Latency: time.Since(start), CheckedAt: start}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil && circuit == CircuitHalfOpen:
+		s.backend = backend
+		s.circuit = CircuitClosed
+		s.failures = 0
+		s.reconnects++
+		log.Printf("Store reconnected, circuit closed")
+	case err == nil:
+		s.failures = 0
+	case circuit == CircuitOpen:
+		// Waiting for the cooldown; keep the error that opened the circuit
+	case circuit == CircuitHalfOpen:
+		s.lastErr = err
+		s.failures++
+		s.circuit = CircuitOpen
+		s.openedAt = time.Now()
+		log.Printf("Store reconnect failed, retrying in %s: %v", s.opts.Cooldown, err)
+	default:
+		s.lastErr = err
+		s.failures++
+		if s.failures >= s.opts.FailureThreshold {
+			s.circuit = CircuitOpen
+			s.openedAt = time.Now()
+			s.backend = nil
+			backend.Close()
+			log.Printf("Store down after %d failed health checks, circuit open: %v", s.failures, err)
+		}
+	}
+
+	h.Up = s.circuit == CircuitClosed && err == nil
+	h.Circuit = s.circuit
+	h.Failures = s.failures
+	h.Reconnects = s.reconnects
+	if !h.Up && s.lastErr != nil {
+		h.Error = s.lastErr.Error()
+	}
+	return h
+}
+
+// reconnect dials a new backend and checks that it answers
+func (s *ReconnectingStore) reconnect(ctx context.Context) (Store, error) {
+	backend, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.Ping(ctx); err != nil {
+		backend.Close()
+		return nil, err
+	}
+	return backend, nil
+}
+
+// Ping pings the backend while the circuit is closed
+func (s *ReconnectingStore) Ping(ctx context.Context) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("ping", "", err)
+	}
+	return b.Ping(ctx)
+}
+
+// SaveSession saves a session, caching it when serving from cache
+func (s *ReconnectingStore) SaveSession(ctx context.Context, clientID string, session *Session) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("save session", clientID, err)
+	}
+	if err := b.SaveSession(ctx, clientID, session); err != nil {
+		return err
+	}
+	s.cacheSession(clientID, session)
+	return nil
+}
+
+// LoadSession loads a session, falling back to the cache while the backend
+// is down
+func (s *ReconnectingStore) LoadSession(ctx context.Context, clientID string) (*Session, error) {
+	b, err := s.current()
+	if err != nil {
+		if session, ok := s.cachedSession(clientID); ok {
+			return session, nil
+		}
+		return nil, opError("load session", clientID, err)
+	}
+	session, err := b.LoadSession(ctx, clientID)
+	if err == nil {
+		s.cacheSession(clientID, session)
+	}
+	return session, err
+}
+
+// DeleteSession deletes a session with its queued and in-flight messages
+func (s *ReconnectingStore) DeleteSession(ctx context.Context, clientID string) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("delete session", clientID, err)
+	}
+	if err := b.DeleteSession(ctx, clientID); err != nil {
+		return err
+	}
+	s.cacheSession(clientID, nil)
+	return nil
+}
+
+// ListSessions lists all sessions, falling back to the cache while the
+// backend is down
+func (s *ReconnectingStore) ListSessions(ctx context.Context) ([]*Session, error) {
+	b, err := s.current()
+	if err != nil {
+		if !s.opts.ServeFromCache {
+			return nil, opError("list sessions", "", err)
+		}
+		s.cacheMu.Lock()
+		defer s.cacheMu.Unlock()
+		sessions := make([]*Session, 0, len(s.sessions))
+		for _, session := range s.sessions {
+			sessions = append(sessions, session)
+		}
+		return sessions, nil
+	}
+	return b.ListSessions(ctx)
+}
+
+// EnqueueMessage queues a message for an offline client
+func (s *ReconnectingStore) EnqueueMessage(ctx context.Context, clientID string, msg *Message) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("enqueue message", clientID, err)
+	}
+	return b.EnqueueMessage(ctx, clientID, msg)
+}
+
+// DequeueMessages removes and returns a client's queued messages
+func (s *ReconnectingStore) DequeueMessages(ctx context.Context, clientID string) ([]*Message, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, opError("dequeue messages", clientID, err)
+	}
+	return b.DequeueMessages(ctx, clientID)
+}
+
+// PeekMessages returns a client's queued messages
+func (s *ReconnectingStore) PeekMessages(ctx context.Context, clientID string) ([]*Message, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, opError("peek messages", clientID, err)
+	}
+	return b.PeekMessages(ctx, clientID)
+}
+
+// DequeueBatch removes and returns up to max of a client's oldest queued
+// messages
+func (s *ReconnectingStore) DequeueBatch(ctx context.Context, clientID string, max int) ([]*Message, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, opError("dequeue batch", clientID, err)
+	}
+	return b.DequeueBatch(ctx, clientID, max)
+}
+
+// PeekQueue returns a page of a client's queued messages
+func (s *ReconnectingStore) PeekQueue(ctx context.Context, clientID string, offset, max int) ([]*Message, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, opError("peek queue", clientID, err)
+	}
+	return b.PeekQueue(ctx, clientID, offset, max)
+}
+
+// QueueLen returns the number of a client's queued messages
+func (s *ReconnectingStore) QueueLen(ctx context.Context, clientID string) (int, error) {
+	b, err := s.current()
+	if err != nil {
+		return 0, opError("queue length", clientID, err)
+	}
+	return b.QueueLen(ctx, clientID)
+}
+
+// StoreRetained stores a retained message, caching it when serving from
+// cache
+func (s *ReconnectingStore) StoreRetained(ctx context.Context, topic string, msg *Message) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("store retained", topic, err)
+	}
+	if err := b.StoreRetained(ctx, topic, msg); err != nil {
+		return err
+	}
+	s.cacheRetained(topic, msg)
+	return nil
+}
+
+// GetRetained retrieves a retained message, falling back to the cache while
+// the backend is down
+func (s *ReconnectingStore) GetRetained(ctx context.Context, topic string) (*Message, error) {
+	b, err := s.current()
+	if err != nil {
+		if msg, ok := s.cachedRetained(topic); ok {
+			return msg, nil
+		}
+		return nil, opError("get retained", topic, err)
+	}
+	msg, err := b.GetRetained(ctx, topic)
+	if err == nil {
+		s.cacheRetained(topic, msg)
+	}
+	return msg, err
+}
+
+// PersistInflight stores an in-flight QoS 1/2 message
+func (s *ReconnectingStore) PersistInflight(ctx context.Context, clientID string, msg *InflightMessage) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("persist inflight", clientID, err)
+	}
+	return b.PersistInflight(ctx, clientID, msg)
+}
+
+// ClearInflight removes an acknowledged in-flight message
+func (s *ReconnectingStore) ClearInflight(ctx context.Context, clientID string, packetID uint16) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("clear inflight", clientID, err)
+	}
+	return b.ClearInflight(ctx, clientID, packetID)
+}
+
+// LoadInflight returns a client's in-flight messages
+func (s *ReconnectingStore) LoadInflight(ctx context.Context, clientID string) ([]*InflightMessage, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, opError("load inflight", clientID, err)
+	}
+	return b.LoadInflight(ctx, clientID)
+}
+
+// SavePresence records a client's connection state
+func (s *ReconnectingStore) SavePresence(ctx context.Context, p *Presence) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("save presence", p.ClientID, err)
+	}
+	return b.SavePresence(ctx, p)
+}
+
+// ListPresence returns the connection state of all known clients
+func (s *ReconnectingStore) ListPresence(ctx context.Context) ([]*Presence, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, opError("list presence", "", err)
+	}
+	return b.ListPresence(ctx)
+}
+
+// DeletePresence forgets a client's connection state
+func (s *ReconnectingStore) DeletePresence(ctx context.Context, clientID string) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("delete presence", clientID, err)
+	}
+	return b.DeletePresence(ctx, clientID)
+}
+
+// SaveStats records a statistics sample
+func (s *ReconnectingStore) SaveStats(ctx context.Context, sample *StatsSample) error {
+	b, err := s.current()
+	if err != nil {
+		return opError("save stats", "", err)
+	}
+	return b.SaveStats(ctx, sample)
+}
+
+// LoadStats returns the statistics samples taken in [from, to)
+func (s *ReconnectingStore) LoadStats(ctx context.Context, from, to time.Time) ([]*StatsSample, error) {
+	b, err := s.current()
+	if err != nil {
+		return nil, opError("load stats", "", err)
+	}
+	return b.LoadStats(ctx, from, to)
+}
+
+// PruneStats deletes the statistics samples taken before a time
+func (s *ReconnectingStore) PruneStats(ctx context.Context, before time.Time) (int, error) {
+	b, err := s.current()
+	if err != nil {
+		return 0, opError("prune stats", "", err)
+	}
+	return b.PruneStats(ctx, before)
+}
+
+// Close closes the backend, if connected
+func (s *ReconnectingStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	backend := s.backend
+	s.backend = nil
+	s.circuit = CircuitOpen
+	if backend == nil {
+		return nil
+	}
+	return backend.Close()
+}
+
+// Stats returns the backend's statistics, if it has any, and the circuit
+// breaker state
+func (s *ReconnectingStore) Stats() map[string]interface{} {
+	s.mu.RLock()
+	backend, circuit, reconnects := s.backend, s.circuit, s.reconnects
+	s.mu.RUnlock()
+
+	stats := make(map[string]interface{})
+	if b, ok := backend.(interface{ Stats() map[string]interface{} }); ok {
+		stats = b.Stats()
+	}
+	stats["circuit"] = circuit
+	stats["reconnects"] = reconnects
+	return stats
+}
+
+// cacheSession remembers a session, or forgets it if session is nil
+func (s *ReconnectingStore) cacheSession(clientID string, session *Session) {
+	if !s.opts.ServeFromCache {
+		return
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if session == nil {
+		delete(s.sessions, clientID)
+		return
+	}
+	s.sessions[clientID] = session
+}
+
+func (s *ReconnectingStore) cachedSession(clientID string) (*Session, bool) {
+	if !s.opts.ServeFromCache {
+		return nil, false
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	session, ok := s.sessions[clientID]
+	return session, ok
+}
+
+func (s *ReconnectingStore) cacheRetained(topic string, msg *Message) {
+	if !s.opts.ServeFromCache {
+		return
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.retained[topic] = msg
+}
+
+func (s *ReconnectingStore) cachedRetained(topic string) (*Message, bool) {
+	if !s.opts.ServeFromCache {
+		return nil, false
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	msg, ok := s.retained[topic]
+	return msg, ok
+}
@@ -0,0 +1,153 @@
+// Package timeseries exports numeric telemetry published to the broker to
+// a time-series database using the InfluxDB line protocol.
+package timeseries
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/metrics"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// Exporter batches points built from matching messages and writes them to
+// the configured line-protocol endpoint
+type Exporter struct {
+	cfg    config.TimeSeriesConfig
+	rules  []*rule
+	client *http.Client
+	lines  chan string
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New creates an exporter for the configured rules
+func New(cfg config.TimeSeriesConfig) *Exporter {
+	e := &Exporter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		lines:  make(chan string, cfg.QueueSize),
+		done:   make(chan struct{}),
+	}
+	for _, r := range cfg.Rules {
+		e.rules = append(e.rules, newRule(r))
+	}
+	return e
+}
+
+// Start registers the exporter with the broker and starts the writer
+func (e *Exporter) Start(broker *server.Server) {
+	broker.AddPublishHook(e.onPublish)
+	e.wg.Add(1)
+	go e.run()
+	log.Printf("Time-series exporter writing to %s", e.cfg.URL)
+}
+
+// Stop writes the pending batch and stops the writer
+func (e *Exporter) Stop() {
+	close(e.done)
+	e.wg.Wait()
+}
+
+// onPublish converts a message using the first matching rule
+func (e *Exporter) onPublish(msg *server.Message) {
+	for _, r := range e.rules {
+		if !server.TopicMatch(r.Filter, msg.Topic) {
+			continue
+		}
+		line := r.line(msg, time.Now())
+		if line == "" {
+			metrics.TimeSeriesPoints.WithLabelValues("skipped").Inc()
+			return
+		}
+		select {
+		case e.lines <- line:
+		default:
+			metrics.TimeSeriesPoints.WithLabelValues("dropped").Inc()
+		}
+		return
+	}
+}
+
+// run collects points into batches, written when full or on every flush
+// interval
+func (e *Exporter) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var batch bytes.Buffer
+	points := 0
+	flush := func() {
+		if points == 0 {
+			return
+		}
+		if err := e.write(batch.Bytes()); err != nil {
+			log.Printf("Time-series exporter: failed to write %d points: %v", points, err)
+			metrics.TimeSeriesPoints.WithLabelValues("failed").Add(float64(points))
+		} else {
+			metrics.TimeSeriesPoints.WithLabelValues("written").Add(float64(points))
+		}
+		batch.Reset()
+		points = 0
+	}
+
+	for {
+		select {
+		case line := <-e.lines:
+			batch.WriteString(line)
+			batch.WriteByte('\n')
+			points++
+			if points >= e.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			for {
+				select {
+				case line := <-e.lines:
+					batch.WriteString(line)
+					batch.WriteByte('\n')
+					points++
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write posts a batch of points to the endpoint
+func (e *Exporter) write(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+e.cfg.Token)
+	} else if e.cfg.Username != "" {
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package timeseries
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// rule maps messages on a topic filter to points of a measurement
+type rule struct {
+	config.TimeSeriesRuleConfig
+	tagKeys []string // sorted, as recommended for line protocol
+}
+
+func newRule(cfg config.TimeSeriesRuleConfig) *rule {
+	r := &rule{TimeSeriesRuleConfig: cfg}
+	for key := range cfg.Tags {
+		r.tagKeys = append(r.tagKeys, key)
+	}
+	sort.Strings(r.tagKeys)
+	return r
+}
+
+// expand replaces "{n}" with the n-th level (1-based) of the topic
+func expand(template string, levels []string) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		b.WriteString(template[:start])
+		if n, err := strconv.Atoi(template[start+1 : end]); err == nil && n >= 1 && n <= len(levels) {
+			b.WriteString(levels[n-1])
+		} else {
+			b.WriteString(template[start : end+1])
+		}
+		template = template[end+1:]
+	}
+	b.WriteString(template)
+	return b.String()
+}
+
+// fields extracts numeric fields from a payload. A bare number becomes the
+// field "value"; nested JSON objects are flattened with "_" separators.
+// Non-numeric values are ignored.
+func fields(payload []byte) map[string]float64 {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return nil
+	}
+	out := make(map[string]float64)
+	collect(out, "", v)
+	return out
+}
+
+func collect(out map[string]float64, prefix string, v interface{}) {
+	switch v := v.(type) {
+	case float64:
+		if prefix == "" {
+			prefix = "value"
+		}
+		out[prefix] = v
+	case map[string]interface{}:
+		for key, child := range v {
+			if prefix != "" {
+				key = prefix + "_" + key
+			}
+			collect(out, key, child)
+		}
+	}
+}
+
+// line encodes a message as a line-protocol point. It returns "" if the
+// payload holds no numeric fields.
+func (r *rule) line(msg *server.Message, ts time.Time) string {
+	values := fields(msg.Payload)
+	if len(values) == 0 {
+		return ""
+	}
+	levels := strings.Split(msg.Topic, "/")
+
+	var b strings.Builder
+	b.WriteString(escape(expand(r.Measurement, levels), ", "))
+	for _, key := range r.tagKeys {
+		value := expand(r.Tags[key], levels)
+		if value == "" {
+			continue // empty tag values are not allowed
+		}
+		b.WriteByte(',')
+		b.WriteString(escape(key, ",= "))
+		b.WriteByte('=')
+		b.WriteString(escape(value, ",= "))
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(escape(key, ",= "))
+		b.WriteByte('=')
+		b.WriteString(strconv.FormatFloat(values[key], 'f', -1, 64))
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(ts.UnixNano(), 10))
+	return b.String()
+}
+
+// escape backslash-escapes the given special characters
+func escape(s, special string) string {
+	if !strings.ContainsAny(s, special+"\\") {
+		return s
+	}
+	var b strings.Builder
+	for _, c := range s {
+		if c == '\\' || strings.ContainsRune(special, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,271 @@
+package transcode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// cborIndefinite is the additional information of indefinite-length items
+const cborIndefinite = 31
+
+// cborBreak ends an indefinite-length item
+const cborBreak = 0xff
+
+// appendCBORHead appends the head of a data item with an argument, in the
+// shortest form
+func appendCBORHead(buf []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(buf, major|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(buf, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, major|27), arg)
+}
+
+// appendCBOR appends the CBOR encoding of a value
+func appendCBOR(buf []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xf6)
+	case bool:
+		if v {
+			return append(buf, 0xf5)
+		}
+		return append(buf, 0xf4)
+	case int64:
+		if v < 0 {
+			return appendCBORHead(buf, cborNegint, uint64(-1-v))
+		}
+		return appendCBORHead(buf, cborUint, uint64(v))
+	case uint64:
+		return appendCBORHead(buf, cborUint, v)
+	case float64:
+		if smallFloat(v) {
+			return binary.BigEndian.AppendUint32(append(buf, 0xfa), math.Float32bits(float32(v)))
+		}
+		return binary.BigEndian.AppendUint64(append(buf, 0xfb), math.Float64bits(v))
+	case string:
+		return append(appendCBORHead(buf, cborText, uint64(len(v))), v...)
+	case []any:
+		buf = appendCBORHead(buf, cborArray, uint64(len(v)))
+		for _, elem := range v {
+			buf = appendCBOR(buf, elem)
+		}
+		return buf
+	case []member:
+		buf = appendCBORHead(buf, cborMap, uint64(len(v)))
+		for _, m := range v {
+			buf = append(appendCBORHead(buf, cborText, uint64(len(m.key))), m.key...)
+			buf = appendCBOR(buf, m.value)
+		}
+		return buf
+	}
+	panic(fmt.Sprintf("transcode: unsupported value %T", v))
+}
+
+// decodeCBORHead reads the head of a data item and returns its major type,
+// additional information and argument
+func decodeCBORHead(data []byte) (major, info byte, arg uint64, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, nil, errTruncated
+	}
+	major, info, data = data[0]>>5, data[0]&0x1f, data[1:]
+	switch {
+	case info < 24:
+		return major, info, uint64(info), data, nil
+	case info == cborIndefinite:
+		return major, info, 0, data, nil
+	case info > 27:
+		return 0, 0, 0, nil, fmt.Errorf("reserved additional information %d", info)
+	}
+	size := 1 << (info - 24)
+	if len(data) < size {
+		return 0, 0, 0, nil, errTruncated
+	}
+	switch size {
+	case 1:
+		arg = uint64(data[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data))
+	default:
+		arg = binary.BigEndian.Uint64(data)
+	}
+	return major, info, arg, data[size:], nil
+}
+
+// decodeCBOR decodes one data item. Tags are dropped; undefined becomes
+// null.
+func decodeCBOR(data []byte, depth int) (any, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, fmt.Errorf("nesting deeper than %d levels", maxDepth)
+	}
+	major, info, arg, data, err := decodeCBORHead(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	indefinite := info == cborIndefinite
+	if indefinite && (major == cborUint || major == cborNegint || major == cborTag) {
+		return nil, nil, fmt.Errorf("indefinite length for major type %d", major)
+	}
+
+	switch major {
+	case cborUint:
+		if arg <= math.MaxInt64 {
+			return int64(arg), data, nil
+		}
+		return arg, data, nil
+	case cborNegint:
+		if arg > math.MaxInt64 {
+			return float64(-1) - float64(arg), data, nil
+		}
+		return -1 - int64(arg), data, nil
+	case cborBytes, cborText:
+		var s []byte
+		if indefinite {
+			s, data, err = decodeCBORChunks(data, major)
+		} else {
+			if arg > uint64(len(data)) {
+				return nil, nil, errTruncated
+			}
+			s, data = data[:arg], data[arg:]
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if major == cborText {
+			return string(s), data, nil
+		}
+		return append([]byte(nil), s...), data, nil
+	case cborArray:
+		if !indefinite && arg > uint64(len(data)) {
+			return nil, nil, errTruncated
+		}
+		arr := []any{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite && len(data) > 0 && data[0] == cborBreak {
+				data = data[1:]
+				break
+			}
+			var elem any
+			if elem, data, err = decodeCBOR(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, elem)
+		}
+		return arr, data, nil
+	case cborMap:
+		if !indefinite && arg > uint64(len(data))/2 {
+			return nil, nil, errTruncated
+		}
+		obj := []member{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite && len(data) > 0 && data[0] == cborBreak {
+				data = data[1:]
+				break
+			}
+			var key, value any
+			if key, data, err = decodeCBOR(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			if value, data, err = decodeCBOR(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			name, err := mapKey(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			obj = append(obj, member{key: name, value: value})
+		}
+		return obj, data, nil
+	case cborTag:
+		return decodeCBOR(data, depth+1)
+	}
+
+	// Major type 7: simple values and floats
+	switch info {
+	case 20:
+		return false, data, nil
+	case 21:
+		return true, data, nil
+	case 22, 23:
+		return nil, data, nil
+	case 25:
+		return halfFloat(uint16(arg)), data, nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), data, nil
+	case 27:
+		return math.Float64frombits(arg), data, nil
+	case cborIndefinite:
+		return nil, nil, fmt.Errorf("unexpected break")
+	}
+	return nil, nil, fmt.Errorf("unsupported simple value %d", arg)
+}
+
+// decodeCBORChunks joins the definite-length chunks of an indefinite-length
+// byte or text string
+func decodeCBORChunks(data []byte, major byte) ([]byte, []byte, error) {
+	var s []byte
+	for {
+		if len(data) == 0 {
+			return nil, nil, errTruncated
+		}
+		if data[0] == cborBreak {
+			return s, data[1:], nil
+		}
+		chunkMajor, info, arg, rest, err := decodeCBORHead(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if chunkMajor != major || info == cborIndefinite {
+			return nil, nil, fmt.Errorf("invalid chunk in indefinite-length string")
+		}
+		if arg > uint64(len(rest)) {
+			return nil, nil, errTruncated
+		}
+		s, data = append(s, rest[:arg]...), rest[arg:]
+	}
+}
+
+// halfFloat converts an IEEE 754 half-precision float
+func halfFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
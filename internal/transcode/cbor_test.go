@@ -0,0 +1,115 @@
+package transcode
+
+import "testing"
+
+// TestCBORVectors tests encoding JSON to CBOR and back against the examples
+// of RFC 8949 Appendix A and further vectors at the argument boundaries
+func TestCBORVectors(t *testing.T) {
+	testVectors(t, CBOR, []vector{
+		// Integers in the shortest head
+		{json: `0`, hex: "00"},
+		{json: `23`, hex: "17"},
+		{json: `24`, hex: "1818"},
+		{json: `255`, hex: "18ff"},
+		{json: `256`, hex: "190100"},
+		{json: `65535`, hex: "19ffff"},
+		{json: `65536`, hex: "1a00010000"},
+		{json: `4294967295`, hex: "1affffffff"},
+		{json: `4294967296`, hex: "1b0000000100000000"},
+		{json: `9223372036854775807`, hex: "1b7fffffffffffffff"},
+		{json: `18446744073709551615`, hex: "1bffffffffffffffff"},
+		{json: `-1`, hex: "20"},
+		{json: `-24`, hex: "37"},
+		{json: `-25`, hex: "3818"},
+		{json: `-256`, hex: "38ff"},
+		{json: `-257`, hex: "390100"},
+		{json: `-65537`, hex: "3a00010000"},
+		{json: `-9223372036854775808`, hex: "3b7fffffffffffffff"},
+
+		// Floats stay floats, in four bytes when that is exact
+		{json: `1.5`, hex: "fa3fc00000"},
+		{json: `1.0`, hex: "fa3f800000", back: `1`},
+		{json: `-4.0`, hex: "fac0800000", back: `-4`},
+		{json: `100000.0`, hex: "fa47c35000", back: `100000`},
+		{json: `1.1`, hex: "fb3ff199999999999a"},
+		{json: `-4.1`, hex: "fbc010666666666666"},
+		{json: `1e300`, hex: "fb7e37e43c8800759c", back: `1e+300`},
+		{json: `18446744073709551616`, hex: "fa5f800000", back: `18446744073709552000`},
+
+		{json: `false`, hex: "f4"},
+		{json: `true`, hex: "f5"},
+		{json: `null`, hex: "f6"},
+
+		// Text lengths count bytes, not characters
+		{json: `""`, hex: "60"},
+		{json: `"a"`, hex: "6161"},
+		{json: `"IETF"`, hex: "6449455446"},
+		{json: `"\"\\"`, hex: "62225c"},
+		{json: `"ü"`, hex: "62c3bc"},
+		{json: `"水"`, hex: "63e6b0b4"},
+		{json: `"𐅑"`, hex: "64f0908591"},
+		{json: `"żółw"`, hex: "67c5bcc3b3c58277"},
+
+		// Nested arrays and maps, keeping the order of map keys
+		{json: `[]`, hex: "80"},
+		{json: `[1,2,3]`, hex: "83010203"},
+		{json: `[1,[2,3],[4,5]]`, hex: "8301820203820405"},
+		{json: `{}`, hex: "a0"},
+		{json: `{"a":1,"b":[2,3]}`, hex: "a26161016162820203"},
+		{json: `["a",{"b":"c"}]`, hex: "826161a161626163"},
+		{json: `{"b":1,"a":2}`, hex: "a2616201616102"},
+		{json: `{"a":[1,{"b":null,"c":[1.5,true]}]}`, hex: "a161618201a26162f6616382fa3fc00000f5"},
+	})
+}
+
+// TestCBORLengths tests the head chosen for text, array and map lengths at
+// the boundaries of the inline, 8, 16 and 32 bit arguments
+func TestCBORLengths(t *testing.T) {
+	testLengths(t, CBOR, []lengthCase{
+		{"string", 23, "77"},
+		{"string", 24, "7818"},
+		{"string", 255, "78ff"},
+		{"string", 256, "790100"},
+		{"string", 65535, "79ffff"},
+		{"string", 65536, "7a00010000"},
+		{"array", 23, "97"},
+		{"array", 24, "9818"},
+		{"array", 255, "98ff"},
+		{"array", 256, "990100"},
+		{"array", 65535, "99ffff"},
+		{"array", 65536, "9a00010000"},
+		{"object", 23, "b7"},
+		{"object", 24, "b818"},
+		{"object", 255, "b8ff"},
+		{"object", 256, "b90100"},
+		{"object", 65535, "b9ffff"},
+		{"object", 65536, "ba00010000"},
+	}, "\x66")
+}
+
+// TestCBORDecode tests decoding encodings the encoder does not produce:
+// half floats, non-shortest heads, indefinite lengths, byte strings, tags
+// and undefined
+func TestCBORDecode(t *testing.T) {
+	testDecodes(t, CBOR, []vector{
+		{json: `0`, hex: "f90000"},
+		{json: `-0`, hex: "f98000"},
+		{json: `1`, hex: "f93c00"},
+		{json: `1.5`, hex: "f93e00"},
+		{json: `65504`, hex: "f97bff"},
+		{json: `5.960464477539063e-8`, hex: "f90001"},
+		{json: `-4`, hex: "f9c400"},
+		{json: `1`, hex: "1b0000000000000001"},
+		{json: `-18446744073709552000`, hex: "3bffffffffffffffff"},
+		{json: `"AQIDBA=="`, hex: "4401020304"},
+		{json: `"AQIDBAU="`, hex: "5f42010243030405ff"},
+		{json: `"streaming"`, hex: "7f657374726561646d696e67ff"},
+		{json: `[]`, hex: "9fff"},
+		{json: `[1,[2,3],[4,5]]`, hex: "9f018202039f0405ffff"},
+		{json: `{"a":1,"b":[2,3]}`, hex: "bf61610161629f0203ffff"},
+		{json: `{"1":2,"3":4}`, hex: "a201020304"},
+		{json: `1363896240`, hex: "c11a514b67b0"},
+		{json: `"2013-03-21T20:04:00Z"`, hex: "c074323031332d30332d32315432303a30343a30305a"},
+		{json: `null`, hex: "f7"},
+	})
+}
@@ -0,0 +1,236 @@
+package transcode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// appendMsgPackHead appends a length in the shortest of a fix, 8, 16 or 32
+// bit form. fix is the fixed-size type byte (0 when there is none) and
+// fixMax its largest length; first is the 8 bit form's type byte (0 when
+// there is none), followed by the 16 and 32 bit forms.
+func appendMsgPackHead(buf []byte, n int, fix byte, fixMax int, first, next byte) []byte {
+	switch {
+	case fix != 0 && n <= fixMax:
+		return append(buf, fix|byte(n))
+	case first != 0 && n <= math.MaxUint8:
+		return append(buf, first, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, next), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, next+1), uint32(n))
+}
+
+// appendMsgPack appends the MessagePack encoding of a value
+func appendMsgPack(buf []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xc0)
+	case bool:
+		if v {
+			return append(buf, 0xc3)
+		}
+		return append(buf, 0xc2)
+	case int64:
+		switch {
+		case v >= 0:
+			return appendMsgPackUint(buf, uint64(v))
+		case v >= -32:
+			return append(buf, byte(v))
+		case v >= math.MinInt8:
+			return append(buf, 0xd0, byte(v))
+		case v >= math.MinInt16:
+			return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(v))
+		case v >= math.MinInt32:
+			return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(v))
+		}
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(v))
+	case uint64:
+		return appendMsgPackUint(buf, v)
+	case float64:
+		if smallFloat(v) {
+			return binary.BigEndian.AppendUint32(append(buf, 0xca), math.Float32bits(float32(v)))
+		}
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(v))
+	case string:
+		return append(appendMsgPackHead(buf, len(v), 0xa0, 31, 0xd9, 0xda), v...)
+	case []any:
+		buf = appendMsgPackHead(buf, len(v), 0x90, 15, 0, 0xdc)
+		for _, elem := range v {
+			buf = appendMsgPack(buf, elem)
+		}
+		return buf
+	case []member:
+		buf = appendMsgPackHead(buf, len(v), 0x80, 15, 0, 0xde)
+		for _, m := range v {
+			buf = append(appendMsgPackHead(buf, len(m.key), 0xa0, 31, 0xd9, 0xda), m.key...)
+			buf = appendMsgPack(buf, m.value)
+		}
+		return buf
+	}
+	panic(fmt.Sprintf("transcode: unsupported value %T", v))
+}
+
+// appendMsgPackUint appends a non-negative integer
+func appendMsgPackUint(buf []byte, v uint64) []byte {
+	switch {
+	case v <= 0x7f:
+		return append(buf, byte(v))
+	case v <= math.MaxUint8:
+		return append(buf, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xcf), v)
+}
+
+// msgPackUint reads a big-endian unsigned integer of size bytes
+func msgPackUint(data []byte, size int) (uint64, []byte, error) {
+	if len(data) < size {
+		return 0, nil, errTruncated
+	}
+	var v uint64
+	for _, b := range data[:size] {
+		v = v<<8 | uint64(b)
+	}
+	return v, data[size:], nil
+}
+
+// decodeMsgPack decodes one value. Extension types are not supported.
+func decodeMsgPack(data []byte, depth int) (any, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, fmt.Errorf("nesting deeper than %d levels", maxDepth)
+	}
+	if len(data) == 0 {
+		return nil, nil, errTruncated
+	}
+	b, data := data[0], data[1:]
+
+	var (
+		n   uint64
+		err error
+	)
+	switch {
+	case b <= 0x7f:
+		return int64(b), data, nil
+	case b >= 0xe0:
+		return int64(int8(b)), data, nil
+	case b&0xe0 == 0xa0:
+		return msgPackString(data, uint64(b&0x1f))
+	case b&0xf0 == 0x90:
+		return msgPackArray(data, uint64(b&0x0f), depth)
+	case b&0xf0 == 0x80:
+		return msgPackMap(data, uint64(b&0x0f), depth)
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, data, nil
+	case 0xc2:
+		return false, data, nil
+	case 0xc3:
+		return true, data, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8/16/32
+		if n, data, err = msgPackUint(data, 1<<(b-0xc4)); err != nil {
+			return nil, nil, err
+		}
+		if n > uint64(len(data)) {
+			return nil, nil, errTruncated
+		}
+		return append([]byte(nil), data[:n]...), data[n:], nil
+	case 0xca:
+		if n, data, err = msgPackUint(data, 4); err != nil {
+			return nil, nil, err
+		}
+		return float64(math.Float32frombits(uint32(n))), data, nil
+	case 0xcb:
+		if n, data, err = msgPackUint(data, 8); err != nil {
+			return nil, nil, err
+		}
+		return math.Float64frombits(n), data, nil
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint 8/16/32/64
+		if n, data, err = msgPackUint(data, 1<<(b-0xcc)); err != nil {
+			return nil, nil, err
+		}
+		if n <= math.MaxInt64 {
+			return int64(n), data, nil
+		}
+		return n, data, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3: // int 8/16/32/64
+		size := 1 << (b - 0xd0)
+		if n, data, err = msgPackUint(data, size); err != nil {
+			return nil, nil, err
+		}
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, data, nil
+	case 0xd9, 0xda, 0xdb: // str 8/16/32
+		if n, data, err = msgPackUint(data, 1<<(b-0xd9)); err != nil {
+			return nil, nil, err
+		}
+		return msgPackString(data, n)
+	case 0xdc, 0xdd: // array 16/32
+		if n, data, err = msgPackUint(data, 2<<(b-0xdc)); err != nil {
+			return nil, nil, err
+		}
+		return msgPackArray(data, n, depth)
+	case 0xde, 0xdf: // map 16/32
+		if n, data, err = msgPackUint(data, 2<<(b-0xde)); err != nil {
+			return nil, nil, err
+		}
+		return msgPackMap(data, n, depth)
+	}
+	return nil, nil, fmt.Errorf("unsupported type byte 0x%02x", b)
+}
+
+func msgPackString(data []byte, n uint64) (any, []byte, error) {
+	if n > uint64(len(data)) {
+		return nil, nil, errTruncated
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+func msgPackArray(data []byte, n uint64, depth int) (any, []byte, error) {
+	if n > uint64(len(data)) {
+		return nil, nil, errTruncated
+	}
+	arr := make([]any, 0, n)
+	for range n {
+		var (
+			elem any
+			err  error
+		)
+		if elem, data, err = decodeMsgPack(data, depth+1); err != nil {
+			return nil, nil, err
+		}
+		arr = append(arr, elem)
+	}
+	return arr, data, nil
+}
+
+func msgPackMap(data []byte, n uint64, depth int) (any, []byte, error) {
+	if n > uint64(len(data))/2 {
+		return nil, nil, errTruncated
+	}
+	obj := make([]member, 0, n)
+	for range n {
+		var (
+			key, value any
+			err        error
+		)
+		if key, data, err = decodeMsgPack(data, depth+1); err != nil {
+			return nil, nil, err
+		}
+		if value, data, err = decodeMsgPack(data, depth+1); err != nil {
+			return nil, nil, err
+		}
+		name, err := mapKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		obj = append(obj, member{key: name, value: value})
+	}
+	return obj, data, nil
+}
@@ -0,0 +1,99 @@
+package transcode
+
+import "testing"
+
+// TestMsgPackVectors tests encoding JSON to MessagePack and back against
+// vectors at the boundaries of each integer and float form
+func TestMsgPackVectors(t *testing.T) {
+	testVectors(t, MsgPack, []vector{
+		// Integers in the smallest form
+		{json: `0`, hex: "00"},
+		{json: `127`, hex: "7f"},
+		{json: `128`, hex: "cc80"},
+		{json: `255`, hex: "ccff"},
+		{json: `256`, hex: "cd0100"},
+		{json: `65535`, hex: "cdffff"},
+		{json: `65536`, hex: "ce00010000"},
+		{json: `4294967295`, hex: "ceffffffff"},
+		{json: `4294967296`, hex: "cf0000000100000000"},
+		{json: `18446744073709551615`, hex: "cfffffffffffffffff"},
+		{json: `-1`, hex: "ff"},
+		{json: `-32`, hex: "e0"},
+		{json: `-33`, hex: "d0df"},
+		{json: `-128`, hex: "d080"},
+		{json: `-129`, hex: "d1ff7f"},
+		{json: `-32768`, hex: "d18000"},
+		{json: `-32769`, hex: "d2ffff7fff"},
+		{json: `-2147483648`, hex: "d280000000"},
+		{json: `-2147483649`, hex: "d3ffffffff7fffffff"},
+		{json: `-9223372036854775808`, hex: "d38000000000000000"},
+
+		// Floats stay floats, in four bytes when that is exact
+		{json: `1.5`, hex: "ca3fc00000"},
+		{json: `1.0`, hex: "ca3f800000", back: `1`},
+		{json: `-4.0`, hex: "cac0800000", back: `-4`},
+		{json: `1.1`, hex: "cb3ff199999999999a"},
+		{json: `-4.1`, hex: "cbc010666666666666"},
+		{json: `1e300`, hex: "cb7e37e43c8800759c", back: `1e+300`},
+
+		{json: `null`, hex: "c0"},
+		{json: `false`, hex: "c2"},
+		{json: `true`, hex: "c3"},
+
+		// String lengths count bytes, not characters
+		{json: `""`, hex: "a0"},
+		{json: `"a"`, hex: "a161"},
+		{json: `"\"\\"`, hex: "a2225c"},
+		{json: `"ü"`, hex: "a2c3bc"},
+		{json: `"水"`, hex: "a3e6b0b4"},
+		{json: `"𐅑"`, hex: "a4f0908591"},
+		{json: `"żółw"`, hex: "a7c5bcc3b3c58277"},
+
+		// Nested arrays and maps, keeping the order of map keys
+		{json: `[]`, hex: "90"},
+		{json: `[1,[2,3],[4,5]]`, hex: "9301920203920405"},
+		{json: `{}`, hex: "80"},
+		{json: `{"a":1,"b":[2,3]}`, hex: "82a16101a162920203"},
+		{json: `{"b":1,"a":2}`, hex: "82a16201a16102"},
+		{json: `{"a":[1,{"b":null,"c":[1.5,true]}]}`, hex: "81a161920182a162c0a16392ca3fc00000c3"},
+	})
+}
+
+// TestMsgPackLengths tests the form chosen for string, array and map
+// lengths at the boundaries of the fix, 8, 16 and 32 bit forms
+func TestMsgPackLengths(t *testing.T) {
+	testLengths(t, MsgPack, []lengthCase{
+		{"string", 31, "bf"},
+		{"string", 32, "d920"},
+		{"string", 255, "d9ff"},
+		{"string", 256, "da0100"},
+		{"string", 65535, "daffff"},
+		{"string", 65536, "db00010000"},
+		{"array", 15, "9f"},
+		{"array", 16, "dc0010"},
+		{"array", 65535, "dcffff"},
+		{"array", 65536, "dd00010000"},
+		{"object", 15, "8f"},
+		{"object", 16, "de0010"},
+		{"object", 65535, "deffff"},
+		{"object", 65536, "df00010000"},
+	}, "\xa6")
+}
+
+// TestMsgPackDecode tests decoding encodings the encoder does not produce:
+// wider forms than needed, binary data and non-string map keys
+func TestMsgPackDecode(t *testing.T) {
+	testDecodes(t, MsgPack, []vector{
+		{json: `1`, hex: "cc01"},
+		{json: `-1`, hex: "d3ffffffffffffffff"},
+		{json: `1`, hex: "cb3ff0000000000000"},
+		{json: `"a"`, hex: "d90161"},
+		{json: `"a"`, hex: "db0000000161"},
+		{json: `[1]`, hex: "dc000101"},
+		{json: `{"a":1}`, hex: "df00000001a16101"},
+		{json: `"AQID"`, hex: "c403010203"},
+		{json: `"AQID"`, hex: "c50003010203"},
+		{json: `""`, hex: "c600000000"},
+		{json: `{"1":true,"null":false,"1.5":null}`, hex: "8301c3c0c2ca3fc00000c0"},
+	})
+}
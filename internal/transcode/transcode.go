@@ -0,0 +1,211 @@
+// Package transcode converts message payloads between JSON and the binary
+// CBOR (RFC 8949) and MessagePack formats. Object member order is kept.
+package transcode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Supported binary formats
+const (
+	CBOR    = "cbor"
+	MsgPack = "msgpack"
+)
+
+// Formats lists the supported binary formats
+var Formats = []string{CBOR, MsgPack}
+
+// ContentType returns the media type of a format
+func ContentType(format string) string {
+	if format == MsgPack {
+		return "application/msgpack"
+	}
+	return "application/cbor"
+}
+
+// maxDepth bounds the nesting of arrays and objects
+const maxDepth = 64
+
+// errTruncated is returned for binary data that ends inside a value
+var errTruncated = errors.New("truncated data")
+
+// member is a key/value pair of an object
+type member struct {
+	key   string
+	value any
+}
+
+// Values are held as nil, bool, int64, uint64, float64, string, []byte,
+// []any and []member (an object)
+
+// FromJSON encodes a JSON document in a binary format
+func FromJSON(format string, data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := parseJSON(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after JSON value")
+	}
+	switch format {
+	case CBOR:
+		return appendCBOR(nil, v), nil
+	case MsgPack:
+		return appendMsgPack(nil, v), nil
+	}
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
+
+// ToJSON decodes a binary document to JSON. Byte strings become base64
+// strings; map keys that are not strings are formatted as JSON text.
+func ToJSON(format string, data []byte) ([]byte, error) {
+	var (
+		v    any
+		rest []byte
+		err  error
+	)
+	switch format {
+	case CBOR:
+		v, rest, err = decodeCBOR(data, 0)
+	case MsgPack:
+		v, rest, err = decodeMsgPack(data, 0)
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d bytes of trailing data", len(rest))
+	}
+	return appendJSON(nil, v)
+}
+
+// parseJSON reads the next JSON value from dec
+func parseJSON(dec *json.Decoder, depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("nesting deeper than %d levels", maxDepth)
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '[':
+			arr := []any{}
+			for dec.More() {
+				v, err := parseJSON(dec, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, v)
+			}
+			_, err := dec.Token()
+			return arr, err
+		case '{':
+			obj := []member{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := parseJSON(dec, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, member{key: key.(string), value: v})
+			}
+			_, err := dec.Token()
+			return obj, err
+		}
+		return nil, fmt.Errorf("unexpected %v", t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+			return u, nil
+		}
+		return t.Float64()
+	default:
+		return t, nil // nil, bool or string
+	}
+}
+
+// appendJSON appends the JSON text of a value
+func appendJSON(buf []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case uint64:
+		return strconv.AppendUint(buf, v, 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%v cannot be represented in JSON", v)
+		}
+		data, err := json.Marshal(v)
+		return append(buf, data...), err
+	case string:
+		data, err := json.Marshal(v)
+		return append(buf, data...), err
+	case []byte:
+		return strconv.AppendQuote(buf, base64.StdEncoding.EncodeToString(v)), nil
+	case []any:
+		buf = append(buf, '[')
+		for i, elem := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			var err error
+			if buf, err = appendJSON(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	case []member:
+		buf = append(buf, '{')
+		for i, m := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			key, _ := json.Marshal(m.key)
+			buf = append(append(buf, key...), ':')
+			var err error
+			if buf, err = appendJSON(buf, m.value); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, '}'), nil
+	}
+	return nil, fmt.Errorf("unsupported value %T", v)
+}
+
+// mapKey returns the string form of a decoded map key
+func mapKey(key any) (string, error) {
+	if s, ok := key.(string); ok {
+		return s, nil
+	}
+	data, err := appendJSON(nil, key)
+	return string(data), err
+}
+
+// smallFloat reports whether a float64 survives a round trip through
+// float32, so it can be sent in four bytes
+func smallFloat(f float64) bool {
+	return float64(float32(f)) == f || math.IsNaN(f)
+}
@@ -0,0 +1,186 @@
+package transcode
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// vector is a JSON document and its binary encoding. back is the JSON the
+// encoding decodes to when it differs from the document, such as floats
+// with an integral value.
+type vector struct {
+	json string
+	hex  string
+	back string
+}
+
+// testVectors checks that each document encodes to its bytes and that the
+// bytes decode back to it
+func testVectors(t *testing.T, format string, vectors []vector) {
+	t.Helper()
+	for _, v := range vectors {
+		want, err := hex.DecodeString(v.hex)
+		if err != nil {
+			t.Fatalf("Invalid hex %s: %v", v.hex, err)
+		}
+		got, err := FromJSON(format, []byte(v.json))
+		if err != nil {
+			t.Errorf("FromJSON(%s) failed: %v", v.json, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("FromJSON(%s) = %x, want %s", v.json, got, v.hex)
+		}
+		back := v.back
+		if back == "" {
+			back = v.json
+		}
+		decoded, err := ToJSON(format, want)
+		if err != nil {
+			t.Errorf("ToJSON(%s) failed: %v", v.hex, err)
+			continue
+		}
+		if string(decoded) != back {
+			t.Errorf("ToJSON(%s) = %s, want %s", v.hex, decoded, back)
+		}
+	}
+}
+
+// testDecodes checks that each encoding, which FromJSON does not produce,
+// decodes to its JSON document
+func testDecodes(t *testing.T, format string, vectors []vector) {
+	t.Helper()
+	for _, v := range vectors {
+		data, err := hex.DecodeString(v.hex)
+		if err != nil {
+			t.Fatalf("Invalid hex %s: %v", v.hex, err)
+		}
+		got, err := ToJSON(format, data)
+		if err != nil {
+			t.Errorf("ToJSON(%s) failed: %v", v.hex, err)
+			continue
+		}
+		if string(got) != v.json {
+			t.Errorf("ToJSON(%s) = %s, want %s", v.hex, got, v.json)
+		}
+	}
+}
+
+// lengthCase is a string, array or object of n items, whose encoding
+// starts with head
+type lengthCase struct {
+	kind string
+	n    int
+	head string
+}
+
+// testLengths checks the length prefixes chosen at the boundaries of their
+// sizes by encoding documents of each length and decoding them back.
+// Strings are made of 'a', arrays of 0 and objects map keys of six
+// characters to 0.
+func testLengths(t *testing.T, format string, cases []lengthCase, keyPrefix string) {
+	t.Helper()
+	for _, tc := range cases {
+		var doc strings.Builder
+		var body []byte
+		switch tc.kind {
+		case "string":
+			doc.WriteString(`"` + strings.Repeat("a", tc.n) + `"`)
+			body = bytes.Repeat([]byte{'a'}, tc.n)
+		case "array":
+			doc.WriteString("[" + strings.TrimSuffix(strings.Repeat("0,", tc.n), ",") + "]")
+			body = make([]byte, tc.n)
+		case "object":
+			doc.WriteString("{")
+			for i := range tc.n {
+				if i > 0 {
+					doc.WriteString(",")
+				}
+				key := fmt.Sprintf("k%05d", i)
+				fmt.Fprintf(&doc, "%q:0", key)
+				body = append(append(append(body, keyPrefix...), key...), 0)
+			}
+			doc.WriteString("}")
+		}
+		head, err := hex.DecodeString(tc.head)
+		if err != nil {
+			t.Fatalf("Invalid hex %s: %v", tc.head, err)
+		}
+		want := append(head, body...)
+
+		got, err := FromJSON(format, []byte(doc.String()))
+		if err != nil {
+			t.Errorf("%s of %d: FromJSON failed: %v", tc.kind, tc.n, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s of %d: encoded with head %x, want %s", tc.kind, tc.n, got[:min(len(got), len(head))], tc.head)
+			continue
+		}
+		back, err := ToJSON(format, got)
+		if err != nil || string(back) != doc.String() {
+			t.Errorf("%s of %d: did not decode back to the document: %v", tc.kind, tc.n, err)
+		}
+	}
+}
+
+// TestTranscodeErrors tests that invalid input in either direction is
+// refused
+func TestTranscodeErrors(t *testing.T) {
+	deep := strings.Repeat("[", maxDepth+2) + strings.Repeat("]", maxDepth+2)
+	for _, format := range Formats {
+		for _, doc := range []string{``, `{"a":}`, `[1,2`, `1 2`, deep} {
+			if _, err := FromJSON(format, []byte(doc)); err == nil {
+				t.Errorf("%s: expected FromJSON(%.20s) to fail", format, doc)
+			}
+		}
+	}
+	if _, err := FromJSON("xml", []byte(`1`)); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+	if _, err := ToJSON("xml", []byte{0}); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+
+	invalid := map[string][]string{
+		CBOR: {
+			"",       // empty
+			"0102",   // trailing data
+			"1a0001", // truncated argument
+			"6261",   // truncated text
+			"8301",   // truncated array
+			"a1",     // truncated map
+			"1c",     // reserved additional information
+			"1f",     // indefinite integer
+			"5f61ff", // text chunk in a byte string
+			"f97e00", // NaN
+			"f97c00", // infinity
+			"ff",     // lone break
+			"f8ff",   // unsupported simple value
+			strings.Repeat("81", maxDepth+2) + "00",
+		},
+		MsgPack: {
+			"",                   // empty
+			"0102",               // trailing data
+			"cd01",               // truncated uint 16
+			"a261",               // truncated fixstr
+			"92",                 // truncated fixarray
+			"de0001",             // truncated map 16
+			"c1",                 // never used
+			"d40100",             // fixext 1
+			"cb7ff8000000000000", // NaN
+			strings.Repeat("91", maxDepth+2) + "00",
+		},
+	}
+	for format, encodings := range invalid {
+		for _, h := range encodings {
+			data, _ := hex.DecodeString(h)
+			if got, err := ToJSON(format, data); err == nil {
+				t.Errorf("%s: expected ToJSON(%.20s) to fail, got %s", format, h, got)
+			}
+		}
+	}
+}
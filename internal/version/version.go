@@ -0,0 +1,96 @@
+// Package version holds the broker's build information. Release builds set
+// it with -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/ZindGH/MQTT-Server/internal/version.Version=1.2.0
+//	  -X github.com/ZindGH/MQTT-Server/internal/version.Commit=$(git rev-parse --short HEAD)
+//	  -X github.com/ZindGH/MQTT-Server/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Build information set at link time
+var (
+	Version   = "1.1.0"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"` // GOOS/GOARCH
+}
+
+// Get returns the build information. Without -ldflags the commit and build
+// date come from the version control stamp of the Go toolchain, if any.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value[:min(len(setting.Value), 12)]
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// String formats the build information for --version output and logs
+func (i Info) String() string {
+	s := "mqtt-server " + i.Version
+	var details []string
+	if i.Commit != "" {
+		details = append(details, "commit "+i.Commit)
+	}
+	if i.BuildDate != "" {
+		details = append(details, "built "+i.BuildDate)
+	}
+	details = append(details, i.GoVersion, i.Platform)
+	return s + " (" + strings.Join(details, ", ") + ")"
+}
+
+// Compatible reports whether tools of one version can work with a broker
+// of another: semantic versions are compatible within a major version.
+// Versions that are not semantic, such as development builds, are assumed
+// to be compatible.
+func Compatible(a, b string) bool {
+	majorA, okA := major(a)
+	majorB, okB := major(b)
+	return !okA || !okB || majorA == majorB
+}
+
+// major returns the major version of a semantic version such as "v1.2.3"
+// or "1.2.3-rc.1"
+func major(v string) (int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	core, _, _ := strings.Cut(v, "-")
+	core, _, _ = strings.Cut(core, "+")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	for _, part := range parts {
+		if n, err := strconv.Atoi(part); err != nil || n < 0 {
+			return 0, false
+		}
+	}
+	n, _ := strconv.Atoi(parts[0])
+	return n, true
+}
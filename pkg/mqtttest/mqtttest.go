@@ -0,0 +1,147 @@
+// Package mqtttest runs the broker inside the tests of applications that
+// embed it: an ephemeral broker on a free local port, backed by an
+// in-memory store instead of a bbolt file and optionally driven by a
+// virtual clock.
+package mqtttest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/clock"
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+	"github.com/ZindGH/MQTT-Server/internal/store"
+)
+
+// startTimeout bounds how long Start waits for the broker to listen
+const startTimeout = 5 * time.Second
+
+// NewStore returns an empty in-memory store
+func NewStore() *store.MemoryStore {
+	return store.NewMemoryStore()
+}
+
+// NewClock returns a virtual clock set to start, which only moves when
+// advanced
+func NewClock(start time.Time) *clock.Virtual {
+	return clock.NewVirtual(start)
+}
+
+// Broker is a broker started by Start
+type Broker struct {
+	Server *server.Server
+	Store  *store.MemoryStore
+	Clock  *clock.Virtual // nil unless started WithClock
+	Addr   string         // host:port the broker listens on
+}
+
+// URL returns the address of the broker for MQTT clients, such as
+// tcp://127.0.0.1:41883
+func (b *Broker) URL() string {
+	return "tcp://" + b.Addr
+}
+
+// options collects the Options of Start
+type options struct {
+	configure []func(*config.Config)
+	store     *store.MemoryStore
+	clock     *clock.Virtual
+}
+
+// Option customizes a broker started by Start
+type Option func(*options)
+
+// WithConfig changes the configuration before the broker is created. The
+// port is chosen by the system.
+func WithConfig(configure func(*config.Config)) Option {
+	return func(o *options) { o.configure = append(o.configure, configure) }
+}
+
+// WithStore backs the broker by st, for example to inspect it or to keep
+// sessions across two brokers started one after the other
+func WithStore(st *store.MemoryStore) Option {
+	return func(o *options) { o.store = st }
+}
+
+// WithClock drives keepalives, delayed wills and expiries by a virtual
+// clock
+func WithClock(c *clock.Virtual) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// Config returns the configuration Start runs a broker with before options
+// apply: no authentication, metrics or admin API, and QoS up to 2
+func Config() *config.Config {
+	return &config.Config{
+		Server: config.ServerConfig{
+			Host:         "127.0.0.1",
+			KeepAlive:    60 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			ReadTimeout:  30 * time.Second,
+		},
+		Storage: config.StorageConfig{Backend: "memory"},
+		Limits: config.LimitsConfig{
+			MaxClients:          1000,
+			MaxMessageSize:      256 * 1024,
+			MaxInflightMessages: 100,
+			RetainedMessages:    true,
+		},
+		QoS: config.QoSConfig{
+			MaxQoS:        2,
+			RetryInterval: 10 * time.Second,
+			MaxRetries:    3,
+		},
+	}
+}
+
+// Start starts a broker on a port of 127.0.0.1 chosen by the system and
+// stops it when the test ends. Brokers of parallel tests do not collide.
+func Start(tb testing.TB, opts ...Option) *Broker {
+	tb.Helper()
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg := Config()
+	for _, configure := range o.configure {
+		configure(cfg)
+	}
+	cfg.Server.Port = 0
+
+	b := &Broker{Store: o.store, Clock: o.clock}
+	if b.Store == nil {
+		b.Store = NewStore()
+	}
+	srv, err := server.NewWithConfig(cfg, b.Store)
+	if err != nil {
+		tb.Fatalf("mqtttest: failed to create broker: %v", err)
+	}
+	if b.Clock != nil {
+		srv.SetClock(b.Clock)
+	}
+	b.Server = srv
+
+	started := make(chan error, 1)
+	go func() { started <- srv.Start() }()
+	select {
+	case <-srv.Ready():
+	case err := <-started:
+		tb.Fatalf("mqtttest: failed to start broker: %v", err)
+	case <-time.After(startTimeout):
+		tb.Fatalf("mqtttest: broker did not start within %s", startTimeout)
+	}
+	b.Addr = srv.Addr().String()
+
+	tb.Cleanup(func() {
+		if err := srv.Stop(); err != nil {
+			tb.Errorf("mqtttest: failed to stop broker: %v", err)
+		}
+		<-started
+		if o.store == nil {
+			b.Store.Close()
+		}
+	})
+	return b
+}
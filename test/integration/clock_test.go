@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/clock"
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// TestMQTTVirtualClock tests that keepalives, delayed wills and trace
+// expiry follow a virtual clock, fast-forwarding them instead of sleeping
+func TestMQTTVirtualClock(t *testing.T) {
+	vc := clock.NewVirtual(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, stop := launchTestServerWithClock(t, nil, vc)
+	defer stop()
+
+	// The watcher sends no PINGREQ, so advancing the clock keeps it connected
+	received := make(chan string, 10)
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("clock-watcher")
+	opts.SetKeepAlive(0)
+	watcher := mqtt.NewClient(opts)
+	if token := watcher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to connect: %v", token.Error())
+	}
+	defer watcher.Disconnect(250)
+	token := watcher.Subscribe("#", 0, func(c mqtt.Client, msg mqtt.Message) {
+		received <- string(msg.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to subscribe: %v", token.Error())
+	}
+
+	pending := vc.Pending()
+	conn := rawConnectWithKeepAlive(t, "clock-idle", "wills/idle", "idle", 10)
+	defer conn.Close()
+	waitFor(t, "the keepalive timer", func() bool { return vc.Pending() > pending })
+	vc.Advance(14 * time.Second)
+	expectNoWill(t, received)
+	vc.Advance(time.Second)
+	expectWill(t, received, "idle")
+	t.Log("✓ Keepalive expired after one and a half periods of virtual time")
+
+	tenMinutes := []byte{0x18, 0, 0, 0x02, 0x58}
+	client, _ := dialV5WithWill(t, "clock-delayed", 3600, "delayed", tenMinutes)
+	client.close()
+	vc.Advance(9 * time.Minute)
+	expectNoWill(t, received)
+	vc.Advance(time.Minute)
+	expectWill(t, received, "delayed")
+	t.Log("✓ Delayed will published once its delay passed in virtual time")
+
+	trace, err := srv.StartTrace("clock-traced", "", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to start trace: %v", err)
+	}
+	if !trace.Expires.Equal(vc.Now().Add(time.Hour)) {
+		t.Fatalf("Expected trace to expire at %s, got %s", vc.Now().Add(time.Hour), trace.Expires)
+	}
+	vc.Advance(time.Hour)
+	if traces := srv.Traces(); len(traces) != 0 {
+		t.Fatalf("Expected the trace to expire, got %v", traces)
+	}
+	t.Log("✓ Trace expired after its TTL in virtual time")
+}
+
+// TestMQTTPolicyKeepAliveBeforeV5 tests that a policy keepalive lengthens
+// the keepalive of an MQTT 3.1.1 client but never shortens it, since such
+// a client cannot be told to ping more often
+func TestMQTTPolicyKeepAliveBeforeV5(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy")
+	if err := os.WriteFile(policyFile, []byte("keepalive 60\n"), 0600); err != nil {
+		t.Fatalf("Failed to write policy file: %v", err)
+	}
+	vc := clock.NewVirtual(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, stop := launchTestServerWithClock(t, func(cfg *config.Config) {
+		cfg.Auth = config.AuthConfig{Enabled: true, AllowAnonymous: true, PolicyFile: policyFile}
+	}, vc)
+	defer stop()
+
+	// The watcher asks for no keepalive, which the policy cannot impose
+	received := make(chan string, 10)
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("policy-watcher")
+	opts.SetKeepAlive(0)
+	watcher := mqtt.NewClient(opts)
+	if token := watcher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to connect: %v", token.Error())
+	}
+	defer watcher.Disconnect(250)
+	token := watcher.Subscribe("wills/#", 0, func(c mqtt.Client, msg mqtt.Message) {
+		received <- string(msg.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to subscribe: %v", token.Error())
+	}
+
+	pending := vc.Pending()
+	short := rawConnectWithKeepAlive(t, "policy-short", "wills/short", "short", 10)
+	defer short.Close()
+	long := rawConnectWithKeepAlive(t, "policy-long", "wills/long", "long", 120)
+	defer long.Close()
+	waitFor(t, "the keepalive timers", func() bool { return vc.Pending() >= pending+2 })
+
+	vc.Advance(89 * time.Second)
+	expectNoWill(t, received)
+	vc.Advance(time.Second)
+	expectWill(t, received, "short")
+	t.Log("✓ Keepalive of 10s lengthened to the policy's 60s")
+
+	vc.Advance(89 * time.Second)
+	expectNoWill(t, received)
+	vc.Advance(time.Second)
+	expectWill(t, received, "long")
+	t.Log("✓ Keepalive of 120s kept instead of the policy's shorter 60s")
+
+	if !watcher.IsConnectionOpen() {
+		t.Error("Expected the client without keepalive to stay connected")
+	}
+}
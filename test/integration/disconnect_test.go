@@ -0,0 +1,328 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/clock"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// dialV5 connects an MQTT 5 client with Clean Start=0, the given Session
+// Expiry Interval and, if willPayload is not empty, a will on
+// disconnect/will. It returns the client with the CONNACK session present
+// flag.
+func dialV5(t *testing.T, clientID string, expiry uint32, willPayload string) (*rawClient, bool) {
+	return dialV5WithWill(t, clientID, expiry, willPayload, nil)
+}
+
+// dialV5WithWill is dialV5 with encoded will properties
+func dialV5WithWill(t *testing.T, clientID string, expiry uint32, willPayload string, willProps []byte) (*rawClient, bool) {
+	return dialV5Session(t, clientID, false, expiry, willPayload, willProps)
+}
+
+// dialV5CleanStart is dialV5 with Clean Start=1
+func dialV5CleanStart(t *testing.T, clientID string, expiry uint32) (*rawClient, bool) {
+	return dialV5Session(t, clientID, true, expiry, "", nil)
+}
+
+// dialV5Session connects an MQTT 5 client with every CONNECT option the
+// other dial helpers set
+func dialV5Session(t *testing.T, clientID string, cleanStart bool, expiry uint32, willPayload string, willProps []byte) (*rawClient, bool) {
+	conn, err := net.Dial("tcp", brokerAddr(t))
+	if err != nil {
+		t.Fatalf("Failed to dial broker: %v", err)
+	}
+	c := &rawClient{t: t, clientID: clientID, conn: conn, reader: bufio.NewReader(conn)}
+
+	str := func(s string) []byte {
+		return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+	}
+	var flags byte
+	if cleanStart {
+		flags |= 0x02
+	}
+	if willPayload != "" {
+		flags |= 0x04
+	}
+	body := append(str("MQTT"), 5, flags, 0, 60)
+	if expiry > 0 {
+		body = append(body, 5, 0x11)
+		body = binary.BigEndian.AppendUint32(body, expiry)
+	} else {
+		body = append(body, 0)
+	}
+	body = append(body, str(clientID)...)
+	if willPayload != "" {
+		body = append(body, byte(len(willProps)))
+		body = append(body, willProps...)
+		body = append(body, str("disconnect/will")...)
+		body = append(body, str(willPayload)...)
+	}
+	c.send(0x10, body)
+
+	first, connack := c.read()
+	if first != 0x20 || len(connack) < 2 || connack[1] != 0 {
+		t.Fatalf("Expected accepted CONNACK, got %#x % x", first, connack)
+	}
+	return c, connack[0]&0x01 == 1
+}
+
+// subscribeV5 subscribes an MQTT 5 client to a topic filter with QoS 1
+func (c *rawClient) subscribeV5(filter string) {
+	body := []byte{0, 1, 0} // packet ID, no properties
+	body = binary.BigEndian.AppendUint16(body, uint16(len(filter)))
+	body = append(body, filter...)
+	body = append(body, 1)
+	c.send(0x82, body)
+	if first, _ := c.read(); first != 0x90 {
+		c.t.Fatalf("Expected SUBACK, got %#x", first)
+	}
+}
+
+// readPublishV5 reads a QoS 1 PUBLISH sent to an MQTT 5 client and returns
+// its payload without acknowledging it
+func (c *rawClient) readPublishV5() string {
+	first, body := c.read()
+	if first>>4 != 3 || (first>>1)&0x03 != 1 {
+		c.t.Fatalf("Expected QoS 1 PUBLISH, got %#x", first)
+	}
+	topicLen := int(binary.BigEndian.Uint16(body))
+	propsLen := int(body[4+topicLen]) // short property lists only
+	return string(body[5+topicLen+propsLen:])
+}
+
+// sessionExpiry returns a DISCONNECT body with a reason code and a Session
+// Expiry Interval property
+func sessionExpiry(reason byte, expiry uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte{reason, 5, 0x11}, expiry)
+}
+
+// TestMQTTv5DisconnectReasonCodes tests which DISCONNECT reason codes an
+// MQTT 5 client may send keep its will: only a normal disconnection
+// discards it
+func TestMQTTv5DisconnectReasonCodes(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	watcher, received := subscribeWills(t, "disconnect/will")
+	defer watcher.Disconnect(250)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		keepWill bool
+	}{
+		{"no reason code", nil, false},
+		{"0x00 normal disconnection", []byte{0x00}, false},
+		{"0x00 with properties", sessionExpiry(0x00, 0), false},
+		{"0x04 disconnect with will", []byte{0x04}, true},
+		{"0x80 unspecified error", []byte{0x80}, true},
+		{"0x81 malformed packet", []byte{0x81}, true},
+		{"0x82 protocol error", []byte{0x82}, true},
+		{"0x83 implementation specific error", []byte{0x83}, true},
+		{"0x90 topic name invalid", []byte{0x90}, true},
+		{"0x93 receive maximum exceeded", []byte{0x93}, true},
+		{"0x94 topic alias invalid", []byte{0x94}, true},
+		{"0x95 packet too large", []byte{0x95}, true},
+		{"0x96 message rate too high", []byte{0x96}, true},
+		{"0x97 quota exceeded", []byte{0x97}, true},
+		{"0x98 administrative action", []byte{0x98}, true},
+		{"0x99 payload format invalid", []byte{0x99}, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			will := fmt.Sprintf("will %d", i)
+			client, _ := dialV5(t, fmt.Sprintf("disconnect-reason-%d", i), 0, will)
+			defer client.conn.Close()
+			client.send(0xE0, tt.body)
+
+			if tt.keepWill {
+				expectWill(t, received, will)
+			} else {
+				expectNoWill(t, received)
+			}
+		})
+	}
+	t.Log("✓ Will discarded only on normal disconnection")
+}
+
+// TestMQTTv5DisconnectSessionExpiry tests that a Session Expiry Interval on
+// DISCONNECT decides whether a persistent session's inflight and queued
+// messages are kept
+func TestMQTTv5DisconnectSessionExpiry(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+	defer stop()
+
+	tests := []struct {
+		name        string
+		disconnect  []byte
+		wantSession bool
+	}{
+		{"connect expiry kept", []byte{0x00}, true},
+		{"expiry extended", sessionExpiry(0x00, 600), true},
+		{"expiry set to 0", sessionExpiry(0x00, 0), false},
+		{"expiry set to 0 with will", sessionExpiry(0x04, 0), false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientID := fmt.Sprintf("disconnect-expiry-%d", i)
+			topic := fmt.Sprintf("disconnect/expiry/%d", i)
+			client, _ := dialV5(t, clientID, 300, "")
+			client.subscribeV5(topic)
+			publishQoS1(t, topic, "inflight")
+			if got := client.readPublishV5(); got != "inflight" {
+				t.Fatalf("Expected %q, got %q", "inflight", got)
+			}
+			client.send(0xE0, tt.disconnect)
+			client.close()
+			publishQoS1(t, topic, "queued")
+
+			client, present := dialV5(t, clientID, 300, "")
+			defer client.conn.Close()
+			if present != tt.wantSession {
+				t.Fatalf("Expected session present %v, got %v", tt.wantSession, present)
+			}
+			if !tt.wantSession {
+				client.expectNoPacket()
+				return
+			}
+			for _, want := range []string{"inflight", "queued"} {
+				if got := client.readPublishV5(); got != want {
+					t.Fatalf("Expected %q, got %q", want, got)
+				}
+			}
+		})
+	}
+	t.Log("✓ Session kept or discarded according to the DISCONNECT session expiry")
+}
+
+// TestMQTTv5DisconnectExpiryProtocolError tests that a client connected
+// with a Session Expiry Interval of 0 cannot set one on DISCONNECT: the
+// broker answers with a protocol error and publishes the will
+func TestMQTTv5DisconnectExpiryProtocolError(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	watcher, received := subscribeWills(t, "disconnect/will")
+	defer watcher.Disconnect(250)
+
+	client, _ := dialV5(t, "disconnect-protocol-error", 0, "gone")
+	defer client.conn.Close()
+	client.send(0xE0, sessionExpiry(0x00, 60))
+
+	first, body := client.read()
+	if first != 0xE0 || len(body) == 0 || body[0] != 0x82 {
+		t.Fatalf("Expected DISCONNECT with reason 0x82, got %#x % x", first, body)
+	}
+	expectWill(t, received, "gone")
+	t.Log("✓ Protocol error on DISCONNECT publishes the will")
+}
+
+// TestMQTTv5CleanStartAndExpiry tests that Clean Start and the Session
+// Expiry Interval act separately: Clean Start discards the existing session
+// while the expiry keeps the new one, and Clean Start=0 resumes a session
+// even if the new connection lets it end on disconnect
+func TestMQTTv5CleanStartAndExpiry(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+	defer stop()
+
+	leave := func(client *rawClient) {
+		client.send(0xE0, []byte{0x00})
+		client.close()
+	}
+
+	client, _ := dialV5(t, "clean-start", 300, "")
+	client.subscribeV5("cleanstart/old")
+	leave(client)
+	publishQoS1(t, "cleanstart/old", "discarded")
+
+	client, present := dialV5CleanStart(t, "clean-start", 300)
+	if present {
+		t.Fatal("Expected Clean Start to discard the session")
+	}
+	client.expectNoPacket()
+	client.subscribeV5("cleanstart/new")
+	leave(client)
+	publishQoS1(t, "cleanstart/old", "unsubscribed")
+	publishQoS1(t, "cleanstart/new", "kept")
+	t.Log("✓ Clean Start discarded the old session")
+
+	client, present = dialV5(t, "clean-start", 0, "")
+	if !present {
+		t.Fatal("Expected the session started with Clean Start to be kept")
+	}
+	if got := client.readPublishV5(); got != "kept" {
+		t.Fatalf("Expected %q, got %q", "kept", got)
+	}
+	client.expectNoPacket()
+	leave(client)
+	t.Log("✓ Session started with Clean Start and an expiry interval was kept")
+
+	client, present = dialV5(t, "clean-start", 300, "")
+	defer client.conn.Close()
+	if present {
+		t.Fatal("Expected the session to end with a connection of expiry 0")
+	}
+	t.Log("✓ Session resumed with expiry 0 ended with the connection")
+}
+
+// TestMQTTv5SessionExpiryInterval tests that offline sessions end when
+// their Session Expiry Interval, from CONNECT or changed on DISCONNECT,
+// passes
+func TestMQTTv5SessionExpiryInterval(t *testing.T) {
+	vc := clock.NewVirtual(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, stop := launchTestServerWithClock(t, nil, vc)
+	defer stop()
+
+	sessions := []struct {
+		clientID   string
+		connect    uint32
+		disconnect []byte
+	}{
+		{"expiry-connect", 60, []byte{0x00}},
+		{"expiry-shortened", 3600, sessionExpiry(0x00, 30)},
+		{"expiry-never", 0xFFFFFFFF, []byte{0x00}},
+	}
+	for _, s := range sessions {
+		client, _ := dialV5(t, s.clientID, s.connect, "")
+		client.subscribeV5("expiry/" + s.clientID)
+		client.send(0xE0, s.disconnect)
+		client.close()
+	}
+
+	expectSessions := func(want map[string]bool) {
+		t.Helper()
+		for clientID, kept := range want {
+			_, err := srv.SessionState(clientID)
+			if kept && err != nil {
+				t.Fatalf("Expected the session of %s, got %v", clientID, err)
+			}
+			if !kept && !errors.Is(err, server.ErrSessionNotFound) {
+				t.Fatalf("Expected the session of %s to have expired, got %v", clientID, err)
+			}
+		}
+	}
+	vc.Advance(29 * time.Second)
+	expectSessions(map[string]bool{"expiry-connect": true, "expiry-shortened": true, "expiry-never": true})
+	vc.Advance(time.Second)
+	expectSessions(map[string]bool{"expiry-connect": true, "expiry-shortened": false, "expiry-never": true})
+	t.Log("✓ Expiry interval changed on DISCONNECT ended the session")
+	vc.Advance(30 * time.Second)
+	expectSessions(map[string]bool{"expiry-connect": false, "expiry-shortened": false, "expiry-never": true})
+	t.Log("✓ Expiry interval from CONNECT ended the session")
+
+	for _, s := range sessions {
+		client, present := dialV5(t, s.clientID, 60, "")
+		client.conn.Close()
+		if want := s.clientID == "expiry-never"; present != want {
+			t.Fatalf("Expected session present %v for %s, got %v", want, s.clientID, present)
+		}
+	}
+	t.Log("✓ Expired sessions were removed from the store")
+}
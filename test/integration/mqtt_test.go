@@ -1,14 +1,21 @@
 package integration
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 
+	"github.com/ZindGH/MQTT-Server/internal/clock"
 	"github.com/ZindGH/MQTT-Server/internal/config"
 	"github.com/ZindGH/MQTT-Server/internal/server"
 	"github.com/ZindGH/MQTT-Server/internal/store"
@@ -16,11 +23,109 @@ import (
 
 // Helper function to start test server
 func startTestServer(t *testing.T) (*server.Server, func()) {
-	// Create test config
+	return startTestServerWith(t, nil)
+}
+
+// startTestServerWith starts a test server after applying configure to the
+// default test configuration
+func startTestServerWith(t *testing.T, configure func(*config.Config)) (*server.Server, func()) {
+	return launchTestServer(t, configure)
+}
+
+// launchTestServer starts a test server whose stop function keeps the
+// store's data, so a broker restart can be simulated by launching again
+// within the same test
+func launchTestServer(t *testing.T, configure func(*config.Config)) (*server.Server, func()) {
+	return launchTestServerWithClock(t, configure, clock.Real)
+}
+
+// testServer is the broker a test launched last and the data directory
+// its relaunches share
+type testServer struct {
+	srv *server.Server
+	dir string
+}
+
+// testServers holds the testServer of each running test by test name, so
+// helpers can reach the broker on the port the system chose for it
+var testServers sync.Map
+
+// runningServer returns the broker launched by the test, or by the test a
+// subtest belongs to
+func runningServer(t *testing.T) *server.Server {
+	t.Helper()
+	for name := t.Name(); ; {
+		if ts, ok := testServers.Load(name); ok {
+			return ts.(*testServer).srv
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			t.Fatalf("%s did not launch a broker", t.Name())
+		}
+		name = name[:i]
+	}
+}
+
+// brokerAddr returns the host:port the test's broker listens on
+func brokerAddr(t *testing.T) string {
+	t.Helper()
+	addr := runningServer(t).Addr()
+	if addr == nil {
+		t.Fatal("The test's broker is not running")
+	}
+	return addr.String()
+}
+
+// brokerURL returns the address of the test's broker for paho clients
+func brokerURL(t *testing.T) string {
+	t.Helper()
+	return "tcp://" + brokerAddr(t)
+}
+
+// waitFor polls cond until it holds, failing the test if it does not
+// within 5s
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(5 * time.Second)
+	for !cond() {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+	}
+}
+
+// waitForDisconnect waits until the test's broker has ended the connection
+// of clientID, suspending or discarding its session
+func waitForDisconnect(t *testing.T, clientID string) {
+	t.Helper()
+	srv := runningServer(t)
+	waitFor(t, clientID+" to be disconnected", func() bool {
+		state, err := srv.SessionState(clientID)
+		return err != nil || !state.Connected
+	})
+}
+
+// launchTestServerWithClock is launchTestServer with the broker's timers
+// driven by clk
+func launchTestServerWithClock(t *testing.T, configure func(*config.Config), clk clock.Clock) (*server.Server, func()) {
+	// A relaunch within the same test reopens the same store
+	ts := &testServer{}
+	if previous, ok := testServers.Load(t.Name()); ok {
+		ts.dir = previous.(*testServer).dir
+	} else {
+		ts.dir = t.TempDir()
+		t.Cleanup(func() { testServers.Delete(t.Name()) })
+	}
+
+	// Create test config, listening on a port chosen by the system
 	cfg := &config.Config{
 		Server: config.ServerConfig{
 			Host:                "127.0.0.1",
-			Port:                1884, // Use different port for testing
+			Port:                0,
 			KeepAlive:           60 * time.Second,
 			WriteTimeout:        10 * time.Second,
 			ReadTimeout:         30 * time.Second,
@@ -28,7 +133,7 @@ func startTestServer(t *testing.T) (*server.Server, func()) {
 		},
 		Storage: config.StorageConfig{
 			Backend: "bbolt",
-			Path:    "./test_data/test_mqtt.db",
+			Path:    filepath.Join(ts.dir, "test_mqtt.db"),
 		},
 		Limits: config.LimitsConfig{
 			MaxClients:          1000,
@@ -51,9 +156,9 @@ func startTestServer(t *testing.T) (*server.Server, func()) {
 		},
 	}
 
-	// Ensure test directory exists
-	dir := filepath.Dir(cfg.Storage.Path)
-	os.MkdirAll(dir, 0755)
+	if configure != nil {
+		configure(cfg)
+	}
 
 	// Initialize store
 	st, err := store.NewBboltStore(cfg.Storage.Path)
@@ -66,25 +171,31 @@ func startTestServer(t *testing.T) (*server.Server, func()) {
 	if err != nil {
 		t.Fatalf("Failed to create server: %v", err)
 	}
+	srv.SetClock(clk)
 
-	// Start server
+	// Start server and wait until it listens
+	started := make(chan error, 1)
 	go func() {
-		if err := srv.Start(); err != nil {
-			t.Logf("Server stopped: %v", err)
-		}
+		started <- srv.Start()
 	}()
+	select {
+	case <-srv.Ready():
+	case err := <-started:
+		st.Close()
+		t.Fatalf("Failed to start server: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not start within 5s")
+	}
 
-	// Give server time to start
-	time.Sleep(200 * time.Millisecond)
+	ts.srv = srv
+	testServers.Store(t.Name(), ts)
 
-	// Return cleanup function
-	cleanup := func() {
+	stop := func() {
 		srv.Stop()
 		st.Close()
-		os.RemoveAll("./test_data")
 	}
 
-	return srv, cleanup
+	return srv, stop
 }
 
 // TestMQTTConnect tests basic MQTT connection
@@ -94,7 +205,7 @@ func TestMQTTConnect(t *testing.T) {
 
 	// Create MQTT client options
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker("tcp://127.0.0.1:1884")
+	opts.AddBroker(brokerURL(t))
 	opts.SetClientID("test-client-connect")
 	opts.SetCleanSession(true)
 	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
@@ -120,7 +231,60 @@ func TestMQTTConnect(t *testing.T) {
 
 	// Disconnect
 	client.Disconnect(250)
-	time.Sleep(100 * time.Millisecond)
+}
+
+// TestMQTT31Connect tests legacy MQTT 3.1 (MQIsdp) clients and client ID rules
+func TestMQTT31Connect(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	connect := func(version uint, clientID string, cleanSession bool) (mqtt.Client, error) {
+		opts := mqtt.NewClientOptions()
+		opts.AddBroker(brokerURL(t))
+		opts.SetProtocolVersion(version)
+		opts.SetClientID(clientID)
+		opts.SetCleanSession(cleanSession)
+		opts.SetAutoReconnect(false)
+
+		client := mqtt.NewClient(opts)
+		token := client.Connect()
+		if !token.WaitTimeout(5 * time.Second) {
+			t.Fatal("Connection timeout")
+		}
+		return client, token.Error()
+	}
+
+	// A 3.1 client with a valid ID can connect and publish
+	client, err := connect(3, "legacy-device", true)
+	if err != nil {
+		t.Fatalf("3.1 client failed to connect: %v", err)
+	}
+	token := client.Publish("legacy/test", 1, false, "hello")
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("3.1 client failed to publish: %v", token.Error())
+	}
+	client.Disconnect(250)
+	t.Log("✓ MQTT 3.1 client connected and published")
+
+	// 3.1 limits client IDs to 23 characters and does not allow empty IDs
+	if _, err := connect(3, "legacy-device-with-a-very-long-id", true); err == nil {
+		t.Fatal("Expected 3.1 client with a 33 character ID to be rejected")
+	}
+	if _, err := connect(3, "", true); err == nil {
+		t.Fatal("Expected 3.1 client with an empty ID to be rejected")
+	}
+	t.Log("✓ Invalid 3.1 client IDs rejected")
+
+	// 3.1.1 clients may leave the ID empty with a clean session only
+	client, err = connect(4, "", true)
+	if err != nil {
+		t.Fatalf("3.1.1 client with empty ID and clean session failed to connect: %v", err)
+	}
+	client.Disconnect(250)
+	if _, err := connect(4, "", false); err == nil {
+		t.Fatal("Expected 3.1.1 client with empty ID and persistent session to be rejected")
+	}
+	t.Log("✓ Empty 3.1.1 client IDs handled")
 }
 
 // TestMQTTPublishSubscribe tests publish/subscribe functionality
@@ -132,7 +296,7 @@ func TestMQTTPublishSubscribe(t *testing.T) {
 
 	// Create subscriber client
 	subOpts := mqtt.NewClientOptions()
-	subOpts.AddBroker("tcp://127.0.0.1:1884")
+	subOpts.AddBroker(brokerURL(t))
 	subOpts.SetClientID("test-subscriber")
 	subOpts.SetCleanSession(true)
 
@@ -154,12 +318,9 @@ func TestMQTTPublishSubscribe(t *testing.T) {
 
 	t.Logf("✓ Subscribed to topic: %s", topic)
 
-	// Give subscription time to register
-	time.Sleep(100 * time.Millisecond)
-
 	// Create publisher client
 	pubOpts := mqtt.NewClientOptions()
-	pubOpts.AddBroker("tcp://127.0.0.1:1884")
+	pubOpts.AddBroker(brokerURL(t))
 	pubOpts.SetClientID("test-publisher")
 	pubOpts.SetCleanSession(true)
 
@@ -201,7 +362,7 @@ func TestMQTTMultipleClients(t *testing.T) {
 	// Connect multiple clients
 	for i := 0; i < numClients; i++ {
 		opts := mqtt.NewClientOptions()
-		opts.AddBroker("tcp://127.0.0.1:1884")
+		opts.AddBroker(brokerURL(t))
 		opts.SetClientID(fmt.Sprintf("test-client-%d", i))
 		opts.SetCleanSession(true)
 
@@ -218,8 +379,6 @@ func TestMQTTMultipleClients(t *testing.T) {
 		client.Disconnect(250)
 		t.Logf("✓ Client %d disconnected", i)
 	}
-
-	time.Sleep(100 * time.Millisecond)
 	t.Logf("✓ All %d clients handled successfully", numClients)
 }
 
@@ -233,7 +392,7 @@ func TestMQTTQoS1(t *testing.T) {
 
 	// Create subscriber
 	subOpts := mqtt.NewClientOptions()
-	subOpts.AddBroker("tcp://127.0.0.1:1884")
+	subOpts.AddBroker(brokerURL(t))
 	subOpts.SetClientID("qos1-subscriber")
 	subOpts.SetCleanSession(false) // Persistent session
 
@@ -254,11 +413,9 @@ func TestMQTTQoS1(t *testing.T) {
 		t.Fatalf("Failed to subscribe: %v", token.Error())
 	}
 
-	time.Sleep(100 * time.Millisecond)
-
 	// Create publisher
 	pubOpts := mqtt.NewClientOptions()
-	pubOpts.AddBroker("tcp://127.0.0.1:1884")
+	pubOpts.AddBroker(brokerURL(t))
 	pubOpts.SetClientID("qos1-publisher")
 	pubOpts.SetCleanSession(true)
 
@@ -292,7 +449,7 @@ func TestMQTTPingPong(t *testing.T) {
 	defer cleanup()
 
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker("tcp://127.0.0.1:1884")
+	opts.AddBroker(brokerURL(t))
 	opts.SetClientID("ping-test-client")
 	opts.SetKeepAlive(2 * time.Second) // Short keep-alive for testing
 	opts.SetPingTimeout(1 * time.Second)
@@ -319,7 +476,7 @@ func TestMQTTReconnect(t *testing.T) {
 	defer cleanup()
 
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker("tcp://127.0.0.1:1884")
+	opts.AddBroker(brokerURL(t))
 	opts.SetClientID("reconnect-test-client")
 	opts.SetCleanSession(false)
 	opts.SetAutoReconnect(true)
@@ -334,7 +491,7 @@ func TestMQTTReconnect(t *testing.T) {
 
 	// Disconnect
 	client.Disconnect(250)
-	time.Sleep(500 * time.Millisecond)
+	waitForDisconnect(t, "reconnect-test-client")
 
 	// Reconnect
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
@@ -359,7 +516,7 @@ func TestMQTTWildcardSubscriptions(t *testing.T) {
 
 	// Create subscriber
 	subOpts := mqtt.NewClientOptions()
-	subOpts.AddBroker("tcp://127.0.0.1:1884")
+	subOpts.AddBroker(brokerURL(t))
 	subOpts.SetClientID("wildcard-subscriber")
 
 	subscriber := mqtt.NewClient(subOpts)
@@ -377,11 +534,9 @@ func TestMQTTWildcardSubscriptions(t *testing.T) {
 		t.Fatalf("Failed to subscribe: %v", token.Error())
 	}
 
-	time.Sleep(100 * time.Millisecond)
-
 	// Create publisher
 	pubOpts := mqtt.NewClientOptions()
-	pubOpts.AddBroker("tcp://127.0.0.1:1884")
+	pubOpts.AddBroker(brokerURL(t))
 	pubOpts.SetClientID("wildcard-publisher")
 
 	publisher := mqtt.NewClient(pubOpts)
@@ -412,7 +567,7 @@ func TestMQTTLargeMessage(t *testing.T) {
 
 	// Create subscriber
 	subOpts := mqtt.NewClientOptions()
-	subOpts.AddBroker("tcp://127.0.0.1:1884")
+	subOpts.AddBroker(brokerURL(t))
 	subOpts.SetClientID("large-msg-subscriber")
 
 	subscriber := mqtt.NewClient(subOpts)
@@ -429,11 +584,9 @@ func TestMQTTLargeMessage(t *testing.T) {
 		t.Fatalf("Failed to subscribe: %v", token.Error())
 	}
 
-	time.Sleep(100 * time.Millisecond)
-
 	// Create publisher
 	pubOpts := mqtt.NewClientOptions()
-	pubOpts.AddBroker("tcp://127.0.0.1:1884")
+	pubOpts.AddBroker(brokerURL(t))
 	pubOpts.SetClientID("large-msg-publisher")
 
 	publisher := mqtt.NewClient(pubOpts)
@@ -469,22 +622,14 @@ func TestMQTTLargeMessage(t *testing.T) {
 
 // TestMQTTRetainedMessages tests retained message functionality
 func TestMQTTRetainedMessages(t *testing.T) {
-	srv, cleanup := startTestServer(t)
+	_, cleanup := startTestServer(t)
 	defer cleanup()
 
-	go func() {
-		if err := srv.Start(); err != nil {
-			t.Logf("Server stopped: %v", err)
-		}
-	}()
-
-	time.Sleep(100 * time.Millisecond)
-
 	topic := "test/retained"
 
 	// Step 1: Publish a retained message
 	pubOpts := mqtt.NewClientOptions()
-	pubOpts.AddBroker("tcp://127.0.0.1:1884")
+	pubOpts.AddBroker(brokerURL(t))
 	pubOpts.SetClientID("retained-publisher")
 
 	publisher := mqtt.NewClient(pubOpts)
@@ -499,16 +644,20 @@ func TestMQTTRetainedMessages(t *testing.T) {
 	}
 	t.Logf("✓ Published retained message")
 
+	// The broker stores the message before it handles the DISCONNECT
 	publisher.Disconnect(250)
-	time.Sleep(200 * time.Millisecond)
+	waitForDisconnect(t, "retained-publisher")
 
 	// Step 2: New subscriber should receive the retained message
 	received := make(chan string, 1)
 	subOpts := mqtt.NewClientOptions()
-	subOpts.AddBroker("tcp://127.0.0.1:1884")
+	subOpts.AddBroker(brokerURL(t))
 	subOpts.SetClientID("retained-subscriber")
 	subOpts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
 		t.Logf("Received retained message: %s", string(msg.Payload()))
+		if !msg.Retained() {
+			t.Errorf("Retained message delivered on subscribe without the RETAIN flag")
+		}
 		received <- string(msg.Payload())
 	})
 
@@ -550,24 +699,69 @@ func TestMQTTRetainedMessages(t *testing.T) {
 	publisher2.Disconnect(250)
 }
 
-// TestMQTTSingleLevelWildcard tests the + (single-level) wildcard
-func TestMQTTSingleLevelWildcard(t *testing.T) {
-	srv, cleanup := startTestServer(t)
+// TestMQTTRetainFlagOnLiveDelivery tests that messages delivered to an
+// existing subscription have the RETAIN flag cleared
+func TestMQTTRetainFlagOnLiveDelivery(t *testing.T) {
+	_, cleanup := startTestServer(t)
 	defer cleanup()
 
-	go func() {
-		if err := srv.Start(); err != nil {
-			t.Logf("Server stopped: %v", err)
+	topic := "test/retain-flag"
+	received := make(chan bool, 1)
+
+	subOpts := mqtt.NewClientOptions()
+	subOpts.AddBroker(brokerURL(t))
+	subOpts.SetClientID("retain-flag-subscriber")
+	subscriber := mqtt.NewClient(subOpts)
+	if token := subscriber.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Subscriber failed to connect: %v", token.Error())
+	}
+	defer subscriber.Disconnect(250)
+
+	token := subscriber.Subscribe(topic, 0, func(client mqtt.Client, msg mqtt.Message) {
+		received <- msg.Retained()
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+
+	pubOpts := mqtt.NewClientOptions()
+	pubOpts.AddBroker(brokerURL(t))
+	pubOpts.SetClientID("retain-flag-publisher")
+	publisher := mqtt.NewClient(pubOpts)
+	if token := publisher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Publisher failed to connect: %v", token.Error())
+	}
+	defer publisher.Disconnect(250)
+
+	token = publisher.Publish(topic, 0, true, "live")
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to publish: %v", token.Error())
+	}
+
+	select {
+	case retained := <-received:
+		if retained {
+			t.Fatal("Live delivery to an existing subscription has the RETAIN flag set")
 		}
-	}()
+		t.Log("✓ RETAIN flag cleared on live delivery")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for message")
+	}
 
-	time.Sleep(100 * time.Millisecond)
+	// Clean up the retained message
+	publisher.Publish(topic, 0, true, "").Wait()
+}
+
+// TestMQTTSingleLevelWildcard tests the + (single-level) wildcard
+func TestMQTTSingleLevelWildcard(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
 
 	receivedTopics := make(chan string, 10)
 
 	// Create subscriber with + wildcard
 	subOpts := mqtt.NewClientOptions()
-	subOpts.AddBroker("tcp://127.0.0.1:1884")
+	subOpts.AddBroker(brokerURL(t))
 	subOpts.SetClientID("wildcard-plus-sub")
 	subOpts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
 		t.Logf("Received on %s: %s", msg.Topic(), string(msg.Payload()))
@@ -587,11 +781,9 @@ func TestMQTTSingleLevelWildcard(t *testing.T) {
 	}
 	t.Logf("✓ Subscribed to sensors/+/temperature")
 
-	time.Sleep(100 * time.Millisecond)
-
 	// Create publisher
 	pubOpts := mqtt.NewClientOptions()
-	pubOpts.AddBroker("tcp://127.0.0.1:1884")
+	pubOpts.AddBroker(brokerURL(t))
 	pubOpts.SetClientID("wildcard-plus-pub")
 
 	publisher := mqtt.NewClient(pubOpts)
@@ -656,22 +848,14 @@ func TestMQTTSingleLevelWildcard(t *testing.T) {
 
 // TestMQTTMixedWildcards tests combining + and # wildcards
 func TestMQTTMixedWildcards(t *testing.T) {
-	srv, cleanup := startTestServer(t)
+	_, cleanup := startTestServer(t)
 	defer cleanup()
 
-	go func() {
-		if err := srv.Start(); err != nil {
-			t.Logf("Server stopped: %v", err)
-		}
-	}()
-
-	time.Sleep(100 * time.Millisecond)
-
 	received := make(chan string, 10)
 
 	// Create subscriber
 	subOpts := mqtt.NewClientOptions()
-	subOpts.AddBroker("tcp://127.0.0.1:1884")
+	subOpts.AddBroker(brokerURL(t))
 	subOpts.SetClientID("mixed-wildcard-sub")
 	subOpts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
 		t.Logf("Received on %s", msg.Topic())
@@ -692,11 +876,9 @@ func TestMQTTMixedWildcards(t *testing.T) {
 	}
 	t.Logf("✓ Subscribed to home/+/sensors/#")
 
-	time.Sleep(100 * time.Millisecond)
-
 	// Create publisher
 	pubOpts := mqtt.NewClientOptions()
-	pubOpts.AddBroker("tcp://127.0.0.1:1884")
+	pubOpts.AddBroker(brokerURL(t))
 	pubOpts.SetClientID("mixed-wildcard-pub")
 
 	publisher := mqtt.NewClient(pubOpts)
@@ -765,3 +947,366 @@ func TestMQTTMixedWildcards(t *testing.T) {
 		t.Logf("✓ Correctly matched %d topics with mixed wildcards", matchedCount)
 	}
 }
+
+// TestMQTTPresenceNotifications tests connected/disconnected events
+func TestMQTTPresenceNotifications(t *testing.T) {
+	_, cleanup := startTestServerWith(t, func(cfg *config.Config) {
+		cfg.Presence = config.PresenceConfig{
+			Enabled:           true,
+			ConnectedTopic:    "$SYS/clients/%c/connected",
+			DisconnectedTopic: "$SYS/clients/%c/disconnected",
+		}
+	})
+	defer cleanup()
+
+	type event struct {
+		topic string
+		body  map[string]any
+	}
+	events := make(chan event, 10)
+
+	watcherOpts := mqtt.NewClientOptions()
+	watcherOpts.AddBroker(brokerURL(t))
+	watcherOpts.SetClientID("presence-watcher")
+	watcher := mqtt.NewClient(watcherOpts)
+	if token := watcher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to connect: %v", token.Error())
+	}
+	defer watcher.Disconnect(250)
+	token := watcher.Subscribe("$SYS/clients/presence-device/+", 0, func(c mqtt.Client, msg mqtt.Message) {
+		var body map[string]any
+		json.Unmarshal(msg.Payload(), &body)
+		events <- event{msg.Topic(), body}
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+
+	deviceOpts := mqtt.NewClientOptions()
+	deviceOpts.AddBroker(brokerURL(t))
+	deviceOpts.SetClientID("presence-device")
+	deviceOpts.SetUsername("sensor")
+	device := mqtt.NewClient(deviceOpts)
+	if token := device.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Device failed to connect: %v", token.Error())
+	}
+	device.Disconnect(250)
+
+	for _, want := range []struct{ topic, event, reason string }{
+		{"$SYS/clients/presence-device/connected", "connected", ""},
+		{"$SYS/clients/presence-device/disconnected", "disconnected", "disconnect"},
+	} {
+		select {
+		case ev := <-events:
+			if ev.topic != want.topic || ev.body["event"] != want.event || ev.body["username"] != "sensor" {
+				t.Fatalf("Expected %s event on %s, got %v on %s", want.event, want.topic, ev.body, ev.topic)
+			}
+			if reason, _ := ev.body["reason"].(string); reason != want.reason {
+				t.Fatalf("Expected reason %q, got %q", want.reason, reason)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for %s event", want.event)
+		}
+	}
+	t.Log("✓ Connected and disconnected events published")
+}
+
+// TestMQTTPresenceTracking tests last-seen tracking and the retained status
+// topic, and that presence records survive a broker restart
+func TestMQTTPresenceTracking(t *testing.T) {
+	configure := func(cfg *config.Config) {
+		cfg.Presence = config.PresenceConfig{Tracking: true, StatusTopic: "$SYS/clients/%c/status"}
+	}
+	srv, stop := launchTestServer(t, configure)
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("tracked-device")
+	device := mqtt.NewClient(opts)
+	if token := device.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Device failed to connect: %v", token.Error())
+	}
+	// Presence is recorded once the CONNACK is sent
+	waitFor(t, "tracked-device to come online", func() bool {
+		p, err := srv.ClientPresence("tracked-device")
+		return err == nil && p.Online
+	})
+
+	p, err := srv.ClientPresence("tracked-device")
+	if err != nil || !p.Online || p.Connections != 1 {
+		t.Fatalf("Expected device online after 1 connection, got %+v (%v)", p, err)
+	}
+	device.Disconnect(250)
+	waitFor(t, "tracked-device to go offline", func() bool {
+		p, err := srv.ClientPresence("tracked-device")
+		return err == nil && !p.Online
+	})
+
+	status := make(chan map[string]any, 1)
+	watcherOpts := mqtt.NewClientOptions()
+	watcherOpts.AddBroker(brokerURL(t))
+	watcherOpts.SetClientID("status-watcher")
+	watcher := mqtt.NewClient(watcherOpts)
+	if token := watcher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to connect: %v", token.Error())
+	}
+	watcher.Subscribe("$SYS/clients/tracked-device/status", 0, func(c mqtt.Client, msg mqtt.Message) {
+		var body map[string]any
+		json.Unmarshal(msg.Payload(), &body)
+		status <- body
+	})
+
+	select {
+	case body := <-status:
+		if body["online"] != false || body["client_id"] != "tracked-device" {
+			t.Fatalf("Expected retained offline status, got %v", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for retained status")
+	}
+	watcher.Disconnect(250)
+	t.Log("✓ Retained status topic mirrors presence")
+	stop()
+
+	srv, stop = launchTestServer(t, configure)
+	defer stop()
+
+	offline := false
+	list := srv.ClientPresences(&offline)
+	if len(list) != 2 || list[1].ClientID != "tracked-device" || list[1].LastSeen.IsZero() || list[1].Connections != 1 {
+		t.Fatalf("Expected tracked-device offline with a last-seen time after restart, got %+v", list)
+	}
+	t.Log("✓ Presence records survived restart")
+}
+
+// TestMQTTAnnotationWrapJSON tests that MQTT 3.1.1 subscribers receive
+// annotated messages wrapped in a JSON envelope
+func TestMQTTAnnotationWrapJSON(t *testing.T) {
+	_, cleanup := startTestServerWith(t, func(cfg *config.Config) {
+		cfg.Annotation = config.AnnotationConfig{Enabled: true, Topics: []string{"annotated/#"}, WrapJSON: true}
+	})
+	defer cleanup()
+
+	received := make(chan []byte, 2)
+	subOpts := mqtt.NewClientOptions()
+	subOpts.AddBroker(brokerURL(t))
+	subOpts.SetClientID("annotation-subscriber")
+	subscriber := mqtt.NewClient(subOpts)
+	if token := subscriber.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Subscriber failed to connect: %v", token.Error())
+	}
+	defer subscriber.Disconnect(250)
+	token := subscriber.Subscribe("#", 1, func(c mqtt.Client, msg mqtt.Message) {
+		received <- msg.Payload()
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+
+	pubOpts := mqtt.NewClientOptions()
+	pubOpts.AddBroker(brokerURL(t))
+	pubOpts.SetClientID("annotated-device")
+	publisher := mqtt.NewClient(pubOpts)
+	if token := publisher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Publisher failed to connect: %v", token.Error())
+	}
+	defer publisher.Disconnect(250)
+
+	publisher.Publish("annotated/temp", 1, false, `{"celsius":21.5}`).Wait()
+	select {
+	case payload := <-received:
+		var env struct {
+			ReceivedAt time.Time       `json:"received_at"`
+			ClientID   string          `json:"client_id"`
+			Listener   string          `json:"listener"`
+			Payload    json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(payload, &env); err != nil {
+			t.Fatalf("Expected JSON envelope, got %s", payload)
+		}
+		if env.ClientID != "annotated-device" || env.Listener != "tcp" || env.ReceivedAt.IsZero() || string(env.Payload) != `{"celsius":21.5}` {
+			t.Fatalf("Unexpected envelope %s", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for annotated message")
+	}
+	t.Log("✓ Annotated message wrapped with receive time, publisher and listener")
+
+	publisher.Publish("plain/temp", 1, false, "21.5").Wait()
+	select {
+	case payload := <-received:
+		if string(payload) != "21.5" {
+			t.Fatalf("Expected unannotated payload unchanged, got %s", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for plain message")
+	}
+	t.Log("✓ Messages outside the annotated topics left unchanged")
+}
+
+func TestMQTTTranscodingCBOR(t *testing.T) {
+	_, cleanup := startTestServerWith(t, func(cfg *config.Config) {
+		cfg.Transcoding = []config.TranscodingRuleConfig{{Filter: "devices/#", Format: "cbor", ClientIDs: []string{"device-*"}}}
+	})
+	defer cleanup()
+
+	received := make(chan []byte, 2)
+	backendOpts := mqtt.NewClientOptions()
+	backendOpts.AddBroker(brokerURL(t))
+	backendOpts.SetClientID("transcoding-backend")
+	backend := mqtt.NewClient(backendOpts)
+	if token := backend.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Backend failed to connect: %v", token.Error())
+	}
+	defer backend.Disconnect(250)
+	token := backend.Subscribe("devices/+/telemetry", 1, func(c mqtt.Client, msg mqtt.Message) {
+		received <- msg.Payload()
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+
+	device, _ := dialRaw(t, "device-1")
+	defer device.conn.Close()
+	device.subscribe("devices/1/cmd")
+
+	publishQoS1(t, "devices/1/cmd", `{"led":true,"level":3}`)
+	payload, packetID, _ := device.readPublish()
+	device.puback(packetID)
+	if want := "\xa2\x63led\xf5\x65level\x03"; payload != want {
+		t.Fatalf("Expected CBOR command % x, got % x", want, payload)
+	}
+	t.Log("✓ JSON command delivered to the device as CBOR")
+
+	publish := func(payload string) {
+		topic := "devices/1/telemetry"
+		body := binary.BigEndian.AppendUint16(nil, uint16(len(topic)))
+		device.send(0x30, append(append(body, topic...), payload...))
+	}
+	publish("\xa1\x64temp\xfa\x41\xac\x00\x00")
+	select {
+	case payload := <-received:
+		if string(payload) != `{"temp":21.5}` {
+			t.Fatalf("Expected JSON telemetry, got %q", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for telemetry")
+	}
+	t.Log("✓ CBOR telemetry delivered to the backend as JSON")
+
+	publish("\xff")
+	select {
+	case payload := <-received:
+		t.Fatalf("Invalid CBOR payload routed: %q", payload)
+	case <-time.After(300 * time.Millisecond):
+	}
+	t.Log("✓ Invalid CBOR payload dropped")
+}
+
+func TestMQTTSchemaRegistry(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/subjects/sensors-value/versions/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"subject":    "sensors-value",
+			"version":    3,
+			"id":         42,
+			"schemaType": "JSON",
+			"schema":     `{"type":"object","required":["temp"],"properties":{"temp":{"type":"number","maximum":100}}}`,
+		})
+	}))
+	defer registry.Close()
+
+	_, cleanup := startTestServerWith(t, func(cfg *config.Config) {
+		cfg.SchemaRegistry = config.SchemaRegistryConfig{
+			Enabled:     true,
+			Kind:        "confluent",
+			URL:         registry.URL,
+			Timeout:     2 * time.Second,
+			CacheTTL:    time.Minute,
+			Unavailable: "reject",
+			Rules:       []config.SchemaRuleConfig{{Filter: "sensors/#", Subject: "sensors-value", Action: "reject"}},
+		}
+	})
+	defer cleanup()
+
+	received := make(chan string, 4)
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("schema-client")
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to connect: %v", token.Error())
+	}
+	defer client.Disconnect(250)
+	token := client.Subscribe("sensors/#", 0, func(c mqtt.Client, msg mqtt.Message) {
+		received <- string(msg.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+
+	for _, payload := range []string{`{"temp":150}`, `{"humidity":40}`, `not json`, `{"temp":21.5}`} {
+		client.Publish("sensors/1", 0, false, payload).Wait()
+	}
+	select {
+	case payload := <-received:
+		if payload != `{"temp":21.5}` {
+			t.Fatalf("Expected only the valid message, got %q", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the valid message")
+	}
+	t.Log("✓ Only the message matching the registry schema was routed")
+}
+
+func TestMQTTACLWithholdsRetained(t *testing.T) {
+	aclFile := filepath.Join(t.TempDir(), "acl")
+	if err := os.WriteFile(aclFile, []byte("topic readwrite #\ntopic deny secret/#\n"), 0600); err != nil {
+		t.Fatalf("Failed to write ACL file: %v", err)
+	}
+	srv, cleanup := startTestServerWith(t, func(cfg *config.Config) {
+		cfg.Auth = config.AuthConfig{Enabled: true, AllowAnonymous: true, ACLFile: aclFile}
+	})
+	defer cleanup()
+
+	// Retained state injected by the broker bypasses the publish ACL
+	srv.Publish(&server.Message{Topic: "public/state", Payload: []byte("public"), QoS: 1, Retain: true})
+	srv.Publish(&server.Message{Topic: "secret/state", Payload: []byte("secret"), QoS: 1, Retain: true})
+
+	received := make(chan string, 4)
+	subOpts := mqtt.NewClientOptions()
+	subOpts.AddBroker(brokerURL(t))
+	subOpts.SetClientID("acl-subscriber")
+	subscriber := mqtt.NewClient(subOpts)
+	if token := subscriber.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Subscriber failed to connect: %v", token.Error())
+	}
+	defer subscriber.Disconnect(250)
+	token := subscriber.Subscribe("#", 1, func(c mqtt.Client, msg mqtt.Message) {
+		received <- msg.Topic()
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+
+	srv.Publish(&server.Message{Topic: "secret/live", Payload: []byte("secret"), QoS: 1})
+	srv.Publish(&server.Message{Topic: "public/live", Payload: []byte("public"), QoS: 1})
+
+	var topics []string
+	timeout := time.After(1 * time.Second)
+	for done := false; !done; {
+		select {
+		case topic := <-received:
+			topics = append(topics, topic)
+		case <-timeout:
+			done = true
+		}
+	}
+	if len(topics) != 2 || topics[0] != "public/state" || topics[1] != "public/live" {
+		t.Fatalf("Expected only public/state and public/live, got %v", topics)
+	}
+	t.Log("✓ Retained and live messages on denied topics withheld from a wildcard subscriber")
+}
@@ -0,0 +1,92 @@
+package integration
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/pkg/mqtttest"
+)
+
+// TestMQTTTestPackage tests the broker test double package: an ephemeral
+// broker on a free port whose sessions live in the in-memory store
+func TestMQTTTestPackage(t *testing.T) {
+	st := mqtttest.NewStore()
+	broker := mqtttest.Start(t, mqtttest.WithStore(st), mqtttest.WithConfig(func(cfg *config.Config) {
+		cfg.QoS.MaxQoS = 1
+	}))
+	if broker.Addr == "127.0.0.1:1883" {
+		t.Fatalf("Expected a free port, got %s", broker.Addr)
+	}
+
+	connect := func(clientID string, clean bool) mqtt.Client {
+		opts := mqtt.NewClientOptions()
+		opts.AddBroker(broker.URL())
+		opts.SetClientID(clientID)
+		opts.SetCleanSession(clean)
+		opts.SetAutoReconnect(false)
+		client := mqtt.NewClient(opts)
+		if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("%s failed to connect: %v", clientID, token.Error())
+		}
+		return client
+	}
+
+	sub := connect("mqtttest-sub", false)
+	if token := sub.Subscribe("mqtttest/#", 1, nil); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+	sub.Disconnect(250)
+
+	pub := connect("mqtttest-pub", true)
+	defer pub.Disconnect(250)
+	if token := pub.Publish("mqtttest/queued", 1, false, "while offline"); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("Failed to publish: %v", token.Error())
+	}
+
+	waitFor(t, "the message queued in the memory store", func() bool {
+		queued, err := st.PeekMessages(context.Background(), "mqtttest-sub")
+		if err != nil {
+			t.Fatalf("Failed to peek queue: %v", err)
+		}
+		return len(queued) == 1 && string(queued[0].Payload) == "while offline"
+	})
+	if _, err := st.LoadSession(context.Background(), "mqtttest-sub"); err != nil {
+		t.Fatalf("Expected the session in the memory store: %v", err)
+	}
+	t.Log("✓ Ephemeral broker keeps sessions and queues in the memory store")
+}
+
+// TestMQTTEphemeralPorts tests that brokers on system-chosen ports run side
+// by side in parallel tests
+func TestMQTTEphemeralPorts(t *testing.T) {
+	addrs := make(chan string, 2)
+	t.Run("group", func(t *testing.T) {
+		for _, name := range []string{"first", "second"} {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				broker := mqtttest.Start(t)
+				if addr := broker.Server.Addr(); addr == nil || addr.(*net.TCPAddr).Port == 0 {
+					t.Fatalf("Expected a bound port, got %v", addr)
+				}
+				opts := mqtt.NewClientOptions()
+				opts.AddBroker(broker.URL())
+				opts.SetClientID("ephemeral-" + name)
+				client := mqtt.NewClient(opts)
+				if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+					t.Fatalf("Failed to connect to %s: %v", broker.Addr, token.Error())
+				}
+				client.Disconnect(250)
+				addrs <- broker.Addr
+			})
+		}
+	})
+	if first, second := <-addrs, <-addrs; first == second {
+		t.Fatalf("Expected two ports, both brokers listened on %s", first)
+	}
+	t.Log("✓ Parallel brokers listened on their own system-chosen ports")
+}
@@ -0,0 +1,117 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// ping sends a PINGREQ and reads the PINGRESP
+func (c *rawClient) ping() {
+	c.send(0xc0, nil)
+	if first, _ := c.read(); first != 0xd0 {
+		c.t.Fatalf("Expected PINGRESP, got %#x", first)
+	}
+}
+
+// expectClosed checks that the broker closes the connection without
+// answering
+func (c *rawClient) expectClosed() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if b, err := c.reader.ReadByte(); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		c.t.Fatalf("Expected the broker to close the connection, got %#x, %v", b, err)
+	}
+}
+
+// TestMQTTParserBudgetConnected tests that a connected client sending more
+// packets or bytes per second than its parser budget is disconnected,
+// while one staying within it is not
+func TestMQTTParserBudgetConnected(t *testing.T) {
+	srv, stop := launchTestServer(t, func(cfg *config.Config) {
+		cfg.Limits.MaxPacketRate = 5
+		cfg.Limits.MaxByteRate = 100
+	})
+	defer stop()
+
+	// A second's worth of packets may arrive at once, and the budget
+	// refills over time
+	steady, _ := dialRaw(t, "budget-steady")
+	defer steady.conn.Close()
+	for range 5 {
+		steady.ping()
+	}
+	time.Sleep(1100 * time.Millisecond)
+	for range 5 {
+		steady.ping()
+	}
+	t.Log("✓ Client within the packet budget kept")
+
+	flood, _ := dialRaw(t, "budget-flood")
+	defer flood.conn.Close()
+	for range 5 {
+		flood.ping()
+	}
+	flood.send(0xc0, nil)
+	flood.expectClosed()
+	waitForDisconnect(t, "budget-flood")
+	t.Log("✓ Client over the packet budget disconnected")
+
+	// The second publish of 62 bytes exceeds the 100 bytes per second
+	bulk, _ := dialRaw(t, "budget-bulk")
+	defer bulk.conn.Close()
+	publish := func(payload string) {
+		body := binary.BigEndian.AppendUint16(nil, uint16(len("bulk")))
+		body = append(body, "bulk"...)
+		bulk.send(0x30, append(body, payload...))
+	}
+	publish(strings.Repeat("x", 54))
+	publish(strings.Repeat("y", 54))
+	bulk.expectClosed()
+	waitForDisconnect(t, "budget-bulk")
+	t.Log("✓ Client over the byte budget disconnected")
+
+	if state, err := srv.SessionState("budget-steady"); err != nil || !state.Connected {
+		t.Errorf("Expected the client within its budget to stay connected, got %v", err)
+	}
+}
+
+// TestMQTTParserBudgetPreAuth tests that a CONNECT larger than the
+// pre-authentication byte budget is refused before it is parsed
+func TestMQTTParserBudgetPreAuth(t *testing.T) {
+	srv, stop := launchTestServer(t, func(cfg *config.Config) {
+		cfg.Limits.MaxPreAuthPacketRate = 1
+		cfg.Limits.MaxPreAuthByteRate = 64
+	})
+	defer stop()
+
+	conn, err := net.Dial("tcp", brokerAddr(t))
+	if err != nil {
+		t.Fatalf("Failed to dial broker: %v", err)
+	}
+	defer conn.Close()
+	large := &rawClient{t: t, clientID: "budget-" + strings.Repeat("l", 60), conn: conn, reader: bufio.NewReader(conn)}
+	body := []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 60}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(large.clientID)))
+	large.send(0x10, append(body, large.clientID...))
+	large.expectClosed()
+	if _, err := srv.SessionState(large.clientID); err == nil {
+		t.Fatal("Expected no session for the refused CONNECT")
+	}
+	t.Log("✓ CONNECT over the pre-authentication byte budget refused")
+
+	// The budget of a connected client is the connected one, unlimited here
+	small, _ := dialRaw(t, "budget-small")
+	defer small.conn.Close()
+	for range 3 {
+		small.ping()
+	}
+	t.Log("✓ CONNECT within the pre-authentication budget accepted")
+}
@@ -0,0 +1,92 @@
+package integration
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// publishV311 sends a QoS 1 PUBLISH from an MQTT 3.1.1 client and reads
+// its PUBACK
+func (c *rawClient) publishV311(topic string, packetID uint16, payload string) {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(topic)))
+	body = append(body, topic...)
+	body = binary.BigEndian.AppendUint16(body, packetID)
+	body = append(body, payload...)
+	c.send(0x32, body)
+
+	if first, ack := c.read(); first != 0x40 || len(ack) != 2 || binary.BigEndian.Uint16(ack) != packetID {
+		c.t.Fatalf("Expected PUBACK for packet %d, got %#x % x", packetID, first, ack)
+	}
+}
+
+// TestMQTTPublishRefusals tests that QoS 1 messages the ACL denies, with an
+// invalid topic name or over the rate limit are not routed: MQTT 5 clients
+// are told why in the PUBACK, MQTT 3.1.1 clients get a plain PUBACK
+func TestMQTTPublishRefusals(t *testing.T) {
+	aclFile := filepath.Join(t.TempDir(), "acl")
+	if err := os.WriteFile(aclFile, []byte("topic readwrite #\ntopic deny secret/#\n"), 0600); err != nil {
+		t.Fatalf("Failed to write ACL file: %v", err)
+	}
+	_, stop := launchTestServer(t, func(cfg *config.Config) {
+		cfg.Auth = config.AuthConfig{Enabled: true, AllowAnonymous: true, ACLFile: aclFile}
+		cfg.Groups = []config.GroupConfig{{Name: "limited", ClientIDs: []string{"refusal-limited-*"}, RateLimit: 0.001, RateBurst: 1}}
+	})
+	defer stop()
+
+	watcher, _ := dialRaw(t, "refusal-watcher")
+	defer watcher.conn.Close()
+	watcher.subscribe("#")
+
+	v5, _ := dialV5(t, "refusal-v5", 0, "")
+	defer v5.conn.Close()
+	if reason := v5.publishV5("secret/x", 1, "denied-v5"); reason != 0x87 {
+		t.Errorf("Expected PUBACK 0x87 for a denied topic, got %#x", reason)
+	}
+	if reason := v5.publishV5("open/+", 2, "wildcard-v5"); reason != 0x90 {
+		t.Errorf("Expected PUBACK 0x90 for a topic with a wildcard, got %#x", reason)
+	}
+	if reason := v5.publishV5("open/x", 3, "allowed-v5"); reason != 0x00 {
+		t.Errorf("Expected PUBACK 0x00 for an allowed topic, got %#x", reason)
+	}
+
+	limitedV5, _ := dialV5(t, "refusal-limited-v5", 0, "")
+	defer limitedV5.conn.Close()
+	if reason := limitedV5.publishV5("open/x", 1, "burst-v5"); reason != 0x00 {
+		t.Errorf("Expected PUBACK 0x00 within the burst, got %#x", reason)
+	}
+	if reason := limitedV5.publishV5("open/x", 2, "limited-v5"); reason != 0x97 {
+		t.Errorf("Expected PUBACK 0x97 over the rate limit, got %#x", reason)
+	}
+	t.Log("✓ MQTT 5 clients told why their messages were refused")
+
+	v3, _ := dialRaw(t, "refusal-v3")
+	defer v3.conn.Close()
+	v3.publishV311("secret/x", 1, "denied-v3")
+	v3.publishV311("open/x", 2, "allowed-v3")
+
+	limitedV3, _ := dialRaw(t, "refusal-limited-v3")
+	defer limitedV3.conn.Close()
+	limitedV3.publishV311("open/x", 1, "burst-v3")
+	limitedV3.publishV311("open/x", 2, "limited-v3")
+	t.Log("✓ MQTT 3.1.1 clients acknowledged whether or not their messages were refused")
+
+	// Only the accepted messages reach subscribers, in whichever order the
+	// publishers' connections routed them
+	var routed []string
+	for range 4 {
+		payload, packetID, _ := watcher.readPublish()
+		watcher.puback(packetID)
+		routed = append(routed, payload)
+	}
+	watcher.expectNoPacket()
+	slices.Sort(routed)
+	if want := []string{"allowed-v3", "allowed-v5", "burst-v3", "burst-v5"}; !slices.Equal(routed, want) {
+		t.Fatalf("Expected %v to be routed, got %v", want, routed)
+	}
+	t.Log("✓ Refused messages not routed for either protocol version")
+}
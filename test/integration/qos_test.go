@@ -0,0 +1,297 @@
+package integration
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// TestMQTTQoSDowngradeMatrix tests every publisher/subscriber QoS
+// combination under max_qos 1 and 2: the broker grants at most its max_qos
+// and delivers with the lower of publish and granted QoS
+func TestMQTTQoSDowngradeMatrix(t *testing.T) {
+	testCases := []struct {
+		maxQoS      byte
+		pubQoS      byte
+		subQoS      byte
+		wantGranted byte
+		wantQoS     byte
+	}{
+		{maxQoS: 1, pubQoS: 0, subQoS: 0, wantGranted: 0, wantQoS: 0},
+		{maxQoS: 1, pubQoS: 0, subQoS: 1, wantGranted: 1, wantQoS: 0},
+		{maxQoS: 1, pubQoS: 0, subQoS: 2, wantGranted: 1, wantQoS: 0},
+		{maxQoS: 1, pubQoS: 1, subQoS: 0, wantGranted: 0, wantQoS: 0},
+		{maxQoS: 1, pubQoS: 1, subQoS: 1, wantGranted: 1, wantQoS: 1},
+		{maxQoS: 1, pubQoS: 1, subQoS: 2, wantGranted: 1, wantQoS: 1},
+		{maxQoS: 1, pubQoS: 2, subQoS: 0, wantGranted: 0, wantQoS: 0},
+		{maxQoS: 1, pubQoS: 2, subQoS: 1, wantGranted: 1, wantQoS: 1},
+		{maxQoS: 1, pubQoS: 2, subQoS: 2, wantGranted: 1, wantQoS: 1},
+		{maxQoS: 2, pubQoS: 0, subQoS: 0, wantGranted: 0, wantQoS: 0},
+		{maxQoS: 2, pubQoS: 0, subQoS: 1, wantGranted: 1, wantQoS: 0},
+		{maxQoS: 2, pubQoS: 0, subQoS: 2, wantGranted: 2, wantQoS: 0},
+		{maxQoS: 2, pubQoS: 1, subQoS: 0, wantGranted: 0, wantQoS: 0},
+		{maxQoS: 2, pubQoS: 1, subQoS: 1, wantGranted: 1, wantQoS: 1},
+		{maxQoS: 2, pubQoS: 1, subQoS: 2, wantGranted: 2, wantQoS: 1},
+		{maxQoS: 2, pubQoS: 2, subQoS: 0, wantGranted: 0, wantQoS: 0},
+		{maxQoS: 2, pubQoS: 2, subQoS: 1, wantGranted: 1, wantQoS: 1},
+		{maxQoS: 2, pubQoS: 2, subQoS: 2, wantGranted: 2, wantQoS: 2},
+	}
+
+	for _, maxQoS := range []byte{1, 2} {
+		t.Run(fmt.Sprintf("max_qos%d", maxQoS), func(t *testing.T) {
+			_, cleanup := startTestServerWith(t, func(cfg *config.Config) {
+				cfg.QoS.MaxQoS = maxQoS
+			})
+			defer cleanup()
+
+			pubOpts := mqtt.NewClientOptions()
+			pubOpts.AddBroker(brokerURL(t))
+			pubOpts.SetClientID("qos-matrix-publisher")
+			publisher := mqtt.NewClient(pubOpts)
+			if token := publisher.Connect(); token.Wait() && token.Error() != nil {
+				t.Fatalf("Publisher failed to connect: %v", token.Error())
+			}
+			defer publisher.Disconnect(250)
+
+			for _, tc := range testCases {
+				if tc.maxQoS != maxQoS {
+					continue
+				}
+				t.Run(fmt.Sprintf("pub%d_sub%d", tc.pubQoS, tc.subQoS), func(t *testing.T) {
+					topic := fmt.Sprintf("qos/matrix/%d/%d", tc.pubQoS, tc.subQoS)
+					received := make(chan byte, 1)
+
+					subOpts := mqtt.NewClientOptions()
+					subOpts.AddBroker(brokerURL(t))
+					subOpts.SetClientID(fmt.Sprintf("qos-matrix-sub-%d-%d", tc.pubQoS, tc.subQoS))
+					subscriber := mqtt.NewClient(subOpts)
+					if token := subscriber.Connect(); token.Wait() && token.Error() != nil {
+						t.Fatalf("Subscriber failed to connect: %v", token.Error())
+					}
+					defer subscriber.Disconnect(250)
+
+					token := subscriber.Subscribe(topic, tc.subQoS, func(c mqtt.Client, msg mqtt.Message) {
+						received <- msg.Qos()
+					})
+					if token.Wait() && token.Error() != nil {
+						t.Fatalf("Failed to subscribe: %v", token.Error())
+					}
+					if granted := token.(*mqtt.SubscribeToken).Result()[topic]; granted != tc.wantGranted {
+						t.Fatalf("Granted QoS %d, want %d", granted, tc.wantGranted)
+					}
+
+					if token := publisher.Publish(topic, tc.pubQoS, false, "data"); token.Wait() && token.Error() != nil {
+						t.Fatalf("Failed to publish: %v", token.Error())
+					}
+
+					select {
+					case qos := <-received:
+						if qos != tc.wantQoS {
+							t.Fatalf("Delivered with QoS %d, want %d", qos, tc.wantQoS)
+						}
+					case <-time.After(2 * time.Second):
+						t.Fatal("Timeout waiting for message")
+					}
+				})
+			}
+		})
+	}
+	t.Log("✓ All QoS combinations delivered with min(publish QoS, granted QoS)")
+}
+
+// TestMQTTOfflineQueueQoS tests that messages queued for an offline
+// persistent session use the granted QoS and that QoS 0 deliveries are not
+// queued
+func TestMQTTOfflineQueueQoS(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	type message struct {
+		payload string
+		qos     byte
+	}
+	received := make(chan message, 10)
+
+	subOpts := mqtt.NewClientOptions()
+	subOpts.AddBroker(brokerURL(t))
+	subOpts.SetClientID("offline-subscriber")
+	subOpts.SetCleanSession(false)
+	subOpts.SetDefaultPublishHandler(func(c mqtt.Client, msg mqtt.Message) {
+		received <- message{string(msg.Payload()), msg.Qos()}
+	})
+
+	subscriber := mqtt.NewClient(subOpts)
+	if token := subscriber.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Subscriber failed to connect: %v", token.Error())
+	}
+	if token := subscriber.Subscribe("offline/#", 2, nil); token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+	subscriber.Disconnect(250)
+	waitForDisconnect(t, "offline-subscriber")
+
+	pubOpts := mqtt.NewClientOptions()
+	pubOpts.AddBroker(brokerURL(t))
+	pubOpts.SetClientID("offline-publisher")
+	publisher := mqtt.NewClient(pubOpts)
+	if token := publisher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Publisher failed to connect: %v", token.Error())
+	}
+	defer publisher.Disconnect(250)
+
+	for _, m := range []message{{"qos0", 0}, {"qos1", 1}} {
+		if token := publisher.Publish("offline/data", m.qos, false, m.payload); token.Wait() && token.Error() != nil {
+			t.Fatalf("Failed to publish: %v", token.Error())
+		}
+	}
+	t.Log("✓ Published while subscriber was offline")
+
+	subscriber = mqtt.NewClient(subOpts)
+	if token := subscriber.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Subscriber failed to reconnect: %v", token.Error())
+	}
+	defer subscriber.Disconnect(250)
+
+	select {
+	case m := <-received:
+		if m.payload != "qos1" || m.qos != 1 {
+			t.Fatalf("Expected queued qos1 message with QoS 1, got %q with QoS %d", m.payload, m.qos)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for queued message")
+	}
+	select {
+	case m := <-received:
+		t.Fatalf("Unexpected extra message %q (QoS %d)", m.payload, m.qos)
+	case <-time.After(500 * time.Millisecond):
+	}
+	t.Log("✓ Queued message delivered with granted QoS, QoS 0 message not queued")
+}
+
+// withQoS2 configures the test server to grant QoS 2
+func withQoS2(cfg *config.Config) {
+	cfg.QoS.MaxQoS = 2
+}
+
+// subscribeQoS2 subscribes a raw client to a topic filter with QoS 2
+func (c *rawClient) subscribeQoS2(filter string) {
+	body := []byte{0, 1}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(filter)))
+	body = append(body, filter...)
+	body = append(body, 2)
+	c.send(0x82, body)
+	if first, suback := c.read(); first != 0x90 || suback[len(suback)-1] != 2 {
+		c.t.Fatalf("Expected SUBACK granting QoS 2, got %#x % x", first, suback)
+	}
+}
+
+// publishQoS2 sends a QoS 2 PUBLISH, with the DUP flag if dup is set
+func (c *rawClient) publishQoS2(topic string, packetID uint16, payload string, dup bool) {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(topic)))
+	body = append(body, topic...)
+	body = binary.BigEndian.AppendUint16(body, packetID)
+	body = append(body, payload...)
+	first := byte(0x34)
+	if dup {
+		first |= 0x08
+	}
+	c.send(first, body)
+}
+
+// expectAck reads a PUBREC, PUBREL or PUBCOMP for a packet ID
+func (c *rawClient) expectAck(want byte, packetID uint16) {
+	first, body := c.read()
+	if first != want || len(body) < 2 || binary.BigEndian.Uint16(body) != packetID {
+		c.t.Fatalf("Expected %#x for packet %d, got %#x % x", want, packetID, first, body)
+	}
+}
+
+// TestMQTTQoS2Inbound tests the QoS 2 flow from a publisher: PUBREC,
+// PUBREL and PUBCOMP, with a resent PUBLISH delivered only once
+func TestMQTTQoS2Inbound(t *testing.T) {
+	_, cleanup := startTestServerWith(t, withQoS2)
+	defer cleanup()
+
+	received := make(chan byte, 10)
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("qos2-subscriber")
+	subscriber := mqtt.NewClient(opts)
+	if token := subscriber.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Subscriber failed to connect: %v", token.Error())
+	}
+	defer subscriber.Disconnect(250)
+	token := subscriber.Subscribe("qos2/in", 2, func(c mqtt.Client, msg mqtt.Message) {
+		received <- msg.Qos()
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+
+	publisher, _ := dialRaw(t, "qos2-publisher")
+	defer publisher.conn.Close()
+	publisher.publishQoS2("qos2/in", 7, "once", false)
+	publisher.expectAck(0x50, 7)
+	publisher.publishQoS2("qos2/in", 7, "once", true)
+	publisher.expectAck(0x50, 7)
+	publisher.send(0x62, binary.BigEndian.AppendUint16(nil, 7))
+	publisher.expectAck(0x70, 7)
+	t.Log("✓ PUBLISH answered with PUBREC, PUBREL with PUBCOMP")
+
+	select {
+	case qos := <-received:
+		if qos != 2 {
+			t.Fatalf("Delivered with QoS %d, want 2", qos)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for message")
+	}
+	select {
+	case <-received:
+		t.Fatal("Resent PUBLISH was delivered twice")
+	case <-time.After(500 * time.Millisecond):
+	}
+	t.Log("✓ Message delivered exactly once with QoS 2")
+}
+
+// TestMQTTQoS2ReleasedAfterRestart tests that a QoS 2 delivery the
+// subscriber has received (PUBREC) but not completed gets its PUBREL, not
+// the PUBLISH, again after a broker restart
+func TestMQTTQoS2ReleasedAfterRestart(t *testing.T) {
+	_, stop := launchTestServer(t, withQoS2)
+
+	client, _ := dialRaw(t, "qos2-restart")
+	client.subscribeQoS2("qos2/out")
+	publisher, _ := dialRaw(t, "qos2-restart-publisher")
+	publisher.publishQoS2("qos2/out", 1, "exactly once", false)
+	publisher.expectAck(0x50, 1)
+	publisher.conn.Close()
+
+	first, body := client.read()
+	if first != 0x34 {
+		t.Fatalf("Expected QoS 2 PUBLISH, got %#x", first)
+	}
+	packetID := binary.BigEndian.Uint16(body[2+len("qos2/out"):])
+	client.send(0x50, binary.BigEndian.AppendUint16(nil, packetID))
+	client.expectAck(0x62, packetID)
+	client.close()
+	stop()
+	t.Log("✓ PUBREC answered with PUBREL, broker stopped before PUBCOMP")
+
+	_, stop = launchTestServer(t, withQoS2)
+	defer stop()
+
+	client, present := dialRaw(t, "qos2-restart")
+	defer client.conn.Close()
+	if !present {
+		t.Fatal("Expected session present after broker restart")
+	}
+	client.expectAck(0x62, packetID)
+	client.send(0x70, binary.BigEndian.AppendUint16(nil, packetID))
+	client.expectNoPacket()
+	t.Log("✓ PUBREL resent after restart, delivery completed by PUBCOMP")
+}
@@ -0,0 +1,104 @@
+package integration
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// waitForGoroutines waits until no more than baseline goroutines run, so a
+// stopped server is known not to have leaked any
+func waitForGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("%d goroutines still running, expected at most %d:\n%s", runtime.NumGoroutine(), baseline, buf[:n])
+		}
+	}
+}
+
+// TestServerRestart tests that a stopped server can be started again in the
+// same process, serving clients on every run, and that stopping it ends all
+// of its goroutines, including those of clients still connected
+func TestServerRestart(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	srv, cleanup := startTestServer(t)
+	defer cleanup()
+
+	connect := func(clientID string) mqtt.Client {
+		opts := mqtt.NewClientOptions()
+		opts.AddBroker(brokerURL(t))
+		opts.SetClientID(clientID)
+		opts.SetAutoReconnect(false)
+		client := mqtt.NewClient(opts)
+		if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("%s failed to connect: %v", clientID, token.Error())
+		}
+		return client
+	}
+
+	for run := 1; run <= 3; run++ {
+		if run > 1 {
+			started := make(chan error, 1)
+			go func() { started <- srv.Start() }()
+			select {
+			case <-srv.Ready():
+			case err := <-started:
+				t.Fatalf("Run %d: server failed to start: %v", run, err)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Run %d: server did not become ready", run)
+			}
+		}
+
+		received := make(chan string, 1)
+		sub := connect(fmt.Sprintf("restart-sub-%d", run))
+		token := sub.Subscribe("restart/topic", 1, func(c mqtt.Client, msg mqtt.Message) {
+			received <- string(msg.Payload())
+		})
+		if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("Run %d: failed to subscribe: %v", run, token.Error())
+		}
+
+		pub := connect(fmt.Sprintf("restart-pub-%d", run))
+		payload := fmt.Sprintf("run %d", run)
+		if token := pub.Publish("restart/topic", 1, false, payload); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("Run %d: failed to publish: %v", run, token.Error())
+		}
+		select {
+		case got := <-received:
+			if got != payload {
+				t.Fatalf("Run %d: expected %q, got %q", run, payload, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Run %d: message not delivered", run)
+		}
+		pub.Disconnect(250)
+
+		// The subscriber is still connected when the server stops
+		if err := srv.Stop(); err != nil {
+			t.Fatalf("Run %d: failed to stop server: %v", run, err)
+		}
+		if n := srv.ClientCount(); n != 0 {
+			t.Fatalf("Run %d: %d clients left after Stop", run, n)
+		}
+		select {
+		case <-srv.Ready():
+			t.Fatalf("Run %d: stopped server reports ready", run)
+		default:
+		}
+		sub.Disconnect(0)
+
+		waitForGoroutines(t, baseline)
+		t.Logf("✓ Run %d served clients and left no goroutines behind", run)
+	}
+}
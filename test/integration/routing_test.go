@@ -0,0 +1,86 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/pkg/mqtttest"
+)
+
+// TestMQTTSubscriptionRouting tests that publishes reach the subscriptions
+// matching them, once per client with the highest QoS of its matching
+// subscriptions, and stop when they are unsubscribed or taken over
+func TestMQTTSubscriptionRouting(t *testing.T) {
+	broker := mqtttest.Start(t)
+
+	connect := func(clientID string, handler mqtt.MessageHandler) mqtt.Client {
+		opts := mqtt.NewClientOptions()
+		opts.AddBroker(broker.URL())
+		opts.SetClientID(clientID)
+		opts.SetAutoReconnect(false)
+		opts.SetDefaultPublishHandler(handler)
+		client := mqtt.NewClient(opts)
+		if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("%s failed to connect: %v", clientID, token.Error())
+		}
+		return client
+	}
+
+	received := make(chan mqtt.Message, 10)
+	sub := connect("routing-sub", func(c mqtt.Client, msg mqtt.Message) { received <- msg })
+	filters := map[string]byte{"routing/#": 0, "routing/+/temp": 1, "routing/a/temp": 0}
+	if token := sub.SubscribeMultiple(filters, nil); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("Failed to subscribe: %v", token.Error())
+	}
+
+	pub := connect("routing-pub", nil)
+	defer pub.Disconnect(250)
+	publish := func(topic string) {
+		if token := pub.Publish(topic, 1, false, topic); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("Failed to publish to %s: %v", topic, token.Error())
+		}
+	}
+	expect := func(topic string, qos byte) {
+		select {
+		case msg := <-received:
+			if msg.Topic() != topic || msg.Qos() != qos {
+				t.Fatalf("Expected %s with QoS %d, got %s with QoS %d", topic, qos, msg.Topic(), msg.Qos())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected a message on %s", topic)
+		}
+	}
+	expectNone := func() {
+		select {
+		case msg := <-received:
+			t.Fatalf("Expected no message, got one on %s", msg.Topic())
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+
+	publish("routing/a/temp")
+	expect("routing/a/temp", 1)
+	publish("routing")
+	expect("routing", 0)
+	publish("other/a/temp")
+	expectNone()
+	t.Log("✓ Overlapping subscriptions delivered once with the highest QoS")
+
+	if token := sub.Unsubscribe("routing/#", "routing/+/temp"); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("Failed to unsubscribe: %v", token.Error())
+	}
+	publish("routing/b/temp")
+	expectNone()
+	publish("routing/a/temp")
+	expect("routing/a/temp", 0)
+	t.Log("✓ Unsubscribed filters no longer match")
+
+	// A clean session taking the client ID over starts without subscriptions
+	takeover := connect("routing-sub", func(c mqtt.Client, msg mqtt.Message) { received <- msg })
+	defer takeover.Disconnect(250)
+	publish("routing/a/temp")
+	expectNone()
+	t.Log("✓ Subscriptions of a taken over connection no longer match")
+}
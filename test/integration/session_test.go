@@ -0,0 +1,657 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// rawClient is a minimal MQTT 3.1.1 client that never acknowledges
+// messages on its own, used to leave deliveries in flight
+type rawClient struct {
+	t        *testing.T
+	clientID string
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// dialRaw connects a persistent session (CleanSession=0) and returns the
+// client with the CONNACK session present flag
+func dialRaw(t *testing.T, clientID string) (*rawClient, bool) {
+	conn, err := net.Dial("tcp", brokerAddr(t))
+	if err != nil {
+		t.Fatalf("Failed to dial broker: %v", err)
+	}
+	c := &rawClient{t: t, clientID: clientID, conn: conn, reader: bufio.NewReader(conn)}
+
+	body := []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0, 0, 60}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(clientID)))
+	body = append(body, clientID...)
+	c.send(0x10, body)
+
+	first, connack := c.read()
+	if first != 0x20 || len(connack) != 2 || connack[1] != 0 {
+		t.Fatalf("Expected accepted CONNACK, got %#x % x", first, connack)
+	}
+	return c, connack[0]&0x01 == 1
+}
+
+// close drops the connection and waits until the broker has ended it
+func (c *rawClient) close() {
+	c.conn.Close()
+	waitForDisconnect(c.t, c.clientID)
+}
+
+func (c *rawClient) send(first byte, body []byte) {
+	pkt := append([]byte{first, byte(len(body))}, body...)
+	if _, err := c.conn.Write(pkt); err != nil {
+		c.t.Fatalf("Failed to write packet: %v", err)
+	}
+}
+
+// read returns the next packet's first byte and body
+func (c *rawClient) read() (byte, []byte) {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	first, err := c.reader.ReadByte()
+	if err != nil {
+		c.t.Fatalf("Failed to read packet: %v", err)
+	}
+	length, multiplier := 0, 1
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			c.t.Fatalf("Failed to read packet length: %v", err)
+		}
+		length += int(b&0x7F) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		c.t.Fatalf("Failed to read packet body: %v", err)
+	}
+	return first, body
+}
+
+// subscribe subscribes to a topic filter with QoS 1
+func (c *rawClient) subscribe(filter string) {
+	body := []byte{0, 1}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(filter)))
+	body = append(body, filter...)
+	body = append(body, 1)
+	c.send(0x82, body)
+	if first, _ := c.read(); first != 0x90 {
+		c.t.Fatalf("Expected SUBACK, got %#x", first)
+	}
+}
+
+// readPublish reads a QoS 1 PUBLISH and returns its payload, packet ID and
+// DUP flag without acknowledging it
+func (c *rawClient) readPublish() (string, uint16, bool) {
+	first, body := c.read()
+	if first>>4 != 3 || (first>>1)&0x03 != 1 {
+		c.t.Fatalf("Expected QoS 1 PUBLISH, got %#x", first)
+	}
+	topicLen := int(binary.BigEndian.Uint16(body))
+	packetID := binary.BigEndian.Uint16(body[2+topicLen:])
+	return string(body[4+topicLen:]), packetID, first&0x08 != 0
+}
+
+// puback acknowledges a QoS 1 delivery
+func (c *rawClient) puback(packetID uint16) {
+	c.send(0x40, binary.BigEndian.AppendUint16(nil, packetID))
+}
+
+// publishQoS1 publishes payloads to a topic from a separate client
+func publishQoS1(t *testing.T, topic string, payloads ...string) {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("session-publisher")
+	publisher := mqtt.NewClient(opts)
+	if token := publisher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Publisher failed to connect: %v", token.Error())
+	}
+	defer publisher.Disconnect(250)
+
+	for _, payload := range payloads {
+		if token := publisher.Publish(topic, 1, false, payload); token.Wait() && token.Error() != nil {
+			t.Fatalf("Failed to publish: %v", token.Error())
+		}
+	}
+}
+
+// TestMQTTSessionPresent tests the CONNACK session present flag
+func TestMQTTSessionPresent(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	connect := func(clientID string, clean bool) bool {
+		opts := mqtt.NewClientOptions()
+		opts.AddBroker(brokerURL(t))
+		opts.SetClientID(clientID)
+		opts.SetCleanSession(clean)
+		client := mqtt.NewClient(opts)
+		token := client.Connect()
+		if token.Wait() && token.Error() != nil {
+			t.Fatalf("Failed to connect: %v", token.Error())
+		}
+		client.Disconnect(250)
+		waitForDisconnect(t, clientID)
+		return token.(*mqtt.ConnectToken).SessionPresent()
+	}
+
+	if connect("session-present", false) {
+		t.Fatal("Expected no session present on first connect")
+	}
+	if !connect("session-present", false) {
+		t.Fatal("Expected session present when resuming a persistent session")
+	}
+	if connect("session-clean", true) || connect("session-clean", true) {
+		t.Fatal("Expected no session present for clean sessions")
+	}
+	t.Log("✓ Session present flag set only for resumed persistent sessions")
+}
+
+// TestMQTTQueuedMessagesSurviveRestart tests that messages queued for an
+// offline persistent session are delivered in order after a broker restart,
+// including messages published after the restart
+func TestMQTTQueuedMessagesSurviveRestart(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+
+	client, _ := dialRaw(t, "restart-queue")
+	client.subscribe("restart/queue")
+	client.close()
+
+	publishQoS1(t, "restart/queue", "1", "2")
+	stop()
+	t.Log("✓ Published while subscriber was offline, broker stopped")
+
+	_, stop = launchTestServer(t, nil)
+	defer stop()
+	publishQoS1(t, "restart/queue", "3")
+
+	client, present := dialRaw(t, "restart-queue")
+	defer client.conn.Close()
+	if !present {
+		t.Fatal("Expected session present after broker restart")
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		payload, packetID, _ := client.readPublish()
+		if payload != want {
+			t.Fatalf("Expected queued message %q, got %q", want, payload)
+		}
+		client.puback(packetID)
+	}
+	t.Log("✓ Queued messages delivered in order after restart")
+}
+
+// TestMQTTQueueLimitSurvivesRestart tests that messages queued before a
+// restart count against the offline queue limit afterwards
+func TestMQTTQueueLimitSurvivesRestart(t *testing.T) {
+	limit := func(cfg *config.Config) { cfg.Limits.MaxQueuedMessages = 3 }
+	_, stop := launchTestServer(t, limit)
+
+	client, _ := dialRaw(t, "restart-limit")
+	client.subscribe("restart/limit")
+	client.close()
+	publishQoS1(t, "restart/limit", "1", "2")
+	stop()
+
+	srv, stop := launchTestServer(t, limit)
+	defer stop()
+	publishQoS1(t, "restart/limit", "3", "4", "5")
+	state, err := srv.SessionState("restart-limit")
+	if err != nil {
+		t.Fatalf("Failed to get session state: %v", err)
+	}
+	if state.Queued.Messages != 3 {
+		t.Fatalf("Expected the queue to stop at its limit of 3, got %d messages", state.Queued.Messages)
+	}
+
+	client, _ = dialRaw(t, "restart-limit")
+	defer client.conn.Close()
+	for _, want := range []string{"1", "2", "3"} {
+		payload, packetID, _ := client.readPublish()
+		if payload != want {
+			t.Fatalf("Expected queued message %q, got %q", want, payload)
+		}
+		client.puback(packetID)
+	}
+	client.expectNoPacket()
+	t.Log("✓ Messages queued before the restart counted against the limit")
+}
+
+// TestMQTTQueuedSessionState tests the summary of an offline session's
+// queue, read from the store a page at a time
+func TestMQTTQueuedSessionState(t *testing.T) {
+	srv, stop := launchTestServer(t, nil)
+	defer stop()
+
+	client, _ := dialRaw(t, "state-queue")
+	client.subscribe("state/#")
+	client.close()
+
+	for i := range 250 {
+		topic := "state/a"
+		if i%5 == 0 {
+			topic = "state/b"
+		}
+		srv.Publish(&server.Message{Topic: topic, Payload: []byte("xyz"), QoS: 1})
+	}
+	var state *server.SessionState
+	waitFor(t, "250 queued messages", func() bool {
+		var err error
+		state, err = srv.SessionState("state-queue")
+		return err == nil && state.Queued != nil && state.Queued.Messages == 250
+	})
+	q := state.Queued
+	if q.Sampled != 250 || q.Bytes != 750 || q.Topics["state/a"] != 200 || q.Topics["state/b"] != 50 {
+		t.Fatalf("Expected all 250 queued messages summarised, got %+v", q)
+	}
+	t.Log("✓ Queue summarised across several pages")
+}
+
+// TestMQTTInflightResumedAfterRestart tests that unacknowledged deliveries
+// are resent with DUP before queued messages when a session is resumed
+// after a broker restart
+func TestMQTTInflightResumedAfterRestart(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+
+	client, _ := dialRaw(t, "restart-inflight")
+	client.subscribe("restart/inflight")
+	publishQoS1(t, "restart/inflight", "a", "b")
+
+	// Receive both messages, acknowledging only the first
+	payload, firstID, _ := client.readPublish()
+	if payload != "a" {
+		t.Fatalf("Expected message %q, got %q", "a", payload)
+	}
+	client.puback(firstID)
+	payload, secondID, _ := client.readPublish()
+	if payload != "b" {
+		t.Fatalf("Expected message %q, got %q", "b", payload)
+	}
+	waitForInflight(t, "restart-inflight", 1)
+	client.close()
+
+	publishQoS1(t, "restart/inflight", "c")
+	stop()
+	t.Log("✓ Left one delivery unacknowledged and one queued, broker stopped")
+
+	_, stop = launchTestServer(t, nil)
+	defer stop()
+
+	client, present := dialRaw(t, "restart-inflight")
+	defer client.conn.Close()
+	if !present {
+		t.Fatal("Expected session present after broker restart")
+	}
+
+	payload, packetID, dup := client.readPublish()
+	if payload != "b" || packetID != secondID || !dup {
+		t.Fatalf("Expected resent %q with packet ID %d and DUP, got %q (ID %d, DUP %v)", "b", secondID, payload, packetID, dup)
+	}
+	client.puback(packetID)
+	payload, packetID, _ = client.readPublish()
+	if payload != "c" {
+		t.Fatalf("Expected queued message %q after the resent one, got %q", "c", payload)
+	}
+	client.puback(packetID)
+	t.Log("✓ Unacknowledged delivery resent with DUP before queued messages")
+}
+
+// unsubscribe removes a topic filter subscription
+func (c *rawClient) unsubscribe(filter string) {
+	body := []byte{0, 2}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(filter)))
+	body = append(body, filter...)
+	c.send(0xA2, body)
+	if first, _ := c.read(); first != 0xB0 {
+		c.t.Fatalf("Expected UNSUBACK, got %#x", first)
+	}
+}
+
+// waitForInflight waits until the broker has n unacknowledged deliveries
+// to the connected clientID, so the acknowledgements sent before have been
+// processed
+func waitForInflight(t *testing.T, clientID string, n int) {
+	t.Helper()
+	srv := runningServer(t)
+	waitFor(t, fmt.Sprintf("%d deliveries in flight to %s", n, clientID), func() bool {
+		state, err := srv.SessionState(clientID)
+		return err == nil && len(state.Inflight) == n
+	})
+}
+
+// expectNoPacket fails if the broker sends anything within a short window
+func (c *rawClient) expectNoPacket() {
+	c.conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if b, err := c.reader.ReadByte(); err == nil {
+		c.t.Fatalf("Unexpected packet %#x", b)
+	}
+}
+
+// TestMQTTUnsubscribeSurvivesRestart tests that UNSUBSCRIBE is persisted
+// immediately for persistent sessions
+func TestMQTTUnsubscribeSurvivesRestart(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+
+	client, _ := dialRaw(t, "restart-unsubscribe")
+	client.subscribe("restart/kept")
+	client.subscribe("restart/dropped")
+	client.unsubscribe("restart/dropped")
+	client.close()
+	stop()
+
+	_, stop = launchTestServer(t, nil)
+	defer stop()
+	publishQoS1(t, "restart/dropped", "dropped")
+	publishQoS1(t, "restart/kept", "kept")
+
+	client, _ = dialRaw(t, "restart-unsubscribe")
+	defer client.conn.Close()
+	payload, packetID, _ := client.readPublish()
+	if payload != "kept" {
+		t.Fatalf("Expected only the message of the kept subscription, got %q", payload)
+	}
+	client.puback(packetID)
+	client.expectNoPacket()
+	t.Log("✓ Unsubscribed filter stayed removed after restart")
+}
+
+// TestMQTTCleanSessionWipesStoredState tests that connecting and
+// disconnecting with CleanSession=1 discards a stored persistent session
+func TestMQTTCleanSessionWipesStoredState(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+
+	client, _ := dialRaw(t, "clean-wipe")
+	client.subscribe("clean/wipe")
+	client.close()
+	publishQoS1(t, "clean/wipe", "queued")
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("clean-wipe")
+	opts.SetCleanSession(true)
+	clean := mqtt.NewClient(opts)
+	if token := clean.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Failed to connect: %v", token.Error())
+	}
+	clean.Disconnect(250)
+	waitForDisconnect(t, "clean-wipe")
+	stop()
+
+	_, stop = launchTestServer(t, nil)
+	defer stop()
+	publishQoS1(t, "clean/wipe", "after restart")
+
+	client, present := dialRaw(t, "clean-wipe")
+	defer client.conn.Close()
+	if present {
+		t.Fatal("Expected no session present after a clean session")
+	}
+	client.expectNoPacket()
+	t.Log("✓ Clean session discarded stored subscriptions and queued messages")
+}
+
+// TestMQTTRedeliveryOrderAfterConnectionKill tests that a session resumed
+// after its TCP connection died mid-flow gets its unacknowledged deliveries
+// first, in their original order with DUP set, then the messages queued
+// while it was offline
+func TestMQTTRedeliveryOrderAfterConnectionKill(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+	defer stop()
+
+	client, _ := dialRaw(t, "kill-order")
+	client.subscribe("kill/order")
+
+	var sent []string
+	for i := 0; i < 20; i++ {
+		sent = append(sent, fmt.Sprintf("m%02d", i))
+	}
+	publishQoS1(t, "kill/order", sent...)
+
+	// Acknowledge a few deliveries, then kill the connection with the rest
+	// in flight or still on their way
+	for i := 0; i < 5; i++ {
+		payload, packetID, _ := client.readPublish()
+		if payload != sent[i] {
+			t.Fatalf("Expected message %q, got %q", sent[i], payload)
+		}
+		client.puback(packetID)
+	}
+	waitForInflight(t, "kill-order", len(sent)-5)
+	client.close()
+
+	publishQoS1(t, "kill/order", "q1", "q2")
+	want := append(sent[5:], "q1", "q2")
+
+	client, present := dialRaw(t, "kill-order")
+	defer client.conn.Close()
+	if !present {
+		t.Fatal("Expected session present after reconnect")
+	}
+	lastDup := true
+	for _, expected := range want {
+		payload, packetID, dup := client.readPublish()
+		if payload != expected {
+			t.Fatalf("Expected message %q, got %q", expected, payload)
+		}
+		if dup && !lastDup {
+			t.Fatalf("Resent message %q arrived after a new delivery", payload)
+		}
+		lastDup = dup
+		client.puback(packetID)
+	}
+	client.expectNoPacket()
+	t.Log("✓ Unacknowledged deliveries resent in order with DUP, then queued messages")
+}
+
+// TestMQTTRedeliveryOrderOnTakeover tests that a connection taking over a
+// session resends the unacknowledged deliveries of the old connection before
+// messages published after the takeover
+func TestMQTTRedeliveryOrderOnTakeover(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+	defer stop()
+
+	old, _ := dialRaw(t, "takeover-order")
+	defer old.conn.Close()
+	old.subscribe("takeover/order")
+	publishQoS1(t, "takeover/order", "a", "b", "c")
+
+	var ids []uint16
+	for _, expected := range []string{"a", "b", "c"} {
+		payload, packetID, _ := old.readPublish()
+		if payload != expected {
+			t.Fatalf("Expected message %q, got %q", expected, payload)
+		}
+		ids = append(ids, packetID)
+	}
+
+	// Take over the session while all three are unacknowledged and keep
+	// publishing
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publishQoS1(t, "takeover/order", "d", "e")
+	}()
+	client, present := dialRaw(t, "takeover-order")
+	defer client.conn.Close()
+	if !present {
+		t.Fatal("Expected session present on takeover")
+	}
+	for i, expected := range []string{"a", "b", "c"} {
+		payload, packetID, dup := client.readPublish()
+		if payload != expected || packetID != ids[i] || !dup {
+			t.Fatalf("Expected resent %q with packet ID %d and DUP, got %q (ID %d, DUP %v)", expected, ids[i], payload, packetID, dup)
+		}
+		client.puback(packetID)
+	}
+	<-done
+	for _, expected := range []string{"d", "e"} {
+		payload, packetID, dup := client.readPublish()
+		if payload != expected || dup {
+			t.Fatalf("Expected new delivery %q without DUP, got %q (DUP %v)", expected, payload, dup)
+		}
+		client.puback(packetID)
+	}
+	t.Log("✓ Taken over session resent its unacknowledged deliveries before new messages")
+}
+
+// TestMQTTDeliveryOrderWhileResuming tests that messages published while a
+// session is still sending its backlog follow the backlog in the order they
+// were published
+func TestMQTTDeliveryOrderWhileResuming(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+	defer stop()
+
+	client, _ := dialRaw(t, "resume-order")
+	client.subscribe("resume/order")
+	client.close()
+
+	var queued, live []string
+	for i := 0; i < 200; i++ {
+		queued = append(queued, fmt.Sprintf("q%03d", i))
+	}
+	for i := 0; i < 50; i++ {
+		live = append(live, fmt.Sprintf("l%03d", i))
+	}
+	publishQoS1(t, "resume/order", queued...)
+
+	// The CONNACK precedes the backlog, so the live messages are routed
+	// while it is still being sent
+	client, present := dialRaw(t, "resume-order")
+	defer client.conn.Close()
+	if !present {
+		t.Fatal("Expected session present after reconnect")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publishQoS1(t, "resume/order", live...)
+	}()
+
+	for _, expected := range append(queued, live...) {
+		payload, packetID, _ := client.readPublish()
+		if payload != expected {
+			t.Fatalf("Expected message %q, got %q", expected, payload)
+		}
+		client.puback(packetID)
+	}
+	<-done
+	client.expectNoPacket()
+	t.Log("✓ Messages published while resuming followed the backlog in order")
+}
+
+// TestMQTTInflightWindow tests that no more than limits.max_inflight_messages
+// deliveries await acknowledgement at once, for live and queued messages,
+// and that the others follow in order as acknowledgements make room
+func TestMQTTInflightWindow(t *testing.T) {
+	_, stop := launchTestServer(t, func(cfg *config.Config) {
+		cfg.Limits.MaxInflightMessages = 2
+	})
+	defer stop()
+
+	// readWindow reads the deliveries that fill the window, and checks that
+	// nothing follows until some are acknowledged
+	readWindow := func(client *rawClient, expected ...string) []uint16 {
+		var packetIDs []uint16
+		for _, want := range expected {
+			payload, packetID, _ := client.readPublish()
+			if payload != want {
+				t.Fatalf("Expected message %q, got %q", want, payload)
+			}
+			packetIDs = append(packetIDs, packetID)
+		}
+		client.expectNoPacket()
+		return packetIDs
+	}
+
+	client, _ := dialRaw(t, "window")
+	client.subscribe("window/live")
+	publishQoS1(t, "window/live", "l1", "l2", "l3", "l4", "l5")
+	acks := readWindow(client, "l1", "l2")
+	waitForInflight(t, "window", 2)
+	client.puback(acks[0])
+	acks = append(acks[1:], readWindow(client, "l3")...)
+	client.puback(acks[0])
+	client.puback(acks[1])
+	readWindow(client, "l4", "l5")
+	waitForInflight(t, "window", 2)
+	client.close()
+	t.Log("✓ Live deliveries held back until acknowledgements made room")
+
+	publishQoS1(t, "window/live", "q1", "q2", "q3")
+	client, _ = dialRaw(t, "window")
+	defer client.conn.Close()
+	acks = readWindow(client, "l4", "l5")
+	client.puback(acks[0])
+	client.puback(acks[1])
+	acks = readWindow(client, "q1", "q2")
+	client.puback(acks[0])
+	client.puback(acks[1])
+	readWindow(client, "q3")
+	t.Log("✓ Resent and queued deliveries kept within the window")
+}
+
+// TestMQTTInflightMemory tests that unacknowledged deliveries count against
+// limits.max_memory until they are acknowledged
+func TestMQTTInflightMemory(t *testing.T) {
+	srv, stop := launchTestServer(t, func(cfg *config.Config) {
+		cfg.Limits.MaxMemory = 1000
+	})
+	defer stop()
+
+	client, _ := dialRaw(t, "inflight-memory")
+	defer client.conn.Close()
+	client.subscribe("memory/#")
+	fill := func() []uint16 {
+		var acks []uint16
+		for _, topic := range []string{"memory/a", "memory/b"} {
+			srv.Publish(&server.Message{Topic: topic, Payload: bytes.Repeat([]byte("x"), 600), QoS: 1})
+			_, packetID, _ := client.readPublish()
+			acks = append(acks, packetID)
+		}
+		return acks
+	}
+
+	// The overloaded broker refuses new connections
+	connect := func() byte {
+		conn, reason, err := connectUser(brokerAddr(t), "inflight-memory-probe", "probe")
+		if err != nil {
+			t.Fatalf("CONNECT failed: %v", err)
+		}
+		conn.Close()
+		return reason
+	}
+	acks := fill()
+	if reason := connect(); reason == 0x00 {
+		t.Fatal("Expected the broker to be overloaded by the deliveries in flight")
+	}
+	t.Log("✓ Unacknowledged deliveries counted against the memory limit")
+
+	for _, packetID := range acks {
+		client.puback(packetID)
+	}
+	waitFor(t, "the broker to leave overload mode", func() bool { return connect() == 0x00 })
+	t.Log("✓ Acknowledged deliveries released from the memory limit")
+
+	// Deliveries left in flight by a connection that ended are kept by the
+	// store, not in memory
+	fill()
+	client.close()
+	waitFor(t, "the broker to leave overload mode", func() bool { return connect() == 0x00 })
+	t.Log("✓ Deliveries of an ended connection released from the memory limit")
+}
@@ -0,0 +1,209 @@
+package integration
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// testPKI is a CA with a server and a client certificate written to a
+// directory
+type testPKI struct {
+	caFile, certFile, keyFile string
+	pool                      *x509.CertPool
+	client                    tls.Certificate
+}
+
+// newTestPKI creates a CA, a server certificate for 127.0.0.1 and a client
+// certificate with the given common name
+func newTestPKI(t *testing.T, clientCN string) *testPKI {
+	dir := t.TempDir()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	issue := func(serial int64, cn string, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("Failed to issue certificate: %v", err)
+		}
+		return der, key
+	}
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	pki := &testPKI{caFile: write("ca.crt", "CERTIFICATE", caDER), pool: x509.NewCertPool()}
+	pki.pool.AddCert(ca)
+
+	serverDER, serverKey := issue(2, "127.0.0.1", x509.ExtKeyUsageServerAuth)
+	serverKeyDER, _ := x509.MarshalECPrivateKey(serverKey)
+	pki.certFile = write("server.crt", "CERTIFICATE", serverDER)
+	pki.keyFile = write("server.key", "EC PRIVATE KEY", serverKeyDER)
+
+	clientDER, clientKey := issue(3, clientCN, x509.ExtKeyUsageClientAuth)
+	pki.client = tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+	return pki
+}
+
+func TestMQTTTLSClientCertificates(t *testing.T) {
+	pki := newTestPKI(t, "device-1")
+	srv, cleanup := startTestServerWith(t, func(cfg *config.Config) {
+		cfg.TLS = config.TLSConfig{Enabled: true, Port: 0, CertFile: pki.certFile, KeyFile: pki.keyFile, CAFile: pki.caFile}
+		cfg.Auth = config.AuthConfig{Enabled: true, RequireClientCerts: true}
+	})
+	defer cleanup()
+
+	connect := func(clientID string, certs []tls.Certificate) error {
+		opts := mqtt.NewClientOptions()
+		opts.AddBroker("ssl://" + srv.TLSAddr().String())
+		opts.SetClientID(clientID)
+		opts.SetConnectTimeout(2 * time.Second)
+		opts.SetTLSConfig(&tls.Config{RootCAs: pki.pool, Certificates: certs})
+		client := mqtt.NewClient(opts)
+		token := client.Connect()
+		if !token.WaitTimeout(3*time.Second) || token.Error() != nil {
+			return token.Error()
+		}
+		client.Disconnect(250)
+		return nil
+	}
+
+	if err := connect("tls-with-cert", []tls.Certificate{pki.client}); err != nil {
+		t.Fatalf("Client with a valid certificate failed to connect: %v", err)
+	}
+	t.Log("✓ Client authenticated by its certificate over TLS")
+
+	if err := connect("tls-without-cert", nil); err == nil {
+		t.Fatal("Client without a certificate connected")
+	}
+	t.Log("✓ TLS client without a certificate refused")
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("plain-anonymous")
+	opts.SetConnectTimeout(2 * time.Second)
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.WaitTimeout(3*time.Second) && token.Error() == nil {
+		client.Disconnect(250)
+		t.Fatal("Anonymous client connected on the plain listener")
+	}
+	t.Log("✓ Plain TCP listener runs alongside and still requires credentials")
+}
+
+// dialVHost connects an MQTT 3.1.1 client with a persistent session over
+// TLS to the virtual host named serverName, and reports whether the broker
+// had a session for it. The client is named by its session key so that
+// the helpers waiting on the broker find it.
+func dialVHost(t *testing.T, srv *server.Server, serverName, clientID string) (*rawClient, bool) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", srv.TLSAddr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to dial %s: %v", serverName, err)
+	}
+	c := &rawClient{t: t, clientID: serverName + "\x00" + clientID, conn: conn, reader: bufio.NewReader(conn)}
+
+	body := []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0, 0, 60}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(clientID)))
+	body = append(body, clientID...)
+	c.send(0x10, body)
+
+	first, connack := c.read()
+	if first != 0x20 || len(connack) != 2 || connack[1] != 0 {
+		t.Fatalf("Expected accepted CONNACK, got %#x % x", first, connack)
+	}
+	return c, connack[0]&0x01 == 1
+}
+
+// TestMQTTVirtualHostSessions tests that clients of two virtual hosts using
+// the same ClientID hold separate sessions: neither takes over the other's
+// connection, subscriptions or queued messages
+func TestMQTTVirtualHostSessions(t *testing.T) {
+	pki := newTestPKI(t, "unused")
+	srv, stop := launchTestServer(t, func(cfg *config.Config) {
+		cfg.TLS = config.TLSConfig{Enabled: true, Port: 0, CertFile: pki.certFile, KeyFile: pki.keyFile,
+			VirtualHosts: []config.VirtualHostConfig{
+				{ServerName: "tenant-a.test", Mountpoint: "tenant-a/"},
+				{ServerName: "tenant-b.test", Mountpoint: "tenant-b/"},
+			},
+		}
+	})
+	defer stop()
+
+	a, present := dialVHost(t, srv, "tenant-a.test", "shared")
+	if present {
+		t.Fatal("Expected no session for tenant A")
+	}
+	a.subscribe("data/#")
+	b, present := dialVHost(t, srv, "tenant-b.test", "shared")
+	if present {
+		t.Fatal("Expected tenant B not to find the session of tenant A")
+	}
+	a.ping()
+	t.Log("✓ Same ClientID connected to both virtual hosts at once")
+
+	// Only tenant A's session queues its messages while it is offline
+	a.close()
+	b.close()
+	srv.Publish(&server.Message{Topic: "tenant-a/data/x", Payload: []byte("for-a"), QoS: 1})
+	b, present = dialVHost(t, srv, "tenant-b.test", "shared")
+	if !present {
+		t.Fatal("Expected tenant B to resume its own session")
+	}
+	b.expectNoPacket()
+	b.close()
+	t.Log("✓ Tenant B got neither the subscriptions nor the queue of tenant A")
+
+	a, present = dialVHost(t, srv, "tenant-a.test", "shared")
+	defer a.conn.Close()
+	if !present {
+		t.Fatal("Expected tenant A to resume its session")
+	}
+	if payload, _, _ := a.readPublish(); payload != "for-a" {
+		t.Fatalf("Expected the queued message, got %q", payload)
+	}
+	t.Log("✓ Tenant A resumed its session with its queued message")
+}
@@ -0,0 +1,271 @@
+package integration
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/ZindGH/MQTT-Server/internal/mqtt"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// referenceMatch is an independent topic matcher written from the MQTT
+// specification: levels are separated by '/', empty levels included, + is
+// exactly one level, # is any number of trailing levels including none,
+// and topics starting with '$' are not matched by a leading wildcard
+func referenceMatch(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (filter == "#" || strings.HasPrefix(filter, "#/") || filter == "+" || strings.HasPrefix(filter, "+/")) {
+		return false
+	}
+	var match func(f, t []string) bool
+	match = func(f, t []string) bool {
+		switch {
+		case len(f) == 0:
+			return len(t) == 0
+		case f[0] == "#":
+			return true
+		case len(t) == 0:
+			return false
+		case f[0] == "+" || f[0] == t[0]:
+			return match(f[1:], t[1:])
+		}
+		return false
+	}
+	return match(strings.Split(filter, "/"), strings.Split(topic, "/"))
+}
+
+// Levels are drawn from small pools so that generated filters and topics
+// share levels often enough to match
+var (
+	topicLevels  = []string{"a", "b", "sport", ""}
+	filterLevels = []string{"a", "b", "sport", "", "+", "+"}
+	rootLevels   = []string{"$SYS", "$share"}
+)
+
+// genLevels returns 1 to 5 levels, the first one sometimes a '$' level
+func genLevels(r *rand.Rand, pool []string) []string {
+	levels := make([]string, 1+r.Intn(5))
+	for i := range levels {
+		levels[i] = pool[r.Intn(len(pool))]
+	}
+	if r.Intn(5) == 0 {
+		levels[0] = rootLevels[r.Intn(len(rootLevels))]
+	}
+	return levels
+}
+
+// topicName is a generated valid topic name
+type topicName string
+
+func (topicName) Generate(r *rand.Rand, size int) reflect.Value {
+	topic := strings.Join(genLevels(r, topicLevels), "/")
+	if topic == "" {
+		topic = "/"
+	}
+	return reflect.ValueOf(topicName(topic))
+}
+
+// topicFilter is a generated valid topic filter
+type topicFilter string
+
+func (topicFilter) Generate(r *rand.Rand, size int) reflect.Value {
+	levels := genLevels(r, filterLevels)
+	if r.Intn(3) == 0 {
+		levels = append(levels[:r.Intn(len(levels)+1)], "#")
+	}
+	filter := strings.Join(levels, "/")
+	if filter == "" {
+		filter = "/"
+	}
+	return reflect.ValueOf(topicFilter(filter))
+}
+
+// derivedMatch is a topic with a filter derived from it by replacing levels
+// with + and cutting it short with #, which matches it unless the topic
+// starts with '$'
+type derivedMatch struct {
+	Topic  string
+	Filter string
+}
+
+func (derivedMatch) Generate(r *rand.Rand, size int) reflect.Value {
+	levels := genLevels(r, topicLevels)
+	topic := strings.Join(levels, "/")
+	if topic == "" {
+		levels, topic = []string{"", ""}, "/"
+	}
+	filter := make([]string, len(levels))
+	for i, level := range levels {
+		filter[i] = level
+		if r.Intn(3) == 0 {
+			filter[i] = "+"
+		}
+	}
+	if r.Intn(2) == 0 {
+		filter = append(filter[:r.Intn(len(filter)+1)], "#")
+	}
+	return reflect.ValueOf(derivedMatch{Topic: topic, Filter: strings.Join(filter, "/")})
+}
+
+// TestTopicMatchProperties tests the broker's topic matcher against the
+// reference matcher on generated topic names and filters
+func TestTopicMatchProperties(t *testing.T) {
+	cfg := &quick.Config{MaxCount: 20000}
+
+	agrees := func(f topicFilter, topic topicName) bool {
+		filter, name := string(f), string(topic)
+		if !mqtt.ValidTopicFilter(filter) || !mqtt.ValidTopicName(name) {
+			t.Fatalf("Generated invalid filter %q or topic %q", filter, name)
+		}
+		if got, want := server.TopicMatch(filter, name), referenceMatch(filter, name); got != want {
+			t.Logf("TopicMatch(%q, %q) = %v, reference says %v", filter, name, got, want)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(agrees, cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("✓ Matcher agrees with the reference on generated filters and topics")
+
+	derived := func(m derivedMatch) bool {
+		want := !strings.HasPrefix(m.Topic, "$") || !strings.ContainsAny(m.Filter[:1], "+#")
+		if referenceMatch(m.Filter, m.Topic) != want {
+			t.Fatalf("Reference matcher is wrong about %q and %q", m.Filter, m.Topic)
+		}
+		if got := server.TopicMatch(m.Filter, m.Topic); got != want {
+			t.Logf("TopicMatch(%q, %q) = %v, expected %v", m.Filter, m.Topic, got, want)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(derived, cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("✓ Filters derived from a topic with + and # match it")
+
+	edgeCases := []struct {
+		filter, topic string
+	}{
+		{"/", "/"},
+		{"+", "/"},
+		{"+/+", "/"},
+		{"/+", "/a"},
+		{"+/a", "/a"},
+		{"#", "/a"},
+		{"/#", "/"},
+		{"a/", "a"},
+		{"a", "a/"},
+		{"a/+", "a/"},
+		{"a/#", "a/"},
+		{"a/#", "a"},
+		{"a//b", "a/b"},
+		{"a/+/b", "a//b"},
+		{"+/#", "a"},
+		{"+/#", "/"},
+		{"+/+/#", "a"},
+		{"#", "$SYS/a"},
+		{"+/a", "$SYS/a"},
+		{"+/#", "$SYS"},
+		{"$SYS/#", "$SYS"},
+		{"$SYS/+", "$SYS/"},
+		{"/#", "$SYS"},
+	}
+	for _, tc := range edgeCases {
+		if got, want := server.TopicMatch(tc.filter, tc.topic), referenceMatch(tc.filter, tc.topic); got != want {
+			t.Errorf("TopicMatch(%q, %q) = %v, expected %v", tc.filter, tc.topic, got, want)
+		}
+	}
+	t.Log("✓ Edge cases of empty levels, '$' topics and adjacent wildcards match as specified")
+}
+
+// nextTrieDelivery returns the payload of the next delivery of a test
+// message, acknowledging it and skipping the broker's own messages
+func nextTrieDelivery(c *rawClient) string {
+	for {
+		first, body := c.read()
+		if first>>4 != 3 {
+			c.t.Fatalf("Expected PUBLISH, got %#x", first)
+		}
+		topicLen := int(binary.BigEndian.Uint16(body))
+		payload := body[2+topicLen:]
+		if (first>>1)&0x03 > 0 {
+			packetID := binary.BigEndian.Uint16(payload)
+			c.puback(packetID)
+			payload = payload[2:]
+		}
+		if strings.HasPrefix(string(payload), "trie-") {
+			return string(payload)
+		}
+	}
+}
+
+// TestTopicTrieProperties tests the subscription trie that routes live
+// publishes against the reference matcher: a client subscribed to each
+// generated filter receives exactly the generated topics it matches
+func TestTopicTrieProperties(t *testing.T) {
+	srv, stop := startTestServer(t)
+	defer stop()
+	r := rand.New(rand.NewSource(1))
+
+	// $share/ filters are shared subscriptions, which have their own tests
+	var filters []string
+	seen := make(map[string]bool)
+	for len(filters) < 60 {
+		filter := string(topicFilter("").Generate(r, 0).Interface().(topicFilter))
+		if !seen[filter] && !strings.HasPrefix(filter, "$share/") {
+			seen[filter] = true
+			filters = append(filters, filter)
+		}
+	}
+	clients := make([]*rawClient, len(filters))
+	for i, filter := range filters {
+		clients[i], _ = dialRaw(t, fmt.Sprintf("trie-%d", i))
+		defer clients[i].conn.Close()
+		// No generated filter matches the end marker of another client
+		clients[i].subscribe(fmt.Sprintf("$trie/end/%d", i))
+		body := binary.BigEndian.AppendUint16([]byte{0, 2}, uint16(len(filter)))
+		clients[i].send(0x82, append(append(body, filter...), 1))
+		// Retained broker messages may arrive before the SUBACK
+		for first, _ := clients[i].read(); first != 0x90; first, _ = clients[i].read() {
+			if first>>4 != 3 {
+				t.Fatalf("Expected SUBACK, got %#x", first)
+			}
+		}
+	}
+
+	topics := make([]string, 200)
+	for i := range topics {
+		topics[i] = string(topicName("").Generate(r, 0).Interface().(topicName))
+		srv.Publish(&server.Message{Topic: topics[i], Payload: []byte(fmt.Sprintf("trie-%d", i)), QoS: 1})
+	}
+	for i := range clients {
+		srv.Publish(&server.Message{Topic: fmt.Sprintf("$trie/end/%d", i), Payload: []byte("trie-end"), QoS: 1})
+	}
+
+	// Deliveries to a client keep the order of the publishes, so the end
+	// marker follows the matching topics and nothing else
+	matches := 0
+	for i, filter := range filters {
+		for j, topic := range topics {
+			if !referenceMatch(filter, topic) {
+				continue
+			}
+			matches++
+			if got := nextTrieDelivery(clients[i]); got != fmt.Sprintf("trie-%d", j) {
+				t.Fatalf("Filter %q: expected topic %q (trie-%d), got %s", filter, topic, j, got)
+			}
+		}
+		if got := nextTrieDelivery(clients[i]); got != "trie-end" {
+			t.Fatalf("Filter %q: unexpected delivery %s, which the reference does not match", filter, got)
+		}
+	}
+	if matches == 0 {
+		t.Fatal("Expected some generated filters to match generated topics")
+	}
+	t.Logf("✓ Trie routed %d matches of %d filters and %d topics as the reference", matches, len(filters), len(topics))
+}
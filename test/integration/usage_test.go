@@ -0,0 +1,243 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/clock"
+	"github.com/ZindGH/MQTT-Server/internal/config"
+	"github.com/ZindGH/MQTT-Server/internal/server"
+)
+
+// dialV5User connects an MQTT 5 client with a username and a clean session
+func dialV5User(t *testing.T, clientID, username string) *rawClient {
+	conn, err := net.Dial("tcp", brokerAddr(t))
+	if err != nil {
+		t.Fatalf("Failed to dial broker: %v", err)
+	}
+	c := &rawClient{t: t, clientID: clientID, conn: conn, reader: bufio.NewReader(conn)}
+
+	str := func(s string) []byte {
+		return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+	}
+	body := append(str("MQTT"), 5, 0x82, 0, 60, 0) // username, clean start
+	body = append(body, str(clientID)...)
+	body = append(body, str(username)...)
+	c.send(0x10, body)
+
+	if first, connack := c.read(); first != 0x20 || len(connack) < 2 || connack[1] != 0 {
+		t.Fatalf("Expected accepted CONNACK, got %#x % x", first, connack)
+	}
+	return c
+}
+
+// publishV5 sends a QoS 1 PUBLISH from an MQTT 5 client and returns the
+// reason code of its PUBACK
+func (c *rawClient) publishV5(topic string, packetID uint16, payload string) byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(topic)))
+	body = append(body, topic...)
+	body = binary.BigEndian.AppendUint16(body, packetID)
+	body = append(body, 0) // no properties
+	body = append(body, payload...)
+	c.send(0x32, body)
+
+	first, ack := c.read()
+	if first != 0x40 || len(ack) < 2 || binary.BigEndian.Uint16(ack) != packetID {
+		c.t.Fatalf("Expected PUBACK for packet %d, got %#x % x", packetID, first, ack)
+	}
+	if len(ack) == 2 {
+		return 0
+	}
+	return ack[2]
+}
+
+// watchUsage subscribes to published data and quota events, returning
+// the payloads of each
+func watchUsage(t *testing.T) (mqtt.Client, <-chan string, <-chan map[string]any) {
+	data := make(chan string, 20)
+	events := make(chan map[string]any, 10)
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("usage-watcher")
+	opts.SetKeepAlive(0)
+	watcher := mqtt.NewClient(opts)
+	if token := watcher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to connect: %v", token.Error())
+	}
+	token := watcher.SubscribeMultiple(map[string]byte{"data/#": 1, "quota/#": 1}, func(c mqtt.Client, msg mqtt.Message) {
+		if strings.HasPrefix(msg.Topic(), "data/") {
+			data <- string(msg.Payload())
+			return
+		}
+		var event map[string]any
+		if err := json.Unmarshal(msg.Payload(), &event); err != nil {
+			t.Errorf("Invalid quota event %s: %v", msg.Payload(), err)
+		}
+		events <- event
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to subscribe: %v", token.Error())
+	}
+	return watcher, data, events
+}
+
+// expectQuotaEvent waits for a quota event and checks whose quota it was
+func expectQuotaEvent(t *testing.T, events <-chan map[string]any, key, quota string, limit float64) {
+	t.Helper()
+	select {
+	case event := <-events:
+		if event["key"] != key || event["quota"] != quota || event["limit"] != limit {
+			t.Fatalf("Expected the %s quota event of %s with limit %v, got %v", quota, key, limit, event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timeout waiting for the %s quota event of %s", quota, key)
+	}
+}
+
+// expectData checks the data the watcher received, and that nothing else
+// follows
+func expectData(t *testing.T, data <-chan string, want ...string) {
+	t.Helper()
+	for _, payload := range want {
+		select {
+		case got := <-data:
+			if got != payload {
+				t.Fatalf("Expected %q, got %q", payload, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for %q", payload)
+		}
+	}
+	select {
+	case got := <-data:
+		t.Fatalf("Unexpected message %q", got)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+// findUsage returns the usage report of key
+func findUsage(t *testing.T, srv *server.Server, key string) server.UsageReport {
+	t.Helper()
+	for _, report := range srv.Usage() {
+		if report.Key == key {
+			return report
+		}
+	}
+	t.Fatalf("No usage report for %q in %+v", key, srv.Usage())
+	return server.UsageReport{}
+}
+
+// TestMQTTUsageQuotaReject tests that publishes over a daily message or
+// byte quota are rejected with a quota event, and admitted again once the
+// day ends in UTC
+func TestMQTTUsageQuotaReject(t *testing.T) {
+	vc := clock.NewVirtual(time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC))
+	srv, stop := launchTestServerWithClock(t, func(cfg *config.Config) {
+		cfg.UsageQuotas = config.UsageQuotaConfig{
+			Enabled:    true,
+			Per:        "username",
+			Messages:   3,
+			Policy:     "reject",
+			EventTopic: "quota/%k",
+			Overrides:  []config.UsageQuotaOverride{{Name: "bulk", Bytes: 10}},
+		}
+	}, vc)
+	defer stop()
+	watcher, data, events := watchUsage(t)
+	defer watcher.Disconnect(250)
+
+	alice := dialV5User(t, "usage-alice", "alice")
+	defer alice.conn.Close()
+	for i, payload := range []string{"a1", "a2", "a3"} {
+		if reason := alice.publishV5("data/alice", uint16(i+1), payload); reason != 0 {
+			t.Fatalf("Expected %s to be admitted, got reason %#x", payload, reason)
+		}
+	}
+	if reason := alice.publishV5("data/alice", 4, "a4"); reason != 0x97 {
+		t.Fatalf("Expected Quota exceeded for the fourth message, got reason %#x", reason)
+	}
+	expectQuotaEvent(t, events, "alice", "messages", 3)
+	if reason := alice.publishV5("data/alice", 5, "a5"); reason != 0x97 {
+		t.Fatalf("Expected Quota exceeded once over quota, got reason %#x", reason)
+	}
+	expectData(t, data, "a1", "a2", "a3")
+	t.Log("✓ Publishes over the daily message quota rejected with Quota exceeded")
+
+	// The override replaces the message quota with a byte quota
+	bulk := dialV5User(t, "usage-bulk", "bulk")
+	defer bulk.conn.Close()
+	for i, payload := range []string{"bulk-1", "bulk-2"} {
+		bulk.publishV5("data/bulk", uint16(i+1), payload)
+	}
+	expectQuotaEvent(t, events, "bulk", "bytes", 10)
+	expectData(t, data, "bulk-1")
+	select {
+	case event := <-events:
+		t.Fatalf("Expected one quota event per day, got %v", event)
+	default:
+	}
+	t.Log("✓ Override byte quota enforced with one quota event")
+
+	if report := findUsage(t, srv, "alice"); report.Day != "2024-01-01" || report.Messages != 3 || report.MessageQuota != 3 || !report.Exceeded {
+		t.Errorf("Unexpected usage of alice %+v", report)
+	}
+	if report := findUsage(t, srv, "bulk"); report.Messages != 1 || report.Bytes != 6 || report.ByteQuota != 10 || report.MessageQuota != 0 || !report.Exceeded {
+		t.Errorf("Unexpected usage of bulk %+v", report)
+	}
+
+	// The quotas reset at midnight UTC
+	vc.Advance(time.Minute)
+	if reports := srv.Usage(); len(reports) != 0 {
+		t.Errorf("Expected no usage on the new day, got %+v", reports)
+	}
+	if reason := alice.publishV5("data/alice", 6, "a6"); reason != 0 {
+		t.Fatalf("Expected a publish on the new day to be admitted, got reason %#x", reason)
+	}
+	expectData(t, data, "a6")
+	if report := findUsage(t, srv, "alice"); report.Day != "2024-01-02" || report.Messages != 1 || report.Exceeded {
+		t.Errorf("Unexpected usage of alice on the new day %+v", report)
+	}
+	t.Log("✓ Quotas reset at midnight UTC")
+}
+
+// TestMQTTUsageQuotaThrottle tests that publishes over quota are admitted
+// at the throttle rate under the throttle policy
+func TestMQTTUsageQuotaThrottle(t *testing.T) {
+	srv, stop := launchTestServer(t, func(cfg *config.Config) {
+		cfg.UsageQuotas = config.UsageQuotaConfig{
+			Enabled:      true,
+			Per:          "username",
+			Messages:     1,
+			Policy:       "throttle",
+			ThrottleRate: 0.001,
+			EventTopic:   "quota/%k",
+		}
+	})
+	defer stop()
+	watcher, data, events := watchUsage(t)
+	defer watcher.Disconnect(250)
+
+	client := dialV5User(t, "usage-throttled", "carol")
+	defer client.conn.Close()
+	reasons := []byte{
+		client.publishV5("data/carol", 1, "c1"),
+		client.publishV5("data/carol", 2, "c2"), // over quota, within the burst
+		client.publishV5("data/carol", 3, "c3"), // over quota and the rate
+	}
+	if reasons[0] != 0 || reasons[1] != 0 || reasons[2] != 0x97 {
+		t.Fatalf("Expected the first two messages admitted and the third throttled, got reasons % x", reasons)
+	}
+	expectQuotaEvent(t, events, "carol", "messages", 1)
+	expectData(t, data, "c1", "c2")
+	if report := findUsage(t, srv, "carol"); report.Messages != 2 || !report.Exceeded {
+		t.Errorf("Unexpected usage of carol %+v", report)
+	}
+	t.Log("✓ Publishes over quota throttled")
+}
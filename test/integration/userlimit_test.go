@@ -0,0 +1,113 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// connectUser sends an MQTT 5 CONNECT with a username and returns the
+// connection and the CONNACK reason code. It does not fail the test, so
+// that it can run in other goroutines.
+func connectUser(addr, clientID, username string) (net.Conn, byte, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	str := func(s string) []byte {
+		return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+	}
+	body := append(str("MQTT"), 5, 0x82, 0, 60, 0) // username, clean start
+	body = append(body, str(clientID)...)
+	body = append(body, str(username)...)
+	if _, err := conn.Write(append([]byte{0x10, byte(len(body))}, body...)); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(bufio.NewReader(conn), connack); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	if connack[0] != 0x20 {
+		conn.Close()
+		return nil, 0, fmt.Errorf("expected CONNACK, got %#x", connack[0])
+	}
+	return conn, connack[3], nil
+}
+
+// TestMQTTUsernameLimitBurst tests that a burst of concurrent CONNECTs with
+// one username is held to limits.max_connections_per_username
+func TestMQTTUsernameLimitBurst(t *testing.T) {
+	const limit, burst = 3, 50
+	_, stop := launchTestServer(t, func(cfg *config.Config) {
+		cfg.Limits.MaxConnectionsPerUsername = limit
+	})
+	defer stop()
+	addr := brokerAddr(t)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted []net.Conn
+		refused  int
+	)
+	for i := range burst {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, reason, err := connectUser(addr, fmt.Sprintf("burst-%d", i), "burst")
+			if err != nil {
+				t.Errorf("CONNECT %d failed: %v", i, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch reason {
+			case 0x00:
+				accepted = append(accepted, conn)
+			case 0x87: // not authorized
+				refused++
+				conn.Close()
+			default:
+				t.Errorf("CONNECT %d: unexpected reason %#x", i, reason)
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, conn := range accepted {
+			conn.Close()
+		}
+	}()
+	if len(accepted) != limit || refused != burst-limit {
+		t.Fatalf("Expected %d connections accepted and %d refused, got %d and %d", limit, burst-limit, len(accepted), refused)
+	}
+	t.Logf("✓ %d of %d concurrent CONNECTs accepted", limit, burst)
+
+	// A closed connection frees its slot
+	accepted[0].Close()
+	accepted = accepted[1:]
+	waitFor(t, "a slot to free", func() bool {
+		conn, reason, err := connectUser(addr, "burst-late", "burst")
+		if err != nil || reason != 0x00 {
+			if conn != nil {
+				conn.Close()
+			}
+			return false
+		}
+		accepted = append(accepted, conn)
+		return true
+	})
+	t.Log("✓ Connection accepted after another one closed")
+}
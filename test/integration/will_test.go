@@ -0,0 +1,296 @@
+package integration
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ZindGH/MQTT-Server/internal/config"
+)
+
+// rawConnectWithWill opens a plain TCP connection and sends a CONNECT with a
+// will, so the test can drop the connection without a DISCONNECT
+func rawConnectWithWill(t *testing.T, clientID, willTopic, willPayload string) net.Conn {
+	return rawConnectWithKeepAlive(t, clientID, willTopic, willPayload, 60)
+}
+
+// rawConnectWithKeepAlive is rawConnectWithWill with a keepalive in seconds
+func rawConnectWithKeepAlive(t *testing.T, clientID, willTopic, willPayload string, keepAlive uint16) net.Conn {
+	conn, err := net.Dial("tcp", brokerAddr(t))
+	if err != nil {
+		t.Fatalf("Failed to dial broker: %v", err)
+	}
+
+	str := func(s string) []byte {
+		b := make([]byte, 2, 2+len(s))
+		binary.BigEndian.PutUint16(b, uint16(len(s)))
+		return append(b, s...)
+	}
+	var body []byte
+	body = append(body, str("MQTT")...)
+	body = append(body, 4)         // protocol level
+	body = append(body, 0x02|0x04) // clean session, will flag (QoS 0)
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+	body = append(body, str(clientID)...)
+	body = append(body, str(willTopic)...)
+	body = append(body, str(willPayload)...)
+
+	packet := append([]byte{0x10, byte(len(body))}, body...)
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatalf("Failed to send CONNECT: %v", err)
+	}
+
+	connack := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(connack); err != nil || connack[0] != 0x20 || connack[3] != 0 {
+		t.Fatalf("CONNECT not accepted: % x, %v", connack, err)
+	}
+	return conn
+}
+
+// subscribeWills connects a client subscribed to topic and returns a channel
+// of received payloads
+func subscribeWills(t *testing.T, topic string) (mqtt.Client, <-chan string) {
+	received := make(chan string, 10)
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID("will-watcher")
+	opts.SetCleanSession(true)
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to connect: %v", token.Error())
+	}
+	token := client.Subscribe(topic, 0, func(c mqtt.Client, msg mqtt.Message) {
+		received <- string(msg.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("Watcher failed to subscribe: %v", token.Error())
+	}
+	return client, received
+}
+
+// connectWithWill connects a paho client that registers a will
+func connectWithWill(t *testing.T, clientID, willTopic, willPayload string) mqtt.Client {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL(t))
+	opts.SetClientID(clientID)
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false)
+	opts.SetWill(willTopic, willPayload, 0, false)
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("%s failed to connect: %v", clientID, token.Error())
+	}
+	return client
+}
+
+func expectWill(t *testing.T, received <-chan string, want string) {
+	select {
+	case got := <-received:
+		if got != want {
+			t.Fatalf("Expected will %q, got %q", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Will %q was not published", want)
+	}
+}
+
+func expectNoWill(t *testing.T, received <-chan string) {
+	select {
+	case got := <-received:
+		t.Fatalf("Unexpected will published: %q", got)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+// TestMQTTWillOnConnectionLoss tests that the will is published when the
+// connection drops without DISCONNECT
+func TestMQTTWillOnConnectionLoss(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	watcher, received := subscribeWills(t, "wills/#")
+	defer watcher.Disconnect(250)
+
+	conn := rawConnectWithWill(t, "will-dropper", "wills/dropper", "offline")
+	conn.Close()
+
+	expectWill(t, received, "offline")
+	t.Log("✓ Will published after connection loss")
+}
+
+// TestMQTTWillOnKeepAliveTimeout tests that a client sending nothing for
+// one and a half keepalive periods is disconnected and its will published
+func TestMQTTWillOnKeepAliveTimeout(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	watcher, received := subscribeWills(t, "wills/#")
+	defer watcher.Disconnect(250)
+
+	conn := rawConnectWithKeepAlive(t, "will-sleeper", "wills/sleeper", "timed out", 1)
+	defer conn.Close()
+
+	expectWill(t, received, "timed out")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected the broker to close the connection, got %v", err)
+	}
+	t.Log("✓ Silent client disconnected after 1.5x its keepalive, will published")
+}
+
+// TestMQTTWillNeedsPublishAuthorization tests that a will is only
+// published to topics its client may publish to
+func TestMQTTWillNeedsPublishAuthorization(t *testing.T) {
+	srv, cleanup := startTestServer(t)
+	defer cleanup()
+	srv.AddPublishAuthorizer(func(clientID, username, topic string) bool {
+		return topic != "wills/denied"
+	})
+
+	watcher, received := subscribeWills(t, "wills/#")
+	defer watcher.Disconnect(250)
+
+	rawConnectWithWill(t, "will-denied", "wills/denied", "denied").Close()
+	expectNoWill(t, received)
+	rawConnectWithWill(t, "will-allowed", "wills/allowed", "allowed").Close()
+	expectWill(t, received, "allowed")
+	t.Log("✓ Will to a topic the client may not publish to discarded")
+}
+
+// TestMQTTv5WillDelay tests that an MQTT 5 Will Delay Interval postpones
+// the will, that reconnecting in the meantime discards it and that a
+// session ending first publishes it at once
+func TestMQTTv5WillDelay(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	watcher, received := subscribeWills(t, "disconnect/will")
+	defer watcher.Disconnect(250)
+	oneSecond := []byte{0x18, 0, 0, 0, 1}
+
+	client, _ := dialV5WithWill(t, "will-delayed", 60, "delayed", oneSecond)
+	client.conn.Close()
+	expectNoWill(t, received)
+	expectWill(t, received, "delayed")
+	t.Log("✓ Will published after its delay")
+
+	client, _ = dialV5WithWill(t, "will-resumed", 60, "resumed", oneSecond)
+	client.close()
+	client, _ = dialV5(t, "will-resumed", 60, "")
+	defer client.conn.Close()
+	time.Sleep(time.Second)
+	expectNoWill(t, received)
+	t.Log("✓ Will discarded when the client reconnects within the delay")
+
+	client, _ = dialV5WithWill(t, "will-session-ended", 0, "ended", oneSecond)
+	client.conn.Close()
+	select {
+	case got := <-received:
+		if got != "ended" {
+			t.Fatalf("Expected will %q, got %q", "ended", got)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Will not published when the session ended")
+	}
+	t.Log("✓ Will published at once when the session ends before the delay")
+}
+
+// TestMQTTWillDelaySurvivesRestart tests that a delayed will pending when
+// the broker stops is published by the next run at its due time, once,
+// unless its client reconnects first
+func TestMQTTWillDelaySurvivesRestart(t *testing.T) {
+	_, stop := launchTestServer(t, nil)
+	twoSeconds := []byte{0x18, 0, 0, 0, 2}
+	client, _ := dialV5WithWill(t, "will-restart", 3600, "restarted", twoSeconds)
+	client.close()
+	client, _ = dialV5WithWill(t, "will-back", 3600, "back", twoSeconds)
+	client.close()
+	stop()
+
+	_, stop = launchTestServer(t, nil)
+	watcher, received := subscribeWills(t, "disconnect/will")
+	client, _ = dialV5(t, "will-back", 3600, "")
+	defer client.conn.Close()
+	expectNoWill(t, received)
+	expectWill(t, received, "restarted")
+	expectNoWill(t, received)
+	t.Log("✓ Will pending at shutdown published after the restart, reconnected client's discarded")
+
+	watcher.Disconnect(250)
+	stop()
+	_, stop = launchTestServer(t, nil)
+	defer stop()
+	watcher, received = subscribeWills(t, "disconnect/will")
+	defer watcher.Disconnect(250)
+	expectNoWill(t, received)
+	t.Log("✓ Resumed will not published again after another restart")
+}
+
+// TestMQTTWillSuppressedOnDisconnect tests that a clean DISCONNECT discards
+// the will
+func TestMQTTWillSuppressedOnDisconnect(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	watcher, received := subscribeWills(t, "wills/#")
+	defer watcher.Disconnect(250)
+
+	client := connectWithWill(t, "will-leaver", "wills/leaver", "offline")
+	client.Disconnect(250)
+
+	expectNoWill(t, received)
+	t.Log("✓ Will discarded after DISCONNECT")
+}
+
+// TestMQTTWillOnTakeover tests the default takeover policy: the replaced
+// connection's will is published
+func TestMQTTWillOnTakeover(t *testing.T) {
+	_, cleanup := startTestServer(t)
+	defer cleanup()
+
+	watcher, received := subscribeWills(t, "wills/#")
+	defer watcher.Disconnect(250)
+
+	first := connectWithWill(t, "will-taken-over", "wills/taken-over", "first gone")
+	defer first.Disconnect(0)
+	second := connectWithWill(t, "will-taken-over", "wills/taken-over", "second gone")
+
+	expectWill(t, received, "first gone")
+
+	// The new connection keeps its own will until it disconnects cleanly
+	second.Disconnect(250)
+	expectNoWill(t, received)
+	t.Log("✓ Will of the replaced connection published on takeover")
+}
+
+// TestMQTTWillSuppressedOnTakeover tests the "suppress" takeover policy
+func TestMQTTWillSuppressedOnTakeover(t *testing.T) {
+	_, cleanup := startTestServerWith(t, func(cfg *config.Config) {
+		cfg.Server.TakeoverWill = "suppress"
+	})
+	defer cleanup()
+
+	watcher, received := subscribeWills(t, "wills/#")
+	defer watcher.Disconnect(250)
+
+	first := connectWithWill(t, "will-taken-over", "wills/taken-over", "first gone")
+	defer first.Disconnect(0)
+	second := connectWithWill(t, "will-taken-over", "wills/taken-over", "second gone")
+
+	expectNoWill(t, received)
+
+	// Connection loss still publishes the will under this policy
+	conn := rawConnectWithWill(t, "will-taken-over", "wills/taken-over", "third gone")
+	conn.Close()
+	expectWill(t, received, "third gone")
+	second.Disconnect(0)
+	t.Log("✓ Will of the replaced connection suppressed on takeover")
+}
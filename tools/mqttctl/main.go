@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ZindGH/MQTT-Server/internal/version"
+)
+
+var (
+	adminURL = flag.String("admin", "http://127.0.0.1:8080", "Admin API base URL")
+	token    = flag.String("token", os.Getenv("MQTTCTL_TOKEN"), "Admin API bearer token (default $MQTTCTL_TOKEN)")
+)
+
+// tapMessage is a message event of the tap stream
+type tapMessage struct {
+	Time      time.Time `json:"time"`
+	Topic     string    `json:"topic"`
+	Origin    string    `json:"origin"`
+	QoS       byte      `json:"qos"`
+	Retain    bool      `json:"retain"`
+	Size      int       `json:"size"`
+	Payload   string    `json:"payload"`
+	Encoding  string    `json:"encoding"`
+	Truncated bool      `json:"truncated"`
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mqttctl [flags] [command]\n\n")
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  tap [-client id] [-rate n] [-max bytes] [filter]  Stream matching messages until interrupted\n")
+		fmt.Fprintf(os.Stderr, "  get <path>                                        Print an admin API resource, e.g. get presence\n")
+		fmt.Fprintf(os.Stderr, "  version                                           Print the mqttctl and broker versions\n")
+		fmt.Fprintf(os.Stderr, "  drain [server-reference]                          Refuse connections and disconnect all clients, sending MQTT 5 clients to server-reference\n")
+		fmt.Fprintf(os.Stderr, "  undrain                                           Accept connections again\n")
+		fmt.Fprintf(os.Stderr, "  (none)                                            Interactive mode\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		interactive()
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "tap":
+		fs := flag.NewFlagSet("tap", flag.ExitOnError)
+		client := fs.String("client", "", "Only messages published by this ClientID")
+		rate := fs.Float64("rate", 0, "Maximum messages per second (0 = broker maximum)")
+		maxPayload := fs.Int("max", 0, "Payload bytes shown per message (0 = broker default)")
+		fs.Parse(args[1:])
+		err = tap(ctx, tapQuery(fs.Arg(0), *client, *rate, *maxPayload))
+	case "get":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = get(ctx, args[1])
+	case "version":
+		err = printVersions(ctx)
+	case "drain":
+		if len(args) > 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = drain(ctx, strings.Join(args[1:], ""))
+	case "undrain":
+		err = undrain(ctx)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "mqttctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// interactive runs a prompt accepting tap and get commands. A running tap
+// is stopped by pressing Enter.
+func interactive() {
+	fmt.Printf("mqttctl connected to %s - type 'help' for commands\n", *adminURL)
+	if broker, err := brokerVersion(context.Background()); err == nil && !version.Compatible(version.Version, broker.Version) {
+		fmt.Printf("Warning: mqttctl %s may not work with broker %s\n", version.Version, broker.Version)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	for {
+		fmt.Print("mqttctl> ")
+		line, ok := <-lines
+		if !ok {
+			fmt.Println()
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "tap":
+			query, err := parseTapArgs(fields[1:])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			fmt.Println("Tapping - press Enter to stop")
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- tap(ctx, query) }()
+			select {
+			case err := <-done:
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+				}
+			case _, ok := <-lines:
+				cancel()
+				<-done
+				if !ok {
+					return
+				}
+			}
+			cancel()
+		case "get":
+			if len(fields) != 2 {
+				fmt.Println("Usage: get <path>")
+				continue
+			}
+			if err := get(context.Background(), fields[1]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		case "version":
+			if err := printVersions(context.Background()); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		case "drain":
+			if len(fields) > 2 {
+				fmt.Println("Usage: drain [server-reference]")
+				continue
+			}
+			if err := drain(context.Background(), strings.Join(fields[1:], "")); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		case "undrain":
+			if err := undrain(context.Background()); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		case "help":
+			fmt.Println("  tap [filter] [client=<id>] [rate=<n>] [max=<bytes>]  Stream matching messages (Enter stops)")
+			fmt.Println("  get <path>                                          Print an admin API resource, e.g. get presence")
+			fmt.Println("  version                                             Print the mqttctl and broker versions")
+			fmt.Println("  drain [server-reference]                            Refuse connections and disconnect all clients")
+			fmt.Println("  undrain                                             Accept connections again")
+			fmt.Println("  quit                                                Exit")
+		case "quit", "exit":
+			return
+		default:
+			fmt.Printf("Unknown command %q - type 'help' for commands\n", fields[0])
+		}
+	}
+}
+
+// parseTapArgs parses "filter key=value ..." arguments of the interactive
+// tap command
+func parseTapArgs(args []string) (url.Values, error) {
+	var filter, client string
+	var rate float64
+	var maxPayload int
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			filter = arg
+			continue
+		}
+		var err error
+		switch key {
+		case "client":
+			client = value
+		case "rate":
+			_, err = fmt.Sscan(value, &rate)
+		case "max":
+			_, err = fmt.Sscan(value, &maxPayload)
+		default:
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", key, value)
+		}
+	}
+	return tapQuery(filter, client, rate, maxPayload), nil
+}
+
+func tapQuery(filter, client string, rate float64, maxPayload int) url.Values {
+	query := url.Values{}
+	if filter != "" {
+		query.Set("topic", filter)
+	}
+	if client != "" {
+		query.Set("client", client)
+	}
+	if rate > 0 {
+		query.Set("rate", fmt.Sprint(rate))
+	}
+	if maxPayload > 0 {
+		query.Set("max_payload", fmt.Sprint(maxPayload))
+	}
+	return query
+}
+
+// tap prints the broker's tap stream until ctx is cancelled or the stream
+// ends
+func tap(ctx context.Context, query url.Values) error {
+	resp, err := request(ctx, "/api/v1/tap?"+query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			printEvent(event, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+func printEvent(event, data string) {
+	switch event {
+	case "message":
+		var msg tapMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			fmt.Printf("Malformed message event: %s\n", data)
+			return
+		}
+		flags := fmt.Sprintf("qos=%d", msg.QoS)
+		if msg.Retain {
+			flags += " retain"
+		}
+		payload := msg.Payload
+		if msg.Encoding != "" {
+			payload = msg.Encoding + ":" + payload
+		}
+		if msg.Truncated {
+			payload += fmt.Sprintf("... (%d bytes)", msg.Size)
+		}
+		fmt.Printf("%s %s [%s] %s %s\n", msg.Time.Local().Format("15:04:05.000"), msg.Topic, msg.Origin, flags, payload)
+	case "dropped":
+		fmt.Printf("-- %s\n", data)
+	}
+}
+
+// get prints a JSON resource of the admin API
+func get(ctx context.Context, path string) error {
+	resp, err := request(ctx, "/api/v1/"+strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+// brokerVersion fetches the build information of the broker
+func brokerVersion(ctx context.Context) (*version.Info, error) {
+	resp, err := request(ctx, "/api/v1/version")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var info version.Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid version response: %w", err)
+	}
+	return &info, nil
+}
+
+// printVersions prints the versions of mqttctl and the broker, warning when
+// their major versions differ
+func printVersions(ctx context.Context) error {
+	fmt.Printf("mqttctl: %s\n", version.Get())
+	broker, err := brokerVersion(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("broker:  %s\n", broker)
+	if !version.Compatible(version.Version, broker.Version) {
+		fmt.Println("Warning: major versions differ, some commands may not work")
+	}
+	return nil
+}
+
+// drain starts draining the broker and prints how many clients were
+// disconnected
+func drain(ctx context.Context, serverReference string) error {
+	body, err := json.Marshal(map[string]string{"server_reference": serverReference})
+	if err != nil {
+		return err
+	}
+	resp, err := send(ctx, http.MethodPost, "/api/v1/drain", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var state struct {
+		Disconnected int `json:"disconnected"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return fmt.Errorf("invalid drain response: %w", err)
+	}
+	if serverReference != "" {
+		fmt.Printf("Draining: %d clients disconnected, MQTT 5 clients sent to %s\n", state.Disconnected, serverReference)
+	} else {
+		fmt.Printf("Draining: %d clients disconnected\n", state.Disconnected)
+	}
+	return nil
+}
+
+// undrain ends a drain
+func undrain(ctx context.Context) error {
+	resp, err := send(ctx, http.MethodDelete, "/api/v1/drain", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Println("Accepting connections")
+	return nil
+}
+
+// request performs an authenticated GET and fails on error statuses
+func request(ctx context.Context, path string) (*http.Response, error) {
+	return send(ctx, http.MethodGet, path, nil)
+}
+
+// send performs an authenticated request and fails on error statuses
+func send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(*adminURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, body.Error)
+	}
+	return resp, nil
+}